@@ -11,23 +11,23 @@ import (
 	"time"
 
 	"github.com/meettoy2004/lnmonja/internal/agent"
+	"github.com/meettoy2004/lnmonja/pkg/systemd"
 	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"github.com/meettoy2004/lnmonja/pkg/version"
 	"go.uber.org/zap"
 )
 
 var (
-	configPath = flag.String("config", "/etc/lnmonja/config.yaml", "Path to config file")
-	debug      = flag.Bool("debug", false, "Enable debug mode")
-	version    = flag.Bool("version", false, "Show version")
-	Version    = "dev"
-	BuildTime  = "unknown"
+	configPath  = flag.String("config", "/etc/lnmonja/config.yaml", "Path to config file")
+	debug       = flag.Bool("debug", false, "Enable debug mode")
+	showVersion = flag.Bool("version", false, "Show version")
 )
 
 func main() {
 	flag.Parse()
 
-	if *version {
-		fmt.Printf("lnmonja Agent v%s (built: %s)\n", Version, BuildTime)
+	if *showVersion {
+		fmt.Printf("lnmonja Agent %s\n", version.Get())
 		return
 	}
 
@@ -49,10 +49,14 @@ func main() {
 	defer logger.Sync()
 
 	logger.Info("Starting lnmonja Agent",
-		zap.String("version", Version),
-		zap.String("build_time", BuildTime),
+		zap.String("version", version.Version),
+		zap.String("build_time", version.BuildTime),
+		zap.String("git_commit", version.Get().GitCommit),
 	)
 
+	// Stay within the container's memory before anything is allocated
+	utils.ApplyMemoryConfig(&config.Memory, logger)
+
 	// Create agent instance
 	ag, err := agent.NewAgent(config, logger)
 	if err != nil {
@@ -67,12 +71,21 @@ func main() {
 		logger.Fatal("Failed to start agent", zap.Error(err))
 	}
 
+	// The agent only dials out, so it has no sockets to activate; systemd
+	// is told once it is registered and collecting, and its watchdog fed
+	// while metrics are processed
+	if _, err := systemd.Notify(systemd.Ready, systemd.Status("Collecting")); err != nil {
+		logger.Warn("Failed to notify systemd", zap.Error(err))
+	}
+	go systemd.RunWatchdog(ctx, ag.Alive, logger)
+
 	// Wait for shutdown signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	logger.Info("Shutting down agent...")
+	systemd.Notify(systemd.Stopping)
 
 	// Graceful shutdown
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// NewArchiveCommand reads the cold tier directly, with the storage settings
+// of a server config file, rather than through the API
+func NewArchiveCommand() *cobra.Command {
+	var configPath string
+
+	cmd := &cobra.Command{
+		Use:   "archive",
+		Short: "List and restore blocks archived to the cold tier",
+	}
+	cmd.PersistentFlags().StringVar(&configPath, "config", "/etc/lnmonja/config.yaml", "Server config file with the storage tiering settings")
+
+	loadStorageConfig := func() (*utils.StorageConfig, error) {
+		config, err := utils.LoadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if !config.Storage.Tiering.Enabled {
+			return nil, fmt.Errorf("storage tiering is not enabled in %s", configPath)
+		}
+		return &config.Storage, nil
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List archived blocks",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadStorageConfig()
+			if err != nil {
+				return err
+			}
+			blocks, err := storage.ListArchivedBlocks(context.Background(), config)
+			if err != nil {
+				return err
+			}
+			return render(blocks, printArchivedBlocks(blocks))
+		},
+	})
+
+	var from, to, dir string
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Download the archived blocks of a time range into a local data directory",
+		Long: `Download the archived blocks overlapping a time range into the shards
+of a data directory. Start a server with storage.path set to that
+directory, and a retention period covering the range, to query them.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadStorageConfig()
+			if err != nil {
+				return err
+			}
+			start, err := parseTimeArg(from)
+			if err != nil {
+				return err
+			}
+			end, err := parseTimeArg(to)
+			if err != nil {
+				return err
+			}
+			if !end.After(start) {
+				return fmt.Errorf("--to must be after --from")
+			}
+
+			blocks, err := storage.RestoreArchivedBlocks(context.Background(), config, start, end, dir)
+			if err != nil {
+				return err
+			}
+			return render(blocks, func(w io.Writer) {
+				printArchivedBlocks(blocks)(w)
+				fmt.Fprintf(w, "\nRestored %d blocks into %s\n", len(blocks), dir)
+			})
+		},
+	}
+	restoreCmd.Flags().StringVar(&from, "from", "", "Start time: RFC3339, Unix seconds or a duration ago")
+	restoreCmd.Flags().StringVar(&to, "to", "now", "End time: now, RFC3339, Unix seconds or a duration ago")
+	restoreCmd.Flags().StringVar(&dir, "dir", "./restored", "Data directory to restore into")
+	restoreCmd.MarkFlagRequired("from")
+	cmd.AddCommand(restoreCmd)
+
+	return cmd
+}
+
+func printArchivedBlocks(blocks []*storage.ArchivedBlock) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "START\tEND\tSIZE\tARCHIVED\tOBJECT")
+		for _, b := range blocks {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", b.Start.UTC().Format(time.RFC3339), b.End.UTC().Format(time.RFC3339),
+				formatBytes(b.Size), formatAge(b.ArchivedAt), b.Object)
+		}
+	}
+}
+
+// parseTimeArg parses a time the way the server's API does
+func parseTimeArg(s string) (time.Time, error) {
+	if s == "now" {
+		return time.Now(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(i, 0), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", s)
+}
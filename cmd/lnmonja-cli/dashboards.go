@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/spf13/cobra"
+)
+
+func NewDashboardsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dashboards",
+		Short: "Manage dashboards",
+	}
+
+	var dryRun bool
+	importCmd := &cobra.Command{
+		Use:   "import-grafana <file>",
+		Short: "Import a Grafana dashboard JSON export",
+		Long: `Convert a Grafana dashboard JSON export and store it as a new dashboard.
+Graph, stat, table, text and heatmap panels and template variables are
+converted; everything else is listed as unsupported. Use --dry-run to
+see the conversion without storing it. A file of - reads stdin.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var data []byte
+			var err error
+			if args[0] == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			if !json.Valid(data) {
+				return fmt.Errorf("%s is not valid JSON", args[0])
+			}
+
+			path := "/api/v1/dashboards/import/grafana"
+			if dryRun {
+				path += "?dry_run=true"
+			}
+			var conversion models.DashboardConversion
+			if err := apiPost(path, json.RawMessage(data), &conversion); err != nil {
+				return err
+			}
+			return render(conversion, printDashboardConversion(&conversion))
+		},
+	}
+	importCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Convert without storing the dashboard")
+	cmd.AddCommand(importCmd)
+
+	return cmd
+}
+
+func printDashboardConversion(c *models.DashboardConversion) func(w io.Writer) {
+	return func(w io.Writer) {
+		if c.Imported {
+			fmt.Fprintf(w, "Imported %q as %s\n", c.Dashboard.Name, c.Dashboard.ID)
+		} else {
+			fmt.Fprintf(w, "Converted %q (not stored)\n", c.Dashboard.Name)
+		}
+		fmt.Fprintf(w, "Panels:\t%d\n", c.Panels)
+		fmt.Fprintf(w, "Variables:\t%d\n", c.Variables)
+		if len(c.Unsupported) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\nNot converted:\n")
+		for _, note := range c.Unsupported {
+			fmt.Fprintf(w, "  - %s\n", note)
+		}
+	}
+}
@@ -2,8 +2,15 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/server/api"
 	"github.com/spf13/cobra"
 )
 
@@ -11,6 +18,10 @@ var rootCmd = &cobra.Command{
 	Use:   "lnmonja",
 	Short: "lnmonja CLI",
 	Long:  "Command-line interface for lnmonja monitoring system",
+
+	// API errors are not usage errors; main prints them once
+	SilenceUsage:  true,
+	SilenceErrors: true,
 }
 
 var (
@@ -20,7 +31,8 @@ var (
 
 func main() {
 	rootCmd.PersistentFlags().StringVar(&serverAddr, "server", "localhost:8080", "Server address")
-	rootCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "API key for authentication")
+	rootCmd.PersistentFlags().StringVar(&apiKey, "api-key", os.Getenv("LNMONJA_API_KEY"), "API key for authentication")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format: table or json")
 
 	rootCmd.AddCommand(
 		NewNodesCommand(),
@@ -28,6 +40,10 @@ func main() {
 		NewAlertsCommand(),
 		NewConfigCommand(),
 		NewStatusCommand(),
+		NewDashboardsCommand(),
+		NewArchiveCommand(),
+		NewReplicationCommand(),
+		NewReportsCommand(),
 	)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -46,17 +62,247 @@ func NewNodesCommand() *cobra.Command {
 		&cobra.Command{
 			Use:   "list",
 			Short: "List all nodes",
-			Run: func(cmd *cobra.Command, args []string) {
-				fmt.Println("Listing nodes...")
-				// Implementation
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var nodes []*models.Node
+				if err := apiGet("/api/v1/nodes", &nodes); err != nil {
+					return err
+				}
+				sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+				return render(nodes, func(w io.Writer) {
+					fmt.Fprintln(w, "ID\tHOSTNAME\tSTATUS\tVERSION\tLAST SEEN\tLABELS")
+					for _, n := range nodes {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+							n.ID, n.Hostname, n.Status, n.Version, formatAge(n.LastSeen), formatLabels(n.ServerLabels))
+					}
+				})
 			},
 		},
 		&cobra.Command{
 			Use:   "info [node-id]",
 			Short: "Show node info",
 			Args:  cobra.ExactArgs(1),
-			Run: func(cmd *cobra.Command, args []string) {
-				fmt.Printf("Showing info for node: %s\n", args[0])
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var node models.Node
+				if err := apiGet("/api/v1/nodes/"+url.PathEscape(args[0]), &node); err != nil {
+					return err
+				}
+				return render(&node, func(w io.Writer) { printNode(w, &node) })
+			},
+		},
+		NewNodesCollectCommand(),
+		NewNodesCollectorsCommand(),
+		NewNodesLabelCommand(),
+		NewNodesDecommissionCommand(),
+		&cobra.Command{
+			Use:   "reactivate [node-id]",
+			Short: "Cancel decommissioning of a retiring node",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var node models.Node
+				path := fmt.Sprintf("/api/v1/nodes/%s/decommission", url.PathEscape(args[0]))
+				if err := apiDelete(path, &node); err != nil {
+					return err
+				}
+				return render(&node, func(w io.Writer) {
+					fmt.Fprintf(w, "%s reactivated\n", node.ID)
+				})
+			},
+		},
+	)
+
+	return cmd
+}
+
+// printNode prints a node's details as key/value rows
+func printNode(w io.Writer, n *models.Node) {
+	fmt.Fprintf(w, "ID:\t%s\n", n.ID)
+	fmt.Fprintf(w, "Hostname:\t%s\n", n.Hostname)
+	fmt.Fprintf(w, "Status:\t%s\n", n.Status)
+	fmt.Fprintf(w, "OS/Arch:\t%s/%s\n", n.OS, n.Arch)
+	fmt.Fprintf(w, "Version:\t%s\n", n.Version)
+	fmt.Fprintf(w, "Last seen:\t%s (%s)\n", n.LastSeen.Format(time.RFC3339), formatAge(n.LastSeen))
+	fmt.Fprintf(w, "Registered:\t%s\n", n.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Labels:\t%s\n", formatLabels(n.Labels))
+	fmt.Fprintf(w, "Server labels:\t%s\n", formatLabels(n.ServerLabels))
+	if n.InstanceGroup != "" {
+		fmt.Fprintf(w, "Instance group:\t%s (ephemeral: %t)\n", n.InstanceGroup, n.Ephemeral)
+	}
+	if d := n.Decommission; d != nil {
+		fmt.Fprintf(w, "Decommission:\t%s at %s (%s)\n", d.Action, d.CompleteAt.Format(time.RFC3339), d.Reason)
+	}
+	if v := n.Vitals; v != nil {
+		fmt.Fprintf(w, "Agent CPU:\t%.1f%%\n", v.CPUPercent)
+	}
+
+	keys := make([]string, 0, len(n.Inventory))
+	for k := range n.Inventory {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s:\t%s\n", k, n.Inventory[k])
+	}
+}
+
+func NewNodesCollectCommand() *cobra.Command {
+	var (
+		collectorNames []string
+		timeout        time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "collect [node-id]",
+		Short: "Run collectors on a node now and print the results",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Leave headroom over the server-side wait for the agent
+			httpClient.Timeout = timeout + 5*time.Second
+
+			req := api.CollectRequest{
+				Collectors: collectorNames,
+				Timeout:    timeout.String(),
+			}
+
+			var resp api.CollectResponse
+			path := fmt.Sprintf("/api/v1/nodes/%s/collect", url.PathEscape(args[0]))
+			if err := apiPost(path, req, &resp); err != nil {
+				return err
+			}
+
+			sort.Slice(resp.Metrics, func(i, j int) bool {
+				if resp.Metrics[i].Name != resp.Metrics[j].Name {
+					return resp.Metrics[i].Name < resp.Metrics[j].Name
+				}
+				return formatLabels(resp.Metrics[i].Labels) < formatLabels(resp.Metrics[j].Labels)
+			})
+
+			return render(&resp, func(w io.Writer) {
+				fmt.Fprintln(w, "METRIC\tLABELS\tVALUE")
+				for _, m := range resp.Metrics {
+					fmt.Fprintf(w, "%s\t%s\t%g\n", m.Name, formatLabels(m.Labels), m.Value)
+				}
+				fmt.Fprintf(w, "\n%d metrics from %s in %s\n", len(resp.Metrics), resp.NodeID, resp.Duration)
+			})
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&collectorNames, "collector", "c", nil, "Collectors to run (default: all enabled)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "How long to wait for the agent")
+
+	return cmd
+}
+
+func NewNodesCollectorsCommand() *cobra.Command {
+	var (
+		enable   []string
+		disable  []string
+		interval []string
+		timeout  time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "collectors [node-id]",
+		Short: "Enable, disable or reschedule collectors on a node",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			updates := make(map[string]*api.CollectorUpdate)
+			get := func(name string) *api.CollectorUpdate {
+				if updates[name] == nil {
+					updates[name] = &api.CollectorUpdate{}
+				}
+				return updates[name]
+			}
+
+			on, off := true, false
+			for _, name := range enable {
+				get(name).Enabled = &on
+			}
+			for _, name := range disable {
+				get(name).Enabled = &off
+			}
+			for _, arg := range interval {
+				name, value, ok := strings.Cut(arg, "=")
+				if !ok || name == "" {
+					return fmt.Errorf("invalid interval %q, expected collector=duration", arg)
+				}
+				get(name).Interval = value
+			}
+			if len(updates) == 0 {
+				return fmt.Errorf("nothing to change, use --enable, --disable or --interval")
+			}
+
+			// Leave headroom over the server-side wait for the agent
+			httpClient.Timeout = timeout + 5*time.Second
+
+			req := api.CollectorsRequest{
+				Collectors: updates,
+				Timeout:    timeout.String(),
+			}
+
+			var resp api.CollectorsResponse
+			path := fmt.Sprintf("/api/v1/nodes/%s/collectors", url.PathEscape(args[0]))
+			if err := apiPatch(path, req, &resp); err != nil {
+				return err
+			}
+
+			return render(&resp, func(w io.Writer) {
+				fmt.Fprintln(w, "COLLECTOR\tENABLED\tINTERVAL")
+				for _, c := range resp.Collectors {
+					fmt.Fprintf(w, "%s\t%t\t%s\n", c.Name, c.Enabled, c.Interval)
+				}
+			})
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&enable, "enable", nil, "Collectors to enable")
+	cmd.Flags().StringSliceVar(&disable, "disable", nil, "Collectors to disable")
+	cmd.Flags().StringSliceVar(&interval, "interval", nil, "Collector intervals as collector=duration")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "How long to wait for the agent")
+
+	return cmd
+}
+
+func NewNodesLabelCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "label",
+		Short: "Manage server-side node labels added to every series",
+	}
+
+	update := func(nodeID string, req api.NodeLabelsRequest) error {
+		var node models.Node
+		path := fmt.Sprintf("/api/v1/nodes/%s/labels", url.PathEscape(nodeID))
+		if err := apiPatch(path, req, &node); err != nil {
+			return err
+		}
+		return render(&node, func(w io.Writer) {
+			fmt.Fprintf(w, "%s %s\n", node.ID, formatLabels(node.ServerLabels))
+		})
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "set [node-id] [key=value]...",
+			Short: "Set labels on a node",
+			Args:  cobra.MinimumNArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				set := make(map[string]string, len(args)-1)
+				for _, arg := range args[1:] {
+					key, value, ok := strings.Cut(arg, "=")
+					if !ok || key == "" {
+						return fmt.Errorf("invalid label %q, expected key=value", arg)
+					}
+					set[key] = value
+				}
+				return update(args[0], api.NodeLabelsRequest{Set: set})
+			},
+		},
+		&cobra.Command{
+			Use:   "remove [node-id] [key]...",
+			Short: "Remove labels from a node",
+			Args:  cobra.MinimumNArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return update(args[0], api.NodeLabelsRequest{Remove: args[1:]})
 			},
 		},
 	)
@@ -64,6 +310,38 @@ func NewNodesCommand() *cobra.Command {
 	return cmd
 }
 
+func NewNodesDecommissionCommand() *cobra.Command {
+	var req api.DecommissionRequest
+	var action string
+
+	cmd := &cobra.Command{
+		Use:   "decommission [node-id]",
+		Short: "Retire a node, silencing its alerts and optionally purging its series",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req.Action = models.DecommissionAction(action)
+
+			var node models.Node
+			path := fmt.Sprintf("/api/v1/nodes/%s/decommission", url.PathEscape(args[0]))
+			if err := apiPost(path, req, &node); err != nil {
+				return err
+			}
+
+			return render(&node, func(w io.Writer) {
+				fmt.Fprintf(w, "%s is retiring; series will be handled (%s) at %s\n",
+					node.ID, node.Decommission.Action, node.Decommission.CompleteAt.Format(time.RFC3339))
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&req.Reason, "reason", "", "Why the node is being retired")
+	cmd.Flags().StringVar(&action, "action", "keep", "What to do with the node's series: keep, archive or purge")
+	cmd.Flags().StringVar(&req.Grace, "grace", "", "How long to wait before archiving or purging (default from server config)")
+	cmd.Flags().StringVar(&req.RequestedBy, "requested-by", os.Getenv("USER"), "Who requested the decommission")
+
+	return cmd
+}
+
 func NewMetricsCommand() *cobra.Command {
 	var query string
 	var from, to string
@@ -72,15 +350,57 @@ func NewMetricsCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "metrics",
 		Short: "Query metrics",
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("Querying metrics: %s\n", query)
-			// Implementation
+		RunE: func(cmd *cobra.Command, args []string) error {
+			params := url.Values{}
+			params.Set("query", query)
+			params.Set("start", from)
+			if to != "now" {
+				params.Set("end", to)
+			}
+			params.Set("step", step)
+
+			var resp struct {
+				Data struct {
+					Result      []*models.TimeSeries `json:"result"`
+					Downsampled bool                 `json:"downsampled"`
+				} `json:"data"`
+			}
+			if err := apiGet("/api/v1/metrics/query?"+params.Encode(), &resp); err != nil {
+				return err
+			}
+			series := resp.Data.Result
+
+			return render(series, func(w io.Writer) {
+				fmt.Fprintln(w, "SERIES\tPOINTS\tMIN\tAVG\tMAX\tLAST\tAT")
+				for _, ts := range series {
+					if len(ts.Samples) == 0 {
+						fmt.Fprintf(w, "%s\t0\t-\t-\t-\t-\t-\n", formatLabels(ts.Labels))
+						continue
+					}
+					min, max, sum := ts.Samples[0].Value, ts.Samples[0].Value, 0.0
+					for _, sample := range ts.Samples {
+						if sample.Value < min {
+							min = sample.Value
+						}
+						if sample.Value > max {
+							max = sample.Value
+						}
+						sum += sample.Value
+					}
+					last := ts.Samples[len(ts.Samples)-1]
+					fmt.Fprintf(w, "%s\t%d\t%g\t%g\t%g\t%g\t%s\n", formatLabels(ts.Labels), len(ts.Samples),
+						min, sum/float64(len(ts.Samples)), max, last.Value, last.Timestamp.Format(time.RFC3339))
+				}
+				if resp.Data.Downsampled {
+					fmt.Fprintln(w, "\n(series were downsampled by the server)")
+				}
+			})
 		},
 	}
 
-	cmd.Flags().StringVarP(&query, "query", "q", "", "PromQL query")
-	cmd.Flags().StringVar(&from, "from", "1h", "Start time")
-	cmd.Flags().StringVar(&to, "to", "now", "End time")
+	cmd.Flags().StringVarP(&query, "query", "q", "", "Metric selector, e.g. name{label=\"value\"}")
+	cmd.Flags().StringVar(&from, "from", "1h", "Start time: RFC3339, Unix seconds or a duration ago")
+	cmd.Flags().StringVar(&to, "to", "now", "End time: now, RFC3339, Unix seconds or a duration ago")
 	cmd.Flags().StringVar(&step, "step", "15s", "Step interval")
 	cmd.MarkFlagRequired("query")
 
@@ -93,20 +413,152 @@ func NewAlertsCommand() *cobra.Command {
 		Short: "Manage alerts",
 	}
 
+	var state string
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List alerts",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/api/v1/alerts"
+			if state != "" {
+				path += "?state=" + url.QueryEscape(state)
+			}
+
+			var alerts []*models.Alert
+			if err := apiGet(path, &alerts); err != nil {
+				return err
+			}
+			sort.Slice(alerts, func(i, j int) bool { return alerts[i].ActiveAt.After(alerts[j].ActiveAt) })
+
+			return render(alerts, func(w io.Writer) {
+				fmt.Fprintln(w, "ID\tNAME\tSTATE\tVALUE\tACTIVE\tLABELS")
+				for _, a := range alerts {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%g\t%s\t%s\n",
+						a.ID, a.Name, a.State, a.Value, formatAge(a.ActiveAt), formatLabels(a.Labels))
+				}
+			})
+		},
+	}
+	listCmd.Flags().StringVar(&state, "state", "", "Only show alerts in this state: inactive, pending, firing or resolved")
+
+	cmd.AddCommand(listCmd, NewAlertsSilenceCommand())
+
+	return cmd
+}
+
+func NewAlertsSilenceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "silence",
+		Short: "Manage silences that mute alert notifications",
+	}
+
+	var silence models.Silence
+	var duration time.Duration
+	addCmd := &cobra.Command{
+		Use:   "add [alert-id]",
+		Short: "Silence alerts matching --matcher, or the alert with the given ID",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				var alerts []*models.Alert
+				if err := apiGet("/api/v1/alerts", &alerts); err != nil {
+					return err
+				}
+				var alert *models.Alert
+				for _, a := range alerts {
+					if a.ID == args[0] {
+						alert = a
+					}
+				}
+				if alert == nil {
+					return fmt.Errorf("alert %s not found", args[0])
+				}
+				silence.Matchers = append(silence.Matchers,
+					fmt.Sprintf("alertname=%q", alert.Name),
+					fmt.Sprintf("node=%q", alert.Labels["node"]))
+			}
+			if len(silence.Matchers) == 0 {
+				return fmt.Errorf("an alert ID or at least one --matcher is required")
+			}
+
+			silence.StartsAt = time.Now()
+			silence.EndsAt = silence.StartsAt.Add(duration)
+
+			var saved models.Silence
+			if err := apiPost("/api/v1/alerts/silences", &silence, &saved); err != nil {
+				return err
+			}
+			return render(&saved, func(w io.Writer) {
+				fmt.Fprintf(w, "%s silences %s until %s\n",
+					saved.ID, strings.Join(saved.Matchers, ", "), saved.EndsAt.Format(time.RFC3339))
+			})
+		},
+	}
+	addCmd.Flags().StringArrayVarP(&silence.Matchers, "matcher", "m", nil, `Label matcher such as alertname="HighCPU" or node=~"web-.*" (repeatable)`)
+	addCmd.Flags().DurationVarP(&duration, "duration", "d", 2*time.Hour, "How long the silence lasts")
+	addCmd.Flags().StringVar(&silence.Comment, "comment", "", "Why the alerts are silenced")
+	addCmd.Flags().StringVar(&silence.CreatedBy, "author", os.Getenv("USER"), "Who created the silence")
+
+	var status string
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List silences",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/api/v1/alerts/silences"
+			if status != "" {
+				path += "?status=" + url.QueryEscape(status)
+			}
+
+			var silences []*models.Silence
+			if err := apiGet(path, &silences); err != nil {
+				return err
+			}
+
+			return render(silences, func(w io.Writer) {
+				fmt.Fprintln(w, "ID\tSTATUS\tMATCHERS\tENDS\tCREATED BY\tCOMMENT")
+				for _, s := range silences {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+						s.ID, s.Status, strings.Join(s.Matchers, ","), s.EndsAt.Local().Format(time.RFC3339), s.CreatedBy, s.Comment)
+				}
+			})
+		},
+	}
+	listCmd.Flags().StringVar(&status, "status", "", "Only show silences in this state: pending, active or expired")
+
 	cmd.AddCommand(
+		addCmd,
+		listCmd,
 		&cobra.Command{
-			Use:   "list",
-			Short: "List alerts",
-			Run: func(cmd *cobra.Command, args []string) {
-				fmt.Println("Listing alerts...")
+			Use:   "get [silence-id]",
+			Short: "Show a silence",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var s models.Silence
+				if err := apiGet("/api/v1/alerts/silences/"+url.PathEscape(args[0]), &s); err != nil {
+					return err
+				}
+				return render(&s, func(w io.Writer) {
+					fmt.Fprintf(w, "ID:\t%s\n", s.ID)
+					fmt.Fprintf(w, "Status:\t%s\n", s.Status)
+					fmt.Fprintf(w, "Matchers:\t%s\n", strings.Join(s.Matchers, ", "))
+					fmt.Fprintf(w, "Starts:\t%s\n", s.StartsAt.Local().Format(time.RFC3339))
+					fmt.Fprintf(w, "Ends:\t%s\n", s.EndsAt.Local().Format(time.RFC3339))
+					fmt.Fprintf(w, "Created by:\t%s\n", s.CreatedBy)
+					fmt.Fprintf(w, "Comment:\t%s\n", s.Comment)
+				})
 			},
 		},
 		&cobra.Command{
-			Use:   "silence [alert-id]",
-			Short: "Silence an alert",
+			Use:   "expire [silence-id]",
+			Short: "End a silence now",
 			Args:  cobra.ExactArgs(1),
-			Run: func(cmd *cobra.Command, args []string) {
-				fmt.Printf("Silencing alert: %s\n", args[0])
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var s models.Silence
+				if err := apiDelete("/api/v1/alerts/silences/"+url.PathEscape(args[0]), &s); err != nil {
+					return err
+				}
+				return render(&s, func(w io.Writer) {
+					fmt.Fprintf(w, "%s expired\n", s.ID)
+				})
 			},
 		},
 	)
@@ -114,19 +566,117 @@ func NewAlertsCommand() *cobra.Command {
 	return cmd
 }
 
+// systemStatus summarizes the server for the status command
+type systemStatus struct {
+	Server struct {
+		Status  string `json:"status"`
+		Version string `json:"version"`
+	} `json:"server"`
+	Nodes        map[string]int `json:"nodes"`
+	TotalNodes   int            `json:"total_nodes"`
+	FiringAlerts int            `json:"firing_alerts"`
+	DiskBytes    int64          `json:"disk_bytes"`
+}
+
 func NewStatusCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Show system status",
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("=== lnmonja Status ===")
-			fmt.Println("Server: Healthy")
-			fmt.Println("Nodes: 5 connected")
-			fmt.Println("Alerts: 0 firing")
-			fmt.Println("Storage: 1.2GB used")
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var status systemStatus
+			if err := apiGet("/health", &status.Server); err != nil {
+				return err
+			}
+
+			var nodes []*models.Node
+			if err := apiGet("/api/v1/nodes", &nodes); err != nil {
+				return err
+			}
+			status.TotalNodes = len(nodes)
+			status.Nodes = make(map[string]int)
+			for _, n := range nodes {
+				status.Nodes[n.Status.String()]++
+			}
+
+			var alerts []*models.Alert
+			if err := apiGet("/api/v1/alerts?state="+models.AlertStateFiring.String(), &alerts); err != nil {
+				return err
+			}
+			status.FiringAlerts = len(alerts)
+
+			var usage models.StorageUsage
+			if err := apiGet("/api/v1/status/storage?limit=1", &usage); err != nil {
+				return err
+			}
+			status.DiskBytes = usage.DiskBytes
+
+			return render(&status, func(w io.Writer) {
+				statuses := make([]string, 0, len(status.Nodes))
+				for name, count := range status.Nodes {
+					statuses = append(statuses, fmt.Sprintf("%d %s", count, name))
+				}
+				sort.Strings(statuses)
+
+				server := status.Server.Status
+				if status.Server.Version != "" {
+					server += " (" + status.Server.Version + ")"
+				}
+				nodes := fmt.Sprint(status.TotalNodes)
+				if len(statuses) > 0 {
+					nodes += " (" + strings.Join(statuses, ", ") + ")"
+				}
+
+				fmt.Fprintln(w, "=== lnmonja Status ===")
+				fmt.Fprintf(w, "Server:\t%s\n", server)
+				fmt.Fprintf(w, "Nodes:\t%s\n", nodes)
+				fmt.Fprintf(w, "Alerts:\t%d firing\n", status.FiringAlerts)
+				fmt.Fprintf(w, "Storage:\t%s used\n", formatBytes(status.DiskBytes))
+			})
 		},
 	}
 
+	cmd.AddCommand(NewStorageStatusCommand())
+
+	return cmd
+}
+
+func NewStorageStatusCommand() *cobra.Command {
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "storage",
+		Short: "Show estimated storage usage by metric and node",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var usage models.StorageUsage
+			if err := apiGet(fmt.Sprintf("/api/v1/status/storage?limit=%d", limit), &usage); err != nil {
+				return err
+			}
+
+			return render(&usage, func(w io.Writer) {
+				fmt.Fprintf(w, "Disk usage: %s (LSM %s, value log %s)\n",
+					formatBytes(usage.DiskBytes), formatBytes(usage.LSMBytes), formatBytes(usage.ValueLogBytes))
+				fmt.Fprintf(w, "Samples: %d, estimated at %s\n\n", usage.Samples, usage.GeneratedAt.Format(time.RFC3339))
+
+				printUsage := func(title string, entries []*models.StorageUsageEntry) {
+					fmt.Fprintf(w, "%s\tSAMPLES\tDISK\tSHARE\n", title)
+					for _, e := range entries {
+						name := e.Name
+						if name == "" {
+							name = "(none)"
+						}
+						fmt.Fprintf(w, "%s\t%d\t%s\t%.1f%%\n", name, e.Samples, formatBytes(e.EstimatedDiskBytes), e.Percent)
+					}
+					fmt.Fprintln(w)
+				}
+
+				printUsage("METRIC", usage.Metrics)
+				printUsage("NODE", usage.Nodes)
+			})
+		},
+	}
+
+	cmd.Flags().IntVar(&limit, "limit", 20, "Maximum number of metrics and nodes to show")
+
 	return cmd
 }
 
@@ -147,9 +697,28 @@ func NewConfigCommand() *cobra.Command {
 		},
 		&cobra.Command{
 			Use:   "reload",
-			Short: "Reload configuration",
-			Run: func(cmd *cobra.Command, args []string) {
-				fmt.Println("Reloading configuration...")
+			Short: "Reload the server's configuration file",
+			Long: "Has the server re-read its configuration file and apply the changed\n" +
+				"settings that can change while it runs: alert rules, notifications, the\n" +
+				"log level and collector defaults. Other changes are listed as needing a\n" +
+				"restart.",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var result models.ConfigReload
+				if err := apiPost("/api/v1/admin/config/reload", nil, &result); err != nil {
+					return err
+				}
+				return render(&result, func(w io.Writer) {
+					fmt.Fprintf(w, "Reloaded %s\n", result.Path)
+					if len(result.Applied) == 0 && len(result.RestartRequired) == 0 {
+						fmt.Fprintln(w, "No settings changed")
+					}
+					for _, setting := range result.Applied {
+						fmt.Fprintf(w, "  applied           %s\n", setting)
+					}
+					for _, setting := range result.RestartRequired {
+						fmt.Fprintf(w, "  needs a restart   %s\n", setting)
+					}
+				})
 			},
 		},
 	)
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// NewReplicationCommand talks to a standby's replication listener, with
+// the replication settings of a server config file
+func NewReplicationCommand() *cobra.Command {
+	var configPath, standby string
+
+	cmd := &cobra.Command{
+		Use:   "replication",
+		Short: "Check and promote a warm standby",
+	}
+	cmd.PersistentFlags().StringVar(&configPath, "config", "/etc/lnmonja/config.yaml", "Server config file of the standby or its primary")
+	cmd.PersistentFlags().StringVar(&standby, "standby", "", "Standby URL, overriding the one the config file describes")
+
+	loadStorageConfig := func() (*utils.StorageConfig, error) {
+		config, err := utils.LoadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if config.Storage.Replication.Role == "" {
+			return nil, fmt.Errorf("storage replication is not configured in %s", configPath)
+		}
+		if standby != "" {
+			config.Storage.Replication.Role = utils.ReplicationRolePrimary
+			config.Storage.Replication.StandbyURL = standby
+		}
+		return &config.Storage, nil
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show the versions the standby has applied",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadStorageConfig()
+			if err != nil {
+				return err
+			}
+			status, err := storage.StandbyStatus(context.Background(), config)
+			if err != nil {
+				return err
+			}
+			return render(status, printStandbyStatus(status))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "promote",
+		Short: "Stop the standby applying writes and start it as a server",
+		Long: `Promote a standby. It stops applying writes and starts as a server on
+the replicated storage. Stop the primary first, or point its agents at
+the standby, so only one server accepts writes.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadStorageConfig()
+			if err != nil {
+				return err
+			}
+			if err := storage.PromoteStandby(context.Background(), config); err != nil {
+				return err
+			}
+			fmt.Println("Standby promoted")
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func printStandbyStatus(status *storage.ReplicationStatus) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintf(w, "Last applied:\t%s\n", formatAge(status.LastApplied))
+		fmt.Fprintf(w, "Metadata:\t%s\n\n", formatAge(status.MetadataAt))
+
+		names := make([]string, 0, len(status.Databases))
+		for name := range status.Databases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "DATABASE\tVERSION")
+		for _, name := range names {
+			fmt.Fprintf(w, "%s\t%d\n", name, status.Databases[name])
+		}
+	}
+}
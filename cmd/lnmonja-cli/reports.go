@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/spf13/cobra"
+)
+
+// NewReportsCommand shows the server's long-term reports
+func NewReportsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reports",
+		Short: "Show long-term reports",
+	}
+
+	var from, to, groupBy string
+	trendsCmd := &cobra.Command{
+		Use:   "trends",
+		Short: "Show monthly CPU, disk and alert trends with month-over-month changes",
+		Long: `Show the monthly average and peak CPU usage, used disk bytes and their
+growth, and the alerts that fired, of each node or group of nodes. The
+change from the previous month follows each figure in parentheses.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			params := url.Values{}
+			if from != "" {
+				params.Set("from", from)
+			}
+			if to != "" {
+				params.Set("to", to)
+			}
+			if groupBy != "" {
+				params.Set("group_by", groupBy)
+			}
+
+			var report models.TrendReport
+			if err := apiGet("/api/v1/reports/trends?"+params.Encode(), &report); err != nil {
+				return err
+			}
+			return render(report, printTrendReport(&report))
+		},
+	}
+	trendsCmd.Flags().StringVar(&from, "from", "", "First month (YYYY-MM), default five months before --to")
+	trendsCmd.Flags().StringVar(&to, "to", "", "Last month (YYYY-MM), default the current month")
+	trendsCmd.Flags().StringVar(&groupBy, "group-by", "", "Group nodes by instance_group or a node label, such as team")
+
+	cmd.AddCommand(trendsCmd)
+	return cmd
+}
+
+func printTrendReport(report *models.TrendReport) func(w io.Writer) {
+	return func(w io.Writer) {
+		if len(report.Series) == 0 {
+			fmt.Fprintf(w, "No trends from %s to %s\n", report.From, report.To)
+			return
+		}
+
+		fmt.Fprintf(w, "%s\tMONTH\tNODES\tCPU AVG\tCPU MAX\tDISK USED\tDISK GROWTH\tALERTS\n", strings.ToUpper(report.GroupBy))
+		for _, s := range report.Series {
+			key := s.Key
+			if key == "" {
+				key = "(none)"
+			}
+			for _, m := range s.Months {
+				cpuAvg := fmt.Sprintf("%.1f%%", m.CPUAvg)
+				cpuMax := fmt.Sprintf("%.1f%%", m.CPUMax)
+				diskUsed := formatBytes(int64(m.DiskUsedBytes))
+				alerts := fmt.Sprintf("%d", m.Alerts)
+				if c := m.Change; c != nil {
+					cpuAvg += fmt.Sprintf(" (%+.1f)", c.CPUAvg)
+					cpuMax += fmt.Sprintf(" (%+.1f)", c.CPUMax)
+					diskUsed += fmt.Sprintf(" (%s)", formatBytesChange(c.DiskUsedBytes))
+					alerts += fmt.Sprintf(" (%+d)", c.Alerts)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+					key, m.Month, m.Nodes, cpuAvg, cpuMax, diskUsed, formatBytesChange(m.DiskGrowthBytes), alerts)
+			}
+		}
+	}
+}
+
+// formatBytesChange renders a signed byte count in human-readable units
+func formatBytesChange(v float64) string {
+	if v < 0 {
+		return "-" + formatBytes(int64(-v))
+	}
+	return "+" + formatBytes(int64(v))
+}
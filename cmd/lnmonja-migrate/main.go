@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"github.com/meettoy2004/lnmonja/pkg/version"
+	"go.uber.org/zap"
+)
+
+var (
+	configPath     = flag.String("config", "/etc/lnmonja/config.yaml", "Path to the server's config file")
+	targetPath     = flag.String("to", "", "Storage path to write the migrated data to")
+	compression    = flag.Bool("compress", true, "Seal samples into compressed chunks in the target")
+	metadataDriver = flag.String("metadata-driver", "", "Target metadata store: sqlite, postgres or badger (default: the source's)")
+	metadataDSN    = flag.String("metadata-dsn", "", "Target metadata connection string")
+	verifyOnly     = flag.Bool("verify", false, "Only compare the sample counts of each block in both paths")
+	showVersion    = flag.Bool("version", false, "Show version")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: lnmonja-migrate -config <file> -to <path> [flags]\n\n")
+		fmt.Fprintf(os.Stderr, "Copies the storage path of a stopped server to a new one in the current\n")
+		fmt.Fprintf(os.Stderr, "storage format, resuming an interrupted run, and checks that each block\n")
+		fmt.Fprintf(os.Stderr, "holds as many samples in both.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("lnmonja Migrate %s\n", version.Get())
+		return
+	}
+	if *targetPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config, err := utils.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	logger, err := utils.NewLogger(config.Logging)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Sync()
+
+	metadata := utils.MetadataConfig{Driver: *metadataDriver, DSN: *metadataDSN}
+	switch metadata.Driver {
+	case "", utils.MetadataDriverSQLite, utils.MetadataDriverBadger:
+	case utils.MetadataDriverPostgres:
+		if metadata.DSN == "" {
+			log.Fatalf("-metadata-dsn is required with the postgres metadata driver")
+		}
+	default:
+		log.Fatalf("Unknown metadata driver: %s", metadata.Driver)
+	}
+
+	migrator, err := storage.NewMigrator(&config.Storage, storage.MigrateOptions{
+		Target:      *targetPath,
+		Compression: *compression,
+		Metadata:    metadata,
+		Progress:    printProgress,
+	}, logger)
+	if err != nil {
+		logger.Fatal("Failed to open storage", zap.Error(err))
+	}
+
+	// Progress is saved after each block, so an interrupted run resumes
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	var report *storage.MigrateReport
+	if *verifyOnly {
+		report, err = migrator.Verify(ctx)
+	} else {
+		report, err = migrator.Run(ctx)
+	}
+	stop()
+	if closeErr := migrator.Close(); closeErr != nil {
+		logger.Warn("Failed to close storage", zap.Error(closeErr))
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Println("Interrupted; run again to resume")
+			os.Exit(1)
+		}
+		logger.Fatal("Migration failed", zap.Error(err))
+	}
+
+	fmt.Printf("\n%d blocks, %d samples in %s\n", len(report.Blocks), report.Samples, report.Elapsed.Round(time.Second))
+	if report.Mismatched > 0 {
+		fmt.Printf("%d blocks hold a different number of samples:\n", report.Mismatched)
+		for _, block := range report.Blocks {
+			if !block.Matches() {
+				fmt.Printf("  %s - %s: %d in the source, %d in the target\n",
+					block.Start.UTC().Format(time.RFC3339), block.End.UTC().Format(time.RFC3339), block.Source, block.Target)
+			}
+		}
+		os.Exit(1)
+	}
+	fmt.Println("Sample counts match")
+}
+
+// printProgress prints a line per block
+func printProgress(p *storage.MigrateProgress) {
+	status := "ok"
+	switch {
+	case p.Resumed:
+		status = "done earlier"
+	case !p.Block.Matches():
+		status = "MISMATCH"
+	}
+	fmt.Printf("[%d/%d %3.0f%%] %s - %s  %d samples  %s\n",
+		p.Done, p.Total, 100*float64(p.Done)/float64(p.Total),
+		p.Block.Start.UTC().Format(time.RFC3339), p.Block.End.UTC().Format(time.RFC3339),
+		p.Block.Target, status)
+}
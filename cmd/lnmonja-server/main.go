@@ -13,30 +13,32 @@ import (
 
 	"github.com/meettoy2004/lnmonja/internal/server"
 	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/systemd"
 	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"github.com/meettoy2004/lnmonja/pkg/version"
 	"go.uber.org/zap"
 )
 
 var (
-	configPath = flag.String("config", "/etc/lnmonja/config.yaml", "Path to config file")
-	version    = flag.Bool("version", false, "Show version")
-	Version    = "dev"
-	BuildTime  = "unknown"
+	configPath  = flag.String("config", "/etc/lnmonja/config.yaml", "Path to config file")
+	showVersion = flag.Bool("version", false, "Show version")
+	demo        = flag.Bool("demo", false, "Simulate nodes with realistic load, anomalies and alerts, to try the server without agents")
 )
 
 func main() {
 	flag.Parse()
 
-	if *version {
-		fmt.Printf("lnmonja Server v%s (built: %s)\n", Version, BuildTime)
+	if *showVersion {
+		fmt.Printf("lnmonja Server %s\n", version.Get())
 		return
 	}
 
 	// Load configuration
-	config, err := utils.LoadConfig(*configPath)
+	config, err := loadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	config.Version = version.Version
 
 	// Setup logger
 	logger, err := utils.NewLogger(config.Logging)
@@ -46,10 +48,24 @@ func main() {
 	defer logger.Sync()
 
 	logger.Info("Starting lnmonja Server",
-		zap.String("version", Version),
-		zap.String("build_time", BuildTime),
+		zap.String("version", version.Version),
+		zap.String("build_time", version.BuildTime),
+		zap.String("git_commit", version.Get().GitCommit),
 	)
 
+	// Stay within the container's memory before anything is allocated
+	utils.ApplyMemoryConfig(&config.Memory, logger)
+
+	// A standby applies the primary's writes until it is promoted, then
+	// starts as a server on the replicated storage
+	if config.Storage.Replication.Role == utils.ReplicationRoleStandby {
+		if storage.IsPromoted(config.Storage.Path) {
+			logger.Warn("Standby was promoted; starting as a server. Clear the storage path to make it a standby again")
+		} else if !runStandby(config, logger) {
+			return
+		}
+	}
+
 	// Initialize storage
 	store, err := storage.NewTimeSeriesDB(&config.Storage, logger)
 	if err != nil {
@@ -63,6 +79,15 @@ func main() {
 		logger.Fatal("Failed to create server", zap.Error(err))
 	}
 
+	// Open listeners first, taking any passed by systemd socket activation
+	activated, err := systemd.Listeners()
+	if err != nil {
+		logger.Fatal("Failed to use systemd sockets", zap.Error(err))
+	}
+	if err := srv.Listen(activated); err != nil {
+		logger.Fatal("Failed to open listeners", zap.Error(err))
+	}
+
 	// Start servers
 	go func() {
 		if err := srv.StartGRPC(); err != nil {
@@ -77,7 +102,7 @@ func main() {
 	}()
 
 	go func() {
-		if err := srv.StartWebSocket(); err != nil {
+		if err := srv.StartWebSocket(); err != nil && err != http.ErrServerClosed {
 			logger.Fatal("Failed to start WebSocket server", zap.Error(err))
 		}
 	}()
@@ -86,6 +111,41 @@ func main() {
 	go srv.StartAlertEngine()
 	go srv.StartRetentionJob()
 	go srv.StartHealthCheck()
+	go srv.StartExports()
+	go srv.StartKubeController()
+	go srv.StartDemo()
+	go srv.StartMetering()
+	go srv.StartTrends()
+
+	// Tell systemd the server is ready, and keep its watchdog fed while
+	// storage answers
+	if _, err := systemd.Notify(systemd.Ready, systemd.Status("Serving")); err != nil {
+		logger.Warn("Failed to notify systemd", zap.Error(err))
+	}
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	go systemd.RunWatchdog(watchdogCtx, srv.Alive, logger)
+
+	// Reload the configuration file, alert rules and TLS certificates on
+	// SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			logger.Info("Reloading configuration, alert rules and TLS certificates")
+			systemd.Notify(systemd.Reloading)
+			if _, err := srv.ReloadConfig(); err != nil {
+				logger.Error("Failed to reload configuration", zap.Error(err))
+			}
+			if err := srv.ReloadRules(); err != nil {
+				logger.Error("Failed to reload alert rules", zap.Error(err))
+			}
+			if err := srv.ReloadTLS(); err != nil {
+				logger.Error("Failed to reload TLS certificates", zap.Error(err))
+			}
+			systemd.Notify(systemd.Ready)
+		}
+	}()
 
 	// Wait for shutdown signal
 	quit := make(chan os.Signal, 1)
@@ -93,6 +153,8 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down server...")
+	systemd.Notify(systemd.Stopping)
+	stopWatchdog()
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -103,4 +165,58 @@ func main() {
 	}
 
 	logger.Info("Server stopped")
-}
\ No newline at end of file
+}
+
+// loadConfig loads the config file. In demo mode the defaults are used
+// when there is none, with storage in a temporary directory.
+func loadConfig() (*utils.Config, error) {
+	if !*demo {
+		return utils.LoadConfig(*configPath)
+	}
+
+	var config *utils.Config
+	if _, err := os.Stat(*configPath); err == nil {
+		if config, err = utils.LoadConfig(*configPath); err != nil {
+			return nil, err
+		}
+	} else {
+		dir, err := os.MkdirTemp("", "lnmonja-demo-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create demo storage: %w", err)
+		}
+		if config, err = utils.DefaultConfig(dir); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Demo mode: no config file at %s; storing data in %s\n", *configPath, dir)
+	}
+	config.Demo.Enabled = true
+	return config, nil
+}
+
+// runStandby applies replicated writes until the standby is promoted,
+// returning true, or stopped
+func runStandby(config *utils.Config, logger *zap.Logger) bool {
+	standby, err := storage.NewStandby(&config.Storage, logger)
+	if err != nil {
+		logger.Fatal("Failed to create standby", zap.Error(err))
+	}
+
+	if _, err := systemd.Notify(systemd.Ready, systemd.Status("Standby")); err != nil {
+		logger.Warn("Failed to notify systemd", zap.Error(err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := standby.Run(ctx); err != nil {
+		if ctx.Err() != nil {
+			logger.Info("Standby stopped")
+			systemd.Notify(systemd.Stopping)
+			return false
+		}
+		logger.Fatal("Standby failed", zap.Error(err))
+	}
+
+	logger.Info("Standby promoted; starting server")
+	systemd.Notify(systemd.Status("Promoting"))
+	return true
+}
@@ -3,12 +3,15 @@ package agent
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/meettoy2004/lnmonja/internal/agent/collectors"
 	"github.com/meettoy2004/lnmonja/internal/agent/client"
+	"github.com/meettoy2004/lnmonja/internal/agent/ebpf"
 	"github.com/meettoy2004/lnmonja/pkg/protocol"
 	"github.com/meettoy2004/lnmonja/pkg/utils"
 	"go.uber.org/zap"
@@ -25,6 +28,23 @@ type Agent struct {
 	metricsCh  chan []*collectors.Metric
 	nodeID     string
 	sessionID  string
+	vitals     *vitals
+	naming     *namingLog
+	kube       *kubeMetadata
+	priorities *priorities
+	states     map[string]*collectorState
+	statesMu   sync.Mutex
+
+	// buffer holds batches the server did not accept; the replay state
+	// is only touched by processMetrics
+	buffer         *diskBuffer
+	bufferRetryAt  time.Time
+	bufferBackoff  time.Duration
+	bufferReplayed int
+
+	// probeCh is answered by processMetrics, showing it is not stuck
+	probeCh chan chan struct{}
+	started time.Time
 }
 
 func NewAgent(config *utils.Config, logger *zap.Logger) (*Agent, error) {
@@ -33,9 +53,29 @@ func NewAgent(config *utils.Config, logger *zap.Logger) (*Agent, error) {
 		logger:     logger,
 		collectors: make(map[string]collectors.Collector),
 		metricsCh:  make(chan []*collectors.Metric, 1000),
+		probeCh:    make(chan chan struct{}),
+		vitals:     newVitals(),
+		states:     make(map[string]*collectorState),
+		priorities: newPriorities(config),
+	}
+
+	kubeMeta, err := newKubeMetadata(config, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Kubernetes metadata: %w", err)
 	}
+	agent.kube = kubeMeta
 
-	// Generate node ID if not provided
+	if config.Naming.Enabled {
+		if agent.naming, err = newNamingLog(&config.Naming, logger); err != nil {
+			return nil, fmt.Errorf("invalid naming conventions: %w", err)
+		}
+	}
+
+	// Generate node ID if not provided; in a DaemonSet the pod's hostname
+	// is not the node's
+	if config.Agent.NodeID == "" && kubeMeta != nil {
+		config.Agent.NodeID = kubeMeta.nodeName
+	}
 	if config.Agent.NodeID == "" {
 		hostname, _ := os.Hostname()
 		config.Agent.NodeID = hostname
@@ -53,6 +93,15 @@ func NewAgent(config *utils.Config, logger *zap.Logger) (*Agent, error) {
 	if err := agent.initCollectors(); err != nil {
 		return nil, fmt.Errorf("failed to initialize collectors: %w", err)
 	}
+	agent.initCollectorStates()
+
+	if config.Agent.Buffer.Enabled {
+		buffer, err := openDiskBuffer(config.Agent.Buffer.Path, config.Agent.Buffer.MaxSize, config.Agent.Buffer.MaxAge, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open metric buffer: %w", err)
+		}
+		agent.buffer = buffer
+	}
 
 	return agent, nil
 }
@@ -66,24 +115,39 @@ func (a *Agent) Start(ctx context.Context) error {
 	}
 
 	// Register with server
-	sessionID, err := a.client.Register(a.nodeID)
+	sessionID, collectors, err := a.client.Register(a.nodeID)
 	if err != nil {
 		return fmt.Errorf("failed to register with server: %w", err)
 	}
 	a.sessionID = sessionID
+	a.started = time.Now()
 
 	a.logger.Info("Agent registered",
 		zap.String("node_id", a.nodeID),
 		zap.String("session_id", sessionID),
 	)
 
+	if a.config.Agent.Telemetry.Enabled {
+		if err := a.startTelemetry(); err != nil {
+			return fmt.Errorf("failed to start telemetry endpoint: %w", err)
+		}
+	}
+
+	if a.kube != nil {
+		a.kube.start(a.ctx)
+		a.wg.Add(1)
+		go a.watchPods()
+	}
+
 	// Start collectors
-	for name, collector := range a.collectors {
-		if collector.Enabled() {
-			a.wg.Add(1)
-			go a.runCollector(name, collector)
+	a.statesMu.Lock()
+	for name, state := range a.states {
+		if state.enabled {
+			a.startCollector(name)
 		}
 	}
+	a.statesMu.Unlock()
+	a.applyRegistration(collectors)
 
 	// Start metric processor
 	a.wg.Add(1)
@@ -93,6 +157,10 @@ func (a *Agent) Start(ctx context.Context) error {
 	a.wg.Add(1)
 	go a.heartbeat()
 
+	// Handle commands from the server
+	a.wg.Add(1)
+	go a.handleControl()
+
 	a.logger.Info("Agent started successfully")
 	return nil
 }
@@ -119,11 +187,34 @@ func (a *Agent) Stop(ctx context.Context) error {
 		a.logger.Warn("Timeout waiting for goroutines to stop")
 	}
 
+	// Release what collectors hold open, such as attached eBPF programs
+	for name, collector := range a.collectors {
+		if closer, ok := collector.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				a.logger.Warn("Failed to close collector", zap.String("collector", name), zap.Error(err))
+			}
+		}
+	}
+
+	// Tell the server this is a clean shutdown, after the last batch is
+	// flushed, so the node is not alerted on as offline
+	if a.client != nil && a.sessionID != "" {
+		if err := a.client.Unregister(ctx, a.sessionID, "shutdown"); err != nil {
+			a.logger.Warn("Failed to unregister from server", zap.Error(err))
+		}
+	}
+
 	// Close client connection
 	if a.client != nil {
 		a.client.Close()
 	}
 
+	if a.buffer != nil {
+		if err := a.buffer.close(); err != nil {
+			a.logger.Warn("Failed to close metric buffer", zap.Error(err))
+		}
+	}
+
 	close(a.metricsCh)
 	a.logger.Info("Agent stopped")
 	return nil
@@ -136,6 +227,7 @@ func (a *Agent) initCollectors() error {
 			Enabled:  a.config.Collectors.System.Enabled,
 			Interval: a.config.Collectors.System.Interval,
 			Metrics:  a.config.Collectors.System.Metrics,
+			Cgroup:   a.config.Collectors.System.Cgroup,
 		}
 		sysCollector, err := collectors.NewSystemCollector(sysConfig)
 		if err != nil {
@@ -161,8 +253,18 @@ func (a *Agent) initCollectors() error {
 	// Container collector
 	if a.config.Collectors.Container.Enabled {
 		containerConfig := collectors.ContainerCollectorConfig{
-			Enabled: a.config.Collectors.Container.Enabled,
-			Runtime: a.config.Collectors.Container.Runtime,
+			Enabled:           a.config.Collectors.Container.Enabled,
+			Interval:          a.config.Collectors.Container.Interval,
+			Runtime:           a.config.Collectors.Container.Runtime,
+			DockerSocket:      a.config.Collectors.Container.DockerSocket,
+			PodmanSocket:      a.config.Collectors.Container.PodmanSocket,
+			CRISocket:         a.config.Collectors.Container.CRISocket,
+			ContainerdSocket:  a.config.Collectors.Container.ContainerdSocket,
+			CgroupRoot:        a.config.Collectors.System.Cgroup.Root,
+			DockerRoot:        a.config.Collectors.Container.DockerRoot,
+			ContainersStorage: a.config.Collectors.Container.ContainersStorage,
+			LabelInclude:      a.config.Collectors.Container.Labels.Include,
+			LabelExclude:      a.config.Collectors.Container.Labels.Exclude,
 		}
 		containerCollector, err := collectors.NewContainerCollector(containerConfig)
 		if err != nil {
@@ -173,6 +275,370 @@ func (a *Agent) initCollectors() error {
 		}
 	}
 
+	// Package updates collector
+	if a.config.Collectors.Packages.Enabled {
+		pkgConfig := collectors.PackageCollectorConfig{
+			Enabled:         a.config.Collectors.Packages.Enabled,
+			Interval:        a.config.Collectors.Packages.Interval,
+			Manager:         a.config.Collectors.Packages.Manager,
+			IncludePackages: a.config.Collectors.Packages.IncludePackages,
+		}
+		pkgCollector, err := collectors.NewPackageCollector(pkgConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create package collector", zap.Error(err))
+		} else {
+			a.collectors["packages"] = pkgCollector
+		}
+	}
+
+	// Kernel and reboot-required collector
+	if a.config.Collectors.Kernel.Enabled {
+		kernelConfig := collectors.KernelCollectorConfig{
+			Enabled:  a.config.Collectors.Kernel.Enabled,
+			Interval: a.config.Collectors.Kernel.Interval,
+		}
+		kernelCollector, err := collectors.NewKernelCollector(kernelConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create kernel collector: %w", err)
+		}
+		a.collectors["kernel"] = kernelCollector
+	}
+
+	// Kernel resource limits collector
+	if a.config.Collectors.Limits.Enabled {
+		limitsConfig := collectors.LimitsCollectorConfig{
+			Enabled:      a.config.Collectors.Limits.Enabled,
+			Interval:     a.config.Collectors.Limits.Interval,
+			TopProcesses: a.config.Collectors.Limits.TopProcesses,
+		}
+		limitsCollector, err := collectors.NewLimitsCollector(limitsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create limits collector: %w", err)
+		}
+		a.collectors["limits"] = limitsCollector
+	}
+
+	// Windows service state collector
+	if a.config.Collectors.WindowsServices.Enabled {
+		svcConfig := collectors.WindowsServiceCollectorConfig{
+			Enabled:  a.config.Collectors.WindowsServices.Enabled,
+			Interval: a.config.Collectors.WindowsServices.Interval,
+			Services: a.config.Collectors.WindowsServices.Services,
+		}
+		svcCollector, err := collectors.NewWindowsServiceCollector(svcConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create Windows service collector", zap.Error(err))
+		} else {
+			a.collectors["windows_services"] = svcCollector
+		}
+	}
+
+	// Windows Event Log collector
+	if a.config.Collectors.WindowsEventLog.Enabled {
+		evtConfig := collectors.WindowsEventLogCollectorConfig{
+			Enabled:  a.config.Collectors.WindowsEventLog.Enabled,
+			Interval: a.config.Collectors.WindowsEventLog.Interval,
+		}
+		for _, ch := range a.config.Collectors.WindowsEventLog.Channels {
+			evtConfig.Channels = append(evtConfig.Channels, collectors.EventLogChannel{
+				Name:      ch.Name,
+				Providers: ch.Providers,
+				Levels:    ch.Levels,
+			})
+		}
+		evtCollector, err := collectors.NewWindowsEventLogCollector(evtConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create Windows event log collector", zap.Error(err))
+		} else {
+			a.collectors["windows_eventlog"] = evtCollector
+		}
+	}
+
+	// Hyper-V VM collector
+	if a.config.Collectors.HyperV.Enabled {
+		hvCollector, err := collectors.NewHyperVCollector(collectors.HyperVCollectorConfig{
+			Enabled:  a.config.Collectors.HyperV.Enabled,
+			Interval: a.config.Collectors.HyperV.Interval,
+		})
+		if err != nil {
+			a.logger.Warn("Failed to create Hyper-V collector", zap.Error(err))
+		} else {
+			a.collectors["hyperv"] = hvCollector
+		}
+	}
+
+	// JMX collector for Java applications
+	if a.config.Collectors.JMX.Enabled {
+		jmxConfig := collectors.JMXCollectorConfig{
+			Enabled:  a.config.Collectors.JMX.Enabled,
+			Interval: a.config.Collectors.JMX.Interval,
+		}
+		for _, app := range a.config.Collectors.JMX.Apps {
+			jmxApp := collectors.JMXApp{
+				Name:               app.Name,
+				URL:                app.URL,
+				Username:           app.Username,
+				Password:           app.Password,
+				Target:             app.Target,
+				TargetUsername:     app.TargetUsername,
+				TargetPassword:     app.TargetPassword,
+				Timeout:            app.Timeout,
+				InsecureSkipVerify: app.InsecureSkipVerify,
+				Labels:             app.Labels,
+				DisableJVMMetrics:  app.DisableJVMMetrics,
+			}
+			for _, bean := range app.Beans {
+				jmxApp.Beans = append(jmxApp.Beans, collectors.JMXBean{
+					MBean:      bean.MBean,
+					Attributes: bean.Attributes,
+					Metric:     bean.Metric,
+					Type:       bean.Type,
+				})
+			}
+			jmxConfig.Apps = append(jmxConfig.Apps, jmxApp)
+		}
+		jmxCollector, err := collectors.NewJMXCollector(jmxConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create JMX collector", zap.Error(err))
+		} else {
+			a.collectors["jmx"] = jmxCollector
+		}
+	}
+
+	// Application server collectors
+	if a.config.Collectors.PHPFPM.Enabled {
+		fpmConfig := collectors.PHPFPMCollectorConfig{
+			Enabled:  a.config.Collectors.PHPFPM.Enabled,
+			Interval: a.config.Collectors.PHPFPM.Interval,
+		}
+		for _, pool := range a.config.Collectors.PHPFPM.Pools {
+			fpmConfig.Pools = append(fpmConfig.Pools, collectors.PHPFPMPool(pool))
+		}
+		fpmCollector, err := collectors.NewPHPFPMCollector(fpmConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create php-fpm collector", zap.Error(err))
+		} else {
+			a.collectors["php_fpm"] = fpmCollector
+		}
+	}
+
+	if a.config.Collectors.UWSGI.Enabled {
+		uwsgiConfig := collectors.UWSGICollectorConfig{
+			Enabled:  a.config.Collectors.UWSGI.Enabled,
+			Interval: a.config.Collectors.UWSGI.Interval,
+		}
+		for _, server := range a.config.Collectors.UWSGI.Servers {
+			uwsgiConfig.Servers = append(uwsgiConfig.Servers, collectors.UWSGIServer(server))
+		}
+		uwsgiCollector, err := collectors.NewUWSGICollector(uwsgiConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create uWSGI collector", zap.Error(err))
+		} else {
+			a.collectors["uwsgi"] = uwsgiCollector
+		}
+	}
+
+	if a.config.Collectors.Gunicorn.Enabled {
+		gunicornConfig := collectors.GunicornCollectorConfig{
+			Enabled:  a.config.Collectors.Gunicorn.Enabled,
+			Interval: a.config.Collectors.Gunicorn.Interval,
+		}
+		for _, server := range a.config.Collectors.Gunicorn.Servers {
+			gunicornConfig.Servers = append(gunicornConfig.Servers, collectors.GunicornServer(server))
+		}
+		gunicornCollector, err := collectors.NewGunicornCollector(gunicornConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create Gunicorn collector", zap.Error(err))
+		} else {
+			a.collectors["gunicorn"] = gunicornCollector
+		}
+	}
+
+	if a.config.Collectors.HAProxy.Enabled {
+		haproxyConfig := collectors.HAProxyCollectorConfig{
+			Enabled:  a.config.Collectors.HAProxy.Enabled,
+			Interval: a.config.Collectors.HAProxy.Interval,
+		}
+		for _, inst := range a.config.Collectors.HAProxy.Instances {
+			haproxyConfig.Instances = append(haproxyConfig.Instances, collectors.HAProxyInstance(inst))
+		}
+		haproxyCollector, err := collectors.NewHAProxyCollector(haproxyConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create HAProxy collector", zap.Error(err))
+		} else {
+			a.collectors["haproxy"] = haproxyCollector
+		}
+	}
+
+	if a.config.Collectors.Envoy.Enabled {
+		envoyConfig := collectors.EnvoyCollectorConfig{
+			Enabled:  a.config.Collectors.Envoy.Enabled,
+			Interval: a.config.Collectors.Envoy.Interval,
+		}
+		for _, inst := range a.config.Collectors.Envoy.Instances {
+			envoyConfig.Instances = append(envoyConfig.Instances, collectors.EnvoyInstance(inst))
+		}
+		envoyCollector, err := collectors.NewEnvoyCollector(envoyConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create Envoy collector", zap.Error(err))
+		} else {
+			a.collectors["envoy"] = envoyCollector
+		}
+	}
+
+	if a.config.Collectors.RabbitMQ.Enabled {
+		rabbitConfig := collectors.RabbitMQCollectorConfig{
+			Enabled:  a.config.Collectors.RabbitMQ.Enabled,
+			Interval: a.config.Collectors.RabbitMQ.Interval,
+		}
+		for _, server := range a.config.Collectors.RabbitMQ.Servers {
+			rabbitConfig.Servers = append(rabbitConfig.Servers, collectors.RabbitMQServer(server))
+		}
+		rabbitCollector, err := collectors.NewRabbitMQCollector(rabbitConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create RabbitMQ collector", zap.Error(err))
+		} else {
+			a.collectors["rabbitmq"] = rabbitCollector
+		}
+	}
+
+	if a.config.Collectors.Elasticsearch.Enabled {
+		esConfig := collectors.ElasticsearchCollectorConfig{
+			Enabled:  a.config.Collectors.Elasticsearch.Enabled,
+			Interval: a.config.Collectors.Elasticsearch.Interval,
+		}
+		for _, cluster := range a.config.Collectors.Elasticsearch.Clusters {
+			esConfig.Clusters = append(esConfig.Clusters, collectors.ElasticsearchCluster(cluster))
+		}
+		esCollector, err := collectors.NewElasticsearchCollector(esConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create Elasticsearch collector", zap.Error(err))
+		} else {
+			a.collectors["elasticsearch"] = esCollector
+		}
+	}
+
+	if a.config.Collectors.Ceph.Enabled {
+		cephCollector, err := collectors.NewCephCollector(collectors.CephCollectorConfig(a.config.Collectors.Ceph))
+		if err != nil {
+			a.logger.Warn("Failed to create Ceph collector", zap.Error(err))
+		} else {
+			a.collectors["ceph"] = cephCollector
+		}
+	}
+
+	if a.config.Collectors.Sockets.Enabled {
+		sockets := a.config.Collectors.Sockets
+		socketCollector, err := collectors.NewSocketCollector(collectors.SocketCollectorConfig(sockets))
+		if err != nil {
+			a.logger.Warn("Failed to create socket collector", zap.Error(err))
+		} else {
+			a.collectors["sockets"] = socketCollector
+		}
+	}
+
+	if a.config.Collectors.Systemd.Enabled {
+		systemd := a.config.Collectors.Systemd
+		systemdCollector, err := collectors.NewSystemdCollector(collectors.SystemdCollectorConfig{
+			Enabled:         systemd.Enabled,
+			Interval:        systemd.Interval,
+			Units:           systemd.Units,
+			CrashLoopWindow: systemd.CrashLoopWindow,
+			Timeout:         systemd.Timeout,
+			Journal:         systemd.Journal.Enabled,
+			JournalPriority: systemd.Journal.Priority,
+		})
+		if err != nil {
+			a.logger.Warn("Failed to create systemd collector", zap.Error(err))
+		} else {
+			a.collectors["systemd"] = systemdCollector
+		}
+	}
+
+	if a.config.Collectors.Kubernetes.Enabled {
+		kubernetes := a.config.Collectors.Kubernetes
+		kubernetesCollector, err := collectors.NewKubernetesCollector(collectors.KubernetesCollectorConfig(kubernetes))
+		if err != nil {
+			a.logger.Warn("Failed to create Kubernetes collector", zap.Error(err))
+		} else {
+			a.collectors["kubernetes"] = kubernetesCollector
+		}
+	}
+
+	if a.config.Collectors.EBPF.Enabled {
+		ebpfCollector, err := ebpf.NewEBPFCollector(ebpf.EBPFCollectorConfig(a.config.Collectors.EBPF))
+		if err != nil {
+			a.logger.Warn("Failed to create eBPF collector", zap.Error(err))
+		} else {
+			a.collectors["ebpf"] = ebpfCollector
+		}
+	}
+
+	if a.config.Collectors.ZFS.Enabled {
+		zfsCollector, err := collectors.NewZFSCollector(collectors.ZFSCollectorConfig(a.config.Collectors.ZFS))
+		if err != nil {
+			a.logger.Warn("Failed to create ZFS collector", zap.Error(err))
+		} else {
+			a.collectors["zfs"] = zfsCollector
+		}
+	}
+
+	if a.config.Collectors.Custom.Enabled {
+		custom := a.config.Collectors.Custom
+		customConfig := collectors.CustomCollectorConfig{
+			Enabled:     custom.Enabled,
+			Interval:    custom.Interval,
+			Path:        custom.Path,
+			Timeout:     custom.Timeout,
+			MaxParallel: custom.MaxParallel,
+			MaxOutput:   custom.MaxOutput,
+			MaxMemory:   custom.MaxMemory,
+			MaxCPUTime:  custom.MaxCPUTime,
+		}
+		for _, script := range custom.Scripts {
+			customConfig.Scripts = append(customConfig.Scripts, collectors.CustomScript(script))
+		}
+		customCollector, err := collectors.NewCustomCollector(customConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create custom collector", zap.Error(err))
+		} else {
+			a.collectors["custom"] = customCollector
+		}
+	}
+
+	if a.config.Collectors.Scrape.Enabled {
+		scrape := a.config.Collectors.Scrape
+		scrapeConfig := collectors.ScrapeCollectorConfig{
+			Enabled:  scrape.Enabled,
+			Interval: scrape.Interval,
+		}
+		for _, t := range scrape.Targets {
+			target := collectors.ScrapeTarget{
+				Name:               t.Name,
+				URL:                t.URL,
+				Interval:           t.Interval,
+				Timeout:            t.Timeout,
+				Username:           t.Username,
+				Password:           t.Password,
+				BearerToken:        t.BearerToken,
+				InsecureSkipVerify: t.InsecureSkipVerify,
+				Labels:             t.Labels,
+				HonorLabels:        t.HonorLabels,
+				SampleLimit:        t.SampleLimit,
+			}
+			for _, rule := range t.MetricRelabelConfigs {
+				target.MetricRelabelConfigs = append(target.MetricRelabelConfigs, collectors.RelabelRule(rule))
+			}
+			scrapeConfig.Targets = append(scrapeConfig.Targets, target)
+		}
+		scrapeCollector, err := collectors.NewScrapeCollector(scrapeConfig)
+		if err != nil {
+			a.logger.Warn("Failed to create scrape collector", zap.Error(err))
+		} else {
+			a.collectors["scrape"] = scrapeCollector
+		}
+	}
+
 	a.logger.Info("Collectors initialized",
 		zap.Int("count", len(a.collectors)),
 		zap.Strings("collectors", a.getCollectorNames()),
@@ -181,10 +647,9 @@ func (a *Agent) initCollectors() error {
 	return nil
 }
 
-func (a *Agent) runCollector(name string, collector collectors.Collector) {
+func (a *Agent) runCollector(ctx context.Context, name string, collector collectors.Collector, interval time.Duration) {
 	defer a.wg.Done()
 
-	interval := collector.Interval()
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -195,13 +660,14 @@ func (a *Agent) runCollector(name string, collector collectors.Collector) {
 
 	for {
 		select {
-		case <-a.ctx.Done():
+		case <-ctx.Done():
 			a.logger.Debug("Collector stopped", zap.String("name", name))
 			return
 		case <-ticker.C:
 			start := time.Now()
 			
-			metrics, err := collector.Collect(a.ctx)
+			metrics, err := collector.Collect(ctx)
+			a.vitals.collectorResult(name, time.Since(start), err)
 			if err != nil {
 				a.logger.Error("Collector failed",
 					zap.String("name", name),
@@ -209,21 +675,18 @@ func (a *Agent) runCollector(name string, collector collectors.Collector) {
 				)
 				continue
 			}
-			
-			// Add node and collector labels
-			for _, metric := range metrics {
-				if metric.Labels == nil {
-					metric.Labels = make(map[string]string)
-				}
-				metric.Labels["node"] = a.nodeID
-				metric.Labels["collector"] = name
+			if a.naming != nil {
+				a.naming.check(name, metrics)
 			}
 			
+			a.labelMetrics(name, metrics)
+			
 			// Send metrics to channel
 			select {
 			case a.metricsCh <- metrics:
 				// Metrics sent successfully
 			default:
+				a.vitals.dropped(len(metrics))
 				a.logger.Warn("Metrics channel full, dropping batch",
 					zap.String("collector", name),
 					zap.Int("metrics", len(metrics)),
@@ -250,6 +713,14 @@ func (a *Agent) processMetrics() {
 	batch := make([]*collectors.Metric, 0, batchSize)
 	batchTimer := time.NewTimer(maxWait)
 
+	// Retry buffered batches even when no new metrics arrive
+	var replayC <-chan time.Time
+	if a.buffer != nil {
+		replayTicker := time.NewTicker(time.Second)
+		defer replayTicker.Stop()
+		replayC = replayTicker.C
+	}
+
 	for {
 		select {
 		case <-a.ctx.Done():
@@ -276,15 +747,53 @@ func (a *Agent) processMetrics() {
 				batch = make([]*collectors.Metric, 0, batchSize)
 			}
 			batchTimer.Reset(maxWait)
+
+		case <-replayC:
+			a.replayBuffer()
+
+		case reply := <-a.probeCh:
+			close(reply)
 		}
 	}
 }
 
-func (a *Agent) sendMetrics(metrics []*collectors.Metric) {
-	// Convert to protobuf format
+// Alive checks that the metric processor is still running its loop, for
+// the systemd watchdog
+func (a *Agent) Alive(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case a.probeCh <- reply:
+		<-reply
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("metric processor is not responding")
+	}
+}
+
+// labelMetrics adds node, collector and instance group labels, and
+// Kubernetes metadata in a DaemonSet, and sets the metrics' priorities
+func (a *Agent) labelMetrics(name string, metrics []*collectors.Metric) {
+	for _, metric := range metrics {
+		if metric.Labels == nil {
+			metric.Labels = make(map[string]string)
+		}
+		metric.Labels["node"] = a.nodeID
+		metric.Labels["collector"] = name
+		if group := a.config.Agent.InstanceGroup; group != "" {
+			metric.Labels["instance_group"] = group
+		}
+	}
+	if a.kube != nil {
+		a.kube.enrich(metrics)
+	}
+	a.priorities.assign(name, metrics)
+}
+
+// toProtoMetrics converts collected metrics to protobuf format
+func toProtoMetrics(metrics []*collectors.Metric) []*protocol.Metric {
 	pbMetrics := make([]*protocol.Metric, 0, len(metrics))
 	now := time.Now().UnixNano()
-	
+
 	for _, metric := range metrics {
 		pbMetric := &protocol.Metric{
 			Name:      metric.Name,
@@ -294,28 +803,40 @@ func (a *Agent) sendMetrics(metrics []*collectors.Metric) {
 			Type:      protocol.MetricType(metric.Type),
 			Help:      metric.Help,
 			Unit:      metric.Unit,
+			Priority:  protocol.Priority(metric.Priority),
 		}
-		
+
 		// Use current time if timestamp is zero
 		if pbMetric.Timestamp == 0 {
 			pbMetric.Timestamp = now
 		}
-		
+
 		pbMetrics = append(pbMetrics, pbMetric)
 	}
-	
-	// Send to server
-	ctx, cancel := context.WithTimeout(a.ctx, 10*time.Second)
-	defer cancel()
-	
-	if err := a.client.SendMetrics(ctx, a.sessionID, pbMetrics); err != nil {
+
+	return pbMetrics
+}
+
+func (a *Agent) sendMetrics(metrics []*collectors.Metric) {
+	pbMetrics := toProtoMetrics(metrics)
+
+	// Queue behind buffered batches so the server receives them in order
+	if a.buffer != nil {
+		if batches, _ := a.buffer.pending(); batches > 0 {
+			a.bufferMetrics(pbMetrics)
+			a.replayBuffer()
+			return
+		}
+	}
+
+	if err := a.send(pbMetrics); err != nil {
 		a.logger.Error("Failed to send metrics",
 			zap.Error(err),
 			zap.Int("metrics", len(pbMetrics)),
 		)
-		
+
 		// Buffer metrics for retry
-		a.bufferMetrics(metrics)
+		a.bufferMetrics(pbMetrics)
 	} else {
 		a.logger.Debug("Metrics sent successfully",
 			zap.Int("count", len(pbMetrics)),
@@ -323,12 +844,171 @@ func (a *Agent) sendMetrics(metrics []*collectors.Metric) {
 	}
 }
 
-func (a *Agent) bufferMetrics(metrics []*collectors.Metric) {
-	// TODO: Implement disk-backed buffer for retry
-	// For now, just log and drop
-	a.logger.Warn("Metrics buffer not implemented, dropping metrics",
-		zap.Int("count", len(metrics)),
+func (a *Agent) send(metrics []*protocol.Metric) error {
+	ctx, cancel := context.WithTimeout(a.ctx, 10*time.Second)
+	defer cancel()
+	err := a.client.SendMetrics(ctx, a.sessionID, metrics)
+	a.vitals.sent(len(metrics), err)
+	return err
+}
+
+// bufferMetrics queues a batch on disk for replay, or drops it when the
+// buffer is disabled
+func (a *Agent) bufferMetrics(metrics []*protocol.Metric) {
+	if a.buffer == nil {
+		a.vitals.dropped(len(metrics))
+		a.logger.Warn("Metric buffer disabled, dropping metrics",
+			zap.Int("count", len(metrics)),
+		)
+		return
+	}
+
+	droppedBatches, droppedMetrics, err := a.buffer.append(metrics)
+	if droppedBatches > 0 {
+		a.vitals.droppedMany(droppedBatches, droppedMetrics)
+		a.logger.Warn("Metric buffer full, dropped oldest batches",
+			zap.Int("batches", droppedBatches),
+			zap.Int("metrics", droppedMetrics),
+		)
+	}
+	if err != nil {
+		a.vitals.dropped(len(metrics))
+		a.logger.Error("Failed to buffer metrics, dropping",
+			zap.Int("count", len(metrics)),
+			zap.Error(err),
+		)
+	}
+}
+
+// replayBuffer sends buffered batches oldest first. It spends at most one
+// batch wait per call so live batches are not held up, and backs off after
+// a failed send.
+func (a *Agent) replayBuffer() {
+	if a.buffer == nil || time.Now().Before(a.bufferRetryAt) {
+		return
+	}
+
+	deadline := time.Now().Add(a.config.Agent.MaxBatchWait)
+	for time.Now().Before(deadline) {
+		rec, err := a.buffer.peek()
+		if err != nil {
+			a.logger.Error("Failed to read metric buffer", zap.Error(err))
+			return
+		}
+		if rec == nil {
+			if a.bufferReplayed > 0 {
+				a.logger.Info("Metric buffer drained",
+					zap.Int("replayed_batches", a.bufferReplayed),
+				)
+				a.bufferReplayed = 0
+			}
+			return
+		}
+
+		if a.buffer.expired(rec) {
+			a.vitals.dropped(len(rec.metrics))
+			a.logger.Warn("Dropping expired buffered batch",
+				zap.Time("written", rec.written),
+				zap.Int("metrics", len(rec.metrics)),
+			)
+		} else if err := a.send(rec.metrics); err != nil {
+			a.bufferBackoff = min(max(2*a.bufferBackoff, time.Second), 30*time.Second)
+			a.bufferRetryAt = time.Now().Add(a.bufferBackoff)
+			a.logger.Debug("Buffered batch replay failed",
+				zap.Duration("retry_in", a.bufferBackoff),
+				zap.Error(err),
+			)
+			return
+		} else {
+			a.bufferBackoff = 0
+			a.bufferReplayed++
+		}
+
+		if err := a.buffer.ack(rec); err != nil {
+			a.logger.Warn("Failed to advance metric buffer", zap.Error(err))
+		}
+	}
+}
+
+// handleControl runs commands sent by the server
+func (a *Agent) handleControl() {
+	defer a.wg.Done()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case msg := <-a.client.Control():
+			if msg == nil {
+				continue
+			}
+			if cmd := msg.GetCollect(); cmd.GetRequestId() != "" {
+				go a.collectNow(cmd)
+			}
+			if update := msg.GetConfig(); update.GetRequestId() != "" {
+				go a.applyConfig(update)
+			}
+		}
+	}
+}
+
+// collectNow runs the requested collectors immediately and replies with
+// their metrics. An empty list runs every enabled collector.
+func (a *Agent) collectNow(cmd *protocol.CollectCommand) {
+	names := cmd.Collectors
+	if len(names) == 0 {
+		for name := range a.collectors {
+			if a.collectorEnabled(name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(a.ctx, 30*time.Second)
+	defer cancel()
+
+	var (
+		metrics []*collectors.Metric
+		errs    []string
 	)
+	for _, name := range names {
+		collector, ok := a.collectors[name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown collector %s", name))
+			continue
+		}
+
+		start := time.Now()
+		collected, err := collector.Collect(ctx)
+		a.vitals.collectorResult(name, time.Since(start), err)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+
+		a.labelMetrics(name, collected)
+		metrics = append(metrics, collected...)
+	}
+
+	// Report partial results only when every collector failed
+	errMsg := ""
+	if len(errs) > 0 && len(metrics) == 0 {
+		errMsg = strings.Join(errs, "; ")
+	}
+
+	a.logger.Info("On-demand collection finished",
+		zap.String("request_id", cmd.RequestId),
+		zap.Strings("collectors", names),
+		zap.Int("metrics", len(metrics)),
+		zap.Strings("errors", errs),
+	)
+
+	if err := a.client.SendCollectResult(ctx, a.sessionID, cmd.RequestId, toProtoMetrics(metrics), errMsg); err != nil {
+		a.logger.Error("Failed to send collect result",
+			zap.String("request_id", cmd.RequestId),
+			zap.Error(err),
+		)
+	}
 }
 
 func (a *Agent) heartbeat() {
@@ -344,7 +1024,7 @@ func (a *Agent) heartbeat() {
 			return
 		case <-ticker.C:
 			ctx, cancel := context.WithTimeout(a.ctx, 5*time.Second)
-			err := a.client.Heartbeat(ctx, a.sessionID)
+			err := a.client.Heartbeat(ctx, a.sessionID, a.collectInventory(), a.vitals.snapshot())
 			cancel()
 			
 			if err != nil {
@@ -371,19 +1051,37 @@ func (a *Agent) reconnect() {
 			}
 			
 			// Re-register
-			sessionID, err := a.client.Register(a.nodeID)
+			sessionID, collectors, err := a.client.Register(a.nodeID)
 			if err != nil {
 				a.logger.Error("Re-register failed", zap.Error(err))
 				continue
 			}
 			
 			a.sessionID = sessionID
+			a.applyRegistration(collectors)
 			a.logger.Info("Reconnected successfully")
 			return
 		}
 	}
 }
 
+// collectInventory merges the inventory reported by all collectors with
+// their runtime schedule
+func (a *Agent) collectInventory() map[string]string {
+	inventory := make(map[string]string)
+	a.collectorInventory(inventory)
+	for _, collector := range a.collectors {
+		provider, ok := collector.(collectors.InventoryProvider)
+		if !ok {
+			continue
+		}
+		for k, v := range provider.Inventory() {
+			inventory[k] = v
+		}
+	}
+	return inventory
+}
+
 func (a *Agent) getCollectorNames() []string {
 	names := make([]string, 0, len(a.collectors))
 	for name := range a.collectors {
@@ -0,0 +1,511 @@
+package agent
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// The metric buffer is a write-ahead log of batches the server did not
+// accept, split into numbered segment files. Each record is
+//
+//	length uint32 | crc32c uint32 | written unix nanos int64 | metrics uint32 | payload
+//
+// where the checksum covers everything after it and the payload is the
+// JSON encoded batch. The read position is kept in a head file, so batches
+// survive agent restarts and are replayed in order.
+const (
+	bufferSegmentExt  = ".wal"
+	bufferHeadFile    = "head"
+	bufferHeaderSize  = 20
+	bufferMaxRecord   = 64 << 20
+	bufferSegmentSize = 8 << 20
+)
+
+var bufferCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// bufferSegment is one file of the buffer
+type bufferSegment struct {
+	id      uint64
+	size    int64
+	batches int
+	metrics int
+}
+
+// bufferRecord is a batch read from the buffer
+type bufferRecord struct {
+	metrics []*protocol.Metric
+	written time.Time
+	// segment and next locate the record following this one
+	segment uint64
+	next    int64
+}
+
+// diskBuffer queues metric batches on disk while the server is unreachable
+type diskBuffer struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	logger  *zap.Logger
+	// segmentSize keeps several segments within maxSize, so dropping the
+	// oldest one frees room without emptying the buffer
+	segmentSize int64
+
+	mu       sync.Mutex
+	segments []*bufferSegment
+	writer   *os.File
+	// readOffset is the position of the next record in segments[0]
+	readOffset int64
+	// headBatches and headMetrics count the records of segments[0] before
+	// readOffset, which are already replayed
+	headBatches int
+	headMetrics int
+	size        int64
+}
+
+// bufferHead is the persisted read position
+type bufferHead struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// openDiskBuffer opens the buffer in dir, recovering from records that
+// were torn by a crash or corrupted on disk
+func openDiskBuffer(dir string, maxSize int64, maxAge time.Duration, logger *zap.Logger) (*diskBuffer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create buffer directory: %w", err)
+	}
+
+	b := &diskBuffer{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		logger:  logger,
+
+		segmentSize: min(bufferSegmentSize, maxSize/4),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read buffer directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, bufferSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, bufferSegmentExt), 10, 64)
+		if err != nil {
+			logger.Warn("Ignoring unknown file in metric buffer", zap.String("file", name))
+			continue
+		}
+		b.segments = append(b.segments, &bufferSegment{id: id})
+	}
+	sort.Slice(b.segments, func(i, j int) bool { return b.segments[i].id < b.segments[j].id })
+
+	head := b.readHead()
+	for len(b.segments) > 0 && b.segments[0].id < head.Segment {
+		b.removeSegment(b.segments[0])
+		b.segments = b.segments[1:]
+	}
+	if len(b.segments) > 0 && b.segments[0].id == head.Segment {
+		b.readOffset = head.Offset
+	}
+
+	for i, segment := range b.segments {
+		headOffset := int64(-1)
+		if i == 0 {
+			headOffset = b.readOffset
+		}
+		if err := b.recoverSegment(segment, headOffset); err != nil {
+			return nil, err
+		}
+		b.size += segment.size
+	}
+
+	if len(b.segments) == 0 {
+		// Keep numbering after the head so stale segments are not reused
+		id := head.Segment
+		if id == 0 {
+			id = 1
+		}
+		b.segments = append(b.segments, &bufferSegment{id: id})
+		b.readOffset = 0
+	}
+	if err := b.openWriter(); err != nil {
+		return nil, err
+	}
+
+	if batches, metrics := b.pendingLocked(); batches > 0 {
+		logger.Info("Metric buffer has batches to replay",
+			zap.Int("batches", batches),
+			zap.Int("metrics", metrics),
+			zap.Int64("bytes", b.size),
+		)
+	}
+
+	return b, nil
+}
+
+// recoverSegment counts the records of a segment and truncates it at the
+// first torn or corrupt record. Records before headOffset are counted as
+// replayed.
+func (b *diskBuffer) recoverSegment(segment *bufferSegment, headOffset int64) error {
+	path := b.segmentPath(segment.id)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open buffer segment: %w", err)
+	}
+	defer f.Close()
+
+	var offset int64
+	for {
+		rec, n, err := readBufferRecord(f, offset, false)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			info, _ := f.Stat()
+			b.logger.Warn("Truncating corrupt metric buffer segment",
+				zap.String("segment", path),
+				zap.Int64("offset", offset),
+				zap.Int64("discarded_bytes", info.Size()-offset),
+				zap.Error(err),
+			)
+			if err := f.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate buffer segment: %w", err)
+			}
+			break
+		}
+
+		segment.batches++
+		segment.metrics += rec.count
+		if offset < headOffset {
+			b.headBatches++
+			b.headMetrics += rec.count
+		}
+		offset += n
+	}
+
+	segment.size = offset
+	if headOffset > offset {
+		// The head points past the data that survived
+		b.readOffset = offset
+	}
+	return nil
+}
+
+// append writes a batch to the end of the buffer. When the buffer is full
+// the oldest segments are dropped to make room; their batch and metric
+// counts are returned.
+func (b *diskBuffer) append(metrics []*protocol.Metric) (droppedBatches, droppedMetrics int, err error) {
+	payload, err := json.Marshal(metrics)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to encode batch: %w", err)
+	}
+	if len(payload) > bufferMaxRecord {
+		return 0, 0, fmt.Errorf("batch of %d bytes exceeds the buffer record limit", len(payload))
+	}
+
+	now := time.Now()
+	record := make([]byte, bufferHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint64(record[8:16], uint64(now.UnixNano()))
+	binary.BigEndian.PutUint32(record[16:20], uint32(len(metrics)))
+	copy(record[bufferHeaderSize:], payload)
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(record[8:], bufferCRC))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tail := b.segments[len(b.segments)-1]
+	if tail.size > 0 && tail.size+int64(len(record)) > b.segmentSize {
+		if err := b.rotate(); err != nil {
+			return 0, 0, err
+		}
+		tail = b.segments[len(b.segments)-1]
+	}
+
+	// Make room by dropping the oldest data, never the segment being
+	// written
+	for b.size+int64(len(record)) > b.maxSize && len(b.segments) > 1 {
+		batches, metrics := b.dropHead()
+		droppedBatches += batches
+		droppedMetrics += metrics
+	}
+
+	if _, err := b.writer.Write(record); err != nil {
+		// Discard a partial write so the segment stays readable
+		b.writer.Truncate(tail.size)
+		b.writer.Seek(tail.size, io.SeekStart)
+		return droppedBatches, droppedMetrics, fmt.Errorf("failed to write buffer record: %w", err)
+	}
+
+	tail.size += int64(len(record))
+	tail.batches++
+	tail.metrics += len(metrics)
+	b.size += int64(len(record))
+
+	return droppedBatches, droppedMetrics, nil
+}
+
+// peek returns the oldest batch without removing it, or nil when the
+// buffer is empty. Corrupt records are skipped.
+func (b *diskBuffer) peek() (*bufferRecord, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		segment := b.segments[0]
+		if b.readOffset >= segment.size {
+			if len(b.segments) == 1 {
+				return nil, nil
+			}
+			b.dropHead()
+			continue
+		}
+
+		f, err := os.Open(b.segmentPath(segment.id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open buffer segment: %w", err)
+		}
+		rec, n, err := readBufferRecord(f, b.readOffset, true)
+		f.Close()
+		if err != nil {
+			// Written records were checksummed when the buffer was
+			// opened, so this is damage on disk; skip the segment
+			b.logger.Warn("Skipping corrupt metric buffer segment",
+				zap.Uint64("segment", segment.id),
+				zap.Int64("offset", b.readOffset),
+				zap.Error(err),
+			)
+			if len(b.segments) == 1 {
+				if err := b.rotate(); err != nil {
+					return nil, err
+				}
+			}
+			b.dropHead()
+			continue
+		}
+
+		return &bufferRecord{
+			metrics: rec.metrics,
+			written: rec.written,
+			segment: segment.id,
+			next:    b.readOffset + n,
+		}, nil
+	}
+}
+
+// ack removes a batch returned by peek once it has been delivered or
+// discarded
+func (b *diskBuffer) ack(rec *bufferRecord) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.segments[0].id != rec.segment {
+		// The segment was dropped to make room meanwhile
+		return nil
+	}
+
+	b.readOffset = rec.next
+	b.headBatches++
+	b.headMetrics += len(rec.metrics)
+
+	// Replay of the last segment is complete; start over with an empty
+	// one so the drained data can be removed
+	segment := b.segments[0]
+	if len(b.segments) == 1 && b.readOffset >= segment.size {
+		if err := b.rotate(); err != nil {
+			return err
+		}
+		b.dropHead()
+		return nil
+	}
+
+	return b.writeHead()
+}
+
+// expired reports whether a batch is older than the maximum age
+func (b *diskBuffer) expired(rec *bufferRecord) bool {
+	return b.maxAge > 0 && time.Since(rec.written) > b.maxAge
+}
+
+// pending returns the number of batches and metrics waiting for replay
+func (b *diskBuffer) pending() (batches, metrics int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pendingLocked()
+}
+
+// bytes returns the size of the buffer on disk and its limit
+func (b *diskBuffer) bytes() (size, maxSize int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size, b.maxSize
+}
+
+func (b *diskBuffer) pendingLocked() (batches, metrics int) {
+	for _, segment := range b.segments {
+		batches += segment.batches
+		metrics += segment.metrics
+	}
+	return batches - b.headBatches, metrics - b.headMetrics
+}
+
+// close syncs and closes the segment being written
+func (b *diskBuffer) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.writer == nil {
+		return nil
+	}
+	b.writeHead()
+	b.writer.Sync()
+	err := b.writer.Close()
+	b.writer = nil
+	return err
+}
+
+// rotate starts a new segment. The caller must hold mu.
+func (b *diskBuffer) rotate() error {
+	if err := b.writer.Sync(); err != nil {
+		b.logger.Warn("Failed to sync metric buffer segment", zap.Error(err))
+	}
+	b.writer.Close()
+
+	b.segments = append(b.segments, &bufferSegment{id: b.segments[len(b.segments)-1].id + 1})
+	return b.openWriter()
+}
+
+// dropHead removes the oldest segment and returns the number of batches
+// and metrics in it that were never replayed. The caller must hold mu and
+// ensure it is not the segment being written.
+func (b *diskBuffer) dropHead() (batches, metrics int) {
+	segment := b.segments[0]
+	batches = segment.batches - b.headBatches
+	metrics = segment.metrics - b.headMetrics
+
+	b.removeSegment(segment)
+	b.size -= segment.size
+	b.segments = b.segments[1:]
+	b.readOffset, b.headBatches, b.headMetrics = 0, 0, 0
+	b.writeHead()
+
+	return batches, metrics
+}
+
+func (b *diskBuffer) removeSegment(segment *bufferSegment) {
+	if err := os.Remove(b.segmentPath(segment.id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		b.logger.Warn("Failed to remove metric buffer segment", zap.Error(err))
+	}
+}
+
+// openWriter opens the last segment for appending. The caller must hold mu.
+func (b *diskBuffer) openWriter() error {
+	tail := b.segments[len(b.segments)-1]
+	f, err := os.OpenFile(b.segmentPath(tail.id), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open buffer segment: %w", err)
+	}
+	if _, err := f.Seek(tail.size, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open buffer segment: %w", err)
+	}
+	b.writer = f
+	return nil
+}
+
+func (b *diskBuffer) segmentPath(id uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", id, bufferSegmentExt))
+}
+
+func (b *diskBuffer) readHead() bufferHead {
+	var head bufferHead
+	data, err := os.ReadFile(filepath.Join(b.dir, bufferHeadFile))
+	if err != nil {
+		return head
+	}
+	if err := json.Unmarshal(data, &head); err != nil || head.Offset < 0 {
+		b.logger.Warn("Ignoring corrupt metric buffer head, replaying from the start")
+		return bufferHead{}
+	}
+	return head
+}
+
+// writeHead persists the read position. The caller must hold mu.
+func (b *diskBuffer) writeHead() error {
+	data, _ := json.Marshal(bufferHead{Segment: b.segments[0].id, Offset: b.readOffset})
+
+	path := filepath.Join(b.dir, bufferHeadFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write buffer head: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write buffer head: %w", err)
+	}
+	return nil
+}
+
+// decodedRecord is a record read from a segment
+type decodedRecord struct {
+	written time.Time
+	count   int
+	metrics []*protocol.Metric
+}
+
+// readBufferRecord reads the record at offset and returns it with its
+// size. io.EOF means there is no record at offset; any other error means
+// the record is torn or corrupt. The payload is decoded only if decode is
+// set.
+func readBufferRecord(f *os.File, offset int64, decode bool) (*decodedRecord, int64, error) {
+	header := make([]byte, bufferHeaderSize)
+	n, err := f.ReadAt(header, offset)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if n < bufferHeaderSize {
+		return nil, 0, fmt.Errorf("torn record header")
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > bufferMaxRecord {
+		return nil, 0, fmt.Errorf("invalid record length %d", length)
+	}
+
+	data := make([]byte, bufferHeaderSize-8+int(length))
+	copy(data, header[8:])
+	if n, _ := f.ReadAt(data[bufferHeaderSize-8:], offset+bufferHeaderSize); n < int(length) {
+		return nil, 0, fmt.Errorf("torn record")
+	}
+	if crc32.Checksum(data, bufferCRC) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, fmt.Errorf("checksum mismatch")
+	}
+
+	rec := &decodedRecord{
+		written: time.Unix(0, int64(binary.BigEndian.Uint64(header[8:16]))),
+		count:   int(binary.BigEndian.Uint32(header[16:20])),
+	}
+	if decode {
+		if err := json.Unmarshal(data[bufferHeaderSize-8:], &rec.metrics); err != nil {
+			return nil, 0, fmt.Errorf("invalid record payload: %w", err)
+		}
+	}
+
+	return rec, bufferHeaderSize + int64(length), nil
+}
@@ -0,0 +1,243 @@
+package agent
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/meettoy2004/lnmonja/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+func openTestBuffer(t *testing.T, dir string, maxSize int64) *diskBuffer {
+	t.Helper()
+	b, err := openDiskBuffer(dir, maxSize, 0, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// appendBatches appends a one metric batch named m<i> for each i, padded
+// so a record is roughly pad bytes
+func appendBatches(t *testing.T, b *diskBuffer, pad int, ids ...int) (droppedBatches int) {
+	t.Helper()
+	for _, i := range ids {
+		dropped, _, err := b.append([]*protocol.Metric{{
+			Name:  fmt.Sprintf("m%d", i),
+			Value: float64(i),
+			Help:  strings.Repeat("x", pad),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		droppedBatches += dropped
+	}
+	return droppedBatches
+}
+
+// drain replays and acknowledges every batch, returning their names
+func drain(t *testing.T, b *diskBuffer) []string {
+	t.Helper()
+	var names []string
+	for {
+		rec, err := b.peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec == nil {
+			return names
+		}
+		for _, m := range rec.metrics {
+			names = append(names, m.Name)
+		}
+		if err := b.ack(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func names(ids ...int) []string {
+	out := make([]string, 0, len(ids))
+	for _, i := range ids {
+		out = append(out, fmt.Sprintf("m%d", i))
+	}
+	return out
+}
+
+func expectBatches(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+}
+
+// segmentFiles returns the buffer's segment files in order
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+bufferSegmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// recordOffsets returns the offset of each record in a segment file
+func recordOffsets(t *testing.T, path string) []int64 {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for off := 0; off < len(data); off += bufferHeaderSize + int(binary.BigEndian.Uint32(data[off:])) {
+		offsets = append(offsets, int64(off))
+	}
+	return offsets
+}
+
+func TestDiskBufferReplaysAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	b := openTestBuffer(t, dir, 1<<20)
+	appendBatches(t, b, 10, 0, 1, 2)
+
+	rec, err := b.peek()
+	if err != nil || rec == nil {
+		t.Fatalf("peek: %v, %v", rec, err)
+	}
+	if err := b.ack(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openTestBuffer(t, dir, 1<<20)
+	defer b.close()
+	if batches, metrics := b.pending(); batches != 2 || metrics != 2 {
+		t.Fatalf("pending %d batches, %d metrics, want 2 and 2", batches, metrics)
+	}
+	expectBatches(t, drain(t, b), names(1, 2))
+}
+
+func TestDiskBufferTornTail(t *testing.T) {
+	dir := t.TempDir()
+	b := openTestBuffer(t, dir, 1<<20)
+	appendBatches(t, b, 10, 0, 1, 2)
+	b.close()
+
+	files := segmentFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("got %d segments, want 1", len(files))
+	}
+	offsets := recordOffsets(t, files[0])
+	info, _ := os.Stat(files[0])
+	if err := os.Truncate(files[0], info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openTestBuffer(t, dir, 1<<20)
+	defer b.close()
+	if info, _ := os.Stat(files[0]); info.Size() != offsets[2] {
+		t.Fatalf("segment is %d bytes after recovery, want %d", info.Size(), offsets[2])
+	}
+	if batches, _ := b.pending(); batches != 2 {
+		t.Fatalf("pending %d batches, want 2", batches)
+	}
+
+	// New records follow the last intact one
+	appendBatches(t, b, 10, 3)
+	expectBatches(t, drain(t, b), names(0, 1, 3))
+}
+
+func TestDiskBufferCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	// Small enough that each segment holds two records
+	b := openTestBuffer(t, dir, 4096)
+	appendBatches(t, b, 400, 0, 1, 2, 3, 4, 5)
+	b.close()
+
+	files := segmentFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("got %d segments, want 3", len(files))
+	}
+
+	// Damage the payload of the first record of the middle segment
+	f, err := os.OpenFile(files[1], os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("?"), bufferHeaderSize+10); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// The segment is cut at the corrupt record; the others replay
+	b = openTestBuffer(t, dir, 4096)
+	defer b.close()
+	if batches, _ := b.pending(); batches != 4 {
+		t.Fatalf("pending %d batches, want 4", batches)
+	}
+	expectBatches(t, drain(t, b), names(0, 1, 4, 5))
+}
+
+func TestDiskBufferHeadPastEOF(t *testing.T) {
+	dir := t.TempDir()
+	b := openTestBuffer(t, dir, 1<<20)
+	appendBatches(t, b, 10, 0, 1)
+	b.close()
+
+	data, err := os.ReadFile(filepath.Join(dir, bufferHeadFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var head bufferHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		t.Fatal(err)
+	}
+	head.Offset = 1 << 20
+	data, _ = json.Marshal(head)
+	if err := os.WriteFile(filepath.Join(dir, bufferHeadFile), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything up to the head counts as replayed, and new records are
+	// read from where they are written
+	b = openTestBuffer(t, dir, 1<<20)
+	defer b.close()
+	if batches, metrics := b.pending(); batches != 0 || metrics != 0 {
+		t.Fatalf("pending %d batches, %d metrics, want none", batches, metrics)
+	}
+	appendBatches(t, b, 10, 2)
+	expectBatches(t, drain(t, b), names(2))
+}
+
+func TestDiskBufferDropsOldestWhenFull(t *testing.T) {
+	dir := t.TempDir()
+	const maxSize = 4096
+	b := openTestBuffer(t, dir, maxSize)
+	defer b.close()
+
+	ids := make([]int, 20)
+	for i := range ids {
+		ids[i] = i
+	}
+	dropped := appendBatches(t, b, 400, ids...)
+	if dropped == 0 {
+		t.Fatal("expected batches to be dropped")
+	}
+	if size, _ := b.bytes(); size > maxSize {
+		t.Fatalf("buffer is %d bytes, over its %d byte limit", size, maxSize)
+	}
+	batches, _ := b.pending()
+	if batches+dropped != len(ids) {
+		t.Fatalf("pending %d and dropped %d batches, want %d in total", batches, dropped, len(ids))
+	}
+
+	// The newest batches are kept, in order
+	expectBatches(t, drain(t, b), names(ids[dropped:]...))
+}
@@ -9,11 +9,14 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 )
 
 // ConnectionManager manages gRPC connection lifecycle
 type ConnectionManager struct {
 	address    string
+	apiKey     string
+	creds      credentials.TransportCredentials
 	conn       *grpc.ClientConn
 	logger     *zap.Logger
 	mu         sync.RWMutex
@@ -22,12 +25,15 @@ type ConnectionManager struct {
 	reconnectC chan struct{}
 }
 
-// NewConnectionManager creates a new connection manager
-func NewConnectionManager(address string, logger *zap.Logger) *ConnectionManager {
+// NewConnectionManager creates a new connection manager that dials with
+// creds. A non-empty apiKey is sent with every call.
+func NewConnectionManager(address, apiKey string, creds credentials.TransportCredentials, logger *zap.Logger) *ConnectionManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &ConnectionManager{
 		address:    address,
+		apiKey:     apiKey,
+		creds:      creds,
 		logger:     logger,
 		ctx:        ctx,
 		cancel:     cancel,
@@ -47,14 +53,21 @@ func (cm *ConnectionManager) Connect() error {
 		}
 	}
 
-	conn, err := grpc.Dial(
-		cm.address,
-		grpc.WithInsecure(),
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(cm.creds),
 		grpc.WithBlock(),
-		grpc.WithTimeout(10*time.Second),
-	)
+		grpc.WithTimeout(10 * time.Second),
+		// Report why the connection failed, such as a TLS error, rather
+		// than only the dial timeout
+		grpc.WithReturnConnectionError(),
+	}
+	if cm.apiKey != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(apiKeyCredentials(cm.apiKey)))
+	}
+
+	conn, err := grpc.Dial(cm.address, opts...)
 	if err != nil {
-		return fmt.Errorf("failed to connect to %s: %w", cm.address, err)
+		return fmt.Errorf("failed to connect to %s: %w", cm.address, withConnectionHint(err))
 	}
 
 	cm.conn = conn
@@ -134,3 +147,16 @@ func (cm *ConnectionManager) monitorConnection() {
 		}
 	}
 }
+
+// apiKeyCredentials sends the agent's API key as x-api-key metadata
+type apiKeyCredentials string
+
+func (k apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-api-key": string(k)}, nil
+}
+
+// RequireTransportSecurity allows the key over plaintext connections; enable
+// agent TLS when the network is untrusted
+func (k apiKeyCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/protocol"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+const (
+	// enrollTimeout bounds enrollment and renewal, including the dial
+	enrollTimeout = 30 * time.Second
+	// renewCheckInterval is how often the certificate is checked for
+	// renewal
+	renewCheckInterval = time.Minute
+)
+
+// enrollIfNeeded gets a certificate from the server's CA when the agent
+// has an enrollment token and no certificate, or one that has expired
+func (c *GRPCClient) enrollIfNeeded(ctx context.Context) error {
+	tlsConfig := c.config.Agent.TLS
+	if tlsConfig.EnrollmentToken == "" {
+		return nil
+	}
+	if cert, err := loadCertificate(tlsConfig.CertFile); err == nil && time.Now().Before(cert.NotAfter) {
+		return nil
+	}
+
+	// Enroll without a client certificate, since an expired one would
+	// fail the handshake
+	bootstrap := tlsConfig
+	bootstrap.CertFile, bootstrap.KeyFile = "", ""
+	creds, err := newTransportCredentials(&bootstrap, &c.config.TLS, c.config.Agent.ServerAddress, c.logger)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, enrollTimeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, c.config.Agent.ServerAddress,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithBlock(),
+		grpc.WithReturnConnectionError(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect for enrollment: %w", withConnectionHint(err))
+	}
+	defer conn.Close()
+
+	key, csr, err := newCertificateRequest(c.config.Agent.NodeID)
+	if err != nil {
+		return err
+	}
+	resp, err := protocol.NewMonitorServiceClient(conn).Enroll(ctx, &protocol.EnrollRequest{
+		NodeId: c.config.Agent.NodeID,
+		Token:  tlsConfig.EnrollmentToken,
+		Csr:    csr,
+	})
+	if err != nil {
+		return fmt.Errorf("enrollment failed: %w", err)
+	}
+	if err := writeKeyPair(tlsConfig.CertFile, tlsConfig.KeyFile, resp.Certificate, key); err != nil {
+		return err
+	}
+
+	c.logger.Info("Enrolled with server",
+		zap.String("cert_file", tlsConfig.CertFile),
+		zap.Time("not_after", resp.ExpiresAt.AsTime()),
+	)
+	return nil
+}
+
+// renewCertificates renews the enrolled certificate until the client is
+// closed
+func (c *GRPCClient) renewCertificates() {
+	ticker := time.NewTicker(renewCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.renewIfDue(); err != nil {
+				// Retried on the next tick; an expired certificate is
+				// replaced by enrolling again on reconnect
+				c.logger.Warn("Failed to renew client certificate", zap.Error(err))
+			}
+		}
+	}
+}
+
+// renewIfDue renews the certificate once two thirds of its lifetime have
+// passed. The new certificate is used from the next handshake.
+func (c *GRPCClient) renewIfDue() error {
+	tlsConfig := c.config.Agent.TLS
+	cert, err := loadCertificate(tlsConfig.CertFile)
+	if err != nil {
+		return err
+	}
+	if time.Until(cert.NotAfter) > cert.NotAfter.Sub(cert.NotBefore)/3 {
+		return nil
+	}
+
+	client, err := c.rpc()
+	if err != nil {
+		return err
+	}
+	key, csr, err := newCertificateRequest(c.config.Agent.NodeID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), enrollTimeout)
+	defer cancel()
+
+	resp, err := client.RenewCertificate(ctx, &protocol.RenewCertificateRequest{Csr: csr})
+	if err != nil {
+		return fmt.Errorf("renew call failed: %w", err)
+	}
+	if err := writeKeyPair(tlsConfig.CertFile, tlsConfig.KeyFile, resp.Certificate, key); err != nil {
+		return err
+	}
+
+	c.logger.Info("Renewed client certificate",
+		zap.String("cert_file", tlsConfig.CertFile),
+		zap.Time("not_after", resp.ExpiresAt.AsTime()),
+	)
+	return nil
+}
+
+// newCertificateRequest generates a key and a PEM encoded CSR for it
+func newCertificateRequest(nodeID string) (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: nodeID},
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// writeKeyPair replaces the key and certificate files. The key is written
+// first; until the certificate follows, a reload fails and the previous
+// pair is kept.
+func writeKeyPair(certFile, keyFile string, certPEM []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %w", err)
+	}
+	if err := writeFileAtomic(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := writeFileAtomic(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate file: %w", err)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadCertificate parses the first certificate in a PEM file
+func loadCertificate(file string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM certificate found in %s", file)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
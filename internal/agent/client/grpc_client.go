@@ -3,19 +3,43 @@ package client
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/meettoy2004/lnmonja/pkg/protocol"
 	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"github.com/meettoy2004/lnmonja/pkg/version"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// registerTimeout bounds the Register call
+const registerTimeout = 10 * time.Second
+
 // GRPCClient handles communication with the lnmonja server
 type GRPCClient struct {
-	config    *utils.Config
-	logger    *zap.Logger
-	connMgr   *ConnectionManager
-	client    protocol.MonitorService
+	config  *utils.Config
+	logger  *zap.Logger
+	connMgr *ConnectionManager
+	control chan *protocol.ControlMessage
+
+	mu        sync.RWMutex
 	connected bool
+	nodeID    string
+
+	// stream is the metric stream of the current session. Batches,
+	// collect results and config acks are sent on it and control messages
+	// received from it.
+	stream       protocol.MonitorService_StreamMetricsClient
+	streamCancel context.CancelFunc
+	sendMu       sync.Mutex
+	batchSeq     atomic.Int64
+
+	// stop ends certificate renewal, which starts on the first Connect
+	stop      chan struct{}
+	renewing  sync.Once
+	closeOnce sync.Once
 }
 
 // NewGRPCClient creates a new gRPC client
@@ -25,57 +49,208 @@ func NewGRPCClient(config *utils.Config, logger *zap.Logger) (*GRPCClient, error
 		return nil, fmt.Errorf("server address not configured")
 	}
 
-	connMgr := NewConnectionManager(serverAddr, logger)
+	creds, err := newTransportCredentials(&config.Agent.TLS, &config.TLS, serverAddr, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load agent TLS config: %w", err)
+	}
+
+	connMgr := NewConnectionManager(serverAddr, config.Agent.APIKey, creds, logger)
 
 	return &GRPCClient{
 		config:  config,
 		logger:  logger,
 		connMgr: connMgr,
+		control: make(chan *protocol.ControlMessage, 16),
+		stop:    make(chan struct{}),
 	}, nil
 }
 
 // Connect establishes connection to the server
 func (c *GRPCClient) Connect(ctx context.Context) error {
+	if err := c.enrollIfNeeded(ctx); err != nil {
+		return err
+	}
 	if err := c.connMgr.Connect(); err != nil {
 		return err
 	}
+	if c.config.Agent.TLS.EnrollmentToken != "" {
+		c.renewing.Do(func() { go c.renewCertificates() })
+	}
 
+	c.mu.Lock()
 	c.connected = true
+	c.mu.Unlock()
 	return nil
 }
 
-// Register registers the agent with the server
-func (c *GRPCClient) Register(nodeID string) (string, error) {
+// rpc returns a client on the current connection, which the connection
+// manager replaces when it reconnects
+func (c *GRPCClient) rpc() (protocol.MonitorServiceClient, error) {
 	conn := c.connMgr.GetConnection()
 	if conn == nil {
-		return "", fmt.Errorf("not connected")
+		return nil, fmt.Errorf("not connected")
+	}
+	return protocol.NewMonitorServiceClient(conn), nil
+}
+
+// Register registers the agent with the server and opens the metric
+// stream of the new session
+func (c *GRPCClient) Register(nodeID string) (string, []*protocol.CollectorConfig, error) {
+	client, err := c.rpc()
+	if err != nil {
+		return "", nil, err
 	}
 
 	sysInfo := utils.GetSystemInfo()
+	build := version.Get()
 
-	// In a real implementation, this would send the registration request via gRPC
-	_ = &protocol.RegisterRequest{
+	req := &protocol.RegisterRequest{
 		NodeId:   nodeID,
 		Hostname: sysInfo.Hostname,
 		Os:       sysInfo.OS,
 		Arch:     sysInfo.Arch,
-		Version:  "1.0.0",
+		Version:  build.Version,
 		Labels:   make(map[string]string),
+		BuildInfo: &protocol.VersionInfo{
+			Version:   build.Version,
+			BuildTime: build.BuildTime,
+			GitCommit: build.GitCommit,
+			GoVersion: build.GoVersion,
+			Os:        build.OS,
+			Arch:      build.Arch,
+		},
+		Ephemeral:     c.config.Agent.Ephemeral,
+		InstanceGroup: c.config.Agent.InstanceGroup,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), registerTimeout)
+	defer cancel()
+
+	resp, err := client.Register(ctx, req)
+	if err != nil {
+		// With TLS 1.3 a rejected client certificate surfaces on the
+		// first call rather than when connecting
+		return "", nil, fmt.Errorf("register call failed: %w", withConnectionHint(err))
+	}
+	if !resp.Success || resp.SessionId == "" {
+		return "", nil, fmt.Errorf("registration rejected: %s", resp.Message)
 	}
 
-	sessionID := utils.GenerateSessionID()
+	c.mu.Lock()
+	c.nodeID = nodeID
+	c.mu.Unlock()
+
+	if err := c.openStream(client, nodeID, resp.SessionId); err != nil {
+		return "", nil, err
+	}
 
 	c.logger.Info("Registered with server",
 		zap.String("node_id", nodeID),
-		zap.String("session_id", sessionID),
+		zap.String("session_id", resp.SessionId),
 	)
 
-	return sessionID, nil
+	return resp.SessionId, resp.Collectors, nil
+}
+
+// openStream starts the metric stream of a session. The server expects
+// the first batch to carry only the session ID.
+func (c *GRPCClient) openStream(client protocol.MonitorServiceClient, nodeID, sessionID string) error {
+	c.closeStream()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.StreamMetrics(ctx)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to open metric stream: %w", err)
+	}
+
+	handshake := &protocol.MetricBatch{
+		NodeId:    nodeID,
+		SessionId: sessionID,
+		SentAt:    timestamppb.Now(),
+	}
+	if err := stream.Send(handshake); err != nil {
+		cancel()
+		return fmt.Errorf("failed to start metric stream: %w", err)
+	}
+
+	c.sendMu.Lock()
+	c.stream = stream
+	c.streamCancel = cancel
+	c.sendMu.Unlock()
+
+	go c.receive(stream)
+	return nil
+}
+
+// receive forwards control messages from the stream until it ends. The
+// server then drops the session, so the next heartbeat fails and the
+// agent reconnects and registers again.
+func (c *GRPCClient) receive(stream protocol.MonitorService_StreamMetricsClient) {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			c.sendMu.Lock()
+			if c.stream == stream {
+				c.stream = nil
+			}
+			c.sendMu.Unlock()
+
+			c.logger.Warn("Metric stream closed", zap.Error(err))
+			return
+		}
+
+		select {
+		case c.control <- msg:
+		default:
+			c.logger.Warn("Control queue full, dropping server command")
+		}
+	}
+}
+
+// closeStream ends the current metric stream, if any
+func (c *GRPCClient) closeStream() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.stream != nil {
+		c.stream.CloseSend()
+		c.stream = nil
+	}
+	if c.streamCancel != nil {
+		c.streamCancel()
+		c.streamCancel = nil
+	}
+}
+
+// send writes a batch to the metric stream. gRPC streams allow one sender
+// at a time.
+func (c *GRPCClient) send(ctx context.Context, batch *protocol.MetricBatch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	batch.NodeId = c.nodeID
+	c.mu.RUnlock()
+	batch.BatchSeq = c.batchSeq.Add(1)
+	batch.SentAt = timestamppb.Now()
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.stream == nil {
+		return fmt.Errorf("metric stream is not open")
+	}
+	if err := c.stream.Send(batch); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+	return nil
 }
 
 // SendMetrics sends metrics to the server
 func (c *GRPCClient) SendMetrics(ctx context.Context, sessionID string, metrics []*protocol.Metric) error {
-	if !c.connected {
+	if !c.isConnected() {
 		return fmt.Errorf("not connected to server")
 	}
 
@@ -84,31 +259,177 @@ func (c *GRPCClient) SendMetrics(ctx context.Context, sessionID string, metrics
 		zap.Int("count", len(metrics)),
 	)
 
+	return c.send(ctx, &protocol.MetricBatch{
+		SessionId: sessionID,
+		Metrics:   metrics,
+	})
+}
+
+// Control returns the control messages received from the server on the
+// metric stream
+func (c *GRPCClient) Control() <-chan *protocol.ControlMessage {
+	return c.control
+}
+
+// SendCollectResult replies to an on-demand collect command with the
+// collected metrics or the error that prevented collection
+func (c *GRPCClient) SendCollectResult(ctx context.Context, sessionID, requestID string, metrics []*protocol.Metric, errMsg string) error {
+	if !c.isConnected() {
+		return fmt.Errorf("not connected to server")
+	}
+
+	batch := &protocol.MetricBatch{
+		SessionId: sessionID,
+		Metrics:   metrics,
+		RequestId: requestID,
+		Error:     errMsg,
+	}
+
+	c.logger.Debug("Sending collect result",
+		zap.String("session_id", batch.SessionId),
+		zap.String("request_id", batch.RequestId),
+		zap.Int("count", len(batch.Metrics)),
+	)
+
+	return c.send(ctx, batch)
+}
+
+// SendConfigAck replies to a config update with the outcome and the
+// resulting collector state
+func (c *GRPCClient) SendConfigAck(ctx context.Context, sessionID string, ack *protocol.ConfigAck) error {
+	if !c.isConnected() {
+		return fmt.Errorf("not connected to server")
+	}
+
+	batch := &protocol.MetricBatch{
+		SessionId: sessionID,
+		RequestId: ack.RequestId,
+		ConfigAck: ack,
+	}
+
+	c.logger.Debug("Sending config ack",
+		zap.String("session_id", batch.SessionId),
+		zap.String("request_id", batch.RequestId),
+		zap.Bool("success", ack.Success),
+	)
+
+	return c.send(ctx, batch)
+}
+
+// Heartbeat sends a heartbeat to the server along with the node inventory
+// and health vitals
+func (c *GRPCClient) Heartbeat(ctx context.Context, sessionID string, inventory map[string]string, vitals *protocol.NodeVitals) error {
+	if !c.isConnected() {
+		return fmt.Errorf("not connected to server")
+	}
+
+	client, err := c.rpc()
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	nodeID := c.nodeID
+	c.mu.RUnlock()
+
+	req := &protocol.HeartbeatRequest{
+		NodeId:    nodeID,
+		SessionId: sessionID,
+		Status:    protocol.NodeStatus_HEALTHY,
+		Inventory: inventory,
+		Vitals:    vitals,
+	}
+
+	c.logger.Debug("Sending heartbeat",
+		zap.String("session_id", req.SessionId),
+		zap.Int("inventory_items", len(req.Inventory)),
+	)
+
+	resp, err := client.Heartbeat(ctx, req)
+	if err != nil {
+		return fmt.Errorf("heartbeat call failed: %w", err)
+	}
+	if !resp.Alive {
+		return fmt.Errorf("server did not accept heartbeat")
+	}
 	return nil
 }
 
-// Heartbeat sends a heartbeat to the server
-func (c *GRPCClient) Heartbeat(ctx context.Context, sessionID string) error {
-	if !c.connected {
+// Unregister tells the server the agent is shutting down cleanly
+func (c *GRPCClient) Unregister(ctx context.Context, sessionID, reason string) error {
+	if !c.isConnected() {
 		return fmt.Errorf("not connected to server")
 	}
 
-	c.logger.Debug("Sending heartbeat", zap.String("session_id", sessionID))
+	client, err := c.rpc()
+	if err != nil {
+		return err
+	}
+
+	req := &protocol.UnregisterRequest{
+		SessionId: sessionID,
+		Reason:    reason,
+	}
+
+	c.logger.Info("Unregistering from server",
+		zap.String("session_id", req.SessionId),
+		zap.String("reason", req.Reason),
+	)
+
+	// Unregister before closing the stream, which ends the session
+	_, err = client.Unregister(ctx, req)
+	c.closeStream()
+	if err != nil {
+		return fmt.Errorf("unregister call failed: %w", err)
+	}
 	return nil
 }
 
-// Reconnect attempts to reconnect to the server
+// Reconnect attempts to reconnect to the server. The caller registers
+// again to open a new session.
 func (c *GRPCClient) Reconnect(ctx context.Context) error {
+	c.closeStream()
+
+	c.mu.Lock()
 	c.connected = false
+	c.mu.Unlock()
+
+	// The certificate may have expired while the agent was disconnected
+	if err := c.enrollIfNeeded(ctx); err != nil {
+		return err
+	}
 	if err := c.connMgr.Reconnect(); err != nil {
 		return err
 	}
+
+	c.mu.Lock()
 	c.connected = true
+	c.mu.Unlock()
 	return nil
 }
 
 // Close closes the connection
 func (c *GRPCClient) Close() error {
+	c.closeOnce.Do(func() { close(c.stop) })
+	c.closeStream()
+
+	c.mu.Lock()
 	c.connected = false
+	c.mu.Unlock()
 	return c.connMgr.Close()
 }
+
+// State returns the state of the connection to the server, such as READY
+// or TRANSIENT_FAILURE
+func (c *GRPCClient) State() string {
+	conn := c.connMgr.GetConnection()
+	if conn == nil {
+		return "SHUTDOWN"
+	}
+	return conn.GetState().String()
+}
+
+func (c *GRPCClient) isConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connected
+}
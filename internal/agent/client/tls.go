@@ -0,0 +1,295 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newTransportCredentials returns the credentials the agent dials the
+// server with, restricted by policy. Without TLS the connection is
+// plaintext, which strict mode refuses.
+func newTransportCredentials(config *utils.AgentTLSConfig, policy *utils.TLSPolicyConfig, serverAddr string, logger *zap.Logger) (credentials.TransportCredentials, error) {
+	if policy.Strict && (!config.Enabled || config.InsecureSkipVerify) {
+		return nil, fmt.Errorf("strict TLS mode needs agent TLS enabled with server verification")
+	}
+	if !config.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+
+	serverName := config.ServerName
+	if serverName == "" {
+		serverName = serverAddr
+		if host, _, err := net.SplitHostPort(serverAddr); err == nil {
+			serverName = host
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		// The server is verified in VerifyConnection instead, against the
+		// current CA file and with the cause of a failure logged
+		InsecureSkipVerify: true,
+	}
+	if err := policy.Apply(tlsConfig); err != nil {
+		return nil, err
+	}
+
+	if config.CertFile != "" {
+		keyPair, err := newKeyPairReloader(config.CertFile, config.KeyFile, config.EnrollmentToken != "", logger)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = keyPair.GetClientCertificate
+	}
+
+	if config.InsecureSkipVerify {
+		logger.Warn("Server certificate verification is disabled; use insecure_skip_verify for development only")
+		return credentials.NewTLS(tlsConfig), nil
+	}
+
+	var roots *caReloader
+	if config.CAFile != "" {
+		var err error
+		if roots, err = newCAReloader(config.CAFile, logger); err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig.VerifyConnection = verifyServer(serverName, roots, logger)
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// verifyServer checks the server's certificate chain and name, as the TLS
+// package would, and logs why verification failed
+func verifyServer(serverName string, roots *caReloader, logger *zap.Logger) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			logger.Error("Server sent no TLS certificate", zap.String("server_name", serverName))
+			return fmt.Errorf("server sent no certificate")
+		}
+
+		opts := x509.VerifyOptions{
+			DNSName:       serverName,
+			Intermediates: x509.NewCertPool(),
+		}
+		if roots != nil {
+			opts.Roots = roots.Pool()
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+
+		leaf := cs.PeerCertificates[0]
+		if _, err := leaf.Verify(opts); err != nil {
+			logger.Error("Server certificate verification failed",
+				zap.String("server_name", serverName),
+				zap.String("subject", leaf.Subject.String()),
+				zap.String("issuer", leaf.Issuer.String()),
+				zap.Time("not_after", leaf.NotAfter),
+				zap.String("hint", verifyHint(err, serverName)),
+				zap.Error(err),
+			)
+			return err
+		}
+		return nil
+	}
+}
+
+// verifyHint explains a server certificate verification error in terms of
+// the agent's TLS settings
+func verifyHint(err error, serverName string) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return "the server certificate is not signed by a trusted CA; set tls.ca_file to the CA that issued it"
+	case errors.As(err, &hostname):
+		return fmt.Sprintf("the server certificate is not valid for %q; set tls.server_name to a name it covers", serverName)
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return "the server certificate has expired or is not yet valid; check it and the agent's clock"
+	}
+	return "check the server certificate and tls.ca_file"
+}
+
+// connectionHint explains TLS failures reported by the server or the
+// connection, which reach the agent only as error text
+func connectionHint(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "certificate required"):
+		return "the server requires a client certificate; set tls.cert_file and tls.key_file"
+	case strings.Contains(msg, "tls: bad certificate"), strings.Contains(msg, "tls: unknown certificate authority"):
+		return "the server rejected the client certificate; it must be signed by the server's client_ca_file"
+	case strings.Contains(msg, "first record does not look like a TLS handshake"):
+		return "the server is not using TLS; disable tls in the agent or enable it on the server"
+	case strings.Contains(msg, "x509:"):
+		return "the server certificate could not be verified; see the verification error logged above"
+	}
+	return ""
+}
+
+// withConnectionHint appends the connectionHint of an error, if any
+func withConnectionHint(err error) error {
+	if hint := connectionHint(err); hint != "" {
+		return fmt.Errorf("%w (%s)", err, hint)
+	}
+	return err
+}
+
+// keyPairReloader serves the client certificate, loading it again when the
+// certificate or key file changes. Before an enrolling agent has written
+// its first certificate no certificate is sent.
+type keyPairReloader struct {
+	certFile string
+	keyFile  string
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newKeyPairReloader(certFile, keyFile string, enrolling bool, logger *zap.Logger) (*keyPairReloader, error) {
+	r := &keyPairReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+
+	modTime, err := latestModTime(certFile, keyFile)
+	if enrolling && errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *keyPairReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate %s: %w", r.certFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse client certificate %s: %w", r.certFile, err)
+		}
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		r.logger.Warn("Client certificate has expired",
+			zap.String("cert_file", r.certFile),
+			zap.Time("not_after", cert.Leaf.NotAfter),
+		)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetClientCertificate returns the current certificate. A certificate
+// that fails to reload is logged and the previous one kept.
+func (r *keyPairReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err == nil && modTime.After(r.modTime) {
+		if err := r.load(modTime); err != nil {
+			r.logger.Warn("Failed to reload client certificate, keeping the current one", zap.Error(err))
+		} else {
+			r.logger.Info("Reloaded client certificate",
+				zap.String("cert_file", r.certFile),
+				zap.Time("not_after", r.cert.Leaf.NotAfter),
+			)
+		}
+	}
+	if r.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+// caReloader serves the CA pool, loading it again when the CA file changes
+type caReloader struct {
+	file   string
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+func newCAReloader(file string, logger *zap.Logger) (*caReloader, error) {
+	r := &caReloader{file: file, logger: logger}
+
+	modTime, err := latestModTime(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *caReloader) load(modTime time.Time) error {
+	data, err := os.ReadFile(r.file)
+	if err != nil {
+		return fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no PEM certificates found in CA file %s", r.file)
+	}
+
+	r.pool = pool
+	r.modTime = modTime
+	return nil
+}
+
+// Pool returns the current CA pool. A CA file that fails to reload is
+// logged and the previous pool kept.
+func (r *caReloader) Pool() *x509.CertPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := latestModTime(r.file)
+	if err == nil && modTime.After(r.modTime) {
+		if err := r.load(modTime); err != nil {
+			r.logger.Warn("Failed to reload CA file, keeping the current one", zap.Error(err))
+		} else {
+			r.logger.Info("Reloaded CA file", zap.String("ca_file", r.file))
+		}
+	}
+	return r.pool
+}
+
+// latestModTime returns the most recent modification time of the files
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// minCollectorInterval bounds intervals pushed by the server
+const minCollectorInterval = time.Second
+
+// errLocalCollectors rejects pushed changes on an agent keeping its own
+// collector schedule
+var errLocalCollectors = errors.New("remote collector changes are disabled on this agent")
+
+// collectorState is the runtime schedule of a collector, which the server
+// may change without restarting the agent
+type collectorState struct {
+	enabled  bool
+	interval time.Duration
+	cancel   context.CancelFunc
+}
+
+// initCollectorStates takes the initial schedule from the collector config
+func (a *Agent) initCollectorStates() {
+	a.statesMu.Lock()
+	defer a.statesMu.Unlock()
+
+	for name, collector := range a.collectors {
+		a.states[name] = &collectorState{
+			enabled:  collector.Enabled(),
+			interval: collector.Interval(),
+		}
+	}
+}
+
+// startCollector runs a collector on its current interval. The caller must
+// hold statesMu.
+func (a *Agent) startCollector(name string) {
+	state := a.states[name]
+	ctx, cancel := context.WithCancel(a.ctx)
+	state.cancel = cancel
+
+	a.wg.Add(1)
+	go a.runCollector(ctx, name, a.collectors[name], state.interval)
+}
+
+// collectorEnabled reports whether a collector is currently scheduled
+func (a *Agent) collectorEnabled(name string) bool {
+	a.statesMu.Lock()
+	defer a.statesMu.Unlock()
+
+	state, ok := a.states[name]
+	return ok && state.enabled
+}
+
+// applyConfig applies collector changes pushed by the server and
+// acknowledges them. Nothing is changed if any change is invalid.
+func (a *Agent) applyConfig(update *protocol.ConfigUpdate) {
+	ack := &protocol.ConfigAck{
+		Success:   true,
+		RequestId: update.RequestId,
+	}
+
+	err := errLocalCollectors
+	if !a.config.Agent.LocalCollectors {
+		err = a.updateCollectors(update.Collectors)
+	}
+	if err != nil {
+		ack.Success = false
+		ack.Message = err.Error()
+		a.logger.Warn("Rejected collector update",
+			zap.String("request_id", update.RequestId),
+			zap.Error(err),
+		)
+	} else {
+		a.logger.Info("Collector update applied",
+			zap.String("request_id", update.RequestId),
+			zap.Int("changes", len(update.Collectors)),
+		)
+	}
+	ack.Collectors = a.collectorInfo()
+
+	ctx, cancel := context.WithTimeout(a.ctx, 10*time.Second)
+	defer cancel()
+
+	if err := a.client.SendConfigAck(ctx, a.sessionID, ack); err != nil {
+		a.logger.Error("Failed to send config ack",
+			zap.String("request_id", update.RequestId),
+			zap.Error(err),
+		)
+	}
+}
+
+// applyRegistration applies the collector schedule the server sent at
+// registration. Collectors this agent does not run, and intervals it would
+// reject, are skipped rather than failing the rest.
+func (a *Agent) applyRegistration(configs []*protocol.CollectorConfig) {
+	if a.config.Agent.LocalCollectors || len(configs) == 0 {
+		return
+	}
+
+	changes := make([]*protocol.CollectorChange, 0, len(configs))
+	for _, config := range configs {
+		if _, ok := a.collectors[config.Name]; !ok {
+			continue
+		}
+		if config.Interval != 0 && time.Duration(config.Interval)*time.Millisecond < minCollectorInterval {
+			a.logger.Warn("Ignoring collector interval from server",
+				zap.String("collector", config.Name),
+				zap.Duration("interval", time.Duration(config.Interval)*time.Millisecond),
+			)
+			config.Interval = 0
+		}
+		enabled := config.Enabled
+		changes = append(changes, &protocol.CollectorChange{
+			Name:     config.Name,
+			Enabled:  &enabled,
+			Interval: config.Interval,
+		})
+	}
+
+	if err := a.updateCollectors(changes); err != nil {
+		a.logger.Warn("Failed to apply collectors from server", zap.Error(err))
+		return
+	}
+	a.logger.Info("Applied collectors from server", zap.Int("collectors", len(changes)))
+}
+
+func (a *Agent) updateCollectors(changes []*protocol.CollectorChange) error {
+	for _, change := range changes {
+		if _, ok := a.collectors[change.Name]; !ok {
+			return fmt.Errorf("collector %s is not configured on this agent", change.Name)
+		}
+		if change.Interval != 0 && time.Duration(change.Interval)*time.Millisecond < minCollectorInterval {
+			return fmt.Errorf("collector %s: interval must be at least %s", change.Name, minCollectorInterval)
+		}
+	}
+
+	a.statesMu.Lock()
+	defer a.statesMu.Unlock()
+
+	if a.ctx.Err() != nil {
+		return fmt.Errorf("agent is stopping")
+	}
+
+	for _, change := range changes {
+		state := a.states[change.Name]
+		enabled, interval := state.enabled, state.interval
+		if change.Enabled != nil {
+			enabled = *change.Enabled
+		}
+		if change.Interval != 0 {
+			interval = time.Duration(change.Interval) * time.Millisecond
+		}
+		if enabled == state.enabled && interval == state.interval {
+			continue
+		}
+
+		if state.cancel != nil {
+			state.cancel()
+			state.cancel = nil
+		}
+		state.enabled, state.interval = enabled, interval
+		if enabled {
+			a.startCollector(change.Name)
+		}
+	}
+
+	return nil
+}
+
+// collectorInfo returns the runtime schedule of every collector
+func (a *Agent) collectorInfo() []*protocol.CollectorInfo {
+	a.statesMu.Lock()
+	defer a.statesMu.Unlock()
+
+	infos := make([]*protocol.CollectorInfo, 0, len(a.states))
+	for name, state := range a.states {
+		infos = append(infos, &protocol.CollectorInfo{
+			Name:     name,
+			Enabled:  state.enabled,
+			Interval: state.interval.Milliseconds(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// collectorInventory reports the runtime schedule as inventory entries
+func (a *Agent) collectorInventory(inventory map[string]string) {
+	a.statesMu.Lock()
+	defer a.statesMu.Unlock()
+
+	for name, state := range a.states {
+		inventory[models.InventoryCollectorEnabled(name)] = strconv.FormatBool(state.enabled)
+		inventory[models.InventoryCollectorInterval(name)] = state.interval.String()
+	}
+}
@@ -0,0 +1,79 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// appServerTimeout bounds a status read when the instance sets no timeout
+const appServerTimeout = 5 * time.Second
+
+// isHTTPAddress reports whether a status address is a URL rather than a
+// socket
+func isHTTPAddress(address string) bool {
+	return strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://")
+}
+
+// socketAddress splits a socket address given as unix:///path,
+// tcp://host:port, a bare path or host:port into a network and address
+func socketAddress(address string) (string, string) {
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "/"):
+		return "unix", address
+	}
+	return "tcp", address
+}
+
+// dialSocket connects to a socket address, closing the connection when
+// ctx is done
+func dialSocket(ctx context.Context, address string) (net.Conn, error) {
+	network, addr := socketAddress(address)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+// getJSON fetches a JSON status page
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// upMetric reports whether an instance's status could be read
+func upMetric(name string, up bool, labels map[string]string, help string) *Metric {
+	return &Metric{
+		Name:   name,
+		Value:  boolToFloat(up),
+		Labels: labels,
+		Type:   MetricTypeGauge,
+		Help:   help,
+	}
+}
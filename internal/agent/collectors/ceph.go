@@ -0,0 +1,222 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CephCollectorConfig holds configuration for the Ceph collector. The
+// agent runs the ceph command, so it must be on a host with a keyring that
+// can read cluster status, such as a monitor or admin node.
+type CephCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	// Cluster is passed to ceph --cluster and reported as the cluster label
+	Cluster    string
+	ConfigFile string
+	// User is the client the command authenticates as, e.g. admin
+	User    string
+	Timeout time.Duration
+}
+
+// CephCollector reports cluster health, OSD and placement group states and
+// capacity from ceph status
+type CephCollector struct {
+	*BaseCollector
+	config CephCollectorConfig
+}
+
+// cephTimeout is used when no timeout is configured
+const cephTimeout = 30 * time.Second
+
+// NewCephCollector creates a new Ceph collector
+func NewCephCollector(config CephCollectorConfig) (*CephCollector, error) {
+	if _, err := exec.LookPath("ceph"); err != nil {
+		return nil, fmt.Errorf("ceph command not found: %w", err)
+	}
+	if config.Cluster == "" {
+		config.Cluster = "ceph"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = cephTimeout
+	}
+	return &CephCollector{
+		BaseCollector: NewBaseCollector("ceph", config.Enabled, config.Interval),
+		config:        config,
+	}, nil
+}
+
+// cephStatus is the part of ceph status --format json that is reported
+type cephStatus struct {
+	Health struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			Severity string `json:"severity"`
+			Muted    bool   `json:"muted"`
+		} `json:"checks"`
+	} `json:"health"`
+	OSDMap cephOSDMap `json:"osdmap"`
+	PGMap  struct {
+		PGsByState []struct {
+			StateName string  `json:"state_name"`
+			Count     float64 `json:"count"`
+		} `json:"pgs_by_state"`
+		NumPGs         float64 `json:"num_pgs"`
+		NumPools       float64 `json:"num_pools"`
+		NumObjects     float64 `json:"num_objects"`
+		DataBytes      float64 `json:"data_bytes"`
+		BytesUsed      float64 `json:"bytes_used"`
+		BytesAvail     float64 `json:"bytes_avail"`
+		BytesTotal     float64 `json:"bytes_total"`
+		ReadBytesSec   float64 `json:"read_bytes_sec"`
+		WriteBytesSec  float64 `json:"write_bytes_sec"`
+		ReadOpPerSec   float64 `json:"read_op_per_sec"`
+		WriteOpPerSec  float64 `json:"write_op_per_sec"`
+		DegradedRatio  float64 `json:"degraded_ratio"`
+		MisplacedRatio float64 `json:"misplaced_ratio"`
+	} `json:"pgmap"`
+}
+
+// cephOSDMap holds OSD counts. Releases before Octopus nest them in a
+// second osdmap object.
+type cephOSDMap struct {
+	NumOSDs   float64     `json:"num_osds"`
+	NumUpOSDs float64     `json:"num_up_osds"`
+	NumInOSDs float64     `json:"num_in_osds"`
+	Nested    *cephOSDMap `json:"osdmap"`
+}
+
+// cephHealthValues maps the cluster health to the reported value
+var cephHealthValues = map[string]float64{
+	"HEALTH_OK":   0,
+	"HEALTH_WARN": 1,
+	"HEALTH_ERR":  2,
+}
+
+// Collect collects Ceph cluster metrics
+func (cc *CephCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	labels := map[string]string{"cluster": cc.config.Cluster}
+
+	status, err := cc.status(ctx)
+	if err != nil {
+		// Report the cluster as down rather than failing the collection
+		return []*Metric{upMetric("ceph_up", false, labels, "Whether ceph status succeeded")}, nil
+	}
+
+	gauge := func(name string, value float64, labels map[string]string, help, unit string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: labels, Type: MetricTypeGauge, Help: help, Unit: unit}
+	}
+	metrics := []*Metric{upMetric("ceph_up", true, labels, "Whether ceph status succeeded")}
+
+	health, ok := cephHealthValues[status.Health.Status]
+	if !ok {
+		health = 2
+	}
+	metrics = append(metrics, gauge("ceph_health_status", health, labels, "Cluster health: 0 HEALTH_OK, 1 HEALTH_WARN, 2 HEALTH_ERR", ""))
+
+	checks := make([]string, 0, len(status.Health.Checks))
+	for name := range status.Health.Checks {
+		checks = append(checks, name)
+	}
+	sort.Strings(checks)
+	for _, name := range checks {
+		check := status.Health.Checks[name]
+		checkLabels := withLabel(withLabel(labels, "check", name), "severity", check.Severity)
+		metrics = append(metrics, gauge("ceph_health_check", 1, withLabel(checkLabels, "muted", fmt.Sprintf("%t", check.Muted)),
+			"Active health check, such as OSD_DOWN or PG_DEGRADED", ""))
+	}
+
+	osds := status.OSDMap
+	if osds.Nested != nil {
+		osds = *osds.Nested
+	}
+	metrics = append(metrics,
+		gauge("ceph_osds", osds.NumOSDs, labels, "Number of OSDs", ""),
+		gauge("ceph_osds_up", osds.NumUpOSDs, labels, "Number of OSDs that are up", ""),
+		gauge("ceph_osds_in", osds.NumInOSDs, labels, "Number of OSDs that are in the cluster", ""),
+		gauge("ceph_osds_down", osds.NumOSDs-osds.NumUpOSDs, labels, "Number of OSDs that are down", ""),
+	)
+
+	pgmap := status.PGMap
+	metrics = append(metrics, gauge("ceph_pgs", pgmap.NumPGs, labels, "Number of placement groups", ""))
+
+	// A PG state such as active+undersized+degraded is counted under each
+	// of its parts
+	states := make(map[string]float64)
+	var activeClean float64
+	for _, s := range pgmap.PGsByState {
+		if s.StateName == "active+clean" {
+			activeClean += s.Count
+		}
+		for _, state := range strings.Split(s.StateName, "+") {
+			states[state] += s.Count
+		}
+	}
+	stateNames := make([]string, 0, len(states))
+	for state := range states {
+		stateNames = append(stateNames, state)
+	}
+	sort.Strings(stateNames)
+	for _, state := range stateNames {
+		metrics = append(metrics, gauge("ceph_pgs_state", states[state], withLabel(labels, "state", state),
+			"Number of placement groups in a state", ""))
+	}
+	metrics = append(metrics,
+		gauge("ceph_pgs_not_active_clean", pgmap.NumPGs-activeClean, labels,
+			"Number of placement groups that are not active+clean", ""),
+		gauge("ceph_objects_degraded_ratio", pgmap.DegradedRatio, labels, "Fraction of object copies that are degraded", ""),
+		gauge("ceph_objects_misplaced_ratio", pgmap.MisplacedRatio, labels, "Fraction of objects that are misplaced", ""),
+		gauge("ceph_pools", pgmap.NumPools, labels, "Number of pools", ""),
+		gauge("ceph_objects", pgmap.NumObjects, labels, "Number of objects", ""),
+		gauge("ceph_data_bytes", pgmap.DataBytes, labels, "Bytes of data stored, before replication", "bytes"),
+		gauge("ceph_bytes_total", pgmap.BytesTotal, labels, "Raw capacity of the cluster", "bytes"),
+		gauge("ceph_bytes_used", pgmap.BytesUsed, labels, "Raw capacity used", "bytes"),
+		gauge("ceph_bytes_avail", pgmap.BytesAvail, labels, "Raw capacity available", "bytes"),
+		gauge("ceph_read_bytes_per_second", pgmap.ReadBytesSec, labels, "Client read throughput", "bytes"),
+		gauge("ceph_write_bytes_per_second", pgmap.WriteBytesSec, labels, "Client write throughput", "bytes"),
+		gauge("ceph_read_ops_per_second", pgmap.ReadOpPerSec, labels, "Client read operations per second", ""),
+		gauge("ceph_write_ops_per_second", pgmap.WriteOpPerSec, labels, "Client write operations per second", ""),
+	)
+	if pgmap.BytesTotal > 0 {
+		metrics = append(metrics, gauge("ceph_used_ratio", pgmap.BytesUsed/pgmap.BytesTotal, labels,
+			"Fraction of raw capacity used", ""))
+	}
+
+	return metrics, nil
+}
+
+// status runs ceph status
+func (cc *CephCollector) status(ctx context.Context) (*cephStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, cc.config.Timeout)
+	defer cancel()
+
+	args := []string{"--cluster", cc.config.Cluster}
+	if cc.config.ConfigFile != "" {
+		args = append(args, "--conf", cc.config.ConfigFile)
+	}
+	if cc.config.User != "" {
+		args = append(args, "--id", cc.config.User)
+	}
+	// Without a connect timeout the command waits for monitors forever
+	args = append(args, "--connect-timeout", fmt.Sprintf("%d", int(cc.config.Timeout.Seconds())),
+		"status", "--format", "json")
+
+	cmd := exec.CommandContext(ctx, "ceph", args...)
+	cmd.Env = append(os.Environ(), "LANG=C", "LC_ALL=C")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ceph status failed: %w", err)
+	}
+
+	var status cephStatus
+	if err := json.Unmarshal(out, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse ceph status: %w", err)
+	}
+	return &status, nil
+}
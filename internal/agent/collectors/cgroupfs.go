@@ -0,0 +1,314 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// containerCgroupPattern matches the cgroup directory of a container:
+// docker-<id>.scope, cri-containerd-<id>.scope, crio-<id>.scope,
+// libpod-<id>.scope or a bare <id> under the cgroupfs driver
+var containerCgroupPattern = regexp.MustCompile(`^(?:[a-z-]+-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// cgroupV1Unlimited is the smallest memory.limit_in_bytes treated as no
+// limit; the kernel reports the page-aligned maximum int64
+const cgroupV1Unlimited = 1 << 62
+
+// OCI annotations CRI-O sets on Kubernetes containers
+const (
+	crioContainerTypeAnnotation = "io.kubernetes.cri-o.ContainerType"
+	crioImageNameAnnotation     = "io.kubernetes.cri-o.ImageName"
+	k8sContainerNameAnnotation  = "io.kubernetes.container.name"
+)
+
+// cgroupfsRuntime finds containers by walking the cgroup hierarchy and
+// reads their usage from cgroupfs, for hosts where the agent cannot reach
+// a runtime socket. Names, images and labels come from the state
+// directories of Docker, containerd and CRI-O when they are readable.
+type cgroupfsRuntime struct {
+	root     string
+	procPath string
+	// v2 is set on the unified hierarchy; v1 walks the memory controller
+	v2 bool
+
+	dockerRoot        string
+	containerdTaskDir string
+	storageRoot       string
+}
+
+func newCgroupfsRuntime(config ContainerCollectorConfig, procPath string) *cgroupfsRuntime {
+	_, err := os.Stat(filepath.Join(config.CgroupRoot, "cgroup.controllers"))
+	return &cgroupfsRuntime{
+		root:              config.CgroupRoot,
+		procPath:          procPath,
+		v2:                err == nil,
+		dockerRoot:        config.DockerRoot,
+		containerdTaskDir: filepath.Join(filepath.Dir(config.ContainerdSocket), "io.containerd.runtime.v2.task"),
+		storageRoot:       config.ContainersStorage,
+	}
+}
+
+func (c *cgroupfsRuntime) name() string {
+	return RuntimeCgroupfs
+}
+
+// hierarchy returns the directory walked to find containers
+func (c *cgroupfsRuntime) hierarchy() string {
+	if c.v2 {
+		return c.root
+	}
+	return filepath.Join(c.root, "memory")
+}
+
+func (c *cgroupfsRuntime) list(ctx context.Context) ([]*containerInfo, error) {
+	var containers []*containerInfo
+	err := c.walk(func(id, rel string) {
+		info := &containerInfo{id: id, name: id[:12], cgroup: rel, restarts: -1}
+		if c.describe(info) {
+			containers = append(containers, info)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// walk calls fn with the ID and cgroup path of every container cgroup
+func (c *cgroupfsRuntime) walk(fn func(id, rel string)) error {
+	base := c.hierarchy()
+	return filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		// crio-conmon-<id>.scope holds the monitor, not the container
+		m := containerCgroupPattern.FindStringSubmatch(d.Name())
+		if m == nil || strings.Contains(d.Name(), "conmon") {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(base, path)
+		fn(m[1], rel)
+		// Processes the container started have their own cgroups below
+		return filepath.SkipDir
+	})
+}
+
+// describe fills in a container's metadata from the runtime state
+// directories and reports whether it is a workload container rather than
+// a pod sandbox or a stopped container
+func (c *cgroupfsRuntime) describe(info *containerInfo) bool {
+	// Docker
+	if data, err := os.ReadFile(filepath.Join(c.dockerRoot, "containers", info.id, "config.v2.json")); err == nil {
+		var config struct {
+			Name   string `json:"Name"`
+			Config struct {
+				Image  string            `json:"Image"`
+				Labels map[string]string `json:"Labels"`
+			} `json:"Config"`
+			State struct {
+				Running bool `json:"Running"`
+				Health  *struct {
+					Status string `json:"Status"`
+				} `json:"Health"`
+			} `json:"State"`
+			RestartCount int `json:"RestartCount"`
+		}
+		if json.Unmarshal(data, &config) == nil {
+			info.name = strings.TrimPrefix(config.Name, "/")
+			info.image = config.Config.Image
+			info.labels = config.Config.Labels
+			info.restarts = config.RestartCount
+			if config.State.Health != nil {
+				info.health = config.State.Health.Status
+			}
+			return config.State.Running
+		}
+	}
+
+	// containerd task bundles, in any namespace
+	if bundles, _ := filepath.Glob(filepath.Join(c.containerdTaskDir, "*", info.id, "config.json")); len(bundles) > 0 {
+		var spec ociSpec
+		if data, err := os.ReadFile(bundles[0]); err == nil && json.Unmarshal(data, &spec) == nil {
+			if spec.Annotations[criContainerTypeAnnotation] == "sandbox" {
+				return false
+			}
+			info.name = firstNonEmpty(spec.Annotations[criContainerNameAnnotation], spec.Annotations[nerdctlNameLabel], info.name)
+			info.image = spec.Annotations[criImageNameAnnotation]
+			info.labels = spec.Annotations
+			return true
+		}
+	}
+
+	// CRI-O and Podman share containers/storage
+	if data, err := os.ReadFile(filepath.Join(c.storageRoot, "overlay-containers", info.id, "userdata", "config.json")); err == nil {
+		var spec ociSpec
+		if json.Unmarshal(data, &spec) == nil {
+			if spec.Annotations[crioContainerTypeAnnotation] == "sandbox" {
+				return false
+			}
+			info.name = firstNonEmpty(spec.Annotations[k8sContainerNameAnnotation], info.name)
+			info.image = spec.Annotations[crioImageNameAnnotation]
+			info.labels = spec.Annotations
+			return true
+		}
+	}
+
+	// Unknown runtime: still report usage under the short ID
+	return true
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c *cgroupfsRuntime) stats(ctx context.Context, info *containerInfo) (*containerStats, error) {
+	return c.cgroupStats(info)
+}
+
+// cgroupStats reads the usage of a container whose cgroup path is known
+func (c *cgroupfsRuntime) cgroupStats(info *containerInfo) (*containerStats, error) {
+	var (
+		stats *containerStats
+		err   error
+	)
+	if c.v2 {
+		stats, err = readCgroupV2Stats(filepath.Join(c.root, info.cgroup))
+	} else {
+		stats, err = readCgroupV1Stats(c.root, info.cgroup)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stats.restarts = info.restarts
+	stats.health = info.health
+
+	// Network counters live in the container's network namespace, reached
+	// through any of its processes
+	if pid := firstCgroupProc(filepath.Join(c.hierarchy(), info.cgroup)); pid != "" {
+		stats.networks, _ = readProcNetDev(filepath.Join(c.procPath, pid, "net", "dev"))
+	}
+
+	return stats, nil
+}
+
+// readCgroupV2Stats reads a container's usage from its cgroup v2 directory
+func readCgroupV2Stats(dir string) (*containerStats, error) {
+	cpu, err := readKeyValueFile(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	stats := &containerStats{
+		cpuSeconds:          float64(cpu["usage_usec"]) / 1e6,
+		cpuThrottledSeconds: float64(cpu["throttled_usec"]) / 1e6,
+		restarts:            -1,
+	}
+
+	if usage, err := readUintFile(filepath.Join(dir, "memory.current")); err == nil {
+		memStat, _ := readKeyValueFile(filepath.Join(dir, "memory.stat"))
+		stats.memoryUsage = workingSet(usage, memStat)
+	}
+	// memory.max is "max" without a limit, which leaves the limit unset
+	if data, err := os.ReadFile(filepath.Join(dir, "memory.max")); err == nil {
+		stats.memoryLimit, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+	stats.blkioRead, stats.blkioWrite, _ = readIOStat(filepath.Join(dir, "io.stat"))
+	stats.pids, _ = readUintFile(filepath.Join(dir, "pids.current"))
+
+	return stats, nil
+}
+
+// readCgroupV1Stats reads a container's usage from the per-controller
+// cgroup v1 hierarchies under root
+func readCgroupV1Stats(root, rel string) (*containerStats, error) {
+	usage, err := readUintFile(filepath.Join(root, "cpuacct", rel, "cpuacct.usage"))
+	if err != nil {
+		return nil, err
+	}
+	stats := &containerStats{
+		cpuSeconds: float64(usage) / 1e9,
+		restarts:   -1,
+	}
+
+	if cpu, err := readKeyValueFile(filepath.Join(root, "cpu", rel, "cpu.stat")); err == nil {
+		stats.cpuThrottledSeconds = float64(cpu["throttled_time"]) / 1e9
+	}
+	if usage, err := readUintFile(filepath.Join(root, "memory", rel, "memory.usage_in_bytes")); err == nil {
+		memStat, _ := readKeyValueFile(filepath.Join(root, "memory", rel, "memory.stat"))
+		stats.memoryUsage = workingSet(usage, memStat)
+	}
+	if limit, err := readUintFile(filepath.Join(root, "memory", rel, "memory.limit_in_bytes")); err == nil && limit < cgroupV1Unlimited {
+		stats.memoryLimit = limit
+	}
+	stats.blkioRead, stats.blkioWrite, _ = readBlkioServiceBytes(filepath.Join(root, "blkio", rel, "blkio.throttle.io_service_bytes_recursive"))
+	stats.pids, _ = readUintFile(filepath.Join(root, "pids", rel, "pids.current"))
+
+	return stats, nil
+}
+
+// readBlkioServiceBytes sums the Read and Write lines of a cgroup v1
+// blkio service bytes file ("8:0 Read 4096")
+func readBlkioServiceBytes(path string) (uint64, uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var read, write uint64
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			read += parseUint(fields[2])
+		case "Write":
+			write += parseUint(fields[2])
+		}
+	}
+	return read, write, nil
+}
+
+// firstCgroupProc returns the first process ID in a cgroup
+func firstCgroupProc(dir string) string {
+	f, err := os.Open(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if scanner.Scan() {
+		return strings.TrimSpace(scanner.Text())
+	}
+	return ""
+}
+
+// cgroupV2Path returns the unified hierarchy path of a process from its
+// /proc/<pid>/cgroup file
+func cgroupV2Path(procPath string, pid int) (string, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if rel, ok := strings.CutPrefix(line, "0::"); ok {
+			return rel, nil
+		}
+	}
+	return "", fmt.Errorf("process %d is not in a cgroup v2 hierarchy", pid)
+}
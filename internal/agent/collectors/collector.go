@@ -20,6 +20,13 @@ type Collector interface {
 	Name() string
 }
 
+// InventoryProvider is implemented by collectors that report node inventory
+// details (installed packages, kernel versions, ...) in addition to metrics
+type InventoryProvider interface {
+	// Inventory returns the latest inventory key/value pairs
+	Inventory() map[string]string
+}
+
 // Metric represents a collected metric
 type Metric struct {
 	Name      string
@@ -29,6 +36,7 @@ type Metric struct {
 	Type      MetricType
 	Help      string
 	Unit      string
+	Priority  Priority
 }
 
 // MetricType represents the type of metric
@@ -41,6 +49,16 @@ const (
 	MetricTypeSummary
 )
 
+// Priority tells the server which metrics to shed first when it is
+// overloaded. The values match the protocol's.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityCritical
+	PriorityLow
+)
+
 // BaseCollector provides common functionality for collectors
 type BaseCollector struct {
 	name     string
@@ -2,30 +2,395 @@ package collectors
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-// ContainerCollector collects container metrics
+// Container runtimes
+const (
+	RuntimeAuto       = "auto"
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+	RuntimeCRI        = "cri"
+	RuntimeContainerd = "containerd"
+	RuntimeCgroupfs   = "cgroupfs"
+	RuntimeHCS        = "hcs"
+)
+
+// containerStatsWorkers bounds concurrent per-container stats requests
+const containerStatsWorkers = 8
+
+// Labels set by Docker Compose
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+var labelNameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// containerRuntime lists the running containers of one runtime and reads
+// their resource usage
+type containerRuntime interface {
+	name() string
+	list(ctx context.Context) ([]*containerInfo, error)
+	stats(ctx context.Context, c *containerInfo) (*containerStats, error)
+}
+
+// containerInfo describes a running container
+type containerInfo struct {
+	id     string
+	name   string
+	image  string
+	labels map[string]string
+	// pid is the container's init process, when the runtime reports it
+	pid int
+	// cgroup is the container's path below the hierarchy root, and
+	// restarts and health its state, for runtimes that only know them
+	// when listing
+	cgroup   string
+	restarts int
+	health   string
+	// source is the index of the socket that listed the container, for
+	// runtimes that read several
+	source int
+	// usage is the container's usage, for runtimes that report all
+	// containers' usage in one call while listing
+	usage *containerStats
+}
+
+// containerStats is a runtime-independent snapshot of a container's usage.
+// Counters are cumulative since the container started.
+type containerStats struct {
+	cpuSeconds          float64
+	cpuThrottledSeconds float64
+	// memoryUsage is the working set: usage minus inactive page cache
+	memoryUsage uint64
+	memoryLimit uint64
+	networks    map[string]*containerNetwork
+	blkioRead   uint64
+	blkioWrite  uint64
+	pids        uint64
+	// restarts is -1 when the runtime does not track restarts
+	restarts int
+	// health is healthy, unhealthy or starting, or empty without a
+	// health check
+	health string
+	// partial is set when only CPU and memory usage are known
+	partial bool
+	// cgroupless is set on Windows, which has no CPU throttling or task
+	// counts
+	cgroupless bool
+}
+
+type containerNetwork struct {
+	rxBytes, txBytes   uint64
+	rxErrors, txErrors uint64
+}
+
+// ContainerCollector collects per-container CPU, memory, network, block IO,
+// restart and health metrics from the container runtime
 type ContainerCollector struct {
 	*BaseCollector
-	runtime string
+	runtime containerRuntime
+	include []string
+	exclude []string
 }
 
 // ContainerCollectorConfig holds configuration
 type ContainerCollectorConfig struct {
-	Enabled bool
-	Runtime string
+	Enabled          bool
+	Interval         time.Duration
+	Runtime          string
+	DockerSocket     string
+	PodmanSocket     string
+	CRISocket        string
+	ContainerdSocket string
+	// CgroupRoot, DockerRoot and ContainersStorage are read by the
+	// cgroupfs runtime, which needs no socket
+	CgroupRoot        string
+	DockerRoot        string
+	ContainersStorage string
+	// LabelInclude and LabelExclude select container labels, by glob,
+	// added to metrics as container_label_<name>
+	LabelInclude []string
+	LabelExclude []string
 }
 
-// NewContainerCollector creates a new container collector
+// NewContainerCollector creates a new container collector. With the auto
+// runtime, Windows uses the Host Compute Service, and elsewhere the first
+// runtime whose socket exists is used, in the order Docker, Podman, CRI,
+// containerd, then reading cgroupfs directly.
 func NewContainerCollector(config ContainerCollectorConfig) (*ContainerCollector, error) {
+	rt := config.Runtime
+	if rt == "" || rt == RuntimeAuto {
+		rt = detectContainerRuntime(config)
+	}
+
+	var runtime containerRuntime
+	switch rt {
+	case RuntimeDocker:
+		runtime = newDockerRuntime(config.DockerSocket)
+	case RuntimePodman:
+		sockets := podmanSockets(config.PodmanSocket)
+		if len(sockets) == 0 {
+			return nil, fmt.Errorf("no Podman socket found")
+		}
+		runtime = newPodmanRuntime(sockets)
+	case RuntimeCRI:
+		cri, err := newCRIRuntime(config, "/proc")
+		if err != nil {
+			return nil, err
+		}
+		runtime = cri
+	case RuntimeContainerd:
+		runtime = newContainerdRuntime(config.ContainerdSocket, config.CgroupRoot, "/proc")
+	case RuntimeCgroupfs:
+		runtime = newCgroupfsRuntime(config, "/proc")
+	case RuntimeHCS:
+		hcs, err := newHCSRuntime()
+		if err != nil {
+			return nil, err
+		}
+		runtime = hcs
+	default:
+		return nil, fmt.Errorf("unsupported container runtime: %s", rt)
+	}
+
+	for _, pattern := range append(config.LabelInclude, config.LabelExclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid label pattern %q: %w", pattern, err)
+		}
+	}
+
 	return &ContainerCollector{
-		BaseCollector: NewBaseCollector("container", config.Enabled, 2*time.Second),
-		runtime:       config.Runtime,
+		BaseCollector: NewBaseCollector("container", config.Enabled, config.Interval),
+		runtime:       runtime,
+		include:       config.LabelInclude,
+		exclude:       config.LabelExclude,
 	}, nil
 }
 
+func detectContainerRuntime(config ContainerCollectorConfig) string {
+	if hcsAvailable {
+		return RuntimeHCS
+	}
+	if _, err := os.Stat(config.DockerSocket); err == nil {
+		return RuntimeDocker
+	}
+	for _, socket := range podmanSockets(config.PodmanSocket) {
+		if _, err := os.Stat(socket); err == nil {
+			return RuntimePodman
+		}
+	}
+	if _, err := os.Stat(config.CRISocket); err == nil {
+		return RuntimeCRI
+	}
+	if _, err := os.Stat(config.ContainerdSocket); err == nil {
+		return RuntimeContainerd
+	}
+	return RuntimeCgroupfs
+}
+
 // Collect collects container metrics
 func (cc *ContainerCollector) Collect(ctx context.Context) ([]*Metric, error) {
-	return make([]*Metric, 0), nil
+	containers, err := cc.runtime.list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s containers: %w", cc.runtime.name(), err)
+	}
+
+	// Stats requests are slow on busy hosts, so read them concurrently
+	results := make([]*containerStats, len(containers))
+	sem := make(chan struct{}, containerStatsWorkers)
+	var wg sync.WaitGroup
+	for i, c := range containers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, c *containerInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// Containers that stop between list and stats are skipped
+			if stats, err := cc.runtime.stats(ctx, c); err == nil {
+				results[i] = stats
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	metrics := []*Metric{{
+		Name:   "container_running",
+		Value:  float64(len(containers)),
+		Labels: map[string]string{"runtime": cc.runtime.name()},
+		Type:   MetricTypeGauge,
+		Help:   "Number of running containers",
+	}}
+	for i, c := range containers {
+		if results[i] != nil {
+			metrics = append(metrics, cc.containerMetrics(c, results[i])...)
+		}
+	}
+
+	return metrics, nil
+}
+
+func (cc *ContainerCollector) containerMetrics(c *containerInfo, s *containerStats) []*Metric {
+	labels := cc.containerLabels(c)
+	metric := func(name string, value float64, typ MetricType, help, unit string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: labels, Type: typ, Help: help, Unit: unit}
+	}
+
+	metrics := []*Metric{
+		metric("container_cpu_usage_seconds_total", s.cpuSeconds, MetricTypeCounter,
+			"Total CPU time consumed by the container", "seconds"),
+		metric("container_memory_usage_bytes", float64(s.memoryUsage), MetricTypeGauge,
+			"Working set memory of the container", "bytes"),
+	}
+	if s.restarts >= 0 {
+		metrics = append(metrics, metric("container_restarts_total", float64(s.restarts), MetricTypeCounter,
+			"Number of times the runtime restarted the container", ""))
+	}
+	if s.partial {
+		return metrics
+	}
+
+	metrics = append(metrics,
+		metric("container_blkio_read_bytes_total", float64(s.blkioRead), MetricTypeCounter,
+			"Total bytes read from block devices", "bytes"),
+		metric("container_blkio_write_bytes_total", float64(s.blkioWrite), MetricTypeCounter,
+			"Total bytes written to block devices", "bytes"),
+	)
+	if !s.cgroupless {
+		metrics = append(metrics,
+			metric("container_cpu_throttled_seconds_total", s.cpuThrottledSeconds, MetricTypeCounter,
+				"Total time the container was throttled by its CPU limit", "seconds"),
+			metric("container_pids", float64(s.pids), MetricTypeGauge,
+				"Number of tasks in the container", ""),
+		)
+	}
+	if s.memoryLimit > 0 {
+		metrics = append(metrics, metric("container_memory_limit_bytes", float64(s.memoryLimit), MetricTypeGauge,
+			"Memory limit of the container", "bytes"))
+	}
+	if s.health != "" {
+		for _, status := range []string{"healthy", "unhealthy", "starting"} {
+			m := metric("container_health_status", boolToFloat(s.health == status), MetricTypeGauge,
+				"Health check status of the container, 1 for the current status", "")
+			m.Labels = withLabel(labels, "status", status)
+			metrics = append(metrics, m)
+		}
+	}
+
+	ifaces := make([]string, 0, len(s.networks))
+	for iface := range s.networks {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Strings(ifaces)
+	for _, iface := range ifaces {
+		n := s.networks[iface]
+		ifLabels := withLabel(labels, "interface", iface)
+		for _, m := range []*Metric{
+			metric("container_network_receive_bytes_total", float64(n.rxBytes), MetricTypeCounter,
+				"Total bytes received by the container", "bytes"),
+			metric("container_network_transmit_bytes_total", float64(n.txBytes), MetricTypeCounter,
+				"Total bytes transmitted by the container", "bytes"),
+			metric("container_network_receive_errors_total", float64(n.rxErrors), MetricTypeCounter,
+				"Total receive errors of the container", ""),
+			metric("container_network_transmit_errors_total", float64(n.txErrors), MetricTypeCounter,
+				"Total transmit errors of the container", ""),
+		} {
+			m.Labels = ifLabels
+			metrics = append(metrics, m)
+		}
+	}
+
+	return metrics
+}
+
+// containerLabels returns the identifying labels of a container and the
+// container labels selected for pass-through
+func (cc *ContainerCollector) containerLabels(c *containerInfo) map[string]string {
+	labels := map[string]string{
+		"container_id":   c.id,
+		"container_name": c.name,
+	}
+	if c.image != "" {
+		labels["image"] = c.image
+	}
+	if project := c.labels[composeProjectLabel]; project != "" {
+		labels["compose_project"] = project
+	}
+	if service := c.labels[composeServiceLabel]; service != "" {
+		labels["compose_service"] = service
+	}
+	// CRI containers carry their pod, which DaemonSet mode would otherwise
+	// look up from the kubelet
+	if pod := c.labels[k8sPodNameLabel]; pod != "" {
+		labels["k8s_pod"] = pod
+		labels["k8s_namespace"] = c.labels[k8sPodNamespaceLabel]
+	}
+
+	for key, value := range c.labels {
+		if matchAny(cc.include, key) && !matchAny(cc.exclude, key) {
+			labels["container_label_"+labelNameSanitizer.ReplaceAllString(key, "_")] = value
+		}
+	}
+	return labels
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+// readProcNetDev reads per-interface counters from a /proc/<pid>/net/dev
+// file, skipping the loopback interface
+func readProcNetDev(file string) (map[string]*containerNetwork, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	networks := make(map[string]*containerNetwork)
+	for _, line := range strings.Split(string(data), "\n") {
+		iface, counters, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		iface = strings.TrimSpace(iface)
+		fields := strings.Fields(counters)
+		if iface == "lo" || len(fields) < 11 {
+			continue
+		}
+		networks[iface] = &containerNetwork{
+			rxBytes:  parseUint(fields[0]),
+			rxErrors: parseUint(fields[2]),
+			txBytes:  parseUint(fields[8]),
+			txErrors: parseUint(fields[10]),
+		}
+	}
+	return networks, nil
+}
+
+func parseUint(s string) uint64 {
+	v, _ := strconv.ParseUint(s, 10, 64)
+	return v
 }
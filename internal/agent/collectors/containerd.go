@@ -0,0 +1,105 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// OCI annotations containerd's CRI plugin sets on Kubernetes containers
+const (
+	criContainerTypeAnnotation = "io.kubernetes.cri.container-type"
+	criContainerNameAnnotation = "io.kubernetes.cri.container-name"
+	criImageNameAnnotation     = "io.kubernetes.cri.image-name"
+	nerdctlNameLabel           = "nerdctl/name"
+)
+
+// containerdRuntime reads running tasks from containerd's v2 runtime state
+// directory next to its socket, and their usage from cgroup v2 and the
+// init process's network namespace. It needs the host's /proc, so an
+// agent in a container runs with hostPID.
+type containerdRuntime struct {
+	taskDir    string
+	cgroupRoot string
+	procPath   string
+}
+
+func newContainerdRuntime(socket, cgroupRoot, procPath string) *containerdRuntime {
+	return &containerdRuntime{
+		taskDir:    filepath.Join(filepath.Dir(socket), "io.containerd.runtime.v2.task"),
+		cgroupRoot: cgroupRoot,
+		procPath:   procPath,
+	}
+}
+
+func (c *containerdRuntime) name() string {
+	return RuntimeContainerd
+}
+
+// ociSpec is the subset of a task's OCI bundle config.json the collector
+// uses
+type ociSpec struct {
+	Annotations map[string]string `json:"annotations"`
+}
+
+func (c *containerdRuntime) list(ctx context.Context) ([]*containerInfo, error) {
+	// Task bundles are grouped by containerd namespace: k8s.io, moby, ...
+	bundles, err := filepath.Glob(filepath.Join(c.taskDir, "*", "*", "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []*containerInfo
+	for _, config := range bundles {
+		dir := filepath.Dir(config)
+		pid, err := readUintFile(filepath.Join(dir, "init.pid"))
+		if err != nil {
+			// Created but not started
+			continue
+		}
+
+		var spec ociSpec
+		data, err := os.ReadFile(config)
+		if err != nil || json.Unmarshal(data, &spec) != nil {
+			continue
+		}
+		// Pod sandboxes only hold the pause process
+		if spec.Annotations[criContainerTypeAnnotation] == "sandbox" {
+			continue
+		}
+
+		id := filepath.Base(dir)
+		name := spec.Annotations[criContainerNameAnnotation]
+		if name == "" {
+			name = spec.Annotations[nerdctlNameLabel]
+		}
+		if name == "" {
+			name = id[:min(12, len(id))]
+		}
+
+		containers = append(containers, &containerInfo{
+			id:     id,
+			name:   name,
+			image:  spec.Annotations[criImageNameAnnotation],
+			labels: spec.Annotations,
+			pid:    int(pid),
+		})
+	}
+	return containers, nil
+}
+
+func (c *containerdRuntime) stats(ctx context.Context, info *containerInfo) (*containerStats, error) {
+	rel, err := cgroupV2Path(c.procPath, info.pid)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := readCgroupV2Stats(filepath.Join(c.cgroupRoot, rel))
+	if err != nil {
+		return nil, err
+	}
+	stats.networks, _ = readProcNetDev(filepath.Join(c.procPath, strconv.Itoa(info.pid), "net", "dev"))
+
+	return stats, nil
+}
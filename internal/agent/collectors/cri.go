@@ -0,0 +1,300 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// CRI v1 RuntimeService methods
+const (
+	criListContainers     = "/runtime.v1.RuntimeService/ListContainers"
+	criListContainerStats = "/runtime.v1.RuntimeService/ListContainerStats"
+)
+
+// criContainerRunning is CONTAINER_RUNNING of the CRI ContainerState enum
+const criContainerRunning = 1
+
+// Kubernetes labels the kubelet sets on CRI containers
+const (
+	k8sPodNameLabel      = "io.kubernetes.pod.name"
+	k8sPodNamespaceLabel = "io.kubernetes.pod.namespace"
+)
+
+// criRuntime reads containers from a Container Runtime Interface socket,
+// such as CRI-O's or containerd's CRI plugin. The CRI only reports CPU and
+// memory usage, so the rest is read from cgroupfs when the container's
+// cgroup is visible to the agent.
+type criRuntime struct {
+	conn    *grpc.ClientConn
+	cgroups *cgroupfsRuntime
+}
+
+func newCRIRuntime(config ContainerCollectorConfig, procPath string) (*criRuntime, error) {
+	// The CRI API is protobuf over gRPC; messages are encoded by hand to
+	// avoid depending on the kubelet's generated types
+	conn, err := grpc.Dial("unix://"+config.CRISocket,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to CRI socket %s: %w", config.CRISocket, err)
+	}
+	return &criRuntime{
+		conn:    conn,
+		cgroups: newCgroupfsRuntime(config, procPath),
+	}, nil
+}
+
+func (c *criRuntime) name() string {
+	return RuntimeCRI
+}
+
+func (c *criRuntime) list(ctx context.Context) ([]*containerInfo, error) {
+	// ListContainersRequest{filter: {state: {state: CONTAINER_RUNNING}}}
+	state := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), criContainerRunning)
+	filter := protowire.AppendBytes(protowire.AppendTag(nil, 2, protowire.BytesType), state)
+	req := protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), filter)
+
+	var resp []byte
+	if err := c.conn.Invoke(ctx, criListContainers, &req, &resp); err != nil {
+		return nil, err
+	}
+
+	var containers []*containerInfo
+	err := walkProto(resp, func(num protowire.Number, _ uint64, b []byte) error {
+		if num != 1 {
+			return nil
+		}
+		info, err := parseCRIContainer(b)
+		if err != nil {
+			return err
+		}
+		containers = append(containers, info)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ListContainers response: %w", err)
+	}
+	if len(containers) == 0 {
+		return nil, nil
+	}
+
+	// Usage of all containers comes in one call, ahead of stats
+	usage, err := c.listStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cgroups := make(map[string]string)
+	_ = c.cgroups.walk(func(id, rel string) { cgroups[id] = rel })
+
+	for _, info := range containers {
+		info.usage = usage[info.id]
+		info.cgroup = cgroups[info.id]
+	}
+	return containers, nil
+}
+
+func (c *criRuntime) stats(ctx context.Context, info *containerInfo) (*containerStats, error) {
+	if info.cgroup != "" {
+		if stats, err := c.cgroups.cgroupStats(info); err == nil {
+			return stats, nil
+		}
+	}
+	if info.usage == nil {
+		return nil, fmt.Errorf("no stats for container %s", info.id)
+	}
+	stats := *info.usage
+	stats.restarts = info.restarts
+	return &stats, nil
+}
+
+// listStats reads the CPU and memory usage the runtime reports for all
+// containers, by ID
+func (c *criRuntime) listStats(ctx context.Context) (map[string]*containerStats, error) {
+	req := []byte{}
+	var resp []byte
+	if err := c.conn.Invoke(ctx, criListContainerStats, &req, &resp); err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]*containerStats)
+	err := walkProto(resp, func(num protowire.Number, _ uint64, b []byte) error {
+		if num != 1 {
+			return nil
+		}
+		id, stats, err := parseCRIContainerStats(b)
+		if err != nil {
+			return err
+		}
+		usage[id] = stats
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ListContainerStats response: %w", err)
+	}
+	return usage, nil
+}
+
+// parseCRIContainer decodes a runtime.v1.Container
+func parseCRIContainer(b []byte) (*containerInfo, error) {
+	info := &containerInfo{labels: make(map[string]string)}
+	err := walkProto(b, func(num protowire.Number, _ uint64, v []byte) error {
+		switch num {
+		case 1: // id
+			info.id = string(v)
+		case 3: // metadata
+			return walkProto(v, func(num protowire.Number, u uint64, v []byte) error {
+				switch num {
+				case 1: // name
+					info.name = string(v)
+				case 2: // attempt
+					info.restarts = int(u)
+				}
+				return nil
+			})
+		case 4: // image
+			return walkProto(v, func(num protowire.Number, _ uint64, v []byte) error {
+				if num == 1 {
+					info.image = string(v)
+				}
+				return nil
+			})
+		case 8: // labels
+			return parseProtoMapEntry(v, info.labels)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info.name == "" {
+		info.name = info.id[:min(12, len(info.id))]
+	}
+	return info, nil
+}
+
+// parseCRIContainerStats decodes a runtime.v1.ContainerStats into the CPU
+// and memory usage it holds
+func parseCRIContainerStats(b []byte) (string, *containerStats, error) {
+	var id string
+	stats := &containerStats{restarts: -1, partial: true}
+	err := walkProto(b, func(num protowire.Number, _ uint64, v []byte) error {
+		switch num {
+		case 1: // attributes
+			return walkProto(v, func(num protowire.Number, _ uint64, v []byte) error {
+				if num == 1 {
+					id = string(v)
+				}
+				return nil
+			})
+		case 2: // cpu
+			return walkProto(v, func(num protowire.Number, _ uint64, v []byte) error {
+				if num == 2 { // usage_core_nano_seconds
+					ns, err := parseUInt64Value(v)
+					stats.cpuSeconds = float64(ns) / 1e9
+					return err
+				}
+				return nil
+			})
+		case 3: // memory
+			return walkProto(v, func(num protowire.Number, _ uint64, v []byte) error {
+				if num == 2 { // working_set_bytes
+					var err error
+					stats.memoryUsage, err = parseUInt64Value(v)
+					return err
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return id, stats, err
+}
+
+// parseUInt64Value decodes a runtime.v1.UInt64Value wrapper
+func parseUInt64Value(b []byte) (uint64, error) {
+	var value uint64
+	err := walkProto(b, func(num protowire.Number, u uint64, _ []byte) error {
+		if num == 1 {
+			value = u
+		}
+		return nil
+	})
+	return value, err
+}
+
+// parseProtoMapEntry decodes one entry of a map<string, string> field
+func parseProtoMapEntry(b []byte, m map[string]string) error {
+	var key, value string
+	err := walkProto(b, func(num protowire.Number, _ uint64, v []byte) error {
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			value = string(v)
+		}
+		return nil
+	})
+	m[key] = value
+	return err
+}
+
+// walkProto calls fn for each field of a protobuf message, with varints in
+// u and length-delimited fields in b. Other wire types are skipped.
+func walkProto(msg []byte, fn func(num protowire.Number, u uint64, b []byte) error) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			u, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			msg = msg[n:]
+			if err := fn(num, u, nil); err != nil {
+				return err
+			}
+		case protowire.BytesType:
+			b, n := protowire.ConsumeBytes(msg)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			msg = msg[n:]
+			if err := fn(num, 0, b); err != nil {
+				return err
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, msg)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			msg = msg[n:]
+		}
+	}
+	return nil
+}
+
+// rawCodec passes pre-encoded protobuf messages through gRPC
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
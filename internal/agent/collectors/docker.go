@@ -0,0 +1,191 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dockerRuntime reads containers from the Docker Engine API over its unix
+// socket
+type dockerRuntime struct {
+	client *http.Client
+	// runtime names the engine, as Podman serves the same API
+	runtime string
+}
+
+func newDockerRuntime(socket string) *dockerRuntime {
+	return &dockerRuntime{
+		runtime: RuntimeDocker,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+				MaxIdleConnsPerHost: containerStatsWorkers,
+			},
+		},
+	}
+}
+
+func (d *dockerRuntime) name() string {
+	return d.runtime
+}
+
+// dockerContainer is an entry of GET /containers/json
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// dockerInspect is the subset of GET /containers/{id}/json the collector
+// uses
+type dockerInspect struct {
+	RestartCount int `json:"RestartCount"`
+	State        struct {
+		Health *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+}
+
+// dockerStats is the subset of GET /containers/{id}/stats the collector
+// uses. CPU and throttling times are in nanoseconds.
+type dockerStats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+		ThrottlingData struct {
+			ThrottledTime uint64 `json:"throttled_time"`
+		} `json:"throttling_data"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	BlkioStats struct {
+		IOServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+	PidsStats struct {
+		Current uint64 `json:"current"`
+	} `json:"pids_stats"`
+	Networks map[string]struct {
+		RxBytes  uint64 `json:"rx_bytes"`
+		RxErrors uint64 `json:"rx_errors"`
+		TxBytes  uint64 `json:"tx_bytes"`
+		TxErrors uint64 `json:"tx_errors"`
+	} `json:"networks"`
+}
+
+func (d *dockerRuntime) list(ctx context.Context) ([]*containerInfo, error) {
+	var entries []dockerContainer
+	if err := d.get(ctx, "/containers/json", &entries); err != nil {
+		return nil, err
+	}
+
+	containers := make([]*containerInfo, 0, len(entries))
+	for _, e := range entries {
+		name := e.ID
+		if len(e.Names) > 0 {
+			name = strings.TrimPrefix(e.Names[0], "/")
+		}
+		containers = append(containers, &containerInfo{
+			id:     e.ID,
+			name:   name,
+			image:  e.Image,
+			labels: e.Labels,
+		})
+	}
+	return containers, nil
+}
+
+func (d *dockerRuntime) stats(ctx context.Context, c *containerInfo) (*containerStats, error) {
+	var inspect dockerInspect
+	if err := d.get(ctx, "/containers/"+c.id+"/json", &inspect); err != nil {
+		return nil, err
+	}
+	// one-shot skips the second sample Docker takes to fill precpu_stats,
+	// which the collector does not use
+	var raw dockerStats
+	if err := d.get(ctx, "/containers/"+c.id+"/stats?stream=false&one-shot=true", &raw); err != nil {
+		return nil, err
+	}
+
+	stats := &containerStats{
+		cpuSeconds:          float64(raw.CPUStats.CPUUsage.TotalUsage) / 1e9,
+		cpuThrottledSeconds: float64(raw.CPUStats.ThrottlingData.ThrottledTime) / 1e9,
+		memoryUsage:         workingSet(raw.MemoryStats.Usage, raw.MemoryStats.Stats),
+		memoryLimit:         raw.MemoryStats.Limit,
+		pids:                raw.PidsStats.Current,
+		restarts:            inspect.RestartCount,
+		networks:            make(map[string]*containerNetwork, len(raw.Networks)),
+	}
+	if inspect.State.Health != nil {
+		stats.health = inspect.State.Health.Status
+	}
+	for _, entry := range raw.BlkioStats.IOServiceBytesRecursive {
+		// cgroup v1 reports Read/Write, cgroup v2 read/write
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.blkioRead += entry.Value
+		case "write":
+			stats.blkioWrite += entry.Value
+		}
+	}
+	for iface, n := range raw.Networks {
+		stats.networks[iface] = &containerNetwork{
+			rxBytes:  n.RxBytes,
+			txBytes:  n.TxBytes,
+			rxErrors: n.RxErrors,
+			txErrors: n.TxErrors,
+		}
+	}
+
+	return stats, nil
+}
+
+// workingSet subtracts inactive page cache from memory usage the way
+// docker stats does
+func workingSet(usage uint64, stats map[string]uint64) uint64 {
+	inactive, ok := stats["total_inactive_file"] // cgroup v1
+	if !ok {
+		inactive = stats["inactive_file"]
+	}
+	if inactive > usage {
+		return 0
+	}
+	return usage - inactive
+}
+
+func (d *dockerRuntime) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", d.runtime, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,351 @@
+package collectors
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ElasticsearchCollectorConfig holds configuration for the Elasticsearch
+// collector
+type ElasticsearchCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Clusters []ElasticsearchCluster
+}
+
+// ElasticsearchCluster is an Elasticsearch or OpenSearch cluster read
+// through the REST API of one of its nodes, e.g. https://127.0.0.1:9200.
+// It authenticates with APIKey, an Elasticsearch API key in its encoded
+// form, or with basic auth.
+type ElasticsearchCluster struct {
+	Name               string
+	URL                string
+	Username           string
+	Password           string
+	APIKey             string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+}
+
+// elasticsearchStatuses maps cluster health to the value reported
+var elasticsearchStatuses = map[string]float64{
+	"green":  0,
+	"yellow": 1,
+	"red":    2,
+}
+
+// ElasticsearchCollector reports cluster health and shard states, and the
+// JVM heap, indexing and search activity of each node
+type ElasticsearchCollector struct {
+	*BaseCollector
+	clusters []*elasticsearchCluster
+}
+
+type elasticsearchCluster struct {
+	config ElasticsearchCluster
+	client *http.Client
+
+	mu sync.Mutex
+	// last holds the previous indexing and search totals, to report rates
+	last *elasticsearchTotals
+}
+
+type elasticsearchTotals struct {
+	at      time.Time
+	indexed float64
+	queries float64
+}
+
+// NewElasticsearchCollector creates a new Elasticsearch collector
+func NewElasticsearchCollector(config ElasticsearchCollectorConfig) (*ElasticsearchCollector, error) {
+	ec := &ElasticsearchCollector{
+		BaseCollector: NewBaseCollector("elasticsearch", config.Enabled, config.Interval),
+	}
+	for _, c := range config.Clusters {
+		if !isHTTPAddress(c.URL) {
+			return nil, fmt.Errorf("elasticsearch cluster %s: url must be an http(s) URL", c.Name)
+		}
+		if c.APIKey != "" && c.Username != "" {
+			return nil, fmt.Errorf("elasticsearch cluster %s: set either api_key or username, not both", c.Name)
+		}
+		ec.clusters = append(ec.clusters, &elasticsearchCluster{
+			config: c,
+			client: &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify},
+				},
+			},
+		})
+	}
+	return ec, nil
+}
+
+// elasticsearchHealth is the document /_cluster/health returns
+type elasticsearchHealth struct {
+	ClusterName                 string  `json:"cluster_name"`
+	Status                      string  `json:"status"`
+	NumberOfNodes               float64 `json:"number_of_nodes"`
+	NumberOfDataNodes           float64 `json:"number_of_data_nodes"`
+	ActivePrimaryShards         float64 `json:"active_primary_shards"`
+	ActiveShards                float64 `json:"active_shards"`
+	RelocatingShards            float64 `json:"relocating_shards"`
+	InitializingShards          float64 `json:"initializing_shards"`
+	UnassignedShards            float64 `json:"unassigned_shards"`
+	DelayedUnassignedShards     float64 `json:"delayed_unassigned_shards"`
+	NumberOfPendingTasks        float64 `json:"number_of_pending_tasks"`
+	ActiveShardsPercentAsNumber float64 `json:"active_shards_percent_as_number"`
+}
+
+// elasticsearchIndexStats are the indices statistics of a node or of
+// the whole cluster
+type elasticsearchIndexStats struct {
+	Docs struct {
+		Count float64 `json:"count"`
+	} `json:"docs"`
+	Store struct {
+		SizeInBytes float64 `json:"size_in_bytes"`
+	} `json:"store"`
+	Indexing struct {
+		IndexTotal        float64 `json:"index_total"`
+		IndexTimeInMillis float64 `json:"index_time_in_millis"`
+		IndexFailed       float64 `json:"index_failed"`
+	} `json:"indexing"`
+	Search struct {
+		QueryTotal        float64 `json:"query_total"`
+		QueryTimeInMillis float64 `json:"query_time_in_millis"`
+		FetchTotal        float64 `json:"fetch_total"`
+	} `json:"search"`
+}
+
+// elasticsearchClusterStats is the document /_stats returns
+type elasticsearchClusterStats struct {
+	All struct {
+		Total elasticsearchIndexStats `json:"total"`
+	} `json:"_all"`
+}
+
+// elasticsearchNodeStats is the document /_nodes/stats returns
+type elasticsearchNodeStats struct {
+	Nodes map[string]struct {
+		Name string `json:"name"`
+		JVM  struct {
+			Mem struct {
+				HeapUsedInBytes float64 `json:"heap_used_in_bytes"`
+				HeapMaxInBytes  float64 `json:"heap_max_in_bytes"`
+				HeapUsedPercent float64 `json:"heap_used_percent"`
+			} `json:"mem"`
+			GC struct {
+				Collectors map[string]struct {
+					CollectionCount        float64 `json:"collection_count"`
+					CollectionTimeInMillis float64 `json:"collection_time_in_millis"`
+				} `json:"collectors"`
+			} `json:"gc"`
+		} `json:"jvm"`
+		Indices    elasticsearchIndexStats `json:"indices"`
+		ThreadPool map[string]struct {
+			Queue    float64 `json:"queue"`
+			Rejected float64 `json:"rejected"`
+		} `json:"thread_pool"`
+		Breakers map[string]struct {
+			Tripped float64 `json:"tripped"`
+		} `json:"breakers"`
+	} `json:"nodes"`
+}
+
+// elasticsearchThreadPools are the thread pools whose queues and
+// rejections are reported
+var elasticsearchThreadPools = []string{"write", "search", "get"}
+
+// Collect collects the metrics of every cluster
+func (ec *ElasticsearchCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	results := make([][]*Metric, len(ec.clusters))
+	var wg sync.WaitGroup
+	for i, c := range ec.clusters {
+		wg.Add(1)
+		go func(i int, c *elasticsearchCluster) {
+			defer wg.Done()
+			results[i] = c.collect(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	var metrics []*Metric
+	for _, r := range results {
+		metrics = append(metrics, r...)
+	}
+	return metrics, nil
+}
+
+func (c *elasticsearchCluster) collect(ctx context.Context) []*Metric {
+	timeout := c.config.Timeout
+	if timeout == 0 {
+		timeout = appServerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	labels := map[string]string{"cluster": c.config.Name}
+	var health elasticsearchHealth
+	if err := c.get(ctx, "/_cluster/health", &health); err != nil {
+		return []*Metric{upMetric("elasticsearch_up", false, labels, "Whether the cluster API could be read")}
+	}
+
+	status, ok := elasticsearchStatuses[health.Status]
+	if !ok {
+		status = elasticsearchStatuses["red"]
+	}
+	gauge := func(name string, value float64, help string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: labels, Type: MetricTypeGauge, Help: help}
+	}
+	shards := func(state string, value float64) *Metric {
+		return &Metric{
+			Name:   "elasticsearch_cluster_shards",
+			Value:  value,
+			Labels: withLabel(labels, "state", state),
+			Type:   MetricTypeGauge,
+			Help:   "Shards by state",
+		}
+	}
+	metrics := []*Metric{
+		upMetric("elasticsearch_up", true, labels, "Whether the cluster API could be read"),
+		gauge("elasticsearch_cluster_status", status, "Cluster health: 0 green, 1 yellow, 2 red"),
+		gauge("elasticsearch_cluster_nodes", health.NumberOfNodes, "Nodes in the cluster"),
+		gauge("elasticsearch_cluster_data_nodes", health.NumberOfDataNodes, "Data nodes in the cluster"),
+		gauge("elasticsearch_cluster_active_primary_shards", health.ActivePrimaryShards, "Active primary shards"),
+		shards("active", health.ActiveShards),
+		shards("relocating", health.RelocatingShards),
+		shards("initializing", health.InitializingShards),
+		shards("unassigned", health.UnassignedShards),
+		shards("delayed_unassigned", health.DelayedUnassignedShards),
+		gauge("elasticsearch_cluster_pending_tasks", health.NumberOfPendingTasks, "Cluster state changes not yet applied"),
+		&Metric{Name: "elasticsearch_cluster_active_shards_percent", Value: health.ActiveShardsPercentAsNumber, Labels: labels, Type: MetricTypeGauge, Help: "Share of shards that are active", Unit: "percent"},
+	}
+
+	var stats elasticsearchClusterStats
+	if err := c.get(ctx, "/_stats/docs,store,indexing,search", &stats); err == nil {
+		metrics = append(metrics, c.indexMetrics(stats.All.Total, labels)...)
+	}
+
+	var nodes elasticsearchNodeStats
+	if err := c.get(ctx, "/_nodes/stats/jvm,indices,thread_pool,breaker", &nodes); err == nil {
+		metrics = append(metrics, elasticsearchNodeMetrics(nodes, labels)...)
+	}
+	return metrics
+}
+
+// indexMetrics reports the cluster's documents and indexing and search
+// totals, and their rates since the previous collection
+func (c *elasticsearchCluster) indexMetrics(stats elasticsearchIndexStats, labels map[string]string) []*Metric {
+	counter := func(name string, value float64, help, unit string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: labels, Type: MetricTypeCounter, Help: help, Unit: unit}
+	}
+	metrics := []*Metric{
+		{Name: "elasticsearch_indices_docs", Value: stats.Docs.Count, Labels: labels, Type: MetricTypeGauge, Help: "Documents in all indices"},
+		{Name: "elasticsearch_indices_store_size_bytes", Value: stats.Store.SizeInBytes, Labels: labels, Type: MetricTypeGauge, Help: "Size of all indices including replicas", Unit: "bytes"},
+		counter("elasticsearch_indices_indexed_total", stats.Indexing.IndexTotal, "Documents indexed", ""),
+		counter("elasticsearch_indices_index_failed_total", stats.Indexing.IndexFailed, "Documents that failed to index", ""),
+		counter("elasticsearch_indices_indexing_seconds_total", stats.Indexing.IndexTimeInMillis/1000, "Time spent indexing", "seconds"),
+		counter("elasticsearch_indices_search_queries_total", stats.Search.QueryTotal, "Search queries run", ""),
+		counter("elasticsearch_indices_search_query_seconds_total", stats.Search.QueryTimeInMillis/1000, "Time spent running search queries", "seconds"),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	current := &elasticsearchTotals{at: now, indexed: stats.Indexing.IndexTotal, queries: stats.Search.QueryTotal}
+	last := c.last
+	c.last = current
+	// Totals drop when indices are deleted, so no rate is reported then
+	if last == nil || current.indexed < last.indexed || current.queries < last.queries {
+		return metrics
+	}
+	elapsed := now.Sub(last.at).Seconds()
+	if elapsed <= 0 {
+		return metrics
+	}
+	return append(metrics,
+		&Metric{Name: "elasticsearch_indexing_rate", Value: (current.indexed - last.indexed) / elapsed, Labels: labels, Type: MetricTypeGauge, Help: "Documents indexed per second"},
+		&Metric{Name: "elasticsearch_search_rate", Value: (current.queries - last.queries) / elapsed, Labels: labels, Type: MetricTypeGauge, Help: "Search queries per second"},
+	)
+}
+
+// elasticsearchNodeMetrics reports the JVM, indexing, search and thread
+// pool activity of each node. The node is labelled es_node, as node names
+// the agent's host.
+func elasticsearchNodeMetrics(stats elasticsearchNodeStats, labels map[string]string) []*Metric {
+	var metrics []*Metric
+	for _, n := range stats.Nodes {
+		nLabels := withLabel(labels, "es_node", n.Name)
+		gauge := func(name string, value float64, help, unit string) *Metric {
+			return &Metric{Name: name, Value: value, Labels: nLabels, Type: MetricTypeGauge, Help: help, Unit: unit}
+		}
+		counter := func(name string, value float64, help, unit string) *Metric {
+			return &Metric{Name: name, Value: value, Labels: nLabels, Type: MetricTypeCounter, Help: help, Unit: unit}
+		}
+		metrics = append(metrics,
+			gauge("elasticsearch_node_jvm_heap_used_bytes", n.JVM.Mem.HeapUsedInBytes, "JVM heap in use", "bytes"),
+			gauge("elasticsearch_node_jvm_heap_max_bytes", n.JVM.Mem.HeapMaxInBytes, "Maximum JVM heap", "bytes"),
+			gauge("elasticsearch_node_jvm_heap_used_percent", n.JVM.Mem.HeapUsedPercent, "Share of the JVM heap in use", "percent"),
+			gauge("elasticsearch_node_docs", n.Indices.Docs.Count, "Documents in shards on the node", ""),
+			gauge("elasticsearch_node_store_size_bytes", n.Indices.Store.SizeInBytes, "Size of shards on the node", "bytes"),
+			counter("elasticsearch_node_indexed_total", n.Indices.Indexing.IndexTotal, "Documents indexed on the node", ""),
+			counter("elasticsearch_node_search_queries_total", n.Indices.Search.QueryTotal, "Search queries run on the node", ""),
+		)
+		for name, gc := range n.JVM.GC.Collectors {
+			gcLabels := withLabel(nLabels, "gc", name)
+			metrics = append(metrics,
+				&Metric{Name: "elasticsearch_node_jvm_gc_collections_total", Value: gc.CollectionCount, Labels: gcLabels, Type: MetricTypeCounter, Help: "Garbage collections run"},
+				&Metric{Name: "elasticsearch_node_jvm_gc_seconds_total", Value: gc.CollectionTimeInMillis / 1000, Labels: gcLabels, Type: MetricTypeCounter, Help: "Time spent in garbage collection", Unit: "seconds"},
+			)
+		}
+		for _, name := range elasticsearchThreadPools {
+			pool, ok := n.ThreadPool[name]
+			if !ok {
+				continue
+			}
+			poolLabels := withLabel(nLabels, "pool", name)
+			metrics = append(metrics,
+				&Metric{Name: "elasticsearch_node_thread_pool_queue", Value: pool.Queue, Labels: poolLabels, Type: MetricTypeGauge, Help: "Tasks waiting in the thread pool"},
+				&Metric{Name: "elasticsearch_node_thread_pool_rejected_total", Value: pool.Rejected, Labels: poolLabels, Type: MetricTypeCounter, Help: "Tasks rejected by the thread pool"},
+			)
+		}
+		var tripped float64
+		for _, b := range n.Breakers {
+			tripped += b.Tripped
+		}
+		metrics = append(metrics, counter("elasticsearch_node_breakers_tripped_total", tripped, "Requests rejected by circuit breakers", ""))
+	}
+	return metrics
+}
+
+// get fetches a cluster API document
+func (c *elasticsearchCluster) get(ctx context.Context, path string, v interface{}) error {
+	u := strings.TrimRight(c.config.URL, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if c.config.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+c.config.APIKey)
+	} else if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,243 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvoyCollectorConfig holds configuration for the Envoy collector
+type EnvoyCollectorConfig struct {
+	Enabled   bool
+	Interval  time.Duration
+	Instances []EnvoyInstance
+}
+
+// EnvoyInstance is an Envoy proxy whose admin interface is read, e.g.
+// http://127.0.0.1:9901
+type EnvoyInstance struct {
+	Name    string
+	URL     string
+	Timeout time.Duration
+}
+
+// envoyStat maps an Envoy statistic to a metric
+type envoyStat struct {
+	name       string
+	metricType MetricType
+	help       string
+}
+
+// envoyClusterStats are the upstream cluster statistics reported, keyed
+// by the statistic's name after cluster.<name>.
+var envoyClusterStats = map[string]envoyStat{
+	"upstream_cx_active":                         {"envoy_cluster_upstream_connections_active", MetricTypeGauge, "Open connections to upstream hosts"},
+	"upstream_cx_total":                          {"envoy_cluster_upstream_connections_total", MetricTypeCounter, "Connections opened to upstream hosts"},
+	"upstream_cx_connect_fail":                   {"envoy_cluster_upstream_connect_failures_total", MetricTypeCounter, "Failed connections to upstream hosts"},
+	"upstream_rq_active":                         {"envoy_cluster_upstream_requests_active", MetricTypeGauge, "Requests in flight to upstream hosts"},
+	"upstream_rq_pending_active":                 {"envoy_cluster_upstream_requests_pending", MetricTypeGauge, "Requests waiting for a connection"},
+	"upstream_rq_total":                          {"envoy_cluster_upstream_requests_total", MetricTypeCounter, "Requests sent to upstream hosts"},
+	"upstream_rq_timeout":                        {"envoy_cluster_upstream_request_timeouts_total", MetricTypeCounter, "Requests that timed out waiting for a response"},
+	"upstream_rq_retry":                          {"envoy_cluster_upstream_request_retries_total", MetricTypeCounter, "Requests retried"},
+	"upstream_rq_pending_overflow":               {"envoy_cluster_upstream_request_overflows_total", MetricTypeCounter, "Requests rejected by the circuit breaker"},
+	"membership_healthy":                         {"envoy_cluster_members_healthy", MetricTypeGauge, "Healthy hosts in the cluster"},
+	"membership_total":                           {"envoy_cluster_members", MetricTypeGauge, "Hosts in the cluster"},
+	"outlier_detection.ejections_active":         {"envoy_cluster_outlier_ejections_active", MetricTypeGauge, "Hosts ejected by outlier detection"},
+	"outlier_detection.ejections_enforced_total": {"envoy_cluster_outlier_ejections_total", MetricTypeCounter, "Ejections enforced by outlier detection"},
+}
+
+// envoyHTTPStats are the HTTP connection manager statistics reported,
+// keyed by the statistic's name after http.<stat_prefix>.
+var envoyHTTPStats = map[string]envoyStat{
+	"downstream_cx_active": {"envoy_http_downstream_connections_active", MetricTypeGauge, "Open client connections"},
+	"downstream_cx_total":  {"envoy_http_downstream_connections_total", MetricTypeCounter, "Client connections accepted"},
+	"downstream_rq_active": {"envoy_http_downstream_requests_active", MetricTypeGauge, "Client requests in flight"},
+	"downstream_rq_total":  {"envoy_http_downstream_requests_total", MetricTypeCounter, "Client requests received"},
+}
+
+var (
+	// Cluster names may contain dots, so the statistic is matched from the
+	// end of the name
+	envoyClusterPattern = regexp.MustCompile(`^cluster\.(.+)\.(upstream_[a-z0-9_]+|membership_[a-z]+|outlier_detection\.[a-z_]+)$`)
+	envoyHTTPPattern    = regexp.MustCompile(`^http\.(.+)\.(downstream_[a-z0-9_]+)$`)
+	envoyCodeClass      = regexp.MustCompile(`^(upstream|downstream)_rq_([1-5]xx)$`)
+)
+
+// EnvoyCollector reports Envoy upstream cluster traffic, errors and host
+// health, and downstream HTTP traffic
+type EnvoyCollector struct {
+	*BaseCollector
+	instances []EnvoyInstance
+	client    *http.Client
+}
+
+// NewEnvoyCollector creates a new Envoy collector
+func NewEnvoyCollector(config EnvoyCollectorConfig) (*EnvoyCollector, error) {
+	for _, inst := range config.Instances {
+		if !isHTTPAddress(inst.URL) {
+			return nil, fmt.Errorf("envoy instance %s: url must be an http(s) URL of the admin interface", inst.Name)
+		}
+	}
+	return &EnvoyCollector{
+		BaseCollector: NewBaseCollector("envoy", config.Enabled, config.Interval),
+		instances:     config.Instances,
+		client:        &http.Client{},
+	}, nil
+}
+
+// envoyStats is the document /stats?format=json returns. Histograms are
+// entries without a value and are skipped.
+type envoyStats struct {
+	Stats []struct {
+		Name  string   `json:"name"`
+		Value *float64 `json:"value"`
+	} `json:"stats"`
+}
+
+// envoyClusters is the document /clusters?format=json returns
+type envoyClusters struct {
+	ClusterStatuses []struct {
+		Name         string `json:"name"`
+		HostStatuses []struct {
+			Address struct {
+				SocketAddress struct {
+					Address   string `json:"address"`
+					PortValue int    `json:"port_value"`
+				} `json:"socket_address"`
+			} `json:"address"`
+			HealthStatus map[string]interface{} `json:"health_status"`
+		} `json:"host_statuses"`
+	} `json:"cluster_statuses"`
+}
+
+// Collect collects the statistics of every instance
+func (ec *EnvoyCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	results := make([][]*Metric, len(ec.instances))
+	var wg sync.WaitGroup
+	for i, inst := range ec.instances {
+		wg.Add(1)
+		go func(i int, inst EnvoyInstance) {
+			defer wg.Done()
+			results[i] = ec.collectInstance(ctx, inst)
+		}(i, inst)
+	}
+	wg.Wait()
+
+	var metrics []*Metric
+	for _, r := range results {
+		metrics = append(metrics, r...)
+	}
+	return metrics, nil
+}
+
+func (ec *EnvoyCollector) collectInstance(ctx context.Context, inst EnvoyInstance) []*Metric {
+	timeout := inst.Timeout
+	if timeout == 0 {
+		timeout = appServerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	labels := map[string]string{"instance": inst.Name}
+	base := strings.TrimRight(inst.URL, "/")
+
+	var stats envoyStats
+	if err := getJSON(ctx, ec.client, base+"/stats?format=json", &stats); err != nil {
+		return []*Metric{upMetric("envoy_up", false, labels, "Whether the admin interface could be read")}
+	}
+
+	metrics := []*Metric{upMetric("envoy_up", true, labels, "Whether the admin interface could be read")}
+	for _, s := range stats.Stats {
+		if s.Value == nil {
+			continue
+		}
+		if m := envoyStatMetric(s.Name, *s.Value, labels); m != nil {
+			metrics = append(metrics, m)
+		}
+	}
+
+	// Host health is only in the clusters document
+	var clusters envoyClusters
+	if err := getJSON(ctx, ec.client, base+"/clusters?format=json", &clusters); err == nil {
+		for _, c := range clusters.ClusterStatuses {
+			for _, h := range c.HostStatuses {
+				host := net.JoinHostPort(h.Address.SocketAddress.Address, strconv.Itoa(h.Address.SocketAddress.PortValue))
+				metrics = append(metrics, &Metric{
+					Name:   "envoy_cluster_upstream_host_healthy",
+					Value:  boolToFloat(envoyHostHealthy(h.HealthStatus)),
+					Labels: withLabel(withLabel(labels, "cluster", c.Name), "host", host),
+					Type:   MetricTypeGauge,
+					Help:   "Whether the upstream host is healthy",
+				})
+			}
+		}
+	}
+	return metrics
+}
+
+// envoyStatMetric converts a cluster, HTTP or server statistic, returning
+// nil for statistics that are not reported
+func envoyStatMetric(name string, value float64, labels map[string]string) *Metric {
+	switch name {
+	case "server.live":
+		return &Metric{Name: "envoy_server_live", Value: value, Labels: labels, Type: MetricTypeGauge, Help: "Whether the server is live and not draining"}
+	case "server.uptime":
+		return &Metric{Name: "envoy_server_uptime_seconds", Value: value, Labels: labels, Type: MetricTypeGauge, Help: "Time since the server started", Unit: "seconds"}
+	case "server.memory_allocated":
+		return &Metric{Name: "envoy_server_memory_allocated_bytes", Value: value, Labels: labels, Type: MetricTypeGauge, Help: "Memory allocated by the server", Unit: "bytes"}
+	}
+
+	var (
+		stats  map[string]envoyStat
+		prefix string
+		stat   string
+	)
+	if m := envoyClusterPattern.FindStringSubmatch(name); m != nil {
+		stats, prefix, stat = envoyClusterStats, "envoy_cluster_upstream", m[2]
+		labels = withLabel(labels, "cluster", m[1])
+	} else if m := envoyHTTPPattern.FindStringSubmatch(name); m != nil {
+		stats, prefix, stat = envoyHTTPStats, "envoy_http_downstream", m[2]
+		labels = withLabel(labels, "stat_prefix", m[1])
+	} else {
+		return nil
+	}
+
+	if m := envoyCodeClass.FindStringSubmatch(stat); m != nil {
+		return &Metric{
+			Name:   prefix + "_responses_total",
+			Value:  value,
+			Labels: withLabel(labels, "code", m[2]),
+			Type:   MetricTypeCounter,
+			Help:   "Responses by status class",
+		}
+	}
+	s, ok := stats[stat]
+	if !ok {
+		return nil
+	}
+	return &Metric{Name: s.name, Value: value, Labels: labels, Type: s.metricType, Help: s.help}
+}
+
+// envoyHostHealthy reports whether a host has no failed health flags and
+// is not marked unhealthy by service discovery
+func envoyHostHealthy(status map[string]interface{}) bool {
+	for flag, v := range status {
+		switch flag {
+		case "eds_health_status":
+			if s, _ := v.(string); s != "" && s != "HEALTHY" {
+				return false
+			}
+		default:
+			if failed, _ := v.(bool); failed && strings.HasPrefix(flag, "failed") {
+				return false
+			}
+		}
+	}
+	return true
+}
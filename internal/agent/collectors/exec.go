@@ -0,0 +1,373 @@
+package collectors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// CustomCollectorConfig holds configuration for the custom collector, which
+// runs scripts and reports the metrics they print. Scripts are the files
+// under Path, run without arguments, and those configured in Scripts.
+type CustomCollectorConfig struct {
+	Enabled     bool
+	Interval    time.Duration
+	Path        string
+	Scripts     []CustomScript
+	Timeout     time.Duration
+	MaxParallel int
+	// MaxOutput bounds the bytes read from a script's stdout. MaxMemory
+	// and MaxCPUTime are applied to each script process on Linux.
+	MaxOutput  int64
+	MaxMemory  int64
+	MaxCPUTime time.Duration
+}
+
+// CustomScript is a script run by the custom collector. Format is
+// prometheus or influx, or detected from the output if empty; Interval
+// runs the script less often than the collector.
+type CustomScript struct {
+	Name     string
+	Command  string
+	Args     []string
+	Format   string
+	Interval time.Duration
+	Timeout  time.Duration
+	Env      map[string]string
+	Labels   map[string]string
+}
+
+// CustomCollector runs scripts on a schedule and reports what they print,
+// each metric labelled with the script's name. Every script also reports
+// custom_script_up and custom_script_duration_seconds.
+type CustomCollector struct {
+	*BaseCollector
+	config CustomCollectorConfig
+
+	mu      sync.Mutex
+	lastRun map[string]time.Time
+
+	inventoryMu sync.RWMutex
+	inventory   map[string]string
+}
+
+const (
+	// defaultMaxOutput is used when no output limit is configured
+	defaultMaxOutput = 1 << 20
+	// maxScriptStderr bounds the stderr kept for error messages
+	maxScriptStderr = 4 << 10
+)
+
+// errOutputLimit stops reading a script whose output is too large
+var errOutputLimit = errors.New("output limit exceeded")
+
+// NewCustomCollector creates a new custom collector
+func NewCustomCollector(config CustomCollectorConfig) (*CustomCollector, error) {
+	if config.Path == "" && len(config.Scripts) == 0 {
+		return nil, fmt.Errorf("no scripts path or scripts configured")
+	}
+	if (config.MaxMemory > 0 || config.MaxCPUTime > 0) && !processLimitsSupported {
+		return nil, fmt.Errorf("max_memory and max_cpu_time are only supported on Linux")
+	}
+	if config.MaxParallel < 1 {
+		config.MaxParallel = 1
+	}
+	if config.MaxOutput <= 0 {
+		config.MaxOutput = defaultMaxOutput
+	}
+
+	seen := make(map[string]bool, len(config.Scripts))
+	for i := range config.Scripts {
+		script := &config.Scripts[i]
+		if script.Command == "" {
+			return nil, fmt.Errorf("script %d: command is required", i+1)
+		}
+		if script.Name == "" {
+			script.Name = scriptName(script.Command)
+		}
+		if seen[script.Name] {
+			return nil, fmt.Errorf("duplicate script %s", script.Name)
+		}
+		seen[script.Name] = true
+		switch script.Format {
+		case "", formatPrometheus, formatInflux:
+		default:
+			return nil, fmt.Errorf("script %s: unknown format %q", script.Name, script.Format)
+		}
+	}
+
+	return &CustomCollector{
+		BaseCollector: NewBaseCollector("custom", config.Enabled, config.Interval),
+		config:        config,
+		lastRun:       make(map[string]time.Time),
+		inventory:     make(map[string]string),
+	}, nil
+}
+
+// scriptName names a script after its file, without the extension
+func scriptName(command string) string {
+	base := filepath.Base(command)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// Collect runs the scripts that are due, up to MaxParallel at once
+func (cc *CustomCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	scripts, invalid, err := cc.scripts()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cc.mu.Lock()
+	due := make([]CustomScript, 0, len(scripts))
+	listed := make(map[string]bool, len(scripts))
+	for _, script := range scripts {
+		listed[script.Name] = true
+		if script.Interval > 0 && now.Sub(cc.lastRun[script.Name]) < script.Interval-script.Interval/10 {
+			continue
+		}
+		cc.lastRun[script.Name] = now
+		due = append(due, script)
+	}
+	for name := range cc.lastRun {
+		if !listed[name] {
+			delete(cc.lastRun, name)
+		}
+	}
+	cc.mu.Unlock()
+
+	results := make([][]*Metric, len(due))
+	errs := make([]error, len(due))
+	sem := make(chan struct{}, cc.config.MaxParallel)
+	var wg sync.WaitGroup
+	for i, script := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, script CustomScript) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], errs[i] = cc.run(ctx, script)
+		}(i, script)
+	}
+	wg.Wait()
+
+	var metrics []*Metric
+	for _, result := range results {
+		metrics = append(metrics, result...)
+	}
+	for name, err := range invalid {
+		metrics = append(metrics, upMetric("custom_script_up", false, map[string]string{"script": name},
+			"Whether the script ran and its output was parsed"))
+		due = append(due, CustomScript{Name: name})
+		errs = append(errs, err)
+	}
+	cc.updateInventory(scripts, invalid, due, errs)
+	return metrics, nil
+}
+
+// scripts returns the configured scripts and the executable files under
+// Path. Files that are unsafe to run are returned as invalid.
+func (cc *CustomCollector) scripts() ([]CustomScript, map[string]error, error) {
+	scripts := append([]CustomScript(nil), cc.config.Scripts...)
+	if cc.config.Path == "" {
+		return scripts, nil, nil
+	}
+
+	entries, err := os.ReadDir(cc.config.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return scripts, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read scripts path: %w", err)
+	}
+
+	configured := make(map[string]bool, len(scripts))
+	for _, script := range scripts {
+		configured[script.Name] = true
+	}
+	invalid := make(map[string]error)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		path := filepath.Join(cc.config.Path, name)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || !isExecutable(info) {
+			continue
+		}
+		script := CustomScript{Name: scriptName(name), Command: path}
+		if configured[script.Name] {
+			continue
+		}
+		configured[script.Name] = true
+		if err := unsafeScript(info); err != nil {
+			invalid[script.Name] = err
+			continue
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, invalid, nil
+}
+
+// run runs a script and labels its metrics, adding its status
+func (cc *CustomCollector) run(ctx context.Context, script CustomScript) ([]*Metric, error) {
+	start := time.Now()
+	metrics, err := cc.execute(ctx, script)
+
+	for _, metric := range metrics {
+		for k, v := range script.Labels {
+			metric.Labels[k] = v
+		}
+		metric.Labels["script"] = script.Name
+	}
+	labels := map[string]string{"script": script.Name}
+	metrics = append(metrics,
+		upMetric("custom_script_up", err == nil, labels, "Whether the script ran and its output was parsed"),
+		&Metric{
+			Name:   "custom_script_duration_seconds",
+			Value:  time.Since(start).Seconds(),
+			Labels: map[string]string{"script": script.Name},
+			Type:   MetricTypeGauge,
+			Help:   "Time the script took to run",
+			Unit:   "seconds",
+		},
+	)
+	return metrics, err
+}
+
+// execute runs a script under its timeout and the resource limits and
+// parses its output
+func (cc *CustomCollector) execute(ctx context.Context, script CustomScript) ([]*Metric, error) {
+	timeout := script.Timeout
+	if timeout == 0 {
+		timeout = cc.config.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, script.Command, script.Args...)
+	cmd.Env = os.Environ()
+	keys := make([]string, 0, len(script.Env))
+	for k := range script.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+script.Env[k])
+	}
+
+	stdout := &limitedBuffer{max: cc.config.MaxOutput, exceeded: cancel}
+	stderr := &limitedBuffer{max: maxScriptStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// Children left running with the output open are not waited for
+	cmd.WaitDelay = time.Second
+	isolateProcess(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start: %w", err)
+	}
+	if err := limitProcess(cmd.Process.Pid, cc.config.MaxMemory, cc.config.MaxCPUTime); err != nil {
+		cancel()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to apply resource limits: %w", err)
+	}
+	err := cmd.Wait()
+
+	switch {
+	case stdout.overflow:
+		return nil, fmt.Errorf("output exceeds %d bytes", cc.config.MaxOutput)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	metrics, err := parseExposition(script.Format, stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("invalid output: %w", err)
+	}
+	return metrics, nil
+}
+
+// limitedBuffer keeps up to max bytes. Stdout fails the write and calls
+// exceeded when it has more; stderr is truncated. The buffer is not
+// embedded, so copying into it goes through Write.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	max      int64
+	exceeded func()
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - int64(b.buf.Len()); int64(len(p)) > room {
+		if b.exceeded == nil {
+			b.buf.Write(p[:max(room, 0)])
+			return len(p), nil
+		}
+		b.overflow = true
+		b.exceeded()
+		return 0, errOutputLimit
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte  { return b.buf.Bytes() }
+func (b *limitedBuffer) String() string { return b.buf.String() }
+
+// Inventory reports why scripts last failed
+func (cc *CustomCollector) Inventory() map[string]string {
+	cc.inventoryMu.RLock()
+	defer cc.inventoryMu.RUnlock()
+
+	inventory := make(map[string]string, len(cc.inventory))
+	for k, v := range cc.inventory {
+		inventory[k] = v
+	}
+	return inventory
+}
+
+// updateInventory records the errors of the scripts that ran, and drops
+// those of scripts that are gone
+func (cc *CustomCollector) updateInventory(scripts []CustomScript, invalid map[string]error, ran []CustomScript, errs []error) {
+	cc.inventoryMu.Lock()
+	defer cc.inventoryMu.Unlock()
+
+	for i, script := range ran {
+		key := models.InventoryCustomScriptError(script.Name)
+		if errs[i] != nil {
+			cc.inventory[key] = errs[i].Error()
+		} else {
+			delete(cc.inventory, key)
+		}
+	}
+
+	current := make(map[string]bool, len(scripts)+len(invalid))
+	for _, script := range scripts {
+		current[models.InventoryCustomScriptError(script.Name)] = true
+	}
+	for name := range invalid {
+		current[models.InventoryCustomScriptError(name)] = true
+	}
+	for key := range cc.inventory {
+		if !current[key] {
+			delete(cc.inventory, key)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package collectors
+
+import "time"
+
+const processLimitsSupported = false
+
+func limitProcess(pid int, memory int64, cpu time.Duration) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+package collectors
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const processLimitsSupported = true
+
+// limitProcess limits a started script's address space and CPU time. The
+// processes it starts afterwards inherit the limits.
+func limitProcess(pid int, memory int64, cpu time.Duration) error {
+	if memory > 0 {
+		limit := &unix.Rlimit{Cur: uint64(memory), Max: uint64(memory)}
+		if err := unix.Prlimit(pid, unix.RLIMIT_AS, limit, nil); err != nil {
+			return err
+		}
+	}
+	if cpu > 0 {
+		// SIGXCPU at the soft limit, SIGKILL a second later
+		seconds := uint64(max(cpu/time.Second, 1))
+		limit := &unix.Rlimit{Cur: seconds, Max: seconds + 1}
+		if err := unix.Prlimit(pid, unix.RLIMIT_CPU, limit, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package collectors
+
+import (
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"syscall"
+)
+
+// isolateProcess runs a script in its own process group, so a timeout
+// kills the processes it started too
+func isolateProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+func isExecutable(info fs.FileInfo) bool {
+	return info.Mode().Perm()&0111 != 0
+}
+
+// unsafeScript rejects scripts any user could replace
+func unsafeScript(info fs.FileInfo) error {
+	if info.Mode().Perm()&0002 != 0 {
+		return fmt.Errorf("script is writable by every user")
+	}
+	return nil
+}
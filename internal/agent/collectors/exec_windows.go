@@ -0,0 +1,24 @@
+package collectors
+
+import (
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// isolateProcess leaves the script to be killed on its own when it times
+// out
+func isolateProcess(cmd *exec.Cmd) {}
+
+func isExecutable(info fs.FileInfo) bool {
+	switch strings.ToLower(filepath.Ext(info.Name())) {
+	case ".exe", ".bat", ".cmd", ".com":
+		return true
+	}
+	return false
+}
+
+func unsafeScript(info fs.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,367 @@
+package collectors
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Formats of the metrics a custom script prints
+const (
+	formatPrometheus = "prometheus"
+	formatInflux     = "influx"
+)
+
+// parseExposition parses metrics in a format, detecting it from the first
+// sample if format is empty
+func parseExposition(format string, data []byte) ([]*Metric, error) {
+	if format == "" {
+		format = detectFormat(data)
+	}
+	switch format {
+	case formatPrometheus:
+		return parsePrometheusText(data)
+	case formatInflux:
+		return parseInfluxLines(data)
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// detectFormat tells the two formats apart by the first sample: its fields
+// are key=value pairs in line protocol, and a bare value in the Prometheus
+// format
+func detectFormat(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexAny(line, "{ "); i >= 0 && line[i] == '{' {
+			return formatPrometheus
+		}
+		fields := splitUnescaped(line, ' ')
+		if len(fields) > 1 && strings.Contains(fields[1], "=") {
+			return formatInflux
+		}
+		return formatPrometheus
+	}
+	return formatPrometheus
+}
+
+// parsePrometheusText parses the Prometheus text exposition format. The
+// samples of histograms and summaries are reported as they are printed,
+// with the type of their family.
+func parsePrometheusText(data []byte) ([]*Metric, error) {
+	types := make(map[string]MetricType)
+	helps := make(map[string]string)
+	var metrics []*Metric
+
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(strings.TrimSpace(line[1:]), " ", 3)
+			if len(fields) < 3 {
+				continue
+			}
+			switch fields[0] {
+			case "TYPE":
+				types[fields[1]] = prometheusType(strings.TrimSpace(fields[2]))
+			case "HELP":
+				helps[fields[1]] = unescapeHelp(strings.TrimSpace(fields[2]))
+			}
+			continue
+		}
+
+		metric, err := parsePrometheusSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		family := metric.Name
+		if _, ok := types[family]; !ok {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if base := strings.TrimSuffix(family, suffix); base != family {
+					if _, ok := types[base]; ok {
+						family = base
+						break
+					}
+				}
+			}
+		}
+		metric.Type = types[family]
+		metric.Help = helps[family]
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+func prometheusType(name string) MetricType {
+	switch name {
+	case "counter":
+		return MetricTypeCounter
+	case "histogram":
+		return MetricTypeHistogram
+	case "summary":
+		return MetricTypeSummary
+	default:
+		return MetricTypeGauge
+	}
+}
+
+func unescapeHelp(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(s)
+}
+
+// parsePrometheusSample parses name{label="value",...} value [timestamp],
+// with the timestamp in milliseconds
+func parsePrometheusSample(line string) (*Metric, error) {
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return nil, fmt.Errorf("invalid sample %q", line)
+	}
+	metric := &Metric{Name: line[:end], Labels: make(map[string]string)}
+	if !validMetricName(metric.Name) {
+		return nil, fmt.Errorf("invalid metric name %q", metric.Name)
+	}
+
+	rest := line[end:]
+	if strings.HasPrefix(rest, "{") {
+		var err error
+		if rest, err = parsePrometheusLabels(rest[1:], metric.Labels); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid sample %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", fields[0])
+	}
+	metric.Value = value
+	if len(fields) == 2 {
+		ms, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+		metric.Timestamp = ms * 1e6
+	}
+	return metric, nil
+}
+
+// parsePrometheusLabels parses label="value" pairs up to the closing
+// brace and returns what follows it
+func parsePrometheusLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return "", fmt.Errorf("invalid labels")
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		if !strings.HasPrefix(s, `"`) {
+			return "", fmt.Errorf("label %s: value is not quoted", name)
+		}
+
+		var value strings.Builder
+		i, closed := 1, false
+		for ; i < len(s); i++ {
+			c := s[i]
+			if c == '"' {
+				closed = true
+				break
+			}
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return "", fmt.Errorf("label %s: unterminated value", name)
+		}
+		labels[name] = value.String()
+
+		s = strings.TrimLeft(s[i+1:], " \t")
+		s = strings.TrimPrefix(s, ",")
+	}
+}
+
+// parseInfluxLines parses InfluxDB line protocol,
+// measurement,tag=value field=value,... [timestamp], with the timestamp in
+// nanoseconds. Each numeric or boolean field is a metric named after the
+// measurement and the field, or the measurement alone for a field named
+// value. String fields are skipped.
+func parseInfluxLines(data []byte) ([]*Metric, error) {
+	var metrics []*Metric
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parsed, err := parseInfluxLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		metrics = append(metrics, parsed...)
+	}
+	return metrics, nil
+}
+
+func parseInfluxLine(line string) ([]*Metric, error) {
+	parts := splitUnescaped(line, ' ')
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid line %q", line)
+	}
+
+	series := splitUnescaped(parts[0], ',')
+	measurement := unescapeInflux(series[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("missing measurement")
+	}
+	tags := make(map[string]string, len(series)-1)
+	for _, tag := range series[1:] {
+		kv := splitUnescaped(tag, '=')
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		tags[sanitizeMetricName(unescapeInflux(kv[0]))] = unescapeInflux(kv[1])
+	}
+
+	var timestamp int64
+	if len(parts) == 3 {
+		ts, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", parts[2])
+		}
+		timestamp = ts
+	}
+
+	var metrics []*Metric
+	for _, field := range splitUnescaped(parts[1], ',') {
+		kv := splitUnescaped(field, '=')
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+		value, ok, err := influxValue(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", kv[0], err)
+		}
+		if !ok {
+			continue
+		}
+
+		name := measurement
+		if key := unescapeInflux(kv[0]); key != "value" {
+			name += "_" + key
+		}
+		labels := make(map[string]string, len(tags))
+		for k, v := range tags {
+			labels[k] = v
+		}
+		metrics = append(metrics, &Metric{
+			Name:      sanitizeMetricName(name),
+			Value:     value,
+			Timestamp: timestamp,
+			Labels:    labels,
+			Type:      MetricTypeGauge,
+		})
+	}
+	return metrics, nil
+}
+
+// influxValue parses a field value; ok is false for strings
+func influxValue(s string) (value float64, ok bool, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return 0, false, nil
+	case s == "t" || s == "T" || s == "true" || s == "True" || s == "TRUE":
+		return 1, true, nil
+	case s == "f" || s == "F" || s == "false" || s == "False" || s == "FALSE":
+		return 0, true, nil
+	case strings.HasSuffix(s, "i"):
+		v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		return float64(v), err == nil, err
+	case strings.HasSuffix(s, "u"):
+		v, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		return float64(v), err == nil, err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, false, fmt.Errorf("invalid value %q", s)
+	}
+	return v, true, nil
+}
+
+// splitUnescaped splits s on sep where it is not escaped with a backslash
+// or inside a quoted string
+func splitUnescaped(s string, sep byte) []string {
+	var (
+		parts   []string
+		start   int
+		quoted  bool
+		escaped bool
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\ `, " ", `\,`, ",", `\=`, "=", `\\`, `\`).Replace(s)
+}
+
+func validMetricName(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || r == ':' || r < unicode.MaxASCII && unicode.IsLetter(r) || i > 0 && r < unicode.MaxASCII && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// sanitizeMetricName replaces the characters metric names cannot hold
+// with underscores
+func sanitizeMetricName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r == '_' || r == ':' || r < unicode.MaxASCII && unicode.IsLetter(r) || i > 0 && r < unicode.MaxASCII && unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
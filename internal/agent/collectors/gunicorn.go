@@ -0,0 +1,360 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GunicornCollectorConfig holds configuration for the Gunicorn collector
+type GunicornCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Servers  []GunicornServer
+}
+
+// GunicornServer is a Gunicorn instance. Gunicorn has no status endpoint:
+// workers and the accept queue are read from the master's process, found
+// through PIDFile, and request counts from the statsd metrics Gunicorn
+// sends to StatsdAddress when started with --statsd-host.
+type GunicornServer struct {
+	Name          string
+	PIDFile       string
+	StatsdAddress string
+}
+
+// GunicornCollector reports Gunicorn workers, accept queues and request
+// rates
+type GunicornCollector struct {
+	*BaseCollector
+	servers  []*gunicornServer
+	procPath string
+}
+
+type gunicornServer struct {
+	config GunicornServer
+	statsd *gunicornStatsd
+}
+
+// NewGunicornCollector creates a new Gunicorn collector, listening for the
+// statsd metrics of servers that send them
+func NewGunicornCollector(config GunicornCollectorConfig) (*GunicornCollector, error) {
+	gc := &GunicornCollector{
+		BaseCollector: NewBaseCollector("gunicorn", config.Enabled, config.Interval),
+		procPath:      "/proc",
+	}
+	for _, s := range config.Servers {
+		if s.PIDFile == "" && s.StatsdAddress == "" {
+			return nil, fmt.Errorf("gunicorn server %s: pid_file or statsd_address is required", s.Name)
+		}
+		server := &gunicornServer{config: s}
+		if s.StatsdAddress != "" {
+			statsd, err := listenGunicornStatsd(s.StatsdAddress)
+			if err != nil {
+				return nil, fmt.Errorf("gunicorn server %s: %w", s.Name, err)
+			}
+			server.statsd = statsd
+		}
+		gc.servers = append(gc.servers, server)
+	}
+	return gc, nil
+}
+
+// Collect collects metrics of every server
+func (gc *GunicornCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	var metrics []*Metric
+	for _, s := range gc.servers {
+		labels := map[string]string{"server": s.config.Name}
+		if s.config.PIDFile != "" {
+			metrics = append(metrics, gc.processMetrics(s.config.PIDFile, labels)...)
+		}
+		if s.statsd != nil {
+			metrics = append(metrics, s.statsd.metrics(labels)...)
+		}
+	}
+	return metrics, nil
+}
+
+// processMetrics reports the master's workers and the accept queues of
+// its listening TCP sockets
+func (gc *GunicornCollector) processMetrics(pidFile string, labels map[string]string) []*Metric {
+	data, err := os.ReadFile(pidFile)
+	master := strings.TrimSpace(string(data))
+	if err == nil {
+		_, err = os.Stat(filepath.Join(gc.procPath, master))
+	}
+	if err != nil {
+		return []*Metric{upMetric("gunicorn_up", false, labels, "Whether the master process is running")}
+	}
+
+	workers, rss := gc.workers(master)
+	metrics := []*Metric{
+		upMetric("gunicorn_up", true, labels, "Whether the master process is running"),
+		{
+			Name:   "gunicorn_workers",
+			Value:  float64(workers),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Worker processes of the master",
+		},
+		{
+			Name:   "gunicorn_worker_memory_bytes",
+			Value:  float64(rss),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Resident memory of all workers",
+			Unit:   "bytes",
+		},
+	}
+
+	for _, l := range gc.listeners(master) {
+		metrics = append(metrics, &Metric{
+			Name:   "gunicorn_listen_queue",
+			Value:  float64(l.queue),
+			Labels: withLabel(labels, "listener", l.address),
+			Type:   MetricTypeGauge,
+			Help:   "Connections waiting to be accepted by a worker",
+		})
+	}
+	return metrics
+}
+
+// workers counts the children of the master and their resident memory
+func (gc *GunicornCollector) workers(master string) (int, uint64) {
+	entries, err := os.ReadDir(gc.procPath)
+	if err != nil {
+		return 0, 0
+	}
+	var (
+		count int
+		rss   uint64
+	)
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join(gc.procPath, e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The command name may contain spaces, so fields are counted from
+		// the closing parenthesis: state, then ppid
+		i := strings.LastIndexByte(string(stat), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 2 || fields[1] != master {
+			continue
+		}
+		count++
+		if statm, err := os.ReadFile(filepath.Join(gc.procPath, e.Name(), "statm")); err == nil {
+			if f := strings.Fields(string(statm)); len(f) > 1 {
+				rss += parseUint(f[1]) * uint64(os.Getpagesize())
+			}
+		}
+	}
+	return count, rss
+}
+
+// gunicornListener is a listening TCP socket and its accept queue
+type gunicornListener struct {
+	address string
+	queue   uint64
+}
+
+// listeners finds the master's listening TCP sockets. For a socket in
+// LISTEN state /proc/net/tcp reports the accept queue as rx_queue.
+func (gc *GunicornCollector) listeners(master string) []gunicornListener {
+	fds, err := os.ReadDir(filepath.Join(gc.procPath, master, "fd"))
+	if err != nil {
+		return nil
+	}
+	inodes := make(map[string]bool)
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(gc.procPath, master, "fd", fd.Name()))
+		if err == nil && strings.HasPrefix(link, "socket:[") {
+			inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = true
+		}
+	}
+
+	var listeners []gunicornListener
+	for _, file := range []string{"tcp", "tcp6"} {
+		f, err := os.Open(filepath.Join(gc.procPath, master, "net", file))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Scan()
+		for scanner.Scan() {
+			// sl local_address rem_address st tx_queue:rx_queue ... inode
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[3] != "0A" || !inodes[fields[9]] {
+				continue
+			}
+			_, rx, _ := strings.Cut(fields[4], ":")
+			queue, _ := strconv.ParseUint(rx, 16, 64)
+			listeners = append(listeners, gunicornListener{
+				address: procNetAddress(fields[1]),
+				queue:   queue,
+			})
+		}
+		f.Close()
+	}
+	return listeners
+}
+
+// procNetAddress decodes an address of /proc/net/tcp, a little-endian hex
+// IP and a hex port
+func procNetAddress(s string) string {
+	ipHex, portHex, _ := strings.Cut(s, ":")
+	port, _ := strconv.ParseUint(portHex, 16, 16)
+	ip := make(net.IP, len(ipHex)/2)
+	for i := range ip {
+		b, _ := strconv.ParseUint(ipHex[i*2:i*2+2], 16, 8)
+		ip[i] = byte(b)
+	}
+	// Each 32-bit word is in host byte order
+	for i := 0; i+4 <= len(ip); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = ip[i+3], ip[i+2], ip[i+1], ip[i]
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10))
+}
+
+// gunicornStatsd aggregates the statsd metrics a Gunicorn server sends
+type gunicornStatsd struct {
+	mu            sync.Mutex
+	requests      float64
+	statuses      map[string]float64
+	logs          map[string]float64
+	durationSum   float64
+	durationCount float64
+}
+
+// listenGunicornStatsd starts receiving statsd packets on a UDP address.
+// The listener runs for the life of the agent.
+func listenGunicornStatsd(address string) (*gunicornStatsd, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for statsd: %w", err)
+	}
+	s := &gunicornStatsd{
+		statuses: make(map[string]float64),
+		logs:     make(map[string]float64),
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			for _, line := range strings.Split(string(buf[:n]), "\n") {
+				s.record(line)
+			}
+			s.mu.Unlock()
+		}
+	}()
+	return s, nil
+}
+
+// record adds one statsd line such as gunicorn.requests:1|c or
+// gunicorn.request.duration:12.5|ms. Names may carry a --statsd-prefix.
+func (s *gunicornStatsd) record(line string) {
+	name, rest, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return
+	}
+	i := strings.Index(name, "gunicorn.")
+	if i < 0 {
+		return
+	}
+	name = name[i+len("gunicorn."):]
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return
+	}
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return
+	}
+	// Sampled counters are scaled back up by their rate
+	for _, p := range parts[2:] {
+		if parts[1] != "c" || !strings.HasPrefix(p, "@") {
+			continue
+		}
+		if rate, err := strconv.ParseFloat(p[1:], 64); err == nil && rate > 0 {
+			value /= rate
+		}
+	}
+
+	switch {
+	case name == "requests":
+		s.requests += value
+	case name == "request.duration":
+		s.durationSum += value / 1000
+		s.durationCount++
+	case strings.HasPrefix(name, "request.status."):
+		s.statuses[strings.TrimPrefix(name, "request.status.")] += value
+	case strings.HasPrefix(name, "log."):
+		s.logs[strings.TrimPrefix(name, "log.")] += value
+	}
+}
+
+func (s *gunicornStatsd) metrics(labels map[string]string) []*Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metrics := []*Metric{
+		{
+			Name:   "gunicorn_requests_total",
+			Value:  s.requests,
+			Labels: labels,
+			Type:   MetricTypeCounter,
+			Help:   "Requests handled",
+		},
+		{
+			Name:   "gunicorn_request_duration_seconds_sum",
+			Value:  s.durationSum,
+			Labels: labels,
+			Type:   MetricTypeCounter,
+			Help:   "Total time spent handling requests",
+			Unit:   "seconds",
+		},
+		{
+			Name:   "gunicorn_request_duration_seconds_count",
+			Value:  s.durationCount,
+			Labels: labels,
+			Type:   MetricTypeCounter,
+			Help:   "Requests with a recorded duration",
+		},
+	}
+	for status, n := range s.statuses {
+		metrics = append(metrics, &Metric{
+			Name:   "gunicorn_responses_total",
+			Value:  n,
+			Labels: withLabel(labels, "status", status),
+			Type:   MetricTypeCounter,
+			Help:   "Responses by HTTP status",
+		})
+	}
+	for level, n := range s.logs {
+		metrics = append(metrics, &Metric{
+			Name:   "gunicorn_log_messages_total",
+			Value:  n,
+			Labels: withLabel(labels, "level", level),
+			Type:   MetricTypeCounter,
+			Help:   "Log messages by level",
+		})
+	}
+	return metrics
+}
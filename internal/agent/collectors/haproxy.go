@@ -0,0 +1,305 @@
+package collectors
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HAProxyCollectorConfig holds configuration for the HAProxy collector
+type HAProxyCollectorConfig struct {
+	Enabled   bool
+	Interval  time.Duration
+	Instances []HAProxyInstance
+}
+
+// HAProxyInstance is an HAProxy process whose statistics are read.
+// Address is its stats socket, or the URL of the stats page in CSV form,
+// e.g. http://127.0.0.1:8404/stats;csv, with optional stats auth.
+type HAProxyInstance struct {
+	Name     string
+	Address  string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+// HAProxy proxy types in the type column of the statistics
+const (
+	haproxyFrontend = "0"
+	haproxyBackend  = "1"
+	haproxyServer   = "2"
+)
+
+// haproxyResponseCodes are the HTTP response classes HAProxy counts
+var haproxyResponseCodes = []string{"1xx", "2xx", "3xx", "4xx", "5xx", "other"}
+
+// HAProxyCollector reports frontend and backend sessions, errors and the
+// health of backend servers
+type HAProxyCollector struct {
+	*BaseCollector
+	instances []HAProxyInstance
+	client    *http.Client
+}
+
+// NewHAProxyCollector creates a new HAProxy collector
+func NewHAProxyCollector(config HAProxyCollectorConfig) (*HAProxyCollector, error) {
+	for _, inst := range config.Instances {
+		if inst.Address == "" {
+			return nil, fmt.Errorf("haproxy instance %s: address is required", inst.Name)
+		}
+	}
+	return &HAProxyCollector{
+		BaseCollector: NewBaseCollector("haproxy", config.Enabled, config.Interval),
+		instances:     config.Instances,
+		client:        &http.Client{},
+	}, nil
+}
+
+// Collect collects the statistics of every instance
+func (hc *HAProxyCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	results := make([][]*Metric, len(hc.instances))
+	var wg sync.WaitGroup
+	for i, inst := range hc.instances {
+		wg.Add(1)
+		go func(i int, inst HAProxyInstance) {
+			defer wg.Done()
+			results[i] = hc.collectInstance(ctx, inst)
+		}(i, inst)
+	}
+	wg.Wait()
+
+	var metrics []*Metric
+	for _, r := range results {
+		metrics = append(metrics, r...)
+	}
+	return metrics, nil
+}
+
+func (hc *HAProxyCollector) collectInstance(ctx context.Context, inst HAProxyInstance) []*Metric {
+	timeout := inst.Timeout
+	if timeout == 0 {
+		timeout = appServerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	labels := map[string]string{"instance": inst.Name}
+	rows, err := hc.stats(ctx, inst)
+	if err != nil {
+		return []*Metric{upMetric("haproxy_up", false, labels, "Whether the statistics could be read")}
+	}
+
+	metrics := []*Metric{upMetric("haproxy_up", true, labels, "Whether the statistics could be read")}
+	for _, row := range rows {
+		switch row["type"] {
+		case haproxyFrontend:
+			metrics = append(metrics, haproxyFrontendMetrics(row, withLabel(labels, "frontend", row["pxname"]))...)
+		case haproxyBackend:
+			metrics = append(metrics, haproxyBackendMetrics(row, withLabel(labels, "backend", row["pxname"]))...)
+		case haproxyServer:
+			serverLabels := withLabel(withLabel(labels, "backend", row["pxname"]), "server", row["svname"])
+			metrics = append(metrics, haproxyServerMetrics(row, serverLabels)...)
+		}
+	}
+	return metrics
+}
+
+// haproxyRow is one line of the statistics, keyed by column name
+type haproxyRow map[string]string
+
+func (r haproxyRow) float(column string) float64 {
+	v, _ := strconv.ParseFloat(r[column], 64)
+	return v
+}
+
+func haproxyFrontendMetrics(row haproxyRow, labels map[string]string) []*Metric {
+	gauge := func(name, column, help string) *Metric {
+		return &Metric{Name: name, Value: row.float(column), Labels: labels, Type: MetricTypeGauge, Help: help}
+	}
+	counter := func(name, column, help, unit string) *Metric {
+		return &Metric{Name: name, Value: row.float(column), Labels: labels, Type: MetricTypeCounter, Help: help, Unit: unit}
+	}
+	metrics := []*Metric{
+		gauge("haproxy_frontend_current_sessions", "scur", "Sessions open on the frontend"),
+		gauge("haproxy_frontend_limit_sessions", "slim", "Session limit of the frontend"),
+		counter("haproxy_frontend_sessions_total", "stot", "Sessions accepted by the frontend", ""),
+		counter("haproxy_frontend_requests_total", "req_tot", "HTTP requests received by the frontend", ""),
+		counter("haproxy_frontend_request_errors_total", "ereq", "Requests that could not be parsed or were aborted", ""),
+		counter("haproxy_frontend_requests_denied_total", "dreq", "Requests denied by ACLs", ""),
+		counter("haproxy_frontend_bytes_in_total", "bin", "Bytes received from clients", "bytes"),
+		counter("haproxy_frontend_bytes_out_total", "bout", "Bytes sent to clients", "bytes"),
+	}
+	return append(metrics, haproxyResponses("haproxy_frontend_http_responses_total", row, labels)...)
+}
+
+func haproxyBackendMetrics(row haproxyRow, labels map[string]string) []*Metric {
+	gauge := func(name, column, help string) *Metric {
+		return &Metric{Name: name, Value: row.float(column), Labels: labels, Type: MetricTypeGauge, Help: help}
+	}
+	counter := func(name, column, help, unit string) *Metric {
+		return &Metric{Name: name, Value: row.float(column), Labels: labels, Type: MetricTypeCounter, Help: help, Unit: unit}
+	}
+	metrics := []*Metric{
+		{
+			Name:   "haproxy_backend_up",
+			Value:  boolToFloat(haproxyStatusUp(row["status"])),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Whether the backend has a usable server",
+		},
+		gauge("haproxy_backend_active_servers", "act", "Active servers that are up"),
+		gauge("haproxy_backend_backup_servers", "bck", "Backup servers that are up"),
+		gauge("haproxy_backend_current_sessions", "scur", "Sessions open to the backend"),
+		gauge("haproxy_backend_current_queue", "qcur", "Requests waiting for a server"),
+		counter("haproxy_backend_sessions_total", "stot", "Sessions sent to the backend", ""),
+		counter("haproxy_backend_connection_errors_total", "econ", "Failed connections to servers", ""),
+		counter("haproxy_backend_response_errors_total", "eresp", "Responses that failed or were aborted", ""),
+		counter("haproxy_backend_retry_warnings_total", "wretr", "Connections retried", ""),
+		counter("haproxy_backend_redispatch_warnings_total", "wredis", "Requests redispatched to another server", ""),
+		counter("haproxy_backend_bytes_in_total", "bin", "Bytes received from clients", "bytes"),
+		counter("haproxy_backend_bytes_out_total", "bout", "Bytes sent to clients", "bytes"),
+		haproxyResponseTime(row, labels, "haproxy_backend_response_time_average_seconds"),
+	}
+	return append(metrics, haproxyResponses("haproxy_backend_http_responses_total", row, labels)...)
+}
+
+func haproxyServerMetrics(row haproxyRow, labels map[string]string) []*Metric {
+	gauge := func(name, column, help string) *Metric {
+		return &Metric{Name: name, Value: row.float(column), Labels: labels, Type: MetricTypeGauge, Help: help}
+	}
+	counter := func(name, column, help, unit string) *Metric {
+		return &Metric{Name: name, Value: row.float(column), Labels: labels, Type: MetricTypeCounter, Help: help, Unit: unit}
+	}
+	metrics := []*Metric{
+		{
+			Name:   "haproxy_server_up",
+			Value:  boolToFloat(haproxyStatusUp(row["status"])),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Whether the server passes its health checks",
+		},
+		gauge("haproxy_server_weight", "weight", "Load balancing weight of the server"),
+		gauge("haproxy_server_current_sessions", "scur", "Sessions open to the server"),
+		gauge("haproxy_server_current_queue", "qcur", "Requests waiting for the server"),
+		counter("haproxy_server_sessions_total", "stot", "Sessions sent to the server", ""),
+		counter("haproxy_server_connection_errors_total", "econ", "Failed connections to the server", ""),
+		counter("haproxy_server_response_errors_total", "eresp", "Responses that failed or were aborted", ""),
+		counter("haproxy_server_check_failures_total", "chkfail", "Failed health checks", ""),
+		counter("haproxy_server_downtime_seconds_total", "downtime", "Time the server has been down", "seconds"),
+		haproxyResponseTime(row, labels, "haproxy_server_response_time_average_seconds"),
+	}
+	return append(metrics, haproxyResponses("haproxy_server_http_responses_total", row, labels)...)
+}
+
+// haproxyResponses reports the HTTP responses of a proxy by class
+func haproxyResponses(name string, row haproxyRow, labels map[string]string) []*Metric {
+	var metrics []*Metric
+	for _, code := range haproxyResponseCodes {
+		column := "hrsp_" + code
+		if _, ok := row[column]; !ok || row[column] == "" {
+			continue
+		}
+		metrics = append(metrics, &Metric{
+			Name:   name,
+			Value:  row.float(column),
+			Labels: withLabel(labels, "code", code),
+			Type:   MetricTypeCounter,
+			Help:   "HTTP responses by status class",
+		})
+	}
+	return metrics
+}
+
+// haproxyResponseTime reports the average response time over the last
+// 1024 requests, which HAProxy gives in milliseconds
+func haproxyResponseTime(row haproxyRow, labels map[string]string, name string) *Metric {
+	return &Metric{
+		Name:   name,
+		Value:  row.float("rtime") / 1000,
+		Labels: labels,
+		Type:   MetricTypeGauge,
+		Help:   "Average response time of the last 1024 requests",
+		Unit:   "seconds",
+	}
+}
+
+// haproxyStatusUp reports whether a status is usable: UP (including UP
+// while going down), OPEN for frontends, or "no check" for unchecked
+// servers
+func haproxyStatusUp(status string) bool {
+	return strings.HasPrefix(status, "UP") || status == "OPEN" || status == "no check"
+}
+
+// stats reads the statistics from the stats socket or the stats page
+func (hc *HAProxyCollector) stats(ctx context.Context, inst HAProxyInstance) ([]haproxyRow, error) {
+	if isHTTPAddress(inst.Address) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, inst.Address, nil)
+		if err != nil {
+			return nil, err
+		}
+		if inst.Username != "" {
+			req.SetBasicAuth(inst.Username, inst.Password)
+		}
+		resp, err := hc.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(io.Discard, resp.Body)
+			return nil, fmt.Errorf("%s returned %s", inst.Address, resp.Status)
+		}
+		return parseHAProxyCSV(resp.Body)
+	}
+
+	conn, err := dialSocket(ctx, inst.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "show stat\n"); err != nil {
+		return nil, err
+	}
+	return parseHAProxyCSV(conn)
+}
+
+// parseHAProxyCSV parses the statistics CSV, whose header line starts
+// with "# "
+func parseHAProxyCSV(r io.Reader) ([]haproxyRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read haproxy statistics: %w", err)
+	}
+	if len(header) == 0 || !strings.HasPrefix(header[0], "# ") {
+		return nil, fmt.Errorf("unexpected haproxy statistics header: %q", strings.Join(header, ","))
+	}
+	header[0] = strings.TrimPrefix(header[0], "# ")
+
+	var rows []haproxyRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse haproxy statistics: %w", err)
+		}
+		row := make(haproxyRow, len(header))
+		for i, column := range header {
+			if i < len(record) && column != "" {
+				row[column] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
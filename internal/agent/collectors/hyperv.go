@@ -0,0 +1,122 @@
+//go:build windows
+
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// hypervQuery lists VMs as JSON. Enums and time spans are converted in the
+// script so the output does not depend on the PowerShell version.
+const hypervQuery = `ConvertTo-Json -Compress -InputObject @(Get-VM | ForEach-Object { [pscustomobject]@{
+Name = $_.Name; Id = $_.Id.ToString(); State = $_.State.ToString(); CPUUsage = $_.CPUUsage;
+ProcessorCount = $_.ProcessorCount; MemoryAssigned = $_.MemoryAssigned; MemoryDemand = $_.MemoryDemand;
+MemoryStartup = $_.MemoryStartup; Uptime = $_.Uptime.TotalSeconds; Heartbeat = "$($_.Heartbeat)" } })`
+
+var hypervStates = []string{"running", "off", "saved", "paused", "other"}
+
+// HyperVCollector reports the state and resource usage of Hyper-V VMs
+type HyperVCollector struct {
+	*BaseCollector
+}
+
+// NewHyperVCollector creates a new Hyper-V collector
+func NewHyperVCollector(config HyperVCollectorConfig) (*HyperVCollector, error) {
+	return &HyperVCollector{
+		BaseCollector: NewBaseCollector("hyperv", config.Enabled, config.Interval),
+	}, nil
+}
+
+// hypervVM is a VM as listed by hypervQuery
+type hypervVM struct {
+	Name           string  `json:"Name"`
+	ID             string  `json:"Id"`
+	State          string  `json:"State"`
+	CPUUsage       float64 `json:"CPUUsage"`
+	ProcessorCount int     `json:"ProcessorCount"`
+	MemoryAssigned uint64  `json:"MemoryAssigned"`
+	MemoryDemand   uint64  `json:"MemoryDemand"`
+	MemoryStartup  uint64  `json:"MemoryStartup"`
+	Uptime         float64 `json:"Uptime"`
+	Heartbeat      string  `json:"Heartbeat"`
+}
+
+// Collect collects VM metrics
+func (hc *HyperVCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", hypervQuery)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Hyper-V VMs: %w", err)
+	}
+
+	var vms []hypervVM
+	if err := json.Unmarshal(out, &vms); err != nil {
+		return nil, fmt.Errorf("failed to parse Hyper-V VMs: %w", err)
+	}
+
+	metrics := []*Metric{{
+		Name:  "hyperv_vms",
+		Value: float64(len(vms)),
+		Type:  MetricTypeGauge,
+		Help:  "Number of Hyper-V virtual machines",
+	}}
+	for _, vm := range vms {
+		metrics = append(metrics, hypervVMMetrics(vm)...)
+	}
+	return metrics, nil
+}
+
+func hypervVMMetrics(vm hypervVM) []*Metric {
+	labels := map[string]string{"vm": vm.Name, "vm_id": vm.ID}
+	metric := func(name string, value float64, help, unit string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: labels, Type: MetricTypeGauge, Help: help, Unit: unit}
+	}
+
+	state := strings.ToLower(vm.State)
+	if !contains(hypervStates, state) {
+		state = "other"
+	}
+
+	var metrics []*Metric
+	for _, s := range hypervStates {
+		metrics = append(metrics, &Metric{
+			Name:   "hyperv_vm_state",
+			Value:  boolToFloat(s == state),
+			Labels: mergeLabels(labels, map[string]string{"state": s}),
+			Type:   MetricTypeGauge,
+			Help:   "Hyper-V VM state (1 for the current state)",
+		})
+	}
+
+	metrics = append(metrics,
+		metric("hyperv_vm_processors", float64(vm.ProcessorCount), "Virtual processors of the VM", ""),
+		metric("hyperv_vm_memory_startup_bytes", float64(vm.MemoryStartup), "Startup memory of the VM", "bytes"),
+	)
+	if state != "running" {
+		return metrics
+	}
+
+	// Heartbeat is OkApplicationsHealthy, OkApplicationsUnknown, ... while
+	// the guest's integration services respond
+	return append(metrics,
+		metric("hyperv_vm_cpu_usage_percent", vm.CPUUsage, "CPU usage of the VM across its processors", "percent"),
+		metric("hyperv_vm_memory_assigned_bytes", float64(vm.MemoryAssigned), "Memory assigned to the VM", "bytes"),
+		metric("hyperv_vm_memory_demand_bytes", float64(vm.MemoryDemand), "Memory the VM's guest demands", "bytes"),
+		metric("hyperv_vm_uptime_seconds", vm.Uptime, "Time since the VM started", "seconds"),
+		metric("hyperv_vm_heartbeat_ok", boolToFloat(strings.HasPrefix(vm.Heartbeat, "Ok")),
+			"Whether the guest's heartbeat integration service responds", ""),
+	)
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
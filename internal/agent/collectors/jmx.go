@@ -0,0 +1,498 @@
+package collectors
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// JMXCollectorConfig holds configuration for the JMX collector
+type JMXCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Apps     []JMXApp
+}
+
+// JMXApp is a Java application read through a Jolokia endpoint: its own
+// agent, or a Jolokia proxy when Target names a JMX service URL
+type JMXApp struct {
+	Name               string
+	URL                string
+	Username           string
+	Password           string
+	Target             string
+	TargetUsername     string
+	TargetPassword     string
+	Timeout            time.Duration
+	InsecureSkipVerify bool
+	Labels             map[string]string
+	// DisableJVMMetrics skips the java.lang heap, GC, thread, class and
+	// CPU metrics collected from every app
+	DisableJVMMetrics bool
+	Beans             []JMXBean
+}
+
+// JMXBean maps the attributes of an MBean, or of every MBean matching an
+// object name pattern, to metrics
+type JMXBean struct {
+	MBean string
+	// Attributes defaults to all attributes with numeric values
+	Attributes []string
+	// Metric is the metric name prefix, jmx_<domain>_<type> by default
+	Metric string
+	// Type is gauge or counter
+	Type string
+}
+
+// JMXCollector reads MBean attributes of Java applications over the
+// Jolokia HTTP protocol
+type JMXCollector struct {
+	*BaseCollector
+	apps []*jmxApp
+}
+
+type jmxApp struct {
+	config JMXApp
+	client *http.Client
+}
+
+// NewJMXCollector creates a new JMX collector
+func NewJMXCollector(config JMXCollectorConfig) (*JMXCollector, error) {
+	jc := &JMXCollector{
+		BaseCollector: NewBaseCollector("jmx", config.Enabled, config.Interval),
+	}
+	for _, app := range config.Apps {
+		if app.URL == "" {
+			return nil, fmt.Errorf("jmx app %s: url is required", app.Name)
+		}
+		timeout := app.Timeout
+		if timeout == 0 {
+			timeout = 10 * time.Second
+		}
+		jc.apps = append(jc.apps, &jmxApp{
+			config: app,
+			client: &http.Client{
+				Timeout: timeout,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: app.InsecureSkipVerify},
+				},
+			},
+		})
+	}
+	return jc, nil
+}
+
+// Collect collects metrics from every app concurrently, so one slow app
+// does not delay the others
+func (jc *JMXCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	results := make([][]*Metric, len(jc.apps))
+	var wg sync.WaitGroup
+	for i, app := range jc.apps {
+		wg.Add(1)
+		go func(i int, app *jmxApp) {
+			defer wg.Done()
+			results[i] = app.collect(ctx)
+		}(i, app)
+	}
+	wg.Wait()
+
+	var metrics []*Metric
+	for _, r := range results {
+		metrics = append(metrics, r...)
+	}
+	return metrics, nil
+}
+
+// jvmBeans are the platform MBeans read from every app
+var jvmBeans = []JMXBean{
+	{MBean: "java.lang:type=Memory", Attributes: []string{"HeapMemoryUsage", "NonHeapMemoryUsage"}},
+	{MBean: "java.lang:type=GarbageCollector,name=*", Attributes: []string{"CollectionCount", "CollectionTime", "LastGcInfo"}},
+	{MBean: "java.lang:type=Threading", Attributes: []string{"ThreadCount", "DaemonThreadCount", "PeakThreadCount"}},
+	{MBean: "java.lang:type=ClassLoading", Attributes: []string{"LoadedClassCount", "TotalLoadedClassCount", "UnloadedClassCount"}},
+	{MBean: "java.lang:type=Runtime", Attributes: []string{"Uptime"}},
+	{MBean: "java.lang:type=OperatingSystem", Attributes: []string{"ProcessCpuLoad", "OpenFileDescriptorCount", "MaxFileDescriptorCount"}},
+}
+
+// jolokiaRequest is a read request of the Jolokia protocol
+type jolokiaRequest struct {
+	Type      string         `json:"type"`
+	MBean     string         `json:"mbean"`
+	Attribute []string       `json:"attribute,omitempty"`
+	Target    *jolokiaTarget `json:"target,omitempty"`
+	// Config sets processing parameters; ignoreErrors keeps attributes
+	// a JVM does not have from failing the whole read
+	Config map[string]interface{} `json:"config,omitempty"`
+}
+
+type jolokiaTarget struct {
+	URL      string `json:"url"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type jolokiaResponse struct {
+	Status int             `json:"status"`
+	Value  json.RawMessage `json:"value"`
+	Error  string          `json:"error"`
+}
+
+// collect reads all beans of an app in one bulk request. Beans that fail,
+// such as ones the JVM does not have, are counted in jmx_scrape_errors.
+func (a *jmxApp) collect(ctx context.Context) []*Metric {
+	labels := map[string]string{"app": a.config.Name}
+	for k, v := range a.config.Labels {
+		labels[k] = v
+	}
+
+	var beans []JMXBean
+	if !a.config.DisableJVMMetrics {
+		beans = append(beans, jvmBeans...)
+	}
+	beans = append(beans, a.config.Beans...)
+
+	start := time.Now()
+	responses, err := a.read(ctx, beans)
+	metrics := []*Metric{
+		{
+			Name:   "jmx_up",
+			Value:  boolToFloat(err == nil),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Whether the app's Jolokia endpoint answered",
+		},
+		{
+			Name:   "jmx_scrape_duration_seconds",
+			Value:  time.Since(start).Seconds(),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Time taken to read the app's MBeans",
+			Unit:   "seconds",
+		},
+	}
+	if err != nil {
+		return metrics
+	}
+
+	var failed int
+	for i, resp := range responses {
+		if resp.Status != http.StatusOK {
+			failed++
+			continue
+		}
+		values, err := beanValues(beans[i].MBean, resp.Value)
+		if err != nil {
+			failed++
+			continue
+		}
+		if i < len(beans)-len(a.config.Beans) {
+			metrics = append(metrics, jvmMetrics(values, labels)...)
+		} else {
+			metrics = append(metrics, beanMetrics(beans[i], values, labels)...)
+		}
+	}
+
+	return append(metrics, &Metric{
+		Name:   "jmx_scrape_errors",
+		Value:  float64(failed),
+		Labels: labels,
+		Type:   MetricTypeGauge,
+		Help:   "MBeans that could not be read in the last scrape",
+	})
+}
+
+// read sends a bulk read request and returns the responses in request
+// order
+func (a *jmxApp) read(ctx context.Context, beans []JMXBean) ([]jolokiaResponse, error) {
+	var target *jolokiaTarget
+	if a.config.Target != "" {
+		target = &jolokiaTarget{
+			URL:      a.config.Target,
+			User:     a.config.TargetUsername,
+			Password: a.config.TargetPassword,
+		}
+	}
+	requests := make([]jolokiaRequest, len(beans))
+	for i, b := range beans {
+		requests[i] = jolokiaRequest{
+			Type:      "read",
+			MBean:     b.MBean,
+			Attribute: b.Attributes,
+			Target:    target,
+			Config:    map[string]interface{}{"ignoreErrors": true},
+		}
+	}
+	body, err := json.Marshal(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.Username != "" {
+		req.SetBasicAuth(a.config.Username, a.config.Password)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("jolokia returned %s", resp.Status)
+	}
+
+	var responses []jolokiaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&responses); err != nil {
+		return nil, fmt.Errorf("failed to decode jolokia response: %w", err)
+	}
+	if len(responses) != len(beans) {
+		return nil, fmt.Errorf("jolokia returned %d responses for %d requests", len(responses), len(beans))
+	}
+	return responses, nil
+}
+
+// beanValues returns the attribute values of a read response by object
+// name. Pattern reads return values keyed by the matching object names,
+// plain reads the attributes of the one bean.
+func beanValues(mbean string, raw json.RawMessage) (map[string]map[string]interface{}, error) {
+	if isObjectNamePattern(mbean) {
+		var values map[string]map[string]interface{}
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+	var attrs map[string]interface{}
+	if err := json.Unmarshal(raw, &attrs); err != nil {
+		return nil, err
+	}
+	return map[string]map[string]interface{}{mbean: attrs}, nil
+}
+
+// beanMetrics maps the attribute values of a configured bean to metrics.
+// Composite values yield one metric per numeric key, and the object name
+// keys the pattern leaves open become labels.
+func beanMetrics(bean JMXBean, values map[string]map[string]interface{}, base map[string]string) []*Metric {
+	prefix := bean.Metric
+	if prefix == "" {
+		domain, keys := parseObjectName(bean.MBean)
+		prefix = "jmx_" + metricName(domain)
+		if t := keys["type"]; t != "" && !strings.ContainsAny(t, "*?") {
+			prefix += "_" + metricName(t)
+		}
+	}
+	metricType := MetricTypeGauge
+	if bean.Type == "counter" {
+		metricType = MetricTypeCounter
+	}
+	_, patternKeys := parseObjectName(bean.MBean)
+
+	var metrics []*Metric
+	for _, name := range sortedKeys(values) {
+		labels := objectNameLabels(name, patternKeys, base)
+		attrs := values[name]
+		for _, attr := range sortedKeys(attrs) {
+			for suffix, v := range numericValues(attrs[attr]) {
+				metrics = append(metrics, &Metric{
+					Name:   prefix + "_" + metricName(attr) + suffix,
+					Value:  v,
+					Labels: labels,
+					Type:   metricType,
+					Help:   fmt.Sprintf("JMX attribute %s of %s", attr, bean.MBean),
+				})
+			}
+		}
+	}
+	return metrics
+}
+
+// objectNameLabels returns the labels of an MBean: the base labels plus
+// its object name keys that the pattern does not fix
+func objectNameLabels(name string, patternKeys, base map[string]string) map[string]string {
+	labels := make(map[string]string, len(base)+2)
+	for k, v := range base {
+		labels[k] = v
+	}
+	_, keys := parseObjectName(name)
+	for k, v := range keys {
+		if p, ok := patternKeys[k]; ok && !strings.ContainsAny(p, "*?") {
+			continue
+		}
+		labels[metricName(k)] = v
+	}
+	return labels
+}
+
+// jvmMetrics maps the platform MBeans to metrics with fixed names
+func jvmMetrics(values map[string]map[string]interface{}, base map[string]string) []*Metric {
+	var metrics []*Metric
+	gauge := func(name string, v interface{}, scale float64, labels map[string]string, help, unit string) {
+		if f, ok := v.(float64); ok && f >= 0 {
+			metrics = append(metrics, &Metric{Name: name, Value: f * scale, Labels: labels, Type: MetricTypeGauge, Help: help, Unit: unit})
+		}
+	}
+	counter := func(name string, v interface{}, scale float64, labels map[string]string, help, unit string) {
+		if f, ok := v.(float64); ok && f >= 0 {
+			metrics = append(metrics, &Metric{Name: name, Value: f * scale, Labels: labels, Type: MetricTypeCounter, Help: help, Unit: unit})
+		}
+	}
+
+	for name, attrs := range values {
+		domain, keys := parseObjectName(name)
+		if domain != "java.lang" {
+			continue
+		}
+		switch keys["type"] {
+		case "Memory":
+			for attr, area := range map[string]string{"HeapMemoryUsage": "heap", "NonHeapMemoryUsage": "nonheap"} {
+				usage, _ := attrs[attr].(map[string]interface{})
+				labels := withLabel(base, "area", area)
+				gauge("jvm_memory_used_bytes", usage["used"], 1, labels, "JVM memory in use", "bytes")
+				gauge("jvm_memory_committed_bytes", usage["committed"], 1, labels, "JVM memory committed by the OS", "bytes")
+				gauge("jvm_memory_max_bytes", usage["max"], 1, labels, "Maximum JVM memory", "bytes")
+			}
+		case "GarbageCollector":
+			labels := withLabel(base, "gc", keys["name"])
+			counter("jvm_gc_collections_total", attrs["CollectionCount"], 1, labels, "Garbage collections", "")
+			counter("jvm_gc_collection_seconds_total", attrs["CollectionTime"], 1e-3, labels, "Time spent in garbage collection", "seconds")
+			// LastGcInfo is only exposed by HotSpot collectors and is null
+			// until the first collection
+			if info, ok := attrs["LastGcInfo"].(map[string]interface{}); ok {
+				gauge("jvm_gc_last_pause_seconds", info["duration"], 1e-3, labels, "Duration of the last garbage collection", "seconds")
+			}
+		case "Threading":
+			gauge("jvm_threads", attrs["ThreadCount"], 1, base, "Live threads", "")
+			gauge("jvm_threads_daemon", attrs["DaemonThreadCount"], 1, base, "Live daemon threads", "")
+			gauge("jvm_threads_peak", attrs["PeakThreadCount"], 1, base, "Peak live threads since the JVM started", "")
+		case "ClassLoading":
+			gauge("jvm_classes_loaded", attrs["LoadedClassCount"], 1, base, "Classes currently loaded", "")
+			counter("jvm_classes_loaded_total", attrs["TotalLoadedClassCount"], 1, base, "Classes loaded since the JVM started", "")
+			counter("jvm_classes_unloaded_total", attrs["UnloadedClassCount"], 1, base, "Classes unloaded since the JVM started", "")
+		case "Runtime":
+			gauge("jvm_uptime_seconds", attrs["Uptime"], 1e-3, base, "Time since the JVM started", "seconds")
+		case "OperatingSystem":
+			// ProcessCpuLoad is -1 until the JVM has a sample
+			gauge("jvm_cpu_usage_percent", attrs["ProcessCpuLoad"], 100, base, "CPU usage of the JVM process", "percent")
+			gauge("jvm_open_fds", attrs["OpenFileDescriptorCount"], 1, base, "Open file descriptors of the JVM process", "")
+			gauge("jvm_max_fds", attrs["MaxFileDescriptorCount"], 1, base, "File descriptor limit of the JVM process", "")
+		}
+	}
+	return metrics
+}
+
+// numericValues flattens an attribute value into its numeric parts, keyed
+// by metric name suffix. Booleans count as 0 or 1; strings and arrays are
+// skipped.
+func numericValues(v interface{}) map[string]float64 {
+	out := make(map[string]float64)
+	var walk func(suffix string, v interface{}, depth int)
+	walk = func(suffix string, v interface{}, depth int) {
+		switch v := v.(type) {
+		case float64:
+			out[suffix] = v
+		case bool:
+			out[suffix] = boolToFloat(v)
+		case map[string]interface{}:
+			if depth == 2 {
+				return
+			}
+			for k, child := range v {
+				walk(suffix+"_"+metricName(k), child, depth+1)
+			}
+		}
+	}
+	walk("", v, 0)
+	return out
+}
+
+// isObjectNamePattern reports whether an object name matches several
+// MBeans
+func isObjectNamePattern(name string) bool {
+	return strings.ContainsAny(name, "*?")
+}
+
+// parseObjectName splits an object name into its domain and key
+// properties. Quoted values may contain commas.
+func parseObjectName(name string) (string, map[string]string) {
+	domain, props, _ := strings.Cut(name, ":")
+	keys := make(map[string]string)
+
+	var (
+		part    strings.Builder
+		quoted  bool
+		escaped bool
+		parts   []string
+	)
+	for _, c := range props {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, part.String())
+			part.Reset()
+			continue
+		}
+		part.WriteRune(c)
+	}
+	parts = append(parts, part.String())
+
+	for _, p := range parts {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			keys[k] = strings.Trim(v, `"`)
+		}
+	}
+	return domain, keys
+}
+
+// metricName converts a JMX name such as HeapMemoryUsage or
+// kafka.server to snake case (heap_memory_usage, kafka_server)
+func metricName(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			// Split before an upper case letter that starts a word, so
+			// HTTPRequests becomes http_requests
+			if i > 0 && b.Len() > 0 && !strings.HasSuffix(b.String(), "_") &&
+				(unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+					(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "_") {
+				b.WriteByte('_')
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
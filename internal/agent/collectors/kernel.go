@@ -0,0 +1,231 @@
+package collectors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// KernelCollector tracks running vs. installed kernel versions and whether
+// the node is waiting for a reboot
+type KernelCollector struct {
+	*BaseCollector
+	rebootFile  string
+	modulesDirs []string
+
+	inventory   map[string]string
+	inventoryMu sync.RWMutex
+}
+
+// KernelCollectorConfig holds configuration for the kernel collector
+type KernelCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+}
+
+// NewKernelCollector creates a new kernel/reboot collector
+func NewKernelCollector(config KernelCollectorConfig) (*KernelCollector, error) {
+	return &KernelCollector{
+		BaseCollector: NewBaseCollector("kernel", config.Enabled, config.Interval),
+		rebootFile:    "/var/run/reboot-required",
+		modulesDirs:   []string{"/lib/modules", "/usr/lib/modules"},
+		inventory:     make(map[string]string),
+	}, nil
+}
+
+// Collect collects kernel version and reboot-required metrics
+func (kc *KernelCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	running, err := host.KernelVersionWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	installed, installedAt := kc.latestInstalledKernel()
+	if installed == "" {
+		installed = running
+	}
+
+	kernelPending := compareVersions(installed, running) > 0
+
+	// The distro flag file wins; otherwise a newer installed kernel means
+	// the node needs a reboot since that kernel was installed.
+	var (
+		rebootRequired bool
+		since          time.Time
+	)
+	if info, err := os.Stat(kc.rebootFile); err == nil {
+		rebootRequired = true
+		since = info.ModTime()
+	} else if kernelPending {
+		rebootRequired = true
+		since = installedAt
+	}
+
+	var pendingSeconds float64
+	if rebootRequired && !since.IsZero() {
+		pendingSeconds = time.Since(since).Seconds()
+	}
+
+	kc.updateInventory(running, installed, kernelPending, rebootRequired, since)
+
+	return []*Metric{
+		{
+			Name:  "system_reboot_required",
+			Value: boolToFloat(rebootRequired),
+			Type:  MetricTypeGauge,
+			Help:  "Whether the node is waiting for a reboot (1) or not (0)",
+		},
+		{
+			Name:  "system_reboot_required_seconds",
+			Value: pendingSeconds,
+			Type:  MetricTypeGauge,
+			Help:  "Seconds since a reboot became required",
+			Unit:  "seconds",
+		},
+		{
+			Name:  "system_kernel_update_pending",
+			Value: boolToFloat(kernelPending),
+			Type:  MetricTypeGauge,
+			Help:  "Whether a newer kernel is installed than the one running",
+		},
+		{
+			Name:  "system_kernel_info",
+			Value: 1,
+			Labels: map[string]string{
+				"running":   running,
+				"installed": installed,
+			},
+			Type: MetricTypeGauge,
+			Help: "Running and newest installed kernel versions",
+		},
+	}, nil
+}
+
+// Inventory returns kernel and reboot details from the last collection
+func (kc *KernelCollector) Inventory() map[string]string {
+	kc.inventoryMu.RLock()
+	defer kc.inventoryMu.RUnlock()
+
+	inventory := make(map[string]string, len(kc.inventory))
+	for k, v := range kc.inventory {
+		inventory[k] = v
+	}
+	return inventory
+}
+
+func (kc *KernelCollector) updateInventory(running, installed string, kernelPending, rebootRequired bool, since time.Time) {
+	inventory := map[string]string{
+		models.InventoryKernelRunning:       running,
+		models.InventoryKernelInstalled:     installed,
+		models.InventoryKernelUpdatePending: strconv.FormatBool(kernelPending),
+		models.InventoryRebootRequired:      strconv.FormatBool(rebootRequired),
+	}
+
+	if rebootRequired && !since.IsZero() {
+		inventory[models.InventoryRebootRequiredSince] = since.UTC().Format(time.RFC3339)
+	}
+
+	// Debian/Ubuntu list the packages that triggered the reboot flag
+	if data, err := os.ReadFile(kc.rebootFile + ".pkgs"); err == nil {
+		pkgs := strings.Fields(string(data))
+		sort.Strings(pkgs)
+		inventory[models.InventoryRebootRequiredPackages] = strings.Join(pkgs, ",")
+	}
+
+	kc.inventoryMu.Lock()
+	kc.inventory = inventory
+	kc.inventoryMu.Unlock()
+}
+
+// latestInstalledKernel returns the newest kernel found in the modules
+// directories along with its installation time
+func (kc *KernelCollector) latestInstalledKernel() (string, time.Time) {
+	var (
+		latest      string
+		installedAt time.Time
+	)
+
+	for _, dir := range kc.modulesDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+
+			// Skip leftovers of removed kernels that only keep extra modules
+			if _, err := os.Stat(filepath.Join(dir, entry.Name(), "modules.dep")); err != nil {
+				continue
+			}
+
+			if latest == "" || compareVersions(entry.Name(), latest) > 0 {
+				latest = entry.Name()
+				if info, err := entry.Info(); err == nil {
+					installedAt = info.ModTime()
+				}
+			}
+		}
+	}
+
+	return latest, installedAt
+}
+
+// compareVersions compares two kernel version strings chunk by chunk,
+// treating digit runs numerically. It returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	ca, cb := splitVersion(a), splitVersion(b)
+
+	for i := 0; i < len(ca) && i < len(cb); i++ {
+		na, errA := strconv.Atoi(ca[i])
+		nb, errB := strconv.Atoi(cb[i])
+
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case ca[i] != cb[i]:
+			if ca[i] < cb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(ca) < len(cb):
+		return -1
+	case len(ca) > len(cb):
+		return 1
+	}
+	return 0
+}
+
+// splitVersion splits "5.15.0-91-generic" into ["5" "15" "0" "91" "generic"]
+func splitVersion(v string) []string {
+	return strings.FieldsFunc(v, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
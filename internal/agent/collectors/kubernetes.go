@@ -1,13 +1,650 @@
 package collectors
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
-// KubernetesCollector collects Kubernetes metrics
+	"github.com/meettoy2004/lnmonja/internal/kube"
+)
+
+// Kubernetes collector scopes. In node scope, as a DaemonSet, the agent
+// reports its own node and pods; in cluster scope, as a single replica,
+// every node and pod, and deployments.
+const (
+	KubernetesScopeNode    = "node"
+	KubernetesScopeCluster = "cluster"
+)
+
+// Sources of pod resource usage
+const (
+	KubernetesUsageKubelet       = "kubelet"
+	KubernetesUsageMetricsServer = "metrics-server"
+)
+
+// KubernetesCollectorConfig holds configuration for the Kubernetes
+// collector. Without a Kubeconfig it uses the service account of the pod
+// it runs in. Namespaces limits the pods and deployments reported; empty
+// reports every namespace.
+type KubernetesCollectorConfig struct {
+	Enabled     bool
+	Interval    time.Duration
+	Kubeconfig  string
+	Context     string
+	Scope       string
+	NodeName    string
+	Namespaces  []string
+	UsageSource string
+	Timeout     time.Duration
+}
+
+// kubernetesTimeout is used when no timeout is configured
+const kubernetesTimeout = 10 * time.Second
+
+// podPhases are the phases a pod can be in, all reported so counts drop
+// to zero
+var podPhases = []string{"Pending", "Running", "Succeeded", "Failed", "Unknown"}
+
+// KubernetesCollector reports pod and container CPU and memory usage from
+// the kubelet summary API or metrics-server, pod counts by phase,
+// container restarts, node conditions and deployment replica availability
+// through the Kubernetes API
 type KubernetesCollector struct {
 	*BaseCollector
+	config     KubernetesCollectorConfig
+	api        *kube.Client
+	namespaces map[string]bool
+}
+
+// NewKubernetesCollector creates a new Kubernetes collector
+func NewKubernetesCollector(config KubernetesCollectorConfig) (*KubernetesCollector, error) {
+	if config.Scope == "" {
+		config.Scope = KubernetesScopeNode
+	}
+	if config.UsageSource == "" {
+		config.UsageSource = KubernetesUsageKubelet
+	}
+	if config.Timeout == 0 {
+		config.Timeout = kubernetesTimeout
+	}
+	if config.Scope == KubernetesScopeNode && config.NodeName == "" {
+		config.NodeName = os.Getenv("NODE_NAME")
+		if config.NodeName == "" {
+			config.NodeName, _ = os.Hostname()
+		}
+	}
+
+	clientConfig := kube.Config{TokenFile: kube.ServiceAccountToken, CAFile: kube.ServiceAccountCA}
+	if config.Kubeconfig != "" {
+		var err error
+		if clientConfig, err = kube.LoadKubeconfig(config.Kubeconfig, config.Context); err != nil {
+			return nil, err
+		}
+	}
+	api, err := kube.NewClient(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes API client: %w", err)
+	}
+
+	kc := &KubernetesCollector{
+		BaseCollector: NewBaseCollector("kubernetes", config.Enabled, config.Interval),
+		config:        config,
+		api:           api,
+	}
+	if len(config.Namespaces) > 0 {
+		kc.namespaces = make(map[string]bool, len(config.Namespaces))
+		for _, ns := range config.Namespaces {
+			kc.namespaces[ns] = true
+		}
+	}
+	return kc, nil
 }
 
-// Collect collects Kubernetes metrics
+// k8sPod is the subset of a pod the collector uses
+type k8sPod struct {
+	Metadata kube.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+	Status struct {
+		Phase             string `json:"phase"`
+		ContainerStatuses []struct {
+			Name         string `json:"name"`
+			Ready        bool   `json:"ready"`
+			RestartCount int    `json:"restartCount"`
+		} `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+// k8sNode is the subset of a node the collector uses
+type k8sNode struct {
+	Metadata kube.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Unschedulable bool `json:"unschedulable"`
+	} `json:"spec"`
+	Status struct {
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// k8sDeployment is the subset of a deployment the collector uses
+type k8sDeployment struct {
+	Metadata kube.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Replicas *int `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		Replicas            int `json:"replicas"`
+		ReadyReplicas       int `json:"readyReplicas"`
+		AvailableReplicas   int `json:"availableReplicas"`
+		UnavailableReplicas int `json:"unavailableReplicas"`
+		UpdatedReplicas     int `json:"updatedReplicas"`
+	} `json:"status"`
+}
+
+// kubeletSummary is the subset of the kubelet /stats/summary response the
+// collector uses
+type kubeletSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		CPU        *summaryCPU    `json:"cpu"`
+		Memory     *summaryMemory `json:"memory"`
+		Containers []struct {
+			Name   string         `json:"name"`
+			CPU    *summaryCPU    `json:"cpu"`
+			Memory *summaryMemory `json:"memory"`
+		} `json:"containers"`
+		Network *struct {
+			RxBytes *uint64 `json:"rxBytes"`
+			TxBytes *uint64 `json:"txBytes"`
+		} `json:"network"`
+	} `json:"pods"`
+}
+
+type summaryCPU struct {
+	UsageNanoCores *uint64 `json:"usageNanoCores"`
+}
+
+type summaryMemory struct {
+	WorkingSetBytes *uint64 `json:"workingSetBytes"`
+}
+
+// podMetricsList is the subset of a metrics-server pod list the collector
+// uses
+type podMetricsList struct {
+	Items []struct {
+		Metadata   kube.ObjectMeta `json:"metadata"`
+		Containers []struct {
+			Name  string            `json:"name"`
+			Usage map[string]string `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// Collect collects pod, node and deployment metrics. A failing part is
+// skipped unless every part fails.
 func (kc *KubernetesCollector) Collect(ctx context.Context) ([]*Metric, error) {
-	return make([]*Metric, 0), nil
+	ctx, cancel := context.WithTimeout(ctx, kc.config.Timeout)
+	defer cancel()
+
+	var metrics []*Metric
+	var errs []string
+
+	pods, err := kc.listPods(ctx)
+	if err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		metrics = append(metrics, kc.podMetrics(pods)...)
+	}
+
+	nodes, err := kc.listNodes(ctx)
+	if err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		metrics = append(metrics, nodeMetrics(nodes)...)
+	}
+
+	usage, err := kc.usageMetrics(ctx, nodes, pods)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	metrics = append(metrics, usage...)
+
+	if kc.config.Scope == KubernetesScopeCluster {
+		deployments, err := kc.listDeployments(ctx)
+		if err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			metrics = append(metrics, deploymentMetrics(deployments)...)
+		}
+	}
+
+	if len(metrics) == 0 && len(errs) > 0 {
+		return nil, fmt.Errorf("kubernetes: %s", strings.Join(errs, "; "))
+	}
+	return metrics, nil
+}
+
+// listPods lists the pods in scope, from each configured namespace
+func (kc *KubernetesCollector) listPods(ctx context.Context) ([]*k8sPod, error) {
+	query := ""
+	if kc.config.Scope == KubernetesScopeNode {
+		query = "?fieldSelector=" + url.QueryEscape("spec.nodeName="+kc.config.NodeName)
+	}
+
+	var pods []*k8sPod
+	for _, prefix := range kc.namespacePaths("/api/v1") {
+		var list struct {
+			Items []*k8sPod `json:"items"`
+		}
+		if err := kc.api.Get(ctx, prefix+"pods"+query, &list); err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
+		pods = append(pods, list.Items...)
+	}
+	return pods, nil
+}
+
+// listNodes lists every node, or only the agent's in node scope
+func (kc *KubernetesCollector) listNodes(ctx context.Context) ([]*k8sNode, error) {
+	if kc.config.Scope == KubernetesScopeNode {
+		var node k8sNode
+		if err := kc.api.Get(ctx, "/api/v1/nodes/"+url.PathEscape(kc.config.NodeName), &node); err != nil {
+			return nil, fmt.Errorf("failed to get node %s: %w", kc.config.NodeName, err)
+		}
+		return []*k8sNode{&node}, nil
+	}
+
+	var list struct {
+		Items []*k8sNode `json:"items"`
+	}
+	if err := kc.api.Get(ctx, "/api/v1/nodes", &list); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	return list.Items, nil
+}
+
+// listDeployments lists the deployments of each configured namespace
+func (kc *KubernetesCollector) listDeployments(ctx context.Context) ([]*k8sDeployment, error) {
+	var deployments []*k8sDeployment
+	for _, prefix := range kc.namespacePaths("/apis/apps/v1") {
+		var list struct {
+			Items []*k8sDeployment `json:"items"`
+		}
+		if err := kc.api.Get(ctx, prefix+"deployments", &list); err != nil {
+			return nil, fmt.Errorf("failed to list deployments: %w", err)
+		}
+		deployments = append(deployments, list.Items...)
+	}
+	return deployments, nil
+}
+
+// namespacePaths returns the path prefixes of the configured namespaces
+// under an API group, or the cluster-wide one
+func (kc *KubernetesCollector) namespacePaths(group string) []string {
+	if len(kc.config.Namespaces) == 0 {
+		return []string{group + "/"}
+	}
+	paths := make([]string, 0, len(kc.config.Namespaces))
+	for _, ns := range kc.config.Namespaces {
+		paths = append(paths, group+"/namespaces/"+url.PathEscape(ns)+"/")
+	}
+	return paths
+}
+
+// podMetrics counts pods by namespace and phase and reports container
+// restarts and readiness
+func (kc *KubernetesCollector) podMetrics(pods []*k8sPod) []*Metric {
+	type phaseKey struct{ namespace, phase string }
+	phases := make(map[phaseKey]int)
+	namespaces := make(map[string]bool)
+	for _, ns := range kc.config.Namespaces {
+		namespaces[ns] = true
+	}
+
+	var metrics []*Metric
+	for _, pod := range pods {
+		ns := pod.Metadata.Namespace
+		namespaces[ns] = true
+		phase := pod.Status.Phase
+		if phase == "" {
+			phase = "Unknown"
+		}
+		phases[phaseKey{ns, phase}]++
+
+		for _, c := range pod.Status.ContainerStatuses {
+			labels := map[string]string{
+				"k8s_namespace": ns,
+				"k8s_pod":       pod.Metadata.Name,
+				"k8s_container": c.Name,
+				"k8s_node":      pod.Spec.NodeName,
+			}
+			metrics = append(metrics,
+				&Metric{
+					Name:   "k8s_container_restarts_total",
+					Value:  float64(c.RestartCount),
+					Labels: labels,
+					Type:   MetricTypeCounter,
+					Help:   "Times the container was restarted",
+				},
+				&Metric{
+					Name:   "k8s_container_ready",
+					Value:  boolToFloat(c.Ready),
+					Labels: labels,
+					Type:   MetricTypeGauge,
+					Help:   "Whether the container passes its readiness probe",
+				},
+			)
+		}
+	}
+
+	for ns := range namespaces {
+		for _, phase := range podPhases {
+			metrics = append(metrics, &Metric{
+				Name:   "k8s_pods",
+				Value:  float64(phases[phaseKey{ns, phase}]),
+				Labels: map[string]string{"k8s_namespace": ns, "phase": phase},
+				Type:   MetricTypeGauge,
+				Help:   "Pods by namespace and phase",
+			})
+		}
+	}
+	return metrics
+}
+
+// nodeMetrics reports each node condition as 1 when true, 0 when false
+// and -1 when unknown
+func nodeMetrics(nodes []*k8sNode) []*Metric {
+	var metrics []*Metric
+	for _, node := range nodes {
+		for _, cond := range node.Status.Conditions {
+			value := -1.0
+			switch cond.Status {
+			case "True":
+				value = 1
+			case "False":
+				value = 0
+			}
+			metrics = append(metrics, &Metric{
+				Name:   "k8s_node_condition",
+				Value:  value,
+				Labels: map[string]string{"k8s_node": node.Metadata.Name, "condition": cond.Type},
+				Type:   MetricTypeGauge,
+				Help:   "Node condition: 1 true, 0 false, -1 unknown",
+			})
+		}
+		metrics = append(metrics, &Metric{
+			Name:   "k8s_node_unschedulable",
+			Value:  boolToFloat(node.Spec.Unschedulable),
+			Labels: map[string]string{"k8s_node": node.Metadata.Name},
+			Type:   MetricTypeGauge,
+			Help:   "Whether the node is cordoned",
+		})
+	}
+	return metrics
+}
+
+// deploymentMetrics reports desired, available and unavailable replicas
+func deploymentMetrics(deployments []*k8sDeployment) []*Metric {
+	var metrics []*Metric
+	for _, d := range deployments {
+		desired := 1
+		if d.Spec.Replicas != nil {
+			desired = *d.Spec.Replicas
+		}
+		labels := map[string]string{
+			"k8s_namespace":  d.Metadata.Namespace,
+			"k8s_deployment": d.Metadata.Name,
+		}
+		metrics = append(metrics,
+			&Metric{
+				Name:   "k8s_deployment_replicas_desired",
+				Value:  float64(desired),
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Replicas the deployment asks for",
+			},
+			&Metric{
+				Name:   "k8s_deployment_replicas_available",
+				Value:  float64(d.Status.AvailableReplicas),
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Replicas ready for at least the deployment's minReadySeconds",
+			},
+			&Metric{
+				Name:   "k8s_deployment_replicas_unavailable",
+				Value:  float64(d.Status.UnavailableReplicas),
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Replicas the deployment still needs to be fully available",
+			},
+			&Metric{
+				Name:   "k8s_deployment_replicas_updated",
+				Value:  float64(d.Status.UpdatedReplicas),
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Replicas running the deployment's current template",
+			},
+		)
+	}
+	return metrics
+}
+
+// usageMetrics reports pod and container CPU and memory usage from the
+// configured source
+func (kc *KubernetesCollector) usageMetrics(ctx context.Context, nodes []*k8sNode, pods []*k8sPod) ([]*Metric, error) {
+	if kc.config.UsageSource == KubernetesUsageMetricsServer {
+		return kc.metricsServerUsage(ctx, pods)
+	}
+
+	var metrics []*Metric
+	var errs []string
+	for _, node := range nodes {
+		m, err := kc.kubeletUsage(ctx, node.Metadata.Name)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		metrics = append(metrics, m...)
+	}
+	if len(errs) > 0 {
+		return metrics, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return metrics, nil
+}
+
+// kubeletUsage reads a node's kubelet summary through the API server
+func (kc *KubernetesCollector) kubeletUsage(ctx context.Context, node string) ([]*Metric, error) {
+	var summary kubeletSummary
+	if err := kc.api.Get(ctx, "/api/v1/nodes/"+url.PathEscape(node)+"/proxy/stats/summary", &summary); err != nil {
+		return nil, fmt.Errorf("failed to read the kubelet summary of %s: %w", node, err)
+	}
+
+	var metrics []*Metric
+	for _, pod := range summary.Pods {
+		ns := pod.PodRef.Namespace
+		if kc.namespaces != nil && !kc.namespaces[ns] {
+			continue
+		}
+		podLabels := map[string]string{"k8s_namespace": ns, "k8s_pod": pod.PodRef.Name, "k8s_node": node}
+		metrics = append(metrics, usageMetric("k8s_pod", podLabels, pod.CPU, pod.Memory)...)
+		if pod.Network != nil {
+			if pod.Network.RxBytes != nil {
+				metrics = append(metrics, &Metric{
+					Name:   "k8s_pod_network_receive_bytes_total",
+					Value:  float64(*pod.Network.RxBytes),
+					Labels: podLabels,
+					Type:   MetricTypeCounter,
+					Help:   "Bytes received by the pod's default interface",
+					Unit:   "bytes",
+				})
+			}
+			if pod.Network.TxBytes != nil {
+				metrics = append(metrics, &Metric{
+					Name:   "k8s_pod_network_transmit_bytes_total",
+					Value:  float64(*pod.Network.TxBytes),
+					Labels: podLabels,
+					Type:   MetricTypeCounter,
+					Help:   "Bytes sent by the pod's default interface",
+					Unit:   "bytes",
+				})
+			}
+		}
+
+		for _, c := range pod.Containers {
+			labels := map[string]string{"k8s_namespace": ns, "k8s_pod": pod.PodRef.Name, "k8s_container": c.Name, "k8s_node": node}
+			metrics = append(metrics, usageMetric("k8s_container", labels, c.CPU, c.Memory)...)
+		}
+	}
+	return metrics, nil
+}
+
+// usageMetric reports the CPU and working set of a pod or container
+func usageMetric(prefix string, labels map[string]string, cpu *summaryCPU, memory *summaryMemory) []*Metric {
+	var metrics []*Metric
+	if cpu != nil && cpu.UsageNanoCores != nil {
+		metrics = append(metrics, &Metric{
+			Name:   prefix + "_cpu_usage_cores",
+			Value:  float64(*cpu.UsageNanoCores) / 1e9,
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "CPU cores in use",
+		})
+	}
+	if memory != nil && memory.WorkingSetBytes != nil {
+		metrics = append(metrics, &Metric{
+			Name:   prefix + "_memory_working_set_bytes",
+			Value:  float64(*memory.WorkingSetBytes),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Memory in use that cannot be reclaimed",
+			Unit:   "bytes",
+		})
+	}
+	return metrics
+}
+
+// metricsServerUsage reads container usage from metrics-server and sums
+// it per pod. In node scope, only the pods listed on the node are kept.
+func (kc *KubernetesCollector) metricsServerUsage(ctx context.Context, pods []*k8sPod) ([]*Metric, error) {
+	var onNode map[string]string
+	if kc.config.Scope == KubernetesScopeNode {
+		onNode = make(map[string]string, len(pods))
+		for _, pod := range pods {
+			onNode[pod.Metadata.Namespace+"/"+pod.Metadata.Name] = pod.Spec.NodeName
+		}
+	}
+
+	var metrics []*Metric
+	for _, prefix := range kc.namespacePaths("/apis/metrics.k8s.io/v1beta1") {
+		var list podMetricsList
+		if err := kc.api.Get(ctx, prefix+"pods", &list); err != nil {
+			return metrics, fmt.Errorf("failed to read pod metrics from metrics-server: %w", err)
+		}
+
+		for _, item := range list.Items {
+			ns, name := item.Metadata.Namespace, item.Metadata.Name
+			if onNode != nil {
+				if _, ok := onNode[ns+"/"+name]; !ok {
+					continue
+				}
+			}
+
+			var podCPU, podMemory float64
+			for _, c := range item.Containers {
+				cpu, cpuErr := parseQuantity(c.Usage["cpu"])
+				memory, memErr := parseQuantity(c.Usage["memory"])
+				labels := map[string]string{"k8s_namespace": ns, "k8s_pod": name, "k8s_container": c.Name}
+				if node := onNode[ns+"/"+name]; node != "" {
+					labels["k8s_node"] = node
+				}
+				if cpuErr == nil {
+					podCPU += cpu
+					metrics = append(metrics, &Metric{
+						Name:   "k8s_container_cpu_usage_cores",
+						Value:  cpu,
+						Labels: labels,
+						Type:   MetricTypeGauge,
+						Help:   "CPU cores in use",
+					})
+				}
+				if memErr == nil {
+					podMemory += memory
+					metrics = append(metrics, &Metric{
+						Name:   "k8s_container_memory_working_set_bytes",
+						Value:  memory,
+						Labels: labels,
+						Type:   MetricTypeGauge,
+						Help:   "Memory in use that cannot be reclaimed",
+						Unit:   "bytes",
+					})
+				}
+			}
+
+			labels := map[string]string{"k8s_namespace": ns, "k8s_pod": name}
+			if node := onNode[ns+"/"+name]; node != "" {
+				labels["k8s_node"] = node
+			}
+			metrics = append(metrics,
+				&Metric{
+					Name:   "k8s_pod_cpu_usage_cores",
+					Value:  podCPU,
+					Labels: labels,
+					Type:   MetricTypeGauge,
+					Help:   "CPU cores in use",
+				},
+				&Metric{
+					Name:   "k8s_pod_memory_working_set_bytes",
+					Value:  podMemory,
+					Labels: labels,
+					Type:   MetricTypeGauge,
+					Help:   "Memory in use that cannot be reclaimed",
+					Unit:   "bytes",
+				},
+			)
+		}
+	}
+	return metrics, nil
+}
+
+// quantitySuffixes are the multipliers of Kubernetes resource quantity
+// suffixes
+var quantitySuffixes = map[string]float64{
+	"n": 1e-9, "u": 1e-6, "m": 1e-3,
+	"k": 1e3, "M": 1e6, "G": 1e9, "T": 1e12, "P": 1e15, "E": 1e18,
+	"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30, "Ti": 1 << 40, "Pi": 1 << 50, "Ei": 1 << 60,
+}
+
+// parseQuantity parses a resource quantity such as 250m, 128974848 or
+// 512Mi
+func parseQuantity(s string) (float64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty quantity")
+	}
+	number, multiplier := s, 1.0
+	for _, n := range []int{2, 1} {
+		if len(s) <= n {
+			continue
+		}
+		if m, ok := quantitySuffixes[s[len(s)-n:]]; ok {
+			number, multiplier = s[:len(s)-n], m
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	return v * multiplier, nil
 }
@@ -0,0 +1,352 @@
+package collectors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LimitsCollector tracks how close the host is to kernel resource limits:
+// file descriptors, PIDs, conntrack entries and entropy
+type LimitsCollector struct {
+	*BaseCollector
+	procPath     string
+	topProcesses int
+}
+
+// LimitsCollectorConfig holds configuration for the limits collector
+type LimitsCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	// TopProcesses is the number of processes with the most open FDs to report
+	TopProcesses int
+}
+
+// limitSysctls are reported as system_sysctl{name=...}
+var limitSysctls = []string{
+	"fs.file-max",
+	"fs.nr_open",
+	"fs.inotify.max_user_watches",
+	"fs.inotify.max_user_instances",
+	"kernel.pid_max",
+	"kernel.threads-max",
+	"net.core.somaxconn",
+	"net.ipv4.ip_local_port_range",
+	"net.netfilter.nf_conntrack_max",
+	"vm.max_map_count",
+}
+
+// NewLimitsCollector creates a new kernel limits collector
+func NewLimitsCollector(config LimitsCollectorConfig) (*LimitsCollector, error) {
+	return &LimitsCollector{
+		BaseCollector: NewBaseCollector("limits", config.Enabled, config.Interval),
+		procPath:      "/proc",
+		topProcesses:  config.TopProcesses,
+	}, nil
+}
+
+// Collect collects kernel limit metrics
+func (lc *LimitsCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	var metrics []*Metric
+
+	metrics = append(metrics, lc.collectFileDescriptors()...)
+	metrics = append(metrics, lc.collectEntropy()...)
+	metrics = append(metrics, lc.collectPIDs()...)
+	metrics = append(metrics, lc.collectConntrack()...)
+	metrics = append(metrics, lc.collectSysctls()...)
+
+	if lc.topProcesses > 0 {
+		metrics = append(metrics, lc.collectProcessFDs(ctx)...)
+	}
+
+	return metrics, nil
+}
+
+// collectFileDescriptors reads /proc/sys/fs/file-nr ("allocated unused max")
+func (lc *LimitsCollector) collectFileDescriptors() []*Metric {
+	data, err := os.ReadFile(filepath.Join(lc.procPath, "sys/fs/file-nr"))
+	if err != nil {
+		return nil
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 {
+		return nil
+	}
+
+	allocated, _ := strconv.ParseFloat(fields[0], 64)
+	unused, _ := strconv.ParseFloat(fields[1], 64)
+	max, _ := strconv.ParseFloat(fields[2], 64)
+	open := allocated - unused
+
+	metrics := []*Metric{
+		{
+			Name:  "system_fd_open",
+			Value: open,
+			Type:  MetricTypeGauge,
+			Help:  "Open file descriptors system-wide",
+		},
+		{
+			Name:  "system_fd_max",
+			Value: max,
+			Type:  MetricTypeGauge,
+			Help:  "System-wide file descriptor limit (fs.file-max)",
+		},
+	}
+
+	if max > 0 {
+		metrics = append(metrics, &Metric{
+			Name:  "system_fd_usage_percent",
+			Value: 100 * open / max,
+			Type:  MetricTypeGauge,
+			Help:  "Open file descriptors as a percentage of fs.file-max",
+			Unit:  "percent",
+		})
+	}
+
+	return metrics
+}
+
+func (lc *LimitsCollector) collectEntropy() []*Metric {
+	avail, err := readUintFile(filepath.Join(lc.procPath, "sys/kernel/random/entropy_avail"))
+	if err != nil {
+		return nil
+	}
+
+	metrics := []*Metric{{
+		Name:  "system_entropy_available_bits",
+		Value: float64(avail),
+		Type:  MetricTypeGauge,
+		Help:  "Entropy available in the kernel random pool",
+		Unit:  "bits",
+	}}
+
+	if size, err := readUintFile(filepath.Join(lc.procPath, "sys/kernel/random/poolsize")); err == nil {
+		metrics = append(metrics, &Metric{
+			Name:  "system_entropy_pool_size_bits",
+			Value: float64(size),
+			Type:  MetricTypeGauge,
+			Help:  "Size of the kernel random pool",
+			Unit:  "bits",
+		})
+	}
+
+	return metrics
+}
+
+// collectPIDs compares the number of tasks from /proc/loadavg to kernel.pid_max
+func (lc *LimitsCollector) collectPIDs() []*Metric {
+	pidMax, err := readUintFile(filepath.Join(lc.procPath, "sys/kernel/pid_max"))
+	if err != nil || pidMax == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(lc.procPath, "loadavg"))
+	if err != nil {
+		return nil
+	}
+
+	// Fourth field is "running/total"
+	fields := strings.Fields(string(data))
+	if len(fields) < 4 {
+		return nil
+	}
+	_, total, ok := strings.Cut(fields[3], "/")
+	if !ok {
+		return nil
+	}
+	tasks, err := strconv.ParseFloat(total, 64)
+	if err != nil {
+		return nil
+	}
+
+	return []*Metric{
+		{
+			Name:  "system_tasks_total",
+			Value: tasks,
+			Type:  MetricTypeGauge,
+			Help:  "Processes and threads currently on the system",
+		},
+		{
+			Name:  "system_pid_usage_percent",
+			Value: 100 * tasks / float64(pidMax),
+			Type:  MetricTypeGauge,
+			Help:  "Tasks as a percentage of kernel.pid_max",
+			Unit:  "percent",
+		},
+	}
+}
+
+// collectConntrack reports netfilter connection tracking table usage
+func (lc *LimitsCollector) collectConntrack() []*Metric {
+	count, err := readUintFile(filepath.Join(lc.procPath, "sys/net/netfilter/nf_conntrack_count"))
+	if err != nil {
+		return nil
+	}
+	max, err := readUintFile(filepath.Join(lc.procPath, "sys/net/netfilter/nf_conntrack_max"))
+	if err != nil || max == 0 {
+		return nil
+	}
+
+	return []*Metric{
+		{
+			Name:  "system_conntrack_entries",
+			Value: float64(count),
+			Type:  MetricTypeGauge,
+			Help:  "Entries in the connection tracking table",
+		},
+		{
+			Name:  "system_conntrack_usage_percent",
+			Value: 100 * float64(count) / float64(max),
+			Type:  MetricTypeGauge,
+			Help:  "Connection tracking entries as a percentage of nf_conntrack_max",
+			Unit:  "percent",
+		},
+	}
+}
+
+func (lc *LimitsCollector) collectSysctls() []*Metric {
+	var metrics []*Metric
+
+	for _, name := range limitSysctls {
+		data, err := os.ReadFile(filepath.Join(lc.procPath, "sys", strings.ReplaceAll(name, ".", "/")))
+		if err != nil {
+			continue
+		}
+
+		// Range sysctls such as ip_local_port_range report their size
+		fields := strings.Fields(string(data))
+		var value float64
+		switch len(fields) {
+		case 1:
+			value, err = strconv.ParseFloat(fields[0], 64)
+		case 2:
+			var lo, hi float64
+			lo, err = strconv.ParseFloat(fields[0], 64)
+			if err == nil {
+				hi, err = strconv.ParseFloat(fields[1], 64)
+			}
+			value = hi - lo + 1
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		metrics = append(metrics, &Metric{
+			Name:   "system_sysctl",
+			Value:  value,
+			Labels: map[string]string{"name": name},
+			Type:   MetricTypeGauge,
+			Help:   "Value of a kernel limit sysctl",
+		})
+	}
+
+	return metrics
+}
+
+type processFDs struct {
+	pid   string
+	name  string
+	open  int
+	limit uint64
+}
+
+// collectProcessFDs reports the processes with the most open descriptors
+// together with their RLIMIT_NOFILE soft limit
+func (lc *LimitsCollector) collectProcessFDs(ctx context.Context) []*Metric {
+	entries, err := os.ReadDir(lc.procPath)
+	if err != nil {
+		return nil
+	}
+
+	var procs []processFDs
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+
+		pid := entry.Name()
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+
+		// Processes we cannot inspect (or that exited) are skipped
+		fds, err := os.ReadDir(filepath.Join(lc.procPath, pid, "fd"))
+		if err != nil {
+			continue
+		}
+
+		procs = append(procs, processFDs{pid: pid, open: len(fds)})
+	}
+
+	sort.Slice(procs, func(i, j int) bool {
+		return procs[i].open > procs[j].open
+	})
+	if len(procs) > lc.topProcesses {
+		procs = procs[:lc.topProcesses]
+	}
+
+	var metrics []*Metric
+	for _, p := range procs {
+		p.name = lc.processName(p.pid)
+		p.limit = lc.processFDLimit(p.pid)
+
+		labels := map[string]string{"pid": p.pid, "process": p.name}
+		metrics = append(metrics, &Metric{
+			Name:   "system_process_open_fds",
+			Value:  float64(p.open),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Open file descriptors of the process",
+		})
+
+		if p.limit > 0 {
+			metrics = append(metrics, &Metric{
+				Name:   "system_process_fd_usage_percent",
+				Value:  100 * float64(p.open) / float64(p.limit),
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Open file descriptors as a percentage of the process soft limit",
+				Unit:   "percent",
+			})
+		}
+	}
+
+	return metrics
+}
+
+func (lc *LimitsCollector) processName(pid string) string {
+	data, err := os.ReadFile(filepath.Join(lc.procPath, pid, "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// processFDLimit returns the "Max open files" soft limit, or 0 if unlimited
+func (lc *LimitsCollector) processFDLimit(pid string) uint64 {
+	data, err := os.ReadFile(filepath.Join(lc.procPath, pid, "limits"))
+	if err != nil {
+		return 0
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			return 0
+		}
+		limit, _ := strconv.ParseUint(fields[0], 10, 64)
+		return limit
+	}
+
+	return 0
+}
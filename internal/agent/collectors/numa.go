@@ -0,0 +1,282 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+)
+
+const (
+	numaNodesDir = "/sys/devices/system/node"
+	hugepagesDir = "/sys/kernel/mm/hugepages"
+	cpuSysfsDir  = "/sys/devices/system/cpu"
+)
+
+// collectNUMAMetrics reports per-node memory usage and allocation locality.
+// Hosts without NUMA sysfs support are skipped silently.
+func (c *SystemCollector) collectNUMAMetrics() []*Metric {
+	nodes, _ := filepath.Glob(filepath.Join(numaNodesDir, "node[0-9]*"))
+
+	var metrics []*Metric
+	for _, dir := range nodes {
+		node := strings.TrimPrefix(filepath.Base(dir), "node")
+		labels := map[string]string{"numa_node": node}
+
+		if meminfo, err := readNodeMeminfo(filepath.Join(dir, "meminfo")); err == nil {
+			total, free := meminfo["MemTotal"], meminfo["MemFree"]
+			metrics = append(metrics,
+				&Metric{
+					Name:   "system_numa_memory_total_bytes",
+					Value:  float64(total),
+					Labels: labels,
+					Type:   MetricTypeGauge,
+					Help:   "Total memory attached to the NUMA node",
+					Unit:   "bytes",
+				},
+				&Metric{
+					Name:   "system_numa_memory_free_bytes",
+					Value:  float64(free),
+					Labels: labels,
+					Type:   MetricTypeGauge,
+					Help:   "Free memory on the NUMA node",
+					Unit:   "bytes",
+				},
+				&Metric{
+					Name:   "system_numa_memory_used_bytes",
+					Value:  float64(meminfo["MemUsed"]),
+					Labels: labels,
+					Type:   MetricTypeGauge,
+					Help:   "Used memory on the NUMA node",
+					Unit:   "bytes",
+				},
+			)
+			if total > 0 {
+				metrics = append(metrics, &Metric{
+					Name:   "system_numa_memory_usage_percent",
+					Value:  100 * float64(total-free) / float64(total),
+					Labels: labels,
+					Type:   MetricTypeGauge,
+					Help:   "Memory usage percentage of the NUMA node",
+					Unit:   "percent",
+				})
+			}
+		}
+
+		if stat, err := readKeyValueFile(filepath.Join(dir, "numastat")); err == nil {
+			metrics = append(metrics,
+				&Metric{
+					Name:   "system_numa_hit_total",
+					Value:  float64(stat["numa_hit"]),
+					Labels: labels,
+					Type:   MetricTypeCounter,
+					Help:   "Pages allocated on the intended NUMA node",
+				},
+				&Metric{
+					Name:   "system_numa_miss_total",
+					Value:  float64(stat["numa_miss"]),
+					Labels: labels,
+					Type:   MetricTypeCounter,
+					Help:   "Pages allocated on this node although another was preferred",
+				},
+				&Metric{
+					Name:   "system_numa_foreign_total",
+					Value:  float64(stat["numa_foreign"]),
+					Labels: labels,
+					Type:   MetricTypeCounter,
+					Help:   "Pages intended for this node but allocated elsewhere",
+				},
+			)
+		}
+
+		metrics = append(metrics, hugepageMetrics("system_numa_hugepages", filepath.Join(dir, "hugepages"), labels)...)
+	}
+
+	return metrics
+}
+
+// collectHugepageMetrics reports host-wide hugepage pools for each page size
+func (c *SystemCollector) collectHugepageMetrics() []*Metric {
+	return hugepageMetrics("system_hugepages", hugepagesDir, nil)
+}
+
+// hugepageMetrics reads the hugepages-<size>kB pools under dir
+func hugepageMetrics(prefix, dir string, base map[string]string) []*Metric {
+	pools, _ := filepath.Glob(filepath.Join(dir, "hugepages-*kB"))
+
+	var metrics []*Metric
+	for _, pool := range pools {
+		sizeKB, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(pool), "hugepages-"), "kB"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		labels := map[string]string{"page_size": strconv.FormatUint(sizeKB*1024, 10)}
+		for k, v := range base {
+			labels[k] = v
+		}
+
+		total, err := readUintFile(filepath.Join(pool, "nr_hugepages"))
+		if err != nil {
+			continue
+		}
+		free, _ := readUintFile(filepath.Join(pool, "free_hugepages"))
+		surplus, _ := readUintFile(filepath.Join(pool, "surplus_hugepages"))
+		if free > total {
+			free = total
+		}
+
+		metrics = append(metrics,
+			&Metric{
+				Name:   prefix + "_total",
+				Value:  float64(total),
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Hugepages in the pool",
+			},
+			&Metric{
+				Name:   prefix + "_free",
+				Value:  float64(free),
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Unallocated hugepages in the pool",
+			},
+			&Metric{
+				Name:   prefix + "_surplus",
+				Value:  float64(surplus),
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Surplus hugepages allocated above the pool size",
+			},
+			&Metric{
+				Name:   prefix + "_used_bytes",
+				Value:  float64((total-free)*sizeKB) * 1024,
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Memory held by allocated hugepages",
+				Unit:   "bytes",
+			},
+		)
+
+		// Reserved pages are only tracked for the host-wide pools
+		if resv, err := readUintFile(filepath.Join(pool, "resv_hugepages")); err == nil {
+			metrics = append(metrics, &Metric{
+				Name:   prefix + "_reserved",
+				Value:  float64(resv),
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Hugepages reserved but not yet faulted in",
+			})
+		}
+	}
+
+	return metrics
+}
+
+// collectSocketMetrics aggregates per-CPU times into per-socket usage
+func (c *SystemCollector) collectSocketMetrics() ([]*Metric, error) {
+	cpuTimes, err := cpu.Times(true)
+	if err != nil {
+		return nil, err
+	}
+
+	sockets := make(map[string]cpu.TimesStat)
+	cores := make(map[string]int)
+	for _, t := range cpuTimes {
+		socket := cpuSocket(t.CPU)
+
+		s := sockets[socket]
+		s.User += t.User
+		s.System += t.System
+		s.Nice += t.Nice
+		s.Idle += t.Idle
+		s.Iowait += t.Iowait
+		s.Irq += t.Irq
+		s.Softirq += t.Softirq
+		s.Steal += t.Steal
+		s.Guest += t.Guest
+		s.GuestNice += t.GuestNice
+		sockets[socket] = s
+		cores[socket]++
+	}
+
+	var metrics []*Metric
+	for socket, t := range sockets {
+		labels := map[string]string{"socket": socket}
+
+		metrics = append(metrics, &Metric{
+			Name:   "system_socket_cpu_cores",
+			Value:  float64(cores[socket]),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Logical CPUs in the socket",
+		})
+
+		if last, exists := c.lastSocket[socket]; exists {
+			totalDelta := totalCPUTime(t) - totalCPUTime(last)
+			if totalDelta > 0 {
+				metrics = append(metrics,
+					&Metric{
+						Name:   "system_socket_cpu_usage",
+						Value:  100 * (totalDelta - (t.Idle - last.Idle) - (t.Iowait - last.Iowait)) / totalDelta,
+						Labels: labels,
+						Type:   MetricTypeGauge,
+						Help:   "CPU usage percentage of the socket",
+						Unit:   "percent",
+					},
+					&Metric{
+						Name:   "system_socket_cpu_iowait",
+						Value:  100 * (t.Iowait - last.Iowait) / totalDelta,
+						Labels: labels,
+						Type:   MetricTypeGauge,
+						Help:   "CPU I/O wait time percentage of the socket",
+						Unit:   "percent",
+					},
+				)
+			}
+		}
+
+		c.lastSocket[socket] = t
+	}
+
+	return metrics, nil
+}
+
+// cpuSocket returns the physical package ID of a "cpuN" entry
+func cpuSocket(name string) string {
+	data, err := os.ReadFile(filepath.Join(cpuSysfsDir, name, "topology", "physical_package_id"))
+	if err != nil {
+		return "0"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readNodeMeminfo parses a per-node meminfo file ("Node 0 MemTotal: 123 kB")
+// into byte values
+func readNodeMeminfo(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+
+		v, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 4 && fields[4] == "kB" {
+			v *= 1024
+		}
+		values[strings.TrimSuffix(fields[2], ":")] = v
+	}
+
+	return values, nil
+}
@@ -0,0 +1,300 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// PackageCollector reports pending OS package updates and security updates
+type PackageCollector struct {
+	*BaseCollector
+	manager         string
+	includePackages bool
+	timeout         time.Duration
+
+	inventory   map[string]string
+	inventoryMu sync.RWMutex
+}
+
+// PackageCollectorConfig holds configuration for the package collector
+type PackageCollectorConfig struct {
+	Enabled         bool
+	Interval        time.Duration
+	Manager         string // apt, dnf, yum or auto
+	IncludePackages bool
+}
+
+// pendingUpdate describes a single upgradable package
+type pendingUpdate struct {
+	Name     string
+	Version  string
+	Security bool
+}
+
+// NewPackageCollector creates a new package update collector
+func NewPackageCollector(config PackageCollectorConfig) (*PackageCollector, error) {
+	manager := config.Manager
+	if manager == "" || manager == "auto" {
+		manager = detectPackageManager()
+		if manager == "" {
+			return nil, fmt.Errorf("no supported package manager found")
+		}
+	}
+
+	switch manager {
+	case "apt", "dnf", "yum":
+	default:
+		return nil, fmt.Errorf("unsupported package manager: %s", manager)
+	}
+
+	return &PackageCollector{
+		BaseCollector:   NewBaseCollector("packages", config.Enabled, config.Interval),
+		manager:         manager,
+		includePackages: config.IncludePackages,
+		timeout:         5 * time.Minute,
+		inventory:       make(map[string]string),
+	}, nil
+}
+
+// Collect collects pending update counts
+func (pc *PackageCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, pc.timeout)
+	defer cancel()
+
+	var (
+		updates []pendingUpdate
+		err     error
+	)
+
+	switch pc.manager {
+	case "apt":
+		updates, err = pc.collectApt(ctx)
+	default:
+		updates, err = pc.collectRPM(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s updates: %w", pc.manager, err)
+	}
+
+	var security int
+	for _, u := range updates {
+		if u.Security {
+			security++
+		}
+	}
+
+	pc.updateInventory(updates, security)
+
+	labels := map[string]string{"manager": pc.manager}
+
+	return []*Metric{
+		{
+			Name:   "system_package_updates_pending",
+			Value:  float64(len(updates)),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Number of packages with pending updates",
+		},
+		{
+			Name:   "system_package_security_updates_pending",
+			Value:  float64(security),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Number of packages with pending security updates",
+		},
+	}, nil
+}
+
+// Inventory returns the package details gathered during the last collection
+func (pc *PackageCollector) Inventory() map[string]string {
+	pc.inventoryMu.RLock()
+	defer pc.inventoryMu.RUnlock()
+
+	inventory := make(map[string]string, len(pc.inventory))
+	for k, v := range pc.inventory {
+		inventory[k] = v
+	}
+	return inventory
+}
+
+func (pc *PackageCollector) updateInventory(updates []pendingUpdate, security int) {
+	inventory := map[string]string{
+		models.InventoryPackageManager:    pc.manager,
+		models.InventoryPackagesPending:   strconv.Itoa(len(updates)),
+		models.InventoryPackagesSecurity:  strconv.Itoa(security),
+		models.InventoryPackagesCheckedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if pc.includePackages {
+		var pending, securityPending []string
+		for _, u := range updates {
+			entry := u.Name
+			if u.Version != "" {
+				entry = fmt.Sprintf("%s=%s", u.Name, u.Version)
+			}
+			pending = append(pending, entry)
+			if u.Security {
+				securityPending = append(securityPending, entry)
+			}
+		}
+		sort.Strings(pending)
+		sort.Strings(securityPending)
+		inventory[models.InventoryPackagesPendingList] = strings.Join(pending, ",")
+		inventory[models.InventoryPackagesSecurityList] = strings.Join(securityPending, ",")
+	}
+
+	pc.inventoryMu.Lock()
+	pc.inventory = inventory
+	pc.inventoryMu.Unlock()
+}
+
+// collectApt lists upgradable packages on Debian-based systems
+func (pc *PackageCollector) collectApt(ctx context.Context) ([]pendingUpdate, error) {
+	out, err := runPackageCommand(ctx, "apt", "list", "--upgradable")
+	if err != nil {
+		return nil, err
+	}
+
+	// Lines look like:
+	// openssl/jammy-updates,jammy-security 3.0.2-0ubuntu1.15 amd64 [upgradable from: 3.0.2-0ubuntu1.14]
+	var updates []pendingUpdate
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "Listing") || strings.HasPrefix(line, "WARNING") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		nameAndOrigin := strings.SplitN(fields[0], "/", 2)
+		update := pendingUpdate{
+			Name:    nameAndOrigin[0],
+			Version: fields[1],
+		}
+		if len(nameAndOrigin) == 2 && strings.Contains(nameAndOrigin[1], "-security") {
+			update.Security = true
+		}
+		updates = append(updates, update)
+	}
+
+	return updates, scanner.Err()
+}
+
+// collectRPM lists upgradable packages on dnf/yum based systems
+func (pc *PackageCollector) collectRPM(ctx context.Context) ([]pendingUpdate, error) {
+	out, err := runPackageCommand(ctx, pc.manager, "-q", "check-update")
+	if err != nil {
+		return nil, err
+	}
+
+	// check-update output: "<name>.<arch>  <version>  <repo>", followed by an
+	// optional "Obsoleting Packages" section that we ignore
+	var updates []pendingUpdate
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Obsoleting") {
+			break
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasPrefix(line, " ") {
+			continue
+		}
+
+		name := fields[0]
+		if i := strings.LastIndex(name, "."); i > 0 {
+			name = name[:i]
+		}
+		index[name] = len(updates)
+		updates = append(updates, pendingUpdate{Name: name, Version: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	securityArgs := []string{"-q", "updateinfo", "list", "--security"}
+	if pc.manager == "yum" {
+		securityArgs = []string{"-q", "updateinfo", "list", "security"}
+	}
+
+	secOut, err := runPackageCommand(ctx, pc.manager, securityArgs...)
+	if err != nil {
+		// Security metadata is optional (e.g. repos without updateinfo)
+		return updates, nil
+	}
+
+	// updateinfo output: "<advisory>  <severity>/Sec.  <name>-<version>-<release>.<arch>"
+	scanner = bufio.NewScanner(bytes.NewReader(secOut))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		if i, ok := index[rpmName(fields[len(fields)-1])]; ok {
+			updates[i].Security = true
+		}
+	}
+
+	return updates, scanner.Err()
+}
+
+// rpmName returns the name of a package from its name-version-release.arch
+// string. The version may carry an epoch, and names may contain dashes.
+func rpmName(nevra string) string {
+	if i := strings.LastIndex(nevra, "."); i > 0 {
+		nevra = nevra[:i]
+	}
+	for n := 0; n < 2; n++ {
+		i := strings.LastIndex(nevra, "-")
+		if i <= 0 {
+			return ""
+		}
+		nevra = nevra[:i]
+	}
+	return nevra
+}
+
+// runPackageCommand runs a package manager command with a stable locale.
+// dnf/yum check-update exit with status 100 when updates are available.
+func runPackageCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), "LANG=C", "LC_ALL=C")
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 100 {
+			return out, nil
+		}
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func detectPackageManager() string {
+	for _, manager := range []string{"apt", "dnf", "yum"} {
+		if _, err := exec.LookPath(manager); err == nil {
+			return manager
+		}
+	}
+	return ""
+}
@@ -0,0 +1,284 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PHPFPMCollectorConfig holds configuration for the php-fpm collector
+type PHPFPMCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Pools    []PHPFPMPool
+}
+
+// PHPFPMPool is a php-fpm pool whose status page is read. Address is an
+// http(s) URL of the status page, or the pool's FastCGI socket, in which
+// case StatusPath (the pool's pm.status_path) is requested directly.
+type PHPFPMPool struct {
+	Name       string
+	Address    string
+	StatusPath string
+	Timeout    time.Duration
+}
+
+// PHPFPMCollector reports php-fpm pool status: process counts, the listen
+// queue and accepted connections
+type PHPFPMCollector struct {
+	*BaseCollector
+	pools  []PHPFPMPool
+	client *http.Client
+}
+
+// NewPHPFPMCollector creates a new php-fpm collector
+func NewPHPFPMCollector(config PHPFPMCollectorConfig) (*PHPFPMCollector, error) {
+	for _, pool := range config.Pools {
+		if pool.Address == "" {
+			return nil, fmt.Errorf("php-fpm pool %s: address is required", pool.Name)
+		}
+	}
+	return &PHPFPMCollector{
+		BaseCollector: NewBaseCollector("php_fpm", config.Enabled, config.Interval),
+		pools:         config.Pools,
+		client:        &http.Client{},
+	}, nil
+}
+
+// phpfpmStatus is the JSON status page of a pool
+type phpfpmStatus struct {
+	Pool               string `json:"pool"`
+	ProcessManager     string `json:"process manager"`
+	StartSince         int64  `json:"start since"`
+	AcceptedConn       uint64 `json:"accepted conn"`
+	ListenQueue        int64  `json:"listen queue"`
+	MaxListenQueue     int64  `json:"max listen queue"`
+	ListenQueueLen     int64  `json:"listen queue len"`
+	IdleProcesses      int64  `json:"idle processes"`
+	ActiveProcesses    int64  `json:"active processes"`
+	TotalProcesses     int64  `json:"total processes"`
+	MaxActiveProcesses int64  `json:"max active processes"`
+	MaxChildrenReached uint64 `json:"max children reached"`
+	SlowRequests       uint64 `json:"slow requests"`
+}
+
+// Collect collects the status of every pool
+func (pc *PHPFPMCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	results := make([][]*Metric, len(pc.pools))
+	var wg sync.WaitGroup
+	for i, pool := range pc.pools {
+		wg.Add(1)
+		go func(i int, pool PHPFPMPool) {
+			defer wg.Done()
+			results[i] = pc.collectPool(ctx, pool)
+		}(i, pool)
+	}
+	wg.Wait()
+
+	var metrics []*Metric
+	for _, r := range results {
+		metrics = append(metrics, r...)
+	}
+	return metrics, nil
+}
+
+func (pc *PHPFPMCollector) collectPool(ctx context.Context, pool PHPFPMPool) []*Metric {
+	timeout := pool.Timeout
+	if timeout == 0 {
+		timeout = appServerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	labels := map[string]string{"pool": pool.Name}
+	status, err := pc.status(ctx, pool)
+	if err != nil {
+		return []*Metric{upMetric("phpfpm_up", false, labels, "Whether the pool's status page could be read")}
+	}
+	if pool.Name == "" {
+		labels["pool"] = status.Pool
+	}
+
+	gauge := func(name string, value int64, help string) *Metric {
+		return &Metric{Name: name, Value: float64(value), Labels: labels, Type: MetricTypeGauge, Help: help}
+	}
+	counter := func(name string, value uint64, help string) *Metric {
+		return &Metric{Name: name, Value: float64(value), Labels: labels, Type: MetricTypeCounter, Help: help}
+	}
+	return []*Metric{
+		upMetric("phpfpm_up", true, labels, "Whether the pool's status page could be read"),
+		{
+			Name:   "phpfpm_processes",
+			Value:  float64(status.IdleProcesses),
+			Labels: withLabel(labels, "state", "idle"),
+			Type:   MetricTypeGauge,
+			Help:   "Pool processes by state",
+		},
+		{
+			Name:   "phpfpm_processes",
+			Value:  float64(status.ActiveProcesses),
+			Labels: withLabel(labels, "state", "active"),
+			Type:   MetricTypeGauge,
+			Help:   "Pool processes by state",
+		},
+		gauge("phpfpm_processes_max_active", status.MaxActiveProcesses, "Most processes active at once since the pool started"),
+		gauge("phpfpm_listen_queue", status.ListenQueue, "Requests waiting for a free process"),
+		gauge("phpfpm_listen_queue_max", status.MaxListenQueue, "Longest listen queue since the pool started"),
+		gauge("phpfpm_listen_queue_length", status.ListenQueueLen, "Size of the socket's listen queue"),
+		counter("phpfpm_accepted_connections_total", status.AcceptedConn, "Requests accepted by the pool"),
+		counter("phpfpm_max_children_reached_total", status.MaxChildrenReached, "Times the process manager hit pm.max_children"),
+		counter("phpfpm_slow_requests_total", status.SlowRequests, "Requests exceeding request_slowlog_timeout"),
+		{
+			Name:   "phpfpm_uptime_seconds",
+			Value:  float64(status.StartSince),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Time since the pool started",
+			Unit:   "seconds",
+		},
+	}
+}
+
+// status reads a pool's status page over HTTP or FastCGI
+func (pc *PHPFPMCollector) status(ctx context.Context, pool PHPFPMPool) (*phpfpmStatus, error) {
+	var status phpfpmStatus
+	if isHTTPAddress(pool.Address) {
+		url := pool.Address
+		if strings.Contains(url, "?") {
+			url += "&json"
+		} else {
+			url += "?json"
+		}
+		if err := getJSON(ctx, pc.client, url, &status); err != nil {
+			return nil, err
+		}
+		return &status, nil
+	}
+
+	path := pool.StatusPath
+	if path == "" {
+		path = "/status"
+	}
+	body, err := fastcgiGet(ctx, pool.Address, map[string]string{
+		"SCRIPT_NAME":     path,
+		"SCRIPT_FILENAME": path,
+		"REQUEST_URI":     path + "?json",
+		"QUERY_STRING":    "json",
+		"REQUEST_METHOD":  "GET",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse php-fpm status: %w", err)
+	}
+	return &status, nil
+}
+
+// FastCGI record types used by fastcgiGet
+const (
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiResponder = 1
+)
+
+// fastcgiGet sends a request with the given CGI params to a FastCGI
+// server and returns the response body, failing on a non-200 Status
+// header
+func fastcgiGet(ctx context.Context, address string, params map[string]string) ([]byte, error) {
+	conn, err := dialSocket(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var req bytes.Buffer
+	writeFCGIRecord(&req, fcgiBeginRequest, []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0})
+	var p bytes.Buffer
+	for k, v := range params {
+		writeFCGILength(&p, len(k))
+		writeFCGILength(&p, len(v))
+		p.WriteString(k)
+		p.WriteString(v)
+	}
+	writeFCGIRecord(&req, fcgiParams, p.Bytes())
+	writeFCGIRecord(&req, fcgiParams, nil)
+	writeFCGIRecord(&req, fcgiStdin, nil)
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	r := bufio.NewReader(conn)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, fmt.Errorf("failed to read FastCGI response: %w", err)
+		}
+		length := int(binary.BigEndian.Uint16(header[4:6]))
+		content := make([]byte, length+int(header[6]))
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, fmt.Errorf("failed to read FastCGI response: %w", err)
+		}
+		switch header[1] {
+		case fcgiStdout:
+			stdout.Write(content[:length])
+		case fcgiStderr:
+			stderr.Write(content[:length])
+		case fcgiEndRequest:
+			return parseCGIResponse(stdout.Bytes(), stderr.String())
+		}
+	}
+}
+
+func writeFCGIRecord(w *bytes.Buffer, recordType byte, content []byte) {
+	w.Write([]byte{1, recordType, 0, 1})
+	binary.Write(w, binary.BigEndian, uint16(len(content)))
+	w.Write([]byte{0, 0})
+	w.Write(content)
+}
+
+// writeFCGILength writes a name or value length, in one byte when it fits
+// in seven bits and in four otherwise
+func writeFCGILength(w *bytes.Buffer, n int) {
+	if n < 128 {
+		w.WriteByte(byte(n))
+		return
+	}
+	binary.Write(w, binary.BigEndian, uint32(n)|1<<31)
+}
+
+// parseCGIResponse splits a CGI response into headers and body
+func parseCGIResponse(out []byte, stderr string) ([]byte, error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(out)))
+	header, err := r.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid CGI response: %w", err)
+	}
+	if status := header.Get("Status"); status != "" {
+		code, _ := strconv.Atoi(strings.Fields(status)[0])
+		if code != http.StatusOK {
+			if stderr != "" {
+				return nil, fmt.Errorf("status page returned %s: %s", status, strings.TrimSpace(stderr))
+			}
+			return nil, fmt.Errorf("status page returned %s", status)
+		}
+	}
+	return io.ReadAll(r.R)
+}
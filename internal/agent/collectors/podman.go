@@ -0,0 +1,73 @@
+package collectors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// podmanRootfulSocket is the socket of the system Podman service
+const podmanRootfulSocket = "/run/podman/podman.sock"
+
+// podmanRuntime reads containers from Podman's Docker-compatible API.
+// Rootless Podman runs one service per user, so every socket found is read
+// and each container remembers the socket that listed it.
+type podmanRuntime struct {
+	sockets []*dockerRuntime
+}
+
+func newPodmanRuntime(sockets []string) *podmanRuntime {
+	p := &podmanRuntime{}
+	for _, socket := range sockets {
+		d := newDockerRuntime(socket)
+		d.runtime = RuntimePodman
+		p.sockets = append(p.sockets, d)
+	}
+	return p
+}
+
+// podmanSockets returns the configured socket, or else the rootful socket
+// and the rootless sockets of all users that exist
+func podmanSockets(configured string) []string {
+	if configured != "" {
+		return []string{configured}
+	}
+
+	var sockets []string
+	if _, err := os.Stat(podmanRootfulSocket); err == nil {
+		sockets = append(sockets, podmanRootfulSocket)
+	}
+	rootless, _ := filepath.Glob("/run/user/*/podman/podman.sock")
+	return append(sockets, rootless...)
+}
+
+func (p *podmanRuntime) name() string {
+	return RuntimePodman
+}
+
+func (p *podmanRuntime) list(ctx context.Context) ([]*containerInfo, error) {
+	var (
+		containers []*containerInfo
+		lastErr    error
+	)
+	for i, socket := range p.sockets {
+		// A user's service may have exited while its socket remains
+		found, err := socket.list(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, c := range found {
+			c.source = i
+		}
+		containers = append(containers, found...)
+	}
+	if containers == nil && lastErr != nil {
+		return nil, lastErr
+	}
+	return containers, nil
+}
+
+func (p *podmanRuntime) stats(ctx context.Context, c *containerInfo) (*containerStats, error) {
+	return p.sockets[c.source].stats(ctx, c)
+}
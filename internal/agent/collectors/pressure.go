@@ -0,0 +1,361 @@
+package collectors
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pressureResources are the resources exposed under /proc/pressure
+var pressureResources = []string{"cpu", "io", "memory"}
+
+// psiLine is a single "some" or "full" line of a PSI file
+type psiLine struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	// Total stall time in microseconds
+	Total uint64
+}
+
+// cgroupSample keeps the previous CPU reading of a cgroup for rate calculation
+type cgroupSample struct {
+	usageUsec uint64
+	at        time.Time
+}
+
+// collectPressureMetrics reads pressure stall information for the whole host.
+// Kernels without PSI support are skipped silently.
+func (c *SystemCollector) collectPressureMetrics() []*Metric {
+	var metrics []*Metric
+
+	for _, resource := range pressureResources {
+		lines, err := readPSIFile(filepath.Join("/proc/pressure", resource))
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, psiMetrics("system_pressure", lines, map[string]string{
+			"resource": resource,
+		})...)
+	}
+
+	return metrics
+}
+
+// collectCgroupMetrics reports cgroup v2 resource accounting for every cgroup
+// under the configured root, down to the configured depth
+func (c *SystemCollector) collectCgroupMetrics() []*Metric {
+	root := c.config.Cgroup.Root
+	if root == "" {
+		root = "/sys/fs/cgroup"
+	}
+	depth := c.config.Cgroup.Depth
+	if depth <= 0 {
+		depth = 1
+	}
+
+	// cgroup.controllers only exists on the unified (v2) hierarchy
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return nil
+	}
+
+	var metrics []*Metric
+	seen := make(map[string]bool)
+	now := time.Now()
+
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == root {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		if strings.Count(rel, string(filepath.Separator))+1 > depth {
+			return filepath.SkipDir
+		}
+
+		seen[rel] = true
+		metrics = append(metrics, c.cgroupMetrics(path, rel, now)...)
+		return nil
+	})
+
+	// Forget cgroups that went away
+	for name := range c.lastCgroup {
+		if !seen[name] {
+			delete(c.lastCgroup, name)
+		}
+	}
+
+	return metrics
+}
+
+func (c *SystemCollector) cgroupMetrics(path, name string, now time.Time) []*Metric {
+	var metrics []*Metric
+	labels := map[string]string{"cgroup": name}
+
+	if stat, err := readKeyValueFile(filepath.Join(path, "cpu.stat")); err == nil {
+		usage := stat["usage_usec"]
+		metrics = append(metrics,
+			&Metric{
+				Name:   "system_cgroup_cpu_usage_seconds_total",
+				Value:  float64(usage) / 1e6,
+				Labels: labels,
+				Type:   MetricTypeCounter,
+				Help:   "Total CPU time consumed by the cgroup",
+				Unit:   "seconds",
+			},
+			&Metric{
+				Name:   "system_cgroup_cpu_throttled_seconds_total",
+				Value:  float64(stat["throttled_usec"]) / 1e6,
+				Labels: labels,
+				Type:   MetricTypeCounter,
+				Help:   "Total time the cgroup was throttled by its CPU limit",
+				Unit:   "seconds",
+			},
+		)
+
+		if last, exists := c.lastCgroup[name]; exists && usage >= last.usageUsec {
+			elapsed := now.Sub(last.at).Microseconds()
+			if elapsed > 0 {
+				metrics = append(metrics, &Metric{
+					Name:   "system_cgroup_cpu_usage_percent",
+					Value:  100 * float64(usage-last.usageUsec) / float64(elapsed) / float64(c.processors),
+					Labels: labels,
+					Type:   MetricTypeGauge,
+					Help:   "CPU usage of the cgroup as a percentage of all CPUs",
+					Unit:   "percent",
+				})
+			}
+		}
+		c.lastCgroup[name] = cgroupSample{usageUsec: usage, at: now}
+	}
+
+	if current, err := readUintFile(filepath.Join(path, "memory.current")); err == nil {
+		metrics = append(metrics, &Metric{
+			Name:   "system_cgroup_memory_current_bytes",
+			Value:  float64(current),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Memory currently used by the cgroup",
+			Unit:   "bytes",
+		})
+	}
+
+	// memory.max is "max" when unlimited, in which case no limit is reported
+	if limit, err := readUintFile(filepath.Join(path, "memory.max")); err == nil {
+		metrics = append(metrics, &Metric{
+			Name:   "system_cgroup_memory_max_bytes",
+			Value:  float64(limit),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Memory limit of the cgroup",
+			Unit:   "bytes",
+		})
+	}
+
+	if events, err := readKeyValueFile(filepath.Join(path, "memory.events")); err == nil {
+		metrics = append(metrics, &Metric{
+			Name:   "system_cgroup_memory_oom_kills_total",
+			Value:  float64(events["oom_kill"]),
+			Labels: labels,
+			Type:   MetricTypeCounter,
+			Help:   "Processes killed by the OOM killer inside the cgroup",
+		})
+	}
+
+	if rbytes, wbytes, err := readIOStat(filepath.Join(path, "io.stat")); err == nil {
+		metrics = append(metrics,
+			&Metric{
+				Name:   "system_cgroup_io_read_bytes_total",
+				Value:  float64(rbytes),
+				Labels: labels,
+				Type:   MetricTypeCounter,
+				Help:   "Bytes read by the cgroup across all devices",
+				Unit:   "bytes",
+			},
+			&Metric{
+				Name:   "system_cgroup_io_write_bytes_total",
+				Value:  float64(wbytes),
+				Labels: labels,
+				Type:   MetricTypeCounter,
+				Help:   "Bytes written by the cgroup across all devices",
+				Unit:   "bytes",
+			},
+		)
+	}
+
+	if pids, err := readUintFile(filepath.Join(path, "pids.current")); err == nil {
+		metrics = append(metrics, &Metric{
+			Name:   "system_cgroup_pids_current",
+			Value:  float64(pids),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Number of tasks in the cgroup",
+		})
+	}
+
+	for _, resource := range pressureResources {
+		lines, err := readPSIFile(filepath.Join(path, resource+".pressure"))
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, psiMetrics("system_cgroup_pressure", lines, map[string]string{
+			"cgroup":   name,
+			"resource": resource,
+		})...)
+	}
+
+	return metrics
+}
+
+// psiMetrics converts parsed PSI lines into metrics. Averages are percentages
+// of wall time in which at least one ("some") or all ("full") tasks stalled.
+func psiMetrics(prefix string, lines map[string]psiLine, labels map[string]string) []*Metric {
+	var metrics []*Metric
+
+	for _, kind := range []string{"some", "full"} {
+		line, ok := lines[kind]
+		if !ok {
+			continue
+		}
+
+		name := prefix + "_" + kind
+		metrics = append(metrics,
+			&Metric{
+				Name:   name + "_avg10",
+				Value:  line.Avg10,
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Share of time stalled on the resource over the last 10s",
+				Unit:   "percent",
+			},
+			&Metric{
+				Name:   name + "_avg60",
+				Value:  line.Avg60,
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Share of time stalled on the resource over the last 60s",
+				Unit:   "percent",
+			},
+			&Metric{
+				Name:   name + "_avg300",
+				Value:  line.Avg300,
+				Labels: labels,
+				Type:   MetricTypeGauge,
+				Help:   "Share of time stalled on the resource over the last 300s",
+				Unit:   "percent",
+			},
+			&Metric{
+				Name:   name + "_stall_seconds_total",
+				Value:  float64(line.Total) / 1e6,
+				Labels: labels,
+				Type:   MetricTypeCounter,
+				Help:   "Total time stalled on the resource",
+				Unit:   "seconds",
+			},
+		)
+	}
+
+	return metrics
+}
+
+// readPSIFile parses a file in the format
+// "some avg10=0.00 avg60=0.00 avg300=0.00 total=0"
+func readPSIFile(path string) (map[string]psiLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := make(map[string]psiLine)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		var line psiLine
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "avg10":
+				line.Avg10, _ = strconv.ParseFloat(value, 64)
+			case "avg60":
+				line.Avg60, _ = strconv.ParseFloat(value, 64)
+			case "avg300":
+				line.Avg300, _ = strconv.ParseFloat(value, 64)
+			case "total":
+				line.Total, _ = strconv.ParseUint(value, 10, 64)
+			}
+		}
+		lines[fields[0]] = line
+	}
+
+	return lines, scanner.Err()
+}
+
+// readKeyValueFile parses flat-keyed cgroup files such as cpu.stat
+func readKeyValueFile(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+
+	return values, nil
+}
+
+// readIOStat sums read and write bytes over all devices in io.stat
+func readIOStat(path string) (uint64, uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var rbytes, wbytes uint64
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, field := range strings.Fields(line) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				rbytes += v
+			case "wbytes":
+				wbytes += v
+			}
+		}
+	}
+
+	return rbytes, wbytes, nil
+}
+
+func readUintFile(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,350 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RabbitMQCollectorConfig holds configuration for the RabbitMQ collector
+type RabbitMQCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Servers  []RabbitMQServer
+}
+
+// RabbitMQServer is a broker read through its management API, e.g.
+// http://127.0.0.1:15672. VHosts limits the queues reported to those
+// virtual hosts and QueuePattern to queues whose name matches it. A
+// queue is growing when its depth rose over GrowthWindow.
+type RabbitMQServer struct {
+	Name         string
+	URL          string
+	Username     string
+	Password     string
+	VHosts       []string
+	QueuePattern string
+	GrowthWindow time.Duration
+	Timeout      time.Duration
+}
+
+// rabbitmqGrowthWindow is used when a server sets no growth window
+const rabbitmqGrowthWindow = 10 * time.Minute
+
+// RabbitMQCollector reports broker totals, connection churn, per-queue
+// depths and consumers, and node resource alarms
+type RabbitMQCollector struct {
+	*BaseCollector
+	servers []*rabbitmqServer
+	client  *http.Client
+}
+
+type rabbitmqServer struct {
+	config  RabbitMQServer
+	pattern *regexp.Regexp
+	vhosts  map[string]bool
+
+	mu sync.Mutex
+	// depths holds recent depth samples of each queue, keyed by vhost and
+	// name, to tell whether it is growing
+	depths map[string][]rabbitmqDepth
+}
+
+type rabbitmqDepth struct {
+	at       time.Time
+	messages float64
+}
+
+// NewRabbitMQCollector creates a new RabbitMQ collector
+func NewRabbitMQCollector(config RabbitMQCollectorConfig) (*RabbitMQCollector, error) {
+	rc := &RabbitMQCollector{
+		BaseCollector: NewBaseCollector("rabbitmq", config.Enabled, config.Interval),
+		client:        &http.Client{},
+	}
+	for _, s := range config.Servers {
+		if !isHTTPAddress(s.URL) {
+			return nil, fmt.Errorf("rabbitmq server %s: url must be an http(s) URL of the management API", s.Name)
+		}
+		server := &rabbitmqServer{config: s, depths: make(map[string][]rabbitmqDepth)}
+		if s.QueuePattern != "" {
+			pattern, err := regexp.Compile(s.QueuePattern)
+			if err != nil {
+				return nil, fmt.Errorf("rabbitmq server %s: invalid queue_pattern: %w", s.Name, err)
+			}
+			server.pattern = pattern
+		}
+		if len(s.VHosts) > 0 {
+			server.vhosts = make(map[string]bool, len(s.VHosts))
+			for _, vhost := range s.VHosts {
+				server.vhosts[vhost] = true
+			}
+		}
+		if server.config.GrowthWindow == 0 {
+			server.config.GrowthWindow = rabbitmqGrowthWindow
+		}
+		rc.servers = append(rc.servers, server)
+	}
+	return rc, nil
+}
+
+// rabbitmqOverview is the document /api/overview returns
+type rabbitmqOverview struct {
+	ObjectTotals struct {
+		Connections float64 `json:"connections"`
+		Channels    float64 `json:"channels"`
+		Queues      float64 `json:"queues"`
+		Consumers   float64 `json:"consumers"`
+	} `json:"object_totals"`
+	ChurnRates struct {
+		ConnectionCreated float64 `json:"connection_created"`
+		ConnectionClosed  float64 `json:"connection_closed"`
+		ChannelCreated    float64 `json:"channel_created"`
+		ChannelClosed     float64 `json:"channel_closed"`
+		QueueDeclared     float64 `json:"queue_declared"`
+		QueueDeleted      float64 `json:"queue_deleted"`
+	} `json:"churn_rates"`
+	MessageStats rabbitmqMessageStats `json:"message_stats"`
+}
+
+// rabbitmqMessageStats are message counts since the broker or queue
+// started
+type rabbitmqMessageStats struct {
+	Publish    float64 `json:"publish"`
+	DeliverGet float64 `json:"deliver_get"`
+	Ack        float64 `json:"ack"`
+	Redeliver  float64 `json:"redeliver"`
+}
+
+// rabbitmqQueue is an entry of /api/queues
+type rabbitmqQueue struct {
+	Name                   string               `json:"name"`
+	VHost                  string               `json:"vhost"`
+	State                  string               `json:"state"`
+	Messages               float64              `json:"messages"`
+	MessagesReady          float64              `json:"messages_ready"`
+	MessagesUnacknowledged float64              `json:"messages_unacknowledged"`
+	Consumers              float64              `json:"consumers"`
+	Memory                 float64              `json:"memory"`
+	MessageStats           rabbitmqMessageStats `json:"message_stats"`
+}
+
+// rabbitmqNode is an entry of /api/nodes
+type rabbitmqNode struct {
+	Name          string  `json:"name"`
+	Running       bool    `json:"running"`
+	MemUsed       float64 `json:"mem_used"`
+	MemLimit      float64 `json:"mem_limit"`
+	MemAlarm      bool    `json:"mem_alarm"`
+	DiskFree      float64 `json:"disk_free"`
+	DiskFreeLimit float64 `json:"disk_free_limit"`
+	DiskFreeAlarm bool    `json:"disk_free_alarm"`
+	FDUsed        float64 `json:"fd_used"`
+	FDTotal       float64 `json:"fd_total"`
+}
+
+// Collect collects the metrics of every server
+func (rc *RabbitMQCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	results := make([][]*Metric, len(rc.servers))
+	var wg sync.WaitGroup
+	for i, s := range rc.servers {
+		wg.Add(1)
+		go func(i int, s *rabbitmqServer) {
+			defer wg.Done()
+			results[i] = rc.collectServer(ctx, s)
+		}(i, s)
+	}
+	wg.Wait()
+
+	var metrics []*Metric
+	for _, r := range results {
+		metrics = append(metrics, r...)
+	}
+	return metrics, nil
+}
+
+func (rc *RabbitMQCollector) collectServer(ctx context.Context, s *rabbitmqServer) []*Metric {
+	timeout := s.config.Timeout
+	if timeout == 0 {
+		timeout = appServerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	labels := map[string]string{"server": s.config.Name}
+	var overview rabbitmqOverview
+	if err := rc.get(ctx, s, "/api/overview", &overview); err != nil {
+		return []*Metric{upMetric("rabbitmq_up", false, labels, "Whether the management API could be read")}
+	}
+
+	gauge := func(name string, value float64, help string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: labels, Type: MetricTypeGauge, Help: help}
+	}
+	counter := func(name string, value float64, help string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: labels, Type: MetricTypeCounter, Help: help}
+	}
+	metrics := []*Metric{
+		upMetric("rabbitmq_up", true, labels, "Whether the management API could be read"),
+		gauge("rabbitmq_connections", overview.ObjectTotals.Connections, "Open client connections"),
+		gauge("rabbitmq_channels", overview.ObjectTotals.Channels, "Open channels"),
+		gauge("rabbitmq_queues", overview.ObjectTotals.Queues, "Declared queues"),
+		gauge("rabbitmq_consumers", overview.ObjectTotals.Consumers, "Consumers on all queues"),
+		counter("rabbitmq_connections_opened_total", overview.ChurnRates.ConnectionCreated, "Connections opened"),
+		counter("rabbitmq_connections_closed_total", overview.ChurnRates.ConnectionClosed, "Connections closed"),
+		counter("rabbitmq_channels_opened_total", overview.ChurnRates.ChannelCreated, "Channels opened"),
+		counter("rabbitmq_channels_closed_total", overview.ChurnRates.ChannelClosed, "Channels closed"),
+		counter("rabbitmq_queues_declared_total", overview.ChurnRates.QueueDeclared, "Queues declared"),
+		counter("rabbitmq_queues_deleted_total", overview.ChurnRates.QueueDeleted, "Queues deleted"),
+		counter("rabbitmq_messages_published_total", overview.MessageStats.Publish, "Messages published"),
+		counter("rabbitmq_messages_delivered_total", overview.MessageStats.DeliverGet, "Messages delivered to consumers or fetched"),
+		counter("rabbitmq_messages_acked_total", overview.MessageStats.Ack, "Messages acknowledged by consumers"),
+		counter("rabbitmq_messages_redelivered_total", overview.MessageStats.Redeliver, "Messages delivered again after a reject or lost consumer"),
+	}
+
+	var queues []rabbitmqQueue
+	if err := rc.get(ctx, s, "/api/queues", &queues); err == nil {
+		metrics = append(metrics, s.queueMetrics(queues, labels)...)
+	}
+
+	var nodes []rabbitmqNode
+	if err := rc.get(ctx, s, "/api/nodes", &nodes); err == nil {
+		metrics = append(metrics, rabbitmqNodeMetrics(nodes, labels)...)
+	}
+	return metrics
+}
+
+// queueMetrics reports the depth and consumers of each queue, and how
+// many queues are growing
+func (s *rabbitmqServer) queueMetrics(queues []rabbitmqQueue, labels map[string]string) []*Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	seen := make(map[string]bool, len(queues))
+	var (
+		metrics []*Metric
+		growing int
+	)
+	for _, q := range queues {
+		if s.vhosts != nil && !s.vhosts[q.VHost] {
+			continue
+		}
+		if s.pattern != nil && !s.pattern.MatchString(q.Name) {
+			continue
+		}
+		key := q.VHost + "/" + q.Name
+		seen[key] = true
+
+		qLabels := withLabel(withLabel(labels, "vhost", q.VHost), "queue", q.Name)
+		gauge := func(name string, value float64, help string) *Metric {
+			return &Metric{Name: name, Value: value, Labels: qLabels, Type: MetricTypeGauge, Help: help}
+		}
+		counter := func(name string, value float64, help string) *Metric {
+			return &Metric{Name: name, Value: value, Labels: qLabels, Type: MetricTypeCounter, Help: help}
+		}
+		metrics = append(metrics,
+			gauge("rabbitmq_queue_messages", q.Messages, "Messages in the queue"),
+			gauge("rabbitmq_queue_messages_ready", q.MessagesReady, "Messages ready for delivery"),
+			gauge("rabbitmq_queue_messages_unacked", q.MessagesUnacknowledged, "Messages delivered but not yet acknowledged"),
+			gauge("rabbitmq_queue_consumers", q.Consumers, "Consumers of the queue"),
+			&Metric{Name: "rabbitmq_queue_memory_bytes", Value: q.Memory, Labels: qLabels, Type: MetricTypeGauge, Help: "Memory used by the queue", Unit: "bytes"},
+			gauge("rabbitmq_queue_running", boolToFloat(q.State == "" || q.State == "running"), "Whether the queue process is running"),
+			counter("rabbitmq_queue_messages_published_total", q.MessageStats.Publish, "Messages published to the queue"),
+			counter("rabbitmq_queue_messages_delivered_total", q.MessageStats.DeliverGet, "Messages delivered from the queue"),
+			counter("rabbitmq_queue_messages_acked_total", q.MessageStats.Ack, "Messages acknowledged from the queue"),
+		)
+
+		// Depth change per second over the growth window, measured from
+		// the oldest sample still inside it
+		samples := append(s.depths[key], rabbitmqDepth{at: now, messages: q.Messages})
+		for len(samples) > 2 && now.Sub(samples[1].at) >= s.config.GrowthWindow {
+			samples = samples[1:]
+		}
+		s.depths[key] = samples
+		if oldest := samples[0]; len(samples) > 1 && now.Sub(oldest.at) > 0 {
+			growth := (q.Messages - oldest.messages) / now.Sub(oldest.at).Seconds()
+			metrics = append(metrics, gauge("rabbitmq_queue_messages_growth_per_second", growth,
+				"Change in queue depth per second over the growth window"))
+			if growth > 0 && now.Sub(oldest.at) >= s.config.GrowthWindow {
+				growing++
+			}
+		}
+	}
+	for key := range s.depths {
+		if !seen[key] {
+			delete(s.depths, key)
+		}
+	}
+
+	return append(metrics, &Metric{
+		Name:   "rabbitmq_queues_growing",
+		Value:  float64(growing),
+		Labels: labels,
+		Type:   MetricTypeGauge,
+		Help:   "Queues whose depth rose over the whole growth window",
+	})
+}
+
+// rabbitmqNodeMetrics reports the resources of each cluster node. The
+// node is labelled broker, as node names the agent's host.
+func rabbitmqNodeMetrics(nodes []rabbitmqNode, labels map[string]string) []*Metric {
+	var (
+		metrics []*Metric
+		alarms  int
+	)
+	for _, n := range nodes {
+		nLabels := withLabel(labels, "broker", n.Name)
+		gauge := func(name string, value float64, help, unit string) *Metric {
+			return &Metric{Name: name, Value: value, Labels: nLabels, Type: MetricTypeGauge, Help: help, Unit: unit}
+		}
+		metrics = append(metrics,
+			gauge("rabbitmq_node_running", boolToFloat(n.Running), "Whether the cluster node is running", ""),
+			gauge("rabbitmq_node_memory_used_bytes", n.MemUsed, "Memory used by the node", "bytes"),
+			gauge("rabbitmq_node_memory_limit_bytes", n.MemLimit, "Memory high watermark of the node", "bytes"),
+			gauge("rabbitmq_node_memory_alarm", boolToFloat(n.MemAlarm), "Whether publishers are blocked by the memory alarm", ""),
+			gauge("rabbitmq_node_disk_free_bytes", n.DiskFree, "Free disk space on the node", "bytes"),
+			gauge("rabbitmq_node_disk_free_limit_bytes", n.DiskFreeLimit, "Free disk space below which the disk alarm is raised", "bytes"),
+			gauge("rabbitmq_node_disk_free_alarm", boolToFloat(n.DiskFreeAlarm), "Whether publishers are blocked by the disk alarm", ""),
+			gauge("rabbitmq_node_fds_used", n.FDUsed, "File descriptors used by the node", ""),
+			gauge("rabbitmq_node_fds_limit", n.FDTotal, "File descriptors available to the node", ""),
+		)
+		if n.MemAlarm {
+			alarms++
+		}
+		if n.DiskFreeAlarm {
+			alarms++
+		}
+	}
+	return append(metrics, &Metric{
+		Name:   "rabbitmq_resource_alarms",
+		Value:  float64(alarms),
+		Labels: labels,
+		Type:   MetricTypeGauge,
+		Help:   "Memory and disk alarms raised across the cluster",
+	})
+}
+
+// get fetches a management API document
+func (rc *RabbitMQCollector) get(ctx context.Context, s *rabbitmqServer, path string, v interface{}) error {
+	u := strings.TrimRight(s.config.URL, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.config.Username, s.config.Password)
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
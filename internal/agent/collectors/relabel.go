@@ -0,0 +1,127 @@
+package collectors
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// metricNameLabel holds the metric name while metrics are relabelled
+const metricNameLabel = "__name__"
+
+// Relabel actions, as in Prometheus
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelMap  = "labelmap"
+	relabelLabelDrop = "labeldrop"
+	relabelLabelKeep = "labelkeep"
+)
+
+// RelabelRule rewrites the labels of scraped metrics like a Prometheus
+// metric_relabel_configs entry. The metric name is the __name__ label.
+type RelabelRule struct {
+	SourceLabels []string
+	Separator    string
+	Regex        string
+	TargetLabel  string
+	Replacement  string
+	Action       string
+}
+
+type relabeler struct {
+	rule  RelabelRule
+	regex *regexp.Regexp
+}
+
+// compileRelabelRules validates rules and fills in the Prometheus defaults
+func compileRelabelRules(rules []RelabelRule) ([]*relabeler, error) {
+	compiled := make([]*relabeler, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Action == "" {
+			rule.Action = relabelReplace
+		}
+		if rule.Separator == "" {
+			rule.Separator = ";"
+		}
+		if rule.Regex == "" {
+			rule.Regex = "(.*)"
+		}
+		if rule.Replacement == "" {
+			rule.Replacement = "$1"
+		}
+		regex, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("relabel rule %d: invalid regex: %w", i+1, err)
+		}
+
+		switch rule.Action {
+		case relabelReplace:
+			if rule.TargetLabel == "" {
+				return nil, fmt.Errorf("relabel rule %d: replace needs a target_label", i+1)
+			}
+		case relabelKeep, relabelDrop:
+			if len(rule.SourceLabels) == 0 {
+				return nil, fmt.Errorf("relabel rule %d: %s needs source_labels", i+1, rule.Action)
+			}
+		case relabelLabelMap, relabelLabelDrop, relabelLabelKeep:
+		default:
+			return nil, fmt.Errorf("relabel rule %d: unknown action %q", i+1, rule.Action)
+		}
+		compiled = append(compiled, &relabeler{rule: rule, regex: regex})
+	}
+	return compiled, nil
+}
+
+// relabel applies the rules to a metric's labels, with its name as
+// __name__, and reports whether the metric is kept
+func relabel(labels map[string]string, rules []*relabeler) bool {
+	for _, r := range rules {
+		switch r.rule.Action {
+		case relabelReplace, relabelKeep, relabelDrop:
+			values := make([]string, len(r.rule.SourceLabels))
+			for i, name := range r.rule.SourceLabels {
+				values[i] = labels[name]
+			}
+			value := strings.Join(values, r.rule.Separator)
+			match := r.regex.FindStringSubmatchIndex(value)
+
+			switch r.rule.Action {
+			case relabelKeep:
+				if match == nil {
+					return false
+				}
+			case relabelDrop:
+				if match != nil {
+					return false
+				}
+			case relabelReplace:
+				if match == nil {
+					continue
+				}
+				target := string(r.regex.ExpandString(nil, r.rule.TargetLabel, value, match))
+				replacement := string(r.regex.ExpandString(nil, r.rule.Replacement, value, match))
+				if replacement == "" {
+					delete(labels, target)
+				} else {
+					labels[target] = replacement
+				}
+			}
+
+		case relabelLabelMap:
+			for name, value := range labels {
+				if match := r.regex.FindStringSubmatchIndex(name); match != nil {
+					labels[string(r.regex.ExpandString(nil, r.rule.Replacement, name, match))] = value
+				}
+			}
+		case relabelLabelDrop, relabelLabelKeep:
+			for name := range labels {
+				if r.regex.MatchString(name) == (r.rule.Action == relabelLabelDrop) {
+					delete(labels, name)
+				}
+			}
+		}
+	}
+	return labels[metricNameLabel] != ""
+}
@@ -0,0 +1,278 @@
+package collectors
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// ScrapeCollectorConfig holds configuration for the scrape collector
+type ScrapeCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Targets  []ScrapeTarget
+}
+
+// ScrapeTarget is an endpoint exposing metrics in the Prometheus text
+// format, e.g. http://127.0.0.1:8080/metrics. Interval scrapes it less
+// often than the collector; SampleLimit fails scrapes with more samples
+// after relabelling.
+type ScrapeTarget struct {
+	Name                 string
+	URL                  string
+	Interval             time.Duration
+	Timeout              time.Duration
+	Username             string
+	Password             string
+	BearerToken          string
+	InsecureSkipVerify   bool
+	Labels               map[string]string
+	HonorLabels          bool
+	SampleLimit          int
+	MetricRelabelConfigs []RelabelRule
+}
+
+// maxScrapeSize bounds the bytes read from a target
+const maxScrapeSize = 32 << 20
+
+// scrapeAccept asks for the Prometheus text format, which is what the
+// collector parses
+const scrapeAccept = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+
+// ScrapeCollector scrapes applications instrumented for Prometheus and
+// reports their metrics labelled with job and instance. Every target also
+// reports up, scrape_duration_seconds, scrape_samples_scraped and
+// scrape_samples_post_metric_relabeling, as Prometheus does.
+type ScrapeCollector struct {
+	*BaseCollector
+	targets []*scrapeTarget
+
+	mu      sync.Mutex
+	lastRun map[string]time.Time
+
+	inventoryMu sync.RWMutex
+	inventory   map[string]string
+}
+
+type scrapeTarget struct {
+	config ScrapeTarget
+	client *http.Client
+	rules  []*relabeler
+	// labels are added to every metric: job, instance and the configured
+	// labels
+	labels map[string]string
+}
+
+// NewScrapeCollector creates a new scrape collector
+func NewScrapeCollector(config ScrapeCollectorConfig) (*ScrapeCollector, error) {
+	sc := &ScrapeCollector{
+		BaseCollector: NewBaseCollector("scrape", config.Enabled, config.Interval),
+		lastRun:       make(map[string]time.Time),
+		inventory:     make(map[string]string),
+	}
+	for _, t := range config.Targets {
+		u, err := url.Parse(t.URL)
+		if err != nil || !isHTTPAddress(t.URL) || u.Host == "" {
+			return nil, fmt.Errorf("scrape target %s: url must be an http(s) URL", t.Name)
+		}
+		rules, err := compileRelabelRules(t.MetricRelabelConfigs)
+		if err != nil {
+			return nil, fmt.Errorf("scrape target %s: %w", t.Name, err)
+		}
+		if t.Timeout == 0 {
+			t.Timeout = appServerTimeout
+		}
+
+		labels := map[string]string{"job": t.Name, "instance": u.Host}
+		for k, v := range t.Labels {
+			labels[k] = v
+		}
+		sc.targets = append(sc.targets, &scrapeTarget{
+			config: t,
+			client: &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify},
+				},
+			},
+			rules:  rules,
+			labels: labels,
+		})
+	}
+	return sc, nil
+}
+
+// Collect scrapes the targets that are due
+func (sc *ScrapeCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	now := time.Now()
+	sc.mu.Lock()
+	due := make([]*scrapeTarget, 0, len(sc.targets))
+	for _, t := range sc.targets {
+		interval := t.config.Interval
+		if interval > 0 && now.Sub(sc.lastRun[t.config.Name]) < interval-interval/10 {
+			continue
+		}
+		sc.lastRun[t.config.Name] = now
+		due = append(due, t)
+	}
+	sc.mu.Unlock()
+
+	results := make([][]*Metric, len(due))
+	errs := make([]error, len(due))
+	var wg sync.WaitGroup
+	for i, t := range due {
+		wg.Add(1)
+		go func(i int, t *scrapeTarget) {
+			defer wg.Done()
+			results[i], errs[i] = t.scrape(ctx)
+		}(i, t)
+	}
+	wg.Wait()
+
+	sc.inventoryMu.Lock()
+	for i, t := range due {
+		key := models.InventoryScrapeTargetError(t.config.Name)
+		if errs[i] != nil {
+			sc.inventory[key] = errs[i].Error()
+		} else {
+			delete(sc.inventory, key)
+		}
+	}
+	sc.inventoryMu.Unlock()
+
+	var metrics []*Metric
+	for _, r := range results {
+		metrics = append(metrics, r...)
+	}
+	return metrics, nil
+}
+
+// Inventory reports why targets last failed
+func (sc *ScrapeCollector) Inventory() map[string]string {
+	sc.inventoryMu.RLock()
+	defer sc.inventoryMu.RUnlock()
+
+	inventory := make(map[string]string, len(sc.inventory))
+	for k, v := range sc.inventory {
+		inventory[k] = v
+	}
+	return inventory
+}
+
+// scrape reads a target and labels and relabels its metrics, adding the
+// scrape's status
+func (t *scrapeTarget) scrape(ctx context.Context) ([]*Metric, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, t.config.Timeout)
+	defer cancel()
+
+	scraped, err := t.fetch(ctx)
+	var metrics []*Metric
+	if err == nil {
+		metrics, err = t.process(scraped)
+	}
+
+	status := func(name string, value float64, help, unit string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: t.statusLabels(), Type: MetricTypeGauge, Help: help, Unit: unit}
+	}
+	metrics = append(metrics,
+		upMetric("up", err == nil, t.statusLabels(), "Whether the target was scraped"),
+		status("scrape_duration_seconds", time.Since(start).Seconds(), "Time the scrape took", "seconds"),
+		status("scrape_samples_scraped", float64(len(scraped)), "Samples the target exposed", ""),
+		status("scrape_samples_post_metric_relabeling", float64(len(metrics)), "Samples left after metric relabelling", ""),
+	)
+	return metrics, err
+}
+
+func (t *scrapeTarget) statusLabels() map[string]string {
+	return map[string]string{"job": t.labels["job"], "instance": t.labels["instance"]}
+}
+
+// fetch reads and parses the target's metrics
+func (t *scrapeTarget) fetch(ctx context.Context) ([]*Metric, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", scrapeAccept)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(t.config.Timeout.Seconds(), 'f', -1, 64))
+	switch {
+	case t.config.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+t.config.BearerToken)
+	case t.config.Username != "":
+		req.SetBasicAuth(t.config.Username, t.config.Password)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxScrapeSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics: %w", err)
+	}
+	if len(data) > maxScrapeSize {
+		return nil, fmt.Errorf("metrics exceed %d bytes", maxScrapeSize)
+	}
+	metrics, err := parsePrometheusText(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// process adds the target's labels to the scraped metrics and relabels
+// them. Scraped labels that clash with the target's are kept as
+// exported_<name> unless HonorLabels is set; node and collector are set by
+// the agent, so they are always renamed.
+func (t *scrapeTarget) process(scraped []*Metric) ([]*Metric, error) {
+	metrics := make([]*Metric, 0, len(scraped))
+	for _, metric := range scraped {
+		labels := metric.Labels
+		for _, name := range []string{"node", "collector"} {
+			if v, ok := labels[name]; ok {
+				labels["exported_"+name] = v
+				delete(labels, name)
+			}
+		}
+		for k, v := range t.labels {
+			if existing, ok := labels[k]; ok {
+				if t.config.HonorLabels {
+					continue
+				}
+				labels["exported_"+k] = existing
+			}
+			labels[k] = v
+		}
+
+		labels[metricNameLabel] = metric.Name
+		if !relabel(labels, t.rules) || !validMetricName(labels[metricNameLabel]) {
+			continue
+		}
+		metric.Name = labels[metricNameLabel]
+		for k := range labels {
+			if strings.HasPrefix(k, "__") {
+				delete(labels, k)
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	if t.config.SampleLimit > 0 && len(metrics) > t.config.SampleLimit {
+		return nil, fmt.Errorf("%d samples exceed the sample limit of %d", len(metrics), t.config.SampleLimit)
+	}
+	return metrics, nil
+}
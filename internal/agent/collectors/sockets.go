@@ -0,0 +1,439 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SocketCollectorConfig holds configuration for the socket collector.
+// Processes breaks TCP connections down by the process holding them for
+// the TopProcesses processes with the most; reading other users' file
+// descriptors needs root or CAP_SYS_PTRACE.
+type SocketCollectorConfig struct {
+	Enabled      bool
+	Interval     time.Duration
+	Processes    bool
+	TopProcesses int
+	// ProcPath is where procfs is mounted, /proc by default; set it to the
+	// host's when the agent runs in a container
+	ProcPath string
+}
+
+// tcpStates names the states of /proc/net/tcp by their hex code
+var tcpStates = map[string]string{
+	"01": "established",
+	"02": "syn_sent",
+	"03": "syn_recv",
+	"04": "fin_wait1",
+	"05": "fin_wait2",
+	"06": "time_wait",
+	"07": "close",
+	"08": "close_wait",
+	"09": "last_ack",
+	"0A": "listen",
+	"0B": "closing",
+	"0C": "new_syn_recv",
+}
+
+// udpErrors are the /proc/net/snmp Udp counters reported, by the type
+// label they are reported with
+var udpErrors = map[string]string{
+	"InErrors":     "receive",
+	"RcvbufErrors": "receive_buffer",
+	"SndbufErrors": "send_buffer",
+	"NoPorts":      "no_port",
+}
+
+// defaultTopProcesses is used when no process limit is configured
+const defaultTopProcesses = 10
+
+// SocketCollector reports TCP connections by state, listening sockets,
+// UDP errors and drops, and conntrack table usage from procfs, which show
+// connection and port exhaustion that interface counters do not
+type SocketCollector struct {
+	*BaseCollector
+	config SocketCollectorConfig
+}
+
+// NewSocketCollector creates a new socket collector
+func NewSocketCollector(config SocketCollectorConfig) (*SocketCollector, error) {
+	if config.ProcPath == "" {
+		config.ProcPath = "/proc"
+	}
+	if config.TopProcesses <= 0 {
+		config.TopProcesses = defaultTopProcesses
+	}
+	if _, err := os.Stat(filepath.Join(config.ProcPath, "net", "tcp")); err != nil {
+		return nil, fmt.Errorf("socket statistics are not available: %w", err)
+	}
+	return &SocketCollector{
+		BaseCollector: NewBaseCollector("sockets", config.Enabled, config.Interval),
+		config:        config,
+	}, nil
+}
+
+// socketEntry is a socket of /proc/net/{tcp,udp}[6]
+type socketEntry struct {
+	state string
+	inode string
+}
+
+// Collect collects socket and conntrack metrics
+func (sc *SocketCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	tcp, err := sc.readSockets("tcp", "tcp6")
+	if err != nil {
+		return nil, err
+	}
+	udp, err := sc.readSockets("udp", "udp6")
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]int, len(tcpStates))
+	for _, name := range tcpStates {
+		states[name] = 0
+	}
+	for _, s := range tcp {
+		states[s.state]++
+	}
+	// An unconnected UDP socket is in the close state
+	var udpBound int
+	for _, s := range udp {
+		if s.state == "close" {
+			udpBound++
+		}
+	}
+
+	var metrics []*Metric
+	for state, n := range states {
+		metrics = append(metrics, &Metric{
+			Name:   "system_tcp_connections",
+			Value:  float64(n),
+			Labels: map[string]string{"state": state},
+			Type:   MetricTypeGauge,
+			Help:   "TCP sockets by connection state",
+		})
+	}
+	metrics = append(metrics,
+		&Metric{
+			Name:   "system_sockets_listening",
+			Value:  float64(states["listen"]),
+			Labels: map[string]string{"protocol": "tcp"},
+			Type:   MetricTypeGauge,
+			Help:   "Listening TCP sockets and bound, unconnected UDP sockets",
+		},
+		&Metric{
+			Name:   "system_sockets_listening",
+			Value:  float64(udpBound),
+			Labels: map[string]string{"protocol": "udp"},
+			Type:   MetricTypeGauge,
+			Help:   "Listening TCP sockets and bound, unconnected UDP sockets",
+		},
+	)
+
+	metrics = append(metrics, sc.sockstatMetrics()...)
+	metrics = append(metrics, sc.snmpMetrics()...)
+	metrics = append(metrics, sc.conntrackMetrics()...)
+
+	if sc.config.Processes {
+		metrics = append(metrics, sc.processMetrics(ctx, tcp)...)
+	}
+	return metrics, nil
+}
+
+// readSockets reads the sockets of the given /proc/net tables. A missing
+// table, such as tcp6 without IPv6, is skipped.
+func (sc *SocketCollector) readSockets(tables ...string) ([]socketEntry, error) {
+	var sockets []socketEntry
+	for _, table := range tables {
+		f, err := os.Open(filepath.Join(sc.config.ProcPath, "net", table))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 {
+				continue
+			}
+			state, ok := tcpStates[fields[3]]
+			if !ok {
+				continue
+			}
+			sockets = append(sockets, socketEntry{state: state, inode: fields[9]})
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read /proc/net/%s: %w", table, err)
+		}
+	}
+	return sockets, nil
+}
+
+// sockstatMetrics reports the sockets in use and orphaned TCP sockets
+func (sc *SocketCollector) sockstatMetrics() []*Metric {
+	stats, err := readProcTable(filepath.Join(sc.config.ProcPath, "net", "sockstat"))
+	if err != nil {
+		return nil
+	}
+
+	var metrics []*Metric
+	if used, ok := stats["sockets"]["used"]; ok {
+		metrics = append(metrics, &Metric{
+			Name:  "system_sockets_used",
+			Value: used,
+			Type:  MetricTypeGauge,
+			Help:  "Sockets in use of every protocol",
+		})
+	}
+	if orphans, ok := stats["TCP"]["orphan"]; ok {
+		metrics = append(metrics, &Metric{
+			Name:  "system_tcp_orphans",
+			Value: orphans,
+			Type:  MetricTypeGauge,
+			Help:  "TCP sockets no longer attached to a file descriptor",
+		})
+	}
+	return metrics
+}
+
+// snmpMetrics reports UDP errors and TCP listen queue overflows
+func (sc *SocketCollector) snmpMetrics() []*Metric {
+	var metrics []*Metric
+
+	if snmp, err := readProcCounters(filepath.Join(sc.config.ProcPath, "net", "snmp")); err == nil {
+		// IPv6 counters are named Udp6InErrors and so on, one per line
+		snmp6, _ := readKeyValueFile(filepath.Join(sc.config.ProcPath, "net", "snmp6"))
+		for counter, errType := range udpErrors {
+			value, ok := snmp["Udp"][counter]
+			if !ok {
+				continue
+			}
+			metrics = append(metrics, &Metric{
+				Name:   "system_udp_errors_total",
+				Value:  value + float64(snmp6["Udp6"+counter]),
+				Labels: map[string]string{"type": errType},
+				Type:   MetricTypeCounter,
+				Help:   "UDP datagrams dropped by type, such as a full receive buffer",
+			})
+		}
+	}
+
+	if netstat, err := readProcCounters(filepath.Join(sc.config.ProcPath, "net", "netstat")); err == nil {
+		if v, ok := netstat["TcpExt"]["ListenOverflows"]; ok {
+			metrics = append(metrics, &Metric{
+				Name:  "system_tcp_listen_overflows_total",
+				Value: v,
+				Type:  MetricTypeCounter,
+				Help:  "Connections dropped because a listen queue was full",
+			})
+		}
+		if v, ok := netstat["TcpExt"]["ListenDrops"]; ok {
+			metrics = append(metrics, &Metric{
+				Name:  "system_tcp_listen_drops_total",
+				Value: v,
+				Type:  MetricTypeCounter,
+				Help:  "Connections dropped by listening sockets for any reason",
+			})
+		}
+	}
+	return metrics
+}
+
+// conntrackMetrics reports the connection tracking table's size and
+// limit. Nodes without the nf_conntrack module report nothing.
+func (sc *SocketCollector) conntrackMetrics() []*Metric {
+	dir := filepath.Join(sc.config.ProcPath, "sys", "net", "netfilter")
+	count, err := readUintFile(filepath.Join(dir, "nf_conntrack_count"))
+	if err != nil {
+		return nil
+	}
+	max, err := readUintFile(filepath.Join(dir, "nf_conntrack_max"))
+	if err != nil {
+		return nil
+	}
+
+	metrics := []*Metric{
+		{
+			Name:  "system_conntrack_entries",
+			Value: float64(count),
+			Type:  MetricTypeGauge,
+			Help:  "Connections in the conntrack table",
+		},
+		{
+			Name:  "system_conntrack_limit",
+			Value: float64(max),
+			Type:  MetricTypeGauge,
+			Help:  "Size of the conntrack table; new connections are dropped once it is full",
+		},
+	}
+	if max > 0 {
+		metrics = append(metrics, &Metric{
+			Name:  "system_conntrack_usage_ratio",
+			Value: float64(count) / float64(max),
+			Type:  MetricTypeGauge,
+			Help:  "Share of the conntrack table in use",
+		})
+	}
+	return metrics
+}
+
+// processMetrics counts the TCP connections of the processes holding the
+// most, by matching socket inodes to their file descriptors. Processes
+// with the same name are counted together.
+func (sc *SocketCollector) processMetrics(ctx context.Context, tcp []socketEntry) []*Metric {
+	states := make(map[string]string, len(tcp))
+	for _, s := range tcp {
+		if s.inode != "0" {
+			states[s.inode] = s.state
+		}
+	}
+
+	entries, err := os.ReadDir(sc.config.ProcPath)
+	if err != nil {
+		return nil
+	}
+	counts := make(map[string]map[string]int)
+	totals := make(map[string]int)
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		pidDir := filepath.Join(sc.config.ProcPath, entry.Name())
+		fds, err := os.ReadDir(filepath.Join(pidDir, "fd"))
+		if err != nil {
+			continue
+		}
+
+		var byState map[string]int
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(pidDir, "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			state, ok := states[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")]
+			if !ok {
+				continue
+			}
+			if byState == nil {
+				byState = make(map[string]int)
+			}
+			byState[state]++
+		}
+		if byState == nil {
+			continue
+		}
+
+		comm, err := os.ReadFile(filepath.Join(pidDir, "comm"))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(string(comm))
+		if counts[name] == nil {
+			counts[name] = make(map[string]int)
+		}
+		for state, n := range byState {
+			counts[name][state] += n
+			totals[name] += n
+		}
+	}
+
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]] != totals[names[j]] {
+			return totals[names[i]] > totals[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > sc.config.TopProcesses {
+		names = names[:sc.config.TopProcesses]
+	}
+
+	var metrics []*Metric
+	for _, name := range names {
+		for state, n := range counts[name] {
+			metrics = append(metrics, &Metric{
+				Name:   "system_process_tcp_connections",
+				Value:  float64(n),
+				Labels: map[string]string{"process": name, "state": state},
+				Type:   MetricTypeGauge,
+				Help:   "TCP sockets held by the processes with the most, by process name and state",
+			})
+		}
+	}
+	return metrics
+}
+
+// readProcTable parses files like /proc/net/sockstat, whose lines are a
+// prefix followed by name and value pairs, e.g. "TCP: inuse 5 orphan 0"
+func readProcTable(path string) (map[string]map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	table := make(map[string]map[string]float64)
+	for _, line := range strings.Split(string(data), "\n") {
+		prefix, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		values := make(map[string]float64, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if v, err := strconv.ParseFloat(fields[i+1], 64); err == nil {
+				values[fields[i]] = v
+			}
+		}
+		table[prefix] = values
+	}
+	return table, nil
+}
+
+// readProcCounters parses files like /proc/net/snmp, where a line of
+// counter names is followed by a line of their values with the same
+// prefix, e.g. "Udp: InDatagrams NoPorts" then "Udp: 10 0"
+func readProcCounters(path string) (map[string]map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]map[string]float64)
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i+1 < len(lines); i += 2 {
+		names, values := strings.Fields(lines[i]), strings.Fields(lines[i+1])
+		if len(names) == 0 || len(names) != len(values) || names[0] != values[0] {
+			continue
+		}
+		prefix := strings.TrimSuffix(names[0], ":")
+		group := make(map[string]float64, len(names)-1)
+		for j := 1; j < len(names); j++ {
+			if v, err := strconv.ParseFloat(values[j], 64); err == nil {
+				group[names[j]] = v
+			}
+		}
+		counters[prefix] = group
+	}
+	return counters, nil
+}
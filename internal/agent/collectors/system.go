@@ -22,6 +22,8 @@ type SystemCollector struct {
 	lastCPU    map[string]cpu.TimesStat
 	lastNet    map[string]net.IOCountersStat
 	lastDisk   map[string]disk.IOCountersStat
+	lastCgroup map[string]cgroupSample
+	lastSocket map[string]cpu.TimesStat
 	processors int
 }
 
@@ -29,13 +31,22 @@ type SystemConfig struct {
 	Enabled  bool          `yaml:"enabled"`
 	Interval time.Duration `yaml:"interval"`
 	Metrics  struct {
-		CPU     bool `yaml:"cpu"`
-		Memory  bool `yaml:"memory"`
-		Load    bool `yaml:"load"`
-		Disk    bool `yaml:"disk"`
-		Network bool `yaml:"network"`
-		Uptime  bool `yaml:"uptime"`
+		CPU       bool `yaml:"cpu"`
+		Memory    bool `yaml:"memory"`
+		Load      bool `yaml:"load"`
+		Disk      bool `yaml:"disk"`
+		Network   bool `yaml:"network"`
+		Uptime    bool `yaml:"uptime"`
+		Pressure  bool `yaml:"pressure"`
+		Cgroup    bool `yaml:"cgroup"`
+		NUMA      bool `yaml:"numa"`
+		Hugepages bool `yaml:"hugepages"`
+		Sockets   bool `yaml:"sockets"`
 	} `yaml:"metrics"`
+	Cgroup struct {
+		Root  string `yaml:"root"`
+		Depth int    `yaml:"depth"`
+	} `yaml:"cgroup"`
 	Disk struct {
 		IgnoreFSTypes   []string `yaml:"ignore_fs_types"`
 		IgnoreMounts    []string `yaml:"ignore_mounts"`
@@ -54,6 +65,8 @@ func NewSystemCollector(config SystemConfig) (*SystemCollector, error) {
 		lastCPU:    make(map[string]cpu.TimesStat),
 		lastNet:    make(map[string]net.IOCountersStat),
 		lastDisk:   make(map[string]disk.IOCountersStat),
+		lastCgroup: make(map[string]cgroupSample),
+		lastSocket: make(map[string]cpu.TimesStat),
 		processors: runtime.NumCPU(),
 	}
 
@@ -129,6 +142,35 @@ func (c *SystemCollector) Collect(ctx context.Context) ([]*Metric, error) {
 		metrics = append(metrics, uptimeMetrics...)
 	}
 
+	// Collect pressure stall information
+	if c.config.Metrics.Pressure {
+		metrics = append(metrics, c.collectPressureMetrics()...)
+	}
+
+	// Collect cgroup v2 resource accounting
+	if c.config.Metrics.Cgroup {
+		metrics = append(metrics, c.collectCgroupMetrics()...)
+	}
+
+	// Collect NUMA node memory metrics
+	if c.config.Metrics.NUMA {
+		metrics = append(metrics, c.collectNUMAMetrics()...)
+	}
+
+	// Collect hugepage pool metrics
+	if c.config.Metrics.Hugepages {
+		metrics = append(metrics, c.collectHugepageMetrics()...)
+	}
+
+	// Collect per-socket CPU metrics
+	if c.config.Metrics.Sockets {
+		socketMetrics, err := c.collectSocketMetrics()
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect socket metrics: %w", err)
+		}
+		metrics = append(metrics, socketMetrics...)
+	}
+
 	return metrics, nil
 }
 
@@ -0,0 +1,432 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SystemdCollectorConfig holds configuration for the systemd collector.
+// Units are unit names or globs. Reading other users' journal entries
+// needs root or the systemd-journal group.
+type SystemdCollectorConfig struct {
+	Enabled         bool
+	Interval        time.Duration
+	Units           []string
+	CrashLoopWindow time.Duration
+	Timeout         time.Duration
+	Journal         bool
+	// JournalPriority is the least severe priority counted
+	JournalPriority string
+}
+
+// journalPriorities are the syslog priorities by their value
+var journalPriorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// unitStates are the active states a unit can be in
+var unitStates = []string{"active", "reloading", "inactive", "failed", "activating", "deactivating"}
+
+// journalOther labels the messages of units that are not monitored
+const journalOther = "other"
+
+// SystemdCollector reports the state of systemd units and how often they
+// restarted, through systemctl, and counts the messages logged to the
+// journal at or above a priority, through journalctl.
+// systemd_unit_recent_restarts rises when a unit crash-loops.
+type SystemdCollector struct {
+	*BaseCollector
+	config SystemdCollectorConfig
+
+	mu sync.Mutex
+	// restarts holds each unit's restart counts over the crash loop
+	// window, oldest first
+	restarts map[string][]restartSample
+	// The journal is read after cursor, or since the collector started
+	cursor      string
+	journalRead time.Time
+	journalSeen map[journalKey]float64
+}
+
+type restartSample struct {
+	at       time.Time
+	restarts float64
+}
+
+type journalKey struct {
+	unit     string
+	priority string
+}
+
+// systemdTimeout is used when no timeout is configured
+const systemdTimeout = 10 * time.Second
+
+// NewSystemdCollector creates a new systemd collector
+func NewSystemdCollector(config SystemdCollectorConfig) (*SystemdCollector, error) {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return nil, fmt.Errorf("systemctl command not found: %w", err)
+	}
+	if config.Journal {
+		if _, err := exec.LookPath("journalctl"); err != nil {
+			return nil, fmt.Errorf("journalctl command not found: %w", err)
+		}
+	}
+	if config.JournalPriority == "" {
+		config.JournalPriority = "err"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = systemdTimeout
+	}
+	return &SystemdCollector{
+		BaseCollector: NewBaseCollector("systemd", config.Enabled, config.Interval),
+		config:        config,
+		restarts:      make(map[string][]restartSample),
+		journalRead:   time.Now(),
+		journalSeen:   make(map[journalKey]float64),
+	}, nil
+}
+
+// Collect reports the failed units, the state of the monitored ones and
+// the journal messages logged since the last collection
+func (sc *SystemdCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, sc.config.Timeout)
+	defer cancel()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	failed, err := sc.listUnits(ctx, "--state=failed")
+	if err != nil {
+		return nil, err
+	}
+	metrics := []*Metric{{
+		Name:   "systemd_units_failed",
+		Value:  float64(len(failed)),
+		Labels: map[string]string{},
+		Type:   MetricTypeGauge,
+		Help:   "Units in the failed state",
+	}}
+
+	units, err := sc.resolveUnits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(units) > 0 {
+		props, err := sc.showUnits(ctx, units)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, sc.unitMetrics(props, time.Now())...)
+	}
+
+	if sc.config.Journal {
+		journal, err := sc.readJournal(ctx, units)
+		metrics = append(metrics, upMetric("systemd_journal_up", err == nil, map[string]string{},
+			"Whether the journal could be read"))
+		if err == nil {
+			metrics = append(metrics, journal...)
+		}
+	}
+	return metrics, nil
+}
+
+// systemctl runs systemctl and returns its output
+func (sc *SystemdCollector) systemctl(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "systemctl", append([]string{"--no-pager"}, args...)...)
+	cmd.Env = append(os.Environ(), "LANG=C", "LC_ALL=C", "SYSTEMD_COLORS=0")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("systemctl %s failed: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// listUnits returns the loaded units matching the arguments
+func (sc *SystemdCollector) listUnits(ctx context.Context, args ...string) ([]string, error) {
+	out, err := sc.systemctl(ctx, append([]string{"list-units", "--all", "--plain", "--no-legend", "--full"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	var units []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		// Failed and missing units are marked with a bullet
+		if len(fields) > 0 && (fields[0] == "●" || fields[0] == "*") {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			units = append(units, fields[0])
+		}
+	}
+	return units, nil
+}
+
+// resolveUnits expands the configured globs into the loaded units they
+// match. Units configured by name are kept even if they are not loaded,
+// so a missing unit is reported.
+func (sc *SystemdCollector) resolveUnits(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	var names, patterns []string
+	for _, unit := range sc.config.Units {
+		if strings.ContainsAny(unit, "*?[") {
+			patterns = append(patterns, unit)
+		} else if !seen[unit] {
+			seen[unit] = true
+			names = append(names, unit)
+		}
+	}
+	if len(patterns) > 0 {
+		matched, err := sc.listUnits(ctx, patterns...)
+		if err != nil {
+			return nil, err
+		}
+		for _, unit := range matched {
+			if !seen[unit] {
+				seen[unit] = true
+				names = append(names, unit)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// showUnits returns the properties of units, in their order
+func (sc *SystemdCollector) showUnits(ctx context.Context, units []string) ([]map[string]string, error) {
+	args := append([]string{"show", "--property=Id,LoadState,ActiveState,SubState,NRestarts,ExecMainStatus"}, units...)
+	out, err := sc.systemctl(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseSystemctlShow(out), nil
+}
+
+// parseSystemctlShow parses Key=Value blocks separated by blank lines
+func parseSystemctlShow(out []byte) []map[string]string {
+	var blocks []map[string]string
+	var block map[string]string
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			block = nil
+			continue
+		}
+		if block == nil {
+			block = make(map[string]string)
+			blocks = append(blocks, block)
+		}
+		block[key] = value
+	}
+	return blocks
+}
+
+// unitMetrics reports the state, restarts and exit status of units
+func (sc *SystemdCollector) unitMetrics(props []map[string]string, now time.Time) []*Metric {
+	var metrics []*Metric
+	seen := make(map[string]bool, len(props))
+	for _, p := range props {
+		unit := p["Id"]
+		if unit == "" {
+			continue
+		}
+		seen[unit] = true
+		labels := map[string]string{"unit": unit}
+
+		for _, state := range unitStates {
+			metrics = append(metrics, &Metric{
+				Name:   "systemd_unit_state",
+				Value:  boolToFloat(p["ActiveState"] == state),
+				Labels: withLabel(labels, "state", state),
+				Type:   MetricTypeGauge,
+				Help:   "Whether the unit is in the state",
+			})
+		}
+		metrics = append(metrics, &Metric{
+			Name:   "systemd_unit_loaded",
+			Value:  boolToFloat(p["LoadState"] == "loaded"),
+			Labels: withLabel(labels, "load_state", p["LoadState"]),
+			Type:   MetricTypeGauge,
+			Help:   "Whether the unit file was found and loaded",
+		})
+
+		if restarts, err := strconv.ParseFloat(p["NRestarts"], 64); err == nil {
+			metrics = append(metrics,
+				&Metric{
+					Name:   "systemd_unit_restarts_total",
+					Value:  restarts,
+					Labels: map[string]string{"unit": unit},
+					Type:   MetricTypeCounter,
+					Help:   "Automatic restarts of the unit since it was last started",
+				},
+				&Metric{
+					Name:   "systemd_unit_recent_restarts",
+					Value:  sc.recentRestarts(unit, restarts, now),
+					Labels: map[string]string{"unit": unit},
+					Type:   MetricTypeGauge,
+					Help:   "Automatic restarts of the unit within the crash loop window",
+				},
+			)
+		}
+		if status, err := strconv.ParseFloat(p["ExecMainStatus"], 64); err == nil {
+			metrics = append(metrics, &Metric{
+				Name:   "systemd_unit_exit_status",
+				Value:  status,
+				Labels: map[string]string{"unit": unit},
+				Type:   MetricTypeGauge,
+				Help:   "Exit status of the unit's main process when it last exited",
+			})
+		}
+	}
+
+	for unit := range sc.restarts {
+		if !seen[unit] {
+			delete(sc.restarts, unit)
+		}
+	}
+	return metrics
+}
+
+// recentRestarts returns how many times a unit restarted within the crash
+// loop window, from the restart counts seen since then. Restarts before
+// the collector first saw the unit are not counted.
+func (sc *SystemdCollector) recentRestarts(unit string, restarts float64, now time.Time) float64 {
+	history := sc.restarts[unit]
+	if n := len(history); n > 0 && restarts < history[n-1].restarts {
+		// The count is reset when the unit is started by hand
+		history = nil
+	}
+	history = append(history, restartSample{at: now, restarts: restarts})
+
+	// The latest sample from before the window is the baseline
+	cutoff := now.Add(-sc.config.CrashLoopWindow)
+	first := 0
+	for first+1 < len(history) && !history[first+1].at.After(cutoff) {
+		first++
+	}
+	history = history[first:]
+	sc.restarts[unit] = history
+	return restarts - history[0].restarts
+}
+
+// journalEntry holds the fields of a journal entry that are counted
+type journalEntry struct {
+	Cursor   string `json:"__CURSOR"`
+	Unit     string `json:"_SYSTEMD_UNIT"`
+	Priority string `json:"PRIORITY"`
+}
+
+// readJournal counts the messages logged since the last read by unit and
+// priority, reporting the totals and the rate over the interval. Messages
+// of units that are not monitored are counted as other.
+func (sc *SystemdCollector) readJournal(ctx context.Context, units []string) ([]*Metric, error) {
+	args := []string{"--output=json", "--no-pager", "--quiet", "--priority=" + sc.config.JournalPriority}
+	if sc.cursor != "" {
+		args = append(args, "--after-cursor="+sc.cursor)
+	} else {
+		args = append(args, "--since="+sc.journalRead.Format("2006-01-02 15:04:05"))
+	}
+
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	cmd.Env = append(os.Environ(), "LANG=C", "LC_ALL=C")
+	stderr := &limitedBuffer{max: maxScriptStderr}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start journalctl: %w", err)
+	}
+
+	monitored := make(map[string]bool, len(units))
+	for _, unit := range units {
+		monitored[unit] = true
+	}
+	counts := make(map[journalKey]float64)
+	// Entries are counted as they are read, so the cursor of the last
+	// one is kept even if journalctl is stopped by the timeout
+	decoder := json.NewDecoder(bufio.NewReader(stdout))
+	for {
+		var entry journalEntry
+		err := decoder.Decode(&entry)
+		var typeErr *json.UnmarshalTypeError
+		if err != nil && !errors.As(err, &typeErr) {
+			if err != io.EOF {
+				io.Copy(io.Discard, stdout)
+			}
+			break
+		}
+		if entry.Cursor == "" {
+			continue
+		}
+		sc.cursor = entry.Cursor
+
+		unit := entry.Unit
+		if !monitored[unit] {
+			unit = journalOther
+		}
+		priority := "unknown"
+		if p, err := strconv.Atoi(entry.Priority); err == nil && p >= 0 && p < len(journalPriorities) {
+			priority = journalPriorities[p]
+		}
+		counts[journalKey{unit: unit, priority: priority}]++
+	}
+	// The entries read are counted even if journalctl failed, as the
+	// cursor moved past them
+	for key, n := range counts {
+		sc.journalSeen[key] += n
+	}
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("journalctl failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("journalctl failed: %w", err)
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(sc.journalRead).Seconds()
+	sc.journalRead = now
+
+	rates := make(map[string]float64, len(units)+1)
+	for _, unit := range units {
+		rates[unit] = 0
+	}
+	rates[journalOther] = 0
+	for key, n := range counts {
+		rates[key.unit] += n
+	}
+
+	var metrics []*Metric
+	for key, total := range sc.journalSeen {
+		metrics = append(metrics, &Metric{
+			Name:   "systemd_journal_messages_total",
+			Value:  total,
+			Labels: map[string]string{"unit": key.unit, "priority": key.priority},
+			Type:   MetricTypeCounter,
+			Help:   "Messages logged to the journal at or above the configured priority",
+		})
+	}
+	for unit, n := range rates {
+		rate := 0.0
+		if elapsed > 0 {
+			rate = n / elapsed
+		}
+		metrics = append(metrics, &Metric{
+			Name:   "systemd_journal_message_rate",
+			Value:  rate,
+			Labels: map[string]string{"unit": unit},
+			Type:   MetricTypeGauge,
+			Help:   "Messages a second logged at or above the configured priority since the last collection",
+		})
+	}
+	return metrics, nil
+}
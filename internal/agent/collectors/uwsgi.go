@@ -0,0 +1,228 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UWSGICollectorConfig holds configuration for the uWSGI collector
+type UWSGICollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Servers  []UWSGIServer
+}
+
+// UWSGIServer is a uWSGI instance whose stats server is read. Address is
+// the --stats socket, or an http(s) URL with --stats-http.
+type UWSGIServer struct {
+	Name    string
+	Address string
+	Timeout time.Duration
+}
+
+// uwsgiWorkerStates are the worker statuses reported by uWSGI
+var uwsgiWorkerStates = []string{"idle", "busy", "cheap", "pause", "sig"}
+
+// UWSGICollector reports uWSGI worker states, queues and request counts
+// from the stats server
+type UWSGICollector struct {
+	*BaseCollector
+	servers []UWSGIServer
+	client  *http.Client
+}
+
+// NewUWSGICollector creates a new uWSGI collector
+func NewUWSGICollector(config UWSGICollectorConfig) (*UWSGICollector, error) {
+	for _, s := range config.Servers {
+		if s.Address == "" {
+			return nil, fmt.Errorf("uwsgi server %s: address is required", s.Name)
+		}
+	}
+	return &UWSGICollector{
+		BaseCollector: NewBaseCollector("uwsgi", config.Enabled, config.Interval),
+		servers:       config.Servers,
+		client:        &http.Client{},
+	}, nil
+}
+
+// uwsgiStats is the document the stats server returns. Response times
+// are in microseconds.
+type uwsgiStats struct {
+	ListenQueue       int64   `json:"listen_queue"`
+	ListenQueueErrors uint64  `json:"listen_queue_errors"`
+	Load              float64 `json:"load"`
+	Sockets           []struct {
+		Name     string `json:"name"`
+		Queue    int64  `json:"queue"`
+		MaxQueue int64  `json:"max_queue"`
+	} `json:"sockets"`
+	Workers []struct {
+		ID            int     `json:"id"`
+		Status        string  `json:"status"`
+		Requests      uint64  `json:"requests"`
+		Exceptions    uint64  `json:"exceptions"`
+		HarakiriCount uint64  `json:"harakiri_count"`
+		RespawnCount  uint64  `json:"respawn_count"`
+		RSS           uint64  `json:"rss"`
+		TX            uint64  `json:"tx"`
+		AvgRT         float64 `json:"avg_rt"`
+	} `json:"workers"`
+}
+
+// Collect collects the stats of every server
+func (uc *UWSGICollector) Collect(ctx context.Context) ([]*Metric, error) {
+	results := make([][]*Metric, len(uc.servers))
+	var wg sync.WaitGroup
+	for i, s := range uc.servers {
+		wg.Add(1)
+		go func(i int, s UWSGIServer) {
+			defer wg.Done()
+			results[i] = uc.collectServer(ctx, s)
+		}(i, s)
+	}
+	wg.Wait()
+
+	var metrics []*Metric
+	for _, r := range results {
+		metrics = append(metrics, r...)
+	}
+	return metrics, nil
+}
+
+func (uc *UWSGICollector) collectServer(ctx context.Context, s UWSGIServer) []*Metric {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = appServerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	labels := map[string]string{"server": s.Name}
+	stats, err := uc.stats(ctx, s.Address)
+	if err != nil {
+		return []*Metric{upMetric("uwsgi_up", false, labels, "Whether the stats server could be read")}
+	}
+
+	metrics := []*Metric{
+		upMetric("uwsgi_up", true, labels, "Whether the stats server could be read"),
+		{
+			Name:   "uwsgi_listen_queue",
+			Value:  float64(stats.ListenQueue),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Requests waiting in the listen queue",
+		},
+		{
+			Name:   "uwsgi_listen_queue_errors_total",
+			Value:  float64(stats.ListenQueueErrors),
+			Labels: labels,
+			Type:   MetricTypeCounter,
+			Help:   "Requests dropped because the listen queue was full",
+		},
+		{
+			Name:   "uwsgi_load",
+			Value:  stats.Load,
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Requests being handled or queued",
+		},
+	}
+	for _, sock := range stats.Sockets {
+		sockLabels := withLabel(labels, "socket", sock.Name)
+		metrics = append(metrics,
+			&Metric{Name: "uwsgi_socket_queue", Value: float64(sock.Queue), Labels: sockLabels, Type: MetricTypeGauge, Help: "Requests queued on the socket"},
+			&Metric{Name: "uwsgi_socket_queue_max", Value: float64(sock.MaxQueue), Labels: sockLabels, Type: MetricTypeGauge, Help: "Size of the socket's listen queue"},
+		)
+	}
+
+	states := make(map[string]int, len(uwsgiWorkerStates))
+	var (
+		requests, exceptions, harakiri, respawns, rss, tx uint64
+		rtSum                                             float64
+		rtWorkers                                         int
+	)
+	for _, w := range stats.Workers {
+		// Workers report sig0, sig1, ... while handling a signal
+		status := w.Status
+		if strings.HasPrefix(status, "sig") {
+			status = "sig"
+		}
+		states[status]++
+		requests += w.Requests
+		exceptions += w.Exceptions
+		harakiri += w.HarakiriCount
+		respawns += w.RespawnCount
+		rss += w.RSS
+		tx += w.TX
+		if w.Requests > 0 {
+			rtSum += w.AvgRT
+			rtWorkers++
+		}
+	}
+	for _, state := range uwsgiWorkerStates {
+		metrics = append(metrics, &Metric{
+			Name:   "uwsgi_workers",
+			Value:  float64(states[state]),
+			Labels: withLabel(labels, "state", state),
+			Type:   MetricTypeGauge,
+			Help:   "Workers by state",
+		})
+	}
+
+	counter := func(name string, value uint64, help, unit string) *Metric {
+		return &Metric{Name: name, Value: float64(value), Labels: labels, Type: MetricTypeCounter, Help: help, Unit: unit}
+	}
+	metrics = append(metrics,
+		counter("uwsgi_requests_total", requests, "Requests handled by the workers", ""),
+		counter("uwsgi_exceptions_total", exceptions, "Exceptions raised by the workers", ""),
+		counter("uwsgi_harakiri_total", harakiri, "Workers killed by harakiri", ""),
+		counter("uwsgi_respawns_total", respawns, "Worker respawns", ""),
+		counter("uwsgi_transmitted_bytes_total", tx, "Bytes sent by the workers", "bytes"),
+		&Metric{
+			Name:   "uwsgi_worker_memory_bytes",
+			Value:  float64(rss),
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Resident memory of all workers",
+			Unit:   "bytes",
+		},
+	)
+	if rtWorkers > 0 {
+		metrics = append(metrics, &Metric{
+			Name:   "uwsgi_response_time_seconds",
+			Value:  rtSum / float64(rtWorkers) / 1e6,
+			Labels: labels,
+			Type:   MetricTypeGauge,
+			Help:   "Average response time of the workers",
+			Unit:   "seconds",
+		})
+	}
+	return metrics
+}
+
+// stats reads the stats server, which writes its JSON document and closes
+// the connection
+func (uc *UWSGICollector) stats(ctx context.Context, address string) (*uwsgiStats, error) {
+	var stats uwsgiStats
+	if isHTTPAddress(address) {
+		if err := getJSON(ctx, uc.client, address, &stats); err != nil {
+			return nil, err
+		}
+		return &stats, nil
+	}
+
+	conn, err := dialSocket(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := json.NewDecoder(conn).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("failed to parse uwsgi stats: %w", err)
+	}
+	return &stats, nil
+}
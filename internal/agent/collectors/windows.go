@@ -0,0 +1,333 @@
+//go:build windows
+
+package collectors
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// WindowsServiceCollector reports the state of Windows services
+type WindowsServiceCollector struct {
+	*BaseCollector
+	services []string
+}
+
+// NewWindowsServiceCollector creates a new Windows service collector
+func NewWindowsServiceCollector(config WindowsServiceCollectorConfig) (*WindowsServiceCollector, error) {
+	return &WindowsServiceCollector{
+		BaseCollector: NewBaseCollector("windows_services", config.Enabled, config.Interval),
+		services:      config.Services,
+	}, nil
+}
+
+// Collect collects service state metrics
+func (wc *WindowsServiceCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	names := wc.services
+	if len(names) == 0 {
+		names, err = m.ListServices()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list services: %w", err)
+		}
+	}
+
+	var metrics []*Metric
+	for _, name := range names {
+		s, err := m.OpenService(name)
+		if err != nil {
+			// Configured service is not installed on this host
+			metrics = append(metrics, serviceStateMetrics(name, "not_installed", "")...)
+			continue
+		}
+
+		status, err := s.Query()
+		if err != nil {
+			s.Close()
+			continue
+		}
+
+		startType := ""
+		if cfg, err := s.Config(); err == nil {
+			startType = serviceStartType(cfg.StartType)
+		}
+		s.Close()
+
+		metrics = append(metrics, serviceStateMetrics(name, serviceStateName(status.State), startType)...)
+	}
+
+	return metrics, nil
+}
+
+func serviceStateMetrics(name, state, startType string) []*Metric {
+	labels := map[string]string{"service": name}
+	if startType != "" {
+		labels["start_type"] = startType
+	}
+
+	metrics := make([]*Metric, 0, len(windowsServiceStates)+1)
+	for _, s := range windowsServiceStates {
+		metrics = append(metrics, &Metric{
+			Name:   "windows_service_state",
+			Value:  boolToFloat(s == state),
+			Labels: mergeLabels(labels, map[string]string{"state": s}),
+			Type:   MetricTypeGauge,
+			Help:   "Windows service state (1 for the current state)",
+		})
+	}
+
+	metrics = append(metrics, &Metric{
+		Name:   "windows_service_running",
+		Value:  boolToFloat(state == "running"),
+		Labels: labels,
+		Type:   MetricTypeGauge,
+		Help:   "Whether the Windows service is running",
+	})
+
+	return metrics
+}
+
+func serviceStateName(state svc.State) string {
+	switch state {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "start_pending"
+	case svc.StopPending:
+		return "stop_pending"
+	case svc.Running:
+		return "running"
+	case svc.ContinuePending:
+		return "continue_pending"
+	case svc.PausePending:
+		return "pause_pending"
+	case svc.Paused:
+		return "paused"
+	default:
+		return "unknown"
+	}
+}
+
+func serviceStartType(startType uint32) string {
+	switch startType {
+	case mgr.StartAutomatic:
+		return "auto"
+	case mgr.StartManual:
+		return "manual"
+	case mgr.StartDisabled:
+		return "disabled"
+	default:
+		return "other"
+	}
+}
+
+// WindowsEventLogCollector counts Event Log entries for selected channels,
+// providers and levels
+type WindowsEventLogCollector struct {
+	*BaseCollector
+	channels []EventLogChannel
+
+	// lastRecord tracks the last EventRecordID seen per channel
+	lastRecord map[string]uint64
+	mu         sync.Mutex
+}
+
+// NewWindowsEventLogCollector creates a new Event Log collector
+func NewWindowsEventLogCollector(config WindowsEventLogCollectorConfig) (*WindowsEventLogCollector, error) {
+	if len(config.Channels) == 0 {
+		return nil, fmt.Errorf("no event log channels configured")
+	}
+
+	for _, ch := range config.Channels {
+		for _, level := range ch.Levels {
+			if _, ok := eventLogLevels[strings.ToLower(level)]; !ok {
+				return nil, fmt.Errorf("channel %s: unknown event level %q", ch.Name, level)
+			}
+		}
+	}
+
+	return &WindowsEventLogCollector{
+		BaseCollector: NewBaseCollector("windows_eventlog", config.Enabled, config.Interval),
+		channels:      config.Channels,
+		lastRecord:    make(map[string]uint64),
+	}, nil
+}
+
+// Collect counts new events since the previous collection
+func (ec *WindowsEventLogCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	var metrics []*Metric
+	for _, ch := range ec.channels {
+		events, err := ec.queryChannel(ctx, ch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query channel %s: %w", ch.Name, err)
+		}
+
+		levels := channelLevels(ch)
+		byLevel := make(map[string]int, len(levels))
+		for _, level := range levels {
+			// Report zero for quiet levels so alert rules can resolve
+			byLevel[level] = 0
+		}
+		byProvider := make(map[[2]string]int)
+
+		for _, ev := range events {
+			if ev.System.EventRecordID > ec.lastRecord[ch.Name] {
+				ec.lastRecord[ch.Name] = ev.System.EventRecordID
+			}
+			level := eventLevelName(ev.System.Level)
+			byLevel[level]++
+			byProvider[[2]string{ev.System.Provider.Name, level}]++
+		}
+
+		for level, count := range byLevel {
+			metrics = append(metrics, &Metric{
+				Name:  "windows_eventlog_events",
+				Value: float64(count),
+				Labels: map[string]string{
+					"channel": ch.Name,
+					"level":   level,
+				},
+				Type: MetricTypeGauge,
+				Help: "Matching Event Log entries since the previous collection",
+			})
+		}
+
+		for key, count := range byProvider {
+			metrics = append(metrics, &Metric{
+				Name:  "windows_eventlog_provider_events",
+				Value: float64(count),
+				Labels: map[string]string{
+					"channel":  ch.Name,
+					"provider": key[0],
+					"level":    key[1],
+				},
+				Type: MetricTypeGauge,
+				Help: "Matching Event Log entries per provider since the previous collection",
+			})
+		}
+	}
+
+	return metrics, nil
+}
+
+// queryChannel fetches events newer than the last seen record using wevtutil
+func (ec *WindowsEventLogCollector) queryChannel(ctx context.Context, ch EventLogChannel) ([]windowsEvent, error) {
+	last, seen := ec.lastRecord[ch.Name]
+
+	var conditions []string
+	if levels := channelLevels(ch); len(levels) > 0 {
+		var parts []string
+		for _, level := range levels {
+			parts = append(parts, fmt.Sprintf("Level=%d", eventLogLevels[level]))
+		}
+		conditions = append(conditions, "("+strings.Join(parts, " or ")+")")
+	}
+	if len(ch.Providers) > 0 {
+		var parts []string
+		for _, p := range ch.Providers {
+			parts = append(parts, fmt.Sprintf("@Name='%s'", strings.ReplaceAll(p, "'", "")))
+		}
+		conditions = append(conditions, "Provider["+strings.Join(parts, " or ")+"]")
+	}
+	if seen {
+		conditions = append(conditions, fmt.Sprintf("EventRecordID > %d", last))
+	} else {
+		// Until we have seen a record, only look back one interval
+		conditions = append(conditions,
+			fmt.Sprintf("TimeCreated[timediff(@SystemTime) <= %d]", ec.Interval().Milliseconds()))
+	}
+
+	query := fmt.Sprintf("*[System[%s]]", strings.Join(conditions, " and "))
+
+	cmd := exec.CommandContext(ctx, "wevtutil", "qe", ch.Name, "/q:"+query, "/f:xml")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// wevtutil emits bare <Event> elements without a root element
+	out = append(append([]byte("<Events>"), out...), []byte("</Events>")...)
+
+	var result struct {
+		Events []windowsEvent `xml:"Event"`
+	}
+	if err := xml.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse events: %w", err)
+	}
+
+	return result.Events, nil
+}
+
+// windowsEvent is the subset of the Event Log XML schema we need
+type windowsEvent struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID       int    `xml:"EventID"`
+		Level         int    `xml:"Level"`
+		EventRecordID uint64 `xml:"EventRecordID"`
+	} `xml:"System"`
+}
+
+var windowsServiceStates = []string{
+	"stopped", "start_pending", "stop_pending", "running",
+	"continue_pending", "pause_pending", "paused", "not_installed",
+}
+
+var eventLogLevels = map[string]int{
+	"critical":    1,
+	"error":       2,
+	"warning":     3,
+	"information": 4,
+	"verbose":     5,
+}
+
+// channelLevels returns the configured levels, defaulting to critical and error
+func channelLevels(ch EventLogChannel) []string {
+	if len(ch.Levels) == 0 {
+		return []string{"critical", "error"}
+	}
+
+	levels := make([]string, 0, len(ch.Levels))
+	for _, l := range ch.Levels {
+		levels = append(levels, strings.ToLower(l))
+	}
+	return levels
+}
+
+func eventLevelName(level int) string {
+	for name, l := range eventLogLevels {
+		if l == level {
+			return name
+		}
+	}
+	// Level 0 (LogAlways) is reported as information
+	return "information"
+}
+
+func mergeLabels(base, extra map[string]string) map[string]string {
+	labels := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		labels[k] = v
+	}
+	for k, v := range extra {
+		labels[k] = v
+	}
+	return labels
+}
@@ -0,0 +1,31 @@
+package collectors
+
+import "time"
+
+// WindowsServiceCollectorConfig holds configuration for the Windows service collector
+type WindowsServiceCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	// Services limits collection to the named services; empty means all
+	Services []string
+}
+
+// WindowsEventLogCollectorConfig holds configuration for the Event Log collector
+type WindowsEventLogCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Channels []EventLogChannel
+}
+
+// EventLogChannel selects events from a single Event Log channel
+type EventLogChannel struct {
+	Name      string
+	Providers []string
+	Levels    []string
+}
+
+// HyperVCollectorConfig holds configuration for the Hyper-V collector
+type HyperVCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+}
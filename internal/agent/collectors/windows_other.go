@@ -0,0 +1,60 @@
+//go:build !windows
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+)
+
+// WindowsServiceCollector is only available on Windows
+type WindowsServiceCollector struct {
+	*BaseCollector
+}
+
+// NewWindowsServiceCollector returns an error on non-Windows platforms
+func NewWindowsServiceCollector(config WindowsServiceCollectorConfig) (*WindowsServiceCollector, error) {
+	return nil, fmt.Errorf("windows service collector is only supported on Windows")
+}
+
+// Collect is never called on non-Windows platforms
+func (wc *WindowsServiceCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	return nil, nil
+}
+
+// WindowsEventLogCollector is only available on Windows
+type WindowsEventLogCollector struct {
+	*BaseCollector
+}
+
+// NewWindowsEventLogCollector returns an error on non-Windows platforms
+func NewWindowsEventLogCollector(config WindowsEventLogCollectorConfig) (*WindowsEventLogCollector, error) {
+	return nil, fmt.Errorf("windows event log collector is only supported on Windows")
+}
+
+// Collect is never called on non-Windows platforms
+func (ec *WindowsEventLogCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	return nil, nil
+}
+
+// HyperVCollector is only available on Windows
+type HyperVCollector struct {
+	*BaseCollector
+}
+
+// NewHyperVCollector returns an error on non-Windows platforms
+func NewHyperVCollector(config HyperVCollectorConfig) (*HyperVCollector, error) {
+	return nil, fmt.Errorf("hyper-v collector is only supported on Windows")
+}
+
+// Collect is never called on non-Windows platforms
+func (hc *HyperVCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	return nil, nil
+}
+
+// hcsAvailable reports whether the Host Compute Service runtime can be used
+const hcsAvailable = false
+
+func newHCSRuntime() (containerRuntime, error) {
+	return nil, fmt.Errorf("the hcs container runtime is only supported on Windows")
+}
@@ -0,0 +1,444 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ZFSCollectorConfig holds configuration for the ZFS collector. Pools
+// limits the pools reported; empty reports every imported pool.
+type ZFSCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Pools    []string
+}
+
+// zfsTimeout bounds each zpool command
+const zfsTimeout = 30 * time.Second
+
+// zfsArcstatsPath is where Linux exposes ARC statistics
+const zfsArcstatsPath = "/proc/spl/kstat/zfs/arcstats"
+
+// ZFSCollector reports pool health and capacity, unhealthy vdevs, scrub
+// status and ARC statistics
+type ZFSCollector struct {
+	*BaseCollector
+	pools map[string]bool
+
+	mu sync.Mutex
+	// lastHits and lastMisses are the ARC totals of the previous
+	// collection, for the hit ratio over the interval
+	lastHits   float64
+	lastMisses float64
+}
+
+// NewZFSCollector creates a new ZFS collector
+func NewZFSCollector(config ZFSCollectorConfig) (*ZFSCollector, error) {
+	if _, err := exec.LookPath("zpool"); err != nil {
+		return nil, fmt.Errorf("zpool command not found: %w", err)
+	}
+
+	zc := &ZFSCollector{
+		BaseCollector: NewBaseCollector("zfs", config.Enabled, config.Interval),
+	}
+	if len(config.Pools) > 0 {
+		zc.pools = make(map[string]bool, len(config.Pools))
+		for _, pool := range config.Pools {
+			zc.pools[pool] = true
+		}
+	}
+	return zc, nil
+}
+
+// zfsPool is a pool as reported by zpool list and zpool status
+type zfsPool struct {
+	name          string
+	health        string
+	size          float64
+	allocated     float64
+	free          float64
+	fragmentation float64 // percent, -1 when not reported
+	capacity      float64 // percent
+
+	// From zpool status
+	devices    []zfsDevice
+	scan       string
+	dataErrors float64
+}
+
+// zfsDevice is a vdev or disk row of the zpool status config section
+type zfsDevice struct {
+	name   string
+	state  string
+	read   float64
+	write  float64
+	cksum  float64
+	isPool bool
+}
+
+// zfsHealthValues maps pool health to the reported value
+var zfsHealthValues = map[string]float64{
+	"ONLINE":   0,
+	"DEGRADED": 1,
+}
+
+// Collect collects ZFS pool and ARC metrics
+func (zc *ZFSCollector) Collect(ctx context.Context) ([]*Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, zfsTimeout)
+	defer cancel()
+
+	pools, err := zc.listPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(pools) > 0 {
+		if err := zc.readStatus(ctx, pools); err != nil {
+			return nil, err
+		}
+	}
+
+	var metrics []*Metric
+	now := time.Now()
+	for _, pool := range pools {
+		metrics = append(metrics, zfsPoolMetrics(pool, now)...)
+	}
+
+	// ARC statistics are only available on Linux
+	if arc, err := readArcstats(zfsArcstatsPath); err == nil {
+		metrics = append(metrics, zc.arcMetrics(arc)...)
+	}
+
+	return metrics, nil
+}
+
+// listPools reads capacity and health of the pools with zpool list
+func (zc *ZFSCollector) listPools(ctx context.Context) ([]*zfsPool, error) {
+	out, err := runZpool(ctx, "list", "-Hp", "-o", "name,size,allocated,free,fragmentation,capacity,health")
+	if err != nil {
+		return nil, fmt.Errorf("zpool list failed: %w", err)
+	}
+
+	var pools []*zfsPool
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 7 {
+			continue
+		}
+		if zc.pools != nil && !zc.pools[fields[0]] {
+			continue
+		}
+		pool := &zfsPool{name: fields[0], health: fields[6]}
+		pool.size, _ = strconv.ParseFloat(fields[1], 64)
+		pool.allocated, _ = strconv.ParseFloat(fields[2], 64)
+		pool.free, _ = strconv.ParseFloat(fields[3], 64)
+		pool.capacity, _ = strconv.ParseFloat(strings.TrimSuffix(fields[5], "%"), 64)
+		// Fragmentation is - for pools that cannot report it
+		if frag, err := strconv.ParseFloat(strings.TrimSuffix(fields[4], "%"), 64); err == nil {
+			pool.fragmentation = frag
+		} else {
+			pool.fragmentation = -1
+		}
+		pools = append(pools, pool)
+	}
+	return pools, scanner.Err()
+}
+
+// readStatus adds the vdevs, scan status and error count of each pool
+// from zpool status
+func (zc *ZFSCollector) readStatus(ctx context.Context, pools []*zfsPool) error {
+	args := []string{"status", "-p"}
+	byName := make(map[string]*zfsPool, len(pools))
+	for _, pool := range pools {
+		args = append(args, pool.name)
+		byName[pool.name] = pool
+	}
+
+	out, err := runZpool(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("zpool status failed: %w", err)
+	}
+	parseZpoolStatus(out, byName)
+	return nil
+}
+
+// parseZpoolStatus parses zpool status output, which looks like:
+//
+//	  pool: tank
+//	 state: DEGRADED
+//	  scan: scrub repaired 0B in 00:00:01 with 0 errors on Sun Oct 11 00:24:01 2026
+//	config:
+//
+//		NAME        STATE     READ WRITE CKSUM
+//		tank        DEGRADED     0     0     0
+//		  mirror-0  DEGRADED     0     0     0
+//		    sda     ONLINE       0     0     0
+//		    sdb     FAULTED      3     0     0  too many errors
+//
+//	errors: No known data errors
+func parseZpoolStatus(out []byte, pools map[string]*zfsPool) {
+	var (
+		pool    *zfsPool
+		section string
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if key, value, ok := strings.Cut(trimmed, ":"); ok && !strings.HasPrefix(line, "\t") && !strings.Contains(key, " ") {
+			section = key
+			value = strings.TrimSpace(value)
+			switch key {
+			case "pool":
+				pool = pools[value]
+			case "scan":
+				if pool != nil {
+					pool.scan = value
+				}
+			case "errors":
+				if pool != nil {
+					pool.dataErrors = zfsDataErrors(value)
+				}
+			}
+			continue
+		}
+		if pool == nil || trimmed == "" {
+			continue
+		}
+
+		switch section {
+		case "scan":
+			// Progress of a running scrub or resilver
+			pool.scan += " " + trimmed
+		case "config":
+			fields := strings.Fields(trimmed)
+			if fields[0] == "NAME" || len(fields) < 5 {
+				// Headers and section rows such as logs, cache and spares
+				continue
+			}
+			dev := zfsDevice{name: fields[0], state: fields[1], isPool: fields[0] == pool.name}
+			dev.read, _ = strconv.ParseFloat(fields[2], 64)
+			dev.write, _ = strconv.ParseFloat(fields[3], 64)
+			dev.cksum, _ = strconv.ParseFloat(fields[4], 64)
+			pool.devices = append(pool.devices, dev)
+		}
+	}
+}
+
+// zfsDataErrors parses the errors line, "No known data errors" or
+// "N data errors, use '-v' for a list"
+func zfsDataErrors(value string) float64 {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+	n, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+var (
+	zfsScanErrorsRe   = regexp.MustCompile(`with (\d+) errors`)
+	zfsScanDateRe     = regexp.MustCompile(`on (\w{3} \w{3} [ \d]\d \d{2}:\d{2}:\d{2} \d{4})`)
+	zfsScanProgressRe = regexp.MustCompile(`([\d.]+)% done`)
+)
+
+// zfsPoolMetrics reports a pool's health, capacity, devices and scan
+func zfsPoolMetrics(pool *zfsPool, now time.Time) []*Metric {
+	labels := map[string]string{"pool": pool.name}
+	gauge := func(name string, value float64, help, unit string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: labels, Type: MetricTypeGauge, Help: help, Unit: unit}
+	}
+
+	health, ok := zfsHealthValues[pool.health]
+	if !ok {
+		health = 2
+	}
+	metrics := []*Metric{
+		gauge("zfs_pool_health", health, "Pool health: 0 ONLINE, 1 DEGRADED, 2 FAULTED, UNAVAIL, SUSPENDED or other", ""),
+		gauge("zfs_pool_size_bytes", pool.size, "Pool size", "bytes"),
+		gauge("zfs_pool_allocated_bytes", pool.allocated, "Space allocated in the pool", "bytes"),
+		gauge("zfs_pool_free_bytes", pool.free, "Free space in the pool", "bytes"),
+		gauge("zfs_pool_capacity_percent", pool.capacity, "Share of the pool allocated", "percent"),
+		gauge("zfs_pool_data_errors", pool.dataErrors, "Files with permanent data errors", ""),
+	}
+	if pool.fragmentation >= 0 {
+		metrics = append(metrics, gauge("zfs_pool_fragmentation_percent", pool.fragmentation, "Free space fragmentation", "percent"))
+	}
+
+	var unhealthy, readErrors, writeErrors, cksumErrors float64
+	for _, dev := range pool.devices {
+		if dev.isPool {
+			continue
+		}
+		readErrors += dev.read
+		writeErrors += dev.write
+		cksumErrors += dev.cksum
+		switch dev.state {
+		case "ONLINE", "AVAIL", "INUSE":
+			continue
+		}
+		unhealthy++
+		metrics = append(metrics, &Metric{
+			Name:   "zfs_pool_vdev_unhealthy",
+			Value:  1,
+			Labels: withLabel(withLabel(labels, "vdev", dev.name), "state", dev.state),
+			Type:   MetricTypeGauge,
+			Help:   "Vdev or disk that is degraded, faulted, offline, removed or unavailable",
+		})
+	}
+	vdevErrors := func(kind string, value float64) *Metric {
+		return &Metric{
+			Name:   "zfs_pool_vdev_errors",
+			Value:  value,
+			Labels: withLabel(labels, "type", kind),
+			Type:   MetricTypeGauge,
+			Help:   "I/O and checksum errors of the pool's devices since they were last cleared",
+		}
+	}
+	metrics = append(metrics,
+		gauge("zfs_pool_vdevs_unhealthy", unhealthy, "Vdevs and disks that are not online", ""),
+		vdevErrors("read", readErrors),
+		vdevErrors("write", writeErrors),
+		vdevErrors("checksum", cksumErrors),
+	)
+
+	return append(metrics, zfsScanMetrics(pool.scan, labels, now)...)
+}
+
+// zfsScanMetrics reports the scan line of zpool status: a scrub or
+// resilver in progress, or the outcome of the last one
+func zfsScanMetrics(scan string, labels map[string]string, now time.Time) []*Metric {
+	gauge := func(name string, value float64, help, unit string) *Metric {
+		return &Metric{Name: name, Value: value, Labels: labels, Type: MetricTypeGauge, Help: help, Unit: unit}
+	}
+
+	scrubbing := strings.HasPrefix(scan, "scrub in progress")
+	resilvering := strings.HasPrefix(scan, "resilver in progress")
+	metrics := []*Metric{
+		gauge("zfs_pool_scrub_in_progress", boolToFloat(scrubbing), "Whether a scrub is running", ""),
+		gauge("zfs_pool_resilver_in_progress", boolToFloat(resilvering), "Whether a resilver is running", ""),
+	}
+
+	if scrubbing || resilvering {
+		if m := zfsScanProgressRe.FindStringSubmatch(scan); m != nil {
+			progress, _ := strconv.ParseFloat(m[1], 64)
+			metrics = append(metrics, gauge("zfs_pool_scan_progress_percent", progress, "Progress of the running scrub or resilver", "percent"))
+		}
+		return metrics
+	}
+
+	// Only a completed scrub verifies the whole pool
+	if !strings.HasPrefix(scan, "scrub repaired") {
+		return metrics
+	}
+	if m := zfsScanErrorsRe.FindStringSubmatch(scan); m != nil {
+		scanErrors, _ := strconv.ParseFloat(m[1], 64)
+		metrics = append(metrics, gauge("zfs_pool_last_scrub_errors", scanErrors, "Errors found by the last scrub", ""))
+	}
+	if m := zfsScanDateRe.FindStringSubmatch(scan); m != nil {
+		if finished, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", m[1], time.Local); err == nil {
+			metrics = append(metrics,
+				gauge("zfs_pool_last_scrub_timestamp", float64(finished.Unix()), "When the last scrub finished", "seconds"),
+				gauge("zfs_pool_last_scrub_age_seconds", now.Sub(finished).Seconds(), "Time since the last scrub finished", "seconds"),
+			)
+		}
+	}
+	return metrics
+}
+
+// zfsArcMetrics maps arcstats fields to metrics
+var zfsArcMetrics = []struct {
+	field string
+	name  string
+	typ   MetricType
+	help  string
+	unit  string
+}{
+	{"size", "zfs_arc_size_bytes", MetricTypeGauge, "ARC size", "bytes"},
+	{"c", "zfs_arc_target_size_bytes", MetricTypeGauge, "ARC target size", "bytes"},
+	{"c_min", "zfs_arc_min_size_bytes", MetricTypeGauge, "Minimum ARC size", "bytes"},
+	{"c_max", "zfs_arc_max_size_bytes", MetricTypeGauge, "Maximum ARC size", "bytes"},
+	{"mru_size", "zfs_arc_mru_size_bytes", MetricTypeGauge, "Size of the most recently used list", "bytes"},
+	{"mfu_size", "zfs_arc_mfu_size_bytes", MetricTypeGauge, "Size of the most frequently used list", "bytes"},
+	{"hits", "zfs_arc_hits_total", MetricTypeCounter, "ARC hits", ""},
+	{"misses", "zfs_arc_misses_total", MetricTypeCounter, "ARC misses", ""},
+	{"memory_throttle_count", "zfs_arc_memory_throttles_total", MetricTypeCounter, "Writes throttled for lack of memory", ""},
+	{"l2_size", "zfs_l2arc_size_bytes", MetricTypeGauge, "L2ARC size", "bytes"},
+	{"l2_hits", "zfs_l2arc_hits_total", MetricTypeCounter, "L2ARC hits", ""},
+	{"l2_misses", "zfs_l2arc_misses_total", MetricTypeCounter, "L2ARC misses", ""},
+}
+
+// arcMetrics reports ARC statistics and the hit ratio since the previous
+// collection
+func (zc *ZFSCollector) arcMetrics(arc map[string]float64) []*Metric {
+	var metrics []*Metric
+	for _, m := range zfsArcMetrics {
+		value, ok := arc[m.field]
+		if !ok {
+			continue
+		}
+		metrics = append(metrics, &Metric{Name: m.name, Value: value, Type: m.typ, Help: m.help, Unit: m.unit})
+	}
+
+	zc.mu.Lock()
+	hits, misses := arc["hits"]-zc.lastHits, arc["misses"]-zc.lastMisses
+	zc.lastHits, zc.lastMisses = arc["hits"], arc["misses"]
+	zc.mu.Unlock()
+
+	// Totals reset when the module is reloaded
+	if hits < 0 || misses < 0 {
+		hits, misses = arc["hits"], arc["misses"]
+	}
+	if hits+misses > 0 {
+		metrics = append(metrics, &Metric{
+			Name:  "zfs_arc_hit_ratio",
+			Value: hits / (hits + misses),
+			Type:  MetricTypeGauge,
+			Help:  "Share of ARC lookups that hit since the previous collection",
+		})
+	}
+	return metrics
+}
+
+// readArcstats reads the name, type and data columns of a kstat file
+func readArcstats(path string) (map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			continue
+		}
+		stats[fields[0]] = value
+	}
+	return stats, scanner.Err()
+}
+
+func runZpool(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "zpool", args...)
+	cmd.Env = append(os.Environ(), "LANG=C", "LC_ALL=C")
+	return cmd.Output()
+}
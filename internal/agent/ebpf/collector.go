@@ -1,17 +1,110 @@
+// Package ebpf collects kernel network and process metrics with eBPF
+// programs attached to tracepoints. The programs are assembled at load time with the kernel's
+// struct offsets taken from its BTF, so no compiler or kernel headers are
+// needed on the node.
 package ebpf
 
 import (
-	"context"
-
-	"github.com/meettoy2004/lnmonja/internal/agent/collectors"
+	"net"
+	"strconv"
+	"time"
 )
 
-// EBPFCollector collects metrics using eBPF
-type EBPFCollector struct {
-	*collectors.BaseCollector
+// EBPFCollectorConfig holds configuration for the eBPF collector
+type EBPFCollectorConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	// MaxDestinations bounds the destinations tracked; the least recently
+	// updated are evicted
+	MaxDestinations int
+	Programs        struct {
+		// TCPConnect measures the time from SYN to an established
+		// connection, and counts failed connects
+		TCPConnect bool
+		// TCPRetransmit counts retransmitted segments
+		TCPRetransmit bool
+		// TCPDrop counts packets of TCP sockets the kernel dropped. It
+		// reads struct sk_buff and struct sock, so needs the kernel's BTF
+		TCPDrop bool
+		// Exec counts process execs, catching processes too short-lived
+		// for sampling
+		Exec bool
+		// FileIO counts the bytes each process reads and writes
+		FileIO bool
+	}
+	// Processes selects the processes the exec and file I/O programs
+	// report
+	Processes struct {
+		// Comms are glob patterns of the command names reported; empty
+		// reports every command
+		Comms []string
+		// Paths are glob patterns, or directory prefixes ending in /, of
+		// the executables whose execs are reported
+		Paths []string
+		// MaxProcesses bounds the processes whose file I/O is tracked
+		MaxProcesses int
+		// ProcPath is where procfs is mounted, used to find processes that
+		// have exited
+		ProcPath string
+		// Events streams the reported execs
+		Events bool
+	}
+}
+
+// connectBuckets are the upper bounds of the connect latency histogram, in
+// microseconds. Latencies above the last bound fall into +Inf.
+var connectBuckets = []int32{
+	100, 250, 500, 1000, 2500, 5000, 10000, 25000, 50000,
+	100000, 250000, 500000, 1000000, 2500000, 5000000, 10000000,
+}
+
+// statsKey identifies a destination in the stats map. Addr holds the
+// address in network order, IPv4 addresses in its first four bytes.
+type statsKey struct {
+	Family uint16
+	Port   uint16
+	Pad    uint32
+	Addr   [16]byte
+}
+
+// statsValue is a destination's counters in the stats map. Buckets are not
+// cumulative; the last one is +Inf.
+type statsValue struct {
+	Buckets     [17]uint64
+	SumNs       uint64
+	Count       uint64
+	Failures    uint64
+	Retransmits uint64
+	Drops       uint64
 }
 
-// Collect collects eBPF metrics
-func (ec *EBPFCollector) Collect(ctx context.Context) ([]*collectors.Metric, error) {
-	return make([]*collectors.Metric, 0), nil
+const (
+	keySize   = 24
+	valueSize = (17 + 5) * 8
+
+	offSum         = 17 * 8
+	offCount       = offSum + 8
+	offFailures    = offCount + 8
+	offRetransmits = offFailures + 8
+	offDrops       = offRetransmits + 8
+)
+
+const (
+	afInet      = 2
+	afInet6     = 10
+	ipprotoTCP  = 6
+	tcpEstab    = 1
+	tcpSynSent  = 2
+	defaultDsts = 1024
+)
+
+// destination returns the key's address and port labels
+func (k *statsKey) destination() (string, string) {
+	var ip net.IP
+	if k.Family == afInet {
+		ip = net.IP(k.Addr[:4])
+	} else {
+		ip = net.IP(k.Addr[:])
+	}
+	return ip.String(), strconv.Itoa(int(k.Port))
 }
@@ -0,0 +1,685 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/cilium/ebpf/rlimit"
+
+	"github.com/meettoy2004/lnmonja/internal/agent/collectors"
+)
+
+// EBPFCollector measures per-destination TCP connect latency,
+// retransmissions and drops, process execs and per-process file I/O with
+// eBPF programs on kernel tracepoints
+type EBPFCollector struct {
+	*collectors.BaseCollector
+	config EBPFCollectorConfig
+
+	stats  *ebpf.Map
+	starts *ebpf.Map
+	events *ebpf.Map
+	lost   *ebpf.Map
+	procs  *ebpf.Map
+	progs  []*ebpf.Program
+	links  []link.Link
+	reader *ringbuf.Reader
+	// done is closed when the exec events reader returns
+	done chan struct{}
+
+	// loaded tells which programs are attached, and why the others are
+	// not
+	loaded map[string]error
+	btf    bool
+
+	filter processFilter
+	stream *execStream
+
+	// mu guards the exec and file I/O counts by command name, which
+	// outlive the processes counted
+	mu    sync.Mutex
+	execs map[string]uint64
+	io    map[string]*ioValue
+	// seen holds the counters of each process in the file I/O map when it
+	// was last read
+	seen map[ioKey]ioValue
+}
+
+// NewEBPFCollector loads and attaches the enabled programs. A program the
+// kernel cannot run is left out, and reported by ebpf_program_loaded;
+// without BTF that is the drop program. It fails if none could be loaded.
+func NewEBPFCollector(config EBPFCollectorConfig) (*EBPFCollector, error) {
+	if config.MaxDestinations <= 0 {
+		config.MaxDestinations = defaultDsts
+	}
+	if config.Processes.MaxProcesses <= 0 {
+		config.Processes.MaxProcesses = defaultProcs
+	}
+	if config.Processes.ProcPath == "" {
+		config.Processes.ProcPath = "/proc"
+	}
+	ec := &EBPFCollector{
+		BaseCollector: collectors.NewBaseCollector("ebpf", config.Enabled, config.Interval),
+		config:        config,
+		loaded:        make(map[string]error),
+		filter:        processFilter{comms: config.Processes.Comms, paths: config.Processes.Paths},
+		execs:         make(map[string]uint64),
+		io:            make(map[string]*ioValue),
+		seen:          make(map[ioKey]ioValue),
+	}
+
+	// Kernels before 5.11 charge BPF memory to RLIMIT_MEMLOCK
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return nil, fmt.Errorf("failed to remove memlock limit: %w", err)
+	}
+
+	spec, btfErr := loadKernelBTF()
+	ec.btf = btfErr == nil
+
+	if config.Programs.TCPConnect || config.Programs.TCPRetransmit || config.Programs.TCPDrop {
+		var err error
+		ec.stats, err = ebpf.NewMap(&ebpf.MapSpec{
+			Name:       "tcp_stats",
+			Type:       ebpf.LRUHash,
+			KeySize:    keySize,
+			ValueSize:  valueSize,
+			MaxEntries: uint32(config.MaxDestinations),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stats map: %w", err)
+		}
+	}
+
+	if config.Programs.TCPConnect {
+		ec.loaded["tcp_connect"] = ec.attachConnect()
+	}
+	if config.Programs.TCPRetransmit {
+		ec.loaded["tcp_retransmit"] = ec.attachRetransmit()
+	}
+	if config.Programs.TCPDrop {
+		ec.loaded["tcp_drop"] = ec.attachDrop(spec, btfErr)
+	}
+	if config.Programs.Exec {
+		ec.loaded["exec"] = ec.attachExec(spec)
+	}
+	if config.Programs.FileIO {
+		ec.loaded["file_io"] = ec.attachFileIO()
+	}
+
+	var errs []error
+	for name, err := range ec.loaded {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if len(errs) == len(ec.loaded) {
+		ec.Close()
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("no eBPF programs enabled")
+		}
+		return nil, fmt.Errorf("failed to load eBPF programs: %w", errors.Join(errs...))
+	}
+
+	if ec.reader != nil {
+		if config.Processes.Events {
+			ec.stream = newExecStream()
+		}
+		ec.done = make(chan struct{})
+		go ec.readExecs()
+	}
+	return ec, nil
+}
+
+// attachConnect loads the connect latency program
+func (ec *EBPFCollector) attachConnect() error {
+	layout, err := readTracepointLayout("sock", "inet_sock_set_state")
+	if err != nil {
+		return err
+	}
+	if err := layout.require("skaddr", "oldstate", "newstate", "protocol", "family", "dport", "daddr", "daddr_v6"); err != nil {
+		return err
+	}
+	ec.starts, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "tcp_starts",
+		Type:       ebpf.LRUHash,
+		KeySize:    8,
+		ValueSize:  8,
+		MaxEntries: 16384,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create connect map: %w", err)
+	}
+	return ec.attach("sock", "inet_sock_set_state", connectProgram(layout, ec.starts, ec.stats))
+}
+
+// attachRetransmit loads the retransmission program
+func (ec *EBPFCollector) attachRetransmit() error {
+	layout, err := readTracepointLayout("tcp", "tcp_retransmit_skb")
+	if err != nil {
+		return err
+	}
+	if err := layout.require("family", "dport", "daddr", "daddr_v6"); err != nil {
+		return err
+	}
+	return ec.attach("tcp", "tcp_retransmit_skb", retransmitProgram(layout, ec.stats))
+}
+
+// attachDrop loads the drop program, whose struct offsets come from the
+// kernel BTF
+func (ec *EBPFCollector) attachDrop(spec *btf.Spec, btfErr error) error {
+	if btfErr != nil {
+		return btfErr
+	}
+	offsets, err := loadSockOffsets(spec)
+	if err != nil {
+		return err
+	}
+	layout, err := readTracepointLayout("skb", "kfree_skb")
+	if err != nil {
+		return err
+	}
+	if err := layout.require("skbaddr"); err != nil {
+		return err
+	}
+	return ec.attach("skb", "kfree_skb", dropProgram(layout, offsets, ec.stats))
+}
+
+// attachExec loads the exec program and opens its event ring buffer. The
+// parent pid of execs is only known with the kernel BTF.
+func (ec *EBPFCollector) attachExec(spec *btf.Spec) error {
+	layout, err := readTracepointLayout("sched", "sched_process_exec")
+	if err != nil {
+		return err
+	}
+	if err := layout.require("filename", "pid"); err != nil {
+		return err
+	}
+	var task *taskOffsets
+	if spec != nil {
+		task, _ = loadTaskOffsets(spec)
+	}
+
+	ec.events, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "exec_events",
+		Type:       ebpf.RingBuf,
+		MaxEntries: execRingSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create exec event buffer: %w", err)
+	}
+	ec.lost, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "exec_lost",
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create exec lost map: %w", err)
+	}
+	if err := ec.attach("sched", "sched_process_exec", execProgram(layout, task, ec.events, ec.lost)); err != nil {
+		return err
+	}
+	ec.reader, err = ringbuf.NewReader(ec.events)
+	if err != nil {
+		return fmt.Errorf("failed to read exec events: %w", err)
+	}
+	return nil
+}
+
+// ioSyscalls are the syscalls whose bytes the file I/O programs count, and
+// whether they write. The read and write syscalls are required, the
+// others are counted where the kernel traces them.
+var ioSyscalls = []struct {
+	name     string
+	write    bool
+	required bool
+}{
+	{"read", false, true},
+	{"write", true, true},
+	{"readv", false, false},
+	{"writev", true, false},
+	{"pread64", false, false},
+	{"pwrite64", true, false},
+}
+
+// attachFileIO loads a file I/O program on the exit of each I/O syscall
+func (ec *EBPFCollector) attachFileIO() error {
+	var err error
+	ec.procs, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "file_io",
+		Type:       ebpf.LRUHash,
+		KeySize:    ioKeySize,
+		ValueSize:  ioValueSize,
+		MaxEntries: uint32(ec.config.Processes.MaxProcesses),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create file I/O map: %w", err)
+	}
+
+	for _, syscall := range ioSyscalls {
+		name := "sys_exit_" + syscall.name
+		layout, err := readTracepointLayout("syscalls", name)
+		if err == nil {
+			err = layout.require("ret")
+		}
+		off := int16(offReadBytes)
+		if syscall.write {
+			off = offWriteBytes
+		}
+		if err == nil {
+			err = ec.attach("syscalls", name, ioProgram(layout, ec.procs, off))
+		}
+		if err != nil && syscall.required {
+			return err
+		}
+	}
+	return nil
+}
+
+// readExecs counts the exec events and streams them until the reader is
+// closed
+func (ec *EBPFCollector) readExecs() {
+	defer close(ec.done)
+
+	var record execRecord
+	for {
+		sample, err := ec.reader.Read()
+		if err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if err := binary.Read(bytes.NewReader(sample.RawSample), binary.NativeEndian, &record); err != nil {
+			continue
+		}
+		event := ExecEvent{
+			Time:     time.Now(),
+			PID:      record.PID,
+			PPID:     record.PPID,
+			UID:      record.UID,
+			Comm:     cString(record.Comm[:]),
+			Filename: cString(record.Filename[:]),
+		}
+		if !ec.filter.matchComm(event.Comm) || !ec.filter.matchPath(event.Filename) {
+			continue
+		}
+
+		ec.mu.Lock()
+		ec.execs[bounded(ec.execs, event.Comm)]++
+		ec.mu.Unlock()
+		if ec.stream != nil {
+			ec.stream.publish(event)
+		}
+	}
+}
+
+// SubscribeExecs streams the reported execs until the returned function is
+// called or the collector is closed. It fails unless exec events are
+// enabled and the exec program is loaded.
+func (ec *EBPFCollector) SubscribeExecs() (<-chan ExecEvent, func(), error) {
+	if ec.stream == nil {
+		return nil, nil, fmt.Errorf("exec events are not enabled")
+	}
+	events, cancel := ec.stream.subscribe()
+	return events, cancel, nil
+}
+
+// bounded returns the key a command name is counted under, other once
+// maxComms names are counted
+func bounded[V any](counts map[string]V, comm string) string {
+	if _, ok := counts[comm]; ok || len(counts) < maxComms {
+		return comm
+	}
+	return "other"
+}
+
+// readFileIO adds the I/O of each process since the last read to the
+// totals of its command name. Processes that have exited or exec'd are
+// deleted from the map, so it only holds running processes; I/O between
+// the read and the delete is missed.
+func (ec *EBPFCollector) readFileIO() error {
+	delta := func(now, before uint64) uint64 {
+		// A process evicted and added back starts from zero
+		if now < before {
+			return now
+		}
+		return now - before
+	}
+
+	seen := make(map[ioKey]ioValue)
+	var gone []ioKey
+	var key ioKey
+	var value ioValue
+
+	ec.mu.Lock()
+	iter := ec.procs.Iterate()
+	for iter.Next(&key, &value) {
+		comm := cString(key.Comm[:])
+		if ec.filter.matchComm(comm) {
+			last := ec.seen[key]
+			name := bounded(ec.io, comm)
+			total, ok := ec.io[name]
+			if !ok {
+				total = &ioValue{}
+				ec.io[name] = total
+			}
+			total.ReadBytes += delta(value.ReadBytes, last.ReadBytes)
+			total.Reads += delta(value.Reads, last.Reads)
+			total.WriteBytes += delta(value.WriteBytes, last.WriteBytes)
+			total.Writes += delta(value.Writes, last.Writes)
+		}
+		if ec.running(key.PID, comm) {
+			seen[key] = value
+		} else {
+			gone = append(gone, key)
+		}
+	}
+	ec.seen = seen
+	ec.mu.Unlock()
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for i := range gone {
+		ec.procs.Delete(&gone[i])
+	}
+	return nil
+}
+
+// running tells whether a process is still running the command. Threads
+// named differently from their process are reported as gone, which only
+// costs their map entry being recreated.
+func (ec *EBPFCollector) running(pid uint32, comm string) bool {
+	data, err := os.ReadFile(filepath.Join(ec.config.Processes.ProcPath, strconv.FormatUint(uint64(pid), 10), "comm"))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSuffix(string(data), "\n") == comm
+}
+
+// attach loads a tracepoint program and attaches it
+func (ec *EBPFCollector) attach(group, name string, insns asm.Instructions) error {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         name,
+		Type:         ebpf.TracePoint,
+		Instructions: insns,
+		License:      "GPL",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load program: %w", err)
+	}
+	l, err := link.Tracepoint(group, name, prog, nil)
+	if err != nil {
+		prog.Close()
+		return fmt.Errorf("failed to attach to %s/%s: %w", group, name, err)
+	}
+	ec.progs = append(ec.progs, prog)
+	ec.links = append(ec.links, l)
+	return nil
+}
+
+// Collect reads the per-destination stats and the process counts
+func (ec *EBPFCollector) Collect(ctx context.Context) ([]*collectors.Metric, error) {
+	metrics := make([]*collectors.Metric, 0)
+	now := time.Now().UnixNano()
+
+	for name, err := range ec.loaded {
+		metrics = append(metrics, &collectors.Metric{
+			Name:      "ebpf_program_loaded",
+			Value:     boolToFloat(err == nil),
+			Timestamp: now,
+			Labels:    map[string]string{"program": name},
+			Type:      collectors.MetricTypeGauge,
+			Help:      "Whether the eBPF program is attached",
+		})
+	}
+	metrics = append(metrics, &collectors.Metric{
+		Name:      "ebpf_btf_available",
+		Value:     boolToFloat(ec.btf),
+		Timestamp: now,
+		Labels:    map[string]string{},
+		Type:      collectors.MetricTypeGauge,
+		Help:      "Whether the kernel exposes BTF type information",
+	})
+
+	if ec.config.Programs.Exec && ec.loaded["exec"] == nil {
+		metrics = append(metrics, ec.execMetrics(now)...)
+	}
+	if ec.config.Programs.FileIO && ec.loaded["file_io"] == nil {
+		if err := ec.readFileIO(); err != nil {
+			return metrics, fmt.Errorf("failed to read eBPF file I/O: %w", err)
+		}
+		metrics = append(metrics, ec.fileIOMetrics(now)...)
+	}
+	if ec.stats == nil {
+		return metrics, nil
+	}
+
+	connect := ec.loaded["tcp_connect"] == nil && ec.config.Programs.TCPConnect
+	retransmit := ec.loaded["tcp_retransmit"] == nil && ec.config.Programs.TCPRetransmit
+	drop := ec.loaded["tcp_drop"] == nil && ec.config.Programs.TCPDrop
+
+	var key statsKey
+	var value statsValue
+	iter := ec.stats.Iterate()
+	for iter.Next(&key, &value) {
+		if ctx.Err() != nil {
+			return metrics, ctx.Err()
+		}
+		destination, port := key.destination()
+		labels := func(extra ...string) map[string]string {
+			l := map[string]string{"destination": destination, "port": port}
+			for i := 0; i+1 < len(extra); i += 2 {
+				l[extra[i]] = extra[i+1]
+			}
+			return l
+		}
+
+		if connect && value.Count > 0 {
+			var cumulative uint64
+			for i, bound := range connectBuckets {
+				cumulative += value.Buckets[i]
+				metrics = append(metrics, &collectors.Metric{
+					Name:      "ebpf_tcp_connect_duration_seconds_bucket",
+					Value:     float64(cumulative),
+					Timestamp: now,
+					Labels:    labels("le", strconv.FormatFloat(float64(bound)/1e6, 'g', -1, 64)),
+					Type:      collectors.MetricTypeHistogram,
+					Help:      "TCP connect latency, from SYN to established",
+					Unit:      "seconds",
+				})
+			}
+			metrics = append(metrics,
+				&collectors.Metric{
+					Name:      "ebpf_tcp_connect_duration_seconds_bucket",
+					Value:     float64(value.Count),
+					Timestamp: now,
+					Labels:    labels("le", "+Inf"),
+					Type:      collectors.MetricTypeHistogram,
+					Help:      "TCP connect latency, from SYN to established",
+					Unit:      "seconds",
+				},
+				&collectors.Metric{
+					Name:      "ebpf_tcp_connect_duration_seconds_sum",
+					Value:     float64(value.SumNs) / 1e9,
+					Timestamp: now,
+					Labels:    labels(),
+					Type:      collectors.MetricTypeHistogram,
+					Help:      "TCP connect latency, from SYN to established",
+					Unit:      "seconds",
+				},
+				&collectors.Metric{
+					Name:      "ebpf_tcp_connect_duration_seconds_count",
+					Value:     float64(value.Count),
+					Timestamp: now,
+					Labels:    labels(),
+					Type:      collectors.MetricTypeHistogram,
+					Help:      "TCP connect latency, from SYN to established",
+				},
+			)
+		}
+		if connect && value.Failures > 0 {
+			metrics = append(metrics, &collectors.Metric{
+				Name:      "ebpf_tcp_connect_failures_total",
+				Value:     float64(value.Failures),
+				Timestamp: now,
+				Labels:    labels(),
+				Type:      collectors.MetricTypeCounter,
+				Help:      "TCP connects that failed before being established",
+			})
+		}
+		if retransmit && value.Retransmits > 0 {
+			metrics = append(metrics, &collectors.Metric{
+				Name:      "ebpf_tcp_retransmits_total",
+				Value:     float64(value.Retransmits),
+				Timestamp: now,
+				Labels:    labels(),
+				Type:      collectors.MetricTypeCounter,
+				Help:      "TCP segments retransmitted",
+			})
+		}
+		if drop && value.Drops > 0 {
+			metrics = append(metrics, &collectors.Metric{
+				Name:      "ebpf_tcp_drops_total",
+				Value:     float64(value.Drops),
+				Timestamp: now,
+				Labels:    labels(),
+				Type:      collectors.MetricTypeCounter,
+				Help:      "Packets of TCP sockets dropped by the kernel",
+			})
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return metrics, fmt.Errorf("failed to read eBPF stats: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// execMetrics reports the execs counted by command name, and the events
+// lost to a full ring buffer
+func (ec *EBPFCollector) execMetrics(now int64) []*collectors.Metric {
+	var lost uint64
+	ec.lost.Lookup(uint32(0), &lost)
+	metrics := []*collectors.Metric{{
+		Name:      "ebpf_process_exec_events_lost_total",
+		Value:     float64(lost),
+		Timestamp: now,
+		Labels:    map[string]string{},
+		Type:      collectors.MetricTypeCounter,
+		Help:      "Exec events dropped because the event buffer was full",
+	}}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	for comm, count := range ec.execs {
+		metrics = append(metrics, &collectors.Metric{
+			Name:      "ebpf_process_execs_total",
+			Value:     float64(count),
+			Timestamp: now,
+			Labels:    map[string]string{"comm": comm},
+			Type:      collectors.MetricTypeCounter,
+			Help:      "Processes executed, including those too short-lived to sample",
+		})
+	}
+	return metrics
+}
+
+// fileIOMetrics reports the file I/O totals by command name
+func (ec *EBPFCollector) fileIOMetrics(now int64) []*collectors.Metric {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	metrics := make([]*collectors.Metric, 0, 4*len(ec.io))
+	for comm, total := range ec.io {
+		labels := map[string]string{"comm": comm}
+		metrics = append(metrics,
+			&collectors.Metric{
+				Name:      "ebpf_process_read_bytes_total",
+				Value:     float64(total.ReadBytes),
+				Timestamp: now,
+				Labels:    labels,
+				Type:      collectors.MetricTypeCounter,
+				Help:      "Bytes returned by read syscalls",
+				Unit:      "bytes",
+			},
+			&collectors.Metric{
+				Name:      "ebpf_process_reads_total",
+				Value:     float64(total.Reads),
+				Timestamp: now,
+				Labels:    labels,
+				Type:      collectors.MetricTypeCounter,
+				Help:      "Read syscalls that returned data",
+			},
+			&collectors.Metric{
+				Name:      "ebpf_process_write_bytes_total",
+				Value:     float64(total.WriteBytes),
+				Timestamp: now,
+				Labels:    labels,
+				Type:      collectors.MetricTypeCounter,
+				Help:      "Bytes written by write syscalls",
+				Unit:      "bytes",
+			},
+			&collectors.Metric{
+				Name:      "ebpf_process_writes_total",
+				Value:     float64(total.Writes),
+				Timestamp: now,
+				Labels:    labels,
+				Type:      collectors.MetricTypeCounter,
+				Help:      "Write syscalls that wrote data",
+			},
+		)
+	}
+	return metrics
+}
+
+// Close detaches the programs, stops the exec event stream and frees the
+// maps
+func (ec *EBPFCollector) Close() error {
+	for _, l := range ec.links {
+		l.Close()
+	}
+	for _, prog := range ec.progs {
+		prog.Close()
+	}
+	if ec.reader != nil {
+		ec.reader.Close()
+		if ec.done != nil {
+			<-ec.done
+		}
+	}
+	if ec.stream != nil {
+		ec.stream.close()
+	}
+	for _, m := range []*ebpf.Map{ec.starts, ec.stats, ec.events, ec.lost, ec.procs} {
+		if m != nil {
+			m.Close()
+		}
+	}
+	return nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
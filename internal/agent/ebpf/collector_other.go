@@ -0,0 +1,35 @@
+//go:build !linux
+
+package ebpf
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/meettoy2004/lnmonja/internal/agent/collectors"
+)
+
+// EBPFCollector is only available on Linux
+type EBPFCollector struct {
+	*collectors.BaseCollector
+}
+
+// NewEBPFCollector returns an error on non-Linux platforms
+func NewEBPFCollector(config EBPFCollectorConfig) (*EBPFCollector, error) {
+	return nil, fmt.Errorf("eBPF collector is only supported on Linux")
+}
+
+// Collect is never called on non-Linux platforms
+func (ec *EBPFCollector) Collect(ctx context.Context) ([]*collectors.Metric, error) {
+	return nil, nil
+}
+
+// SubscribeExecs fails on non-Linux platforms
+func (ec *EBPFCollector) SubscribeExecs() (<-chan ExecEvent, func(), error) {
+	return nil, nil, fmt.Errorf("exec events are only supported on Linux")
+}
+
+// Close does nothing on non-Linux platforms
+func (ec *EBPFCollector) Close() error {
+	return nil
+}
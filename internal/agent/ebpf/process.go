@@ -0,0 +1,163 @@
+package ebpf
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecEvent is a traced process exec
+type ExecEvent struct {
+	Time time.Time `json:"time"`
+	PID  uint32    `json:"pid"`
+	// PPID is zero without the kernel's BTF
+	PPID     uint32 `json:"ppid,omitempty"`
+	UID      uint32 `json:"uid"`
+	Comm     string `json:"comm"`
+	Filename string `json:"filename"`
+}
+
+// execRecord is an exec event as the exec program writes it to the ring
+// buffer
+type execRecord struct {
+	PID      uint32
+	PPID     uint32
+	UID      uint32
+	Pad      uint32
+	Comm     [16]byte
+	Filename [256]byte
+}
+
+// ioKey identifies a process in the file I/O map. A process that execs
+// gets a new key with its new command name.
+type ioKey struct {
+	PID  uint32
+	Pad  uint32
+	Comm [16]byte
+}
+
+// ioValue is a process's counters in the file I/O map
+type ioValue struct {
+	ReadBytes  uint64
+	Reads      uint64
+	WriteBytes uint64
+	Writes     uint64
+}
+
+const (
+	execRecordSize = 32 + 256
+	ioKeySize      = 24
+	ioValueSize    = 4 * 8
+
+	offReadBytes  = 0
+	offWriteBytes = 16
+
+	// execRingSize is the size of the exec event ring buffer, a power of
+	// two multiple of the page size
+	execRingSize = 256 << 10
+	defaultProcs = 4096
+	// maxComms bounds the command names counted; execs and I/O of others
+	// are counted under "other"
+	maxComms = 1024
+)
+
+// cString returns the string in a NUL terminated buffer
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// processFilter selects the processes the exec and file I/O programs
+// report
+type processFilter struct {
+	comms []string
+	paths []string
+}
+
+// matchComm tells whether a command name matches the comm patterns
+func (f *processFilter) matchComm(comm string) bool {
+	if len(f.comms) == 0 {
+		return true
+	}
+	for _, pattern := range f.comms {
+		if ok, _ := path.Match(pattern, comm); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath tells whether an executable's path matches the path patterns.
+// A pattern ending in / matches everything below the directory.
+func (f *processFilter) matchPath(filename string) bool {
+	if len(f.paths) == 0 {
+		return true
+	}
+	for _, pattern := range f.paths {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(filename, pattern) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, filename); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// execStream fans exec events out to subscribers. A subscriber that falls
+// behind misses events rather than holding up the others.
+type execStream struct {
+	mu   sync.Mutex
+	subs map[chan ExecEvent]struct{}
+}
+
+func newExecStream() *execStream {
+	return &execStream{subs: make(map[chan ExecEvent]struct{})}
+}
+
+// subscribe returns a channel of exec events and a function that ends the
+// subscription
+func (s *execStream) subscribe() (<-chan ExecEvent, func()) {
+	ch := make(chan ExecEvent, 256)
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subs[ch]; ok {
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends an event to every subscriber with room for it
+func (s *execStream) publish(event ExecEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// close ends every subscription
+func (s *execStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
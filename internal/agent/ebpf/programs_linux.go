@@ -0,0 +1,572 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+// tracingRoots are where tracefs is mounted
+var tracingRoots = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// field is the location of a tracepoint field or struct member
+type field struct {
+	offset int16
+	size   int
+}
+
+// tracepointLayout maps a tracepoint's field names to their locations
+type tracepointLayout map[string]field
+
+// readTracepointLayout parses a tracepoint's format file. Every kernel
+// with the tracepoint has it, with or without BTF.
+func readTracepointLayout(group, name string) (tracepointLayout, error) {
+	var lastErr error
+	for _, root := range tracingRoots {
+		f, err := os.Open(filepath.Join(root, "events", group, name, "format"))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		defer f.Close()
+
+		layout := make(tracepointLayout)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// field:__u8 daddr[4];	offset:36;	size:4;	signed:0;
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "field:") {
+				continue
+			}
+			var decl string
+			var fl field
+			for _, part := range strings.Split(line, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+				if !ok {
+					continue
+				}
+				switch key {
+				case "field":
+					decl = value
+				case "offset":
+					n, _ := strconv.Atoi(value)
+					fl.offset = int16(n)
+				case "size":
+					fl.size, _ = strconv.Atoi(value)
+				}
+			}
+			words := strings.Fields(decl)
+			if len(words) == 0 {
+				continue
+			}
+			fieldName := strings.TrimLeft(words[len(words)-1], "*")
+			if i := strings.IndexByte(fieldName, '['); i >= 0 {
+				fieldName = fieldName[:i]
+			}
+			layout[fieldName] = fl
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return layout, nil
+	}
+	return nil, fmt.Errorf("tracepoint %s/%s: %w", group, name, lastErr)
+}
+
+// require returns an error naming the first field the layout lacks
+func (l tracepointLayout) require(names ...string) error {
+	for _, name := range names {
+		if _, ok := l[name]; !ok {
+			return fmt.Errorf("tracepoint has no %s field", name)
+		}
+	}
+	return nil
+}
+
+// memberField returns the location of a member of a kernel struct,
+// following a path of member names. Members of anonymous structs and
+// unions are found as if they were the parent's.
+func memberField(spec *btf.Spec, structName string, path ...string) (field, error) {
+	var s *btf.Struct
+	if err := spec.TypeByName(structName, &s); err != nil {
+		return field{}, err
+	}
+	var typ btf.Type = s
+	var offset btf.Bits
+	var bitfield btf.Bits
+	for _, name := range path {
+		member, base, ok := findMember(typ, name)
+		if !ok {
+			return field{}, fmt.Errorf("struct %s has no member %s", structName, strings.Join(path, "."))
+		}
+		offset += base + member.Offset
+		typ = member.Type
+		bitfield = member.BitfieldSize
+	}
+	if offset%8 != 0 || bitfield%8 != 0 {
+		return field{}, fmt.Errorf("%s.%s is not byte aligned", structName, strings.Join(path, "."))
+	}
+	size, err := btf.Sizeof(typ)
+	if err != nil {
+		return field{}, err
+	}
+	if bitfield != 0 {
+		size = int(bitfield / 8)
+	}
+	return field{offset: int16(offset / 8), size: size}, nil
+}
+
+// findMember looks up a member of a struct or union, descending into
+// anonymous members, and returns it with the offset of its parent
+func findMember(typ btf.Type, name string) (btf.Member, btf.Bits, bool) {
+	var members []btf.Member
+	switch t := btf.UnderlyingType(typ).(type) {
+	case *btf.Struct:
+		members = t.Members
+	case *btf.Union:
+		members = t.Members
+	default:
+		return btf.Member{}, 0, false
+	}
+	for _, m := range members {
+		if m.Name == name {
+			return m, 0, true
+		}
+	}
+	for _, m := range members {
+		if m.Name != "" {
+			continue
+		}
+		if found, base, ok := findMember(m.Type, name); ok {
+			return found, m.Offset + base, true
+		}
+	}
+	return btf.Member{}, 0, false
+}
+
+// sockOffsets are the members of struct sk_buff and struct sock that the
+// drop program reads
+type sockOffsets struct {
+	skbSk    field
+	family   field
+	protocol field
+	dport    field
+	daddr    field
+	// daddr6 is zero without IPv6 support
+	daddr6 field
+}
+
+// loadSockOffsets resolves the drop program's offsets from the kernel BTF
+func loadSockOffsets(spec *btf.Spec) (*sockOffsets, error) {
+	var o sockOffsets
+	var err error
+	if o.skbSk, err = memberField(spec, "sk_buff", "sk"); err != nil {
+		return nil, err
+	}
+	if o.family, err = memberField(spec, "sock", "__sk_common", "skc_family"); err != nil {
+		return nil, err
+	}
+	if o.protocol, err = memberField(spec, "sock", "sk_protocol"); err != nil {
+		return nil, err
+	}
+	if o.dport, err = memberField(spec, "sock", "__sk_common", "skc_dport"); err != nil {
+		return nil, err
+	}
+	if o.daddr, err = memberField(spec, "sock", "__sk_common", "skc_daddr"); err != nil {
+		return nil, err
+	}
+	o.daddr6, _ = memberField(spec, "sock", "__sk_common", "skc_v6_daddr")
+	return &o, nil
+}
+
+// Stack layout shared by the programs, relative to the frame pointer
+const (
+	stackScratch = -8
+	stackSkaddr  = -16
+	stackKey     = -16 - keySize
+	stackValue   = stackKey - valueSize
+)
+
+// sizes maps a byte count to its load and store size
+var sizes = map[int]asm.Size{1: asm.Byte, 2: asm.Half, 4: asm.Word, 8: asm.DWord}
+
+// copyFromCtx copies a tracepoint field to the stack. Context loads must
+// be aligned to their size, so the field is copied in the widest aligned
+// pieces.
+func copyFromCtx(dst int16, f field, n int) asm.Instructions {
+	var insns asm.Instructions
+	for copied := 0; copied < n; {
+		width := 8
+		for width > 1 && ((int(f.offset)+copied)%width != 0 || width > n-copied || (int(dst)+copied)%width != 0) {
+			width /= 2
+		}
+		insns = append(insns,
+			asm.LoadMem(asm.R1, asm.R6, f.offset+int16(copied), sizes[width]),
+			asm.StoreMem(asm.RFP, dst+int16(copied), asm.R1, sizes[width]),
+		)
+		copied += width
+	}
+	return insns
+}
+
+// zeroStack zeroes n bytes of the stack, n a multiple of 8
+func zeroStack(off int16, n int) asm.Instructions {
+	var insns asm.Instructions
+	for i := 0; i < n; i += 8 {
+		insns = append(insns, asm.StoreImm(asm.RFP, off+int16(i), 0, asm.DWord))
+	}
+	return insns
+}
+
+// keyFromCtx builds the stats key on the stack from a tracepoint's family,
+// dport, daddr and daddr_v6 fields, exiting for other families
+func keyFromCtx(l tracepointLayout) asm.Instructions {
+	insns := zeroStack(stackKey, keySize)
+	insns = append(insns, copyFromCtx(stackKey, l["family"], 2)...)
+	insns = append(insns, copyFromCtx(stackKey+2, l["dport"], 2)...)
+	insns = append(insns,
+		asm.LoadMem(asm.R1, asm.RFP, stackKey, asm.Half),
+		asm.JEq.Imm(asm.R1, afInet, "ipv4"),
+		asm.JNE.Imm(asm.R1, afInet6, "exit"),
+	)
+	insns = append(insns, copyFromCtx(stackKey+8, l["daddr_v6"], 16)...)
+	insns = append(insns, asm.Ja.Label("key"))
+	v4 := copyFromCtx(stackKey+8, l["daddr"], 4)
+	v4[0] = v4[0].WithSymbol("ipv4")
+	return append(insns, v4...)
+}
+
+// lookupStats leaves a pointer to the stats of the key on the stack in R7,
+// creating them if the destination is new. Its first instruction is
+// labelled key.
+func lookupStats(stats *ebpf.Map) asm.Instructions {
+	return lookup(stats, stackKey, stackValue, valueSize)
+}
+
+// lookup leaves a pointer to the value of the key at key on the stack in
+// R7, adding a zeroed value built at value if the key is new. Its first
+// instruction is labelled key.
+func lookup(m *ebpf.Map, key, value int16, size int) asm.Instructions {
+	insns := asm.Instructions{
+		asm.LoadMapPtr(asm.R1, m.FD()).WithSymbol("key"),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, int32(key)),
+		asm.FnMapLookupElem.Call(),
+		asm.JNE.Imm(asm.R0, 0, "found"),
+	}
+	insns = append(insns, zeroStack(value, size)...)
+	return append(insns,
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, int32(key)),
+		asm.Mov.Reg(asm.R3, asm.RFP),
+		asm.Add.Imm(asm.R3, int32(value)),
+		asm.Mov.Imm(asm.R4, int32(ebpf.UpdateNoExist)),
+		asm.FnMapUpdateElem.Call(),
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, int32(key)),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+		asm.Mov.Reg(asm.R7, asm.R0).WithSymbol("found"),
+	)
+}
+
+// increment atomically adds src to the stats counter at off
+func increment(off int16, src asm.Register) asm.Instruction {
+	ins := asm.StoreXAdd(asm.R7, src, asm.DWord)
+	ins.Offset = off
+	return ins
+}
+
+// exit returns 0 and is labelled exit
+func exit() asm.Instructions {
+	return asm.Instructions{
+		asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+		asm.Return(),
+	}
+}
+
+// connectProgram handles sock/inet_sock_set_state. It records when a TCP
+// socket enters SYN_SENT and, when it leaves it, observes the latency or
+// counts the failure.
+func connectProgram(l tracepointLayout, starts, stats *ebpf.Map) asm.Instructions {
+	skaddr := copyFromCtx(stackSkaddr, l["skaddr"], 8)
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.LoadMem(asm.R1, asm.R6, l["protocol"].offset, sizes[l["protocol"].size]),
+		asm.JNE.Imm(asm.R1, ipprotoTCP, "exit"),
+		asm.LoadMem(asm.R8, asm.R6, l["newstate"].offset, asm.Word),
+		asm.LoadMem(asm.R9, asm.R6, l["oldstate"].offset, asm.Word),
+		asm.JNE.Imm(asm.R8, tcpSynSent, "leave"),
+	}
+	insns = append(insns, skaddr...)
+	insns = append(insns,
+		asm.FnKtimeGetNs.Call(),
+		asm.StoreMem(asm.RFP, stackScratch, asm.R0, asm.DWord),
+		asm.LoadMapPtr(asm.R1, starts.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackSkaddr),
+		asm.Mov.Reg(asm.R3, asm.RFP),
+		asm.Add.Imm(asm.R3, stackScratch),
+		asm.Mov.Imm(asm.R4, int32(ebpf.UpdateAny)),
+		asm.FnMapUpdateElem.Call(),
+		asm.Ja.Label("exit"),
+		asm.JNE.Imm(asm.R9, tcpSynSent, "exit").WithSymbol("leave"),
+	)
+	insns = append(insns, skaddr...)
+	insns = append(insns,
+		asm.LoadMapPtr(asm.R1, starts.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackSkaddr),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+		asm.LoadMem(asm.R9, asm.R0, 0, asm.DWord),
+		asm.LoadMapPtr(asm.R1, starts.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackSkaddr),
+		asm.FnMapDeleteElem.Call(),
+		asm.FnKtimeGetNs.Call(),
+		asm.Sub.Reg(asm.R0, asm.R9),
+		asm.Mov.Reg(asm.R9, asm.R0),
+	)
+	insns = append(insns, keyFromCtx(l)...)
+	insns = append(insns, lookupStats(stats)...)
+	insns = append(insns,
+		asm.Mov.Imm(asm.R1, 1),
+		asm.JNE.Imm(asm.R8, tcpEstab, "failed"),
+		increment(offCount, asm.R1),
+		increment(offSum, asm.R9),
+		asm.Mov.Reg(asm.R2, asm.R9),
+		asm.Div.Imm(asm.R2, 1000),
+	)
+	for i, bound := range connectBuckets {
+		insns = append(insns, asm.JLT.Imm(asm.R2, bound, fmt.Sprintf("bucket%d", i)))
+	}
+	insns = append(insns, asm.Ja.Label(fmt.Sprintf("bucket%d", len(connectBuckets))))
+	for i := 0; i <= len(connectBuckets); i++ {
+		insns = append(insns,
+			increment(int16(i*8), asm.R1).WithSymbol(fmt.Sprintf("bucket%d", i)),
+			asm.Ja.Label("exit"),
+		)
+	}
+	insns = append(insns, increment(offFailures, asm.R1).WithSymbol("failed"))
+	return append(insns, exit()...)
+}
+
+// retransmitProgram handles tcp/tcp_retransmit_skb
+func retransmitProgram(l tracepointLayout, stats *ebpf.Map) asm.Instructions {
+	insns := asm.Instructions{asm.Mov.Reg(asm.R6, asm.R1)}
+	insns = append(insns, keyFromCtx(l)...)
+	insns = append(insns, lookupStats(stats)...)
+	insns = append(insns,
+		asm.Mov.Imm(asm.R1, 1),
+		increment(offRetransmits, asm.R1),
+	)
+	return append(insns, exit()...)
+}
+
+// probeRead copies size bytes from the kernel address in src plus off to
+// the stack
+func probeRead(dst int16, src asm.Register, f field) asm.Instructions {
+	return asm.Instructions{
+		asm.Mov.Reg(asm.R3, src),
+		asm.Add.Imm(asm.R3, int32(f.offset)),
+		asm.Mov.Reg(asm.R1, asm.RFP),
+		asm.Add.Imm(asm.R1, int32(dst)),
+		asm.Mov.Imm(asm.R2, int32(f.size)),
+		asm.FnProbeReadKernel.Call(),
+	}
+}
+
+// dropProgram handles skb/kfree_skb. It reads the socket of the dropped
+// packet, rx_sk where the tracepoint has it or else skb->sk, and counts
+// the drop for the socket's peer if it is TCP.
+func dropProgram(l tracepointLayout, o *sockOffsets, stats *ebpf.Map) asm.Instructions {
+	insns := asm.Instructions{asm.Mov.Reg(asm.R6, asm.R1)}
+	if rx, ok := l["rx_sk"]; ok {
+		insns = append(insns,
+			asm.LoadMem(asm.R8, asm.R6, rx.offset, asm.DWord),
+			asm.JNE.Imm(asm.R8, 0, "sock"),
+		)
+	}
+	insns = append(insns,
+		asm.StoreImm(asm.RFP, stackScratch, 0, asm.DWord),
+		asm.LoadMem(asm.R7, asm.R6, l["skbaddr"].offset, asm.DWord),
+	)
+	insns = append(insns, probeRead(stackScratch, asm.R7, o.skbSk)...)
+	insns = append(insns,
+		asm.LoadMem(asm.R8, asm.RFP, stackScratch, asm.DWord),
+		asm.JEq.Imm(asm.R8, 0, "exit"),
+		asm.StoreImm(asm.RFP, stackScratch, 0, asm.DWord).WithSymbol("sock"),
+	)
+	insns = append(insns, probeRead(stackScratch, asm.R8, o.protocol)...)
+	insns = append(insns,
+		asm.LoadMem(asm.R1, asm.RFP, stackScratch, sizes[o.protocol.size]),
+		asm.JNE.Imm(asm.R1, ipprotoTCP, "exit"),
+	)
+	insns = append(insns, zeroStack(stackKey, keySize)...)
+	insns = append(insns, probeRead(stackKey, asm.R8, field{o.family.offset, 2})...)
+	insns = append(insns, probeRead(stackKey+2, asm.R8, field{o.dport.offset, 2})...)
+	insns = append(insns,
+		asm.LoadMem(asm.R1, asm.RFP, stackKey+2, asm.Half),
+		asm.HostTo(asm.BE, asm.R1, asm.Half),
+		asm.StoreMem(asm.RFP, stackKey+2, asm.R1, asm.Half),
+		asm.LoadMem(asm.R1, asm.RFP, stackKey, asm.Half),
+		asm.JEq.Imm(asm.R1, afInet, "ipv4"),
+	)
+	if o.daddr6.size == 16 {
+		insns = append(insns, asm.JNE.Imm(asm.R1, afInet6, "exit"))
+		insns = append(insns, probeRead(stackKey+8, asm.R8, o.daddr6)...)
+		insns = append(insns, asm.Ja.Label("key"))
+	} else {
+		insns = append(insns, asm.Ja.Label("exit"))
+	}
+	v4 := probeRead(stackKey+8, asm.R8, field{o.daddr.offset, 4})
+	v4[0] = v4[0].WithSymbol("ipv4")
+	insns = append(insns, v4...)
+	insns = append(insns, lookupStats(stats)...)
+	insns = append(insns,
+		asm.Mov.Imm(asm.R1, 1),
+		increment(offDrops, asm.R1),
+	)
+	return append(insns, exit()...)
+}
+
+// taskOffsets are the members of struct task_struct the exec program
+// reads to find the parent process
+type taskOffsets struct {
+	realParent field
+	tgid       field
+}
+
+// loadTaskOffsets resolves the exec program's offsets from the kernel BTF
+func loadTaskOffsets(spec *btf.Spec) (*taskOffsets, error) {
+	var o taskOffsets
+	var err error
+	if o.realParent, err = memberField(spec, "task_struct", "real_parent"); err != nil {
+		return nil, err
+	}
+	if o.tgid, err = memberField(spec, "task_struct", "tgid"); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Stack layout of the exec and file I/O programs
+const (
+	stackEvent   = stackScratch - execRecordSize
+	stackIOKey   = -ioKeySize
+	stackIOValue = stackIOKey - ioValueSize
+)
+
+// execProgram handles sched/sched_process_exec. It writes the exec to the
+// events ring buffer, counting it in lost if the buffer is full. The
+// parent is read when the task offsets are known.
+func execProgram(l tracepointLayout, task *taskOffsets, events, lost *ebpf.Map) asm.Instructions {
+	insns := asm.Instructions{asm.Mov.Reg(asm.R6, asm.R1)}
+	insns = append(insns, zeroStack(stackEvent, execRecordSize)...)
+	insns = append(insns, copyFromCtx(stackEvent, l["pid"], 4)...)
+	insns = append(insns,
+		asm.FnGetCurrentUidGid.Call(),
+		asm.StoreMem(asm.RFP, stackEvent+8, asm.R0, asm.Word),
+	)
+	if task != nil {
+		insns = append(insns,
+			asm.FnGetCurrentTask.Call(),
+			asm.Mov.Reg(asm.R7, asm.R0),
+			asm.StoreImm(asm.RFP, stackScratch, 0, asm.DWord),
+		)
+		insns = append(insns, probeRead(stackScratch, asm.R7, task.realParent)...)
+		insns = append(insns,
+			asm.LoadMem(asm.R8, asm.RFP, stackScratch, asm.DWord),
+			asm.JEq.Imm(asm.R8, 0, "comm"),
+		)
+		insns = append(insns, probeRead(stackEvent+4, asm.R8, field{task.tgid.offset, 4})...)
+	}
+	// The filename is a __data_loc field: the low 16 bits locate it in
+	// the tracepoint record
+	insns = append(insns,
+		asm.Mov.Reg(asm.R1, asm.RFP).WithSymbol("comm"),
+		asm.Add.Imm(asm.R1, stackEvent+16),
+		asm.Mov.Imm(asm.R2, 16),
+		asm.FnGetCurrentComm.Call(),
+		asm.LoadMem(asm.R3, asm.R6, l["filename"].offset, asm.Word),
+		asm.And.Imm(asm.R3, 0xffff),
+		asm.Add.Reg(asm.R3, asm.R6),
+		asm.Mov.Reg(asm.R1, asm.RFP),
+		asm.Add.Imm(asm.R1, stackEvent+32),
+		asm.Mov.Imm(asm.R2, 256),
+		asm.FnProbeReadKernelStr.Call(),
+		asm.LoadMapPtr(asm.R1, events.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackEvent),
+		asm.Mov.Imm(asm.R3, execRecordSize),
+		asm.Mov.Imm(asm.R4, 0),
+		asm.FnRingbufOutput.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+		asm.StoreImm(asm.RFP, stackScratch, 0, asm.Word),
+		asm.LoadMapPtr(asm.R1, lost.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackScratch),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+		asm.Mov.Reg(asm.R7, asm.R0),
+		asm.Mov.Imm(asm.R1, 1),
+		increment(0, asm.R1),
+	)
+	return append(insns, exit()...)
+}
+
+// ioProgram handles a syscalls/sys_exit_ tracepoint of a read or write
+// syscall, adding the bytes transferred to the counters at off of the
+// calling process
+func ioProgram(l tracepointLayout, procs *ebpf.Map, off int16) asm.Instructions {
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.LoadMem(asm.R8, asm.R6, l["ret"].offset, asm.DWord),
+		asm.JSLE.Imm(asm.R8, 0, "exit"),
+	}
+	insns = append(insns, zeroStack(stackIOKey, ioKeySize)...)
+	insns = append(insns,
+		asm.FnGetCurrentPidTgid.Call(),
+		asm.RSh.Imm(asm.R0, 32),
+		asm.StoreMem(asm.RFP, stackIOKey, asm.R0, asm.Word),
+		asm.Mov.Reg(asm.R1, asm.RFP),
+		asm.Add.Imm(asm.R1, stackIOKey+8),
+		asm.Mov.Imm(asm.R2, 16),
+		asm.FnGetCurrentComm.Call(),
+	)
+	insns = append(insns, lookup(procs, stackIOKey, stackIOValue, ioValueSize)...)
+	insns = append(insns,
+		increment(off, asm.R8),
+		asm.Mov.Imm(asm.R1, 1),
+		increment(off+8, asm.R1),
+	)
+	return append(insns, exit()...)
+}
+
+// errNoBTF is returned when the kernel does not expose its BTF
+var errNoBTF = errors.New("kernel BTF not available")
+
+// loadKernelBTF loads the running kernel's BTF
+func loadKernelBTF() (*btf.Spec, error) {
+	spec, err := btf.LoadKernelSpec()
+	if err != nil {
+		if errors.Is(err, btf.ErrNotSupported) || errors.Is(err, os.ErrNotExist) {
+			return nil, errNoBTF
+		}
+		return nil, err
+	}
+	return spec, nil
+}
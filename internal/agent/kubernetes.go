@@ -0,0 +1,340 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/agent/collectors"
+	"github.com/meettoy2004/lnmonja/internal/kube"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Labels added to metrics of an agent running in Kubernetes
+const (
+	labelK8sCluster   = "k8s_cluster"
+	labelK8sNode      = "k8s_node"
+	labelZone         = "zone"
+	labelRegion       = "region"
+	labelK8sNamespace = "k8s_namespace"
+	labelK8sPod       = "k8s_pod"
+	labelK8sContainer = "k8s_container"
+)
+
+// Well-known node topology labels, newest first
+var (
+	zoneLabels   = []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"}
+	regionLabels = []string{"topology.kubernetes.io/region", "failure-domain.beta.kubernetes.io/region"}
+)
+
+var (
+	// containerIDPattern matches the container ID in a cgroup path such as
+	// .../cri-containerd-<id>.scope or .../docker/<id>
+	containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+	// podUIDPattern matches the pod UID in a cgroup path; the systemd
+	// driver writes it with underscores
+	podUIDPattern = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+)
+
+// podRef identifies the pod, and the container if known, a process or
+// cgroup belongs to
+type podRef struct {
+	namespace string
+	pod       string
+	container string
+}
+
+// kubeletPodList is the subset of the kubelet /pods response the agent uses
+type kubeletPodList struct {
+	Items []struct {
+		Metadata kube.ObjectMeta `json:"metadata"`
+		Status   struct {
+			ContainerStatuses     []kubeletContainerStatus `json:"containerStatuses"`
+			InitContainerStatuses []kubeletContainerStatus `json:"initContainerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type kubeletContainerStatus struct {
+	Name string `json:"name"`
+	// ContainerID is prefixed with the runtime, e.g. containerd://<id>
+	ContainerID string `json:"containerID"`
+}
+
+// kubeMetadata labels the metrics of an agent running as a DaemonSet with
+// its cluster, node and zone, and attributes process, cgroup and container
+// metrics to pods from the kubelet's pod list
+type kubeMetadata struct {
+	api      *kube.Client
+	kubelet  *kube.Client
+	nodeName string
+	zone     string
+	refresh  time.Duration
+	procPath string
+	logger   *zap.Logger
+
+	mu         sync.RWMutex
+	static     map[string]string
+	pods       map[string]*podRef
+	containers map[string]*podRef
+}
+
+// newKubeMetadata returns nil when the agent is not running in Kubernetes
+// or the integration is disabled
+func newKubeMetadata(config *utils.Config, logger *zap.Logger) (*kubeMetadata, error) {
+	kc := config.Agent.Kubernetes
+	switch kc.Mode {
+	case utils.KubernetesModeDisabled:
+		return nil, nil
+	case utils.KubernetesModeAuto:
+		if !kube.InCluster() {
+			return nil, nil
+		}
+	}
+
+	nodeName := kc.NodeName
+	if nodeName == "" {
+		nodeName = os.Getenv("NODE_NAME")
+	}
+	if nodeName == "" {
+		// With hostNetwork the pod shares the node's hostname
+		nodeName, _ = os.Hostname()
+	}
+	cluster := kc.Cluster
+	if cluster == "" {
+		cluster = os.Getenv("CLUSTER_NAME")
+	}
+
+	api, err := kube.NewClient(kube.Config{TokenFile: kube.ServiceAccountToken, CAFile: kube.ServiceAccountCA})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes API client: %w", err)
+	}
+
+	kubeletURL := kc.KubeletURL
+	if kubeletURL == "" {
+		host := os.Getenv("NODE_IP")
+		if host == "" {
+			host = nodeName
+		}
+		kubeletURL = "https://" + net.JoinHostPort(host, "10250")
+	}
+	kubelet, err := kube.NewClient(kube.Config{
+		Host:               kubeletURL,
+		TokenFile:          kube.ServiceAccountToken,
+		CAFile:             kube.ServiceAccountCA,
+		InsecureSkipVerify: kc.KubeletInsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubelet client: %w", err)
+	}
+
+	static := map[string]string{labelK8sNode: nodeName}
+	if cluster != "" {
+		static[labelK8sCluster] = cluster
+	}
+	if kc.Zone != "" {
+		static[labelZone] = kc.Zone
+	}
+
+	return &kubeMetadata{
+		api:        api,
+		kubelet:    kubelet,
+		nodeName:   nodeName,
+		zone:       kc.Zone,
+		refresh:    kc.PodRefreshInterval,
+		procPath:   "/proc",
+		logger:     logger.With(zap.String("k8s_node", nodeName)),
+		static:     static,
+		pods:       make(map[string]*podRef),
+		containers: make(map[string]*podRef),
+	}, nil
+}
+
+// loadNode reads the zone and region from the node's topology labels
+func (k *kubeMetadata) loadNode(ctx context.Context) error {
+	var node struct {
+		Metadata kube.ObjectMeta `json:"metadata"`
+	}
+	if err := k.api.Get(ctx, "/api/v1/nodes/"+k.nodeName, &node); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if zone := firstLabel(node.Metadata.Labels, zoneLabels); zone != "" && k.zone == "" {
+		k.static[labelZone] = zone
+	}
+	if region := firstLabel(node.Metadata.Labels, regionLabels); region != "" {
+		k.static[labelRegion] = region
+	}
+	return nil
+}
+
+func firstLabel(labels map[string]string, keys []string) string {
+	for _, key := range keys {
+		if v := labels[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// refreshPods replaces the pod and container index with the kubelet's
+// current pod list
+func (k *kubeMetadata) refreshPods(ctx context.Context) error {
+	var list kubeletPodList
+	if err := k.kubelet.Get(ctx, "/pods", &list); err != nil {
+		return err
+	}
+
+	pods := make(map[string]*podRef, len(list.Items))
+	containers := make(map[string]*podRef)
+	for _, item := range list.Items {
+		meta := item.Metadata
+		pods[meta.UID] = &podRef{namespace: meta.Namespace, pod: meta.Name}
+
+		statuses := append(item.Status.ContainerStatuses, item.Status.InitContainerStatuses...)
+		for _, status := range statuses {
+			id := trimRuntime(status.ContainerID)
+			if id == "" {
+				continue
+			}
+			containers[id] = &podRef{namespace: meta.Namespace, pod: meta.Name, container: status.Name}
+		}
+	}
+
+	k.mu.Lock()
+	k.pods, k.containers = pods, containers
+	k.mu.Unlock()
+	return nil
+}
+
+// trimRuntime strips the runtime prefix from a container ID
+func trimRuntime(id string) string {
+	if i := strings.Index(id, "://"); i >= 0 {
+		return id[i+3:]
+	}
+	return id
+}
+
+// enrich adds the static labels to every metric and pod labels to metrics
+// with a pid, cgroup or container_id label that belongs to a pod
+func (k *kubeMetadata) enrich(metrics []*collectors.Metric) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	// Collectors emit several metrics per process or cgroup
+	resolved := make(map[string]*podRef)
+
+	for _, metric := range metrics {
+		if metric.Labels == nil {
+			metric.Labels = make(map[string]string)
+		}
+		// The Kubernetes collector sets the node of each object it reports
+		for name, value := range k.static {
+			if _, ok := metric.Labels[name]; !ok {
+				metric.Labels[name] = value
+			}
+		}
+
+		ref := k.resolve(metric.Labels, resolved)
+		if ref == nil {
+			continue
+		}
+		metric.Labels[labelK8sNamespace] = ref.namespace
+		metric.Labels[labelK8sPod] = ref.pod
+		if ref.container != "" {
+			metric.Labels[labelK8sContainer] = ref.container
+		}
+	}
+}
+
+// resolve finds the pod of a metric, remembering lookups in resolved.
+// The caller holds k.mu.
+func (k *kubeMetadata) resolve(labels map[string]string, resolved map[string]*podRef) *podRef {
+	var key string
+	switch {
+	case labels["container_id"] != "":
+		key = "container:" + labels["container_id"]
+	case labels["cgroup"] != "":
+		key = "cgroup:" + labels["cgroup"]
+	case labels["pid"] != "":
+		key = "pid:" + labels["pid"]
+	default:
+		return nil
+	}
+	if ref, ok := resolved[key]; ok {
+		return ref
+	}
+
+	var ref *podRef
+	switch {
+	case labels["container_id"] != "":
+		ref = k.containers[trimRuntime(labels["container_id"])]
+	case labels["cgroup"] != "":
+		ref = k.fromCgroup(labels["cgroup"])
+	default:
+		// Needs hostPID to see the node's processes
+		data, err := os.ReadFile(filepath.Join(k.procPath, labels["pid"], "cgroup"))
+		if err == nil {
+			ref = k.fromCgroup(string(data))
+		}
+	}
+	resolved[key] = ref
+	return ref
+}
+
+// fromCgroup finds the pod of a cgroup path, or of the contents of a
+// /proc/<pid>/cgroup file
+func (k *kubeMetadata) fromCgroup(path string) *podRef {
+	for _, id := range containerIDPattern.FindAllString(path, -1) {
+		if ref := k.containers[id]; ref != nil {
+			return ref
+		}
+	}
+	if m := podUIDPattern.FindStringSubmatch(path); m != nil {
+		return k.pods[strings.ReplaceAll(m[1], "_", "-")]
+	}
+	return nil
+}
+
+// start loads the node's topology and the first pod list so the first
+// metrics are labelled. Failures are logged; the pod list is retried by
+// watchPods.
+func (k *kubeMetadata) start(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if err := k.loadNode(ctx); err != nil {
+		k.logger.Warn("Failed to read node topology, zone and region labels unavailable", zap.Error(err))
+	}
+	if err := k.refreshPods(ctx); err != nil {
+		k.logger.Warn("Failed to list pods from the kubelet", zap.Error(err))
+	}
+}
+
+// watchPods keeps the pod index current
+func (a *Agent) watchPods() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.kube.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.kube.refreshPods(a.ctx); err != nil && a.ctx.Err() == nil {
+				a.kube.logger.Warn("Failed to list pods from the kubelet", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/meettoy2004/lnmonja/internal/agent/collectors"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// maxNamingSeen bounds the metric names and label keys remembered as
+// checked, after which they are checked and logged again
+const maxNamingSeen = 10000
+
+// namingLog logs a warning the first time a collector produces a metric
+// that breaks the naming conventions
+type namingLog struct {
+	linter *utils.NamingLinter
+	logger *zap.Logger
+
+	mu sync.Mutex
+	// seen holds the checked metric names, with their unit and type, and
+	// label keys
+	seen map[string]bool
+}
+
+func newNamingLog(config *utils.NamingConfig, logger *zap.Logger) (*namingLog, error) {
+	linter, err := utils.NewNamingLinter(config)
+	if err != nil {
+		return nil, err
+	}
+	return &namingLog{
+		linter: linter,
+		logger: logger,
+		seen:   make(map[string]bool),
+	}, nil
+}
+
+// check logs the conventions broken by metrics a collector produced
+func (n *namingLog) check(collector string, metrics []*collectors.Metric) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, metric := range metrics {
+		counter := metric.Type == collectors.MetricTypeCounter
+		key := metric.Name + "\x00" + metric.Unit
+		if counter {
+			key += "\x00counter"
+		}
+		if n.first(key) {
+			for _, v := range n.linter.CheckName(metric.Name, metric.Unit, counter) {
+				n.warn(collector, metric.Name, v)
+			}
+		}
+
+		for label := range metric.Labels {
+			if n.first("\x00label\x00" + label) {
+				if v := n.linter.CheckLabel(label); v != nil {
+					n.warn(collector, metric.Name, *v)
+				}
+			}
+		}
+	}
+}
+
+// first reports whether key is checked for the first time
+func (n *namingLog) first(key string) bool {
+	if n.seen[key] {
+		return false
+	}
+	if len(n.seen) >= maxNamingSeen {
+		n.seen = make(map[string]bool)
+	}
+	n.seen[key] = true
+	return true
+}
+
+func (n *namingLog) warn(collector, metric string, v utils.NamingViolation) {
+	n.logger.Warn("Metric breaks naming convention",
+		zap.String("collector", collector),
+		zap.String("metric", metric),
+		zap.String("rule", v.Rule),
+		zap.String("message", v.Message),
+	)
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"path"
+
+	"github.com/meettoy2004/lnmonja/internal/agent/collectors"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// priorities assigns metrics the priority the server sheds them by when it
+// is overloaded
+type priorities struct {
+	collectors map[string]collectors.Priority
+	metrics    []metricPriority
+}
+
+type metricPriority struct {
+	match    string
+	priority collectors.Priority
+}
+
+// newPriorities returns the configured priorities, or nil if every metric
+// is normal priority
+func newPriorities(config *utils.Config) *priorities {
+	conf := config.Agent.Priorities
+	if len(conf.Collectors) == 0 && len(conf.Metrics) == 0 {
+		return nil
+	}
+
+	p := &priorities{collectors: make(map[string]collectors.Priority, len(conf.Collectors))}
+	for name, priority := range conf.Collectors {
+		p.collectors[name] = parsePriority(priority)
+	}
+	for _, m := range conf.Metrics {
+		p.metrics = append(p.metrics, metricPriority{match: m.Match, priority: parsePriority(m.Priority)})
+	}
+	return p
+}
+
+// parsePriority parses a priority the config was validated to hold
+func parsePriority(priority string) collectors.Priority {
+	switch priority {
+	case "critical":
+		return collectors.PriorityCritical
+	case "low":
+		return collectors.PriorityLow
+	default:
+		return collectors.PriorityNormal
+	}
+}
+
+// assign sets the priority of a collector's metrics
+func (p *priorities) assign(collector string, metrics []*collectors.Metric) {
+	if p == nil {
+		return
+	}
+	def := p.collectors[collector]
+	for _, metric := range metrics {
+		metric.Priority = def
+		for _, m := range p.metrics {
+			if ok, _ := path.Match(m.match, metric.Name); ok {
+				metric.Priority = m.priority
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/agent/ebpf"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Health states reported by the telemetry endpoint
+const (
+	healthOK        = "ok"
+	healthDegraded  = "degraded"
+	healthUnhealthy = "unhealthy"
+)
+
+// agentHealth is the body served at /health
+type agentHealth struct {
+	Status            string     `json:"status"`
+	NodeID            string     `json:"node_id"`
+	Connection        string     `json:"connection"`
+	LastSend          *time.Time `json:"last_send,omitempty"`
+	BufferedBatches   int        `json:"buffered_batches"`
+	DroppedBatches    uint64     `json:"dropped_batches"`
+	FailingCollectors []string   `json:"failing_collectors,omitempty"`
+	Problems          []string   `json:"problems,omitempty"`
+}
+
+// startTelemetry serves the agent's health at /health, its metrics in the
+// Prometheus exposition format at /metrics and the eBPF exec events at
+// /events/exec, until the agent stops
+func (a *Agent) startTelemetry() error {
+	listener, err := net.Listen("tcp", a.config.Agent.Telemetry.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", a.config.Agent.Telemetry.Address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", a.healthHandler)
+	mux.HandleFunc("/metrics", a.metricsHandler)
+	mux.HandleFunc("/events/exec", a.execEventsHandler)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			a.logger.Error("Telemetry endpoint failed", zap.Error(err))
+		}
+	}()
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		<-a.ctx.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	a.logger.Info("Telemetry endpoint started",
+		zap.String("address", listener.Addr().String()),
+	)
+	return nil
+}
+
+// health checks the agent. It is unhealthy when the metric processor is
+// stuck or no batch has been accepted by the server for three heartbeat
+// intervals, and degraded while disconnected, buffering or with failing
+// collectors.
+func (a *Agent) health(ctx context.Context) *agentHealth {
+	h := &agentHealth{
+		Status:            healthOK,
+		NodeID:            a.nodeID,
+		Connection:        a.client.State(),
+		DroppedBatches:    a.vitals.droppedBatches.Load(),
+		FailingCollectors: a.vitals.failingCollectors(),
+	}
+	if a.buffer != nil {
+		h.BufferedBatches, _ = a.buffer.pending()
+	}
+
+	var unhealthy, degraded []string
+	aliveCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := a.Alive(aliveCtx); err != nil {
+		unhealthy = append(unhealthy, err.Error())
+	}
+
+	since := a.started
+	if last := a.vitals.lastSent(); !last.IsZero() {
+		h.LastSend = &last
+		since = last
+	}
+	if stale := 3 * max(a.config.Agent.HeartbeatInterval, a.config.Agent.MaxBatchWait); time.Since(since) > stale {
+		unhealthy = append(unhealthy, fmt.Sprintf("no metrics accepted by the server for %s", time.Since(since).Round(time.Second)))
+	}
+
+	if h.Connection != "READY" {
+		degraded = append(degraded, "not connected to the server")
+	}
+	if h.BufferedBatches > 0 {
+		degraded = append(degraded, fmt.Sprintf("%d batches buffered", h.BufferedBatches))
+	}
+	if len(h.FailingCollectors) > 0 {
+		degraded = append(degraded, "collectors failing: "+strings.Join(h.FailingCollectors, ", "))
+	}
+
+	switch {
+	case len(unhealthy) > 0:
+		h.Status = healthUnhealthy
+	case len(degraded) > 0:
+		h.Status = healthDegraded
+	}
+	h.Problems = append(unhealthy, degraded...)
+	return h
+}
+
+func (a *Agent) healthHandler(w http.ResponseWriter, r *http.Request) {
+	h := a.health(r.Context())
+
+	code := http.StatusOK
+	if h.Status == healthUnhealthy {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(h); err != nil {
+		a.logger.Debug("Failed to write health response", zap.Error(err))
+	}
+}
+
+// execEventsHandler streams the execs traced by the eBPF collector as JSON
+// lines until the client goes away or the agent stops
+func (a *Agent) execEventsHandler(w http.ResponseWriter, r *http.Request) {
+	collector, ok := a.collectors["ebpf"].(*ebpf.EBPFCollector)
+	if !ok {
+		http.Error(w, "eBPF collector is not running", http.StatusNotFound)
+		return
+	}
+	events, cancel, err := collector.SubscribeExecs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// metricsHandler writes the agent's self-metrics in the Prometheus text
+// exposition format
+func (a *Agent) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	runs := a.vitals.runStats()
+	names := make([]string, 0, len(runs))
+	for name := range runs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	collectorMetric := func(name, typ, help string, value func(collectorRuns) float64) {
+		writeMetricHeader(w, name, typ, help)
+		for _, collector := range names {
+			fmt.Fprintf(w, "%s{collector=\"%s\"} %s\n", name, escapeLabelValue(collector), formatValue(value(runs[collector])))
+		}
+	}
+	collectorMetric("lnmonja_agent_collector_duration_seconds", "gauge",
+		"Duration of the last run of each collector.",
+		func(r collectorRuns) float64 { return r.lastDuration.Seconds() })
+	collectorMetric("lnmonja_agent_collector_last_run_timestamp_seconds", "gauge",
+		"Time of the last run of each collector.",
+		func(r collectorRuns) float64 { return unixSeconds(r.lastRun) })
+	collectorMetric("lnmonja_agent_collector_runs_total", "counter",
+		"Runs of each collector.",
+		func(r collectorRuns) float64 { return float64(r.runs) })
+	collectorMetric("lnmonja_agent_collector_errors_total", "counter",
+		"Failed runs of each collector.",
+		func(r collectorRuns) float64 { return float64(r.errors) })
+
+	writeMetric(w, "lnmonja_agent_sent_batches_total", "counter",
+		"Batches accepted by the server.", float64(a.vitals.sentBatches.Load()))
+	writeMetric(w, "lnmonja_agent_sent_metrics_total", "counter",
+		"Metrics accepted by the server.", float64(a.vitals.sentMetrics.Load()))
+	writeMetric(w, "lnmonja_agent_send_errors_total", "counter",
+		"Batches the server did not accept.", float64(a.vitals.sendErrors.Load()))
+	writeMetric(w, "lnmonja_agent_last_send_timestamp_seconds", "gauge",
+		"Time the server last accepted a batch.", unixSeconds(a.vitals.lastSent()))
+	writeMetric(w, "lnmonja_agent_dropped_batches_total", "counter",
+		"Batches dropped because they could not be sent or buffered.", float64(a.vitals.droppedBatches.Load()))
+	writeMetric(w, "lnmonja_agent_dropped_metrics_total", "counter",
+		"Metrics dropped because they could not be sent or buffered.", float64(a.vitals.droppedMetrics.Load()))
+	writeMetric(w, "lnmonja_agent_metrics_queue_length", "gauge",
+		"Collected batches waiting to be sent.", float64(len(a.metricsCh)))
+
+	var batches int
+	var size, maxSize int64
+	if a.buffer != nil {
+		batches, _ = a.buffer.pending()
+		size, maxSize = a.buffer.bytes()
+	}
+	writeMetric(w, "lnmonja_agent_buffer_batches", "gauge",
+		"Batches in the disk buffer waiting for replay.", float64(batches))
+	writeMetric(w, "lnmonja_agent_buffer_bytes", "gauge",
+		"Size of the disk buffer.", float64(size))
+	writeMetric(w, "lnmonja_agent_buffer_max_bytes", "gauge",
+		"Size limit of the disk buffer.", float64(maxSize))
+
+	connected := 0.0
+	if a.client.State() == "READY" {
+		connected = 1
+	}
+	writeMetric(w, "lnmonja_agent_connected", "gauge",
+		"Whether the connection to the server is ready.", connected)
+	writeMetric(w, "lnmonja_agent_memory_limit_bytes", "gauge",
+		"Soft memory limit of the Go runtime, 0 without one.", float64(utils.MemoryLimit()))
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeMetric(w io.Writer, name, typ, help string, value float64) {
+	writeMetricHeader(w, name, typ, help)
+	fmt.Fprintf(w, "%s %s\n", name, formatValue(value))
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+// unixSeconds returns t in seconds since the epoch, or 0 for the zero time
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/protocol"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// vitals tracks agent health counters reported with each heartbeat
+type vitals struct {
+	droppedBatches  atomic.Uint64
+	droppedMetrics  atomic.Uint64
+	collectorErrors atomic.Uint64
+
+	// Batches and metrics the server accepted, failed sends and the time
+	// of the last accepted batch in Unix nanoseconds
+	sentBatches atomic.Uint64
+	sentMetrics atomic.Uint64
+	sendErrors  atomic.Uint64
+	lastSend    atomic.Int64
+
+	// failing holds collectors whose most recent run failed; runs holds
+	// the run statistics of each collector
+	failing map[string]bool
+	runs    map[string]*collectorRuns
+	mu      sync.Mutex
+}
+
+// collectorRuns are the run statistics of a collector
+type collectorRuns struct {
+	runs         uint64
+	errors       uint64
+	lastDuration time.Duration
+	lastRun      time.Time
+}
+
+func newVitals() *vitals {
+	return &vitals{
+		failing: make(map[string]bool),
+		runs:    make(map[string]*collectorRuns),
+	}
+}
+
+// dropped records a batch of metrics that was discarded
+func (v *vitals) dropped(metrics int) {
+	v.droppedMany(1, metrics)
+}
+
+// droppedMany records several discarded batches
+func (v *vitals) droppedMany(batches, metrics int) {
+	v.droppedBatches.Add(uint64(batches))
+	v.droppedMetrics.Add(uint64(metrics))
+}
+
+// sent records the outcome of sending a batch to the server
+func (v *vitals) sent(metrics int, err error) {
+	if err != nil {
+		v.sendErrors.Add(1)
+		return
+	}
+	v.sentBatches.Add(1)
+	v.sentMetrics.Add(uint64(metrics))
+	v.lastSend.Store(time.Now().UnixNano())
+}
+
+// lastSent returns when the server last accepted a batch, or the zero
+// time if it has not
+func (v *vitals) lastSent() time.Time {
+	if ns := v.lastSend.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// collectorResult records the outcome and duration of a collector run
+func (v *vitals) collectorResult(name string, duration time.Duration, err error) {
+	if err != nil {
+		v.collectorErrors.Add(1)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err != nil {
+		v.failing[name] = true
+	} else {
+		delete(v.failing, name)
+	}
+
+	runs := v.runs[name]
+	if runs == nil {
+		runs = &collectorRuns{}
+		v.runs[name] = runs
+	}
+	runs.runs++
+	if err != nil {
+		runs.errors++
+	}
+	runs.lastDuration = duration
+	runs.lastRun = time.Now()
+}
+
+// runStats returns a copy of the run statistics of each collector
+func (v *vitals) runStats() map[string]collectorRuns {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	runs := make(map[string]collectorRuns, len(v.runs))
+	for name, r := range v.runs {
+		runs[name] = *r
+	}
+	return runs
+}
+
+// failingCollectors returns the collectors whose last run failed, sorted
+func (v *vitals) failingCollectors() []string {
+	v.mu.Lock()
+	names := make([]string, 0, len(v.failing))
+	for name := range v.failing {
+		names = append(names, name)
+	}
+	v.mu.Unlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// snapshot returns current host vitals and agent counters. CPU usage is
+// measured since the previous snapshot.
+func (v *vitals) snapshot() *protocol.NodeVitals {
+	pb := &protocol.NodeVitals{
+		DroppedBatches:  v.droppedBatches.Load(),
+		DroppedMetrics:  v.droppedMetrics.Load(),
+		CollectorErrors: v.collectorErrors.Load(),
+	}
+
+	if percent, err := cpu.Percent(0, false); err == nil && len(percent) > 0 {
+		pb.CpuPercent = percent[0]
+	}
+	if vm, err := mem.VirtualMemory(); err == nil {
+		pb.MemoryPercent = vm.UsedPercent
+	}
+	if avg, err := load.Avg(); err == nil {
+		pb.Load1 = avg.Load1
+	}
+
+	if failing := v.failingCollectors(); len(failing) > 0 {
+		pb.FailingCollectors = failing
+	}
+	return pb
+}
@@ -0,0 +1,289 @@
+// Package kube is a minimal Kubernetes API client for listing and watching
+// the lnmonja custom resources and reading from the kubelet. It only needs
+// the service account of the pod it runs in.
+package kube
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Group and version of the lnmonja custom resources
+const (
+	Group   = "lnmonja.io"
+	Version = "v1alpha1"
+)
+
+// Service account files mounted into every pod
+const (
+	ServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	ServiceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// Config locates an API server or kubelet and how to authenticate to it
+type Config struct {
+	// Host is a URL such as https://10.0.0.1:443; empty means the
+	// in-cluster API server
+	Host      string
+	TokenFile string
+	CAFile    string
+	// Token is used when there is no TokenFile. CAData and the client
+	// certificate are PEM, as read from a kubeconfig.
+	Token    string
+	CAData   []byte
+	CertData []byte
+	KeyData  []byte
+	// InsecureSkipVerify skips certificate checks, for kubelets with
+	// self-signed serving certificates
+	InsecureSkipVerify bool
+}
+
+// InCluster reports whether the process runs in a pod with a service
+// account
+func InCluster() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return false
+	}
+	_, err := os.Stat(ServiceAccountToken)
+	return err == nil
+}
+
+// ErrGone is returned by Watch when the resource version is too old and
+// the caller has to list again
+var ErrGone = errors.New("resource version expired")
+
+// ObjectMeta is the subset of object metadata the controller uses
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	UID             string            `json:"uid,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Generation      int64             `json:"generation,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+}
+
+// Object is a custom resource with an undecoded spec
+type Object struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   ObjectMeta      `json:"metadata"`
+	Spec       json.RawMessage `json:"spec"`
+}
+
+// Key returns namespace/name
+func (o *Object) Key() string {
+	if o.Metadata.Namespace == "" {
+		return o.Metadata.Name
+	}
+	return o.Metadata.Namespace + "/" + o.Metadata.Name
+}
+
+// List is a list response
+type List struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []*Object `json:"items"`
+}
+
+// Event is a watch event. Type is ADDED, MODIFIED, DELETED, BOOKMARK or
+// ERROR.
+type Event struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// status is the body of an API error
+type status struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Client talks to the Kubernetes API server
+type Client struct {
+	host      string
+	tokenFile string
+	token     string
+	http      *http.Client
+}
+
+// NewClient creates a client from the configuration, defaulting to the
+// in-cluster API server
+func NewClient(config Config) (*Client, error) {
+	host := config.Host
+	if host == "" {
+		h, p := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if h == "" || p == "" {
+			return nil, fmt.Errorf("not running in a cluster: KUBERNETES_SERVICE_HOST is not set and no api_server is configured")
+		}
+		host = "https://" + net.JoinHostPort(h, p)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if (config.CAFile != "" || len(config.CAData) > 0) && !config.InsecureSkipVerify {
+		pem, source := config.CAData, "the CA data"
+		if len(pem) == 0 {
+			var err error
+			if pem, err = os.ReadFile(config.CAFile); err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %w", err)
+			}
+			source = config.CAFile
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", source)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.CertData) > 0 {
+		cert, err := tls.X509KeyPair(config.CertData, config.KeyData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &Client{
+		host:      strings.TrimSuffix(host, "/"),
+		tokenFile: config.TokenFile,
+		token:     config.Token,
+		http: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     tlsConfig,
+				TLSHandshakeTimeout: 10 * time.Second,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}, nil
+}
+
+// ResourcePath returns the API path of a custom resource, across all
+// namespaces when namespace is empty
+func ResourcePath(plural, namespace string) string {
+	if namespace == "" {
+		return fmt.Sprintf("/apis/%s/%s/%s", Group, Version, plural)
+	}
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", Group, Version, namespace, plural)
+}
+
+// List lists the objects at path
+func (c *Client) List(ctx context.Context, path string) (*List, error) {
+	var list List
+	if err := c.Get(ctx, path, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Get decodes the JSON response for path into v
+func (c *Client) Get(ctx context.Context, path string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// Watch streams changes to the objects at path after resourceVersion to
+// fn until the server ends the watch, the context is cancelled or fn
+// returns an error. It returns ErrGone when the caller must list again.
+func (c *Client) Watch(ctx context.Context, path, resourceVersion string, timeout time.Duration, fn func(*Event) error) error {
+	query := fmt.Sprintf("?watch=1&allowWatchBookmarks=true&resourceVersion=%s&timeoutSeconds=%d",
+		resourceVersion, int(timeout.Seconds()))
+
+	resp, err := c.get(ctx, path+query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("failed to decode watch event: %w", err)
+		}
+
+		if event.Type == "ERROR" {
+			var st status
+			json.Unmarshal(event.Object, &st)
+			if st.Code == http.StatusGone {
+				return ErrGone
+			}
+			return fmt.Errorf("watch error: %s", st.Message)
+		}
+
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// Projected service account tokens rotate, so read it every time
+	switch {
+	case c.tokenFile != "":
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return nil, ErrGone
+	}
+	var st status
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(body, &st) == nil && st.Message != "" {
+		return nil, fmt.Errorf("GET %s: %s (%d)", path, st.Message, resp.StatusCode)
+	}
+	return nil, fmt.Errorf("GET %s: unexpected status %d", path, resp.StatusCode)
+}
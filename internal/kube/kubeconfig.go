@@ -0,0 +1,133 @@
+package kube
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kubeconfig is the subset of a kubeconfig file the client supports:
+// servers with a CA, and users with a token or a client certificate.
+// Exec and auth provider plugins are not supported.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string    `yaml:"token"`
+			TokenFile             string    `yaml:"tokenFile"`
+			ClientCertificate     string    `yaml:"client-certificate"`
+			ClientCertificateData string    `yaml:"client-certificate-data"`
+			ClientKey             string    `yaml:"client-key"`
+			ClientKeyData         string    `yaml:"client-key-data"`
+			Exec                  yaml.Node `yaml:"exec"`
+			AuthProvider          yaml.Node `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// LoadKubeconfig returns the API server and credentials of a kubeconfig
+// context, the current one if context is empty. Files it names are
+// relative to the kubeconfig's directory.
+func LoadKubeconfig(path, context string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return Config{}, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+
+	if context == "" {
+		context = kc.CurrentContext
+	}
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == context {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+			break
+		}
+	}
+	if !found {
+		return Config{}, fmt.Errorf("context %q not found in %s", context, path)
+	}
+
+	dir := filepath.Dir(path)
+	var config Config
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		config.Host = c.Cluster.Server
+		config.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		if config.CAData, err = fileOrData(dir, c.Cluster.CertificateAuthority, c.Cluster.CertificateAuthorityData); err != nil {
+			return Config{}, fmt.Errorf("cluster %s CA: %w", clusterName, err)
+		}
+		found = true
+		break
+	}
+	if !found || config.Host == "" {
+		return Config{}, fmt.Errorf("cluster %q of context %q has no server", clusterName, context)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if !u.User.Exec.IsZero() || !u.User.AuthProvider.IsZero() {
+			return Config{}, fmt.Errorf("user %s authenticates with a plugin, which is not supported; use a token or client certificate", userName)
+		}
+		config.Token = u.User.Token
+		if u.User.TokenFile != "" {
+			config.TokenFile = resolvePath(dir, u.User.TokenFile)
+		}
+		if config.CertData, err = fileOrData(dir, u.User.ClientCertificate, u.User.ClientCertificateData); err != nil {
+			return Config{}, fmt.Errorf("user %s client certificate: %w", userName, err)
+		}
+		if config.KeyData, err = fileOrData(dir, u.User.ClientKey, u.User.ClientKeyData); err != nil {
+			return Config{}, fmt.Errorf("user %s client key: %w", userName, err)
+		}
+		break
+	}
+	return config, nil
+}
+
+// fileOrData returns base64 data if set, or else the contents of file
+func fileOrData(dir, file, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(resolvePath(dir, file))
+}
+
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
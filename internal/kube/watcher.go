@@ -0,0 +1,135 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Watcher keeps the current set of objects of one resource, listing once
+// and then following changes with a watch
+type Watcher struct {
+	client *Client
+	path   string
+	resync time.Duration
+	logger *zap.Logger
+
+	objects map[string]*Object
+}
+
+// NewWatcher creates a watcher for a custom resource. Every resync
+// interval the resource is listed again, which also repairs a cache that
+// missed events.
+func NewWatcher(client *Client, plural, namespace string, resync time.Duration, logger *zap.Logger) *Watcher {
+	return &Watcher{
+		client:  client,
+		path:    ResourcePath(plural, namespace),
+		resync:  resync,
+		logger:  logger.With(zap.String("resource", plural)),
+		objects: make(map[string]*Object),
+	}
+}
+
+// Run calls fn with every object, sorted by key, after the initial list,
+// after every change and on every resync, until the context is cancelled
+func (w *Watcher) Run(ctx context.Context, fn func([]*Object)) {
+	backoff := time.Second
+
+	for ctx.Err() == nil {
+		list, err := w.client.List(ctx, w.path)
+		if err != nil {
+			w.logger.Warn("Failed to list custom resources",
+				zap.Duration("retry_in", backoff),
+				zap.Error(err),
+			)
+			if !sleep(ctx, backoff) {
+				return
+			}
+			backoff = min(2*backoff, time.Minute)
+			continue
+		}
+		backoff = time.Second
+
+		w.objects = make(map[string]*Object, len(list.Items))
+		for _, obj := range list.Items {
+			w.objects[obj.Key()] = obj
+		}
+		fn(w.snapshot())
+
+		err = w.follow(ctx, list.Metadata.ResourceVersion, fn)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, ErrGone), err == nil:
+			// Relist on expiry and on resync
+		default:
+			w.logger.Warn("Watch failed, listing again", zap.Error(err))
+			if !sleep(ctx, backoff) {
+				return
+			}
+		}
+	}
+}
+
+// follow applies watch events until the resync interval has passed
+func (w *Watcher) follow(ctx context.Context, resourceVersion string, fn func([]*Object)) error {
+	deadline := time.Now().Add(w.resync)
+
+	for time.Now().Before(deadline) {
+		timeout := time.Until(deadline)
+		if timeout < time.Second {
+			return nil
+		}
+
+		err := w.client.Watch(ctx, w.path, resourceVersion, timeout, func(event *Event) error {
+			var obj Object
+			if err := json.Unmarshal(event.Object, &obj); err != nil {
+				return fmt.Errorf("failed to decode %s event: %w", event.Type, err)
+			}
+			resourceVersion = obj.Metadata.ResourceVersion
+
+			switch event.Type {
+			case "ADDED", "MODIFIED":
+				w.objects[obj.Key()] = &obj
+			case "DELETED":
+				delete(w.objects, obj.Key())
+			default:
+				// Bookmarks only move the resource version
+				return nil
+			}
+
+			fn(w.snapshot())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) snapshot() []*Object {
+	objects := make([]*Object, 0, len(w.objects))
+	for _, obj := range w.objects {
+		objects = append(objects, obj)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key() < objects[j].Key() })
+	return objects
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -1,5 +1,14 @@
 package models
 
-// This file is intentionally separate from metric.go
-// It could be used for additional alert-related types in the future
-// Currently, all alert types are in metric.go
+import "time"
+
+// AlertEvent is a persisted alert state transition. Seq increases with
+// every event and serves as the resume cursor of the alert feed.
+type AlertEvent struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// From is empty for a newly raised alert
+	From  string `json:"from,omitempty"`
+	To    string `json:"to"`
+	Alert *Alert `json:"alert"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import "time"
+
+// Annotation marks a point or region in time on charts, such as a deploy,
+// a maintenance window or an alert firing
+type Annotation struct {
+	ID      string            `json:"id"`
+	Time    time.Time         `json:"time"`
+	TimeEnd *time.Time        `json:"time_end,omitempty"`
+	Title   string            `json:"title"`
+	Text    string            `json:"text,omitempty"`
+	Tags    []string          `json:"tags,omitempty"`
+	Source  string            `json:"source"`
+	NodeID  string            `json:"node_id,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	// CreatedBy is the user or component that added the annotation
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Annotation sources
+const (
+	AnnotationSourceManual      = "manual"
+	AnnotationSourceDeploy      = "deploy"
+	AnnotationSourceMaintenance = "maintenance"
+	AnnotationSourceAlert       = "alert"
+)
+
+// AnnotationFilter represents filters for querying annotations
+type AnnotationFilter struct {
+	Start  time.Time
+	End    time.Time
+	Tags   []string
+	Source string
+	NodeID string
+	Limit  int
+}
+
+// Matches reports whether the annotation overlaps the filter's time range
+// and carries all requested tags
+func (f *AnnotationFilter) Matches(a *Annotation) bool {
+	end := a.Time
+	if a.TimeEnd != nil {
+		end = *a.TimeEnd
+	}
+	if !f.Start.IsZero() && end.Before(f.Start) {
+		return false
+	}
+	if !f.End.IsZero() && a.Time.After(f.End) {
+		return false
+	}
+	if f.Source != "" && a.Source != f.Source {
+		return false
+	}
+	if f.NodeID != "" && a.NodeID != f.NodeID {
+		return false
+	}
+
+	for _, want := range f.Tags {
+		found := false
+		for _, tag := range a.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// ConfigReload is the outcome of reloading the server's configuration
+// file: the changed settings applied, and those that only take effect
+// after a restart, as dotted YAML paths
+type ConfigReload struct {
+	Path            string    `json:"path"`
+	ReloadedAt      time.Time `json:"reloaded_at"`
+	Applied         []string  `json:"applied"`
+	RestartRequired []string  `json:"restart_required"`
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // Dashboard represents a monitoring dashboard
 type Dashboard struct {
@@ -12,6 +15,30 @@ type Dashboard struct {
 	Variables   map[string]string `json:"variables"`
 	CreatedAt   time.Time         `json:"created_at"`
 	UpdatedAt   time.Time         `json:"updated_at"`
+	// Version counts the saves of a stored dashboard, starting at 1
+	Version   int    `json:"version,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+}
+
+// DashboardVersion describes a saved version of a dashboard
+type DashboardVersion struct {
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	Name      string    `json:"name"`
+	Panels    int       `json:"panels"`
+}
+
+// DashboardConversion is a dashboard converted from another tool, with
+// what could not be carried over
+type DashboardConversion struct {
+	Dashboard   *Dashboard `json:"dashboard"`
+	Panels      int        `json:"panels"`
+	Variables   int        `json:"variables"`
+	Unsupported []string   `json:"unsupported"`
+	// Imported is set once the dashboard has been stored
+	Imported bool `json:"imported"`
 }
 
 // Panel represents a dashboard panel
@@ -51,4 +78,40 @@ type DashboardFilter struct {
 	Tags      []string
 	CreatedBy string
 	Since     *time.Time
+	// Search matches the name or description, ignoring case
+	Search string
+	Limit  int
+}
+
+// Matches reports whether the dashboard carries all requested tags, was
+// created by the requested user, was updated since the requested time
+// and matches the search
+func (f *DashboardFilter) Matches(d *Dashboard) bool {
+	if f.CreatedBy != "" && d.CreatedBy != f.CreatedBy {
+		return false
+	}
+	if f.Since != nil && d.UpdatedAt.Before(*f.Since) {
+		return false
+	}
+	if f.Search != "" {
+		search := strings.ToLower(f.Search)
+		if !strings.Contains(strings.ToLower(d.Name), search) && !strings.Contains(strings.ToLower(d.Description), search) {
+			return false
+		}
+	}
+
+	for _, want := range f.Tags {
+		found := false
+		for _, tag := range d.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
 }
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DerivedMetric is a named metric calculated from other metrics, e.g.
+// mem_used_ratio = system_memory_used_bytes / system_memory_total_bytes
+type DerivedMetric struct {
+	Name        string    `json:"name"`
+	Expression  string    `json:"expression"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// IngestRate describes ingestion volume for one node, metric name or the
+// whole server over a window
+type IngestRate struct {
+	Name          string  `json:"name,omitempty"`
+	Samples       int64   `json:"samples"`
+	SamplesPerSec float64 `json:"samples_per_sec"`
+	BytesPerSec   float64 `json:"bytes_per_sec"`
+	ActiveSeries  int     `json:"active_series"`
+	// Rejected counts samples refused by metric filters
+	Rejected int64 `json:"rejected,omitempty"`
+}
+
+// IngestStats breaks down recent ingestion by node and metric name
+type IngestStats struct {
+	Window  string        `json:"window"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Total   IngestRate    `json:"total"`
+	Nodes   []*IngestRate `json:"nodes"`
+	Metrics []*IngestRate `json:"metrics"`
+}
+
+// OverloadStatus is the server's ingestion load and the samples it shed by
+// priority since it started. State is normal, elevated, where low
+// priority samples are sampled, or overloaded, where they are dropped and
+// normal priority ones are sampled.
+type OverloadStatus struct {
+	Enabled     bool              `json:"enabled"`
+	State       string            `json:"state"`
+	Since       time.Time         `json:"since"`
+	Inflight    int64             `json:"inflight"`
+	MaxInflight int               `json:"max_inflight"`
+	Shed        map[string]uint64 `json:"shed"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// IngestRule transforms or drops metrics as the server receives them,
+// before they are stored or evaluated by alert rules. Matchers use the
+// syntax of silences and may match the metric name as __name__; a rule
+// without matchers applies to every metric. Rules run by ascending Order,
+// then name, each seeing the result of the previous ones.
+type IngestRule struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Order       int      `json:"order"`
+	Matchers    []string `json:"matchers"`
+	Action      string   `json:"action"`
+	// NewName is the metric name set by rename
+	NewName string `json:"new_name,omitempty"`
+	// Labels are set by add_labels, replacing values reported by agents
+	Labels map[string]string `json:"labels,omitempty"`
+	// Factor multiplies values for scale, which sets the unit to Unit if
+	// it is not empty
+	Factor    float64   `json:"factor,omitempty"`
+	Unit      string    `json:"unit,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Ingest rule actions
+const (
+	IngestActionDrop      = "drop"
+	IngestActionRename    = "rename"
+	IngestActionAddLabels = "add_labels"
+	IngestActionScale     = "scale"
+)
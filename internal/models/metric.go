@@ -1,7 +1,12 @@
 package models
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/version"
 )
 
 type Metric struct {
@@ -66,16 +71,56 @@ type Sample struct {
 	Value     float64   `json:"value"`
 }
 
+// Series identifies a series by its metric name and labels
+type Series struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+}
+
+// String returns the series as a selector, name{label="value",...}, with
+// its labels sorted
+func (s *Series) String() string {
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(s.Name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", k, s.Labels[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
 type Node struct {
 	ID        string            `json:"id"`
 	Hostname  string            `json:"hostname"`
 	OS        string            `json:"os"`
 	Arch      string            `json:"arch"`
 	Version   string            `json:"version"`
+	BuildInfo *version.Info     `json:"build_info,omitempty"`
 	Labels    map[string]string `json:"labels"`
-	Status    NodeStatus        `json:"status"`
-	LastSeen  time.Time         `json:"last_seen"`
-	CreatedAt time.Time         `json:"created_at"`
+	// ServerLabels are managed on the server (team, env, rack) and added
+	// to every series the node reports
+	ServerLabels map[string]string `json:"server_labels,omitempty"`
+	Inventory    map[string]string `json:"inventory,omitempty"`
+	Vitals       *NodeVitals       `json:"vitals,omitempty"`
+	Decommission *Decommission     `json:"decommission,omitempty"`
+	Status       NodeStatus        `json:"status"`
+	LastSeen     time.Time         `json:"last_seen"`
+	CreatedAt    time.Time         `json:"created_at"`
+
+	// Ephemeral nodes belong to an autoscaled instance group
+	Ephemeral     bool       `json:"ephemeral,omitempty"`
+	InstanceGroup string     `json:"instance_group,omitempty"`
+	DepartedAt    *time.Time `json:"departed_at,omitempty"`
 }
 
 type NodeStatus int
@@ -86,6 +131,16 @@ const (
 	NodeStatusDegraded
 	NodeStatusUnhealthy
 	NodeStatusOffline
+	// NodeStatusRetiring nodes are being decommissioned: their alerts are
+	// silenced and missing heartbeats are expected
+	NodeStatusRetiring
+	NodeStatusDecommissioned
+	// NodeStatusDeparted ephemeral nodes scaled in cleanly and are removed
+	// once the ephemeral TTL passes
+	NodeStatusDeparted
+	// NodeStatusStopped nodes shut down their agent cleanly and are not
+	// expected to send heartbeats until they register again
+	NodeStatusStopped
 )
 
 type Alert struct {
@@ -99,6 +154,8 @@ type Alert struct {
 	ActiveAt    time.Time         `json:"active_at"`
 	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`
 	CreatedAt   time.Time         `json:"created_at"`
+	// SilencedBy lists the active silences muting the alert
+	SilencedBy []string `json:"silenced_by,omitempty"`
 }
 
 type AlertState int
@@ -137,6 +194,14 @@ func (s NodeStatus) String() string {
 		return "unhealthy"
 	case NodeStatusOffline:
 		return "offline"
+	case NodeStatusRetiring:
+		return "retiring"
+	case NodeStatusDecommissioned:
+		return "decommissioned"
+	case NodeStatusDeparted:
+		return "departed"
+	case NodeStatusStopped:
+		return "stopped"
 	default:
 		return "unknown"
 	}
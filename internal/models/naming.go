@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// NamingViolation is a metric naming convention broken by received metrics
+type NamingViolation struct {
+	Metric    string    `json:"metric"`
+	Rule      string    `json:"rule"`
+	Message   string    `json:"message"`
+	Nodes     []string  `json:"nodes"`
+	Samples   int64     `json:"samples"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// NamingReport lists the naming conventions broken since tracking started,
+// with the number of violations of each rule
+type NamingReport struct {
+	TrackingSince time.Time          `json:"tracking_since"`
+	Rules         map[string]int     `json:"rules"`
+	Violations    []*NamingViolation `json:"violations"`
+}
@@ -1,5 +1,123 @@
 package models
 
+import "time"
+
 // This file is intentionally separate from metric.go
 // Node type is defined in metric.go
 // This file could be used for additional node-related types in the future
+
+// Well-known node inventory keys reported by agent collectors
+const (
+	InventoryPackageManager         = "packages.manager"
+	InventoryPackagesPending        = "packages.pending"
+	InventoryPackagesSecurity       = "packages.security_pending"
+	InventoryPackagesCheckedAt      = "packages.checked_at"
+	InventoryPackagesPendingList    = "packages.pending_list"
+	InventoryPackagesSecurityList   = "packages.security_list"
+	InventoryKernelRunning          = "kernel.running"
+	InventoryKernelInstalled        = "kernel.installed"
+	InventoryKernelUpdatePending    = "kernel.update_pending"
+	InventoryRebootRequired         = "reboot.required"
+	InventoryRebootRequiredSince    = "reboot.required_since"
+	InventoryRebootRequiredPackages = "reboot.required_packages"
+)
+
+// InventoryCollectorEnabled is the inventory key reporting whether a
+// collector is running on the agent
+func InventoryCollectorEnabled(name string) string {
+	return "collector." + name + ".enabled"
+}
+
+// InventoryCollectorInterval is the inventory key reporting a collector's
+// current interval
+func InventoryCollectorInterval(name string) string {
+	return "collector." + name + ".interval"
+}
+
+// InventoryCustomScriptError is the inventory key holding why a custom
+// collector script last failed; it is absent while the script succeeds
+func InventoryCustomScriptError(script string) string {
+	return "custom." + script + ".error"
+}
+
+// InventoryScrapeTargetError is the inventory key holding why a scrape
+// target last failed; it is absent while the target is scraped
+func InventoryScrapeTargetError(target string) string {
+	return "scrape." + target + ".error"
+}
+
+// CollectorChange enables, disables or reschedules a collector on an
+// agent. Nil Enabled and zero Interval leave the setting unchanged.
+type CollectorChange struct {
+	Name     string
+	Enabled  *bool
+	Interval time.Duration
+}
+
+// CollectorState is the runtime schedule of a collector acknowledged by
+// an agent
+type CollectorState struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Interval string `json:"interval"`
+}
+
+// NodeVitals is the health summary an agent sends with each heartbeat, so
+// the server has fresh vitals even when the metric stream lags. Counters are
+// cumulative since the agent started.
+type NodeVitals struct {
+	CPUPercent        float64   `json:"cpu_percent"`
+	MemoryPercent     float64   `json:"memory_percent"`
+	Load1             float64   `json:"load1"`
+	DroppedBatches    uint64    `json:"dropped_batches"`
+	DroppedMetrics    uint64    `json:"dropped_metrics"`
+	CollectorErrors   uint64    `json:"collector_errors"`
+	FailingCollectors []string  `json:"failing_collectors,omitempty"`
+	ReportedAt        time.Time `json:"reported_at"`
+}
+
+// DecommissionAction is what happens to a node's series once its
+// decommission grace period ends
+type DecommissionAction string
+
+const (
+	DecommissionKeep    DecommissionAction = "keep"
+	DecommissionArchive DecommissionAction = "archive"
+	DecommissionPurge   DecommissionAction = "purge"
+)
+
+// Decommission records a node being retired
+type Decommission struct {
+	Reason      string             `json:"reason,omitempty"`
+	Action      DecommissionAction `json:"action"`
+	RequestedBy string             `json:"requested_by,omitempty"`
+	RequestedAt time.Time          `json:"requested_at"`
+	// CompleteAt is when the grace period ends and the action runs
+	CompleteAt  time.Time  `json:"complete_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Series samples removed, and the archive file they were written to
+	DeletedSamples int64  `json:"deleted_samples,omitempty"`
+	ArchivePath    string `json:"archive_path,omitempty"`
+}
+
+// Node lifecycle event types sent to webhooks
+const (
+	NodeEventRegistered     = "node.registered"
+	NodeEventUnhealthy      = "node.unhealthy"
+	NodeEventOffline        = "node.offline"
+	NodeEventRecovered      = "node.recovered"
+	NodeEventRetiring       = "node.retiring"
+	NodeEventReactivated    = "node.reactivated"
+	NodeEventDecommissioned = "node.decommissioned"
+	NodeEventDeparted       = "node.departed"
+	NodeEventRemoved        = "node.removed"
+	NodeEventStopped        = "node.stopped"
+)
+
+// NodeEvent is a node lifecycle change delivered to webhooks
+type NodeEvent struct {
+	Type   string    `json:"type"`
+	NodeID string    `json:"node_id"`
+	Time   time.Time `json:"time"`
+	Node   *Node     `json:"node,omitempty"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Silence mutes notifications for alerts whose labels satisfy all of its
+// matchers between StartsAt and EndsAt. Matchers use the syntax of the
+// notification routes, e.g. alertname="HighCPU" or node=~"web-.*".
+type Silence struct {
+	ID        string    `json:"id"`
+	Matchers  []string  `json:"matchers"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	CreatedBy string    `json:"created_by"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Status is derived from the time range when the silence is read
+	Status string `json:"status"`
+}
+
+// Silence statuses
+const (
+	SilenceStatusPending = "pending"
+	SilenceStatusActive  = "active"
+	SilenceStatusExpired = "expired"
+)
+
+// StatusAt returns the status of the silence at t
+func (s *Silence) StatusAt(t time.Time) string {
+	switch {
+	case !t.Before(s.EndsAt):
+		return SilenceStatusExpired
+	case t.Before(s.StartsAt):
+		return SilenceStatusPending
+	}
+	return SilenceStatusActive
+}
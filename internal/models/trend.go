@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// NodeMonth is a node's resource usage and alerts over a calendar month,
+// accumulated as its metrics are received and its alerts fire, so trends
+// outlive the retention of the samples
+type NodeMonth struct {
+	NodeID string `json:"node_id"`
+	// Month is formatted 2006-01, in UTC
+	Month      string  `json:"month"`
+	CPUSum     float64 `json:"cpu_sum"`
+	CPUSamples int64   `json:"cpu_samples"`
+	CPUMax     float64 `json:"cpu_max"`
+	// DiskFirst and DiskLast are the node's used disk bytes when first and
+	// last measured in the month
+	DiskFirst   float64   `json:"disk_first"`
+	DiskFirstAt time.Time `json:"disk_first_at"`
+	DiskLast    float64   `json:"disk_last"`
+	DiskLastAt  time.Time `json:"disk_last_at"`
+	// Alerts counts the alerts that started firing
+	Alerts    int64     `json:"alerts"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TrendMonth is the aggregates of a node or group over a month
+type TrendMonth struct {
+	Month string `json:"month"`
+	// Nodes reported metrics or alerts in the month
+	Nodes           int     `json:"nodes"`
+	CPUAvg          float64 `json:"cpu_avg"`
+	CPUMax          float64 `json:"cpu_max"`
+	DiskUsedBytes   float64 `json:"disk_used_bytes"`
+	DiskGrowthBytes float64 `json:"disk_growth_bytes"`
+	Alerts          int64   `json:"alerts"`
+	// Change is the difference from the previous month, absent if it has
+	// no data
+	Change *TrendChange `json:"change,omitempty"`
+}
+
+// TrendChange is the month-over-month difference of a node's or group's
+// aggregates. AlertsPercent is absent when the previous month had none.
+type TrendChange struct {
+	CPUAvg          float64  `json:"cpu_avg"`
+	CPUMax          float64  `json:"cpu_max"`
+	DiskUsedBytes   float64  `json:"disk_used_bytes"`
+	DiskGrowthBytes float64  `json:"disk_growth_bytes"`
+	Alerts          int64    `json:"alerts"`
+	AlertsPercent   *float64 `json:"alerts_percent,omitempty"`
+}
+
+// TrendSeries is the months of one node or group
+type TrendSeries struct {
+	// Key is the node ID, or the value of the group label
+	Key    string        `json:"key"`
+	Months []*TrendMonth `json:"months"`
+}
+
+// TrendReport is the monthly aggregates of nodes or groups over a range
+// of months
+type TrendReport struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// GroupBy is "node", or the node label the series are grouped by
+	GroupBy string         `json:"group_by"`
+	Series  []*TrendSeries `json:"series"`
+}
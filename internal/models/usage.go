@@ -0,0 +1,116 @@
+package models
+
+import "time"
+
+// SeriesInfo summarizes a stored series
+type SeriesInfo struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	Samples   int64             `json:"samples"`
+	Bytes     int64             `json:"bytes"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
+}
+
+// MetricUsage describes how often a metric name is queried or referenced
+type MetricUsage struct {
+	Name          string     `json:"name"`
+	Series        int        `json:"series"`
+	UsedSeries    int        `json:"used_series"`
+	Queries       int64      `json:"queries"`
+	LastQueriedAt *time.Time `json:"last_queried_at,omitempty"`
+	Sources       []string   `json:"sources,omitempty"`
+}
+
+// UsageReport lists metric usage since tracking started
+type UsageReport struct {
+	TrackingSince time.Time      `json:"tracking_since"`
+	Metrics       []*MetricUsage `json:"metrics"`
+}
+
+// UnusedSeriesReport lists stored series that no query, rule or export uses
+type UnusedSeriesReport struct {
+	TrackingSince time.Time     `json:"tracking_since"`
+	TotalSeries   int           `json:"total_series"`
+	UnusedSeries  int           `json:"unused_series"`
+	UnusedSamples int64         `json:"unused_samples"`
+	UnusedBytes   int64         `json:"unused_bytes"`
+	Series        []*SeriesInfo `json:"series"`
+}
+
+// StorageUsageEntry is the estimated storage used by one metric name or node
+type StorageUsageEntry struct {
+	Name               string  `json:"name"`
+	Samples            int64   `json:"samples"`
+	Bytes              int64   `json:"bytes"`
+	EstimatedDiskBytes int64   `json:"estimated_disk_bytes"`
+	Percent            float64 `json:"percent"`
+}
+
+// StorageUsage attributes TSDB disk usage to metric names and nodes
+type StorageUsage struct {
+	GeneratedAt   time.Time            `json:"generated_at"`
+	Duration      string               `json:"duration"`
+	DiskBytes     int64                `json:"disk_bytes"`
+	LSMBytes      int64                `json:"lsm_bytes"`
+	ValueLogBytes int64                `json:"value_log_bytes"`
+	Samples       int64                `json:"samples"`
+	Bytes         int64                `json:"bytes"`
+	SampleRate    int                  `json:"sample_rate"`
+	Metrics       []*StorageUsageEntry `json:"metrics"`
+	Nodes         []*StorageUsageEntry `json:"nodes"`
+}
+
+// MigrationStatus reports the progress of a storage migration: the new
+// store receives every write since Started and holds the samples written
+// from BackfilledFrom. Queries are compared over the time it covers.
+type MigrationStatus struct {
+	Enabled        bool      `json:"enabled"`
+	Path           string    `json:"path,omitempty"`
+	Started        time.Time `json:"started"`
+	BackfilledFrom time.Time `json:"backfilled_from"`
+	BackfillDone   bool      `json:"backfill_done"`
+	// CoveredFrom is the oldest time queries are compared from
+	CoveredFrom       time.Time          `json:"covered_from"`
+	BackfilledSamples int64              `json:"backfilled_samples"`
+	Writes            uint64             `json:"writes"`
+	WriteErrors       uint64             `json:"write_errors"`
+	Comparisons       uint64             `json:"comparisons"`
+	Mismatches        uint64             `json:"mismatches"`
+	Skipped           uint64             `json:"skipped"`
+	LastMismatch      *MigrationMismatch `json:"last_mismatch,omitempty"`
+}
+
+// MigrationMismatch is a query whose results differed between the stores
+type MigrationMismatch struct {
+	Query  string    `json:"query"`
+	At     time.Time `json:"at"`
+	Detail string    `json:"detail"`
+}
+
+// TenantUsage is a tenant's metered usage on a UTC day
+type TenantUsage struct {
+	Tenant string `json:"tenant"`
+	// Day is the date, as 2006-01-02; empty in a report's totals
+	Day             string `json:"day,omitempty"`
+	IngestedSamples int64  `json:"ingested_samples"`
+	IngestedBytes   int64  `json:"ingested_bytes"`
+	// StoredBytes and StoredSeries are the largest measured on the day,
+	// or over the report's days in its totals
+	StoredBytes  int64 `json:"stored_bytes"`
+	StoredSeries int64 `json:"stored_series"`
+	// QuerySamples counts the samples returned to API queries
+	QuerySamples int64     `json:"query_samples"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// TenantUsageReport is the metered usage of tenants over a range of days
+type TenantUsageReport struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	TenantLabel string `json:"tenant_label"`
+	// Days holds a record per tenant and day, ordered by day and tenant
+	Days []*TenantUsage `json:"days"`
+	// Totals holds a record per tenant over the range
+	Totals []*TenantUsage `json:"totals"`
+}
@@ -0,0 +1,579 @@
+// Package query implements the arithmetic expression language used by
+// derived metrics, e.g. `system_memory_used_bytes / system_memory_total_bytes`.
+package query
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// Expr is a parsed arithmetic expression over metric selectors
+type Expr interface {
+	String() string
+}
+
+// NumberExpr is a numeric literal
+type NumberExpr struct {
+	Value float64
+}
+
+// SelectorExpr selects series by metric name and exact label matches
+type SelectorExpr struct {
+	Name   string
+	Labels map[string]string
+	// Raw is the selector as written, suitable for the storage query parser
+	Raw string
+}
+
+// BinaryExpr applies +, -, * or / to two operands
+type BinaryExpr struct {
+	Op  byte
+	LHS Expr
+	RHS Expr
+}
+
+func (e *NumberExpr) String() string   { return strconv.FormatFloat(e.Value, 'g', -1, 64) }
+func (e *SelectorExpr) String() string { return e.Raw }
+func (e *BinaryExpr) String() string {
+	return fmt.Sprintf("(%s %c %s)", e.LHS, e.Op, e.RHS)
+}
+
+// Parse parses an expression
+func Parse(input string) (Expr, error) {
+	p := &parser{input: input}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+
+	return expr, nil
+}
+
+// Selectors returns all selectors referenced by the expression
+func Selectors(expr Expr) []*SelectorExpr {
+	switch e := expr.(type) {
+	case *SelectorExpr:
+		return []*SelectorExpr{e}
+	case *BinaryExpr:
+		return append(Selectors(e.LHS), Selectors(e.RHS)...)
+	default:
+		return nil
+	}
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t' || p.input[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// parseExpr handles + and -
+func (p *parser) parseExpr() (Expr, error) {
+	lhs, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return lhs, nil
+		}
+		p.pos++
+
+		rhs, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+}
+
+// parseTerm handles * and /
+func (p *parser) parseTerm() (Expr, error) {
+	lhs, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return lhs, nil
+		}
+		p.pos++
+
+		rhs, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+}
+
+func (p *parser) parseFactor() (Expr, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing closing parenthesis at position %d", p.pos)
+		}
+		p.pos++
+		return expr, nil
+	case c == '-':
+		p.pos++
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Op: '*', LHS: &NumberExpr{Value: -1}, RHS: operand}, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case isNameStart(c):
+		return p.parseSelector()
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos)
+	}
+}
+
+func (p *parser) parseNumber() (Expr, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] == '.' || p.input[p.pos] == 'e' ||
+		(p.input[p.pos] >= '0' && p.input[p.pos] <= '9')) {
+		p.pos++
+	}
+
+	v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", p.input[start:p.pos])
+	}
+	return &NumberExpr{Value: v}, nil
+}
+
+func (p *parser) parseSelector() (Expr, error) {
+	start := p.pos
+	for p.pos < len(p.input) && isNameChar(p.input[p.pos]) {
+		p.pos++
+	}
+
+	sel := &SelectorExpr{
+		Name:   p.input[start:p.pos],
+		Labels: make(map[string]string),
+	}
+
+	if p.pos < len(p.input) && p.input[p.pos] == '{' {
+		end := strings.IndexByte(p.input[p.pos:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated label selector at position %d", p.pos)
+		}
+
+		for _, pair := range strings.Split(p.input[p.pos+1:p.pos+end], ",") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid label matcher %q", pair)
+			}
+			sel.Labels[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), "\"")
+		}
+		p.pos += end + 1
+	}
+
+	sel.Raw = p.input[start:p.pos]
+	return sel, nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// FetchFunc returns the series for a selector
+type FetchFunc func(sel *SelectorExpr) ([]*models.TimeSeries, error)
+
+// value is either a scalar or a set of series
+type value struct {
+	scalar *float64
+	series []*models.TimeSeries
+}
+
+// Eval evaluates the expression over range data. Series on both sides of an
+// operator are matched by identical label sets; a side with a single series
+// is applied to every series on the other side. Samples are joined on equal
+// timestamps and non-finite results (e.g. division by zero) are dropped.
+func Eval(expr Expr, fetch FetchFunc) ([]*models.TimeSeries, error) {
+	v, err := eval(expr, fetch, nil)
+	if err != nil {
+		return nil, err
+	}
+	if v.scalar != nil {
+		return nil, fmt.Errorf("expression does not reference any metric")
+	}
+	return v.series, nil
+}
+
+// Step records the evaluation of one part of an expression
+type Step struct {
+	Expr string `json:"expr"`
+	// Kind is number, selector or binary
+	Kind string `json:"kind"`
+	Op   string `json:"op,omitempty"`
+	// Matching is how the operands of a binary step were joined: scalar,
+	// one-to-one, one-to-many or labels
+	Matching string `json:"matching,omitempty"`
+	// Scalar is the value of a step without metrics
+	Scalar  *float64 `json:"scalar,omitempty"`
+	Series  int      `json:"series"`
+	Samples int      `json:"samples"`
+	// Unmatched counts operand series without a series of the same labels
+	// on the other side. Dropped counts operand samples without a sample
+	// at the same time on the other side, or with a non-finite result.
+	Unmatched int                  `json:"unmatched,omitempty"`
+	Dropped   int                  `json:"dropped,omitempty"`
+	Result    []*models.TimeSeries `json:"result,omitempty"`
+}
+
+// Trace evaluates the expression like Eval, or like Scalar when it
+// references no metric, and returns a step for each part of it, operands
+// before their operator
+func Trace(expr Expr, fetch FetchFunc) (series []*models.TimeSeries, scalar *float64, steps []*Step, err error) {
+	v, err := eval(expr, fetch, &steps)
+	if err != nil {
+		return nil, nil, steps, err
+	}
+	return v.series, v.scalar, steps, nil
+}
+
+// Scalar evaluates an expression that references no metric, e.g. `1 + 1`.
+// It reports false if the expression references one.
+func Scalar(expr Expr) (float64, bool) {
+	if len(Selectors(expr)) > 0 {
+		return 0, false
+	}
+	v, err := eval(expr, nil, nil)
+	if err != nil || v.scalar == nil {
+		return 0, false
+	}
+	return *v.scalar, true
+}
+
+// EvalInstant evaluates the expression against a single batch of metrics,
+// as received from one agent, and returns the results as metrics named name
+func EvalInstant(expr Expr, name string, metrics []*models.Metric) ([]*models.Metric, error) {
+	var (
+		nodeID string
+		latest time.Time
+	)
+	for _, m := range metrics {
+		if m.Timestamp.After(latest) {
+			latest = m.Timestamp
+		}
+		nodeID = m.NodeID
+	}
+
+	// Collapse the batch onto a single timestamp so samples line up
+	fetch := func(sel *SelectorExpr) ([]*models.TimeSeries, error) {
+		var series []*models.TimeSeries
+		for _, m := range metrics {
+			if m.Name != sel.Name || !labelsMatch(m.Labels, sel.Labels) {
+				continue
+			}
+			series = append(series, &models.TimeSeries{
+				Labels:  m.Labels,
+				Samples: []models.Sample{{Timestamp: latest, Value: m.Value}},
+			})
+		}
+		return series, nil
+	}
+
+	series, err := Eval(expr, fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.Metric
+	for _, s := range series {
+		for _, sample := range s.Samples {
+			result = append(result, &models.Metric{
+				NodeID:    nodeID,
+				Name:      name,
+				Value:     sample.Value,
+				Timestamp: sample.Timestamp,
+				Labels:    s.Labels,
+				Type:      models.MetricTypeGauge,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// eval evaluates expr, appending a step for each part of it to steps
+// unless steps is nil
+func eval(expr Expr, fetch FetchFunc, steps *[]*Step) (*value, error) {
+	switch e := expr.(type) {
+	case *NumberExpr:
+		v := e.Value
+		result := &value{scalar: &v}
+		record(steps, &Step{Expr: e.String(), Kind: "number"}, result)
+		return result, nil
+	case *SelectorExpr:
+		series, err := fetch(e)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", e.Raw, err)
+		}
+		result := &value{series: series}
+		record(steps, &Step{Expr: e.String(), Kind: "selector"}, result)
+		return result, nil
+	case *BinaryExpr:
+		lhs, err := eval(e.LHS, fetch, steps)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := eval(e.RHS, fetch, steps)
+		if err != nil {
+			return nil, err
+		}
+		result := binaryOp(e.Op, lhs, rhs)
+		if steps != nil {
+			step := &Step{Expr: e.String(), Kind: "binary", Op: string(e.Op), Matching: joinKind(lhs, rhs)}
+			step.Unmatched, step.Dropped = joinLosses(step.Matching, lhs, rhs, result)
+			record(steps, step, result)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", expr)
+	}
+}
+
+// record completes a step with its result and appends it to steps
+func record(steps *[]*Step, step *Step, v *value) {
+	if steps == nil {
+		return
+	}
+	step.Scalar = v.scalar
+	step.Series = len(v.series)
+	step.Samples = countSamples(v.series)
+	step.Result = v.series
+	*steps = append(*steps, step)
+}
+
+// joinKind returns how binaryOp joins its operands
+func joinKind(lhs, rhs *value) string {
+	switch {
+	case lhs.scalar != nil || rhs.scalar != nil:
+		return "scalar"
+	case len(rhs.series) == 1 && len(lhs.series) > 1 && findSeries(lhs.series, rhs.series[0].Labels) == nil,
+		len(lhs.series) == 1 && len(rhs.series) > 1 && findSeries(rhs.series, lhs.series[0].Labels) == nil:
+		return "one-to-many"
+	case len(lhs.series) == 1 && len(rhs.series) == 1:
+		return "one-to-one"
+	default:
+		return "labels"
+	}
+}
+
+// joinLosses counts the operand series and samples a join left out of
+// its result
+func joinLosses(kind string, lhs, rhs, result *value) (unmatched, dropped int) {
+	switch kind {
+	case "scalar":
+		if lhs.scalar == nil {
+			return 0, countSamples(lhs.series) - countSamples(result.series)
+		}
+		return 0, countSamples(rhs.series) - countSamples(result.series)
+	case "one-to-many":
+		many := lhs.series
+		if len(lhs.series) == 1 {
+			many = rhs.series
+		}
+		return 0, countSamples(many) - countSamples(result.series)
+	case "one-to-one":
+		return 0, countSamples(lhs.series) - countSamples(result.series)
+	}
+
+	matched := 0
+	for _, l := range lhs.series {
+		if r := findSeries(rhs.series, l.Labels); r != nil {
+			matched += len(l.Samples)
+		} else {
+			unmatched++
+		}
+	}
+	for _, r := range rhs.series {
+		if findSeries(lhs.series, r.Labels) == nil {
+			unmatched++
+		}
+	}
+	return unmatched, matched - countSamples(result.series)
+}
+
+func countSamples(series []*models.TimeSeries) int {
+	n := 0
+	for _, s := range series {
+		n += len(s.Samples)
+	}
+	return n
+}
+
+func binaryOp(op byte, lhs, rhs *value) *value {
+	switch {
+	case lhs.scalar != nil && rhs.scalar != nil:
+		v := apply(op, *lhs.scalar, *rhs.scalar)
+		return &value{scalar: &v}
+	case rhs.scalar != nil:
+		return &value{series: mapSeries(lhs.series, func(v float64) float64 { return apply(op, v, *rhs.scalar) })}
+	case lhs.scalar != nil:
+		return &value{series: mapSeries(rhs.series, func(v float64) float64 { return apply(op, *lhs.scalar, v) })}
+	}
+
+	switch joinKind(lhs, rhs) {
+	case "one-to-many":
+		// Broadcast a single series against many
+		if len(rhs.series) == 1 {
+			return &value{series: joinEach(op, lhs.series, rhs.series[0], false)}
+		}
+		return &value{series: joinEach(op, rhs.series, lhs.series[0], true)}
+	case "one-to-one":
+		return &value{series: joinEach(op, lhs.series, rhs.series[0], false)}
+	}
+
+	var result []*models.TimeSeries
+	for _, l := range lhs.series {
+		r := findSeries(rhs.series, l.Labels)
+		if r == nil {
+			continue
+		}
+		if s := joinSamples(op, l, r, l.Labels); len(s.Samples) > 0 {
+			result = append(result, s)
+		}
+	}
+	return &value{series: result}
+}
+
+// joinEach combines every series in many with one; swapped puts one on the left
+func joinEach(op byte, many []*models.TimeSeries, one *models.TimeSeries, swapped bool) []*models.TimeSeries {
+	var result []*models.TimeSeries
+	for _, s := range many {
+		var joined *models.TimeSeries
+		if swapped {
+			joined = joinSamples(op, one, s, s.Labels)
+		} else {
+			joined = joinSamples(op, s, one, s.Labels)
+		}
+		if len(joined.Samples) > 0 {
+			result = append(result, joined)
+		}
+	}
+	return result
+}
+
+func joinSamples(op byte, lhs, rhs *models.TimeSeries, labels map[string]string) *models.TimeSeries {
+	rhsValues := make(map[int64]float64, len(rhs.Samples))
+	for _, s := range rhs.Samples {
+		rhsValues[s.Timestamp.UnixNano()] = s.Value
+	}
+
+	out := &models.TimeSeries{Labels: labels}
+	for _, s := range lhs.Samples {
+		r, ok := rhsValues[s.Timestamp.UnixNano()]
+		if !ok {
+			continue
+		}
+		if v := apply(op, s.Value, r); isFinite(v) {
+			out.Samples = append(out.Samples, models.Sample{Timestamp: s.Timestamp, Value: v})
+		}
+	}
+
+	sort.Slice(out.Samples, func(i, j int) bool {
+		return out.Samples[i].Timestamp.Before(out.Samples[j].Timestamp)
+	})
+	return out
+}
+
+func mapSeries(series []*models.TimeSeries, fn func(float64) float64) []*models.TimeSeries {
+	result := make([]*models.TimeSeries, 0, len(series))
+	for _, s := range series {
+		out := &models.TimeSeries{Labels: s.Labels}
+		for _, sample := range s.Samples {
+			if v := fn(sample.Value); isFinite(v) {
+				out.Samples = append(out.Samples, models.Sample{Timestamp: sample.Timestamp, Value: v})
+			}
+		}
+		result = append(result, out)
+	}
+	return result
+}
+
+func findSeries(series []*models.TimeSeries, labels map[string]string) *models.TimeSeries {
+	for _, s := range series {
+		if len(s.Labels) == len(labels) && labelsMatch(s.Labels, labels) {
+			return s
+		}
+	}
+	return nil
+}
+
+// labelsMatch reports whether labels contains every matcher
+func labelsMatch(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func apply(op byte, a, b float64) float64 {
+	switch op {
+	case '+':
+		return a + b
+	case '-':
+		return a - b
+	case '*':
+		return a * b
+	case '/':
+		return a / b
+	}
+	return math.NaN()
+}
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
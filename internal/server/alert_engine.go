@@ -7,6 +7,7 @@ import (
 
 	"github.com/meettoy2004/lnmonja/internal/models"
 	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
 	"github.com/meettoy2004/lnmonja/pkg/utils"
 	"go.uber.org/zap"
 )
@@ -20,6 +21,36 @@ type AlertManager struct {
 	rulesMu      sync.RWMutex
 	activeAlerts map[string]*models.Alert
 	alertsMu     sync.RWMutex
+	derived      *DerivedMetrics
+	// nodes is used to skip retiring nodes, whose alerts are silenced
+	nodes *NodeManager
+	// annotations holds the open annotation of each firing alert, keyed
+	// like activeAlerts, so it can be closed when the alert resolves
+	annotations map[string]*models.Annotation
+	// feed records every state transition for the alert feed
+	feed *AlertFeed
+	// dispatcher routes notifications to receivers
+	dispatcher *Dispatcher
+	// silences mutes notifications of matching alerts
+	silences *Silencer
+	// metrics counts rule evaluations
+	metrics *telemetry.Metrics
+	// live receives alert state changes for live clients
+	live LivePublisher
+	// trends counts the alerts that start firing per node, if trends are
+	// enabled
+	trends *Trends
+	// scheduler evaluates the rules on their interval when set; otherwise
+	// they are evaluated against every batch received
+	scheduler *RuleScheduler
+
+	// fileRules names the rules loaded from the rule files at rulesPath,
+	// or the default rules without them, which a reload replaces.
+	// rulesSignature identifies the files last loaded.
+	rulesPath      string
+	fileRules      map[string]bool
+	rulesSignature string
+	reloadMu       sync.Mutex
 }
 
 // AlertRule represents an alert rule
@@ -34,6 +65,12 @@ type AlertRule struct {
 	Threshold   float64
 	Operator    string // >, <, >=, <=, ==, !=
 	MetricName  string
+	// Matchers select the series of the metric the rule applies to
+	Matchers []*utils.Matcher
+	// Group is the rule group the rule was loaded from. Interval is the
+	// group's evaluation interval, or 0 for alerting.evaluation_interval.
+	Group    string
+	Interval time.Duration
 }
 
 // NewAlertManager creates a new alert manager
@@ -44,10 +81,17 @@ func NewAlertManager(config *utils.Config, store storage.Storage, logger *zap.Lo
 		logger:       logger,
 		rules:        make(map[string]*AlertRule),
 		activeAlerts: make(map[string]*models.Alert),
+		annotations:  make(map[string]*models.Annotation),
+		feed:         NewAlertFeed(store, logger),
+		rulesPath:    config.Alerting.RulesPath,
 	}
 
-	// Load default alert rules
-	am.loadDefaultRules()
+	// Rule files replace the default rules
+	if am.rulesPath == "" {
+		am.loadDefaultRules()
+	} else if err := am.ReloadRules(); err != nil {
+		logger.Error("Failed to load alert rules", zap.Error(err))
+	}
 
 	return am
 }
@@ -106,23 +150,191 @@ func (am *AlertManager) loadDefaultRules() {
 			Operator:   ">",
 			MetricName: "system_disk_usage_percent",
 		},
+		{
+			Name:       "RebootPendingTooLong",
+			Expression: "system_reboot_required_seconds > 604800",
+			Labels: map[string]string{
+				"severity": "warning",
+				"category": "compliance",
+			},
+			Annotations: map[string]string{
+				"summary":     "Reboot pending for more than 7 days",
+				"description": "Node has been waiting for a reboot for over 7 days",
+			},
+			Enabled:    true,
+			Threshold:  604800,
+			Operator:   ">",
+			MetricName: "system_reboot_required_seconds",
+		},
+		{
+			Name:       "FileDescriptorsNearLimit",
+			Expression: "system_fd_usage_percent > 90",
+			For:        5 * time.Minute,
+			Labels: map[string]string{
+				"severity": "critical",
+				"category": "limits",
+			},
+			Annotations: map[string]string{
+				"summary":     "File descriptors near exhaustion",
+				"description": "Open file descriptors are above 90% of fs.file-max",
+			},
+			Enabled:    true,
+			Threshold:  90.0,
+			Operator:   ">",
+			MetricName: "system_fd_usage_percent",
+		},
+		{
+			Name:       "ProcessFileDescriptorsNearLimit",
+			Expression: "system_process_fd_usage_percent > 90",
+			For:        5 * time.Minute,
+			Labels: map[string]string{
+				"severity": "warning",
+				"category": "limits",
+			},
+			Annotations: map[string]string{
+				"summary":     "Process near its open file limit",
+				"description": "A process is using more than 90% of its RLIMIT_NOFILE",
+			},
+			Enabled:    true,
+			Threshold:  90.0,
+			Operator:   ">",
+			MetricName: "system_process_fd_usage_percent",
+		},
+		{
+			Name:       "PIDsNearLimit",
+			Expression: "system_pid_usage_percent > 90",
+			For:        5 * time.Minute,
+			Labels: map[string]string{
+				"severity": "critical",
+				"category": "limits",
+			},
+			Annotations: map[string]string{
+				"summary":     "PID space near exhaustion",
+				"description": "Tasks are above 90% of kernel.pid_max",
+			},
+			Enabled:    true,
+			Threshold:  90.0,
+			Operator:   ">",
+			MetricName: "system_pid_usage_percent",
+		},
+		{
+			Name:       "ConntrackTableNearLimit",
+			Expression: "system_conntrack_usage_percent > 90",
+			For:        5 * time.Minute,
+			Labels: map[string]string{
+				"severity": "critical",
+				"category": "limits",
+			},
+			Annotations: map[string]string{
+				"summary":     "Conntrack table near exhaustion",
+				"description": "Connection tracking entries are above 90% of nf_conntrack_max",
+			},
+			Enabled:    true,
+			Threshold:  90.0,
+			Operator:   ">",
+			MetricName: "system_conntrack_usage_percent",
+		},
+		{
+			Name:       "LowEntropy",
+			Expression: "system_entropy_available_bits < 200",
+			For:        10 * time.Minute,
+			Labels: map[string]string{
+				"severity": "warning",
+				"category": "limits",
+			},
+			Annotations: map[string]string{
+				"summary":     "Low available entropy",
+				"description": "Kernel random pool has less than 200 bits available",
+			},
+			Enabled:    true,
+			Threshold:  200,
+			Operator:   "<",
+			MetricName: "system_entropy_available_bits",
+		},
+		{
+			Name:       "RabbitMQDown",
+			Expression: "rabbitmq_up < 1",
+			For:        time.Minute,
+			Labels: map[string]string{
+				"severity": "critical",
+				"category": "messaging",
+			},
+			Annotations: map[string]string{
+				"summary":     "RabbitMQ management API unreachable",
+				"description": "The RabbitMQ management API could not be read for over a minute",
+			},
+			Enabled:    true,
+			Threshold:  1,
+			Operator:   "<",
+			MetricName: "rabbitmq_up",
+		},
+		{
+			Name:       "RabbitMQQueueGrowing",
+			Expression: "rabbitmq_queues_growing > 0",
+			For:        5 * time.Minute,
+			Labels: map[string]string{
+				"severity": "warning",
+				"category": "messaging",
+			},
+			Annotations: map[string]string{
+				"summary":     "RabbitMQ queues growing",
+				"description": "Queue depth has been rising for longer than the growth window; consumers are not keeping up",
+			},
+			Enabled:    true,
+			Threshold:  0,
+			Operator:   ">",
+			MetricName: "rabbitmq_queues_growing",
+		},
+		{
+			Name:       "RabbitMQResourceAlarm",
+			Expression: "rabbitmq_resource_alarms > 0",
+			Labels: map[string]string{
+				"severity": "critical",
+				"category": "messaging",
+			},
+			Annotations: map[string]string{
+				"summary":     "RabbitMQ resource alarm",
+				"description": "A memory or disk alarm is blocking publishers",
+			},
+			Enabled:    true,
+			Threshold:  0,
+			Operator:   ">",
+			MetricName: "rabbitmq_resource_alarms",
+		},
 	}
 
-	am.rulesMu.Lock()
-	defer am.rulesMu.Unlock()
-
 	for _, rule := range defaultRules {
-		am.rules[rule.Name] = rule
+		rule.Group = "default"
 	}
-
+	am.replaceFileRules(defaultRules)
 	am.logger.Info("Loaded default alert rules", zap.Int("count", len(defaultRules)))
 }
 
 // CheckMetrics checks metrics against alert rules
 func (am *AlertManager) CheckMetrics(nodeID string, metrics []*models.Metric) {
+	if am.nodes != nil && am.nodes.IsRetiring(nodeID) {
+		return
+	}
+
+	// Derived metrics can be used in rules like any collected metric
+	if am.derived != nil {
+		if derived := am.derived.Evaluate(metrics); len(derived) > 0 {
+			metrics = append(metrics[:len(metrics):len(metrics)], derived...)
+		}
+	}
+
+	if am.scheduler != nil {
+		am.scheduler.observe(nodeID, metrics)
+		return
+	}
+
 	am.rulesMu.RLock()
 	defer am.rulesMu.RUnlock()
 
+	start := time.Now()
+	evaluated := 0
+	defer func() { am.metrics.ObserveAlertCheck(evaluated, start) }()
+
 	for _, metric := range metrics {
 		for ruleName, rule := range am.rules {
 			if !rule.Enabled {
@@ -130,11 +342,12 @@ func (am *AlertManager) CheckMetrics(nodeID string, metrics []*models.Metric) {
 			}
 
 			// Check if metric matches the rule
-			if metric.Name != rule.MetricName {
+			if metric.Name != rule.MetricName || !matchesAll(rule.Matchers, metric.Labels) {
 				continue
 			}
 
 			// Evaluate the rule
+			evaluated++
 			if am.evaluateRule(rule, metric.Value) {
 				am.fireAlert(nodeID, rule, metric)
 			} else {
@@ -181,6 +394,22 @@ func (am *AlertManager) fireAlert(nodeID string, rule *AlertRule, metric *models
 
 		// Update the alert value
 		existingAlert.Value = metric.Value
+
+		// Pending for long enough, start firing
+		if existingAlert.State == models.AlertStatePending {
+			existingAlert.State = models.AlertStateFiring
+			am.logger.Warn("Alert firing",
+				zap.String("alert", rule.Name),
+				zap.String("node", nodeID),
+				zap.Float64("value", metric.Value),
+			)
+			am.annotateFiring(alertKey, nodeID, existingAlert)
+			am.sendNotification(existingAlert)
+			am.store.SaveAlert(existingAlert)
+			am.recordTransition(models.AlertStatePending, existingAlert)
+			return
+		}
+
 		am.store.SaveAlert(existingAlert)
 		return
 	}
@@ -190,7 +419,7 @@ func (am *AlertManager) fireAlert(nodeID string, rule *AlertRule, metric *models
 		ID:          utils.GenerateAlertID(),
 		Name:        rule.Name,
 		Expression:  rule.Expression,
-		Labels:      rule.Labels,
+		Labels:      copyLabels(rule.Labels),
 		Annotations: rule.Annotations,
 		State:       models.AlertStatePending,
 		Value:       metric.Value,
@@ -214,8 +443,10 @@ func (am *AlertManager) fireAlert(nodeID string, rule *AlertRule, metric *models
 			zap.Float64("value", metric.Value),
 		)
 
+		am.annotateFiring(alertKey, nodeID, alert)
+
 		// Send notification
-		go am.sendNotification(alert)
+		am.sendNotification(alert)
 	} else {
 		am.logger.Debug("Alert pending",
 			zap.String("alert", rule.Name),
@@ -226,6 +457,19 @@ func (am *AlertManager) fireAlert(nodeID string, rule *AlertRule, metric *models
 
 	am.activeAlerts[alertKey] = alert
 	am.store.SaveAlert(alert)
+	am.recordTransition(models.AlertStateInactive, alert)
+}
+
+// recordTransition records an alert's state change in the alert feed and
+// publishes it to live clients
+func (am *AlertManager) recordTransition(from models.AlertState, alert *models.Alert) {
+	am.feed.Record(from, alert)
+	if am.trends != nil && alert.State == models.AlertStateFiring && from != models.AlertStateFiring {
+		am.trends.RecordAlert(alert)
+	}
+	if am.live != nil {
+		am.live.BroadcastAlert(alert)
+	}
 }
 
 // resolveAlert resolves an active alert
@@ -240,61 +484,186 @@ func (am *AlertManager) resolveAlert(nodeID string, ruleName string) {
 		return
 	}
 
-	// Mark alert as resolved
-	alert.State = models.AlertStateResolved
-	now := time.Now()
-	alert.ResolvedAt = &now
+	am.closeAlert(alertKey, alert)
 
 	am.logger.Info("Alert resolved",
 		zap.String("alert", ruleName),
 		zap.String("node", nodeID),
 	)
 
+	// Send resolution notification
+	am.sendNotification(alert)
+}
+
+// resolveRule resolves the active alerts of a rule on every node
+func (am *AlertManager) resolveRule(ruleName string) {
+	am.alertsMu.Lock()
+	defer am.alertsMu.Unlock()
+
+	for alertKey, alert := range am.activeAlerts {
+		if alert.Name != ruleName {
+			continue
+		}
+		am.closeAlert(alertKey, alert)
+		am.sendNotification(alert)
+	}
+}
+
+// SilenceNode resolves the active alerts of a node without sending
+// notifications. New alerts are not raised while the node is retiring.
+func (am *AlertManager) SilenceNode(nodeID string) {
+	am.alertsMu.Lock()
+	defer am.alertsMu.Unlock()
+
+	for alertKey, alert := range am.activeAlerts {
+		if alert.Labels["node"] != nodeID {
+			continue
+		}
+
+		am.closeAlert(alertKey, alert)
+		if am.dispatcher != nil {
+			am.dispatcher.Drop(alert)
+		}
+
+		am.logger.Info("Alert silenced",
+			zap.String("alert", alert.Name),
+			zap.String("node", nodeID),
+		)
+	}
+}
+
+// closeAlert marks an active alert resolved and closes its annotation.
+// Callers must hold alertsMu.
+func (am *AlertManager) closeAlert(alertKey string, alert *models.Alert) {
+	// Mark alert as resolved
+	from := alert.State
+	alert.State = models.AlertStateResolved
+	now := time.Now()
+	alert.ResolvedAt = &now
+
 	// Save to storage
 	am.store.SaveAlert(alert)
+	am.recordTransition(from, alert)
 
-	// Send resolution notification
-	go am.sendNotification(alert)
+	// Close the alert's annotation region
+	if annotation, exists := am.annotations[alertKey]; exists {
+		annotation.TimeEnd = &now
+		if err := am.store.SaveAnnotation(annotation); err != nil {
+			am.logger.Warn("Failed to save alert annotation", zap.Error(err))
+		}
+		delete(am.annotations, alertKey)
+	}
 
 	// Remove from active alerts
 	delete(am.activeAlerts, alertKey)
 }
 
-// sendNotification sends an alert notification
-func (am *AlertManager) sendNotification(alert *models.Alert) {
-	// This is a placeholder for notification logic
-	// In a real implementation, you would:
-	// 1. Check notification configuration
-	// 2. Format the alert message
-	// 3. Send to configured channels (Slack, Email, etc.)
-
-	am.logger.Info("Sending alert notification",
-		zap.String("alert", alert.Name),
-		zap.String("state", alert.State.String()),
-		zap.Any("labels", alert.Labels),
-	)
+// annotateFiring records an annotation when an alert starts firing.
+// Callers must hold alertsMu.
+func (am *AlertManager) annotateFiring(alertKey, nodeID string, alert *models.Alert) {
+	annotation := &models.Annotation{
+		ID:        "annotation-" + alert.ID,
+		Time:      time.Now(),
+		Title:     fmt.Sprintf("%s firing", alert.Name),
+		Text:      alert.Annotations["summary"],
+		Tags:      []string{"alert", alert.Name},
+		Source:    models.AnnotationSourceAlert,
+		NodeID:    nodeID,
+		Labels:    alert.Labels,
+		CreatedBy: "alertmanager",
+		CreatedAt: time.Now(),
+	}
+	if severity := alert.Labels["severity"]; severity != "" {
+		annotation.Tags = append(annotation.Tags, severity)
+	}
 
-	// Example: Send to Slack
-	if am.config.Alerting.Notification.Slack.Enabled {
-		am.sendSlackNotification(alert)
+	if err := am.store.SaveAnnotation(annotation); err != nil {
+		am.logger.Warn("Failed to save alert annotation", zap.Error(err))
+		return
 	}
+	am.annotations[alertKey] = annotation
+}
 
-	// Example: Send to Email
-	if am.config.Alerting.Notification.Email.Enabled {
-		am.sendEmailNotification(alert)
+// sendNotification hands an alert state change to the notification
+// routing tree
+func (am *AlertManager) sendNotification(alert *models.Alert) {
+	if am.dispatcher == nil {
+		return
+	}
+	if am.silences != nil && alert.State == models.AlertStateFiring {
+		alert.SilencedBy = am.silences.Silenced(routingLabels(alert))
+	}
+	if len(alert.SilencedBy) > 0 {
+		am.dispatcher.Drop(alert)
+		return
 	}
+	am.dispatcher.Dispatch(alert)
 }
 
-// sendSlackNotification sends a notification to Slack
-func (am *AlertManager) sendSlackNotification(alert *models.Alert) {
-	// Placeholder for Slack notification
-	am.logger.Debug("Would send Slack notification", zap.String("alert", alert.Name))
+// ApplySilences updates which firing alerts are silenced. Alerts a
+// silence now matches are withdrawn from notification; alerts whose
+// silences have ended or been expired are notified again.
+func (am *AlertManager) ApplySilences() {
+	if am.silences == nil {
+		return
+	}
+
+	am.alertsMu.Lock()
+	defer am.alertsMu.Unlock()
+
+	for _, alert := range am.activeAlerts {
+		if alert.State != models.AlertStateFiring {
+			continue
+		}
+		silencedBy := am.silences.Silenced(routingLabels(alert))
+		if equalStrings(silencedBy, alert.SilencedBy) {
+			continue
+		}
+
+		wasSilenced := len(alert.SilencedBy) > 0
+		alert.SilencedBy = silencedBy
+		switch {
+		case len(silencedBy) > 0 && !wasSilenced:
+			if am.dispatcher != nil {
+				am.dispatcher.Drop(alert)
+			}
+			am.logger.Info("Alert silenced",
+				zap.String("alert", alert.Name),
+				zap.String("node", alert.Labels["node"]),
+				zap.Strings("silences", silencedBy),
+			)
+		case len(silencedBy) == 0:
+			if am.dispatcher != nil {
+				am.dispatcher.Dispatch(alert)
+			}
+			am.logger.Info("Alert unsilenced",
+				zap.String("alert", alert.Name),
+				zap.String("node", alert.Labels["node"]),
+			)
+		}
+		am.store.SaveAlert(alert)
+	}
 }
 
-// sendEmailNotification sends a notification via email
-func (am *AlertManager) sendEmailNotification(alert *models.Alert) {
-	// Placeholder for email notification
-	am.logger.Debug("Would send email notification", zap.String("alert", alert.Name))
+// WatchSilences applies silences as they start and end and deletes old
+// expired ones until stop is closed
+func (am *AlertManager) WatchSilences(interval time.Duration, stop <-chan struct{}) {
+	if am.silences == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			am.ApplySilences()
+			am.silences.GC()
+		case <-stop:
+			return
+		}
+	}
 }
 
 // AddRule adds a new alert rule
@@ -306,7 +675,12 @@ func (am *AlertManager) AddRule(rule *AlertRule) error {
 	am.rulesMu.Lock()
 	defer am.rulesMu.Unlock()
 
+	// Rule files own their rules' names; a reload would replace the rule
+	if am.fileRules[rule.Name] {
+		return fmt.Errorf("rule %s is loaded from rule files", rule.Name)
+	}
 	am.rules[rule.Name] = rule
+	am.scheduler.notify()
 	am.logger.Info("Alert rule added", zap.String("rule", rule.Name))
 
 	return nil
@@ -320,13 +694,28 @@ func (am *AlertManager) RemoveRule(ruleName string) error {
 	if _, exists := am.rules[ruleName]; !exists {
 		return fmt.Errorf("rule %s not found", ruleName)
 	}
+	if am.fileRules[ruleName] {
+		return fmt.Errorf("rule %s is loaded from rule files", ruleName)
+	}
 
 	delete(am.rules, ruleName)
+	am.scheduler.notify()
 	am.logger.Info("Alert rule removed", zap.String("rule", ruleName))
 
 	return nil
 }
 
+// rule returns the rule with a name, or nil if there is none or it is
+// disabled
+func (am *AlertManager) rule(name string) *AlertRule {
+	am.rulesMu.RLock()
+	defer am.rulesMu.RUnlock()
+	if rule := am.rules[name]; rule != nil && rule.Enabled {
+		return rule
+	}
+	return nil
+}
+
 // GetActiveAlerts returns all active alerts
 func (am *AlertManager) GetActiveAlerts() []*models.Alert {
 	am.alertsMu.RLock()
@@ -352,3 +741,32 @@ func (am *AlertManager) GetRules() []*AlertRule {
 
 	return rules
 }
+
+func matchesAll(matchers []*utils.Matcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
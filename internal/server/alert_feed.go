@@ -0,0 +1,104 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"go.uber.org/zap"
+)
+
+// alertFeedBuffer is the number of live events buffered per subscriber.
+// A subscriber that falls further behind is dropped and has to resume from
+// its last cursor.
+const alertFeedBuffer = 256
+
+// AlertFeed persists alert state transitions and fans them out to live
+// subscribers
+type AlertFeed struct {
+	store  storage.Storage
+	logger *zap.Logger
+
+	// mu orders appends with subscriptions, so a subscriber sees every
+	// event after the head it was given
+	mu   sync.Mutex
+	subs map[chan *models.AlertEvent]struct{}
+}
+
+// NewAlertFeed creates a new alert feed
+func NewAlertFeed(store storage.Storage, logger *zap.Logger) *AlertFeed {
+	return &AlertFeed{
+		store:  store,
+		logger: logger,
+		subs:   make(map[chan *models.AlertEvent]struct{}),
+	}
+}
+
+// Record persists a transition of an alert and publishes it. The alert is
+// copied, so later changes to it do not leak into the event.
+func (f *AlertFeed) Record(from models.AlertState, alert *models.Alert) {
+	snapshot := *alert
+	event := &models.AlertEvent{
+		Time:  time.Now(),
+		To:    alert.State.String(),
+		Alert: &snapshot,
+	}
+	if from != models.AlertStateInactive {
+		event.From = from.String()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.store.AppendAlertEvent(event); err != nil {
+		f.logger.Warn("Failed to record alert event",
+			zap.String("alert", alert.Name),
+			zap.Error(err),
+		)
+		return
+	}
+
+	for ch := range f.subs {
+		select {
+		case ch <- event:
+		default:
+			// Too slow; closing the channel tells the subscriber to
+			// resume from its cursor
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Events returns persisted events after the given sequence
+func (f *AlertFeed) Events(after uint64, limit int) ([]*models.AlertEvent, error) {
+	return f.store.GetAlertEvents(after, limit)
+}
+
+// Subscribe registers a live subscriber. It returns the sequence of the
+// newest persisted event; every later event is delivered on the channel.
+// The channel is closed if the subscriber falls behind or cancels.
+func (f *AlertFeed) Subscribe() (<-chan *models.AlertEvent, uint64, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	head, err := f.store.LastAlertEventSeq()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	ch := make(chan *models.AlertEvent, alertFeedBuffer)
+	f.subs[ch] = struct{}{}
+
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subs[ch]; ok {
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+
+	return ch, head, cancel, nil
+}
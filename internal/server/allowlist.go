@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// allowlistListener closes connections from addresses outside its
+// allowlist as they are accepted, before any TLS handshake or request
+type allowlistListener struct {
+	net.Listener
+	name    string
+	allowed *utils.Allowlist
+	audit   *zap.Logger
+}
+
+// restrict wraps l to enforce allowed, or returns l when allowed is nil
+func restrict(l net.Listener, name string, allowed *utils.Allowlist, logger *zap.Logger) net.Listener {
+	if allowed == nil {
+		return l
+	}
+	return &allowlistListener{
+		Listener: l,
+		name:     name,
+		allowed:  allowed,
+		audit:    logger.Named("audit"),
+	}
+}
+
+func (l *allowlistListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.allowed.AllowsAddr(conn.RemoteAddr().String()) {
+			return conn, nil
+		}
+		l.audit.Warn("Rejected connection from outside the allowed networks",
+			zap.String("listener", l.name),
+			zap.String("remote_addr", conn.RemoteAddr().String()),
+		)
+		conn.Close()
+	}
+}
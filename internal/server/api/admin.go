@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// reloadConfigHandler applies the reloadable changes to the server's
+// configuration file, as SIGHUP does
+func (a *RESTAPI) reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := a.store.ReloadConfig()
+	if err != nil {
+		a.respondError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if principal, ok := utils.PrincipalFromContext(r.Context()); ok {
+		a.logger.Info("Configuration reloaded", zap.String("by", principal.Name))
+	}
+
+	a.respondJSON(w, http.StatusOK, result)
+}
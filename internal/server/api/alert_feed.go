@@ -0,0 +1,289 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"go.uber.org/zap"
+)
+
+// alertFeedPageSize is the number of persisted events read at a time when
+// replaying the backlog of a resuming client
+const alertFeedPageSize = 500
+
+// Alert feed message types
+const (
+	alertFeedCursor     = "cursor"
+	alertFeedTransition = "transition"
+	alertFeedGap        = "gap"
+)
+
+// AlertFeedMessage is one message of the alert feed. Cursor is the
+// sequence to resume from after this message; a gap reports events in
+// From..To that were trimmed by retention before the client resumed.
+type AlertFeedMessage struct {
+	Type   string             `json:"type"`
+	Cursor uint64             `json:"cursor"`
+	Event  *models.AlertEvent `json:"event,omitempty"`
+	From   uint64             `json:"from,omitempty"`
+	To     uint64             `json:"to,omitempty"`
+}
+
+// alertFeedStream writes feed messages to one client
+type alertFeedStream interface {
+	send(msg *AlertFeedMessage) error
+	ping() error
+	// closed is closed when the client goes away
+	closed() <-chan struct{}
+}
+
+var alertFeedUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// alertFeedHandler streams alert state transitions over a WebSocket or,
+// for plain HTTP requests, as Server-Sent Events. A client resumes from
+// the cursor query parameter or the Last-Event-ID header and receives
+// every transition it missed before live ones; without a cursor it starts
+// at the current head.
+func (a *RESTAPI) alertFeedHandler(w http.ResponseWriter, r *http.Request) {
+	cursorParam := r.URL.Query().Get("cursor")
+	if cursorParam == "" {
+		cursorParam = r.Header.Get("Last-Event-ID")
+	}
+
+	var cursor uint64
+	resume := cursorParam != ""
+	if resume {
+		var err error
+		cursor, err = strconv.ParseUint(cursorParam, 10, 64)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid cursor: %s", cursorParam))
+			return
+		}
+	}
+
+	// Subscribe before reading the backlog so no event falls between the
+	// two; duplicates are skipped by sequence
+	events, head, cancel, err := a.store.SubscribeAlertEvents()
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer cancel()
+
+	if cursor > head {
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("cursor %d is ahead of the feed (head %d)", cursor, head))
+		return
+	}
+
+	var stream alertFeedStream
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := alertFeedUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			a.logger.Error("Failed to upgrade alert feed connection", zap.Error(err))
+			return
+		}
+		ws := newWSAlertFeed(conn)
+		defer ws.close()
+		stream = ws
+	} else {
+		sse, err := newSSEAlertFeed(w, r)
+		if err != nil {
+			a.logger.Error("Streaming not supported for alert feed client", zap.Error(err))
+			return
+		}
+		stream = sse
+	}
+
+	a.logger.Info("Alert feed client connected",
+		zap.String("remote_addr", r.RemoteAddr),
+		zap.Bool("resume", resume),
+		zap.Uint64("cursor", cursor),
+	)
+
+	last := head
+	if resume {
+		last, err = a.replayAlertEvents(stream, cursor, head)
+		if err != nil {
+			a.logger.Debug("Alert feed replay ended", zap.Error(err))
+			return
+		}
+	} else if err := stream.send(&AlertFeedMessage{Type: alertFeedCursor, Cursor: head}); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(ssePingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stream.closed():
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for falling behind; the client resumes
+				// from its last cursor
+				return
+			}
+			if event.Seq <= last {
+				continue
+			}
+			if err := stream.send(&AlertFeedMessage{Type: alertFeedTransition, Cursor: event.Seq, Event: event}); err != nil {
+				return
+			}
+			last = event.Seq
+
+		case <-ticker.C:
+			if err := stream.ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// replayAlertEvents sends persisted events after cursor up to head and
+// returns the sequence of the last one sent
+func (a *RESTAPI) replayAlertEvents(stream alertFeedStream, cursor, head uint64) (uint64, error) {
+	last := cursor
+	for last < head {
+		events, err := a.store.AlertEvents(last, alertFeedPageSize)
+		if err != nil {
+			return last, err
+		}
+
+		// Events the client missed may have been trimmed by retention
+		next := head + 1
+		if len(events) > 0 {
+			next = events[0].Seq
+		}
+		if next > last+1 {
+			gap := &AlertFeedMessage{Type: alertFeedGap, Cursor: next - 1, From: last + 1, To: next - 1}
+			if err := stream.send(gap); err != nil {
+				return last, err
+			}
+			last = next - 1
+		}
+
+		for _, event := range events {
+			if event.Seq > head {
+				return last, nil
+			}
+			if err := stream.send(&AlertFeedMessage{Type: alertFeedTransition, Cursor: event.Seq, Event: event}); err != nil {
+				return last, err
+			}
+			last = event.Seq
+		}
+		if len(events) < alertFeedPageSize {
+			break
+		}
+	}
+	return last, nil
+}
+
+// sseAlertFeed writes feed messages as Server-Sent Events. The event ID is
+// the cursor, so browsers resume through Last-Event-ID on reconnect.
+type sseAlertFeed struct {
+	w    http.ResponseWriter
+	rc   *http.ResponseController
+	done <-chan struct{}
+}
+
+func newSSEAlertFeed(w http.ResponseWriter, r *http.Request) (*sseAlertFeed, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+	return &sseAlertFeed{w: w, rc: rc, done: r.Context().Done()}, nil
+}
+
+func (s *sseAlertFeed) send(msg *AlertFeedMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", msg.Cursor, msg.Type, data))
+}
+
+func (s *sseAlertFeed) ping() error {
+	return s.write(": ping\n\n")
+}
+
+func (s *sseAlertFeed) write(chunk string) error {
+	s.rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprint(s.w, chunk); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s *sseAlertFeed) closed() <-chan struct{} {
+	return s.done
+}
+
+// wsAlertFeed writes feed messages as WebSocket text frames
+type wsAlertFeed struct {
+	conn *websocket.Conn
+	done chan struct{}
+}
+
+func newWSAlertFeed(conn *websocket.Conn) *wsAlertFeed {
+	f := &wsAlertFeed{conn: conn, done: make(chan struct{})}
+
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * ssePingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * ssePingInterval))
+	})
+
+	// The feed is one way; reading only processes control frames and
+	// notices when the client goes away
+	go func() {
+		defer close(f.done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return f
+}
+
+func (f *wsAlertFeed) send(msg *AlertFeedMessage) error {
+	f.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return f.conn.WriteJSON(msg)
+}
+
+func (f *wsAlertFeed) ping() error {
+	return f.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+}
+
+func (f *wsAlertFeed) closed() <-chan struct{} {
+	return f.done
+}
+
+func (f *wsAlertFeed) close() {
+	f.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	f.conn.Close()
+}
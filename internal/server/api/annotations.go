@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// defaultAnnotationLimit caps list responses when no limit is given
+const defaultAnnotationLimit = 100
+
+// listAnnotationsHandler returns annotations overlapping [from, to],
+// optionally filtered by tags (comma-separated, all must match), source
+// and node
+func (a *RESTAPI) listAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter := &models.AnnotationFilter{
+		Start:  time.Now().Add(-24 * time.Hour),
+		End:    time.Now(),
+		Source: q.Get("source"),
+		NodeID: q.Get("node"),
+		Limit:  defaultAnnotationLimit,
+	}
+
+	if from := q.Get("from"); from != "" {
+		ts, err := parseTime(from)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.Start = ts
+	}
+	if to := q.Get("to"); to != "" {
+		ts, err := parseTime(to)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.End = ts
+	}
+	if tags := q.Get("tags"); tags != "" {
+		filter.Tags = splitTags(tags)
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			a.respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: %s", limit))
+			return
+		}
+		filter.Limit = n
+	}
+
+	annotations, err := a.store.GetAnnotations(filter)
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, annotations)
+}
+
+func (a *RESTAPI) createAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	var annotation models.Annotation
+	if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if annotation.Title == "" && annotation.Text == "" {
+		a.respondError(w, http.StatusBadRequest, "title or text is required")
+		return
+	}
+	if annotation.Time.IsZero() {
+		annotation.Time = time.Now().UTC()
+	}
+	if annotation.TimeEnd != nil && annotation.TimeEnd.Before(annotation.Time) {
+		a.respondError(w, http.StatusBadRequest, "time_end must not be before time")
+		return
+	}
+
+	switch annotation.Source {
+	case "":
+		annotation.Source = models.AnnotationSourceManual
+	case models.AnnotationSourceManual, models.AnnotationSourceDeploy, models.AnnotationSourceMaintenance:
+	default:
+		a.respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid source: %s", annotation.Source))
+		return
+	}
+
+	annotation.ID = utils.GenerateAnnotationID()
+	annotation.CreatedAt = time.Now().UTC()
+
+	if err := a.store.SaveAnnotation(&annotation); err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusCreated, annotation)
+}
+
+func (a *RESTAPI) deleteAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if err := a.store.DeleteAnnotation(id); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Annotation %s deleted", id),
+	})
+}
+
+// grafanaAnnotationRequest is the body Grafana's JSON datasource sends
+type grafanaAnnotationRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+// grafanaAnnotationsHandler serves annotations in the JSON datasource
+// format. The annotation query is a comma-separated list of tags.
+func (a *RESTAPI) grafanaAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	var req grafanaAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	annotations, err := a.store.GetAnnotations(&models.AnnotationFilter{
+		Start: req.Range.From,
+		End:   req.Range.To,
+		Tags:  splitTags(req.Annotation.Query),
+	})
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(annotations))
+	for _, an := range annotations {
+		entry := map[string]interface{}{
+			"annotation": req.Annotation,
+			"time":       an.Time.UnixMilli(),
+			"title":      an.Title,
+			"text":       an.Text,
+			"tags":       an.Tags,
+		}
+		if an.TimeEnd != nil {
+			entry["timeEnd"] = an.TimeEnd.UnixMilli()
+		}
+		result = append(result, entry)
+	}
+
+	a.respondJSON(w, http.StatusOK, result)
+}
+
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+)
+
+// maxBatchQueries limits the number of queries in a single batch request
+const maxBatchQueries = 50
+
+// BatchQueryRequest is the body of POST /api/v1/metrics/query_batch
+type BatchQueryRequest struct {
+	Start   string       `json:"start"`
+	End     string       `json:"end"`
+	Step    string       `json:"step"`
+	Queries []BatchQuery `json:"queries"`
+	// Vars are user variables shared by all queries in the batch
+	Vars map[string]string `json:"vars,omitempty"`
+	// MaxPoints and Downsample override the server's preview downsampling
+	MaxPoints  *int   `json:"max_points,omitempty"`
+	Downsample string `json:"downsample,omitempty"`
+}
+
+// BatchQuery is a single query within a batch
+type BatchQuery struct {
+	// ID is echoed back so clients can match results to panels
+	ID      string   `json:"id"`
+	Query   string   `json:"query"`
+	Offsets []string `json:"offsets,omitempty"`
+}
+
+// BatchQueryResult is the result of one query in a batch. Errors are
+// reported per query so one bad panel does not fail the whole batch.
+type BatchQueryResult struct {
+	ID          string               `json:"id"`
+	Status      string               `json:"status"`
+	ResultType  string               `json:"resultType,omitempty"`
+	Result      []*models.TimeSeries `json:"result,omitempty"`
+	Downsampled bool                 `json:"downsampled,omitempty"`
+	Error       string               `json:"error,omitempty"`
+}
+
+// queryBatchHandler evaluates several queries over a shared time range.
+// Queries selecting the same metric share a single storage scan.
+func (a *RESTAPI) queryBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(req.Queries) == 0 {
+		a.respondError(w, http.StatusBadRequest, "at least one query is required")
+		return
+	}
+	if len(req.Queries) > maxBatchQueries {
+		a.respondError(w, http.StatusBadRequest, fmt.Sprintf("too many queries: %d (max %d)", len(req.Queries), maxBatchQueries))
+		return
+	}
+
+	start := time.Now().Add(-1 * time.Hour)
+	if req.Start != "" {
+		ts, err := parseTime(req.Start)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		start = ts
+	}
+
+	end := time.Now()
+	if req.End != "" {
+		ts, err := parseTime(req.End)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		end = ts
+	}
+
+	step := 15 * time.Second
+	if req.Step != "" {
+		d, err := time.ParseDuration(req.Step)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		step = d
+	}
+
+	downsample := downsampleOptions{
+		Method:    a.config.Server.Query.DownsampleMethod,
+		MaxPoints: a.config.Server.Query.MaxPointsPerSeries,
+	}
+	if req.Downsample != "" {
+		downsample.Method = req.Downsample
+	}
+	if req.MaxPoints != nil {
+		downsample.MaxPoints = *req.MaxPoints
+	}
+	if err := downsample.validate(); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Expand macros up front so queries that resolve to the same metric
+	// can share a scan
+	expandErrs := make([]error, len(req.Queries))
+	for i := range req.Queries {
+		req.Queries[i].Query, expandErrs[i] = expandQuery(req.Queries[i].Query, start, end, step, req.Vars)
+	}
+
+	scans := newSharedScans(a.store, start, end, step, req.Queries)
+
+	results := make([]*BatchQueryResult, 0, len(req.Queries))
+	for i, q := range req.Queries {
+		result := &BatchQueryResult{ID: q.ID}
+		if result.ID == "" {
+			result.ID = fmt.Sprintf("%d", i)
+		}
+
+		var series []*models.TimeSeries
+		err := expandErrs[i]
+		if err == nil {
+			series, err = a.evaluateBatchQuery(scans, q, start, end, step)
+		}
+		if err != nil {
+			result.Status = "error"
+			result.Error = err.Error()
+		} else {
+			result.Status = "success"
+			result.ResultType = "matrix"
+			result.Result, result.Downsampled = downsample.downsample(series)
+		}
+
+		results = append(results, result)
+	}
+
+	a.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"results": results,
+		},
+	})
+}
+
+func (a *RESTAPI) evaluateBatchQuery(scans *sharedScans, q BatchQuery, start, end time.Time, step time.Duration) ([]*models.TimeSeries, error) {
+	if q.Query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+
+	// Comparison offsets need their own time ranges and are not shared
+	if len(q.Offsets) > 0 {
+		if len(q.Offsets) > maxQueryOffsets {
+			return nil, fmt.Errorf("too many offsets: %d (max %d)", len(q.Offsets), maxQueryOffsets)
+		}
+		return a.queryWithOffsets(q.Query, start, end, step, q.Offsets)
+	}
+
+	return scans.query(q.Query)
+}
+
+// sharedScans runs one storage query per metric name that appears in more
+// than one batch query and filters the series in memory for each selector
+type sharedScans struct {
+	store      Storage
+	start, end time.Time
+	step       time.Duration
+	shared     map[string]bool
+	results    map[string][]*models.TimeSeries
+	errors     map[string]error
+}
+
+func newSharedScans(store Storage, start, end time.Time, step time.Duration, queries []BatchQuery) *sharedScans {
+	counts := make(map[string]int)
+	for _, q := range queries {
+		if len(q.Offsets) == 0 && q.Query != "" {
+			name, _ := storage.ParseQuery(q.Query)
+			counts[name]++
+		}
+	}
+
+	shared := make(map[string]bool)
+	for name, n := range counts {
+		if n > 1 {
+			shared[name] = true
+		}
+	}
+
+	return &sharedScans{
+		store:   store,
+		start:   start,
+		end:     end,
+		step:    step,
+		shared:  shared,
+		results: make(map[string][]*models.TimeSeries),
+		errors:  make(map[string]error),
+	}
+}
+
+func (s *sharedScans) query(query string) ([]*models.TimeSeries, error) {
+	// Usage is recorded for the selector, not the shared name-only scan
+	s.store.RecordQueryUsage(query)
+
+	name, matchers := storage.ParseQuery(query)
+	if !s.shared[name] {
+		return s.store.QueryMetrics(query, s.start, s.end, s.step)
+	}
+
+	if _, scanned := s.results[name]; !scanned {
+		if _, failed := s.errors[name]; !failed {
+			series, err := s.store.QueryMetrics(name, s.start, s.end, s.step)
+			if err != nil {
+				s.errors[name] = err
+			} else {
+				s.results[name] = series
+			}
+		}
+	}
+	if err := s.errors[name]; err != nil {
+		return nil, err
+	}
+
+	// Series are grouped by their full label set, so filtering whole series
+	// gives the same result as filtering samples during the scan
+	var matched []*models.TimeSeries
+	for _, series := range s.results[name] {
+		if labelsMatch(series.Labels, matchers) {
+			matched = append(matched, series)
+		}
+	}
+
+	return matched, nil
+}
+
+func labelsMatch(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+const (
+	defaultCollectTimeout = 10 * time.Second
+	maxCollectTimeout     = 60 * time.Second
+)
+
+// CollectRequest names the collectors to run on demand. An empty list runs
+// every collector enabled on the agent.
+type CollectRequest struct {
+	Collectors []string `json:"collectors"`
+	Timeout    string   `json:"timeout,omitempty"`
+}
+
+// CollectResponse holds the metrics returned by an on-demand collection
+type CollectResponse struct {
+	NodeID     string           `json:"node_id"`
+	Collectors []string         `json:"collectors"`
+	Duration   string           `json:"duration"`
+	Metrics    []*models.Metric `json:"metrics"`
+}
+
+// collectNodeHandler asks an agent to run collectors immediately and waits
+// for the results
+func (a *RESTAPI) collectNodeHandler(w http.ResponseWriter, r *http.Request) {
+	nodeID := chi.URLParam(r, "nodeID")
+
+	var req CollectRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	req.Collectors = append(req.Collectors, r.URL.Query()["collector"]...)
+
+	timeout := defaultCollectTimeout
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		req.Timeout = s
+	}
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d <= 0 || d > maxCollectTimeout {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout: %s (max %s)", req.Timeout, maxCollectTimeout))
+			return
+		}
+		timeout = d
+	}
+
+	if _, err := a.store.GetNode(nodeID); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	started := time.Now()
+	metrics, err := a.store.CollectNow(ctx, nodeID, req.Collectors)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		a.respondError(w, status, err)
+		return
+	}
+
+	if req.Collectors == nil {
+		req.Collectors = []string{}
+	}
+
+	a.respondJSON(w, http.StatusOK, CollectResponse{
+		NodeID:     nodeID,
+		Collectors: req.Collectors,
+		Duration:   time.Since(started).String(),
+		Metrics:    metrics,
+	})
+}
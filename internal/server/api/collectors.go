@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+const minCollectorInterval = time.Second
+
+// CollectorsRequest changes collector schedules on an agent, keyed by
+// collector name. Omitted fields are left unchanged.
+type CollectorsRequest struct {
+	Collectors map[string]*CollectorUpdate `json:"collectors"`
+	Timeout    string                      `json:"timeout,omitempty"`
+}
+
+// CollectorUpdate enables, disables or reschedules one collector
+type CollectorUpdate struct {
+	Enabled  *bool  `json:"enabled,omitempty"`
+	Interval string `json:"interval,omitempty"`
+}
+
+// CollectorsResponse holds the collector state acknowledged by the agent
+type CollectorsResponse struct {
+	NodeID     string                   `json:"node_id"`
+	Collectors []*models.CollectorState `json:"collectors"`
+}
+
+// updateCollectorsHandler pushes collector changes to an agent and waits
+// for it to acknowledge them
+func (a *RESTAPI) updateCollectorsHandler(w http.ResponseWriter, r *http.Request) {
+	nodeID := chi.URLParam(r, "nodeID")
+
+	var req CollectorsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if len(req.Collectors) == 0 {
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("no collector changes given"))
+		return
+	}
+
+	changes := make([]*models.CollectorChange, 0, len(req.Collectors))
+	for name, update := range req.Collectors {
+		if update == nil || (update.Enabled == nil && update.Interval == "") {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("collector %s: nothing to change", name))
+			return
+		}
+
+		change := &models.CollectorChange{Name: name, Enabled: update.Enabled}
+		if update.Interval != "" {
+			d, err := time.ParseDuration(update.Interval)
+			if err != nil || d < minCollectorInterval {
+				a.respondError(w, http.StatusBadRequest, fmt.Errorf("collector %s: invalid interval: %s (min %s)", name, update.Interval, minCollectorInterval))
+				return
+			}
+			change.Interval = d
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+
+	timeout := defaultCollectTimeout
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d <= 0 || d > maxCollectTimeout {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout: %s (max %s)", req.Timeout, maxCollectTimeout))
+			return
+		}
+		timeout = d
+	}
+
+	if _, err := a.store.GetNode(nodeID); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	states, err := a.store.UpdateCollectors(ctx, nodeID, changes)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		a.respondError(w, status, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, CollectorsResponse{
+		NodeID:     nodeID,
+		Collectors: states,
+	})
+}
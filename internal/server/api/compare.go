@@ -0,0 +1,120 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// maxQueryOffsets caps how many comparison series a single query may request
+const maxQueryOffsets = 5
+
+// queryWithOffsets runs the query for the requested range and once more for
+// each offset, shifting the older samples forward so they line up with the
+// current series. Shifted series carry an "offset" label such as "7d".
+func (a *RESTAPI) queryWithOffsets(query string, start, end time.Time, step time.Duration, offsets []string) ([]*models.TimeSeries, error) {
+	a.store.RecordQueryUsage(query)
+
+	series, err := a.store.QueryMetrics(query, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, raw := range offsets {
+		offset, err := parseOffset(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		past, err := a.store.QueryMetrics(query, start.Add(-offset), end.Add(-offset), step)
+		if err != nil {
+			return nil, fmt.Errorf("offset %s: %w", raw, err)
+		}
+
+		for _, s := range past {
+			series = append(series, shiftSeries(s, offset, raw))
+		}
+	}
+
+	return series, nil
+}
+
+// shiftSeries returns a copy of s moved forward by offset and labelled with it
+func shiftSeries(s *models.TimeSeries, offset time.Duration, label string) *models.TimeSeries {
+	labels := make(map[string]string, len(s.Labels)+1)
+	for k, v := range s.Labels {
+		labels[k] = v
+	}
+	labels["offset"] = label
+
+	samples := make([]models.Sample, len(s.Samples))
+	for i, sample := range s.Samples {
+		samples[i] = models.Sample{
+			Timestamp: sample.Timestamp.Add(offset),
+			Value:     sample.Value,
+		}
+	}
+
+	return &models.TimeSeries{Labels: labels, Samples: samples}
+}
+
+// parseOffsets splits a comma-separated offsets parameter ("1d,7d")
+func parseOffsets(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var offsets []string
+	for _, o := range strings.Split(s, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		if _, err := parseOffset(o); err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, o)
+	}
+
+	if len(offsets) > maxQueryOffsets {
+		return nil, fmt.Errorf("too many offsets: %d (max %d)", len(offsets), maxQueryOffsets)
+	}
+
+	return offsets, nil
+}
+
+// parseOffset parses a positive duration, additionally accepting days ("d")
+// and weeks ("w") which time.ParseDuration does not support
+func parseOffset(s string) (time.Duration, error) {
+	d, err := parseExtendedDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q: %w", s, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid offset %q: must be positive", s)
+	}
+	return d, nil
+}
+
+// parseExtendedDuration parses durations like "90m", "1d" or "2w"
+func parseExtendedDuration(s string) (time.Duration, error) {
+	unit := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+
+	if n := len(s); n > 1 {
+		if mult, ok := unit[s[n-1]]; ok {
+			v, err := strconv.Atoi(s[:n-1])
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(v) * mult, nil
+		}
+	}
+
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// ComplianceEntry summarizes patch and reboot compliance for a single node
+type ComplianceEntry struct {
+	NodeID              string     `json:"node_id"`
+	Hostname            string     `json:"hostname"`
+	RunningKernel       string     `json:"running_kernel,omitempty"`
+	InstalledKernel     string     `json:"installed_kernel,omitempty"`
+	KernelUpdatePending bool       `json:"kernel_update_pending"`
+	RebootRequired      bool       `json:"reboot_required"`
+	RebootRequiredSince *time.Time `json:"reboot_required_since,omitempty"`
+	PendingUpdates      int        `json:"pending_updates"`
+	SecurityUpdates     int        `json:"security_updates"`
+	Compliant           bool       `json:"compliant"`
+}
+
+// ComplianceReport is the fleet-wide compliance summary
+type ComplianceReport struct {
+	GeneratedAt         time.Time          `json:"generated_at"`
+	TotalNodes          int                `json:"total_nodes"`
+	CompliantNodes      int                `json:"compliant_nodes"`
+	RebootRequired      int                `json:"reboot_required"`
+	KernelUpdatePending int                `json:"kernel_update_pending"`
+	SecurityUpdates     int                `json:"nodes_with_security_updates"`
+	Nodes               []*ComplianceEntry `json:"nodes"`
+}
+
+// complianceReportHandler builds a compliance report from node inventories.
+// Pass noncompliant=true to only list nodes that need attention.
+func (a *RESTAPI) complianceReportHandler(w http.ResponseWriter, r *http.Request) {
+	nodes, err := a.store.GetNodes()
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	onlyNoncompliant := r.URL.Query().Get("noncompliant") == "true"
+
+	report := &ComplianceReport{
+		GeneratedAt: time.Now().UTC(),
+		Nodes:       make([]*ComplianceEntry, 0, len(nodes)),
+	}
+
+	for _, node := range nodes {
+		entry := newComplianceEntry(node)
+
+		report.TotalNodes++
+		if entry.Compliant {
+			report.CompliantNodes++
+		}
+		if entry.RebootRequired {
+			report.RebootRequired++
+		}
+		if entry.KernelUpdatePending {
+			report.KernelUpdatePending++
+		}
+		if entry.SecurityUpdates > 0 {
+			report.SecurityUpdates++
+		}
+
+		if onlyNoncompliant && entry.Compliant {
+			continue
+		}
+		report.Nodes = append(report.Nodes, entry)
+	}
+
+	sort.Slice(report.Nodes, func(i, j int) bool {
+		return report.Nodes[i].NodeID < report.Nodes[j].NodeID
+	})
+
+	a.respondJSON(w, http.StatusOK, report)
+}
+
+func newComplianceEntry(node *models.Node) *ComplianceEntry {
+	inv := node.Inventory
+
+	entry := &ComplianceEntry{
+		NodeID:          node.ID,
+		Hostname:        node.Hostname,
+		RunningKernel:   inv[models.InventoryKernelRunning],
+		InstalledKernel: inv[models.InventoryKernelInstalled],
+	}
+
+	// The agent compares kernel versions, so the report agrees with its
+	// system_kernel_update_pending metric
+	entry.KernelUpdatePending, _ = strconv.ParseBool(inv[models.InventoryKernelUpdatePending])
+	entry.RebootRequired, _ = strconv.ParseBool(inv[models.InventoryRebootRequired])
+	if since, err := time.Parse(time.RFC3339, inv[models.InventoryRebootRequiredSince]); err == nil {
+		entry.RebootRequiredSince = &since
+	}
+	entry.PendingUpdates, _ = strconv.Atoi(inv[models.InventoryPackagesPending])
+	entry.SecurityUpdates, _ = strconv.Atoi(inv[models.InventoryPackagesSecurity])
+
+	entry.Compliant = !entry.RebootRequired && !entry.KernelUpdatePending && entry.SecurityUpdates == 0
+
+	return entry
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// listDashboardsHandler returns dashboards sorted by name, optionally
+// filtered by tags (comma-separated, all must match), creator, update
+// time and a search of names and descriptions
+func (a *RESTAPI) listDashboardsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter := &models.DashboardFilter{
+		Tags:      splitTags(q.Get("tags")),
+		CreatedBy: q.Get("created_by"),
+		Search:    q.Get("search"),
+	}
+	if since := q.Get("since"); since != "" {
+		ts, err := parseTime(since)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.Since = &ts
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			a.respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: %s", limit))
+			return
+		}
+		filter.Limit = n
+	}
+
+	dashboards, err := a.store.ListDashboards(filter)
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, dashboards)
+}
+
+func (a *RESTAPI) getDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	dashboard, err := a.store.GetDashboard(chi.URLParam(r, "id"))
+	if err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, dashboard)
+}
+
+func (a *RESTAPI) createDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	var dashboard models.Dashboard
+	if err := json.NewDecoder(r.Body).Decode(&dashboard); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	dashboard.CreatedBy = a.principalName(r)
+
+	saved, err := a.store.CreateDashboard(&dashboard)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusCreated, saved)
+}
+
+// updateDashboardHandler saves a new version of a dashboard. The body's
+// updated_at must be that of the version it was edited from; if the
+// dashboard has been saved since, the update is rejected with 409.
+func (a *RESTAPI) updateDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := a.store.GetDashboard(id); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var dashboard models.Dashboard
+	if err := json.NewDecoder(r.Body).Decode(&dashboard); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	if dashboard.Name == "" {
+		a.respondError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if dashboard.UpdatedAt.IsZero() {
+		a.respondError(w, http.StatusBadRequest, "updated_at of the version being edited is required")
+		return
+	}
+	dashboard.UpdatedBy = a.principalName(r)
+
+	saved, err := a.store.UpdateDashboard(id, &dashboard)
+	if err != nil {
+		a.respondError(w, http.StatusConflict, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, saved)
+}
+
+func (a *RESTAPI) deleteDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := a.store.GetDashboard(id); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if err := a.store.DeleteDashboard(id); err != nil {
+		a.respondError(w, http.StatusConflict, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Dashboard %s deleted", id),
+	})
+}
+
+// exportDashboardHandler returns a dashboard as a JSON file that
+// /dashboards/import accepts
+func (a *RESTAPI) exportDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	dashboard, err := a.store.GetDashboard(id)
+	if err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".json"))
+	a.respondJSON(w, http.StatusOK, dashboard)
+}
+
+// importDashboardsHandler creates dashboards from an exported dashboard or
+// a list of them. Each gets a new ID and starts at version 1.
+func (a *RESTAPI) importDashboardsHandler(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var dashboards []*models.Dashboard
+	if err := json.Unmarshal(body, &dashboards); err != nil {
+		var dashboard models.Dashboard
+		if err := json.Unmarshal(body, &dashboard); err != nil {
+			a.respondError(w, http.StatusBadRequest, "body must be a dashboard or a list of dashboards")
+			return
+		}
+		dashboards = []*models.Dashboard{&dashboard}
+	}
+	if len(dashboards) == 0 {
+		a.respondError(w, http.StatusBadRequest, "no dashboards to import")
+		return
+	}
+
+	imported, err := a.store.ImportDashboards(dashboards, a.principalName(r))
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusCreated, imported)
+}
+
+// listDashboardVersionsHandler lists the saved versions of a dashboard,
+// newest first
+func (a *RESTAPI) listDashboardVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	versions, err := a.store.DashboardVersions(chi.URLParam(r, "id"))
+	if err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, versions)
+}
+
+func (a *RESTAPI) getDashboardVersionHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || version <= 0 {
+		a.respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid version: %s", chi.URLParam(r, "version")))
+		return
+	}
+
+	dashboard, err := a.store.DashboardVersion(chi.URLParam(r, "id"), version)
+	if err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, dashboard)
+}
+
+// restoreDashboardVersionHandler rolls a dashboard back by saving an
+// earlier version as its newest
+func (a *RESTAPI) restoreDashboardVersionHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || version <= 0 {
+		a.respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid version: %s", chi.URLParam(r, "version")))
+		return
+	}
+
+	dashboard, err := a.store.RestoreDashboardVersion(chi.URLParam(r, "id"), version, a.principalName(r))
+	if err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, dashboard)
+}
+
+// principalName returns the caller's name, or an empty string when
+// authentication is disabled
+func (a *RESTAPI) principalName(r *http.Request) string {
+	if principal, ok := utils.PrincipalFromContext(r.Context()); ok && a.auth.Enabled() {
+		return principal.Name
+	}
+	return ""
+}
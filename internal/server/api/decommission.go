@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// DecommissionRequest retires a node. Action is keep, archive or purge and
+// applies to the node's series once the grace period ends.
+type DecommissionRequest struct {
+	Reason      string                    `json:"reason,omitempty"`
+	Action      models.DecommissionAction `json:"action,omitempty"`
+	Grace       string                    `json:"grace,omitempty"`
+	RequestedBy string                    `json:"requested_by,omitempty"`
+}
+
+// decommissionNodeHandler marks a node as retiring
+func (a *RESTAPI) decommissionNodeHandler(w http.ResponseWriter, r *http.Request) {
+	nodeID := chi.URLParam(r, "nodeID")
+
+	var req DecommissionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+
+	switch req.Action {
+	case "", models.DecommissionKeep, models.DecommissionArchive, models.DecommissionPurge:
+	default:
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid action: %s (keep, archive or purge)", req.Action))
+		return
+	}
+
+	grace := a.config.Lifecycle.DecommissionGrace
+	if req.Grace != "" {
+		d, err := time.ParseDuration(req.Grace)
+		if err != nil || d < 0 {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid grace: %s", req.Grace))
+			return
+		}
+		grace = d
+	}
+
+	if _, err := a.store.GetNode(nodeID); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	now := time.Now()
+	node, err := a.store.DecommissionNode(nodeID, &models.Decommission{
+		Reason:      req.Reason,
+		Action:      req.Action,
+		RequestedBy: req.RequestedBy,
+		RequestedAt: now,
+		CompleteAt:  now.Add(grace),
+	})
+	if err != nil {
+		a.respondError(w, http.StatusConflict, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, node)
+}
+
+// reactivateNodeHandler cancels decommissioning of a retiring node
+func (a *RESTAPI) reactivateNodeHandler(w http.ResponseWriter, r *http.Request) {
+	nodeID := chi.URLParam(r, "nodeID")
+
+	if _, err := a.store.GetNode(nodeID); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	node, err := a.store.ReactivateNode(nodeID)
+	if err != nil {
+		a.respondError(w, http.StatusConflict, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, node)
+}
+
+// scaleInNodeHandler records a clean scale-in of an ephemeral node, for
+// autoscaler lifecycle hooks. The node is removed after the ephemeral TTL
+// and no offline alerts are raised for it.
+func (a *RESTAPI) scaleInNodeHandler(w http.ResponseWriter, r *http.Request) {
+	nodeID := chi.URLParam(r, "nodeID")
+
+	if _, err := a.store.GetNode(nodeID); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	node, err := a.store.DepartNode(nodeID)
+	if err != nil {
+		a.respondError(w, http.StatusConflict, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, node)
+}
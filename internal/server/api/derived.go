@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+func (a *RESTAPI) listDerivedMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	defs, err := a.store.ListDerivedMetrics()
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, defs)
+}
+
+func (a *RESTAPI) getDerivedMetricHandler(w http.ResponseWriter, r *http.Request) {
+	def, err := a.store.GetDerivedMetric(chi.URLParam(r, "name"))
+	if err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, def)
+}
+
+// saveDerivedMetricHandler creates or replaces a derived metric. The name
+// comes from the URL; the body carries the expression and description.
+func (a *RESTAPI) saveDerivedMetricHandler(w http.ResponseWriter, r *http.Request) {
+	var def models.DerivedMetric
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	def.Name = chi.URLParam(r, "name")
+
+	saved, err := a.store.SaveDerivedMetric(&def)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, saved)
+}
+
+func (a *RESTAPI) deleteDerivedMetricHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	if err := a.store.DeleteDerivedMetric(name); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"message": "Derived metric " + name + " deleted",
+	})
+}
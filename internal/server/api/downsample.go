@@ -0,0 +1,201 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// Downsampling methods
+const (
+	DownsampleLTTB   = "lttb"
+	DownsampleMinMax = "minmax"
+	DownsampleNone   = "none"
+)
+
+// downsampleOptions controls preview downsampling for a query response
+type downsampleOptions struct {
+	Method    string
+	MaxPoints int
+}
+
+// downsampleOptionsFor reads max_points and downsample query parameters,
+// falling back to the server defaults
+func (a *RESTAPI) downsampleOptionsFor(q url.Values) (downsampleOptions, error) {
+	opts := downsampleOptions{
+		Method:    a.config.Server.Query.DownsampleMethod,
+		MaxPoints: a.config.Server.Query.MaxPointsPerSeries,
+	}
+
+	if m := q.Get("downsample"); m != "" {
+		opts.Method = m
+	}
+	if p := q.Get("max_points"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid max_points: %s", p)
+		}
+		opts.MaxPoints = n
+	}
+
+	return opts, opts.validate()
+}
+
+func (o downsampleOptions) validate() error {
+	switch o.Method {
+	case "", DownsampleLTTB, DownsampleMinMax, DownsampleNone:
+	default:
+		return fmt.Errorf("invalid downsample method: %s", o.Method)
+	}
+	if o.Method != DownsampleNone && o.MaxPoints > 0 && o.MaxPoints < 3 {
+		return fmt.Errorf("max_points must be at least 3")
+	}
+	return nil
+}
+
+// downsample reduces every series with more than MaxPoints samples and
+// reports whether any series was reduced. Input series are not modified.
+func (o downsampleOptions) downsample(series []*models.TimeSeries) ([]*models.TimeSeries, bool) {
+	if o.Method == DownsampleNone || o.MaxPoints <= 0 {
+		return series, false
+	}
+
+	reduced := false
+	result := make([]*models.TimeSeries, len(series))
+	for i, s := range series {
+		if len(s.Samples) <= o.MaxPoints {
+			result[i] = s
+			continue
+		}
+
+		samples := sortedSamples(s.Samples)
+		switch o.Method {
+		case DownsampleMinMax:
+			samples = minMaxEnvelope(samples, o.MaxPoints)
+		default:
+			samples = lttb(samples, o.MaxPoints)
+		}
+
+		result[i] = &models.TimeSeries{Labels: s.Labels, Samples: samples}
+		reduced = true
+	}
+
+	return result, reduced
+}
+
+func sortedSamples(samples []models.Sample) []models.Sample {
+	less := func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) }
+	if sort.SliceIsSorted(samples, less) {
+		return samples
+	}
+
+	sorted := make([]models.Sample, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+	return sorted
+}
+
+// lttb implements Largest-Triangle-Three-Buckets, which keeps the visual
+// shape of a series with threshold points
+func lttb(samples []models.Sample, threshold int) []models.Sample {
+	n := len(samples)
+	if threshold >= n || threshold < 3 {
+		return samples
+	}
+
+	out := make([]models.Sample, 0, threshold)
+	out = append(out, samples[0])
+
+	bucketSize := float64(n-2) / float64(threshold-2)
+	a := 0
+
+	for i := 0; i < threshold-2; i++ {
+		// Average of the next bucket is the third triangle point
+		nextStart := int(math.Floor(float64(i+1)*bucketSize)) + 1
+		nextEnd := int(math.Floor(float64(i+2)*bucketSize)) + 1
+		if nextEnd > n {
+			nextEnd = n
+		}
+
+		var avgX, avgY float64
+		for j := nextStart; j < nextEnd; j++ {
+			avgX += float64(samples[j].Timestamp.UnixNano())
+			avgY += samples[j].Value
+		}
+		if count := float64(nextEnd - nextStart); count > 0 {
+			avgX /= count
+			avgY /= count
+		}
+
+		// Pick the point in this bucket forming the largest triangle
+		start := int(math.Floor(float64(i)*bucketSize)) + 1
+		end := nextStart
+
+		ax := float64(samples[a].Timestamp.UnixNano())
+		ay := samples[a].Value
+
+		maxArea := -1.0
+		chosen := start
+		for j := start; j < end; j++ {
+			area := math.Abs((ax-avgX)*(samples[j].Value-ay) -
+				(ax-float64(samples[j].Timestamp.UnixNano()))*(avgY-ay))
+			if area > maxArea {
+				maxArea = area
+				chosen = j
+			}
+		}
+
+		out = append(out, samples[chosen])
+		a = chosen
+	}
+
+	return append(out, samples[n-1])
+}
+
+// minMaxEnvelope splits the series into maxPoints/2 buckets and keeps the
+// minimum and maximum of each, preserving spikes that LTTB might smooth
+func minMaxEnvelope(samples []models.Sample, maxPoints int) []models.Sample {
+	buckets := maxPoints / 2
+	if buckets < 1 || len(samples) <= maxPoints {
+		return samples
+	}
+
+	out := make([]models.Sample, 0, buckets*2)
+	size := float64(len(samples)) / float64(buckets)
+
+	for b := 0; b < buckets; b++ {
+		start := int(float64(b) * size)
+		end := int(float64(b+1) * size)
+		if b == buckets-1 {
+			end = len(samples)
+		}
+		if start >= end {
+			continue
+		}
+
+		minIdx, maxIdx := start, start
+		for j := start + 1; j < end; j++ {
+			if samples[j].Value < samples[minIdx].Value {
+				minIdx = j
+			}
+			if samples[j].Value > samples[maxIdx].Value {
+				maxIdx = j
+			}
+		}
+
+		// Emit in time order
+		if minIdx == maxIdx {
+			out = append(out, samples[minIdx])
+		} else if minIdx < maxIdx {
+			out = append(out, samples[minIdx], samples[maxIdx])
+		} else {
+			out = append(out, samples[maxIdx], samples[minIdx])
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,471 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/query"
+)
+
+// maxGrafanaDashboardSize bounds a Grafana dashboard export
+const maxGrafanaDashboardSize = 10 << 20
+
+// grafanaPanelTypes maps the Grafana panel types that have an equivalent
+var grafanaPanelTypes = map[string]models.PanelType{
+	"graph":      models.PanelTypeGraph,
+	"timeseries": models.PanelTypeGraph,
+	"stat":       models.PanelTypeSingleStat,
+	"singlestat": models.PanelTypeSingleStat,
+	"table":      models.PanelTypeTable,
+	"table-old":  models.PanelTypeTable,
+	"text":       models.PanelTypeText,
+	"heatmap":    models.PanelTypeHeatmap,
+}
+
+var (
+	// grafanaBracketVariable matches the deprecated [[name]] and
+	// [[name:format]] syntax
+	grafanaBracketVariable = regexp.MustCompile(`\[\[(\w+)(?::\w+)?\]\]`)
+	// grafanaFormattedVariable matches ${name:format}
+	grafanaFormattedVariable = regexp.MustCompile(`\$\{(\w+):[^}]*\}`)
+)
+
+type grafanaDashboard struct {
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Tags        []string        `json:"tags"`
+	Refresh     json.RawMessage `json:"refresh"`
+	Panels      []*grafanaPanel `json:"panels"`
+	// Rows hold the panels of dashboards from before Grafana 5
+	Rows []struct {
+		Title  string          `json:"title"`
+		Panels []*grafanaPanel `json:"panels"`
+	} `json:"rows"`
+	Templating struct {
+		List []*grafanaVariable `json:"list"`
+	} `json:"templating"`
+	Annotations struct {
+		List []struct {
+			Name    string `json:"name"`
+			BuiltIn int    `json:"builtIn"`
+		} `json:"list"`
+	} `json:"annotations"`
+	Links []json.RawMessage `json:"links"`
+}
+
+type grafanaPanel struct {
+	ID          int             `json:"id"`
+	Type        string          `json:"type"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Datasource  json.RawMessage `json:"datasource"`
+	Targets     []struct {
+		RefID        string          `json:"refId"`
+		Expr         string          `json:"expr"`
+		LegendFormat string          `json:"legendFormat"`
+		Hide         bool            `json:"hide"`
+		Datasource   json.RawMessage `json:"datasource"`
+	} `json:"targets"`
+	GridPos *struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+		W int `json:"w"`
+		H int `json:"h"`
+	} `json:"gridPos"`
+	// Span is the width, out of 12, of a panel in a pre-Grafana 5 row
+	Span        float64 `json:"span"`
+	FieldConfig struct {
+		Defaults struct {
+			Unit     string   `json:"unit"`
+			Min      *float64 `json:"min"`
+			Max      *float64 `json:"max"`
+			Decimals *int     `json:"decimals"`
+		} `json:"defaults"`
+		Overrides []json.RawMessage `json:"overrides"`
+	} `json:"fieldConfig"`
+	Options struct {
+		Content       string `json:"content"`
+		Mode          string `json:"mode"`
+		ReduceOptions struct {
+			Calcs []string `json:"calcs"`
+		} `json:"reduceOptions"`
+	} `json:"options"`
+	// Content, Mode and Format are the settings of older text and
+	// singlestat panels
+	Content         string            `json:"content"`
+	Mode            string            `json:"mode"`
+	Format          string            `json:"format"`
+	Panels          []*grafanaPanel   `json:"panels"`
+	Repeat          string            `json:"repeat"`
+	Transformations []json.RawMessage `json:"transformations"`
+	SeriesOverrides []json.RawMessage `json:"seriesOverrides"`
+	Alert           json.RawMessage   `json:"alert"`
+	Links           []json.RawMessage `json:"links"`
+}
+
+type grafanaVariable struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Query    json.RawMessage `json:"query"`
+	AllValue string          `json:"allValue"`
+	Current  struct {
+		Value json.RawMessage `json:"value"`
+	} `json:"current"`
+	Options []struct {
+		Value    string `json:"value"`
+		Selected bool   `json:"selected"`
+	} `json:"options"`
+}
+
+// importGrafanaDashboardHandler converts a Grafana dashboard export and,
+// unless dry_run=true, stores it as a new dashboard. The response lists
+// what could not be converted.
+func (a *RESTAPI) importGrafanaDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxGrafanaDashboardSize+1))
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxGrafanaDashboardSize {
+		a.respondError(w, http.StatusRequestEntityTooLarge, "dashboard export too large")
+		return
+	}
+
+	conversion, err := convertGrafanaDashboard(body)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.URL.Query().Get("dry_run") == "true" {
+		a.respondJSON(w, http.StatusOK, conversion)
+		return
+	}
+
+	imported, err := a.store.ImportDashboards([]*models.Dashboard{conversion.Dashboard}, a.principalName(r))
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	conversion.Dashboard = imported[0]
+	conversion.Imported = true
+
+	a.respondJSON(w, http.StatusCreated, conversion)
+}
+
+// convertGrafanaDashboard converts a Grafana dashboard JSON export, as
+// saved from the UI or returned by its API, to a dashboard
+func convertGrafanaDashboard(data []byte) (*models.DashboardConversion, error) {
+	var wrapper struct {
+		Dashboard json.RawMessage `json:"dashboard"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return nil, fmt.Errorf("invalid Grafana dashboard: %w", err)
+	}
+	if len(wrapper.Dashboard) > 0 {
+		data = wrapper.Dashboard
+	}
+
+	var src grafanaDashboard
+	if err := json.Unmarshal(data, &src); err != nil {
+		return nil, fmt.Errorf("invalid Grafana dashboard: %w", err)
+	}
+	if src.Title == "" {
+		return nil, fmt.Errorf("dashboard has no title")
+	}
+
+	c := &grafanaConverter{
+		dashboard: &models.Dashboard{
+			Name:        src.Title,
+			Description: src.Description,
+			Tags:        src.Tags,
+			Panels:      []*models.Panel{},
+			Variables:   make(map[string]string),
+		},
+		notes: []string{},
+	}
+	var refresh string
+	if json.Unmarshal(src.Refresh, &refresh) == nil && refresh != "" {
+		if d, err := time.ParseDuration(refresh); err == nil {
+			c.refresh = d
+		} else {
+			c.unsupported("dashboard refresh %q is not a duration", refresh)
+		}
+	}
+
+	// Variables first, so queries can be checked against them
+	for _, v := range src.Templating.List {
+		c.convertVariable(v)
+	}
+
+	for _, p := range src.Panels {
+		c.convertPanel(p, nil)
+	}
+	// Rows span the 24 columns of Grafana's grid, wrapping full ones
+	var y int
+	for _, row := range src.Rows {
+		x := 0
+		for _, p := range row.Panels {
+			width := int(p.Span * 2)
+			if width <= 0 || width > 24 {
+				width = 24
+			}
+			if x+width > 24 {
+				x = 0
+				y += 8
+			}
+			c.convertPanel(p, &models.PanelPosition{X: x, Y: y, Width: width, Height: 8})
+			x += width
+		}
+		y += 8
+	}
+	if c.rows > 0 || len(src.Rows) > 0 {
+		c.unsupported("row headers are not kept; their panels are placed on the dashboard")
+	}
+
+	for _, ann := range src.Annotations.List {
+		if ann.BuiltIn == 0 {
+			c.unsupported("annotation query %q is not converted", ann.Name)
+		}
+	}
+	if len(src.Links) > 0 {
+		c.unsupported("%d dashboard links are not converted", len(src.Links))
+	}
+
+	return &models.DashboardConversion{
+		Dashboard:   c.dashboard,
+		Panels:      len(c.dashboard.Panels),
+		Variables:   len(c.dashboard.Variables),
+		Unsupported: c.notes,
+	}, nil
+}
+
+// grafanaConverter accumulates a converted dashboard and notes on what it
+// left out
+type grafanaConverter struct {
+	dashboard *models.Dashboard
+	refresh   time.Duration
+	rows      int
+	notes     []string
+}
+
+func (c *grafanaConverter) unsupported(format string, args ...interface{}) {
+	c.notes = append(c.notes, fmt.Sprintf(format, args...))
+}
+
+// convertVariable keeps a template variable's current value as its
+// default
+func (c *grafanaConverter) convertVariable(v *grafanaVariable) {
+	switch v.Type {
+	case "query", "custom", "constant", "textbox", "interval":
+	default:
+		c.unsupported("variable $%s: %s variables are not supported", v.Name, v.Type)
+		return
+	}
+
+	value, ok := grafanaVariableValue(v)
+	if !ok {
+		c.unsupported("variable $%s has no current value", v.Name)
+		return
+	}
+	c.dashboard.Variables[v.Name] = value
+	if v.Type == "query" {
+		c.unsupported("variable $%s: query variables are not refreshed; kept with value %q", v.Name, value)
+	}
+}
+
+// grafanaVariableValue returns a variable's selected value. Several
+// values, or All, become a regular expression matching any of them, as
+// Grafana expands them in Prometheus queries.
+func grafanaVariableValue(v *grafanaVariable) (string, bool) {
+	var values []string
+	var one string
+	switch {
+	case json.Unmarshal(v.Current.Value, &one) == nil:
+		values = []string{one}
+	case json.Unmarshal(v.Current.Value, &values) == nil:
+	}
+	if len(values) == 0 {
+		for _, opt := range v.Options {
+			if opt.Selected {
+				values = append(values, opt.Value)
+			}
+		}
+	}
+	if len(values) == 0 && v.Type == "constant" && json.Unmarshal(v.Query, &one) == nil {
+		values = []string{one}
+	}
+
+	for _, value := range values {
+		if value == "$__all" {
+			if v.AllValue != "" {
+				return v.AllValue, true
+			}
+			return ".*", true
+		}
+	}
+	switch len(values) {
+	case 0:
+		return "", false
+	case 1:
+		return values[0], true
+	default:
+		return "(" + strings.Join(values, "|") + ")", true
+	}
+}
+
+// convertPanel adds a panel, or the panels of a row. pos places panels of
+// pre-Grafana 5 rows, which have no grid position.
+func (c *grafanaConverter) convertPanel(p *grafanaPanel, pos *models.PanelPosition) {
+	title := p.Title
+	if title == "" {
+		title = fmt.Sprintf("#%d", p.ID)
+	}
+	if p.Type == "row" {
+		c.rows++
+		for _, child := range p.Panels {
+			c.convertPanel(child, nil)
+		}
+		return
+	}
+
+	panelType, ok := grafanaPanelTypes[p.Type]
+	if !ok {
+		c.unsupported("panel %q: %s panels are not supported", title, p.Type)
+		return
+	}
+
+	panel := &models.Panel{
+		ID:          fmt.Sprintf("panel-%d", len(c.dashboard.Panels)+1),
+		Title:       p.Title,
+		Type:        panelType,
+		Position:    pos,
+		Options:     make(map[string]interface{}),
+		RefreshRate: c.refresh,
+	}
+	if p.GridPos != nil {
+		panel.Position = &models.PanelPosition{X: p.GridPos.X, Y: p.GridPos.Y, Width: p.GridPos.W, Height: p.GridPos.H}
+	}
+	if p.Description != "" {
+		panel.Options["description"] = p.Description
+	}
+
+	defaults := p.FieldConfig.Defaults
+	if defaults.Unit != "" {
+		panel.Options["unit"] = defaults.Unit
+	} else if p.Format != "" {
+		panel.Options["unit"] = p.Format
+	}
+	if defaults.Min != nil {
+		panel.Options["min"] = *defaults.Min
+	}
+	if defaults.Max != nil {
+		panel.Options["max"] = *defaults.Max
+	}
+	if defaults.Decimals != nil {
+		panel.Options["decimals"] = *defaults.Decimals
+	}
+	if calcs := p.Options.ReduceOptions.Calcs; len(calcs) > 0 {
+		panel.Options["reduce"] = calcs[0]
+	}
+
+	if panelType == models.PanelTypeText {
+		content, mode := p.Options.Content, p.Options.Mode
+		if content == "" {
+			content, mode = p.Content, p.Mode
+		}
+		panel.Options["content"] = content
+		if mode != "" {
+			panel.Options["mode"] = mode
+		}
+	} else {
+		c.convertTargets(p, title, panel)
+	}
+
+	if len(p.FieldConfig.Overrides) > 0 || len(p.SeriesOverrides) > 0 {
+		c.unsupported("panel %q: field and series overrides are not converted", title)
+	}
+	if len(p.Transformations) > 0 {
+		c.unsupported("panel %q: transformations are not converted", title)
+	}
+	if p.Repeat != "" {
+		c.unsupported("panel %q: repeating by $%s is not supported", title, p.Repeat)
+	}
+	if len(p.Alert) > 0 && string(p.Alert) != "null" {
+		c.unsupported("panel %q: legacy panel alerts are not converted; define an alert rule instead", title)
+	}
+	if len(p.Links) > 0 {
+		c.unsupported("panel %q: links are not converted", title)
+	}
+
+	c.dashboard.Panels = append(c.dashboard.Panels, panel)
+}
+
+// convertTargets keeps a panel's first visible Prometheus query
+func (c *grafanaConverter) convertTargets(p *grafanaPanel, title string, panel *models.Panel) {
+	if t := grafanaDatasourceType(p.Datasource); t != "" && t != "prometheus" && t != "datasource" {
+		c.unsupported("panel %q: %s datasource queries are not supported", title, t)
+		return
+	}
+
+	var kept int
+	for _, t := range p.Targets {
+		if t.Hide {
+			continue
+		}
+		if dsType := grafanaDatasourceType(t.Datasource); t.Expr == "" || (dsType != "" && dsType != "prometheus") {
+			c.unsupported("panel %q: query %s is not a Prometheus query", title, t.RefID)
+			continue
+		}
+		kept++
+		if kept > 1 {
+			c.unsupported("panel %q: only the first query is kept; query %s dropped", title, t.RefID)
+			continue
+		}
+
+		expr := grafanaBracketVariable.ReplaceAllString(t.Expr, "$${$1}")
+		if formatted := grafanaFormattedVariable.ReplaceAllString(expr, "$${$1}"); formatted != expr {
+			c.unsupported("panel %q: variable formats in query %s are dropped", title, t.RefID)
+			expr = formatted
+		}
+		panel.Query = expr
+		if t.LegendFormat != "" {
+			panel.Options["legend"] = t.LegendFormat
+		}
+		c.checkQuery(title, expr)
+	}
+	if kept == 0 {
+		c.unsupported("panel %q has no query", title)
+	}
+}
+
+// checkQuery notes a query that would fail to run once its variables are
+// expanded
+func (c *grafanaConverter) checkQuery(title, expr string) {
+	now := time.Now()
+	expanded, err := expandQuery(expr, now.Add(-time.Hour), now, time.Minute, c.dashboard.Variables)
+	if err != nil {
+		c.unsupported("panel %q: %v", title, err)
+		return
+	}
+	if _, err := query.Parse(expanded); err != nil {
+		c.unsupported("panel %q: query %q is not supported by the query engine: %v", title, expr, err)
+	}
+}
+
+// grafanaDatasourceType returns the type of a datasource reference, which
+// is an object with a type in recent exports, or just a name
+func grafanaDatasourceType(raw json.RawMessage) string {
+	var ref struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(raw, &ref) != nil {
+		return ""
+	}
+	return ref.Type
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/meettoy2004/lnmonja/pkg/i18n"
+)
+
+// LocalesResponse lists the locales the dashboard can be shown in
+type LocalesResponse struct {
+	Default string   `json:"default"`
+	Locales []string `json:"locales"`
+}
+
+// MessagesResponse holds every message of a locale, with those it falls
+// back to
+type MessagesResponse struct {
+	Locale   string            `json:"locale"`
+	Messages map[string]string `json:"messages"`
+}
+
+// SetCatalog serves the messages of catalog under /i18n. Without one, only
+// the built-in English messages are served.
+func (a *RESTAPI) SetCatalog(catalog *i18n.Catalog) {
+	a.catalog = catalog
+}
+
+func (a *RESTAPI) i18nCatalog() *i18n.Catalog {
+	if a.catalog != nil {
+		return a.catalog
+	}
+	catalog, _ := i18n.Load("", "")
+	return catalog
+}
+
+func (a *RESTAPI) localesHandler(w http.ResponseWriter, r *http.Request) {
+	catalog := a.i18nCatalog()
+	a.respondJSON(w, http.StatusOK, &LocalesResponse{
+		Default: catalog.Default(),
+		Locales: catalog.Locales(),
+	})
+}
+
+// messagesHandler returns the messages of the locale parameter, or of the
+// locale negotiated from Accept-Language
+func (a *RESTAPI) messagesHandler(w http.ResponseWriter, r *http.Request) {
+	catalog := a.i18nCatalog()
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale = catalog.Negotiate(r.Header.Get("Accept-Language"))
+	}
+	if !i18n.ValidLocale(locale) {
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid locale: %q", locale))
+		return
+	}
+
+	locale = catalog.Resolve(locale)
+	w.Header().Set("Content-Language", locale)
+	w.Header().Add("Vary", "Accept-Language")
+	a.respondJSON(w, http.StatusOK, &MessagesResponse{
+		Locale:   locale,
+		Messages: catalog.Messages(locale),
+	})
+}
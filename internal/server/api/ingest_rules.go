@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+func (a *RESTAPI) listIngestRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := a.store.ListIngestRules()
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, rules)
+}
+
+func (a *RESTAPI) getIngestRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule, err := a.store.GetIngestRule(chi.URLParam(r, "name"))
+	if err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, rule)
+}
+
+// saveIngestRuleHandler creates or replaces an ingest rule. The name comes
+// from the URL; the body carries the matchers, action and its settings.
+func (a *RESTAPI) saveIngestRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var rule models.IngestRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	rule.Name = chi.URLParam(r, "name")
+
+	saved, err := a.store.SaveIngestRule(&rule)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, saved)
+}
+
+func (a *RESTAPI) deleteIngestRuleHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	if err := a.store.DeleteIngestRule(name); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"message": "Ingest rule " + name + " deleted",
+	})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// variablePattern matches $name and ${name}
+var variablePattern = regexp.MustCompile(`\$\{(\w+)\}|\$(\w+)`)
+
+// variableParamPrefix marks user variables in query strings, e.g. var-host=web1
+const variableParamPrefix = "var-"
+
+// expandQuery resolves built-in macros and user variables in a query.
+// Built-ins are derived from the request range:
+//
+//	$__interval, $__interval_ms   the query step
+//	$__rate_interval              four steps, the smallest safe rate window
+//	$__range, $__range_s, $__range_ms   end minus start
+//	$__from, $__to                range bounds as Unix milliseconds
+func expandQuery(query string, start, end time.Time, step time.Duration, vars map[string]string) (string, error) {
+	if !strings.Contains(query, "$") {
+		return query, nil
+	}
+
+	rng := end.Sub(start)
+	builtins := map[string]string{
+		"__interval":      formatMacroDuration(step),
+		"__interval_ms":   strconv.FormatInt(step.Milliseconds(), 10),
+		"__rate_interval": formatMacroDuration(4 * step),
+		"__range":         formatMacroDuration(rng),
+		"__range_s":       strconv.FormatInt(int64(rng.Seconds()), 10),
+		"__range_ms":      strconv.FormatInt(rng.Milliseconds(), 10),
+		"__from":          strconv.FormatInt(start.UnixMilli(), 10),
+		"__to":            strconv.FormatInt(end.UnixMilli(), 10),
+	}
+
+	var unknown []string
+	expanded := variablePattern.ReplaceAllStringFunc(query, func(match string) string {
+		groups := variablePattern.FindStringSubmatch(match)
+		name := groups[1]
+		if name == "" {
+			name = groups[2]
+		}
+
+		if v, ok := builtins[name]; ok {
+			return v
+		}
+		if v, ok := vars[name]; ok {
+			return v
+		}
+
+		unknown = append(unknown, name)
+		return match
+	})
+
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("undefined query variables: %s", strings.Join(unknown, ", "))
+	}
+
+	return expanded, nil
+}
+
+// queryVariables collects user variables passed as var-<name>=<value>
+func queryVariables(values url.Values) map[string]string {
+	vars := make(map[string]string)
+	for key, v := range values {
+		if name := strings.TrimPrefix(key, variableParamPrefix); name != key && len(v) > 0 {
+			vars[name] = v[0]
+		}
+	}
+	return vars
+}
+
+// formatMacroDuration renders a duration in the largest whole unit, e.g. "5m"
+func formatMacroDuration(d time.Duration) string {
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+
+	for _, u := range units {
+		if d >= u.size && d%u.size == 0 {
+			return fmt.Sprintf("%d%s", d/u.size, u.suffix)
+		}
+	}
+
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// defaultMetadataLimit caps the series, label names or label values in
+// one response; limit=0 returns them all
+const defaultMetadataLimit = 1000
+
+// seriesHandler lists the series matching any of the repeatable match[]
+// selectors, or every series, between start and end. Series are sorted by
+// selector; when more remain, next is the after value of the next page.
+func (a *RESTAPI) seriesHandler(w http.ResponseWriter, r *http.Request) {
+	start, end, limit, err := metadataParams(r)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	series, err := a.store.FindSeries(r.URL.Query()["match[]"], start, end)
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page, next := paginate(series, (*models.Series).String, r.URL.Query().Get("after"), limit)
+	a.respondMetadata(w, page, next, nil)
+}
+
+// labelsHandler lists the label names, __name__ included, of the series
+// between start and end, matching the match[] selectors if given
+func (a *RESTAPI) labelsHandler(w http.ResponseWriter, r *http.Request) {
+	start, end, limit, err := metadataParams(r)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	names, err := a.store.LabelNames(r.URL.Query()["match[]"], start, end)
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page, next := paginate(names, identity, r.URL.Query().Get("after"), limit)
+	a.respondMetadata(w, page, next, nil)
+}
+
+// labelValuesHandler lists the values of a label, the metric names for
+// __name__, in the series between start and end, matching the match[]
+// selectors if given
+func (a *RESTAPI) labelValuesHandler(w http.ResponseWriter, r *http.Request) {
+	labelName := chi.URLParam(r, "name")
+
+	start, end, limit, err := metadataParams(r)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	values, err := a.store.LabelValues(labelName, r.URL.Query()["match[]"], start, end)
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page, next := paginate(values, identity, r.URL.Query().Get("after"), limit)
+	a.respondMetadata(w, page, next, map[string]interface{}{"label": labelName})
+}
+
+func (a *RESTAPI) respondMetadata(w http.ResponseWriter, data interface{}, next string, extra map[string]interface{}) {
+	response := map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	}
+	if next != "" {
+		response["next"] = next
+	}
+	for k, v := range extra {
+		response[k] = v
+	}
+	a.respondJSON(w, http.StatusOK, response)
+}
+
+// metadataParams parses the start and end of a metadata query, the last
+// hour by default, and its limit
+func metadataParams(r *http.Request) (time.Time, time.Time, int, error) {
+	start := time.Now().Add(-1 * time.Hour)
+	if s := r.URL.Query().Get("start"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		start = t
+	}
+
+	end := time.Now()
+	if s := r.URL.Query().Get("end"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		end = t
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("end is before start")
+	}
+
+	limit, err := limitParam(r, defaultMetadataLimit)
+	return start, end, limit, err
+}
+
+// paginate returns up to limit items, sorted by key, that come after the
+// key after, and the key of the last one returned if more remain
+func paginate[T any](items []T, key func(T) string, after string, limit int) ([]T, string) {
+	if after != "" {
+		i := sort.Search(len(items), func(i int) bool { return key(items[i]) > after })
+		items = items[i:]
+	}
+	if limit == 0 || len(items) <= limit {
+		return items, ""
+	}
+	return items[:limit], key(items[limit-1])
+}
+
+func identity(s string) string { return s }
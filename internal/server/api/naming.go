@@ -0,0 +1,35 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+const defaultNamingLimit = 100
+
+// namingReportHandler lists received metrics that break the naming
+// conventions. Pass rule to only list violations of one convention.
+func (a *RESTAPI) namingReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.config.Naming.Enabled {
+		a.respondError(w, http.StatusNotFound, "naming checks are not enabled")
+		return
+	}
+
+	rule := r.URL.Query().Get("rule")
+	switch rule {
+	case "", utils.NamingRuleName, utils.NamingRuleLabel, utils.NamingRuleCounterSuffix, utils.NamingRuleUnitSuffix:
+	default:
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("unknown rule: %s", rule))
+		return
+	}
+
+	limit, err := limitParam(r, defaultNamingLimit)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, a.store.NamingReport(rule, limit))
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// NodeLabelsRequest sets and removes server-side labels on a node
+type NodeLabelsRequest struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// updateNodeLabelsHandler changes the labels added to every series a node
+// reports. Changes apply to metrics ingested from then on.
+func (a *RESTAPI) updateNodeLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	nodeID := chi.URLParam(r, "nodeID")
+
+	var req NodeLabelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	if len(req.Set) == 0 && len(req.Remove) == 0 {
+		a.respondError(w, http.StatusBadRequest, "set or remove is required")
+		return
+	}
+
+	if _, err := a.store.GetNode(nodeID); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	node, err := a.store.UpdateNodeLabels(nodeID, req.Set, req.Remove)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, node)
+}
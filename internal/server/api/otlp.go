@@ -0,0 +1,152 @@
+package api
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	otlpContentTypeProtobuf = "application/x-protobuf"
+	otlpContentTypeJSON     = "application/json"
+)
+
+// otlpMetricsHandler receives OTLP/HTTP metric exports, encoded as
+// protobuf or JSON and optionally gzipped. Responses and errors are
+// encoded like the request.
+func (a *RESTAPI) otlpMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != otlpContentTypeProtobuf && contentType != otlpContentTypeJSON {
+		a.respondOTLPError(w, otlpContentTypeJSON, http.StatusUnsupportedMediaType,
+			fmt.Sprintf("unsupported content type %q, expected %s or %s", contentType, otlpContentTypeProtobuf, otlpContentTypeJSON))
+		return
+	}
+
+	body, err := readOTLPBody(r, a.config.Server.OTLP.MaxRequestSize)
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, errOTLPTooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		a.respondOTLPError(w, contentType, code, err.Error())
+		return
+	}
+
+	data := new(metricspb.MetricsData)
+	if contentType == otlpContentTypeJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, data)
+	} else {
+		err = proto.Unmarshal(body, data)
+	}
+	if err != nil {
+		a.respondOTLPError(w, contentType, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	rejected, reason, err := a.store.ExportOTLPMetrics(data)
+	if err != nil {
+		// Exporters retry on 503
+		a.respondOTLPError(w, contentType, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	if contentType == otlpContentTypeJSON {
+		resp := map[string]interface{}{}
+		if rejected > 0 {
+			resp["partialSuccess"] = map[string]string{
+				"rejectedDataPoints": strconv.FormatInt(rejected, 10),
+				"errorMessage":       reason,
+			}
+		}
+		a.respondJSON(w, http.StatusOK, resp)
+		return
+	}
+	out, _ := proto.Marshal(&wrapperspb.BytesValue{Value: OTLPPartialSuccess(rejected, reason)})
+	w.Header().Set("Content-Type", otlpContentTypeProtobuf)
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+var errOTLPTooLarge = errors.New("request body too large")
+
+// readOTLPBody reads a request body of at most max bytes after gzip
+// decoding
+func readOTLPBody(r *http.Request, max int64) ([]byte, error) {
+	var reader io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", r.Header.Get("Content-Encoding"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, max+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if int64(len(body)) > max {
+		return nil, errOTLPTooLarge
+	}
+	return body, nil
+}
+
+// respondOTLPError writes an error as a google.rpc.Status, as OTLP/HTTP
+// specifies
+func (a *RESTAPI) respondOTLPError(w http.ResponseWriter, contentType string, code int, msg string) {
+	st := status.New(otlpStatusCode(code), msg).Proto()
+
+	var out []byte
+	if contentType == otlpContentTypeJSON {
+		out, _ = protojson.Marshal(st)
+	} else {
+		out, _ = proto.Marshal(st)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	if _, err := w.Write(out); err != nil {
+		a.logger.Debug("Failed to write OTLP error", zap.Error(err))
+	}
+}
+
+func otlpStatusCode(code int) codes.Code {
+	switch code {
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusRequestEntityTooLarge:
+		return codes.ResourceExhausted
+	default:
+		return codes.InvalidArgument
+	}
+}
+
+// OTLPPartialSuccess encodes the ExportMetricsPartialSuccess message of an
+// OTLP export response, or returns nil if no data points were rejected
+func OTLPPartialSuccess(rejected int64, reason string) []byte {
+	if rejected == 0 {
+		return nil
+	}
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(rejected))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendString(b, reason)
+}
@@ -0,0 +1,374 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/query"
+	"github.com/meettoy2004/lnmonja/pkg/version"
+)
+
+// The Prometheus HTTP API subset Grafana's Prometheus datasource uses.
+// Queries are lnmonja expressions: selectors and arithmetic.
+
+const (
+	// promLookback is how far back an instant query looks for the latest
+	// sample of each series, as in Prometheus
+	promLookback = 5 * time.Minute
+	// promMaxPoints caps the points per series of a range query, as in
+	// Prometheus
+	promMaxPoints = 11000
+)
+
+// promPoint is a sample encoded the Prometheus way: [<unix seconds>, "<value>"]
+type promPoint struct {
+	t time.Time
+	v float64
+}
+
+func (p promPoint) MarshalJSON() ([]byte, error) {
+	ts := strconv.FormatFloat(float64(p.t.UnixMilli())/1000, 'f', -1, 64)
+	return []byte(fmt.Sprintf("[%s,%q]", ts, strconv.FormatFloat(p.v, 'f', -1, 64))), nil
+}
+
+type promVectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  promPoint         `json:"value"`
+}
+
+type promMatrixSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values []promPoint       `json:"values"`
+}
+
+// promQueryHandler evaluates an instant query at time, now by default,
+// returning each series' latest sample within the lookback
+func (a *RESTAPI) promQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	expr, err := parsePromQuery(r.Form.Get("query"))
+	if err != nil {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+
+	ts := time.Now()
+	if s := r.Form.Get("time"); s != "" {
+		if ts, err = parsePromTime(s); err != nil {
+			a.respondPromError(w, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+	}
+
+	if v, ok := query.Scalar(expr); ok {
+		a.respondProm(w, map[string]interface{}{
+			"resultType": "scalar",
+			"result":     promPoint{ts, v},
+		})
+		return
+	}
+
+	a.store.RecordQueryUsage(r.Form.Get("query"))
+	// A millisecond step keeps each sample in its own bucket
+	series, err := a.evalPromQuery(expr, ts.Add(-promLookback), ts, time.Millisecond)
+	if err != nil {
+		a.respondPromError(w, http.StatusUnprocessableEntity, "execution", err)
+		return
+	}
+
+	result := make([]promVectorSample, 0, len(series))
+	for _, s := range series {
+		if len(s.Samples) == 0 {
+			continue
+		}
+		latest := s.Samples[len(s.Samples)-1]
+		result = append(result, promVectorSample{Metric: s.Labels, Value: promPoint{ts, latest.Value}})
+	}
+
+	a.respondProm(w, map[string]interface{}{
+		"resultType": "vector",
+		"result":     result,
+	})
+}
+
+// promQueryRangeHandler evaluates a range query, averaging samples over
+// each step
+func (a *RESTAPI) promQueryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	expr, err := parsePromQuery(r.Form.Get("query"))
+	if err != nil {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+
+	start, err := parsePromTime(r.Form.Get("start"))
+	if err != nil {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", fmt.Errorf("invalid start: %w", err))
+		return
+	}
+	end, err := parsePromTime(r.Form.Get("end"))
+	if err != nil {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", fmt.Errorf("invalid end: %w", err))
+		return
+	}
+	if end.Before(start) {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", fmt.Errorf("end timestamp must not be before start time"))
+		return
+	}
+	step, err := parsePromDuration(r.Form.Get("step"))
+	if err != nil || step <= 0 {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", fmt.Errorf("invalid step: %q", r.Form.Get("step")))
+		return
+	}
+	if end.Sub(start)/step > promMaxPoints {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data",
+			fmt.Errorf("exceeded maximum resolution of %d points per timeseries. Try decreasing the query resolution (?step=XX)", promMaxPoints))
+		return
+	}
+
+	if v, ok := query.Scalar(expr); ok {
+		values := []promPoint{}
+		for t := start; !t.After(end); t = t.Add(step) {
+			values = append(values, promPoint{t, v})
+		}
+		a.respondProm(w, map[string]interface{}{
+			"resultType": "matrix",
+			"result":     []promMatrixSeries{{Metric: map[string]string{}, Values: values}},
+		})
+		return
+	}
+
+	a.store.RecordQueryUsage(r.Form.Get("query"))
+	series, err := a.evalPromQuery(expr, start, end, step)
+	if err != nil {
+		a.respondPromError(w, http.StatusUnprocessableEntity, "execution", err)
+		return
+	}
+
+	result := make([]promMatrixSeries, 0, len(series))
+	for _, s := range series {
+		if len(s.Samples) == 0 {
+			continue
+		}
+		values := make([]promPoint, len(s.Samples))
+		for i, sample := range s.Samples {
+			values[i] = promPoint{sample.Timestamp, sample.Value}
+		}
+		result = append(result, promMatrixSeries{Metric: s.Labels, Values: values})
+	}
+
+	a.respondProm(w, map[string]interface{}{
+		"resultType": "matrix",
+		"result":     result,
+	})
+}
+
+// promSeriesHandler lists the label sets, __name__ included, of the series
+// matching the match[] selectors
+func (a *RESTAPI) promSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	start, end, limit, ok := a.promMetadataParams(w, r)
+	if !ok {
+		return
+	}
+	matchers := r.Form["match[]"]
+	if len(matchers) == 0 {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", fmt.Errorf("no match[] parameter provided"))
+		return
+	}
+
+	series, err := a.store.FindSeries(matchers, start, end)
+	if err != nil {
+		a.respondPromError(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if limit > 0 && len(series) > limit {
+		series = series[:limit]
+	}
+
+	data := make([]map[string]string, len(series))
+	for i, s := range series {
+		data[i] = promLabels(s.Labels, s.Name)
+	}
+	a.respondProm(w, data)
+}
+
+// promLabelsHandler lists label names, optionally of the series matching
+// the match[] selectors
+func (a *RESTAPI) promLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	start, end, limit, ok := a.promMetadataParams(w, r)
+	if !ok {
+		return
+	}
+
+	names, err := a.store.LabelNames(r.Form["match[]"], start, end)
+	if err != nil {
+		a.respondPromError(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+	a.respondProm(w, names)
+}
+
+// promLabelValuesHandler lists a label's values, optionally in the series
+// matching the match[] selectors
+func (a *RESTAPI) promLabelValuesHandler(w http.ResponseWriter, r *http.Request) {
+	start, end, limit, ok := a.promMetadataParams(w, r)
+	if !ok {
+		return
+	}
+
+	values, err := a.store.LabelValues(chi.URLParam(r, "name"), r.Form["match[]"], start, end)
+	if err != nil {
+		a.respondPromError(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if limit > 0 && len(values) > limit {
+		values = values[:limit]
+	}
+	a.respondProm(w, values)
+}
+
+// promBuildInfoHandler reports the version, which Grafana reads to detect
+// the features of the datasource
+func (a *RESTAPI) promBuildInfoHandler(w http.ResponseWriter, r *http.Request) {
+	info := version.Get()
+	a.respondProm(w, map[string]string{
+		"version":   info.Version,
+		"revision":  info.GitCommit,
+		"branch":    "",
+		"buildUser": "",
+		"buildDate": info.BuildTime,
+		"goVersion": runtime.Version(),
+	})
+}
+
+// promMetadataParams parses the start and end, the last hour by default,
+// and limit of a metadata request, responding with an error if invalid
+func (a *RESTAPI) promMetadataParams(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, int, bool) {
+	if err := r.ParseForm(); err != nil {
+		a.respondPromError(w, http.StatusBadRequest, "bad_data", err)
+		return time.Time{}, time.Time{}, 0, false
+	}
+
+	start, end := time.Now().Add(-1*time.Hour), time.Now()
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"start", &start}, {"end", &end}} {
+		s := r.Form.Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := parsePromTime(s)
+		if err != nil {
+			a.respondPromError(w, http.StatusBadRequest, "bad_data", fmt.Errorf("invalid %s: %w", p.name, err))
+			return time.Time{}, time.Time{}, 0, false
+		}
+		*p.t = t
+	}
+
+	var limit int
+	if s := r.Form.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			a.respondPromError(w, http.StatusBadRequest, "bad_data", fmt.Errorf("invalid limit: %s", s))
+			return time.Time{}, time.Time{}, 0, false
+		}
+		limit = n
+	}
+	return start, end, limit, true
+}
+
+// evalPromQuery evaluates an expression over [start, end]. Results of a
+// bare selector keep the metric name as __name__; those of arithmetic
+// drop it, as in Prometheus.
+func (a *RESTAPI) evalPromQuery(expr query.Expr, start, end time.Time, step time.Duration) ([]*models.TimeSeries, error) {
+	series, err := query.Eval(expr, func(sel *query.SelectorExpr) ([]*models.TimeSeries, error) {
+		return a.store.QueryMetrics(sel.Raw, start, end, step)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	name := ""
+	if sel, ok := expr.(*query.SelectorExpr); ok {
+		name = sel.Name
+	}
+	for _, s := range series {
+		s.Labels = promLabels(s.Labels, name)
+	}
+	return series, nil
+}
+
+// promLabels returns a copy of labels with __name__ set to name, if any.
+// It is never nil, so encodes as an object.
+func promLabels(labels map[string]string, name string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	if name != "" {
+		result["__name__"] = name
+	}
+	return result
+}
+
+func parsePromQuery(s string) (query.Expr, error) {
+	if s == "" {
+		return nil, fmt.Errorf("query parameter is required")
+	}
+	expr, err := query.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	return expr, nil
+}
+
+// parsePromTime parses Unix seconds with an optional fraction, as Grafana
+// sends them, or RFC3339
+func parsePromTime(s string) (time.Time, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1000))*int64(time.Millisecond)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parsePromDuration parses a duration such as 15s or a number of seconds
+func parsePromDuration(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (a *RESTAPI) respondProm(w http.ResponseWriter, data interface{}) {
+	a.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+func (a *RESTAPI) respondPromError(w http.ResponseWriter, status int, errorType string, err error) {
+	a.respondJSON(w, status, map[string]interface{}{
+		"status":    "error",
+		"errorType": errorType,
+		"error":     err.Error(),
+	})
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -11,22 +12,80 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
+	"github.com/meettoy2004/lnmonja/pkg/i18n"
 	"github.com/meettoy2004/lnmonja/pkg/utils"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
 	"go.uber.org/zap"
 )
 
 type RESTAPI struct {
-	config *utils.Config
-	store  Storage
-	logger *zap.Logger
-	router *chi.Mux
+	config    *utils.Config
+	store     Storage
+	logger    *zap.Logger
+	router    *chi.Mux
+	websocket *WebSocketServer
+	auth      *utils.Authenticator
+	// metrics times requests for the server's self-metrics
+	metrics *telemetry.Metrics
+	// catalog holds the dashboard's messages by locale
+	catalog *i18n.Catalog
 }
 
 type Storage interface {
 	QueryMetrics(query string, start, end time.Time, step time.Duration) ([]*models.TimeSeries, error)
+	FindSeries(matchers []string, start, end time.Time) ([]*models.Series, error)
+	LabelNames(matchers []string, start, end time.Time) ([]string, error)
+	LabelValues(label string, matchers []string, start, end time.Time) ([]string, error)
 	GetNodes() ([]*models.Node, error)
 	GetNode(nodeID string) (*models.Node, error)
+	UpdateNodeLabels(nodeID string, set map[string]string, remove []string) (*models.Node, error)
+	DecommissionNode(nodeID string, decommission *models.Decommission) (*models.Node, error)
+	ReactivateNode(nodeID string) (*models.Node, error)
+	DepartNode(nodeID string) (*models.Node, error)
 	GetAlerts(state string) ([]*models.Alert, error)
+	AlertEvents(after uint64, limit int) ([]*models.AlertEvent, error)
+	SubscribeAlertEvents() (<-chan *models.AlertEvent, uint64, func(), error)
+	ListDashboards(filter *models.DashboardFilter) ([]*models.Dashboard, error)
+	GetDashboard(id string) (*models.Dashboard, error)
+	CreateDashboard(dashboard *models.Dashboard) (*models.Dashboard, error)
+	UpdateDashboard(id string, dashboard *models.Dashboard) (*models.Dashboard, error)
+	DeleteDashboard(id string) error
+	DashboardVersions(id string) ([]*models.DashboardVersion, error)
+	DashboardVersion(id string, version int) (*models.Dashboard, error)
+	RestoreDashboardVersion(id string, version int, user string) (*models.Dashboard, error)
+	ImportDashboards(dashboards []*models.Dashboard, user string) ([]*models.Dashboard, error)
+	ListDerivedMetrics() ([]*models.DerivedMetric, error)
+	GetDerivedMetric(name string) (*models.DerivedMetric, error)
+	SaveDerivedMetric(def *models.DerivedMetric) (*models.DerivedMetric, error)
+	DeleteDerivedMetric(name string) error
+	ListIngestRules() ([]*models.IngestRule, error)
+	GetIngestRule(name string) (*models.IngestRule, error)
+	SaveIngestRule(rule *models.IngestRule) (*models.IngestRule, error)
+	DeleteIngestRule(name string) error
+	ExportOTLPMetrics(data *metricspb.MetricsData) (int64, string, error)
+	ListSilences(status string) ([]*models.Silence, error)
+	GetSilence(id string) (*models.Silence, error)
+	CreateSilence(silence *models.Silence) (*models.Silence, error)
+	UpdateSilence(id string, silence *models.Silence) (*models.Silence, error)
+	ExpireSilence(id string) (*models.Silence, error)
+	ReloadRules() error
+	ReloadConfig() (*models.ConfigReload, error)
+	GetAnnotations(filter *models.AnnotationFilter) ([]*models.Annotation, error)
+	SaveAnnotation(annotation *models.Annotation) error
+	DeleteAnnotation(id string) error
+	RecordQueryUsage(query string)
+	UsageReport(start, end time.Time) (*models.UsageReport, error)
+	UnusedSeries(start, end time.Time) (*models.UnusedSeriesReport, error)
+	TenantUsage(from, to, tenant string) (*models.TenantUsageReport, error)
+	TrendReport(from, to, groupBy string) (*models.TrendReport, error)
+	IngestStats(window time.Duration, limit int) *models.IngestStats
+	OverloadStatus() *models.OverloadStatus
+	NamingReport(rule string, limit int) *models.NamingReport
+	StorageUsage() (*models.StorageUsage, error)
+	MigrationStatus() *models.MigrationStatus
+	CollectNow(ctx context.Context, nodeID string, collectors []string) ([]*models.Metric, error)
+	UpdateCollectors(ctx context.Context, nodeID string, changes []*models.CollectorChange) ([]*models.CollectorState, error)
 	Ping() error
 }
 
@@ -36,6 +95,7 @@ func NewRESTAPI(config *utils.Config, store Storage, logger *zap.Logger) *RESTAP
 		store:  store,
 		logger: logger,
 		router: chi.NewRouter(),
+		auth:   utils.NewAuthenticator(&config.Authentication),
 	}
 
 	api.setupMiddleware()
@@ -44,6 +104,21 @@ func NewRESTAPI(config *utils.Config, store Storage, logger *zap.Logger) *RESTAP
 	return api
 }
 
+// SetMetrics records the duration of each request in metrics
+func (a *RESTAPI) SetMetrics(metrics *telemetry.Metrics) {
+	a.metrics = metrics
+}
+
+// SetWebSocket reports the WebSocket server's stats under /status and, if
+// serve is set, serves it at /ws behind the API middleware so it shares
+// authentication with the REST routes
+func (a *RESTAPI) SetWebSocket(ws *WebSocketServer, serve bool) {
+	a.websocket = ws
+	if serve {
+		a.router.Get("/ws", ws.ServeHTTP)
+	}
+}
+
 func (a *RESTAPI) setupMiddleware() {
 	// Request ID
 	a.router.Use(middleware.RequestID)
@@ -66,8 +141,11 @@ func (a *RESTAPI) setupMiddleware() {
 		a.router.Use(corsMiddleware.Handler)
 	}
 	
-	// Timeout
-	a.router.Use(middleware.Timeout(60 * time.Second))
+	// Timeout, except for long-lived streams
+	a.router.Use(skipStreams(middleware.Timeout(60 * time.Second)))
+
+	// Request durations, except for long-lived streams
+	a.router.Use(skipStreams(a.instrument))
 	
 	// Authentication (if enabled)
 	if a.config.Authentication.Enabled {
@@ -75,46 +153,193 @@ func (a *RESTAPI) setupMiddleware() {
 	}
 }
 
+// skipStreams applies a middleware to every request except the WebSocket,
+// Server-Sent Events and alert feed streams
+func skipStreams(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/ws", "/api/v1/events", "/api/v1/alerts/feed":
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
+// instrument records the duration of each request by its route pattern
+func (a *RESTAPI) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		a.metrics.ObserveRequest(route, r.Method, ww.Status(), time.Since(start))
+	})
+}
+
 func (a *RESTAPI) setupRoutes() {
 	// Health check
 	a.router.Get("/health", a.healthHandler)
 	a.router.Get("/ready", a.readyHandler)
 	
+	// Every authenticated caller can read; changes need the editor role
+	// and configuration and node lifecycle need the admin role
+	editor := a.requireRole(utils.RoleEditor)
+	admin := a.requireRole(utils.RoleAdmin)
+	if a.config.Storage.Replication.Role == utils.ReplicationRoleReplica {
+		// A read replica only serves reads; changes go to the primary
+		editor, admin = a.rejectWrites, a.rejectWrites
+	}
+
 	// API v1
 	a.router.Route("/api/v1", func(r chi.Router) {
+		// Version
+		r.Get("/version", a.versionHandler)
+
+		// Dashboard messages by locale
+		r.Route("/i18n", func(r chi.Router) {
+			r.Get("/locales", a.localesHandler)
+			r.Get("/messages", a.messagesHandler)
+		})
+
+		// Live updates for clients that cannot use WebSockets
+		r.Get("/events", a.eventsHandler)
+
 		// Nodes
 		r.Route("/nodes", func(r chi.Router) {
 			r.Get("/", a.listNodesHandler)
 			r.Get("/{nodeID}", a.getNodeHandler)
 			r.Get("/{nodeID}/metrics", a.getNodeMetricsHandler)
 			r.Get("/{nodeID}/alerts", a.getNodeAlertsHandler)
+			r.With(editor).Post("/{nodeID}/collect", a.collectNodeHandler)
+			r.With(admin).Patch("/{nodeID}/collectors", a.updateCollectorsHandler)
+			r.With(editor).Patch("/{nodeID}/labels", a.updateNodeLabelsHandler)
+			r.With(admin).Post("/{nodeID}/decommission", a.decommissionNodeHandler)
+			r.With(admin).Delete("/{nodeID}/decommission", a.reactivateNodeHandler)
+			r.With(admin).Post("/{nodeID}/scale-in", a.scaleInNodeHandler)
 		})
 		
 		// Metrics
 		r.Route("/metrics", func(r chi.Router) {
 			r.Get("/query", a.queryMetricsHandler)
+			r.Post("/query_batch", a.queryBatchHandler)
+			r.Post("/try", a.tryExpressionHandler)
 			r.Get("/series", a.seriesHandler)
 			r.Get("/labels", a.labelsHandler)
 			r.Get("/label/{name}/values", a.labelValuesHandler)
 		})
+
+		// Prometheus HTTP API, so Grafana can use lnmonja as a
+		// Prometheus datasource
+		r.Get("/query", a.promQueryHandler)
+		r.Post("/query", a.promQueryHandler)
+		r.Get("/query_range", a.promQueryRangeHandler)
+		r.Post("/query_range", a.promQueryRangeHandler)
+		r.Get("/series", a.promSeriesHandler)
+		r.Post("/series", a.promSeriesHandler)
+		r.Get("/labels", a.promLabelsHandler)
+		r.Post("/labels", a.promLabelsHandler)
+		r.Get("/label/{name}/values", a.promLabelValuesHandler)
+
+		// Derived metrics
+		r.Route("/derived", func(r chi.Router) {
+			r.Get("/", a.listDerivedMetricsHandler)
+			r.Get("/{name}", a.getDerivedMetricHandler)
+			r.With(editor).Put("/{name}", a.saveDerivedMetricHandler)
+			r.With(editor).Delete("/{name}", a.deleteDerivedMetricHandler)
+		})
 		
+		// Ingest rules
+		r.Route("/ingest-rules", func(r chi.Router) {
+			r.Get("/", a.listIngestRulesHandler)
+			r.Get("/{name}", a.getIngestRuleHandler)
+			r.With(admin).Put("/{name}", a.saveIngestRuleHandler)
+			r.With(admin).Delete("/{name}", a.deleteIngestRuleHandler)
+		})
+
 		// Alerts
 		r.Route("/alerts", func(r chi.Router) {
 			r.Get("/", a.listAlertsHandler)
-			r.Post("/silence", a.silenceAlertHandler)
-			r.Delete("/silence/{id}", a.deleteSilenceHandler)
+			r.Get("/feed", a.alertFeedHandler)
+			r.Route("/silences", func(r chi.Router) {
+				r.Get("/", a.listSilencesHandler)
+				r.With(editor).Post("/", a.createSilenceHandler)
+				r.Get("/{id}", a.getSilenceHandler)
+				r.With(editor).Put("/{id}", a.updateSilenceHandler)
+				r.With(editor).Delete("/{id}", a.expireSilenceHandler)
+			})
+		})
+		
+		// Annotations
+		r.Route("/annotations", func(r chi.Router) {
+			r.Get("/", a.listAnnotationsHandler)
+			r.With(editor).Post("/", a.createAnnotationHandler)
+			r.With(editor).Delete("/{id}", a.deleteAnnotationHandler)
+		})
+
+		// Grafana JSON datasource compatibility
+		r.Route("/grafana", func(r chi.Router) {
+			r.Post("/annotations", a.grafanaAnnotationsHandler)
 		})
 		
 		// Dashboards
 		r.Route("/dashboards", func(r chi.Router) {
 			r.Get("/", a.listDashboardsHandler)
 			r.Get("/{id}", a.getDashboardHandler)
-			r.Post("/", a.createDashboardHandler)
-			r.Put("/{id}", a.updateDashboardHandler)
-			r.Delete("/{id}", a.deleteDashboardHandler)
+			r.With(editor).Post("/", a.createDashboardHandler)
+			r.With(editor).Put("/{id}", a.updateDashboardHandler)
+			r.With(editor).Delete("/{id}", a.deleteDashboardHandler)
+			r.Get("/{id}/export", a.exportDashboardHandler)
+			r.With(editor).Post("/import", a.importDashboardsHandler)
+			r.With(editor).Post("/import/grafana", a.importGrafanaDashboardHandler)
+			r.Get("/{id}/versions", a.listDashboardVersionsHandler)
+			r.Get("/{id}/versions/{version}", a.getDashboardVersionHandler)
+			r.With(editor).Post("/{id}/versions/{version}/restore", a.restoreDashboardVersionHandler)
+		})
+
+		// Alert rules
+		r.Route("/rules", func(r chi.Router) {
+			r.With(admin).Post("/reload", a.reloadRulesHandler)
+		})
+
+		// Server administration
+		r.Route("/admin", func(r chi.Router) {
+			r.With(admin).Post("/config/reload", a.reloadConfigHandler)
+		})
+
+		// Server status
+		r.Route("/status", func(r chi.Router) {
+			r.Get("/ingest", a.ingestStatusHandler)
+			r.Get("/overload", a.overloadStatusHandler)
+			r.Get("/storage", a.storageStatusHandler)
+			r.Get("/migration", a.migrationStatusHandler)
+			r.Get("/websocket", a.websocketStatusHandler)
+			r.Get("/buildinfo", a.promBuildInfoHandler)
+		})
+
+		// Reports
+		r.Route("/reports", func(r chi.Router) {
+			r.Get("/compliance", a.complianceReportHandler)
+			r.Get("/usage", a.usageReportHandler)
+			r.Get("/unused-series", a.unusedSeriesHandler)
+			r.Get("/tenant-usage", a.tenantUsageHandler)
+			r.Get("/trends", a.trendReportHandler)
+			r.Get("/naming", a.namingReportHandler)
 		})
 	})
 	
+	// OpenTelemetry metrics, at the path OTLP/HTTP exporters use
+	if a.config.Server.OTLP.Enabled {
+		a.router.With(editor).Post("/v1/metrics", a.otlpMetricsHandler)
+	}
+
 	// Static files for dashboard
 	if a.config.Server.HTTP.Static.Enabled {
 		a.router.Handle("/*", http.FileServer(http.Dir(a.config.Server.HTTP.Static.Path)))
@@ -201,19 +426,51 @@ func (a *RESTAPI) queryMetricsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	
+	// Resolve $__interval, $__range and var-<name> user variables
+	query, err := expandQuery(query, start, end, step, queryVariables(r.URL.Query()))
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	
+	// Optional comparison offsets, e.g. offsets=1d,7d
+	offsets, err := parseOffsets(r.URL.Query().Get("offsets"))
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	
+	downsample, err := a.downsampleOptionsFor(r.URL.Query())
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	
 	// Execute query
-	series, err := a.store.QueryMetrics(query, start, end, step)
+	series, err := a.queryWithOffsets(query, start, end, step, offsets)
 	if err != nil {
 		a.respondError(w, http.StatusBadRequest, err)
 		return
 	}
 	
+	// Keep long ranges cheap to render
+	series, downsampled := downsample.downsample(series)
+	
+	data := map[string]interface{}{
+		"resultType": "matrix",
+		"result":     series,
+	}
+	if downsampled {
+		data["downsampled"] = true
+		data["downsample"] = map[string]interface{}{
+			"method":     downsample.Method,
+			"max_points": downsample.MaxPoints,
+		}
+	}
+	
 	response := map[string]interface{}{
 		"status": "success",
-		"data": map[string]interface{}{
-			"resultType": "matrix",
-			"result":     series,
-		},
+		"data":   data,
 	}
 	
 	a.respondJSON(w, http.StatusOK, response)
@@ -231,6 +488,8 @@ func (a *RESTAPI) listAlertsHandler(w http.ResponseWriter, r *http.Request) {
 	a.respondJSON(w, http.StatusOK, alerts)
 }
 
+// authMiddleware authenticates every request except health checks and
+// live updates and stores the caller's principal in the request context
 func (a *RESTAPI) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip auth for health checks
@@ -238,33 +497,54 @@ func (a *RESTAPI) authMiddleware(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
-		
-		// Get API key from header
-		apiKey := r.Header.Get("X-API-Key")
-		if apiKey == "" {
-			apiKey = r.URL.Query().Get("api_key")
+		// Live updates authenticate themselves, accepting WebSocket
+		// tokens and keys sent as a subprotocol too
+		if r.URL.Path == "/ws" || r.URL.Path == "/api/v1/events" {
+			next.ServeHTTP(w, r)
+			return
 		}
-		
-		// Validate API key
-		if !a.validateAPIKey(apiKey) {
+
+		principal, err := a.auth.Request(r)
+		if err != nil {
 			a.respondJSON(w, http.StatusUnauthorized, map[string]string{
-				"error": "Invalid API key",
+				"error": "Invalid API key or credentials",
 			})
 			return
 		}
-		
-		next.ServeHTTP(w, r)
+
+		next.ServeHTTP(w, r.WithContext(utils.WithPrincipal(r.Context(), principal)))
 	})
 }
 
-func (a *RESTAPI) validateAPIKey(apiKey string) bool {
-	// Check against configured API keys
-	for _, key := range a.config.Authentication.APIKeys {
-		if key == apiKey {
-			return true
-		}
+// requireRole rejects requests whose principal lacks the role. Requests
+// pass when authentication is disabled.
+func (a *RESTAPI) requireRole(role utils.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.auth.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			principal, ok := utils.PrincipalFromContext(r.Context())
+			if !ok || !principal.Role.Allows(role) {
+				a.respondJSON(w, http.StatusForbidden, map[string]string{
+					"error": fmt.Sprintf("%s role required", role),
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
-	return false
+}
+
+// rejectWrites rejects every request, on the routes that change state of
+// a read replica
+func (a *RESTAPI) rejectWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.respondJSON(w, http.StatusMethodNotAllowed, map[string]string{
+			"error": "this server is a read-only replica; send changes to the primary",
+		})
+	})
 }
 
 func (a *RESTAPI) respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -348,6 +628,13 @@ func (a *RESTAPI) getNodeMetricsHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	query, err := expandQuery(query, start, end, step, queryVariables(r.URL.Query()))
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.store.RecordQueryUsage(query)
 	series, err := a.store.QueryMetrics(query, start, end, step)
 	if err != nil {
 		a.respondError(w, http.StatusInternalServerError, err)
@@ -378,105 +665,6 @@ func (a *RESTAPI) getNodeAlertsHandler(w http.ResponseWriter, r *http.Request) {
 	a.respondJSON(w, http.StatusOK, nodeAlerts)
 }
 
-func (a *RESTAPI) seriesHandler(w http.ResponseWriter, r *http.Request) {
-	// Get all unique metric series
-	// This is a simplified implementation
-	a.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   []string{},
-	})
-}
-
-func (a *RESTAPI) labelsHandler(w http.ResponseWriter, r *http.Request) {
-	// Get all label names
-	// This is a simplified implementation
-	a.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   []string{"node", "collector", "metric"},
-	})
-}
-
-func (a *RESTAPI) labelValuesHandler(w http.ResponseWriter, r *http.Request) {
-	labelName := chi.URLParam(r, "name")
-
-	// Get all values for this label
-	// This is a simplified implementation
-	a.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   []string{},
-		"label":  labelName,
-	})
-}
-
-func (a *RESTAPI) silenceAlertHandler(w http.ResponseWriter, r *http.Request) {
-	// Silence an alert
-	// This is a simplified implementation
-	a.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"status":  "success",
-		"message": "Alert silenced",
-	})
-}
-
-func (a *RESTAPI) deleteSilenceHandler(w http.ResponseWriter, r *http.Request) {
-	silenceID := chi.URLParam(r, "id")
-
-	// Delete a silence
-	a.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"status":  "success",
-		"message": fmt.Sprintf("Silence %s deleted", silenceID),
-	})
-}
-
-func (a *RESTAPI) listDashboardsHandler(w http.ResponseWriter, r *http.Request) {
-	// List all dashboards
-	// This is a simplified implementation
-	a.respondJSON(w, http.StatusOK, []interface{}{})
-}
-
-func (a *RESTAPI) getDashboardHandler(w http.ResponseWriter, r *http.Request) {
-	dashboardID := chi.URLParam(r, "id")
-
-	// Get dashboard by ID
-	a.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"id":   dashboardID,
-		"name": "Dashboard",
-	})
-}
-
-func (a *RESTAPI) createDashboardHandler(w http.ResponseWriter, r *http.Request) {
-	var dashboard models.Dashboard
-	if err := json.NewDecoder(r.Body).Decode(&dashboard); err != nil {
-		a.respondError(w, http.StatusBadRequest, err)
-		return
-	}
-
-	// Create dashboard
-	a.respondJSON(w, http.StatusCreated, dashboard)
-}
-
-func (a *RESTAPI) updateDashboardHandler(w http.ResponseWriter, r *http.Request) {
-	dashboardID := chi.URLParam(r, "id")
-
-	var dashboard models.Dashboard
-	if err := json.NewDecoder(r.Body).Decode(&dashboard); err != nil {
-		a.respondError(w, http.StatusBadRequest, err)
-		return
-	}
-
-	dashboard.ID = dashboardID
-	a.respondJSON(w, http.StatusOK, dashboard)
-}
-
-func (a *RESTAPI) deleteDashboardHandler(w http.ResponseWriter, r *http.Request) {
-	dashboardID := chi.URLParam(r, "id")
-
-	// Delete dashboard
-	a.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"status":  "success",
-		"message": fmt.Sprintf("Dashboard %s deleted", dashboardID),
-	})
-}
-
 func (a *RESTAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.router.ServeHTTP(w, r)
 }
\ No newline at end of file
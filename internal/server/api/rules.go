@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// reloadRulesHandler reloads the alert rule files, as SIGHUP does
+func (a *RESTAPI) reloadRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.store.ReloadRules(); err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if principal, ok := utils.PrincipalFromContext(r.Context()); ok {
+		a.logger.Info("Alert rules reloaded", zap.String("by", principal.Name))
+	}
+
+	a.respondJSON(w, http.StatusOK, map[string]string{
+		"status": "reloaded",
+	})
+}
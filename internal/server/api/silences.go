@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// listSilencesHandler lists silences, filtered by ?status=pending, active
+// or expired
+func (a *RESTAPI) listSilencesHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.SilenceStatusPending, models.SilenceStatusActive, models.SilenceStatusExpired:
+	default:
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid status: %q", status))
+		return
+	}
+
+	silences, err := a.store.ListSilences(status)
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, silences)
+}
+
+func (a *RESTAPI) getSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	silence, err := a.store.GetSilence(chi.URLParam(r, "id"))
+	if err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, silence)
+}
+
+func (a *RESTAPI) createSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	var silence models.Silence
+	if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Record who silenced the alerts rather than trusting the request
+	if principal, ok := utils.PrincipalFromContext(r.Context()); ok && a.auth.Enabled() {
+		silence.CreatedBy = principal.Name
+	}
+
+	saved, err := a.store.CreateSilence(&silence)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusCreated, saved)
+}
+
+func (a *RESTAPI) updateSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := a.store.GetSilence(id); err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var silence models.Silence
+	if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	saved, err := a.store.UpdateSilence(id, &silence)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, saved)
+}
+
+// expireSilenceHandler ends a silence. Expired silences are kept for the
+// configured retention.
+func (a *RESTAPI) expireSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	silence, err := a.store.ExpireSilence(chi.URLParam(r, "id"))
+	if err != nil {
+		a.respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, silence)
+}
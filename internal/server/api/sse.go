@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const ssePingInterval = 30 * time.Second
+
+// eventsHandler streams live updates as Server-Sent Events, for clients
+// behind proxies that block WebSockets
+func (a *RESTAPI) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.websocket == nil {
+		a.respondError(w, http.StatusNotFound, "live updates are not enabled")
+		return
+	}
+	a.websocket.ServeSSE(w, r)
+}
+
+// ServeSSE streams the topics named by the topics query parameter (comma
+// separated or repeated, default all) as Server-Sent Events, limited to
+// the nodes and metrics parameters' glob patterns when set. Each event's
+// data is the same JSON message sent to WebSocket clients.
+func (ws *WebSocketServer) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	query := r.URL.Query()
+	topics := queryList(query["topics"])
+	if len(topics) == 0 {
+		topics = []string{"all"}
+	}
+	filter, err := newTopicFilter(queryList(query["nodes"]), queryList(query["metrics"]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	subs := make([]subscription, len(topics))
+	for i, topic := range topics {
+		subs[i] = subscription{topic: topic, filter: filter}
+	}
+
+	principal, err := ws.authenticate(r)
+	if err != nil {
+		http.Error(w, "Invalid API key or credentials", http.StatusUnauthorized)
+		return
+	}
+	client := ws.newClient(transportSSE, r.RemoteAddr, principal)
+	if denied := client.subscribe(subs); len(denied) > 0 {
+		http.Error(w, fmt.Sprintf("permission denied for topics: %s", strings.Join(denied, ", ")), http.StatusForbidden)
+		return
+	}
+
+	if !ws.addClient(client) {
+		http.Error(w, "Too many live update clients", http.StatusServiceUnavailable)
+		return
+	}
+	defer ws.removeClient(client, websocket.CloseNormalClosure, "")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if err := rc.Flush(); err != nil {
+		ws.logger.Error("Streaming not supported for SSE client", zap.Error(err))
+		return
+	}
+
+	ws.logger.Info("New SSE client connected",
+		zap.String("remote_addr", r.RemoteAddr),
+		zap.Strings("topics", topics),
+	)
+
+	ticker := time.NewTicker(ssePingInterval)
+	defer ticker.Stop()
+
+	// write sends a chunk under a write deadline, replacing the server's
+	// write timeout, which would otherwise end the stream
+	write := func(chunk string) bool {
+		rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := fmt.Fprint(w, chunk); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.done:
+			return
+
+		case <-client.queue.notify:
+			messages := client.queue.drain()
+			if len(messages) == 0 {
+				continue
+			}
+
+			var b strings.Builder
+			for _, message := range messages {
+				b.WriteString("data: ")
+				b.Write(message)
+				b.WriteString("\n\n")
+			}
+			if !write(b.String()) {
+				return
+			}
+			client.sent.Add(uint64(len(messages)))
+			ws.delivered.Add(uint64(len(messages)))
+
+		case <-ticker.C:
+			if !write(": ping\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// queryList splits query parameter values that are comma separated or
+// repeated
+func queryList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultIngestWindow = 5 * time.Minute
+	maxIngestWindow     = 15 * time.Minute
+	defaultIngestLimit  = 20
+	defaultStorageLimit = 20
+	defaultClientLimit  = 20
+)
+
+// ingestStatusHandler reports samples/sec, bytes/sec and active series by
+// node and metric name over a recent window
+func (a *RESTAPI) ingestStatusHandler(w http.ResponseWriter, r *http.Request) {
+	window := defaultIngestWindow
+	if s := r.URL.Query().Get("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 || d > maxIngestWindow {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid window: %s (max %s)", s, maxIngestWindow))
+			return
+		}
+		window = d
+	}
+
+	limit, err := limitParam(r, defaultIngestLimit)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, a.store.IngestStats(window, limit))
+}
+
+// overloadStatusHandler reports whether ingestion is shedding metrics and
+// how many of each priority it shed
+func (a *RESTAPI) overloadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	a.respondJSON(w, http.StatusOK, a.store.OverloadStatus())
+}
+
+// storageStatusHandler reports the latest estimate of disk usage per metric
+// name and node
+func (a *RESTAPI) storageStatusHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r, defaultStorageLimit)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	usage, err := a.store.StorageUsage()
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Truncate a copy so the cached estimate keeps every entry
+	result := *usage
+	if limit > 0 && len(result.Metrics) > limit {
+		result.Metrics = result.Metrics[:limit]
+	}
+	if limit > 0 && len(result.Nodes) > limit {
+		result.Nodes = result.Nodes[:limit]
+	}
+
+	a.respondJSON(w, http.StatusOK, &result)
+}
+
+// migrationStatusHandler reports how far the storage migration has
+// backfilled the new store and how its query results compare
+func (a *RESTAPI) migrationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	a.respondJSON(w, http.StatusOK, a.store.MigrationStatus())
+}
+
+// websocketStatusHandler reports WebSocket fan-out counters and the
+// slowest clients
+func (a *RESTAPI) websocketStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if a.websocket == nil {
+		a.respondError(w, http.StatusNotFound, "WebSocket server is not running")
+		return
+	}
+
+	limit, err := limitParam(r, defaultClientLimit)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, a.websocket.Stats(limit))
+}
+
+// limitParam parses the limit query parameter; 0 means no limit
+func limitParam(r *http.Request, def int) (int, error) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid limit: %s", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// defaultTrendMonths is how many months a trend report covers, ending with
+// the current one, unless from is given
+const defaultTrendMonths = 6
+
+// trendCSVHeader are the columns of a trend report CSV export
+var trendCSVHeader = []string{
+	"month", "key", "nodes", "cpu_avg", "cpu_max", "disk_used_bytes", "disk_growth_bytes", "alerts",
+	"cpu_avg_change", "cpu_max_change", "disk_used_bytes_change", "disk_growth_bytes_change", "alerts_change",
+}
+
+// trendReportHandler reports the monthly CPU, disk and alert aggregates of
+// each node, or of the groups of group_by (instance_group or a node
+// label), with month-over-month changes. Months from and to (2006-01)
+// default to the last six, through the current one.
+func (a *RESTAPI) trendReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.config.Trends.Enabled {
+		a.respondError(w, http.StatusNotFound, "trends are not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	now := time.Now().UTC()
+	to := now.Format("2006-01")
+	from := time.Date(now.Year(), now.Month()-(defaultTrendMonths-1), 1, 0, 0, 0, 0, time.UTC).Format("2006-01")
+	if s := q.Get("to"); s != "" && q.Get("from") == "" {
+		// Keep the default length when only to is given
+		if t, err := time.Parse("2006-01", s); err == nil {
+			from = t.AddDate(0, 1-defaultTrendMonths, 0).Format("2006-01")
+		}
+	}
+	for name, value := range map[string]*string{"from": &from, "to": &to} {
+		if s := q.Get(name); s != "" {
+			if _, err := time.Parse("2006-01", s); err != nil {
+				a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %s (want YYYY-MM)", name, s))
+				return
+			}
+			*value = s
+		}
+	}
+	if to < from {
+		a.respondError(w, http.StatusBadRequest, "to is before from")
+		return
+	}
+
+	report, err := a.store.TrendReport(from, to, q.Get("group_by"))
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch format := q.Get("format"); format {
+	case "", "json":
+		a.respondJSON(w, http.StatusOK, report)
+	case "csv":
+		writeTrendCSV(w, report)
+	default:
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("unknown format: %s", format))
+	}
+}
+
+// writeTrendCSV writes a row per node or group and month. Change columns
+// are empty when the previous month has no data.
+func writeTrendCSV(w http.ResponseWriter, report *models.TrendReport) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("trends-%s-%s.csv", report.From, report.To)))
+	w.WriteHeader(http.StatusOK)
+
+	float := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+	cw := csv.NewWriter(w)
+	cw.Write(trendCSVHeader)
+	for _, s := range report.Series {
+		for _, m := range s.Months {
+			row := []string{
+				m.Month,
+				s.Key,
+				strconv.Itoa(m.Nodes),
+				float(m.CPUAvg),
+				float(m.CPUMax),
+				float(m.DiskUsedBytes),
+				float(m.DiskGrowthBytes),
+				strconv.FormatInt(m.Alerts, 10),
+				"", "", "", "", "",
+			}
+			if c := m.Change; c != nil {
+				copy(row[8:], []string{
+					float(c.CPUAvg),
+					float(c.CPUMax),
+					float(c.DiskUsedBytes),
+					float(c.DiskGrowthBytes),
+					strconv.FormatInt(c.Alerts, 10),
+				})
+			}
+			cw.Write(row)
+		}
+	}
+	cw.Flush()
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/query"
+)
+
+const (
+	// maxTrySeries limits the inline series of a playground request
+	maxTrySeries = 1000
+	// maxTrySamples limits the inline samples of a playground request
+	maxTrySamples = 100000
+)
+
+// TryRequest is the body of POST /api/v1/metrics/try. Without Data the
+// expression is evaluated against stored samples in [Start, End]; with
+// it, against the inline series only.
+type TryRequest struct {
+	Expression string            `json:"expression"`
+	Start      string            `json:"start,omitempty"`
+	End        string            `json:"end,omitempty"`
+	Step       string            `json:"step,omitempty"`
+	Vars       map[string]string `json:"vars,omitempty"`
+	Data       []*TrySeries      `json:"data,omitempty"`
+	// Condition is an alert rule condition checked against the result
+	Condition *TryCondition `json:"condition,omitempty"`
+}
+
+// TrySeries is an inline series of a playground request
+type TrySeries struct {
+	Name    string            `json:"name"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Samples []models.Sample   `json:"samples"`
+}
+
+// TryCondition compares each result sample with a threshold, as an alert
+// rule does
+type TryCondition struct {
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+}
+
+// TryResponse is the evaluation of a playground expression
+type TryResponse struct {
+	// Expression is the expression as parsed, with every operation in
+	// parentheses
+	Expression string `json:"expression"`
+	// Source is storage or inline
+	Source     string               `json:"source"`
+	Scalar     *float64             `json:"scalar,omitempty"`
+	Result     []*models.TimeSeries `json:"result"`
+	Steps      []*query.Step        `json:"steps"`
+	Condition  []*TryConditionMatch `json:"condition,omitempty"`
+	Error      string               `json:"error,omitempty"`
+	DurationMs float64              `json:"duration_ms"`
+}
+
+// TryConditionMatch reports how a result series meets the condition
+type TryConditionMatch struct {
+	Labels map[string]string `json:"labels"`
+	// Latest is the last sample, which alert rules evaluate
+	Latest *models.Sample `json:"latest,omitempty"`
+	// Firing is whether the latest sample meets the condition
+	Firing bool `json:"firing"`
+	// Matching counts the samples that meet it
+	Matching int `json:"matching"`
+	Samples  int `json:"samples"`
+}
+
+// tryExpressionHandler evaluates an expression step by step, against
+// stored samples or inline ones, for the query playground and rule
+// authoring. Evaluation errors are reported with the steps that ran.
+func (a *RESTAPI) tryExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	var req TryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Expression == "" {
+		a.respondError(w, http.StatusBadRequest, "expression is required")
+		return
+	}
+	if req.Condition != nil && !validOperator(req.Condition.Operator) {
+		a.respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid condition operator %q", req.Condition.Operator))
+		return
+	}
+
+	start := time.Now().Add(-1 * time.Hour)
+	if req.Start != "" {
+		ts, err := parseTime(req.Start)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		start = ts
+	}
+
+	end := time.Now()
+	if req.End != "" {
+		ts, err := parseTime(req.End)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		end = ts
+	}
+
+	step := 15 * time.Second
+	if req.Step != "" {
+		d, err := time.ParseDuration(req.Step)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		step = d
+	}
+
+	expanded, err := expandQuery(req.Expression, start, end, step, req.Vars)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	expr, err := query.Parse(expanded)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid expression: %w", err))
+		return
+	}
+
+	resp := &TryResponse{Expression: expr.String(), Source: "storage"}
+	fetch := func(sel *query.SelectorExpr) ([]*models.TimeSeries, error) {
+		return a.store.QueryMetrics(sel.Raw, start, end, step)
+	}
+	if req.Data != nil {
+		if fetch, err = inlineFetch(req.Data); err != nil {
+			a.respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		resp.Source = "inline"
+	}
+
+	began := time.Now()
+	series, scalar, steps, err := query.Trace(expr, fetch)
+	resp.DurationMs = float64(time.Since(began).Microseconds()) / 1000
+	resp.Steps = steps
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	resp.Scalar = scalar
+	resp.Result = series
+	if resp.Result == nil {
+		resp.Result = []*models.TimeSeries{}
+	}
+	if req.Condition != nil {
+		resp.Condition = checkCondition(req.Condition, series)
+	}
+
+	a.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   resp,
+	})
+}
+
+// inlineFetch returns the inline series matching a selector, with their
+// samples in time order
+func inlineFetch(data []*TrySeries) (query.FetchFunc, error) {
+	if len(data) > maxTrySeries {
+		return nil, fmt.Errorf("too many inline series: %d (max %d)", len(data), maxTrySeries)
+	}
+	samples := 0
+	for i, s := range data {
+		if s == nil || s.Name == "" {
+			return nil, fmt.Errorf("inline series %d has no name", i)
+		}
+		samples += len(s.Samples)
+		sort.Slice(s.Samples, func(i, j int) bool {
+			return s.Samples[i].Timestamp.Before(s.Samples[j].Timestamp)
+		})
+	}
+	if samples > maxTrySamples {
+		return nil, fmt.Errorf("too many inline samples: %d (max %d)", samples, maxTrySamples)
+	}
+
+	return func(sel *query.SelectorExpr) ([]*models.TimeSeries, error) {
+		var series []*models.TimeSeries
+		for _, s := range data {
+			if s.Name != sel.Name || !hasLabels(s.Labels, sel.Labels) {
+				continue
+			}
+			series = append(series, &models.TimeSeries{Labels: s.Labels, Samples: s.Samples})
+		}
+		return series, nil
+	}, nil
+}
+
+// hasLabels reports whether labels contains every matcher
+func hasLabels(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCondition compares the samples of each series with the condition
+func checkCondition(cond *TryCondition, series []*models.TimeSeries) []*TryConditionMatch {
+	matches := make([]*TryConditionMatch, 0, len(series))
+	for _, s := range series {
+		m := &TryConditionMatch{Labels: s.Labels, Samples: len(s.Samples)}
+		for _, sample := range s.Samples {
+			if compare(cond.Operator, sample.Value, cond.Threshold) {
+				m.Matching++
+			}
+		}
+		if n := len(s.Samples); n > 0 {
+			latest := s.Samples[n-1]
+			m.Latest = &latest
+			m.Firing = compare(cond.Operator, latest.Value, cond.Threshold)
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+func validOperator(op string) bool {
+	switch op {
+	case ">", "<", ">=", "<=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+// compare applies an alert rule operator
+func compare(op string, value, threshold float64) bool {
+	switch op {
+	case ">":
+		return value > threshold
+	case "<":
+		return value < threshold
+	case ">=":
+		return value >= threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
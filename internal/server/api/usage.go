@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// defaultUsageLookback is how far back stored series are considered
+const defaultUsageLookback = 24 * time.Hour
+
+// usageReportHandler reports how often each metric name is used
+func (a *RESTAPI) usageReportHandler(w http.ResponseWriter, r *http.Request) {
+	start, end, err := usageRange(r)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := a.store.UsageReport(start, end)
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, report)
+}
+
+// unusedSeriesHandler lists stored series that no query, rule or export uses
+func (a *RESTAPI) unusedSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	start, end, err := usageRange(r)
+	if err != nil {
+		a.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := a.store.UnusedSeries(start, end)
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.respondJSON(w, http.StatusOK, report)
+}
+
+// usageRange returns the range of stored series to consider, from the
+// lookback parameter (e.g. "7d")
+func usageRange(r *http.Request) (time.Time, time.Time, error) {
+	lookback := defaultUsageLookback
+	if s := r.URL.Query().Get("lookback"); s != "" {
+		d, err := parseExtendedDuration(s)
+		if err != nil || d <= 0 {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid lookback: %s", s)
+		}
+		lookback = d
+	}
+
+	end := time.Now()
+	return end.Add(-lookback), end, nil
+}
+
+// tenantUsageCSVHeader are the columns of a tenant usage CSV export
+var tenantUsageCSVHeader = []string{
+	"day", "tenant", "ingested_samples", "ingested_bytes", "stored_bytes", "stored_series", "query_samples",
+}
+
+// tenantUsageHandler reports each tenant's daily usage, from the first of
+// the month to today unless from and to (2006-01-02) are given. With
+// format=csv a row per tenant and day is returned, for chargeback.
+func (a *RESTAPI) tenantUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.config.Metering.Enabled {
+		a.respondError(w, http.StatusNotFound, "metering is not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	now := time.Now().UTC()
+	from := now.AddDate(0, 0, 1-now.Day()).Format("2006-01-02")
+	to := now.Format("2006-01-02")
+	for name, value := range map[string]*string{"from": &from, "to": &to} {
+		if s := q.Get(name); s != "" {
+			if _, err := time.Parse("2006-01-02", s); err != nil {
+				a.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %s (want YYYY-MM-DD)", name, s))
+				return
+			}
+			*value = s
+		}
+	}
+	if to < from {
+		a.respondError(w, http.StatusBadRequest, "to is before from")
+		return
+	}
+
+	report, err := a.store.TenantUsage(from, to, q.Get("tenant"))
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch format := q.Get("format"); format {
+	case "", "json":
+		a.respondJSON(w, http.StatusOK, report)
+	case "csv":
+		writeTenantUsageCSV(w, report)
+	default:
+		a.respondError(w, http.StatusBadRequest, fmt.Errorf("unknown format: %s", format))
+	}
+}
+
+// writeTenantUsageCSV writes a row per tenant and day
+func writeTenantUsageCSV(w http.ResponseWriter, report *models.TenantUsageReport) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("tenant-usage-%s-%s.csv", report.From, report.To)))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write(tenantUsageCSVHeader)
+	for _, u := range report.Days {
+		cw.Write([]string{
+			u.Day,
+			u.Tenant,
+			strconv.FormatInt(u.IngestedSamples, 10),
+			strconv.FormatInt(u.IngestedBytes, 10),
+			strconv.FormatInt(u.StoredBytes, 10),
+			strconv.FormatInt(u.StoredSeries, 10),
+			strconv.FormatInt(u.QuerySamples, 10),
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/meettoy2004/lnmonja/pkg/version"
+)
+
+// VersionResponse reports the server build and the versions agents
+// reported at registration
+type VersionResponse struct {
+	Server version.Info    `json:"server"`
+	Agents []*AgentVersion `json:"agents"`
+	Nodes  []*NodeVersion  `json:"nodes"`
+	// Skew is true when agents run more than one version or a version
+	// different from the server
+	Skew bool `json:"skew"`
+}
+
+// AgentVersion counts nodes running one agent build
+type AgentVersion struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit,omitempty"`
+	Nodes     int    `json:"nodes"`
+}
+
+// NodeVersion is the agent build reported by a node
+type NodeVersion struct {
+	NodeID    string        `json:"node_id"`
+	Hostname  string        `json:"hostname"`
+	Version   string        `json:"version"`
+	BuildInfo *version.Info `json:"build_info,omitempty"`
+}
+
+func (a *RESTAPI) versionHandler(w http.ResponseWriter, r *http.Request) {
+	nodes, err := a.store.GetNodes()
+	if err != nil {
+		a.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := &VersionResponse{
+		Server: version.Get(),
+		Agents: make([]*AgentVersion, 0),
+		Nodes:  make([]*NodeVersion, 0, len(nodes)),
+	}
+
+	builds := make(map[[2]string]*AgentVersion)
+	for _, node := range nodes {
+		resp.Nodes = append(resp.Nodes, &NodeVersion{
+			NodeID:    node.ID,
+			Hostname:  node.Hostname,
+			Version:   node.Version,
+			BuildInfo: node.BuildInfo,
+		})
+
+		var commit string
+		if node.BuildInfo != nil {
+			commit = node.BuildInfo.GitCommit
+		}
+		key := [2]string{node.Version, commit}
+		if builds[key] == nil {
+			builds[key] = &AgentVersion{Version: node.Version, GitCommit: commit}
+			resp.Agents = append(resp.Agents, builds[key])
+		}
+		builds[key].Nodes++
+
+		if node.Version != resp.Server.Version {
+			resp.Skew = true
+		}
+	}
+	if len(resp.Agents) > 1 {
+		resp.Skew = true
+	}
+
+	sort.Slice(resp.Agents, func(i, j int) bool {
+		if resp.Agents[i].Nodes != resp.Agents[j].Nodes {
+			return resp.Agents[i].Nodes > resp.Agents[j].Nodes
+		}
+		return resp.Agents[i].Version < resp.Agents[j].Version
+	})
+	sort.Slice(resp.Nodes, func(i, j int) bool {
+		return resp.Nodes[i].NodeID < resp.Nodes[j].NodeID
+	})
+
+	a.respondJSON(w, http.StatusOK, resp)
+}
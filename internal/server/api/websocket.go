@@ -3,13 +3,19 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"maps"
+	"math"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/meettoy2004/lnmonja/internal/models"
 	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
 	"go.uber.org/zap"
 )
 
@@ -18,21 +24,103 @@ type WebSocketServer struct {
 	upgrader  websocket.Upgrader
 	clients   map[*WebSocketClient]bool
 	clientsMu sync.RWMutex
-	broadcast chan *WSMessage
+	workers   []chan *WSMessage
 	store     storage.Storage
 	logger    *zap.Logger
+	auth      *utils.Authenticator
+	allowed   *utils.Allowlist
 	ctx       context.Context
 	cancel    context.CancelFunc
 	wg        sync.WaitGroup
+
+	// Per-client queueing
+	queueSize  int
+	dropOldest bool
+	coalesce   map[string]bool
+
+	// Access: tokens granting the viewer role, the browser origins
+	// allowed, and limits on clients and the messages each is sent
+	tokens         []string
+	origins        []string
+	maxConnections int
+	rateLimit      float64
+	rateBurst      int
+	maxMessageSize int64
+
+	// Fan-out counters
+	broadcasts        atomic.Uint64
+	broadcastsDropped atomic.Uint64
+	delivered         atomic.Uint64
+	dropped           atomic.Uint64
+	coalesced         atomic.Uint64
+	slowDisconnects   atomic.Uint64
+	rateLimited       atomic.Uint64
+	rejected          atomic.Uint64
 }
 
-// WebSocketClient represents a connected WebSocket client
+// Client transports
+const (
+	transportWebSocket = "websocket"
+	transportSSE       = "sse"
+)
+
+// WebSocketClient represents a connected live-update client. Server-Sent
+// Events clients share the queue and subscriptions but have no conn.
 type WebSocketClient struct {
-	conn       *websocket.Conn
-	send       chan []byte
-	server     *WebSocketServer
-	subscriptions map[string]bool
-	subsMu     sync.RWMutex
+	conn          *websocket.Conn
+	queue         *clientQueue
+	server        *WebSocketServer
+	subscriptions map[string]*topicFilter
+	subsMu        sync.RWMutex
+	transport     string
+	remoteAddr    string
+	connectedAt   time.Time
+	sent          atomic.Uint64
+	// limiter drops the broadcasts sent beyond the client rate limit
+	limiter     *rateLimiter
+	rateLimited atomic.Uint64
+	// principal is the authenticated caller; its role limits the topics
+	// the client may subscribe to
+	principal *utils.Principal
+
+	// done is closed when the client is removed; writePump then sends
+	// closeCode and closes the connection
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+}
+
+// WebSocketStats reports fan-out counters and per-client queue state
+type WebSocketStats struct {
+	Clients           int                     `json:"clients"`
+	QueueSize         int                     `json:"queue_size"`
+	SlowClientPolicy  string                  `json:"slow_client_policy"`
+	Broadcasts        uint64                  `json:"broadcasts"`
+	BroadcastsDropped uint64                  `json:"broadcasts_dropped"`
+	Delivered         uint64                  `json:"delivered"`
+	Dropped           uint64                  `json:"dropped"`
+	Coalesced         uint64                  `json:"coalesced"`
+	SlowDisconnects   uint64                  `json:"slow_disconnects"`
+	RateLimited       uint64                  `json:"rate_limited"`
+	MaxConnections    int                     `json:"max_connections,omitempty"`
+	Rejected          uint64                  `json:"rejected_connections"`
+	SlowClients       int                     `json:"slow_clients"`
+	ClientStats       []*WebSocketClientStats `json:"client_stats"`
+}
+
+// WebSocketClientStats is the queue state of one client. A client is slow
+// when its queue is at least half full.
+type WebSocketClientStats struct {
+	Transport   string    `json:"transport"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	QueueDepth  int       `json:"queue_depth"`
+	Sent        uint64    `json:"sent"`
+	Dropped     uint64    `json:"dropped"`
+	Coalesced   uint64    `json:"coalesced"`
+	RateLimited uint64    `json:"rate_limited"`
+	Slow        bool      `json:"slow"`
 }
 
 // WSMessage represents a WebSocket message
@@ -44,50 +132,120 @@ type WSMessage struct {
 }
 
 // NewWebSocketServer creates a new WebSocket server
-func NewWebSocketServer(store storage.Storage, logger *zap.Logger) *WebSocketServer {
+func NewWebSocketServer(config *utils.Config, store storage.Storage, logger *zap.Logger) *WebSocketServer {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	wsConfig := config.Server.WebSocket
+	queueSize := wsConfig.ClientQueueSize
+	if queueSize <= 0 {
+		queueSize = 256
+	}
+	coalesce := make(map[string]bool, len(wsConfig.CoalesceTopics))
+	for _, topic := range wsConfig.CoalesceTopics {
+		coalesce[topic] = true
+	}
+	// Browsers allowed to call the API may connect too
+	allowedOrigins := wsConfig.AllowedOrigins
+	if len(allowedOrigins) == 0 && config.Server.HTTP.CORS.Enabled {
+		allowedOrigins = config.Server.HTTP.CORS.AllowedOrigins
+	}
+	origins := make([]string, len(allowedOrigins))
+	for i, origin := range allowedOrigins {
+		origins[i] = strings.ToLower(origin)
+	}
+	rateBurst := wsConfig.ClientRateBurst
+	if rateBurst <= 0 {
+		rateBurst = int(math.Ceil(wsConfig.ClientRateLimit))
+	}
+
 	ws := &WebSocketServer{
 		upgrader: websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins in development
-			},
+			ReadBufferSize:  wsConfig.ReadBufferSize,
+			WriteBufferSize: wsConfig.WriteBufferSize,
+			Subprotocols:    []string{wsProtocol},
 		},
-		clients:   make(map[*WebSocketClient]bool),
-		broadcast: make(chan *WSMessage, 1000),
-		store:     store,
-		logger:    logger,
-		ctx:       ctx,
-		cancel:    cancel,
+		clients: make(map[*WebSocketClient]bool),
+		store:   store,
+		logger:  logger,
+		auth:    utils.NewAuthenticator(&config.Authentication),
+		ctx:     ctx,
+		cancel:  cancel,
+
+		queueSize:  queueSize,
+		dropOldest: wsConfig.SlowClientPolicy != utils.WebSocketPolicyDisconnect,
+		coalesce:   coalesce,
+
+		tokens:         wsConfig.Tokens,
+		origins:        origins,
+		maxConnections: wsConfig.MaxConnections,
+		rateLimit:      wsConfig.ClientRateLimit,
+		rateBurst:      rateBurst,
+		maxMessageSize: wsConfig.MaxMessageSize,
 	}
+	ws.upgrader.CheckOrigin = ws.checkOrigin
 
-	// Start broadcast handler
-	ws.wg.Add(1)
-	go ws.handleBroadcasts()
+	// Start the fan-out workers
+	workers := wsConfig.FanOutWorkers
+	if workers <= 0 {
+		workers = 4
+	}
+	for i := 0; i < workers; i++ {
+		worker := make(chan *WSMessage, fanOutQueueSize)
+		ws.workers = append(ws.workers, worker)
+		ws.wg.Add(1)
+		go ws.fanOutWorker(worker)
+	}
 
 	return ws
 }
 
+// SetAllowlist restricts clients to the allowed networks. The standalone
+// listener enforces them too; this covers /ws on the HTTP port.
+func (ws *WebSocketServer) SetAllowlist(allowed *utils.Allowlist) {
+	ws.allowed = allowed
+}
+
+// topicRoles is the role needed to subscribe to each topic. Topics not
+// listed need the admin role.
+var topicRoles = map[string]utils.Role{
+	"all":         utils.RoleViewer,
+	"metrics":     utils.RoleViewer,
+	"alert":       utils.RoleViewer,
+	"node_status": utils.RoleViewer,
+}
+
 // ServeHTTP handles WebSocket upgrade requests
 func (ws *WebSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if !ws.allowed.AllowsAddr(r.RemoteAddr) {
+		ws.logger.Named("audit").Warn("Rejected connection from outside the allowed networks",
+			zap.String("listener", "websocket"),
+			zap.String("remote_addr", r.RemoteAddr),
+		)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	principal, err := ws.authenticate(r)
 	if err != nil {
-		ws.logger.Error("Failed to upgrade connection", zap.Error(err))
+		http.Error(w, "Invalid API key or credentials", http.StatusUnauthorized)
 		return
 	}
 
-	client := &WebSocketClient{
-		conn:          conn,
-		send:          make(chan []byte, 256),
-		server:        ws,
-		subscriptions: make(map[string]bool),
+	// The client is added before the upgrade so it counts against
+	// max_connections; it is only sent messages once writePump starts
+	client := ws.newClient(transportWebSocket, r.RemoteAddr, principal)
+	if !ws.addClient(client) {
+		http.Error(w, "Too many live update clients", http.StatusServiceUnavailable)
+		return
 	}
 
-	ws.clientsMu.Lock()
-	ws.clients[client] = true
-	ws.clientsMu.Unlock()
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		ws.removeClient(client, websocket.CloseNormalClosure, "")
+		ws.logger.Warn("Failed to upgrade connection", zap.Error(err))
+		return
+	}
+	client.conn = conn
 
 	ws.logger.Info("New WebSocket client connected",
 		zap.String("remote_addr", r.RemoteAddr),
@@ -98,101 +256,241 @@ func (ws *WebSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	go client.readPump()
 }
 
-// handleBroadcasts handles broadcasting messages to all clients
-func (ws *WebSocketServer) handleBroadcasts() {
+func (ws *WebSocketServer) newClient(transport, remoteAddr string, principal *utils.Principal) *WebSocketClient {
+	return &WebSocketClient{
+		principal:     principal,
+		queue:         newClientQueue(ws.queueSize),
+		server:        ws,
+		subscriptions: make(map[string]*topicFilter),
+		transport:     transport,
+		remoteAddr:    remoteAddr,
+		connectedAt:   time.Now(),
+		limiter:       newRateLimiter(ws.rateLimit, ws.rateBurst),
+		done:          make(chan struct{}),
+	}
+}
+
+// addClient adds a client, reporting false if max_connections clients
+// are already connected
+func (ws *WebSocketServer) addClient(client *WebSocketClient) bool {
+	ws.clientsMu.Lock()
+	defer ws.clientsMu.Unlock()
+	if ws.maxConnections > 0 && len(ws.clients) >= ws.maxConnections {
+		ws.rejected.Add(1)
+		ws.logger.Warn("Rejected live update client, too many connected",
+			zap.String("remote_addr", client.remoteAddr),
+			zap.Int("max_connections", ws.maxConnections),
+		)
+		return false
+	}
+	ws.clients[client] = true
+	return true
+}
+
+// fanOutQueueSize is how many messages each fan-out worker buffers
+const fanOutQueueSize = 256
+
+// fanOutWorker fans messages out to the queues of subscribed clients.
+// Each worker takes the messages of some nodes, so those of one node stay
+// in order.
+func (ws *WebSocketServer) fanOutWorker(messages <-chan *WSMessage) {
 	defer ws.wg.Done()
 
 	for {
 		select {
 		case <-ws.ctx.Done():
 			return
-		case message := <-ws.broadcast:
-			ws.clientsMu.RLock()
-			for client := range ws.clients {
-				// Check if client is subscribed to this message type
-				if !client.isSubscribed(message.Type) && !client.isSubscribed("all") {
-					continue
-				}
+		case message := <-messages:
+			ws.fanOut(message)
+		}
+	}
+}
 
-				data, err := json.Marshal(message)
-				if err != nil {
-					ws.logger.Error("Failed to marshal message", zap.Error(err))
-					continue
-				}
+// publish hands a message to the fan-out worker of its node, dropping it
+// if that worker is behind, so publishers never wait for clients
+func (ws *WebSocketServer) publish(message *WSMessage) {
+	// FNV-1a of the node ID
+	h := uint32(2166136261)
+	for i := 0; i < len(message.NodeID); i++ {
+		h ^= uint32(message.NodeID[i])
+		h *= 16777619
+	}
 
-				select {
-				case client.send <- data:
-				default:
-					// Client send buffer is full, close connection
-					ws.removeClient(client)
-				}
+	select {
+	case ws.workers[h%uint32(len(ws.workers))] <- message:
+	default:
+		if dropped := ws.broadcastsDropped.Add(1); dropped == 1 || dropped%1000 == 0 {
+			ws.logger.Warn("Fan-out queue full, dropping live updates",
+				zap.String("type", message.Type),
+				zap.Uint64("dropped", dropped),
+			)
+		}
+	}
+}
+
+func (ws *WebSocketServer) fanOut(message *WSMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		ws.logger.Error("Failed to marshal message", zap.Error(err))
+		return
+	}
+	ws.broadcasts.Add(1)
+
+	// Coalesce state updates per topic and node
+	key := ""
+	if ws.coalesce[message.Type] {
+		key = message.Type + "/" + message.NodeID
+	}
+
+	// Clients with the same filter share the filtered message; nil data
+	// means the filter let none of it through
+	filtered := make(map[string][]byte)
+
+	var slow []*WebSocketClient
+	ws.clientsMu.RLock()
+	for client := range ws.clients {
+		// Check if client is subscribed to this message type
+		filter, ok := client.subscription(message.Type)
+		if !ok {
+			if filter, ok = client.subscription("all"); !ok {
+				continue
 			}
-			ws.clientsMu.RUnlock()
 		}
+
+		clientData := data
+		if filter != nil {
+			var cached bool
+			if clientData, cached = filtered[filter.key()]; !cached {
+				clientData = ws.filter(filter, message, data)
+				filtered[filter.key()] = clientData
+			}
+			if clientData == nil {
+				continue
+			}
+		}
+		if !client.limiter.allow() {
+			client.rateLimited.Add(1)
+			ws.rateLimited.Add(1)
+			continue
+		}
+		if !ws.enqueue(client, key, clientData) {
+			slow = append(slow, client)
+		}
+	}
+	ws.clientsMu.RUnlock()
+
+	for _, client := range slow {
+		ws.slowDisconnects.Add(1)
+		ws.logger.Warn("Disconnecting slow WebSocket client",
+			zap.String("remote_addr", client.remoteAddr),
+			zap.Int("queue_size", ws.queueSize),
+		)
+		ws.removeClient(client, websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+// filter returns the encoded part of a message a filter lets through, or
+// nil if none is
+func (ws *WebSocketServer) filter(filter *topicFilter, message *WSMessage, data []byte) []byte {
+	kept := filter.apply(message)
+	switch kept {
+	case nil:
+		return nil
+	case message:
+		return data
+	}
+	encoded, err := json.Marshal(kept)
+	if err != nil {
+		ws.logger.Error("Failed to marshal message", zap.Error(err))
+		return nil
 	}
+	return encoded
 }
 
-// BroadcastMetrics broadcasts metrics to subscribed clients
+// enqueue queues a message for a client, reporting false when the client
+// must be disconnected under the disconnect policy
+func (ws *WebSocketServer) enqueue(client *WebSocketClient, key string, data []byte) bool {
+	switch client.queue.push(key, data, ws.dropOldest) {
+	case pushCoalesced:
+		ws.coalesced.Add(1)
+	case pushDroppedOldest:
+		if ws.dropped.Add(1); client.dropped() == 1 {
+			ws.logger.Warn("Slow WebSocket client, dropping oldest messages",
+				zap.String("remote_addr", client.remoteAddr),
+				zap.Int("queue_size", ws.queueSize),
+			)
+		}
+	case pushFull:
+		return false
+	}
+	return true
+}
+
+// BroadcastMetrics broadcasts a batch of one node's metrics to subscribed
+// clients. The metrics are copied, since ingestion reuses them once the
+// batch is stored.
 func (ws *WebSocketServer) BroadcastMetrics(metrics []*models.Metric) {
-	if len(metrics) == 0 {
+	if len(metrics) == 0 || ws.GetConnectedClients() == 0 {
 		return
 	}
 
-	message := &WSMessage{
-		Type:      "metrics",
-		Timestamp: time.Now(),
-		Data:      metrics,
+	copies := make([]models.Metric, len(metrics))
+	snapshot := make([]*models.Metric, len(metrics))
+	for i, metric := range metrics {
+		copies[i] = *metric
+		snapshot[i] = &copies[i]
 	}
 
-	select {
-	case ws.broadcast <- message:
-	default:
-		ws.logger.Warn("Broadcast channel full, dropping metrics update")
-	}
+	ws.publish(&WSMessage{
+		Type:      "metrics",
+		Timestamp: time.Now(),
+		Data:      snapshot,
+		NodeID:    metrics[0].NodeID,
+	})
 }
 
-// BroadcastAlert broadcasts an alert to all clients
+// BroadcastAlert broadcasts an alert's state to all clients
 func (ws *WebSocketServer) BroadcastAlert(alert *models.Alert) {
-	message := &WSMessage{
+	if ws.GetConnectedClients() == 0 {
+		return
+	}
+
+	snapshot := *alert
+	ws.publish(&WSMessage{
 		Type:      "alert",
 		Timestamp: time.Now(),
-		Data:      alert,
+		Data:      &snapshot,
 		NodeID:    alert.Labels["node"],
-	}
-
-	select {
-	case ws.broadcast <- message:
-	default:
-		ws.logger.Warn("Broadcast channel full, dropping alert")
-	}
+	})
 }
 
 // BroadcastNodeStatus broadcasts node status changes
 func (ws *WebSocketServer) BroadcastNodeStatus(node *models.Node) {
-	message := &WSMessage{
+	if ws.GetConnectedClients() == 0 {
+		return
+	}
+
+	// The node manager goes on changing the node
+	snapshot := *node
+	snapshot.Labels = maps.Clone(node.Labels)
+	snapshot.ServerLabels = maps.Clone(node.ServerLabels)
+	snapshot.Inventory = maps.Clone(node.Inventory)
+	ws.publish(&WSMessage{
 		Type:      "node_status",
 		Timestamp: time.Now(),
-		Data:      node,
+		Data:      &snapshot,
 		NodeID:    node.ID,
-	}
-
-	select {
-	case ws.broadcast <- message:
-	default:
-		ws.logger.Warn("Broadcast channel full, dropping node status")
-	}
+	})
 }
 
-// removeClient removes a client from the server
-func (ws *WebSocketServer) removeClient(client *WebSocketClient) {
+// removeClient removes a client from the server and closes its connection
+// with the given close code
+func (ws *WebSocketServer) removeClient(client *WebSocketClient, code int, text string) {
 	ws.clientsMu.Lock()
-	defer ws.clientsMu.Unlock()
+	delete(ws.clients, client)
+	ws.clientsMu.Unlock()
 
-	if _, ok := ws.clients[client]; ok {
-		delete(ws.clients, client)
-		close(client.send)
-		client.conn.Close()
-	}
+	client.close(code, text)
 }
 
 // Close closes the WebSocket server
@@ -201,24 +499,85 @@ func (ws *WebSocketServer) Close() error {
 	ws.wg.Wait()
 
 	ws.clientsMu.Lock()
-	defer ws.clientsMu.Unlock()
+	clients := ws.clients
+	ws.clients = make(map[*WebSocketClient]bool)
+	ws.clientsMu.Unlock()
 
-	for client := range ws.clients {
-		client.conn.Close()
-		close(client.send)
+	for client := range clients {
+		client.close(websocket.CloseGoingAway, "server shutting down")
 	}
 
 	return nil
 }
 
+// Stats returns fan-out counters and the queue state of up to limit
+// clients, slowest first. A limit of 0 lists every client.
+func (ws *WebSocketServer) Stats(limit int) *WebSocketStats {
+	stats := &WebSocketStats{
+		QueueSize:         ws.queueSize,
+		SlowClientPolicy:  utils.WebSocketPolicyDropOldest,
+		Broadcasts:        ws.broadcasts.Load(),
+		BroadcastsDropped: ws.broadcastsDropped.Load(),
+		Delivered:         ws.delivered.Load(),
+		Dropped:           ws.dropped.Load(),
+		Coalesced:         ws.coalesced.Load(),
+		SlowDisconnects:   ws.slowDisconnects.Load(),
+		RateLimited:       ws.rateLimited.Load(),
+		MaxConnections:    ws.maxConnections,
+		Rejected:          ws.rejected.Load(),
+		ClientStats:       []*WebSocketClientStats{},
+	}
+	if !ws.dropOldest {
+		stats.SlowClientPolicy = utils.WebSocketPolicyDisconnect
+	}
+
+	ws.clientsMu.RLock()
+	for client := range ws.clients {
+		depth, dropped, coalesced := client.queue.stats()
+		cs := &WebSocketClientStats{
+			Transport:   client.transport,
+			RemoteAddr:  client.remoteAddr,
+			ConnectedAt: client.connectedAt,
+			QueueDepth:  depth,
+			Sent:        client.sent.Load(),
+			Dropped:     dropped,
+			Coalesced:   coalesced,
+			RateLimited: client.rateLimited.Load(),
+			Slow:        depth*2 >= ws.queueSize,
+		}
+		if cs.Slow {
+			stats.SlowClients++
+		}
+		stats.ClientStats = append(stats.ClientStats, cs)
+	}
+	ws.clientsMu.RUnlock()
+
+	stats.Clients = len(stats.ClientStats)
+	sort.Slice(stats.ClientStats, func(i, j int) bool {
+		a, b := stats.ClientStats[i], stats.ClientStats[j]
+		if a.QueueDepth != b.QueueDepth {
+			return a.QueueDepth > b.QueueDepth
+		}
+		return a.Dropped > b.Dropped
+	})
+	if limit > 0 && len(stats.ClientStats) > limit {
+		stats.ClientStats = stats.ClientStats[:limit]
+	}
+
+	return stats
+}
+
 // Client methods
 
 // readPump reads messages from the WebSocket connection
 func (c *WebSocketClient) readPump() {
 	defer func() {
-		c.server.removeClient(c)
+		c.server.removeClient(c, websocket.CloseNormalClosure, "")
 	}()
 
+	if c.server.maxMessageSize > 0 {
+		c.conn.SetReadLimit(c.server.maxMessageSize)
+	}
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -239,7 +598,7 @@ func (c *WebSocketClient) readPump() {
 	}
 }
 
-// writePump writes messages to the WebSocket connection
+// writePump writes queued messages to the WebSocket connection
 func (c *WebSocketClient) writePump() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
@@ -249,29 +608,36 @@ func (c *WebSocketClient) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
+		case <-c.done:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText))
+			return
+
+		case <-c.queue.notify:
+			messages := c.queue.drain()
+			if len(messages) == 0 {
+				continue
 			}
 
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
 			}
-			w.Write(message)
 
-			// Add queued messages to current websocket message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
+			// Send everything queued as one websocket message
+			for i, message := range messages {
+				if i > 0 {
+					w.Write([]byte{'\n'})
+				}
+				w.Write(message)
 			}
 
 			if err := w.Close(); err != nil {
 				return
 			}
+			c.sent.Add(uint64(len(messages)))
+			c.server.delivered.Add(uint64(len(messages)))
 
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
@@ -282,11 +648,29 @@ func (c *WebSocketClient) writePump() {
 	}
 }
 
+// close stops the client's writePump, which sends a close message and
+// closes the connection
+func (c *WebSocketClient) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// dropped returns how many messages were dropped for the client
+func (c *WebSocketClient) dropped() uint64 {
+	_, dropped, _ := c.queue.stats()
+	return dropped
+}
+
 // handleMessage handles messages from the client
 func (c *WebSocketClient) handleMessage(data []byte) {
 	var msg struct {
-		Type   string   `json:"type"`
-		Topics []string `json:"topics"`
+		Type string `json:"type"`
+		// Topics are topic names or filtered subscriptions; see
+		// parseSubscriptions
+		Topics []json.RawMessage `json:"topics"`
 	}
 
 	if err := json.Unmarshal(data, &msg); err != nil {
@@ -296,9 +680,16 @@ func (c *WebSocketClient) handleMessage(data []byte) {
 
 	switch msg.Type {
 	case "subscribe":
-		c.subscribe(msg.Topics)
+		subs, invalid := parseSubscriptions(msg.Topics)
+		if len(invalid) > 0 {
+			c.sendError("invalid subscription", invalid)
+		}
+		if denied := c.subscribe(subs); len(denied) > 0 {
+			c.sendError("permission denied", denied)
+		}
 	case "unsubscribe":
-		c.unsubscribe(msg.Topics)
+		subs, _ := parseSubscriptions(msg.Topics)
+		c.unsubscribe(subs)
 	case "ping":
 		c.sendPong()
 	default:
@@ -306,36 +697,73 @@ func (c *WebSocketClient) handleMessage(data []byte) {
 	}
 }
 
-// subscribe subscribes the client to topics
-func (c *WebSocketClient) subscribe(topics []string) {
+// subscribe subscribes the client to the topics its role allows and
+// returns the topics it was denied. Subscribing to a topic again replaces
+// its filter.
+func (c *WebSocketClient) subscribe(subs []subscription) []string {
 	c.subsMu.Lock()
 	defer c.subsMu.Unlock()
 
-	for _, topic := range topics {
-		c.subscriptions[topic] = true
+	var topics, denied []string
+	for _, sub := range subs {
+		topics = append(topics, sub.topic)
+		required, ok := topicRoles[sub.topic]
+		if !ok {
+			required = utils.RoleAdmin
+		}
+		if !c.principal.Role.Allows(required) {
+			denied = append(denied, sub.topic)
+			continue
+		}
+		c.subscriptions[sub.topic] = sub.filter
 	}
 
-	c.server.logger.Debug("Client subscribed", zap.Strings("topics", topics))
+	c.server.logger.Debug("Client subscribed",
+		zap.Strings("topics", topics),
+		zap.Strings("denied", denied),
+	)
+	return denied
 }
 
 // unsubscribe unsubscribes the client from topics
-func (c *WebSocketClient) unsubscribe(topics []string) {
+func (c *WebSocketClient) unsubscribe(subs []subscription) {
 	c.subsMu.Lock()
 	defer c.subsMu.Unlock()
 
-	for _, topic := range topics {
-		delete(c.subscriptions, topic)
+	var topics []string
+	for _, sub := range subs {
+		topics = append(topics, sub.topic)
+		delete(c.subscriptions, sub.topic)
 	}
 
 	c.server.logger.Debug("Client unsubscribed", zap.Strings("topics", topics))
 }
 
-// isSubscribed checks if the client is subscribed to a topic
-func (c *WebSocketClient) isSubscribed(topic string) bool {
+// subscription reports whether the client is subscribed to a topic, and
+// the filter limiting it, which is nil when there is none
+func (c *WebSocketClient) subscription(topic string) (*topicFilter, bool) {
 	c.subsMu.RLock()
 	defer c.subsMu.RUnlock()
 
-	return c.subscriptions[topic]
+	filter, ok := c.subscriptions[topic]
+	return filter, ok
+}
+
+// sendError tells the client a request failed for the given topics
+func (c *WebSocketClient) sendError(message string, topics []string) {
+	response := map[string]interface{}{
+		"type":   "error",
+		"error":  message,
+		"topics": topics,
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+
+	if !c.server.enqueue(c, "", data) {
+		c.server.removeClient(c, websocket.CloseTryAgainLater, "client too slow")
+	}
 }
 
 // sendPong sends a pong response
@@ -346,9 +774,8 @@ func (c *WebSocketClient) sendPong() {
 		return
 	}
 
-	select {
-	case c.send <- data:
-	default:
+	if !c.server.enqueue(c, "", data) {
+		c.server.removeClient(c, websocket.CloseTryAgainLater, "client too slow")
 	}
 }
 
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// wsProtocol is the subprotocol the server accepts; browsers, which
+	// cannot set headers on a WebSocket, offer it along with their key as
+	// wsKeyProtocol followed by the key
+	wsProtocol    = "lnmonja"
+	wsKeyProtocol = "lnmonja.key."
+)
+
+// tokenPrincipal is the caller of a live update request with one of the
+// WebSocket tokens
+var tokenPrincipal = &utils.Principal{Name: "websocket-token", Role: utils.RoleViewer}
+
+// authenticate returns the caller of a live update request. A client with
+// one of the WebSocket tokens is a viewer; others authenticate like the
+// REST API. With tokens set, clients must present one or an API key even
+// when authentication is disabled.
+func (ws *WebSocketServer) authenticate(r *http.Request) (*utils.Principal, error) {
+	if principal, ok := utils.PrincipalFromContext(r.Context()); ok {
+		return principal, nil
+	}
+
+	key := liveCredential(r)
+	if key != "" && ws.isToken(key) {
+		return tokenPrincipal, nil
+	}
+	if len(ws.tokens) > 0 && !ws.auth.Enabled() {
+		return nil, utils.ErrUnauthenticated
+	}
+	if key, ok := protocolKey(r); ok {
+		return ws.auth.APIKey(key)
+	}
+	return ws.auth.Request(r)
+}
+
+// isToken reports whether key is one of the WebSocket tokens, comparing
+// every token so the time taken does not reveal a match
+func (ws *WebSocketServer) isToken(key string) bool {
+	found := false
+	for _, token := range ws.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// liveCredential returns the key a live update request carries in its
+// X-API-Key header, api_key parameter, bearer token or key subprotocol
+func liveCredential(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if key := r.URL.Query().Get("api_key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	key, _ := protocolKey(r)
+	return key
+}
+
+// protocolKey returns the key a WebSocket request offers as a subprotocol
+func protocolKey(r *http.Request) (string, bool) {
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if key, ok := strings.CutPrefix(strings.TrimSpace(protocol), wsKeyProtocol); ok {
+				return key, true
+			}
+		}
+	}
+	return "", false
+}
+
+// checkOrigin accepts requests from the allowed origins, glob patterns
+// like https://*.example.com or *, or with none configured, from the
+// server's own origin. Requests without an Origin are not from browsers
+// and are accepted.
+func (ws *WebSocketServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	allowed := false
+	if len(ws.origins) == 0 {
+		u, err := url.Parse(origin)
+		allowed = err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, pattern := range ws.origins {
+		if ok, _ := path.Match(pattern, strings.ToLower(origin)); ok || pattern == "*" {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		ws.logger.Named("audit").Warn("Rejected WebSocket connection from a disallowed origin",
+			zap.String("origin", origin),
+			zap.String("remote_addr", r.RemoteAddr),
+		)
+	}
+	return allowed
+}
+
+// rateLimiter is a token bucket limiting the messages sent to a client
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing rate messages a second, in
+// bursts of up to burst, or nil if rate is not positive
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// allow reports whether a message may be sent now, taking a token if so.
+// A nil limiter allows everything.
+func (l *rateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
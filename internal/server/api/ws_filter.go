@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// subscription is a topic a client subscribes to, limited by filter when
+// it is set
+type subscription struct {
+	topic  string
+	filter *topicFilter
+}
+
+// topicFilter limits a subscription to the messages about some nodes and,
+// for metrics, some metric names. Both are lists of glob patterns; an
+// empty list matches everything.
+type topicFilter struct {
+	nodes   []string
+	metrics []string
+}
+
+// newTopicFilter compiles a filter, returning nil when it matches
+// everything
+func newTopicFilter(nodes, metrics []string) (*topicFilter, error) {
+	if len(nodes) == 0 && len(metrics) == 0 {
+		return nil, nil
+	}
+	for _, pattern := range append(append([]string{}, nodes...), metrics...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return &topicFilter{nodes: nodes, metrics: metrics}, nil
+}
+
+// parseSubscriptions parses the topics of a subscribe or unsubscribe
+// message: topic names, or objects naming a topic with its filter, like
+// {"type":"metrics","nodes":["web-01"],"metrics":["system_cpu_*"]}. It
+// returns the entries that could not be parsed separately.
+func parseSubscriptions(topics []json.RawMessage) ([]subscription, []string) {
+	var subs []subscription
+	var invalid []string
+	for _, raw := range topics {
+		var topic string
+		if err := json.Unmarshal(raw, &topic); err == nil {
+			subs = append(subs, subscription{topic: topic})
+			continue
+		}
+
+		var entry struct {
+			Type    string   `json:"type"`
+			Nodes   []string `json:"nodes"`
+			Metrics []string `json:"metrics"`
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil || entry.Type == "" {
+			invalid = append(invalid, string(raw))
+			continue
+		}
+		filter, err := newTopicFilter(entry.Nodes, entry.Metrics)
+		if err != nil {
+			invalid = append(invalid, entry.Type)
+			continue
+		}
+		subs = append(subs, subscription{topic: entry.Type, filter: filter})
+	}
+	return subs, invalid
+}
+
+// key identifies the filter, so clients with the same one share the
+// filtered message
+func (f *topicFilter) key() string {
+	return strings.Join(f.nodes, ",") + "|" + strings.Join(f.metrics, ",")
+}
+
+func (f *topicFilter) matchNode(nodeID string) bool {
+	return matchAny(f.nodes, nodeID)
+}
+
+func (f *topicFilter) matchMetric(name string) bool {
+	return matchAny(f.metrics, name)
+}
+
+// apply returns the part of a message the filter lets through, the
+// message itself if it is all let through, or nil if none is. Metric
+// batches are filtered per metric; other messages by their node, and a
+// node filter drops messages about no node in particular.
+func (f *topicFilter) apply(message *WSMessage) *WSMessage {
+	metrics, ok := message.Data.([]*models.Metric)
+	if !ok {
+		if len(f.nodes) > 0 && (message.NodeID == "" || !f.matchNode(message.NodeID)) {
+			return nil
+		}
+		return message
+	}
+
+	kept := make([]*models.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if f.matchNode(metric.NodeID) && f.matchMetric(metric.Name) {
+			kept = append(kept, metric)
+		}
+	}
+	switch len(kept) {
+	case 0:
+		return nil
+	case len(metrics):
+		return message
+	}
+	filtered := *message
+	filtered.Data = kept
+	return &filtered
+}
+
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
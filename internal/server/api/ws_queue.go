@@ -0,0 +1,109 @@
+package api
+
+import "sync"
+
+// clientQueue is a bounded outgoing queue for one WebSocket client. Messages
+// with a coalescing key replace a queued message with the same key, so a
+// slow client receives the latest state per topic instead of a backlog.
+type clientQueue struct {
+	mu        sync.Mutex
+	items     []*queuedMessage
+	pending   map[string]*queuedMessage
+	size      int
+	dropped   uint64
+	coalesced uint64
+
+	// notify is signalled when messages are queued
+	notify chan struct{}
+}
+
+type queuedMessage struct {
+	key  string
+	data []byte
+}
+
+// pushResult reports what happened to a queued message
+type pushResult int
+
+const (
+	pushQueued pushResult = iota
+	pushCoalesced
+	pushDroppedOldest
+	pushFull
+)
+
+func newClientQueue(size int) *clientQueue {
+	return &clientQueue{
+		pending: make(map[string]*queuedMessage),
+		size:    size,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// push queues a message. When the queue is full the oldest message is
+// dropped, unless dropOldest is false, in which case nothing is queued and
+// pushFull is returned.
+func (q *clientQueue) push(key string, data []byte, dropOldest bool) pushResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if key != "" {
+		if queued, ok := q.pending[key]; ok {
+			queued.data = data
+			q.coalesced++
+			return pushCoalesced
+		}
+	}
+
+	result := pushQueued
+	if len(q.items) >= q.size {
+		if !dropOldest {
+			return pushFull
+		}
+		oldest := q.items[0]
+		q.items[0] = nil
+		q.items = q.items[1:]
+		if oldest.key != "" && q.pending[oldest.key] == oldest {
+			delete(q.pending, oldest.key)
+		}
+		q.dropped++
+		result = pushDroppedOldest
+	}
+
+	msg := &queuedMessage{key: key, data: data}
+	q.items = append(q.items, msg)
+	if key != "" {
+		q.pending[key] = msg
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return result
+}
+
+// drain removes and returns every queued message in order
+func (q *clientQueue) drain() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return nil
+	}
+
+	out := make([][]byte, len(q.items))
+	for i, msg := range q.items {
+		out[i] = msg.data
+	}
+	q.items = nil
+	q.pending = make(map[string]*queuedMessage)
+	return out
+}
+
+// stats returns the queue depth and drop and coalesce counts
+func (q *clientQueue) stats() (depth int, dropped, coalesced uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items), q.dropped, q.coalesced
+}
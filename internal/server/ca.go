@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+	// caValidity is how long a generated CA certificate is valid
+	caValidity = 10 * 365 * 24 * time.Hour
+	// clockSkew backdates issued certificates for agents whose clocks are
+	// slightly behind
+	clockSkew = 5 * time.Minute
+)
+
+// certificateAuthority issues agents client certificates. Its key and
+// certificate are created on first start and kept in the CA directory.
+type certificateAuthority struct {
+	cert     *x509.Certificate
+	key      crypto.Signer
+	tokens   []string
+	validity time.Duration
+	logger   *zap.Logger
+}
+
+// newCertificateAuthority loads the CA from its directory, creating it if
+// it does not exist
+func newCertificateAuthority(config *utils.EnrollmentConfig, logger *zap.Logger) (*certificateAuthority, error) {
+	ca := &certificateAuthority{
+		tokens:   config.Tokens,
+		validity: config.CertValidity,
+		logger:   logger,
+	}
+
+	certFile := filepath.Join(config.CADir, caCertFile)
+	keyFile := filepath.Join(config.CADir, caKeyFile)
+	if _, err := os.Stat(certFile); errors.Is(err, os.ErrNotExist) {
+		if err := createCA(config.CADir, certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("failed to create CA: %w", err)
+		}
+		logger.Info("Created agent CA", zap.String("ca_dir", config.CADir))
+	}
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+	if ca.cert, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CA key in %s cannot sign", keyFile)
+	}
+	ca.key = signer
+
+	if time.Until(ca.cert.NotAfter) < 30*24*time.Hour {
+		logger.Warn("Agent CA certificate expires soon",
+			zap.String("cert_file", certFile),
+			zap.Time("not_after", ca.cert.NotAfter),
+		)
+	}
+	return ca, nil
+}
+
+// createCA writes a self-signed CA certificate and its key
+func createCA(dir, certFile, keyFile string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "lnmonja agent CA"},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	// The key is written first, so a certificate never exists without it
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// validToken reports whether token is one of the enrollment tokens
+func (ca *certificateAuthority) validToken(token string) bool {
+	valid := false
+	for _, t := range ca.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return token != "" && valid
+}
+
+// issued reports whether cert was issued by this CA
+func (ca *certificateAuthority) issued(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(ca.cert) == nil
+}
+
+// sign issues a client certificate for nodeID to the key of a PEM encoded
+// CSR. The node ID is the certificate's common name, whatever the CSR
+// asks for.
+func (ca *certificateAuthority) sign(csrPEM []byte, nodeID string) ([]byte, time.Time, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, time.Time{}, fmt.Errorf("no PEM certificate request found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to parse certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	now := time.Now()
+	notAfter := now.Add(ca.validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: nodeID},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), notAfter, nil
+}
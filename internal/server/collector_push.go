@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// collectorPushWorkers bounds the agents updated at once when the
+	// collector defaults change
+	collectorPushWorkers = 32
+	// collectorPushTimeout bounds the wait for each agent's ConfigAck
+	collectorPushTimeout = 10 * time.Second
+)
+
+// defaultChanges returns the collector defaults as changes to an agent's
+// schedule
+func defaultChanges(defaults []utils.CollectorDefaultConfig) []*models.CollectorChange {
+	changes := make([]*models.CollectorChange, 0, len(defaults))
+	for _, d := range defaults {
+		enabled := d.Enabled
+		changes = append(changes, &models.CollectorChange{
+			Name:     d.Name,
+			Enabled:  &enabled,
+			Interval: d.Interval,
+		})
+	}
+	return changes
+}
+
+// unappliedChanges returns the changes a node's inventory does not reflect
+// yet, leaving out collectors its agent does not run
+func unappliedChanges(node *models.Node, changes []*models.CollectorChange) []*models.CollectorChange {
+	var unapplied []*models.CollectorChange
+	for _, c := range changes {
+		enabled, known := node.Inventory[models.InventoryCollectorEnabled(c.Name)]
+		if !known {
+			// The collector is not configured on this agent
+			continue
+		}
+		upToDate := c.Enabled == nil || enabled == strconv.FormatBool(*c.Enabled)
+		if c.Interval != 0 && node.Inventory[models.InventoryCollectorInterval(c.Name)] != c.Interval.String() {
+			upToDate = false
+		}
+		if !upToDate {
+			unapplied = append(unapplied, c)
+		}
+	}
+	sort.Slice(unapplied, func(i, j int) bool { return unapplied[i].Name < unapplied[j].Name })
+	return unapplied
+}
+
+// connectedNodes returns the IDs of the nodes with an open metric stream
+func (s *GRPCServer) connectedNodes() []string {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	seen := make(map[string]bool)
+	var nodes []string
+	for _, session := range s.sessions {
+		if session.Stream != nil && !seen[session.NodeID] {
+			seen[session.NodeID] = true
+			nodes = append(nodes, session.NodeID)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// pushCollectorDefaults sends the collector defaults to every connected
+// agent whose schedule differs from them. Agents that register later get
+// them with their registration.
+func (s *GRPCServer) pushCollectorDefaults(defaults []utils.CollectorDefaultConfig) {
+	changes := defaultChanges(defaults)
+	nodes := s.connectedNodes()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		updated int
+		failed  int
+	)
+	sem := make(chan struct{}, collectorPushWorkers)
+	for _, nodeID := range nodes {
+		info, err := s.nodeMgr.GetNode(nodeID)
+		if err != nil {
+			continue
+		}
+		pending := unappliedChanges(info.Node, changes)
+		if len(pending) == 0 {
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(nodeID string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), collectorPushTimeout)
+			defer cancel()
+			_, err := s.UpdateCollectors(ctx, nodeID, pending)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				s.logger.Warn("Failed to push collector defaults",
+					zap.String("node_id", nodeID),
+					zap.Error(err),
+				)
+				return
+			}
+			updated++
+		}(nodeID)
+	}
+	wg.Wait()
+
+	s.logger.Info("Pushed collector defaults to connected agents",
+		zap.Int("connected", len(nodes)),
+		zap.Int("updated", updated),
+		zap.Int("failed", failed),
+	)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// reloadableSettings are the settings a config reload applies, with
+// everything under them; changes to any other take a restart
+var reloadableSettings = []string{
+	"alerting.rules_path",
+	"alerting.notification",
+	"alerting.route",
+	"alerting.receivers",
+	"logging.level",
+	"server.grpc.collector_defaults",
+}
+
+// ConfigReloader re-reads the server's configuration file and applies the
+// changes that are safe while it runs
+type ConfigReloader struct {
+	alerts *AlertManager
+	grpc   *GRPCServer
+	logger *zap.Logger
+
+	mu sync.Mutex
+	// running is the configuration in effect: the one the server started
+	// with, plus the changes applied since
+	running utils.Config
+}
+
+// NewConfigReloader creates a reloader of the file config was loaded from
+func NewConfigReloader(config *utils.Config, alerts *AlertManager, grpc *GRPCServer, logger *zap.Logger) *ConfigReloader {
+	return &ConfigReloader{
+		alerts:  alerts,
+		grpc:    grpc,
+		logger:  logger,
+		running: *config,
+	}
+}
+
+// Reload reads the configuration file and applies the settings changed
+// since it was last applied, if they are reloadable, reporting the others.
+// A file that cannot be loaded, or an invalid log level, routes or alert
+// rules, leave the configuration as it was.
+func (r *ConfigReloader) Reload() (*models.ConfigReload, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running.File == "" {
+		return nil, errors.New("the server was not started from a config file")
+	}
+	next, err := utils.LoadConfig(r.running.File)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.ConfigReload{
+		Path:            r.running.File,
+		ReloadedAt:      time.Now(),
+		Applied:         []string{},
+		RestartRequired: []string{},
+	}
+	apply := make(map[string]bool)
+	for _, setting := range utils.ChangedSettings(&r.running, next) {
+		if section := reloadableSection(setting); section != "" {
+			apply[section] = true
+			result.Applied = append(result.Applied, setting)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, setting)
+		}
+	}
+
+	// Nothing is applied unless the level and routes are valid and the
+	// rules load
+	level, err := utils.ParseLogLevel(next.Logging.Level)
+	if apply["logging.level"] && err != nil {
+		return nil, err
+	}
+	notify := apply["alerting.notification"] || apply["alerting.route"] || apply["alerting.receivers"]
+	if notify && r.alerts.dispatcher != nil {
+		if _, _, err := newRouting(next, r.alerts.dispatcher.catalog); err != nil {
+			return nil, err
+		}
+	}
+	if apply["alerting.rules_path"] {
+		if err := r.alerts.SetRulesPath(next.Alerting.RulesPath); err != nil {
+			return nil, err
+		}
+		r.running.Alerting.RulesPath = next.Alerting.RulesPath
+	}
+
+	if notify && r.alerts.dispatcher != nil {
+		if err := r.alerts.dispatcher.Reconfigure(next); err != nil {
+			return nil, err
+		}
+		r.running.Alerting.Notification = next.Alerting.Notification
+		r.running.Alerting.Route = next.Alerting.Route
+		r.running.Alerting.Receivers = next.Alerting.Receivers
+	}
+	if apply["server.grpc.collector_defaults"] {
+		r.grpc.SetCollectorDefaults(next.Server.GRPC.CollectorDefaults)
+		r.running.Server.GRPC.CollectorDefaults = next.Server.GRPC.CollectorDefaults
+	}
+	if apply["logging.level"] {
+		utils.SetLogLevel(level)
+		r.running.Logging.Level = next.Logging.Level
+	}
+
+	r.logger.Info("Configuration reloaded",
+		zap.String("path", result.Path),
+		zap.Strings("applied", result.Applied),
+	)
+	if len(result.RestartRequired) > 0 {
+		r.logger.Warn("Changed settings take effect after a restart",
+			zap.Strings("settings", result.RestartRequired),
+		)
+	}
+	return result, nil
+}
+
+// reloadableSection returns the reloadable setting a setting is or is
+// under, or "" if it is not reloadable
+func reloadableSection(setting string) string {
+	for _, section := range reloadableSettings {
+		if setting == section || strings.HasPrefix(setting, section+".") {
+			return section
+		}
+	}
+	return ""
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Dashboards keeps stored dashboards in memory and persists each save as
+// a new version. Updates must carry the UpdatedAt of the version they
+// edit, so concurrent edits are not silently overwritten.
+type Dashboards struct {
+	store      storage.Storage
+	logger     *zap.Logger
+	dashboards map[string]*models.Dashboard
+	mu         sync.RWMutex
+}
+
+// NewDashboards creates the dashboard registry and loads stored
+// dashboards
+func NewDashboards(store storage.Storage, logger *zap.Logger) (*Dashboards, error) {
+	d := &Dashboards{
+		store:  store,
+		logger: logger,
+	}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload replaces the dashboards with the stored ones
+func (d *Dashboards) Reload() error {
+	defs, err := d.store.ListDashboards()
+	if err != nil {
+		return fmt.Errorf("failed to load dashboards: %w", err)
+	}
+
+	dashboards := make(map[string]*models.Dashboard, len(defs))
+	for _, def := range defs {
+		dashboards[def.ID] = def
+	}
+	d.mu.Lock()
+	d.dashboards = dashboards
+	d.mu.Unlock()
+	return nil
+}
+
+// List returns every stored dashboard
+func (d *Dashboards) List() []*models.Dashboard {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	dashboards := make([]*models.Dashboard, 0, len(d.dashboards))
+	for _, def := range d.dashboards {
+		dashboards = append(dashboards, def)
+	}
+	return dashboards
+}
+
+// Get returns a stored dashboard
+func (d *Dashboards) Get(id string) (*models.Dashboard, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	def, ok := d.dashboards[id]
+	return def, ok
+}
+
+// Create validates and stores a new dashboard as its first version
+func (d *Dashboards) Create(def *models.Dashboard) (*models.Dashboard, error) {
+	if err := validateDashboard(def); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	saved := *def
+	saved.ID = utils.GenerateDashboardID()
+	saved.Version = 1
+	saved.CreatedAt = now
+	saved.UpdatedAt = now
+	saved.UpdatedBy = saved.CreatedBy
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.save(&saved); err != nil {
+		return nil, err
+	}
+
+	d.logger.Info("Dashboard created",
+		zap.String("id", saved.ID),
+		zap.String("name", saved.Name),
+		zap.String("created_by", saved.CreatedBy),
+	)
+	return &saved, nil
+}
+
+// Update replaces a dashboard's content with a new version. The update's
+// UpdatedAt must match the dashboard's, or the dashboard has been changed
+// since the caller read it.
+func (d *Dashboards) Update(id string, def *models.Dashboard) (*models.Dashboard, error) {
+	if err := validateDashboard(def); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	existing, ok := d.dashboards[id]
+	if !ok {
+		return nil, fmt.Errorf("dashboard %s not found", id)
+	}
+	if def.UpdatedAt.IsZero() {
+		return nil, fmt.Errorf("updated_at of the version being edited is required")
+	}
+	if !def.UpdatedAt.Equal(existing.UpdatedAt) {
+		return nil, fmt.Errorf("dashboard %s was changed at %s (version %d); reload it and apply the changes again",
+			id, existing.UpdatedAt.Format(time.RFC3339Nano), existing.Version)
+	}
+
+	saved := d.nextVersion(existing, def)
+	if err := d.save(saved); err != nil {
+		return nil, err
+	}
+
+	d.logger.Info("Dashboard updated",
+		zap.String("id", id),
+		zap.Int("version", saved.Version),
+		zap.String("updated_by", saved.UpdatedBy),
+	)
+	return saved, nil
+}
+
+// Delete deletes a dashboard and its versions
+func (d *Dashboards) Delete(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.dashboards[id]; !ok {
+		return fmt.Errorf("dashboard %s not found", id)
+	}
+	if err := d.store.DeleteDashboard(id); err != nil {
+		return fmt.Errorf("failed to delete dashboard: %w", err)
+	}
+	delete(d.dashboards, id)
+
+	d.logger.Info("Dashboard deleted", zap.String("id", id))
+	return nil
+}
+
+// Versions lists the saved versions of a dashboard, newest first
+func (d *Dashboards) Versions(id string) ([]*models.DashboardVersion, error) {
+	if _, ok := d.Get(id); !ok {
+		return nil, fmt.Errorf("dashboard %s not found", id)
+	}
+
+	defs, err := d.store.GetDashboardVersions(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dashboard versions: %w", err)
+	}
+
+	versions := make([]*models.DashboardVersion, 0, len(defs))
+	for _, def := range defs {
+		versions = append(versions, &models.DashboardVersion{
+			Version:   def.Version,
+			UpdatedAt: def.UpdatedAt,
+			UpdatedBy: def.UpdatedBy,
+			Name:      def.Name,
+			Panels:    len(def.Panels),
+		})
+	}
+	return versions, nil
+}
+
+// Version returns a saved version of a dashboard
+func (d *Dashboards) Version(id string, version int) (*models.Dashboard, error) {
+	if _, ok := d.Get(id); !ok {
+		return nil, fmt.Errorf("dashboard %s not found", id)
+	}
+
+	defs, err := d.store.GetDashboardVersions(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dashboard versions: %w", err)
+	}
+	for _, def := range defs {
+		if def.Version == version {
+			return def, nil
+		}
+	}
+	return nil, fmt.Errorf("dashboard %s has no version %d", id, version)
+}
+
+// Restore saves the content of an earlier version as a new version
+func (d *Dashboards) Restore(id string, version int, user string) (*models.Dashboard, error) {
+	old, err := d.Version(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	existing, ok := d.dashboards[id]
+	if !ok {
+		return nil, fmt.Errorf("dashboard %s not found", id)
+	}
+	restored := *old
+	restored.UpdatedBy = user
+	saved := d.nextVersion(existing, &restored)
+	if err := d.save(saved); err != nil {
+		return nil, err
+	}
+
+	d.logger.Info("Dashboard version restored",
+		zap.String("id", id),
+		zap.Int("restored", version),
+		zap.Int("version", saved.Version),
+		zap.String("updated_by", saved.UpdatedBy),
+	)
+	return saved, nil
+}
+
+// Import creates a dashboard from each exported one, with new IDs. None
+// are created unless all are valid.
+func (d *Dashboards) Import(defs []*models.Dashboard, user string) ([]*models.Dashboard, error) {
+	for i, def := range defs {
+		if err := validateDashboard(def); err != nil {
+			return nil, fmt.Errorf("dashboard %d: %w", i, err)
+		}
+	}
+
+	imported := make([]*models.Dashboard, 0, len(defs))
+	for _, def := range defs {
+		def.CreatedBy = user
+		saved, err := d.Create(def)
+		if err != nil {
+			return imported, err
+		}
+		imported = append(imported, saved)
+	}
+	return imported, nil
+}
+
+// nextVersion returns def as the version after existing. Callers must
+// hold mu.
+func (d *Dashboards) nextVersion(existing, def *models.Dashboard) *models.Dashboard {
+	saved := *def
+	saved.ID = existing.ID
+	saved.Version = existing.Version + 1
+	saved.CreatedAt = existing.CreatedAt
+	saved.CreatedBy = existing.CreatedBy
+	saved.UpdatedAt = time.Now().UTC()
+	return &saved
+}
+
+// save stores a dashboard version and makes it current. Callers must
+// hold mu.
+func (d *Dashboards) save(def *models.Dashboard) error {
+	if err := d.store.SaveDashboard(def); err != nil {
+		return fmt.Errorf("failed to save dashboard: %w", err)
+	}
+	d.dashboards[def.ID] = def
+	return nil
+}
+
+// validateDashboard checks a dashboard's content
+func validateDashboard(def *models.Dashboard) error {
+	if def.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	for i, panel := range def.Panels {
+		if panel == nil {
+			return fmt.Errorf("panel %d is empty", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Decommissioner retires nodes: it silences their alerts, stops health
+// checks from flagging them and, once the grace period ends, archives or
+// purges their series. Ephemeral nodes that scale in are removed after the
+// ephemeral TTL instead.
+type Decommissioner struct {
+	config *utils.LifecycleConfig
+	store  storage.Storage
+	nodes  *NodeManager
+	alerts *AlertManager
+	logger *zap.Logger
+	mu     sync.Mutex
+}
+
+// NewDecommissioner creates a decommissioner
+func NewDecommissioner(config *utils.LifecycleConfig, store storage.Storage, nodes *NodeManager, alerts *AlertManager, logger *zap.Logger) *Decommissioner {
+	return &Decommissioner{
+		config: config,
+		store:  store,
+		nodes:  nodes,
+		alerts: alerts,
+		logger: logger,
+	}
+}
+
+// Decommission marks a node as retiring and silences its alerts
+func (d *Decommissioner) Decommission(nodeID string, decommission *models.Decommission) (*models.Node, error) {
+	switch decommission.Action {
+	case models.DecommissionKeep, models.DecommissionArchive, models.DecommissionPurge:
+	case "":
+		decommission.Action = models.DecommissionKeep
+	default:
+		return nil, fmt.Errorf("unknown decommission action: %s", decommission.Action)
+	}
+
+	node, err := d.nodes.MarkRetiring(nodeID, decommission)
+	if err != nil {
+		return nil, err
+	}
+
+	d.alerts.SilenceNode(nodeID)
+	return node, nil
+}
+
+// Depart records a clean scale-in of an ephemeral node and silences its
+// alerts
+func (d *Decommissioner) Depart(nodeID string) (*models.Node, error) {
+	node, err := d.nodes.Depart(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	d.alerts.SilenceNode(nodeID)
+	return node, nil
+}
+
+// Reactivate cancels decommissioning of a retiring node
+func (d *Decommissioner) Reactivate(nodeID string) (*models.Node, error) {
+	return d.nodes.Reactivate(nodeID)
+}
+
+// Run completes decommissioning of retiring nodes whose grace period has
+// ended and removes departed ephemeral nodes past the TTL. Failed nodes are
+// retried on the next run.
+func (d *Decommissioner) Run(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Retiring nodes may not be cached by the node manager after a restart
+	nodes, err := d.store.ListNodes()
+	if err != nil {
+		d.logger.Error("Failed to list nodes for decommissioning", zap.Error(err))
+		return
+	}
+
+	for _, node := range nodes {
+		if node.Status == models.NodeStatusDeparted && node.DepartedAt != nil {
+			if now.Sub(*node.DepartedAt) < d.config.EphemeralTTL {
+				continue
+			}
+			if err := d.nodes.Remove(node.ID); err != nil {
+				d.logger.Error("Failed to remove departed node",
+					zap.String("node_id", node.ID),
+					zap.Error(err),
+				)
+			}
+			continue
+		}
+
+		if node.Status != models.NodeStatusRetiring || node.Decommission == nil {
+			continue
+		}
+		if now.Before(node.Decommission.CompleteAt) {
+			continue
+		}
+
+		if err := d.complete(node.ID, node.Decommission.Action, now); err != nil {
+			d.logger.Error("Failed to decommission node",
+				zap.String("node_id", node.ID),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (d *Decommissioner) complete(nodeID string, action models.DecommissionAction, now time.Time) error {
+	var (
+		deleted     int64
+		archivePath string
+		err         error
+	)
+
+	switch action {
+	case models.DecommissionArchive:
+		archivePath, deleted, err = d.archive(nodeID, now)
+	case models.DecommissionPurge:
+		deleted, err = d.store.DeleteNodeMetrics(nodeID, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = d.nodes.MarkDecommissioned(nodeID, deleted, archivePath)
+	return err
+}
+
+// archive writes a node's samples as gzipped JSON lines and deletes them
+func (d *Decommissioner) archive(nodeID string, now time.Time) (string, int64, error) {
+	if err := os.MkdirAll(d.config.ArchivePath, 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	path := filepath.Join(d.config.ArchivePath,
+		fmt.Sprintf("%s-%s.jsonl.gz", sanitizeFileName(nodeID), now.UTC().Format("20060102T150405Z")))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	zw := gzip.NewWriter(f)
+	deleted, err := d.store.DeleteNodeMetrics(nodeID, &fileArchiver{
+		file: f,
+		zw:   zw,
+		enc:  json.NewEncoder(zw),
+	})
+	if err != nil {
+		// The archiver is closed before anything is deleted
+		os.Remove(path)
+		return "", 0, err
+	}
+
+	return path, deleted, nil
+}
+
+// fileArchiver writes samples to a gzipped JSON lines file
+type fileArchiver struct {
+	file *os.File
+	zw   *gzip.Writer
+	enc  *json.Encoder
+}
+
+func (a *fileArchiver) Archive(metric *models.Metric) error {
+	return a.enc.Encode(metric)
+}
+
+func (a *fileArchiver) Close() error {
+	if err := a.zw.Close(); err != nil {
+		a.file.Close()
+		return err
+	}
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// sanitizeFileName replaces characters that are unsafe in file names
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
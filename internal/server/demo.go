@@ -0,0 +1,425 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"github.com/meettoy2004/lnmonja/pkg/version"
+	"go.uber.org/zap"
+)
+
+// demoBackfillBatch bounds the samples written at once while backfilling
+const demoBackfillBatch = 50000
+
+// demoRole shapes the load of the simulated nodes with a role
+type demoRole struct {
+	name     string
+	cores    int
+	memory   float64 // bytes
+	disk     float64 // bytes
+	cpu      float64 // mean CPU usage, percent
+	memUsed  float64 // mean memory usage, fraction
+	diskUsed float64 // disk usage after cleanup, fraction
+	network  float64 // mean bytes received a second
+}
+
+const gib = 1 << 30
+
+var demoRoles = []demoRole{
+	{name: "web", cores: 4, memory: 8 * gib, disk: 50 * gib, cpu: 25, memUsed: 0.45, diskUsed: 0.40, network: 4e6},
+	{name: "api", cores: 8, memory: 16 * gib, disk: 100 * gib, cpu: 35, memUsed: 0.55, diskUsed: 0.35, network: 2e6},
+	{name: "db", cores: 16, memory: 64 * gib, disk: 500 * gib, cpu: 30, memUsed: 0.70, diskUsed: 0.60, network: 8e6},
+	{name: "cache", cores: 4, memory: 32 * gib, disk: 50 * gib, cpu: 15, memUsed: 0.65, diskUsed: 0.25, network: 12e6},
+	{name: "worker", cores: 8, memory: 16 * gib, disk: 200 * gib, cpu: 50, memUsed: 0.50, diskUsed: 0.50, network: 1e6},
+}
+
+// demoAnomaly is a disturbance of a simulated node
+type demoAnomaly int
+
+const (
+	// demoCPUSpike pins every core, firing HighCPUUsage
+	demoCPUSpike demoAnomaly = iota
+	// demoMemoryLeak grows memory until the process restarts, firing
+	// HighMemoryUsage
+	demoMemoryLeak
+	// demoDiskFill fills the disk until it is cleaned up, firing
+	// LowDiskSpace
+	demoDiskFill
+	// demoTrafficBurst multiplies network traffic, with some extra CPU
+	demoTrafficBurst
+)
+
+func (a demoAnomaly) String() string {
+	switch a {
+	case demoCPUSpike:
+		return "cpu_spike"
+	case demoMemoryLeak:
+		return "memory_leak"
+	case demoDiskFill:
+		return "disk_fill"
+	default:
+		return "traffic_burst"
+	}
+}
+
+// demoNode is the state of a simulated node
+type demoNode struct {
+	node  *models.Node
+	role  demoRole
+	phase float64 // offset of the daily cycle, in radians
+	boot  time.Time
+
+	load5, load15 float64
+	diskUsed      float64
+	rxTotal       float64
+	txTotal       float64
+
+	anomaly      demoAnomaly
+	anomalyStart time.Time
+	anomalyEnd   time.Time
+	// anomalyFrom is the memory or disk usage when the anomaly started
+	anomalyFrom float64
+}
+
+// DemoGenerator simulates nodes reporting system metrics with daily
+// cycles, noise and occasional anomalies, written through the agent
+// ingest path so they show in queries, dashboards, live updates and
+// alerts
+type DemoGenerator struct {
+	config   *utils.DemoConfig
+	store    storage.Storage
+	nodeMgr  *NodeManager
+	alertMgr *AlertManager
+	ingest   *IngestStats
+	metrics  *telemetry.Metrics
+	live     LivePublisher
+	logger   *zap.Logger
+
+	rand  *rand.Rand
+	nodes []*demoNode
+}
+
+// NewDemoGenerator creates the simulated nodes
+func NewDemoGenerator(config *utils.DemoConfig, store storage.Storage, nodeMgr *NodeManager, alertMgr *AlertManager, logger *zap.Logger) *DemoGenerator {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	g := &DemoGenerator{
+		config:   config,
+		store:    store,
+		nodeMgr:  nodeMgr,
+		alertMgr: alertMgr,
+		logger:   logger.Named("demo"),
+		rand:     rand.New(rand.NewSource(seed)),
+	}
+
+	start := time.Now().Add(-config.Backfill)
+	counts := make(map[string]int)
+	for i := 0; i < config.Nodes; i++ {
+		role := demoRoles[i%len(demoRoles)]
+		counts[role.name]++
+		id := fmt.Sprintf("demo-%s-%d", role.name, counts[role.name])
+		datacenter := "dc" + strconv.Itoa(1+i%2)
+
+		g.nodes = append(g.nodes, &demoNode{
+			node: &models.Node{
+				ID:       id,
+				Hostname: id + "." + datacenter + ".demo.local",
+				OS:       "linux",
+				Arch:     "amd64",
+				Version:  version.Version,
+				Labels: map[string]string{
+					"role":       role.name,
+					"datacenter": datacenter,
+					"env":        "demo",
+				},
+				Status:    models.NodeStatusHealthy,
+				LastSeen:  time.Now(),
+				CreatedAt: start,
+			},
+			role:     role,
+			phase:    g.rand.Float64() * 0.5,
+			boot:     start.Add(-time.Duration(1+g.rand.Intn(60*24)) * time.Hour),
+			diskUsed: role.diskUsed + g.rand.Float64()*0.1,
+			rxTotal:  g.rand.Float64() * 1e12,
+			txTotal:  g.rand.Float64() * 1e12,
+		})
+	}
+	return g
+}
+
+// Run registers the simulated nodes, backfills their history and then
+// reports their metrics every interval until stop is closed
+func (g *DemoGenerator) Run(stop <-chan struct{}) {
+	for _, n := range g.nodes {
+		if err := g.nodeMgr.RegisterNode(n.node); err != nil {
+			g.logger.Error("Failed to register demo node", zap.String("node_id", n.node.ID), zap.Error(err))
+		}
+	}
+	g.logger.Info("Demo mode: simulating nodes",
+		zap.Int("nodes", len(g.nodes)),
+		zap.Duration("interval", g.config.Interval),
+		zap.Duration("backfill", g.config.Backfill),
+	)
+
+	if err := g.backfill(time.Now()); err != nil {
+		g.logger.Error("Failed to backfill demo history", zap.Error(err))
+	}
+
+	// Start with an anomaly, so an alert fires within minutes
+	if g.config.AnomaliesPerHour > 0 && len(g.nodes) > 0 {
+		now := time.Now()
+		g.startAnomaly(g.nodes[0], demoCPUSpike, now, now.Add(10*time.Minute))
+	}
+
+	ticker := time.NewTicker(g.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for _, n := range g.nodes {
+				g.report(n, now)
+			}
+		}
+	}
+}
+
+// backfill writes the history of every node from the backfill period
+// up to now, without alerting on it
+func (g *DemoGenerator) backfill(now time.Time) error {
+	if g.config.Backfill <= 0 {
+		return nil
+	}
+
+	var batch []*models.Metric
+	written := 0
+	for t := now.Add(-g.config.Backfill); t.Before(now); t = t.Add(g.config.Interval) {
+		for _, n := range g.nodes {
+			batch = append(batch, g.sample(n, t)...)
+		}
+		if len(batch) >= demoBackfillBatch {
+			if err := g.store.WriteMetrics(batch); err != nil {
+				return err
+			}
+			written += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := g.store.WriteMetrics(batch); err != nil {
+		return err
+	}
+	written += len(batch)
+
+	g.logger.Info("Backfilled demo history", zap.Int("samples", written))
+	return nil
+}
+
+// report runs the agent ingest path for one sample of a node
+func (g *DemoGenerator) report(n *demoNode, now time.Time) {
+	nodeID := n.node.ID
+	metrics := g.sample(n, now)
+	addNodeLabels(metrics, g.nodeMgr.ServerLabels(nodeID))
+	if g.ingest != nil {
+		g.ingest.Record(nodeID, metrics)
+	}
+
+	if err := g.store.WriteMetrics(metrics); err != nil {
+		g.metrics.StorageError("write")
+		g.logger.Error("Failed to store demo metrics",
+			zap.String("node_id", nodeID),
+			zap.Error(err),
+		)
+	} else {
+		g.metrics.Ingested("demo", len(metrics))
+		g.nodeMgr.IncrementMetricCount(nodeID, int64(len(metrics)))
+		if g.live != nil {
+			g.live.BroadcastMetrics(metrics)
+		}
+	}
+
+	g.alertMgr.CheckMetrics(nodeID, metrics)
+
+	g.nodeMgr.UpdateHeartbeat(nodeID)
+	g.nodeMgr.UpdateVitals(nodeID, &models.NodeVitals{
+		CPUPercent:    valueOf(metrics, "system_cpu_usage_total"),
+		MemoryPercent: valueOf(metrics, "system_memory_usage_percent"),
+		Load1:         valueOf(metrics, "system_load1"),
+		ReportedAt:    now,
+	})
+}
+
+// sample advances a node's state to t and returns its metrics, named and
+// labelled like those of the agent's system collector
+func (g *DemoGenerator) sample(n *demoNode, t time.Time) []*models.Metric {
+	g.advanceAnomaly(n, t)
+	role := n.role
+
+	// Busiest in the afternoon, quietest before dawn
+	hours := float64(t.Hour()) + float64(t.Minute())/60
+	daily := math.Sin(2*math.Pi*(hours-8)/24 + n.phase)
+
+	cpu := role.cpu + 15*daily
+	memUsed := role.memUsed + 0.05*daily
+	network := role.network * (1 + 0.5*daily)
+	active := n.anomalyActive(t)
+	progress := n.anomalyProgress(t)
+	if active {
+		switch n.anomaly {
+		case demoCPUSpike:
+			cpu = 94
+		case demoMemoryLeak:
+			memUsed = n.anomalyFrom + (0.97-n.anomalyFrom)*progress
+		case demoDiskFill:
+			n.diskUsed = n.anomalyFrom + (0.93-n.anomalyFrom)*progress
+		case demoTrafficBurst:
+			network *= 8
+			cpu += 25
+		}
+	}
+
+	// Logs and data grow slowly until they are cleaned up
+	if !active || n.anomaly != demoDiskFill {
+		n.diskUsed += 0.00002 * g.config.Interval.Seconds() / 15
+		if n.diskUsed > role.diskUsed+0.2 {
+			n.diskUsed = role.diskUsed
+		}
+	}
+
+	var metrics []*models.Metric
+	add := func(name string, value float64, labels map[string]string, typ models.MetricType, help, unit string) {
+		metrics = append(metrics, &models.Metric{
+			NodeID:    n.node.ID,
+			Name:      name,
+			Value:     value,
+			Timestamp: t,
+			Labels:    labels,
+			Type:      typ,
+			Help:      help,
+			Unit:      unit,
+		})
+	}
+
+	add("system_cpu_cores", float64(role.cores), nil, models.MetricTypeGauge, "Number of CPU cores", "")
+	var total float64
+	for i := 0; i < role.cores; i++ {
+		core := clamp(cpu+g.rand.NormFloat64()*5, 0, 100)
+		if active && n.anomaly == demoCPUSpike {
+			core = clamp(cpu+g.rand.NormFloat64()*2, 85, 100)
+		}
+		total += core
+		add("system_cpu_usage", core, map[string]string{"cpu": "cpu" + strconv.Itoa(i)}, models.MetricTypeGauge, "CPU usage percentage", "percent")
+	}
+	total /= float64(role.cores)
+	add("system_cpu_usage_total", total, nil, models.MetricTypeGauge, "Total CPU usage percentage", "percent")
+
+	load1 := math.Max(0, float64(role.cores)*total/100+g.rand.NormFloat64()*0.2)
+	if n.load5 == 0 {
+		n.load5, n.load15 = load1, load1
+	}
+	n.load5 += (load1 - n.load5) * g.config.Interval.Seconds() / 300
+	n.load15 += (load1 - n.load15) * g.config.Interval.Seconds() / 900
+	add("system_load1", load1, nil, models.MetricTypeGauge, "1-minute load average", "")
+	add("system_load5", n.load5, nil, models.MetricTypeGauge, "5-minute load average", "")
+	add("system_load15", n.load15, nil, models.MetricTypeGauge, "15-minute load average", "")
+
+	memUsed = clamp(memUsed+g.rand.NormFloat64()*0.01, 0.05, 0.99)
+	used := role.memory * memUsed
+	add("system_memory_total_bytes", role.memory, nil, models.MetricTypeGauge, "Total memory", "bytes")
+	add("system_memory_used_bytes", used, nil, models.MetricTypeGauge, "Used memory", "bytes")
+	add("system_memory_available_bytes", role.memory-used, nil, models.MetricTypeGauge, "Available memory", "bytes")
+	add("system_memory_usage_percent", 100*memUsed, nil, models.MetricTypeGauge, "Memory usage percentage", "percent")
+
+	disk := map[string]string{"device": "/dev/sda1", "mount": "/", "fstype": "ext4"}
+	diskUsed := role.disk * n.diskUsed
+	add("system_disk_total_bytes", role.disk, disk, models.MetricTypeGauge, "Total disk space", "bytes")
+	add("system_disk_used_bytes", diskUsed, disk, models.MetricTypeGauge, "Used disk space", "bytes")
+	add("system_disk_free_bytes", role.disk-diskUsed, disk, models.MetricTypeGauge, "Free disk space", "bytes")
+	add("system_disk_usage_percent", 100*n.diskUsed, disk, models.MetricTypeGauge, "Disk usage percentage", "percent")
+
+	iface := map[string]string{"interface": "eth0"}
+	rx := math.Max(0, network*(1+g.rand.NormFloat64()*0.1))
+	tx := math.Max(0, network*0.6*(1+g.rand.NormFloat64()*0.1))
+	n.rxTotal += rx * g.config.Interval.Seconds()
+	n.txTotal += tx * g.config.Interval.Seconds()
+	add("system_network_receive_bytes_per_second", rx, iface, models.MetricTypeGauge, "Network receive bytes per second", "bytes")
+	add("system_network_transmit_bytes_per_second", tx, iface, models.MetricTypeGauge, "Network transmit bytes per second", "bytes")
+	add("system_network_receive_bytes_total", n.rxTotal, iface, models.MetricTypeCounter, "Total network bytes received", "bytes")
+	add("system_network_transmit_bytes_total", n.txTotal, iface, models.MetricTypeCounter, "Total network bytes transmitted", "bytes")
+
+	add("system_uptime_seconds", t.Sub(n.boot).Seconds(), nil, models.MetricTypeGauge, "System uptime in seconds", "seconds")
+	return metrics
+}
+
+// advanceAnomaly ends a node's anomaly once it is over and starts a new
+// one at random
+func (g *DemoGenerator) advanceAnomaly(n *demoNode, t time.Time) {
+	if !n.anomalyEnd.IsZero() && !t.Before(n.anomalyEnd) {
+		if n.anomaly == demoDiskFill {
+			// Cleaned up
+			n.diskUsed = n.role.diskUsed
+		}
+		n.anomalyStart, n.anomalyEnd = time.Time{}, time.Time{}
+	}
+	if n.anomalyActive(t) || g.config.AnomaliesPerHour <= 0 {
+		return
+	}
+
+	if g.rand.Float64() >= g.config.AnomaliesPerHour*g.config.Interval.Hours() {
+		return
+	}
+	anomaly := demoAnomaly(g.rand.Intn(4))
+	duration := time.Duration(6+g.rand.Intn(15)) * time.Minute
+	g.startAnomaly(n, anomaly, t, t.Add(duration))
+}
+
+// startAnomaly starts an anomaly on a node
+func (g *DemoGenerator) startAnomaly(n *demoNode, anomaly demoAnomaly, start, end time.Time) {
+	n.anomaly, n.anomalyStart, n.anomalyEnd = anomaly, start, end
+	switch anomaly {
+	case demoMemoryLeak:
+		n.anomalyFrom = n.role.memUsed
+	case demoDiskFill:
+		n.anomalyFrom = n.diskUsed
+	}
+	g.logger.Debug("Demo anomaly",
+		zap.String("node_id", n.node.ID),
+		zap.Stringer("anomaly", anomaly),
+		zap.Time("until", end),
+	)
+}
+
+func (n *demoNode) anomalyActive(t time.Time) bool {
+	return !n.anomalyStart.IsZero() && !t.Before(n.anomalyStart) && t.Before(n.anomalyEnd)
+}
+
+// anomalyProgress is how far through its anomaly a node is, from 0 to 1
+func (n *demoNode) anomalyProgress(t time.Time) float64 {
+	if !n.anomalyActive(t) {
+		return 0
+	}
+	return float64(t.Sub(n.anomalyStart)) / float64(n.anomalyEnd.Sub(n.anomalyStart))
+}
+
+// valueOf returns the value of the first metric with a name
+func valueOf(metrics []*models.Metric, name string) float64 {
+	for _, m := range metrics {
+		if m.Name == name {
+			return m.Value
+		}
+	}
+	return 0
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/query"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"go.uber.org/zap"
+)
+
+var derivedNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// DerivedMetrics keeps parsed derived metric definitions in memory and
+// persists them to storage
+type DerivedMetrics struct {
+	store   storage.Storage
+	logger  *zap.Logger
+	metrics map[string]*derivedMetric
+	mu      sync.RWMutex
+}
+
+type derivedMetric struct {
+	def  *models.DerivedMetric
+	expr query.Expr
+}
+
+// NewDerivedMetrics creates the registry and loads stored definitions
+func NewDerivedMetrics(store storage.Storage, logger *zap.Logger) (*DerivedMetrics, error) {
+	dm := &DerivedMetrics{
+		store:  store,
+		logger: logger,
+	}
+	if err := dm.Reload(); err != nil {
+		return nil, err
+	}
+	return dm, nil
+}
+
+// Reload replaces the definitions with the stored ones
+func (dm *DerivedMetrics) Reload() error {
+	defs, err := dm.store.ListDerivedMetrics()
+	if err != nil {
+		return fmt.Errorf("failed to load derived metrics: %w", err)
+	}
+
+	metrics := make(map[string]*derivedMetric, len(defs))
+	for _, def := range defs {
+		expr, err := query.Parse(def.Expression)
+		if err != nil {
+			dm.logger.Warn("Skipping invalid derived metric",
+				zap.String("name", def.Name),
+				zap.Error(err),
+			)
+			continue
+		}
+		metrics[def.Name] = &derivedMetric{def: def, expr: expr}
+	}
+
+	dm.mu.Lock()
+	dm.metrics = metrics
+	dm.mu.Unlock()
+	return nil
+}
+
+// Save validates and stores a derived metric definition
+func (dm *DerivedMetrics) Save(def *models.DerivedMetric) (*models.DerivedMetric, error) {
+	if !derivedNamePattern.MatchString(def.Name) {
+		return nil, fmt.Errorf("invalid derived metric name: %q", def.Name)
+	}
+
+	expr, err := query.Parse(def.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+
+	selectors := query.Selectors(expr)
+	if len(selectors) == 0 {
+		return nil, fmt.Errorf("expression must reference at least one metric")
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	// Derived metrics may not reference other derived metrics
+	for _, sel := range selectors {
+		if _, exists := dm.metrics[sel.Name]; exists || sel.Name == def.Name {
+			return nil, fmt.Errorf("expression may not reference derived metric %s", sel.Name)
+		}
+	}
+
+	now := time.Now().UTC()
+	saved := *def
+	saved.CreatedAt = now
+	if existing, exists := dm.metrics[def.Name]; exists {
+		saved.CreatedAt = existing.def.CreatedAt
+	}
+	saved.UpdatedAt = now
+
+	if err := dm.store.SaveDerivedMetric(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save derived metric: %w", err)
+	}
+	dm.metrics[def.Name] = &derivedMetric{def: &saved, expr: expr}
+
+	dm.logger.Info("Derived metric saved",
+		zap.String("name", saved.Name),
+		zap.String("expression", saved.Expression),
+	)
+
+	return &saved, nil
+}
+
+// Delete removes a derived metric definition
+func (dm *DerivedMetrics) Delete(name string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if _, exists := dm.metrics[name]; !exists {
+		return fmt.Errorf("derived metric %s not found", name)
+	}
+
+	if err := dm.store.DeleteDerivedMetric(name); err != nil {
+		return fmt.Errorf("failed to delete derived metric: %w", err)
+	}
+	delete(dm.metrics, name)
+
+	dm.logger.Info("Derived metric deleted", zap.String("name", name))
+	return nil
+}
+
+// Get returns a derived metric definition by name
+func (dm *DerivedMetrics) Get(name string) (*models.DerivedMetric, bool) {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	m, exists := dm.metrics[name]
+	if !exists {
+		return nil, false
+	}
+	return m.def, true
+}
+
+// List returns all derived metric definitions sorted by name
+func (dm *DerivedMetrics) List() []*models.DerivedMetric {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	defs := make([]*models.DerivedMetric, 0, len(dm.metrics))
+	for _, m := range dm.metrics {
+		defs = append(defs, m.def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+
+	return defs
+}
+
+// Selectors returns the selectors referenced by a derived metric, or nil
+// when name is not a derived metric
+func (dm *DerivedMetrics) Selectors(name string) []*query.SelectorExpr {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	m, exists := dm.metrics[name]
+	if !exists {
+		return nil
+	}
+	return query.Selectors(m.expr)
+}
+
+// Query evaluates a derived metric over a time range. ok is false when name
+// is not a derived metric. Label matchers are applied to the result.
+func (dm *DerivedMetrics) Query(name string, labels map[string]string, start, end time.Time, step time.Duration) (series []*models.TimeSeries, ok bool, err error) {
+	dm.mu.RLock()
+	m, exists := dm.metrics[name]
+	dm.mu.RUnlock()
+
+	if !exists {
+		return nil, false, nil
+	}
+
+	result, err := query.Eval(m.expr, func(sel *query.SelectorExpr) ([]*models.TimeSeries, error) {
+		return dm.store.QueryMetrics(&models.Query{
+			MetricName: sel.Name,
+			Labels:     sel.Labels,
+			StartTime:  start,
+			EndTime:    end,
+			Step:       step,
+		})
+	})
+	if err != nil {
+		return nil, true, err
+	}
+
+	for _, s := range result {
+		if matchLabels(s.Labels, labels) {
+			series = append(series, s)
+		}
+	}
+
+	return series, true, nil
+}
+
+// Evaluate computes all derived metrics that can be calculated from a batch
+// of metrics received from one node
+func (dm *DerivedMetrics) Evaluate(metrics []*models.Metric) []*models.Metric {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var derived []*models.Metric
+	for name, m := range dm.metrics {
+		result, err := query.EvalInstant(m.expr, name, metrics)
+		if err != nil {
+			dm.logger.Debug("Failed to evaluate derived metric",
+				zap.String("name", name),
+				zap.Error(err),
+			)
+			continue
+		}
+		derived = append(derived, result...)
+	}
+
+	return derived
+}
+
+func matchLabels(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// Parquet file format constants, from parquet.thrift
+const (
+	parquetMagic = "PAR1"
+
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired   = 0
+	parquetPlain      = 0
+	parquetRLE        = 3
+	parquetGzip       = 2
+	parquetDataPage   = 0
+	parquetUTF8       = 0
+	parquetTimeMicros = 10
+)
+
+// Thrift compact protocol field types
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, which
+// Parquet uses for page headers and the file footer
+type thriftWriter struct {
+	buf bytes.Buffer
+	// last holds the previous field ID of each open struct, as field
+	// headers are deltas from it
+	last []int16
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	top := len(w.last) - 1
+	if delta := id - w.last[top]; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+	w.last[top] = id
+}
+
+func (w *thriftWriter) begin() { w.last = append(w.last, 0) }
+
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) bool(id int16, v bool) {
+	if v {
+		w.field(id, thriftTrue)
+	} else {
+		w.field(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) binary(v string) {
+	w.varint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+func (w *thriftWriter) string(id int16, v string) {
+	w.field(id, thriftBinary)
+	w.binary(v)
+}
+
+// list writes a list field header; the caller writes the n elements
+func (w *thriftWriter) list(id int16, elem byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		w.buf.WriteByte(0xf0 | elem)
+		w.varint(uint64(n))
+	}
+}
+
+// structField opens a struct field; the caller closes it with end
+func (w *thriftWriter) structField(id int16) {
+	w.field(id, thriftStruct)
+	w.begin()
+}
+
+// parquetColumn is a required column of the export schema, with its values
+// PLAIN encoded
+type parquetColumn struct {
+	name string
+	typ  int32
+	// converted and logical annotate the physical type; logical writes
+	// the LogicalType union
+	converted int32
+	logical   func(w *thriftWriter)
+	values    bytes.Buffer
+}
+
+// exportColumns returns the columns of an export, matching the CSV
+// header, with the rows' values encoded
+func exportColumns(cfg utils.ExportConfig, rows []exportRow) []*parquetColumn {
+	timestamp := &parquetColumn{
+		name:      "timestamp",
+		typ:       parquetInt64,
+		converted: parquetTimeMicros,
+		logical: func(w *thriftWriter) {
+			w.structField(8) // TIMESTAMP
+			w.bool(1, true)  // isAdjustedToUTC
+			w.structField(2) // unit
+			w.structField(2) // MICROS
+			w.end()
+			w.end()
+			w.end()
+		},
+	}
+	str := func(name string) *parquetColumn {
+		return &parquetColumn{
+			name:      name,
+			typ:       parquetByteArray,
+			converted: parquetUTF8,
+			logical: func(w *thriftWriter) {
+				w.structField(1) // STRING
+				w.end()
+			},
+		}
+	}
+	export, query, labels := str("export"), str("query"), str("labels")
+	value := &parquetColumn{name: "value", typ: parquetDouble, converted: -1}
+
+	var b [8]byte
+	putString := func(c *parquetColumn, s string) {
+		binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+		c.values.Write(b[:4])
+		c.values.WriteString(s)
+	}
+	for _, r := range rows {
+		binary.LittleEndian.PutUint64(b[:], uint64(r.ts.UnixMicro()))
+		timestamp.values.Write(b[:])
+		putString(export, cfg.Name)
+		putString(query, cfg.Query)
+		putString(labels, r.labels)
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(r.value))
+		value.values.Write(b[:])
+	}
+
+	return []*parquetColumn{timestamp, export, query, labels, value}
+}
+
+// encodeParquet encodes rows as a Parquet file with one row group and a
+// gzip compressed data page per column
+func encodeParquet(cfg utils.ExportConfig, rows []exportRow) ([]byte, error) {
+	columns := exportColumns(cfg, rows)
+
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	type chunk struct {
+		offset, size, uncompressed int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, c := range columns {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(c.values.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+
+		var header thriftWriter
+		header.begin()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(c.values.Len()))
+		header.i32(3, int32(compressed.Len()))
+		header.structField(5)
+		header.i32(1, int32(len(rows)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunks[i] = chunk{
+			offset:       int64(file.Len()),
+			size:         int64(header.buf.Len() + compressed.Len()),
+			uncompressed: int64(header.buf.Len() + c.values.Len()),
+		}
+		file.Write(header.buf.Bytes())
+		file.Write(compressed.Bytes())
+	}
+
+	var meta thriftWriter
+	meta.begin()
+	meta.i32(1, 1) // version
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.begin()
+	meta.string(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.end()
+	for _, c := range columns {
+		meta.begin()
+		meta.i32(1, c.typ)
+		meta.i32(3, parquetRequired)
+		meta.string(4, c.name)
+		if c.converted >= 0 {
+			meta.i32(6, c.converted)
+		}
+		if c.logical != nil {
+			meta.structField(10)
+			c.logical(&meta)
+			meta.end()
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(len(rows)))
+
+	var total int64
+	meta.list(4, thriftStruct, 1)
+	meta.begin()
+	meta.list(1, thriftStruct, len(columns))
+	for i, c := range columns {
+		meta.begin()
+		meta.i64(2, chunks[i].offset)
+		meta.structField(3)
+		meta.i32(1, c.typ)
+		meta.list(2, thriftI32, 2)
+		meta.zigzag(parquetPlain)
+		meta.zigzag(parquetRLE)
+		meta.list(3, thriftBinary, 1)
+		meta.binary(c.name)
+		meta.i32(4, parquetGzip)
+		meta.i64(5, int64(len(rows)))
+		meta.i64(6, chunks[i].uncompressed)
+		meta.i64(7, chunks[i].size)
+		meta.i64(9, chunks[i].offset)
+		meta.end()
+		meta.end()
+		total += chunks[i].uncompressed
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(len(rows)))
+	meta.end()
+	meta.string(6, "lnmonja")
+	meta.end()
+
+	file.Write(meta.buf.Bytes())
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(meta.buf.Len()))
+	file.Write(size[:])
+	file.WriteString(parquetMagic)
+
+	return file.Bytes(), nil
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// thriftReader decodes Thrift compact protocol structs into maps of field
+// ID to value, independently of the writer under test
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		panic("thrift: unexpected end of data")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		panic("thrift: invalid varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		h := r.byte()
+		n, elem := int(h>>4), h&0x0f
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	default:
+		panic(fmt.Sprintf("thrift: unexpected type %d", typ))
+	}
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return fields
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(h & 0x0f)
+		last = id
+	}
+}
+
+func field(t *testing.T, s map[int16]interface{}, id int16) interface{} {
+	t.Helper()
+	v, ok := s[id]
+	if !ok {
+		t.Fatalf("field %d missing from %v", id, s)
+	}
+	return v
+}
+
+func TestEncodeParquet(t *testing.T) {
+	cfg := utils.ExportConfig{Name: "cpu", Query: `cpu_usage{mode="user"}`, Format: utils.ExportFormatParquet}
+	base := time.Date(2026, 10, 16, 12, 0, 0, 123456000, time.UTC)
+	rows := []exportRow{
+		{ts: base, labels: `cpu="0"`, value: 1.5},
+		{ts: base.Add(time.Second), labels: `cpu="1",host="ünïcode"`, value: math.NaN()},
+		{ts: base.Add(2 * time.Second), labels: "", value: math.Inf(1)},
+		{ts: base.Add(3 * time.Second), labels: `cpu="0"`, value: math.Inf(-1)},
+	}
+	// More rows than fit a short list header
+	for i := 0; i < 20; i++ {
+		rows = append(rows, exportRow{ts: base.Add(time.Duration(4+i) * time.Second), labels: `cpu="2"`, value: float64(i)})
+	}
+
+	data, err := encodeParquet(cfg, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(parquetMagic)) || !bytes.HasSuffix(data, []byte(parquetMagic)) {
+		t.Fatal("file is not framed by the Parquet magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - footerLen
+	footer := &thriftReader{data: data[footerStart : len(data)-8]}
+	meta := footer.structure()
+	if footer.pos != footerLen {
+		t.Fatalf("footer decoded %d of %d bytes", footer.pos, footerLen)
+	}
+
+	if field(t, meta, 1) != int64(1) {
+		t.Fatalf("version %v, want 1", meta[1])
+	}
+	if field(t, meta, 3) != int64(len(rows)) {
+		t.Fatalf("num_rows %v, want %d", meta[3], len(rows))
+	}
+
+	// The root, then one required leaf per CSV column
+	schema := field(t, meta, 2).([]interface{})
+	if len(schema) != len(exportCSVHeader)+1 {
+		t.Fatalf("got %d schema elements, want %d", len(schema), len(exportCSVHeader)+1)
+	}
+	root := schema[0].(map[int16]interface{})
+	if field(t, root, 5) != int64(len(exportCSVHeader)) {
+		t.Fatalf("root has %v children, want %d", root[5], len(exportCSVHeader))
+	}
+	types := []int64{parquetInt64, parquetByteArray, parquetByteArray, parquetByteArray, parquetDouble}
+	for i, name := range exportCSVHeader {
+		el := schema[i+1].(map[int16]interface{})
+		if field(t, el, 4) != name || field(t, el, 1) != types[i] || field(t, el, 3) != int64(parquetRequired) {
+			t.Fatalf("schema element %d: got %v", i, el)
+		}
+	}
+	timestamp := field(t, schema[1].(map[int16]interface{}), 10).(map[int16]interface{})
+	ts := field(t, timestamp, 8).(map[int16]interface{})
+	unit := field(t, ts, 2).(map[int16]interface{})
+	if field(t, ts, 1) != true || unit[2] == nil {
+		t.Fatalf("timestamp logical type %v, want TIMESTAMP(MICROS, UTC)", timestamp)
+	}
+
+	groups := field(t, meta, 4).([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("got %d row groups, want 1", len(groups))
+	}
+	group := groups[0].(map[int16]interface{})
+	if field(t, group, 3) != int64(len(rows)) {
+		t.Fatalf("row group has %v rows, want %d", group[3], len(rows))
+	}
+	chunks := field(t, group, 1).([]interface{})
+	if len(chunks) != len(exportCSVHeader) {
+		t.Fatalf("got %d column chunks, want %d", len(chunks), len(exportCSVHeader))
+	}
+
+	end := len(parquetMagic)
+	for i, name := range exportCSVHeader {
+		chunk := chunks[i].(map[int16]interface{})
+		md := field(t, chunk, 3).(map[int16]interface{})
+		path := field(t, md, 3).([]interface{})
+		if len(path) != 1 || path[0] != name || field(t, md, 1) != types[i] || field(t, md, 4) != int64(parquetGzip) {
+			t.Fatalf("column %s: got metadata %v", name, md)
+		}
+		if field(t, md, 5) != int64(len(rows)) {
+			t.Fatalf("column %s: %v values, want %d", name, md[5], len(rows))
+		}
+
+		// Column chunks are contiguous and each is one data page
+		offset := int(field(t, md, 9).(int64))
+		if offset != end || field(t, chunk, 2) != int64(offset) {
+			t.Fatalf("column %s: page at %d, want %d", name, offset, end)
+		}
+		pageReader := &thriftReader{data: data[offset:footerStart]}
+		page := pageReader.structure()
+		compressed := int(field(t, page, 3).(int64))
+		uncompressed := int(field(t, page, 2).(int64))
+		end = offset + pageReader.pos + compressed
+		if field(t, page, 1) != int64(parquetDataPage) || field(t, md, 7) != int64(end-offset) ||
+			field(t, md, 6) != int64(pageReader.pos+uncompressed) {
+			t.Fatalf("column %s: page header %v does not match metadata %v", name, page, md)
+		}
+		header := field(t, page, 5).(map[int16]interface{})
+		if field(t, header, 1) != int64(len(rows)) || field(t, header, 2) != int64(parquetPlain) {
+			t.Fatalf("column %s: data page header %v", name, header)
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(data[end-compressed : end]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		values, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != uncompressed {
+			t.Fatalf("column %s: %d bytes of values, want %d", name, len(values), uncompressed)
+		}
+
+		for j, row := range rows {
+			switch types[i] {
+			case parquetInt64:
+				got := int64(binary.LittleEndian.Uint64(values))
+				values = values[8:]
+				if got != row.ts.UnixMicro() {
+					t.Fatalf("row %d: timestamp %d, want %d", j, got, row.ts.UnixMicro())
+				}
+			case parquetDouble:
+				got := binary.LittleEndian.Uint64(values)
+				values = values[8:]
+				if got != math.Float64bits(row.value) {
+					t.Fatalf("row %d: value %v, want %v", j, math.Float64frombits(got), row.value)
+				}
+			case parquetByteArray:
+				n := binary.LittleEndian.Uint32(values)
+				got := string(values[4 : 4+n])
+				values = values[4+n:]
+				want := []string{"", cfg.Name, cfg.Query, row.labels}[i]
+				if got != want {
+					t.Fatalf("row %d: %s %q, want %q", j, name, got, want)
+				}
+			}
+		}
+		if len(values) != 0 {
+			t.Fatalf("column %s: %d bytes left after the values", name, len(values))
+		}
+	}
+	if end != footerStart {
+		t.Fatalf("column chunks end at %d, footer starts at %d", end, footerStart)
+	}
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var exportCSVHeader = []string{"timestamp", "export", "query", "labels", "value"}
+
+// Exporter runs saved queries on a cron schedule and writes the results as
+// CSV or Parquet to a local directory or an S3 bucket
+type Exporter struct {
+	store  *restStore
+	logger *zap.Logger
+	jobs   []*exportJob
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type exportJob struct {
+	config   utils.ExportConfig
+	schedule *utils.CronSchedule
+	s3       *storage.S3Client
+
+	// lastLocal and lastS3 are the newest samples already written to each
+	// destination, so overlapping ranges do not write duplicate rows and a
+	// failed destination catches up on the next run
+	lastLocal time.Time
+	lastS3    time.Time
+}
+
+// exportRow is a sample of an export's results
+type exportRow struct {
+	ts     time.Time
+	labels string
+	value  float64
+}
+
+// NewExporter creates an exporter for the configured exports
+func NewExporter(exports []utils.ExportConfig, store *restStore, logger *zap.Logger) (*Exporter, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	e := &Exporter{
+		store:  store,
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	for _, cfg := range exports {
+		schedule, err := utils.ParseCron(cfg.Schedule)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("export %s: %w", cfg.Name, err)
+		}
+
+		job := &exportJob{config: cfg, schedule: schedule}
+		if cfg.Destination.S3.Bucket != "" {
+			job.s3, err = storage.NewS3Client(cfg.Destination.S3)
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("export %s: %w", cfg.Name, err)
+			}
+		}
+
+		e.jobs = append(e.jobs, job)
+	}
+
+	return e, nil
+}
+
+// Start runs each export on its schedule until Stop is called
+func (e *Exporter) Start() {
+	for _, job := range e.jobs {
+		e.wg.Add(1)
+		go e.runJob(job)
+	}
+
+	e.logger.Info("Scheduled exports started", zap.Int("exports", len(e.jobs)))
+}
+
+// Stop stops all scheduled exports and waits for running ones to finish
+func (e *Exporter) Stop() {
+	e.cancel()
+	e.wg.Wait()
+}
+
+func (e *Exporter) runJob(job *exportJob) {
+	defer e.wg.Done()
+
+	for {
+		next := job.schedule.Next(time.Now())
+		if next.IsZero() {
+			e.logger.Warn("Export schedule has no future runs", zap.String("export", job.config.Name))
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-e.ctx.Done():
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			if err := e.export(job, now); err != nil {
+				e.logger.Error("Scheduled export failed",
+					zap.String("export", job.config.Name),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// export runs the job's query over its range ending at now and writes the
+// rows each destination has not received yet
+func (e *Exporter) export(job *exportJob, now time.Time) error {
+	cfg := job.config
+	start := now.Add(-cfg.Range)
+	if after := job.exported(); after.After(start) {
+		start = after.Add(time.Nanosecond)
+	}
+
+	e.store.usage.RecordQuery(cfg.Query, UsageSourceExport)
+	series, err := e.store.QueryMetrics(cfg.Query, start, now, cfg.Step)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+
+	rows := exportRows(series)
+	if len(rows) == 0 {
+		e.logger.Debug("Scheduled export produced no rows", zap.String("export", cfg.Name))
+		return nil
+	}
+
+	// Each destination keeps its own progress, so one failing does not
+	// make the other write the same rows twice
+	var errs []error
+	written := 0
+	if cfg.Destination.Path != "" {
+		if pending, newest := rowsAfter(rows, job.lastLocal); len(pending) > 0 {
+			if err := writeLocalExport(cfg, pending, now); err != nil {
+				errs = append(errs, err)
+			} else {
+				job.lastLocal = newest
+				written = len(pending)
+			}
+		}
+	}
+	if job.s3 != nil {
+		if pending, newest := rowsAfter(rows, job.lastS3); len(pending) > 0 {
+			if err := e.uploadExport(job, pending, now); err != nil {
+				errs = append(errs, err)
+			} else {
+				job.lastS3 = newest
+				written = max(written, len(pending))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	e.logger.Info("Scheduled export completed",
+		zap.String("export", cfg.Name),
+		zap.Int("rows", written),
+	)
+
+	return nil
+}
+
+// exported returns the newest sample every destination of the job has
+// received
+func (job *exportJob) exported() time.Time {
+	var after time.Time
+	first := true
+	for _, d := range []struct {
+		enabled bool
+		last    time.Time
+	}{
+		{job.config.Destination.Path != "", job.lastLocal},
+		{job.s3 != nil, job.lastS3},
+	} {
+		if d.enabled && (first || d.last.Before(after)) {
+			after, first = d.last, false
+		}
+	}
+	return after
+}
+
+// writeLocalExport appends rows to the export's CSV file, or writes them
+// to a new Parquet file in the export's directory, as Parquet files cannot
+// be appended to
+func writeLocalExport(cfg utils.ExportConfig, rows []exportRow, now time.Time) error {
+	if cfg.Format == utils.ExportFormatParquet {
+		data, err := encodeParquet(cfg, rows)
+		if err != nil {
+			return err
+		}
+		return writeExportFile(filepath.Join(cfg.Destination.Path, cfg.Name, exportFileName(cfg, now)), data)
+	}
+	return appendCSV(filepath.Join(cfg.Destination.Path, cfg.Name+".csv"), encodeCSVRows(cfg, rows))
+}
+
+// uploadExport writes rows to a new object in the export's S3 prefix
+func (e *Exporter) uploadExport(job *exportJob, rows []exportRow, now time.Time) error {
+	cfg := job.config
+	var (
+		data        []byte
+		err         error
+		contentType = "text/csv"
+	)
+	if cfg.Format == utils.ExportFormatParquet {
+		data, err = encodeParquet(cfg, rows)
+		contentType = "application/vnd.apache.parquet"
+	} else {
+		data, err = encodeCSV(encodeCSVRows(cfg, rows), true)
+	}
+	if err != nil {
+		return err
+	}
+
+	key := job.s3.Key(fmt.Sprintf("%s/%s/%s", cfg.Name, now.UTC().Format("2006/01/02"), exportFileName(cfg, now)))
+	if err := job.s3.PutObject(e.ctx, key, data, contentType); err != nil {
+		return fmt.Errorf("failed to upload export: %w", err)
+	}
+	return nil
+}
+
+// exportFileName names the file of one export run
+func exportFileName(cfg utils.ExportConfig, now time.Time) string {
+	return fmt.Sprintf("%s-%s.%s", cfg.Name, now.UTC().Format("20060102T150405Z"), cfg.Format)
+}
+
+// exportRows flattens series into rows ordered by time
+func exportRows(series []*models.TimeSeries) []exportRow {
+	var rows []exportRow
+	for _, s := range series {
+		labels := formatExportLabels(s.Labels)
+		for _, sample := range s.Samples {
+			rows = append(rows, exportRow{ts: sample.Timestamp, labels: labels, value: sample.Value})
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].ts.Equal(rows[j].ts) {
+			return rows[i].ts.Before(rows[j].ts)
+		}
+		return rows[i].labels < rows[j].labels
+	})
+	return rows
+}
+
+// rowsAfter returns the rows after a time, and the newest of them
+func rowsAfter(rows []exportRow, after time.Time) ([]exportRow, time.Time) {
+	i := sort.Search(len(rows), func(i int) bool { return rows[i].ts.After(after) })
+	if i == len(rows) {
+		return nil, after
+	}
+	return rows[i:], rows[len(rows)-1].ts
+}
+
+// encodeCSVRows formats rows as CSV records
+func encodeCSVRows(cfg utils.ExportConfig, rows []exportRow) [][]string {
+	records := make([][]string, 0, len(rows))
+	for _, r := range rows {
+		records = append(records, []string{
+			r.ts.UTC().Format(time.RFC3339Nano),
+			cfg.Name,
+			cfg.Query,
+			r.labels,
+			strconv.FormatFloat(r.value, 'g', -1, 64),
+		})
+	}
+	return records
+}
+
+// formatExportLabels renders labels as a stable `k="v",...` string so every
+// export has the same set of columns
+func formatExportLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// appendCSV appends rows to a local CSV file, writing the header when the
+// file is new
+func appendCSV(path string, rows [][]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	header := os.IsNotExist(err) || (err == nil && info.Size() == 0)
+
+	data, err := encodeCSV(rows, header)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open export file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+
+	return nil
+}
+
+// writeExportFile writes a new export file, through a temporary file so
+// readers of the directory never see it partly written
+func writeExportFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	return nil
+}
+
+func encodeCSV(rows [][]string, header bool) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if header {
+		if err := w.Write(exportCSVHeader); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return nil, fmt.Errorf("failed to encode csv: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -5,25 +5,36 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/meettoy2004/lnmonja/internal/models"
 	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
 	"github.com/meettoy2004/lnmonja/pkg/protocol"
 	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"github.com/meettoy2004/lnmonja/pkg/version"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type GRPCServer struct {
+	protocol.UnimplementedMonitorServiceServer
+
 	config     *utils.Config
 	server     *grpc.Server
 	listener   net.Listener
@@ -31,8 +42,35 @@ type GRPCServer struct {
 	store      storage.Storage
 	nodeMgr    *NodeManager
 	alertMgr   *AlertManager
+	ingest     *IngestStats
+	rules      *IngestRules
+	decom      *Decommissioner
+	auth       *utils.Authenticator
 	sessions   map[string]*Session
 	sessionsMu sync.RWMutex
+	// pending holds on-demand collection requests awaiting an agent reply
+	pending   map[string]chan *protocol.MetricBatch
+	pendingMu sync.Mutex
+	// certs is set when TLS is enabled; stop ends its watcher
+	certs *certReloader
+	stop  <-chan struct{}
+	// ca issues agent certificates when enrollment is enabled
+	ca *certificateAuthority
+	// otlp serves the OTLP MetricsService, if the receiver is enabled
+	otlp *OTLPReceiver
+	// metrics records ingestion for the server's self-metrics
+	metrics *telemetry.Metrics
+	// filters rejects metric names nodes may not send
+	filters *MetricFilters
+	// naming reports metrics that break the naming conventions
+	naming *NamingChecker
+	// overload sheds low priority metrics when ingestion falls behind
+	overload *OverloadGuard
+	// live receives stored metrics for live clients
+	live LivePublisher
+	// collectorDefaults are sent to registering agents; a config reload
+	// replaces them and pushes them to connected ones
+	collectorDefaults atomic.Pointer[[]utils.CollectorDefaultConfig]
 }
 
 type Session struct {
@@ -43,18 +81,23 @@ type Session struct {
 	Labels      map[string]string
 	Collectors  []string
 	ConnectedAt time.Time
+	sendMu      sync.Mutex
 }
 
-func NewGRPCServer(config *utils.Config, store storage.Storage, logger *zap.Logger) (*GRPCServer, error) {
+// NewGRPCServer creates the agent-facing gRPC server. Node and alert
+// managers are shared with the HTTP side of the server.
+func NewGRPCServer(config *utils.Config, store storage.Storage, nodeMgr *NodeManager, alertMgr *AlertManager, logger *zap.Logger) (*GRPCServer, error) {
 	s := &GRPCServer{
 		config:   config,
 		logger:   logger,
 		store:    store,
+		nodeMgr:  nodeMgr,
+		alertMgr: alertMgr,
+		auth:     utils.NewAuthenticator(&config.Authentication),
 		sessions: make(map[string]*Session),
+		pending:  make(map[string]chan *protocol.MetricBatch),
 	}
-
-	s.nodeMgr = NewNodeManager(store, logger)
-	s.alertMgr = NewAlertManager(config, store, logger)
+	s.SetCollectorDefaults(config.Server.GRPC.CollectorDefaults)
 
 	return s, nil
 }
@@ -62,12 +105,15 @@ func NewGRPCServer(config *utils.Config, store storage.Storage, logger *zap.Logg
 func (s *GRPCServer) Start() error {
 	addr := fmt.Sprintf("%s:%d", s.config.Server.GRPC.Address, s.config.Server.GRPC.Port)
 
-	// Create listener
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen: %w", err)
+	// Create listener, unless one was passed by socket activation
+	listener := s.listener
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", addr); err != nil {
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+		s.listener = listener
 	}
-	s.listener = listener
 
 	// Setup gRPC options
 	opts := []grpc.ServerOption{
@@ -85,6 +131,13 @@ func (s *GRPCServer) Start() error {
 
 	// Add TLS if enabled
 	if s.config.Server.GRPC.TLS.Enabled {
+		if s.config.Server.GRPC.Enrollment.Enabled {
+			ca, err := newCertificateAuthority(&s.config.Server.GRPC.Enrollment, s.logger)
+			if err != nil {
+				return fmt.Errorf("failed to load agent CA: %w", err)
+			}
+			s.ca = ca
+		}
 		creds, err := s.loadTLSCredentials()
 		if err != nil {
 			return fmt.Errorf("failed to load TLS credentials: %w", err)
@@ -95,9 +148,12 @@ func (s *GRPCServer) Start() error {
 	// Create gRPC server
 	s.server = grpc.NewServer(opts...)
 	protocol.RegisterMonitorServiceServer(s.server, s)
+	if s.otlp != nil {
+		s.server.RegisterService(&otlpMetricsServiceDesc, s.otlp)
+	}
 
 	s.logger.Info("Starting gRPC server",
-		zap.String("address", addr),
+		zap.String("address", listener.Addr().String()),
 		zap.Bool("tls", s.config.Server.GRPC.TLS.Enabled),
 	)
 
@@ -117,6 +173,13 @@ func (s *GRPCServer) Stop() {
 	}
 }
 
+// SessionCount returns the number of registered agent sessions
+func (s *GRPCServer) SessionCount() int {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+	return len(s.sessions)
+}
+
 // Implement gRPC methods
 func (s *GRPCServer) Register(ctx context.Context, req *protocol.RegisterRequest) (*protocol.RegisterResponse, error) {
 	s.logger.Info("Node registration",
@@ -129,6 +192,10 @@ func (s *GRPCServer) Register(ctx context.Context, req *protocol.RegisterRequest
 	if req.NodeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "node_id is required")
 	}
+	// A certificate from the built-in CA is only valid for its own node
+	if cert := peerCertificate(ctx); cert != nil && s.ca != nil && s.ca.issued(cert) && cert.Subject.CommonName != req.NodeId {
+		return nil, status.Error(codes.PermissionDenied, "client certificate was issued to another node")
+	}
 
 	// Generate session ID
 	sessionID := utils.GenerateSessionID()
@@ -153,13 +220,17 @@ func (s *GRPCServer) Register(ctx context.Context, req *protocol.RegisterRequest
 		OS:        req.Os,
 		Arch:      req.Arch,
 		Version:   req.Version,
+		BuildInfo: buildInfoFromProto(req.BuildInfo),
 		Labels:    req.Labels,
 		Status:    models.NodeStatusHealthy,
 		LastSeen:  time.Now(),
 		CreatedAt: time.Now(),
+
+		Ephemeral:     req.Ephemeral,
+		InstanceGroup: req.InstanceGroup,
 	}
 
-	if err := s.store.SaveNode(node); err != nil {
+	if err := s.nodeMgr.RegisterNode(node); err != nil {
 		s.logger.Error("Failed to save node", zap.Error(err))
 	}
 
@@ -225,6 +296,11 @@ func (s *GRPCServer) StreamMetrics(stream protocol.MonitorService_StreamMetricsS
 
 		session.LastSeen = time.Now()
 
+		// Replies to on-demand collection are also stored like any batch
+		if batch.RequestId != "" {
+			s.deliverReply(batch)
+		}
+
 		// Process metrics in background
 		go s.processMetrics(session, batch)
 	}
@@ -237,6 +313,158 @@ func (s *GRPCServer) StreamMetrics(stream protocol.MonitorService_StreamMetricsS
 	return nil
 }
 
+// CollectNow asks the agent of a node to run the named collectors
+// immediately and waits for the collected metrics
+func (s *GRPCServer) CollectNow(ctx context.Context, nodeID string, collectors []string) ([]*models.Metric, error) {
+	batch, err := s.request(ctx, nodeID, func(requestID string) *protocol.ControlMessage {
+		return &protocol.ControlMessage{
+			Command: &protocol.ControlMessage_Collect{
+				Collect: &protocol.CollectCommand{
+					Collectors: collectors,
+					RequestId:  requestID,
+				},
+			},
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if batch.Error != "" {
+		return nil, fmt.Errorf("agent error: %s", batch.Error)
+	}
+	return batchToMetrics(nodeID, batch), nil
+}
+
+// UpdateCollectors pushes collector changes to a node's agent and waits
+// for its ConfigAck. The acknowledged collector state is recorded in the
+// node inventory.
+func (s *GRPCServer) UpdateCollectors(ctx context.Context, nodeID string, changes []*models.CollectorChange) ([]*models.CollectorState, error) {
+	pbChanges := make([]*protocol.CollectorChange, 0, len(changes))
+	for _, c := range changes {
+		pbChanges = append(pbChanges, &protocol.CollectorChange{
+			Name:     c.Name,
+			Enabled:  c.Enabled,
+			Interval: c.Interval.Milliseconds(),
+		})
+	}
+
+	batch, err := s.request(ctx, nodeID, func(requestID string) *protocol.ControlMessage {
+		return &protocol.ControlMessage{
+			Command: &protocol.ControlMessage_Config{
+				Config: &protocol.ConfigUpdate{
+					NodeId:     nodeID,
+					Collectors: pbChanges,
+					RequestId:  requestID,
+				},
+			},
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ack := batch.ConfigAck
+	if ack == nil {
+		return nil, fmt.Errorf("agent did not acknowledge the update")
+	}
+	if !ack.Success {
+		return nil, fmt.Errorf("agent rejected the update: %s", ack.Message)
+	}
+
+	states := make([]*models.CollectorState, 0, len(ack.Collectors))
+	inventory := make(map[string]string, 2*len(ack.Collectors))
+	for _, c := range ack.Collectors {
+		interval := (time.Duration(c.Interval) * time.Millisecond).String()
+		states = append(states, &models.CollectorState{
+			Name:     c.Name,
+			Enabled:  c.Enabled,
+			Interval: interval,
+		})
+		inventory[models.InventoryCollectorEnabled(c.Name)] = strconv.FormatBool(c.Enabled)
+		inventory[models.InventoryCollectorInterval(c.Name)] = interval
+	}
+
+	if err := s.nodeMgr.UpdateInventory(nodeID, inventory); err != nil {
+		s.logger.Warn("Failed to update node inventory",
+			zap.String("node_id", nodeID),
+			zap.Error(err),
+		)
+	}
+
+	return states, nil
+}
+
+// request sends a control message to a node's agent and waits for the
+// batch replying to it
+func (s *GRPCServer) request(ctx context.Context, nodeID string, build func(requestID string) *protocol.ControlMessage) (*protocol.MetricBatch, error) {
+	session := s.streamSession(nodeID)
+	if session == nil {
+		return nil, fmt.Errorf("node %s is not connected", nodeID)
+	}
+
+	requestID := utils.GenerateSessionID()
+	reply := make(chan *protocol.MetricBatch, 1)
+
+	s.pendingMu.Lock()
+	s.pending[requestID] = reply
+	s.pendingMu.Unlock()
+
+	defer func() {
+		s.pendingMu.Lock()
+		delete(s.pending, requestID)
+		s.pendingMu.Unlock()
+	}()
+
+	session.sendMu.Lock()
+	err := session.Stream.Send(build(requestID))
+	session.sendMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send command: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for node %s: %w", nodeID, ctx.Err())
+	case batch := <-reply:
+		return batch, nil
+	}
+}
+
+// streamSession returns the most recent session of a node with an open
+// metric stream
+func (s *GRPCServer) streamSession(nodeID string) *Session {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	var latest *Session
+	for _, session := range s.sessions {
+		if session.NodeID != nodeID || session.Stream == nil {
+			continue
+		}
+		if latest == nil || session.ConnectedAt.After(latest.ConnectedAt) {
+			latest = session
+		}
+	}
+	return latest
+}
+
+func (s *GRPCServer) deliverReply(batch *protocol.MetricBatch) {
+	s.pendingMu.Lock()
+	reply, ok := s.pending[batch.RequestId]
+	s.pendingMu.Unlock()
+
+	if !ok {
+		// The request already timed out
+		return
+	}
+
+	select {
+	case reply <- batch:
+	default:
+	}
+}
+
 // Heartbeat handles heartbeat requests from agents
 func (s *GRPCServer) Heartbeat(ctx context.Context, req *protocol.HeartbeatRequest) (*protocol.HeartbeatResponse, error) {
 	// Get session
@@ -254,12 +482,137 @@ func (s *GRPCServer) Heartbeat(ctx context.Context, req *protocol.HeartbeatReque
 	// Update node status
 	s.nodeMgr.UpdateNodeStatus(session.NodeID, models.NodeStatusHealthy)
 
+	// Update node inventory
+	if len(req.Inventory) > 0 {
+		if err := s.nodeMgr.UpdateInventory(session.NodeID, req.Inventory); err != nil {
+			s.logger.Warn("Failed to update node inventory",
+				zap.String("node_id", session.NodeID),
+				zap.Error(err),
+			)
+		}
+	}
+
+	// Update node vitals
+	if req.Vitals != nil {
+		if err := s.nodeMgr.UpdateVitals(session.NodeID, vitalsFromProto(req.Vitals)); err != nil {
+			s.logger.Warn("Failed to update node vitals",
+				zap.String("node_id", session.NodeID),
+				zap.Error(err),
+			)
+		}
+	}
+
 	return &protocol.HeartbeatResponse{
 		Alive:         true,
 		NextHeartbeat: time.Now().Add(s.config.Server.GRPC.HeartbeatInterval).Unix(),
 	}, nil
 }
 
+// Unregister handles a clean agent shutdown. The node is marked stopped, or
+// departed if it is ephemeral, instead of timing out and alerting offline.
+func (s *GRPCServer) Unregister(ctx context.Context, req *protocol.UnregisterRequest) (*protocol.UnregisterResponse, error) {
+	s.sessionsMu.Lock()
+	session, exists := s.sessions[req.SessionId]
+	delete(s.sessions, req.SessionId)
+	s.sessionsMu.Unlock()
+
+	if !exists {
+		return nil, status.Error(codes.Unauthenticated, "invalid session")
+	}
+
+	s.logger.Info("Node unregistering",
+		zap.String("node_id", session.NodeID),
+		zap.String("session_id", req.SessionId),
+		zap.String("reason", req.Reason),
+	)
+
+	info, err := s.nodeMgr.GetNode(session.NodeID)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	if info.Node.Ephemeral && s.decom != nil {
+		_, err = s.decom.Depart(session.NodeID)
+	} else {
+		_, err = s.nodeMgr.MarkStopped(session.NodeID, req.Reason)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &protocol.UnregisterResponse{Success: true}, nil
+}
+
+// Enroll issues a client certificate to an agent that presents an
+// enrollment token
+func (s *GRPCServer) Enroll(ctx context.Context, req *protocol.EnrollRequest) (*protocol.CertificateResponse, error) {
+	if s.ca == nil {
+		return nil, status.Error(codes.Unimplemented, "agent enrollment is not enabled")
+	}
+	if !s.ca.validToken(req.Token) {
+		s.logger.Warn("Enrollment with an invalid token", zap.String("node_id", req.NodeId))
+		return nil, status.Error(codes.Unauthenticated, "invalid enrollment token")
+	}
+	if req.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "node_id is required")
+	}
+
+	cert, notAfter, err := s.ca.sign(req.Csr, req.NodeId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	s.logger.Info("Enrolled agent",
+		zap.String("node_id", req.NodeId),
+		zap.Time("not_after", notAfter),
+	)
+	return &protocol.CertificateResponse{
+		Certificate: cert,
+		ExpiresAt:   timestamppb.New(notAfter),
+	}, nil
+}
+
+// RenewCertificate issues a new certificate for the node of the client
+// certificate the call was made with
+func (s *GRPCServer) RenewCertificate(ctx context.Context, req *protocol.RenewCertificateRequest) (*protocol.CertificateResponse, error) {
+	if s.ca == nil {
+		return nil, status.Error(codes.Unimplemented, "agent enrollment is not enabled")
+	}
+	current := peerCertificate(ctx)
+	if current == nil || !s.ca.issued(current) {
+		return nil, status.Error(codes.PermissionDenied, "only certificates issued by the server CA can be renewed")
+	}
+
+	nodeID := current.Subject.CommonName
+	cert, notAfter, err := s.ca.sign(req.Csr, nodeID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	s.logger.Debug("Renewed agent certificate",
+		zap.String("node_id", nodeID),
+		zap.Time("not_after", notAfter),
+	)
+	return &protocol.CertificateResponse{
+		Certificate: cert,
+		ExpiresAt:   timestamppb.New(notAfter),
+	}, nil
+}
+
+// peerCertificate returns the verified client certificate of a call, or
+// nil if the client sent none
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
 // UpdateConfig handles configuration update requests
 func (s *GRPCServer) UpdateConfig(ctx context.Context, req *protocol.ConfigUpdate) (*protocol.ConfigAck, error) {
 	s.logger.Info("Config update received",
@@ -276,29 +629,62 @@ func (s *GRPCServer) UpdateConfig(ctx context.Context, req *protocol.ConfigUpdat
 }
 
 func (s *GRPCServer) processMetrics(session *Session, batch *protocol.MetricBatch) {
-	// Convert protobuf metrics to internal models
-	metrics := make([]*models.Metric, 0, len(batch.Metrics))
+	level := s.overload.enter()
+	defer s.overload.leave()
 
-	for _, pbMetric := range batch.Metrics {
-		metric := &models.Metric{
-			NodeID:    session.NodeID,
-			Name:      pbMetric.Name,
-			Value:     pbMetric.Value,
-			Timestamp: time.Unix(0, pbMetric.Timestamp),
-			Labels:    pbMetric.Labels,
-			Type:      models.MetricType(pbMetric.Type),
-			Help:      pbMetric.Help,
-			Unit:      pbMetric.Unit,
+	// Replies to on-demand collection were asked for, so they are not shed
+	pbMetrics := batch.Metrics
+	if batch.RequestId == "" {
+		pbMetrics = s.overload.filter(level, pbMetrics)
+	}
+
+	// Convert protobuf metrics to internal models, in a slab reused once
+	// the batch is processed. Nothing below keeps the metrics.
+	slab := getMetricSlab(len(pbMetrics))
+	defer putMetricSlab(slab)
+	metrics := slab.fill(session.NodeID, pbMetrics)
+	if len(metrics) == 0 {
+		return
+	}
+
+	serverLabels := s.nodeMgr.ServerLabels(session.NodeID)
+	if s.filters != nil {
+		if metrics = s.filters.Apply(session.NodeID, nodeLabels(session.Labels, serverLabels), metrics); len(metrics) == 0 {
+			return
+		}
+	}
+	if s.naming != nil {
+		s.naming.Check(session.NodeID, metrics)
+	}
+
+	addNodeLabels(metrics, serverLabels)
+
+	// Ingest rules see server-side labels; stats count what is stored
+	if s.rules != nil {
+		received := len(metrics)
+		metrics = s.rules.Apply(metrics)
+		s.metrics.Dropped("grpc", received-len(metrics))
+		if len(metrics) == 0 {
+			return
 		}
-		metrics = append(metrics, metric)
+	}
+
+	if s.ingest != nil {
+		s.ingest.Record(session.NodeID, metrics)
 	}
 
 	// Store metrics
 	if err := s.store.WriteMetrics(metrics); err != nil {
+		s.metrics.StorageError("write")
 		s.logger.Error("Failed to store metrics",
 			zap.String("node_id", session.NodeID),
 			zap.Error(err),
 		)
+	} else {
+		s.metrics.Ingested("grpc", len(metrics))
+		if s.live != nil {
+			s.live.BroadcastMetrics(metrics)
+		}
 	}
 
 	// Check alerts
@@ -308,6 +694,139 @@ func (s *GRPCServer) processMetrics(session *Session, batch *protocol.MetricBatc
 	s.nodeMgr.UpdateNodeStatus(session.NodeID, models.NodeStatusHealthy)
 }
 
+// addNodeLabels adds server-side node labels to metrics. Labels reported by
+// the agent take precedence.
+func addNodeLabels(metrics []*models.Metric, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+
+	for _, metric := range metrics {
+		merged := make(map[string]string, len(metric.Labels)+len(labels))
+		for k, v := range labels {
+			merged[k] = v
+		}
+		for k, v := range metric.Labels {
+			merged[k] = v
+		}
+		metric.Labels = merged
+	}
+}
+
+// nodeLabels merges a node's server-side labels over those its agent
+// reports
+func nodeLabels(agent, server map[string]string) map[string]string {
+	if len(server) == 0 {
+		return agent
+	}
+	merged := copyLabels(agent)
+	for k, v := range server {
+		merged[k] = v
+	}
+	return merged
+}
+
+func batchToMetrics(nodeID string, batch *protocol.MetricBatch) []*models.Metric {
+	metrics := make([]*models.Metric, 0, len(batch.Metrics))
+
+	for _, pbMetric := range batch.Metrics {
+		metric := &models.Metric{
+			NodeID:    nodeID,
+			Name:      utils.Symbols.Intern(pbMetric.Name),
+			Value:     pbMetric.Value,
+			Timestamp: time.Unix(0, pbMetric.Timestamp),
+			Labels:    utils.Symbols.InternLabels(pbMetric.Labels),
+			Type:      models.MetricType(pbMetric.Type),
+			Help:      pbMetric.Help,
+			Unit:      pbMetric.Unit,
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return metrics
+}
+
+// metricSlab holds the metrics of one batch in a single allocation, reused
+// across batches
+type metricSlab struct {
+	metrics []models.Metric
+	ptrs    []*models.Metric
+}
+
+var metricSlabs = sync.Pool{
+	New: func() interface{} { return &metricSlab{} },
+}
+
+// maxPooledSlab keeps slabs grown by unusually large batches out of the
+// pool
+const maxPooledSlab = 16384
+
+func getMetricSlab(n int) *metricSlab {
+	slab := metricSlabs.Get().(*metricSlab)
+	if cap(slab.metrics) < n {
+		slab.metrics = make([]models.Metric, n)
+		slab.ptrs = make([]*models.Metric, 0, n)
+	}
+	return slab
+}
+
+func putMetricSlab(slab *metricSlab) {
+	if cap(slab.metrics) > maxPooledSlab {
+		return
+	}
+	// Drop the references to the batch's strings and labels
+	clear(slab.metrics[:cap(slab.metrics)])
+	clear(slab.ptrs[:cap(slab.ptrs)])
+	slab.ptrs = slab.ptrs[:0]
+	metricSlabs.Put(slab)
+}
+
+// fill converts a batch's metrics like batchToMetrics, into the slab
+func (slab *metricSlab) fill(nodeID string, pbMetrics []*protocol.Metric) []*models.Metric {
+	slab.metrics = slab.metrics[:len(pbMetrics)]
+	for i, pbMetric := range pbMetrics {
+		slab.metrics[i] = models.Metric{
+			NodeID:    nodeID,
+			Name:      utils.Symbols.Intern(pbMetric.Name),
+			Value:     pbMetric.Value,
+			Timestamp: time.Unix(0, pbMetric.Timestamp),
+			Labels:    utils.Symbols.InternLabels(pbMetric.Labels),
+			Type:      models.MetricType(pbMetric.Type),
+			Help:      pbMetric.Help,
+			Unit:      pbMetric.Unit,
+		}
+		slab.ptrs = append(slab.ptrs, &slab.metrics[i])
+	}
+	return slab.ptrs
+}
+
+func vitalsFromProto(v *protocol.NodeVitals) *models.NodeVitals {
+	return &models.NodeVitals{
+		CPUPercent:        v.CpuPercent,
+		MemoryPercent:     v.MemoryPercent,
+		Load1:             v.Load1,
+		DroppedBatches:    v.DroppedBatches,
+		DroppedMetrics:    v.DroppedMetrics,
+		CollectorErrors:   v.CollectorErrors,
+		FailingCollectors: v.FailingCollectors,
+		ReportedAt:        time.Now(),
+	}
+}
+
+func buildInfoFromProto(info *protocol.VersionInfo) *version.Info {
+	if info == nil {
+		return nil
+	}
+	return &version.Info{
+		Version:   info.Version,
+		BuildTime: info.BuildTime,
+		GitCommit: info.GitCommit,
+		GoVersion: info.GoVersion,
+		OS:        info.Os,
+		Arch:      info.Arch,
+	}
+}
+
 func (s *GRPCServer) handleHeartbeat(ctx context.Context, session *Session) {
 	ticker := time.NewTicker(s.config.Server.GRPC.HeartbeatInterval)
 	defer ticker.Stop()
@@ -331,47 +850,44 @@ func (s *GRPCServer) handleHeartbeat(ctx context.Context, session *Session) {
 }
 
 func (s *GRPCServer) getCollectorConfigs(req *protocol.RegisterRequest) []*protocol.CollectorConfig {
-	configs := []*protocol.CollectorConfig{}
-
-	// Default collectors
-	defaultCollectors := map[string]*protocol.CollectorConfig{
-		"system": {
-			Name:     "system",
-			Enabled:  true,
-			Interval: 1000, // 1 second in milliseconds
-			Params: map[string]string{
-				"include_cpu":    "true",
-				"include_memory": "true",
-				"include_disk":   "true",
-			},
-		},
-		"process": {
-			Name:     "process",
-			Enabled:  true,
-			Interval: 5000, // 5 seconds
-		},
+	defaults := *s.collectorDefaults.Load()
+	configs := make([]*protocol.CollectorConfig, 0, len(defaults)+1)
+
+	hasContainer := false
+	for _, collector := range defaults {
+		configs = append(configs, &protocol.CollectorConfig{
+			Name:     collector.Name,
+			Enabled:  collector.Enabled,
+			Interval: collector.Interval.Milliseconds(),
+			Params:   collector.Params,
+		})
+		hasContainer = hasContainer || collector.Name == "container"
 	}
 
 	// Check if node has docker
-	if s.hasDocker() {
-		defaultCollectors["container"] = &protocol.CollectorConfig{
+	if !hasContainer && s.hasDocker() {
+		configs = append(configs, &protocol.CollectorConfig{
 			Name:     "container",
 			Enabled:  true,
 			Interval: 2000,
 			Params: map[string]string{
 				"runtime": "docker",
 			},
-		}
-	}
-
-	// Add all enabled collectors
-	for _, collector := range defaultCollectors {
-		configs = append(configs, collector)
+		})
 	}
 
 	return configs
 }
 
+// SetCollectorDefaults replaces the collectors registering agents are told
+// to run, and pushes changed defaults to the agents already connected
+func (s *GRPCServer) SetCollectorDefaults(defaults []utils.CollectorDefaultConfig) {
+	previous := s.collectorDefaults.Swap(&defaults)
+	if previous != nil && !reflect.DeepEqual(*previous, defaults) {
+		go s.pushCollectorDefaults(defaults)
+	}
+}
+
 func (s *GRPCServer) hasDocker() bool {
 	// Check if Docker socket exists
 	// This is a simplified check
@@ -381,33 +897,102 @@ func (s *GRPCServer) hasDocker() bool {
 	return false
 }
 
+// loadTLSCredentials loads the server certificate and client CAs, which are
+// reloaded when their files change
 func (s *GRPCServer) loadTLSCredentials() (credentials.TransportCredentials, error) {
-	cert, err := tls.LoadX509KeyPair(
-		s.config.Server.GRPC.TLS.CertFile,
-		s.config.Server.GRPC.TLS.KeyFile,
-	)
+	tlsConfig := s.config.Server.GRPC.TLS
+	certs, err := newCertReloader(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile, s.logger)
 	if err != nil {
 		return nil, err
 	}
+	s.certs = certs
+	go certs.watch(s.stop)
+
+	clientAuth := tls.RequireAndVerifyClientCert
+	if s.ca != nil {
+		// Enrolling agents have no certificate yet, so authorize requires
+		// one for the other methods instead
+		certs.trust(s.ca.cert)
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	config, err := certs.tlsConfig(clientAuth, &s.config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(config), nil
+}
 
-	config := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    x509.NewCertPool(),
+// grpcMethodRoles is the role needed to call each method. Methods not
+// listed, which are used by agents, need the editor role.
+var grpcMethodRoles = map[string]utils.Role{
+	"UpdateConfig": utils.RoleAdmin,
+}
+
+// authorize authenticates the API key in the call's metadata, sent as
+// x-api-key or a bearer token, and checks the principal's role for the
+// method
+func (s *GRPCServer) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	if s.ca != nil {
+		// An enrolling agent authenticates with its token instead
+		if path.Base(fullMethod) == "Enroll" {
+			return ctx, nil
+		}
+		if peerCertificate(ctx) == nil {
+			return nil, status.Error(codes.Unauthenticated, "a client certificate is required; enroll the agent first")
+		}
 	}
 
-	// Load CA certificate
-	if caCert, err := ioutil.ReadFile(s.config.Server.GRPC.TLS.ClientCAFile); err == nil {
-		config.ClientCAs.AppendCertsFromPEM(caCert)
+	if !s.auth.Enabled() {
+		return ctx, nil
 	}
 
-	return credentials.NewTLS(config), nil
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-api-key"); len(v) > 0 {
+			key = v[0]
+		} else if v := md.Get("authorization"); len(v) > 0 {
+			key = strings.TrimPrefix(v[0], "Bearer ")
+		}
+	}
+	principal, err := s.auth.APIKey(key)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid or missing API key")
+	}
+
+	required, ok := grpcMethodRoles[path.Base(fullMethod)]
+	if !ok {
+		required = utils.RoleEditor
+	}
+	if !principal.Role.Allows(required) {
+		s.logger.Warn("gRPC call denied",
+			zap.String("method", fullMethod),
+			zap.String("principal", principal.Name),
+			zap.String("role", string(principal.Role)),
+		)
+		return nil, status.Errorf(codes.PermissionDenied, "%s role required", required)
+	}
+
+	return utils.WithPrincipal(ctx, principal), nil
+}
+
+// authorizedStream carries the authenticated principal in its context
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
 }
 
 func (s *GRPCServer) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	// Authentication and logging for streaming
+	ctx, err := s.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
 	start := time.Now()
-	err := handler(srv, ss)
+	err = handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
 	duration := time.Since(start)
 
 	s.logger.Debug("Stream request",
@@ -420,7 +1005,11 @@ func (s *GRPCServer) streamInterceptor(srv interface{}, ss grpc.ServerStream, in
 }
 
 func (s *GRPCServer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	// Authentication and logging for unary RPCs
+	ctx, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
 	start := time.Now()
 	resp, err := handler(ctx, req)
 	duration := time.Since(start)
@@ -432,4 +1021,4 @@ func (s *GRPCServer) unaryInterceptor(ctx context.Context, req interface{}, info
 	)
 
 	return resp, err
-}
\ No newline at end of file
+}
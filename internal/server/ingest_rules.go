@@ -0,0 +1,261 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var (
+	ingestRuleNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	labelNamePattern      = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// IngestRules keeps the ingest rules, parsed and in the order they run,
+// in memory and persists them to storage
+type IngestRules struct {
+	store  storage.Storage
+	logger *zap.Logger
+	rules  map[string]*ingestRule
+	// ordered is replaced, never modified, so Apply can use it unlocked
+	ordered []*ingestRule
+	mu      sync.RWMutex
+}
+
+type ingestRule struct {
+	def      *models.IngestRule
+	matchers []*utils.Matcher
+}
+
+// NewIngestRules creates the registry and loads stored rules
+func NewIngestRules(store storage.Storage, logger *zap.Logger) (*IngestRules, error) {
+	ir := &IngestRules{
+		store:  store,
+		logger: logger,
+		rules:  make(map[string]*ingestRule),
+	}
+
+	defs, err := store.ListIngestRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ingest rules: %w", err)
+	}
+
+	for _, def := range defs {
+		matchers, err := validateIngestRule(def)
+		if err != nil {
+			logger.Warn("Skipping invalid ingest rule",
+				zap.String("name", def.Name),
+				zap.Error(err),
+			)
+			continue
+		}
+		ir.rules[def.Name] = &ingestRule{def: def, matchers: matchers}
+	}
+	ir.reorder()
+
+	return ir, nil
+}
+
+// Save validates and stores an ingest rule, which applies to metrics
+// received from then on
+func (ir *IngestRules) Save(def *models.IngestRule) (*models.IngestRule, error) {
+	matchers, err := validateIngestRule(def)
+	if err != nil {
+		return nil, err
+	}
+
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	now := time.Now().UTC()
+	saved := *def
+	saved.CreatedAt = now
+	if existing, exists := ir.rules[def.Name]; exists {
+		saved.CreatedAt = existing.def.CreatedAt
+	}
+	saved.UpdatedAt = now
+
+	if err := ir.store.SaveIngestRule(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save ingest rule: %w", err)
+	}
+	ir.rules[def.Name] = &ingestRule{def: &saved, matchers: matchers}
+	ir.reorder()
+
+	ir.logger.Info("Ingest rule saved",
+		zap.String("name", saved.Name),
+		zap.String("action", saved.Action),
+		zap.Strings("matchers", saved.Matchers),
+	)
+
+	return &saved, nil
+}
+
+// Delete removes an ingest rule
+func (ir *IngestRules) Delete(name string) error {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	if _, exists := ir.rules[name]; !exists {
+		return fmt.Errorf("ingest rule %s not found", name)
+	}
+
+	if err := ir.store.DeleteIngestRule(name); err != nil {
+		return fmt.Errorf("failed to delete ingest rule: %w", err)
+	}
+	delete(ir.rules, name)
+	ir.reorder()
+
+	ir.logger.Info("Ingest rule deleted", zap.String("name", name))
+	return nil
+}
+
+// Get returns an ingest rule by name
+func (ir *IngestRules) Get(name string) (*models.IngestRule, bool) {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+
+	rule, exists := ir.rules[name]
+	if !exists {
+		return nil, false
+	}
+	return rule.def, true
+}
+
+// List returns the ingest rules in the order they run
+func (ir *IngestRules) List() []*models.IngestRule {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+
+	defs := make([]*models.IngestRule, len(ir.ordered))
+	for i, rule := range ir.ordered {
+		defs[i] = rule.def
+	}
+	return defs
+}
+
+// reorder rebuilds the run order. Callers must hold mu.
+func (ir *IngestRules) reorder() {
+	ordered := make([]*ingestRule, 0, len(ir.rules))
+	for _, rule := range ir.rules {
+		ordered = append(ordered, rule)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].def.Order != ordered[j].def.Order {
+			return ordered[i].def.Order < ordered[j].def.Order
+		}
+		return ordered[i].def.Name < ordered[j].def.Name
+	})
+	ir.ordered = ordered
+}
+
+// Apply runs the rules over a batch, changing metrics in place, and
+// returns those not dropped
+func (ir *IngestRules) Apply(metrics []*models.Metric) []*models.Metric {
+	ir.mu.RLock()
+	rules := ir.ordered
+	ir.mu.RUnlock()
+
+	if len(rules) == 0 {
+		return metrics
+	}
+
+	kept := metrics[:0]
+	for _, metric := range metrics {
+		if applyIngestRules(rules, metric) {
+			kept = append(kept, metric)
+		}
+	}
+	return kept
+}
+
+// applyIngestRules runs the rules over a metric, reporting false if one
+// dropped it
+func applyIngestRules(rules []*ingestRule, metric *models.Metric) bool {
+	for _, rule := range rules {
+		if !rule.matches(metric) {
+			continue
+		}
+
+		switch rule.def.Action {
+		case models.IngestActionDrop:
+			return false
+		case models.IngestActionRename:
+			metric.Name = rule.def.NewName
+		case models.IngestActionAddLabels:
+			if metric.Labels == nil {
+				metric.Labels = make(map[string]string, len(rule.def.Labels))
+			}
+			for k, v := range rule.def.Labels {
+				metric.Labels[k] = v
+			}
+		case models.IngestActionScale:
+			metric.Value *= rule.def.Factor
+			if rule.def.Unit != "" {
+				metric.Unit = rule.def.Unit
+			}
+		}
+	}
+	return true
+}
+
+// matches reports whether a metric satisfies all of the rule's matchers.
+// __name__ matches the metric name.
+func (r *ingestRule) matches(metric *models.Metric) bool {
+	for _, m := range r.matchers {
+		value := metric.Labels[m.Name]
+		if m.Name == "__name__" {
+			value = metric.Name
+		}
+		if !m.MatchesValue(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateIngestRule checks a rule's name, matchers and action settings
+func validateIngestRule(def *models.IngestRule) ([]*utils.Matcher, error) {
+	if !ingestRuleNamePattern.MatchString(def.Name) {
+		return nil, fmt.Errorf("invalid ingest rule name: %q", def.Name)
+	}
+	matchers, err := utils.ParseMatchers(def.Matchers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid matchers: %w", err)
+	}
+
+	switch def.Action {
+	case models.IngestActionDrop:
+		if len(matchers) == 0 {
+			return nil, fmt.Errorf("a drop rule must have at least one matcher")
+		}
+	case models.IngestActionRename:
+		if !derivedNamePattern.MatchString(def.NewName) {
+			return nil, fmt.Errorf("invalid new_name: %q", def.NewName)
+		}
+	case models.IngestActionAddLabels:
+		if len(def.Labels) == 0 {
+			return nil, fmt.Errorf("an add_labels rule must have labels")
+		}
+		for name := range def.Labels {
+			if !labelNamePattern.MatchString(name) || name == "__name__" {
+				return nil, fmt.Errorf("invalid label name: %q", name)
+			}
+		}
+	case models.IngestActionScale:
+		if def.Factor == 0 || math.IsNaN(def.Factor) || math.IsInf(def.Factor, 0) {
+			return nil, fmt.Errorf("a scale rule must have a non-zero factor")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q, expected %s, %s, %s or %s", def.Action,
+			models.IngestActionDrop, models.IngestActionRename, models.IngestActionAddLabels, models.IngestActionScale)
+	}
+	return matchers, nil
+}
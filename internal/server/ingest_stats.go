@@ -0,0 +1,256 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+const (
+	// ingestBucketWidth is the resolution of ingestion statistics
+	ingestBucketWidth = 10 * time.Second
+
+	// maxIngestWindow is the longest window ingestion statistics cover
+	maxIngestWindow = 15 * time.Minute
+)
+
+// IngestStats tracks recent ingestion volume per node and metric name in
+// fixed-width time buckets
+type IngestStats struct {
+	started time.Time
+	buckets []*ingestBucket
+
+	// series holds the last time each series was received
+	series map[string]*ingestSeries
+	mu     sync.Mutex
+
+	// meter counts the samples per tenant, if metering is enabled
+	meter *Metering
+	// trends aggregates node CPU and disk usage, if trends are enabled
+	trends *Trends
+}
+
+type ingestBucket struct {
+	start   time.Time
+	nodes   map[string]*ingestCounter
+	metrics map[string]*ingestCounter
+}
+
+type ingestCounter struct {
+	samples  int64
+	bytes    int64
+	rejected int64
+}
+
+type ingestSeries struct {
+	node     string
+	metric   string
+	lastSeen time.Time
+}
+
+// NewIngestStats creates an empty ingestion tracker
+func NewIngestStats() *IngestStats {
+	return &IngestStats{
+		started: time.Now(),
+		series:  make(map[string]*ingestSeries),
+	}
+}
+
+// Record accounts a batch of metrics received from a node
+func (s *IngestStats) Record(nodeID string, metrics []*models.Metric) {
+	if len(metrics) == 0 {
+		return
+	}
+	if s.meter != nil {
+		s.meter.RecordIngest(metrics)
+	}
+	if s.trends != nil {
+		s.trends.RecordIngest(nodeID, metrics)
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.bucket(now)
+	node := b.nodes[nodeID]
+	if node == nil {
+		node = &ingestCounter{}
+		b.nodes[nodeID] = node
+	}
+
+	for _, m := range metrics {
+		size := metricWireSize(m)
+
+		node.samples++
+		node.bytes += size
+
+		counter := b.metrics[m.Name]
+		if counter == nil {
+			counter = &ingestCounter{}
+			b.metrics[m.Name] = counter
+		}
+		counter.samples++
+		counter.bytes += size
+
+		key := nodeID + "|" + m.Name + "|" + utils.HashLabels(m.Labels)
+		if series, ok := s.series[key]; ok {
+			series.lastSeen = now
+		} else {
+			s.series[key] = &ingestSeries{node: nodeID, metric: m.Name, lastSeen: now}
+		}
+	}
+}
+
+// RecordRejected accounts samples from a node refused by metric filters
+func (s *IngestStats) RecordRejected(nodeID string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.bucket(time.Now())
+	node := b.nodes[nodeID]
+	if node == nil {
+		node = &ingestCounter{}
+		b.nodes[nodeID] = node
+	}
+	node.rejected += int64(n)
+}
+
+// bucket returns the bucket for now, dropping buckets and series that fell
+// out of the maximum window
+func (s *IngestStats) bucket(now time.Time) *ingestBucket {
+	start := now.Truncate(ingestBucketWidth)
+	if n := len(s.buckets); n > 0 && s.buckets[n-1].start.Equal(start) {
+		return s.buckets[n-1]
+	}
+
+	cutoff := now.Add(-maxIngestWindow)
+	i := 0
+	for i < len(s.buckets) && s.buckets[i].start.Add(ingestBucketWidth).Before(cutoff) {
+		i++
+	}
+	s.buckets = s.buckets[i:]
+
+	for key, series := range s.series {
+		if series.lastSeen.Before(cutoff) {
+			delete(s.series, key)
+		}
+	}
+
+	b := &ingestBucket{
+		start:   start,
+		nodes:   make(map[string]*ingestCounter),
+		metrics: make(map[string]*ingestCounter),
+	}
+	s.buckets = append(s.buckets, b)
+	return b
+}
+
+// Snapshot returns ingestion rates over the given window, largest first.
+// limit caps the number of nodes and metrics returned; 0 means no limit.
+func (s *IngestStats) Snapshot(window time.Duration, limit int) *models.IngestStats {
+	if window <= 0 || window > maxIngestWindow {
+		window = maxIngestWindow
+	}
+
+	now := time.Now()
+	from := now.Add(-window)
+
+	// Rates are averaged over the time we have actually been tracking
+	elapsed := window
+	if since := now.Sub(s.started); since < elapsed {
+		elapsed = since
+	}
+	seconds := elapsed.Seconds()
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes := make(map[string]*models.IngestRate)
+	metrics := make(map[string]*models.IngestRate)
+	rate := func(rates map[string]*models.IngestRate, name string) *models.IngestRate {
+		r, ok := rates[name]
+		if !ok {
+			r = &models.IngestRate{Name: name}
+			rates[name] = r
+		}
+		return r
+	}
+
+	stats := &models.IngestStats{Window: window.String(), From: from, To: now}
+	var totalBytes int64
+
+	for _, b := range s.buckets {
+		if b.start.Add(ingestBucketWidth).Before(from) {
+			continue
+		}
+		for name, c := range b.nodes {
+			r := rate(nodes, name)
+			r.Samples += c.samples
+			r.BytesPerSec += float64(c.bytes)
+			r.Rejected += c.rejected
+			stats.Total.Samples += c.samples
+			stats.Total.Rejected += c.rejected
+			totalBytes += c.bytes
+		}
+		for name, c := range b.metrics {
+			r := rate(metrics, name)
+			r.Samples += c.samples
+			r.BytesPerSec += float64(c.bytes)
+		}
+	}
+
+	for _, series := range s.series {
+		if series.lastSeen.Before(from) {
+			continue
+		}
+		rate(nodes, series.node).ActiveSeries++
+		rate(metrics, series.metric).ActiveSeries++
+		stats.Total.ActiveSeries++
+	}
+
+	stats.Total.SamplesPerSec = float64(stats.Total.Samples) / seconds
+	stats.Total.BytesPerSec = float64(totalBytes) / seconds
+	stats.Nodes = ingestRates(nodes, seconds, limit)
+	stats.Metrics = ingestRates(metrics, seconds, limit)
+
+	return stats
+}
+
+// ingestRates converts totals to per-second rates, sorted by sample rate
+func ingestRates(rates map[string]*models.IngestRate, seconds float64, limit int) []*models.IngestRate {
+	list := make([]*models.IngestRate, 0, len(rates))
+	for _, r := range rates {
+		r.SamplesPerSec = float64(r.Samples) / seconds
+		r.BytesPerSec /= seconds
+		list = append(list, r)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Samples != list[j].Samples {
+			return list[i].Samples > list[j].Samples
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// metricWireSize estimates the encoded size of a metric sample: its strings
+// plus value, timestamp and type fields
+func metricWireSize(m *models.Metric) int64 {
+	size := len(m.Name) + len(m.Help) + len(m.Unit) + 8 + 8 + 1
+	for k, v := range m.Labels {
+		size += len(k) + len(v) + 2
+	}
+	return int64(size)
+}
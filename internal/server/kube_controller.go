@@ -0,0 +1,452 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/kube"
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Plural names of the lnmonja custom resources
+const (
+	kubeAlertRules   = "alertrules"
+	kubeDashboards   = "dashboards"
+	kubeAgentConfigs = "agentconfigs"
+)
+
+// alertRuleSpec is the spec of an AlertRule resource
+type alertRuleSpec struct {
+	Metric      string            `json:"metric"`
+	Operator    string            `json:"operator"`
+	Threshold   float64           `json:"threshold"`
+	For         string            `json:"for,omitempty"`
+	Severity    string            `json:"severity,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Enabled     *bool             `json:"enabled,omitempty"`
+}
+
+// dashboardSpec is the spec of a Dashboard resource
+type dashboardSpec struct {
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Panels      []*models.Panel   `json:"panels,omitempty"`
+	Variables   map[string]string `json:"variables,omitempty"`
+}
+
+// agentConfigSpec is the spec of an AgentConfig resource. It applies to
+// nodes whose labels and server labels match every selector entry.
+type agentConfigSpec struct {
+	NodeSelector map[string]string                `json:"nodeSelector,omitempty"`
+	Collectors   map[string]*agentCollectorConfig `json:"collectors"`
+}
+
+type agentCollectorConfig struct {
+	Enabled  *bool  `json:"enabled,omitempty"`
+	Interval string `json:"interval,omitempty"`
+}
+
+type kubeDashboard struct {
+	dashboard  *models.Dashboard
+	generation int64
+}
+
+// agentConfig is a validated AgentConfig resource
+type agentConfig struct {
+	selector map[string]string
+	changes  []*models.CollectorChange
+}
+
+// KubeController applies AlertRule, Dashboard and AgentConfig custom
+// resources to the running server, so cluster monitoring config can live
+// in the cluster alongside the workloads
+type KubeController struct {
+	config   *utils.KubernetesConfig
+	client   *kube.Client
+	store    storage.Storage
+	alertMgr *AlertManager
+	grpc     *GRPCServer
+	logger   *zap.Logger
+
+	mu sync.RWMutex
+	// rules and dashboards map what was created from resources to the
+	// resource generation, so unchanged resources are not re-applied
+	rules        map[string]int64
+	dashboards   map[string]*kubeDashboard
+	agentConfigs []*agentConfig
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewKubeController creates a controller talking to the configured API
+// server
+func NewKubeController(config *utils.KubernetesConfig, store storage.Storage, alertMgr *AlertManager, grpc *GRPCServer, logger *zap.Logger) (*KubeController, error) {
+	client, err := kube.NewClient(kube.Config{
+		Host:      config.APIServer,
+		TokenFile: config.TokenFile,
+		CAFile:    config.CAFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &KubeController{
+		config:     config,
+		client:     client,
+		store:      store,
+		alertMgr:   alertMgr,
+		grpc:       grpc,
+		logger:     logger.With(zap.String("component", "kube-controller")),
+		rules:      make(map[string]int64),
+		dashboards: make(map[string]*kubeDashboard),
+	}, nil
+}
+
+// Start watches the custom resources until Stop is called
+func (kc *KubeController) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	kc.cancel = cancel
+
+	kc.logger.Info("Starting Kubernetes controller",
+		zap.String("namespace", kc.config.Namespace),
+		zap.Duration("resync_interval", kc.config.ResyncInterval),
+	)
+
+	watch := func(plural string, fn func([]*kube.Object)) {
+		w := kube.NewWatcher(kc.client, plural, kc.config.Namespace, kc.config.ResyncInterval, kc.logger)
+		kc.wg.Add(1)
+		go func() {
+			defer kc.wg.Done()
+			w.Run(ctx, fn)
+		}()
+	}
+	watch(kubeAlertRules, kc.syncAlertRules)
+	watch(kubeDashboards, kc.syncDashboards)
+	watch(kubeAgentConfigs, func(objects []*kube.Object) {
+		kc.syncAgentConfigs(ctx, objects)
+	})
+
+	// Agents connect at any time, so agent configs are re-applied
+	// periodically as well as on change
+	kc.wg.Add(1)
+	go func() {
+		defer kc.wg.Done()
+		ticker := time.NewTicker(kc.config.ResyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				kc.applyAgentConfigs(ctx)
+			}
+		}
+	}()
+}
+
+// Stop stops watching
+func (kc *KubeController) Stop() {
+	if kc.cancel == nil {
+		return
+	}
+	kc.cancel()
+	kc.wg.Wait()
+}
+
+// syncAlertRules replaces the resource-managed alert rules
+func (kc *KubeController) syncAlertRules(objects []*kube.Object) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	seen := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		name := obj.Key()
+		seen[name] = true
+
+		if generation, ok := kc.rules[name]; ok && generation == obj.Metadata.Generation {
+			continue
+		}
+
+		rule, err := alertRuleFromObject(obj)
+		if err != nil {
+			kc.logger.Warn("Ignoring invalid AlertRule", zap.String("name", name), zap.Error(err))
+			if _, ok := kc.rules[name]; ok {
+				kc.alertMgr.RemoveRule(name)
+				delete(kc.rules, name)
+			}
+			continue
+		}
+		if err := kc.alertMgr.AddRule(rule); err != nil {
+			kc.logger.Warn("Ignoring AlertRule", zap.String("name", name), zap.Error(err))
+			continue
+		}
+		kc.rules[name] = obj.Metadata.Generation
+	}
+
+	for name := range kc.rules {
+		if !seen[name] {
+			kc.alertMgr.RemoveRule(name)
+			delete(kc.rules, name)
+		}
+	}
+}
+
+// alertRuleFromObject validates an AlertRule resource. Rules are named
+// namespace/name so resources in different namespaces do not collide.
+func alertRuleFromObject(obj *kube.Object) (*AlertRule, error) {
+	var spec alertRuleSpec
+	if err := json.Unmarshal(obj.Spec, &spec); err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+	if spec.Metric == "" {
+		return nil, fmt.Errorf("metric is required")
+	}
+	switch spec.Operator {
+	case ">", "<", ">=", "<=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("invalid operator %q", spec.Operator)
+	}
+
+	rule := &AlertRule{
+		Name:        obj.Key(),
+		Expression:  fmt.Sprintf("%s %s %g", spec.Metric, spec.Operator, spec.Threshold),
+		Labels:      copyLabels(spec.Labels),
+		Annotations: spec.Annotations,
+		Severity:    spec.Severity,
+		Enabled:     spec.Enabled == nil || *spec.Enabled,
+		Threshold:   spec.Threshold,
+		Operator:    spec.Operator,
+		MetricName:  spec.Metric,
+	}
+	if spec.For != "" {
+		d, err := time.ParseDuration(spec.For)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid for duration %q", spec.For)
+		}
+		rule.For = d
+	}
+	if spec.Severity != "" {
+		if rule.Labels == nil {
+			rule.Labels = make(map[string]string)
+		}
+		rule.Labels["severity"] = spec.Severity
+	}
+
+	return rule, nil
+}
+
+// syncDashboards replaces the resource-managed dashboards
+func (kc *KubeController) syncDashboards(objects []*kube.Object) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	dashboards := make(map[string]*kubeDashboard, len(objects))
+	for _, obj := range objects {
+		id := kubeDashboardID(obj)
+		previous, ok := kc.dashboards[id]
+		if ok && previous.generation == obj.Metadata.Generation {
+			dashboards[id] = previous
+			continue
+		}
+
+		var spec dashboardSpec
+		if err := json.Unmarshal(obj.Spec, &spec); err != nil {
+			kc.logger.Warn("Ignoring invalid Dashboard", zap.String("name", obj.Key()), zap.Error(err))
+			continue
+		}
+
+		now := time.Now()
+		dashboard := &models.Dashboard{
+			ID:          id,
+			Name:        spec.Title,
+			Description: spec.Description,
+			Tags:        spec.Tags,
+			Panels:      spec.Panels,
+			Variables:   spec.Variables,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if dashboard.Name == "" {
+			dashboard.Name = obj.Metadata.Name
+		}
+		if ok {
+			dashboard.CreatedAt = previous.dashboard.CreatedAt
+		}
+		dashboards[id] = &kubeDashboard{dashboard: dashboard, generation: obj.Metadata.Generation}
+	}
+
+	kc.dashboards = dashboards
+}
+
+// kubeDashboardID derives a URL-safe dashboard ID from a resource
+func kubeDashboardID(obj *kube.Object) string {
+	return "k8s-" + obj.Metadata.Namespace + "-" + obj.Metadata.Name
+}
+
+// Dashboards returns the resource-managed dashboards sorted by name
+func (kc *KubeController) Dashboards() []*models.Dashboard {
+	kc.mu.RLock()
+	defer kc.mu.RUnlock()
+
+	dashboards := make([]*models.Dashboard, 0, len(kc.dashboards))
+	for _, d := range kc.dashboards {
+		dashboards = append(dashboards, d.dashboard)
+	}
+	sort.Slice(dashboards, func(i, j int) bool { return dashboards[i].Name < dashboards[j].Name })
+	return dashboards
+}
+
+// Dashboard returns a resource-managed dashboard
+func (kc *KubeController) Dashboard(id string) (*models.Dashboard, bool) {
+	kc.mu.RLock()
+	defer kc.mu.RUnlock()
+	d, ok := kc.dashboards[id]
+	if !ok {
+		return nil, false
+	}
+	return d.dashboard, true
+}
+
+// syncAgentConfigs replaces the agent configs and applies them
+func (kc *KubeController) syncAgentConfigs(ctx context.Context, objects []*kube.Object) {
+	configs := make([]*agentConfig, 0, len(objects))
+	for _, obj := range objects {
+		config, err := agentConfigFromObject(obj)
+		if err != nil {
+			kc.logger.Warn("Ignoring invalid AgentConfig", zap.String("name", obj.Key()), zap.Error(err))
+			continue
+		}
+		configs = append(configs, config)
+	}
+
+	kc.mu.Lock()
+	kc.agentConfigs = configs
+	kc.mu.Unlock()
+
+	kc.applyAgentConfigs(ctx)
+}
+
+func agentConfigFromObject(obj *kube.Object) (*agentConfig, error) {
+	var spec agentConfigSpec
+	if err := json.Unmarshal(obj.Spec, &spec); err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+	if len(spec.Collectors) == 0 {
+		return nil, fmt.Errorf("no collectors configured")
+	}
+
+	config := &agentConfig{selector: spec.NodeSelector}
+	for name, c := range spec.Collectors {
+		if c == nil || (c.Enabled == nil && c.Interval == "") {
+			return nil, fmt.Errorf("collector %s: nothing to change", name)
+		}
+		change := &models.CollectorChange{Name: name, Enabled: c.Enabled}
+		if c.Interval != "" {
+			d, err := time.ParseDuration(c.Interval)
+			if err != nil || d < time.Second {
+				return nil, fmt.Errorf("collector %s: invalid interval %q (min 1s)", name, c.Interval)
+			}
+			change.Interval = d
+		}
+		config.changes = append(config.changes, change)
+	}
+	sort.Slice(config.changes, func(i, j int) bool { return config.changes[i].Name < config.changes[j].Name })
+
+	return config, nil
+}
+
+// applyAgentConfigs pushes the collector settings of matching agent
+// configs to every connected node whose inventory differs. Configs are
+// applied in namespace/name order, so later ones win.
+func (kc *KubeController) applyAgentConfigs(ctx context.Context) {
+	kc.mu.RLock()
+	configs := kc.agentConfigs
+	kc.mu.RUnlock()
+	if len(configs) == 0 {
+		return
+	}
+
+	nodes, err := kc.store.ListNodes()
+	if err != nil {
+		kc.logger.Warn("Failed to list nodes for agent configs", zap.Error(err))
+		return
+	}
+
+	for _, node := range nodes {
+		if node.Status != models.NodeStatusHealthy && node.Status != models.NodeStatusDegraded {
+			continue
+		}
+
+		changes := pendingCollectorChanges(node, configs)
+		if len(changes) == 0 {
+			continue
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		_, err := kc.grpc.UpdateCollectors(reqCtx, node.ID, changes)
+		cancel()
+		if err != nil {
+			kc.logger.Warn("Failed to apply agent config",
+				zap.String("node_id", node.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		kc.logger.Info("Applied agent config",
+			zap.String("node_id", node.ID),
+			zap.Int("changes", len(changes)),
+		)
+	}
+}
+
+// pendingCollectorChanges merges the configs matching a node and returns
+// the changes its inventory does not reflect yet
+func pendingCollectorChanges(node *models.Node, configs []*agentConfig) []*models.CollectorChange {
+	merged := make(map[string]*models.CollectorChange)
+	for _, config := range configs {
+		if !matchesNode(config.selector, node) {
+			continue
+		}
+		for _, c := range config.changes {
+			m, ok := merged[c.Name]
+			if !ok {
+				m = &models.CollectorChange{Name: c.Name}
+				merged[c.Name] = m
+			}
+			if c.Enabled != nil {
+				m.Enabled = c.Enabled
+			}
+			if c.Interval != 0 {
+				m.Interval = c.Interval
+			}
+		}
+	}
+
+	changes := make([]*models.CollectorChange, 0, len(merged))
+	for _, c := range merged {
+		changes = append(changes, c)
+	}
+	return unappliedChanges(node, changes)
+}
+
+// matchesNode reports whether a node's labels or server labels match
+// every selector entry
+func matchesNode(selector map[string]string, node *models.Node) bool {
+	for k, v := range selector {
+		if node.ServerLabels[k] != v && node.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const webhookAttempts = 3
+
+// LifecycleHooks delivers node lifecycle events to webhooks
+type LifecycleHooks struct {
+	webhooks []utils.WebhookConfig
+	client   *http.Client
+	logger   *zap.Logger
+}
+
+// NewLifecycleHooks creates a dispatcher for the configured webhooks
+func NewLifecycleHooks(webhooks []utils.WebhookConfig, logger *zap.Logger) *LifecycleHooks {
+	return &LifecycleHooks{
+		webhooks: webhooks,
+		client:   &http.Client{},
+		logger:   logger,
+	}
+}
+
+// Emit sends an event to every subscribed webhook in the background. The
+// node is encoded before Emit returns, so callers may hold locks guarding it.
+func (h *LifecycleHooks) Emit(eventType string, node *models.Node) {
+	if h == nil || len(h.webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(&models.NodeEvent{
+		Type:   eventType,
+		NodeID: node.ID,
+		Time:   time.Now(),
+		Node:   node,
+	})
+	if err != nil {
+		h.logger.Error("Failed to encode lifecycle event", zap.Error(err))
+		return
+	}
+
+	for _, webhook := range h.webhooks {
+		if !subscribed(webhook, eventType) {
+			continue
+		}
+		go h.deliver(webhook, eventType, node.ID, body)
+	}
+}
+
+func (h *LifecycleHooks) deliver(webhook utils.WebhookConfig, eventType, nodeID string, body []byte) {
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if err = h.post(webhook, body); err == nil {
+			h.logger.Debug("Lifecycle event delivered",
+				zap.String("event", eventType),
+				zap.String("node_id", nodeID),
+				zap.String("url", webhook.URL),
+			)
+			return
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+
+	h.logger.Warn("Failed to deliver lifecycle event",
+		zap.String("event", eventType),
+		zap.String("node_id", nodeID),
+		zap.String("url", webhook.URL),
+		zap.Error(err),
+	)
+}
+
+func (h *LifecycleHooks) post(webhook utils.WebhookConfig, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range webhook.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := *h.client
+	client.Timeout = webhook.Timeout
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func subscribed(webhook utils.WebhookConfig, eventType string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,12 @@
+package server
+
+import "github.com/meettoy2004/lnmonja/internal/models"
+
+// LivePublisher pushes updates to live clients as they happen. Its methods
+// must not block and must not keep what they are given past the call,
+// since ingested metrics are reused once a batch is processed.
+type LivePublisher interface {
+	BroadcastMetrics(metrics []*models.Metric)
+	BroadcastAlert(alert *models.Alert)
+	BroadcastNodeStatus(node *models.Node)
+}
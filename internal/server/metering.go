@@ -0,0 +1,284 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// meteringDay formats the UTC day usage is recorded against
+const meteringDay = "2006-01-02"
+
+// Metering counts each tenant's ingested samples, stored bytes and queried
+// samples per UTC day, for chargeback. Counts are kept in memory and added
+// to the day's saved records every flush interval, so usage survives
+// restarts up to the last flush.
+type Metering struct {
+	config *utils.MeteringConfig
+	store  storage.Storage
+	logger *zap.Logger
+
+	// pending holds the usage counted since the last flush, by day then
+	// tenant. Stored bytes and series are the largest measured.
+	pending map[string]map[string]*models.TenantUsage
+	mu      sync.Mutex
+
+	// flushMu serializes flushes, which read and update saved records
+	flushMu sync.Mutex
+	// readOnly is set on read replicas, which only report usage
+	readOnly bool
+}
+
+// NewMetering creates a tenant usage meter
+func NewMetering(config *utils.MeteringConfig, store storage.Storage, logger *zap.Logger) *Metering {
+	return &Metering{
+		config:  config,
+		store:   store,
+		logger:  logger.Named("metering"),
+		pending: make(map[string]map[string]*models.TenantUsage),
+	}
+}
+
+// tenant returns the tenant a series with these labels belongs to
+func (m *Metering) tenant(labels map[string]string) string {
+	if tenant := labels[m.config.TenantLabel]; tenant != "" {
+		return tenant
+	}
+	return m.config.DefaultTenant
+}
+
+// usage returns the pending usage of a tenant on a day. The caller holds
+// m.mu.
+func (m *Metering) usage(day, tenant string) *models.TenantUsage {
+	byTenant, ok := m.pending[day]
+	if !ok {
+		byTenant = make(map[string]*models.TenantUsage)
+		m.pending[day] = byTenant
+	}
+	u, ok := byTenant[tenant]
+	if !ok {
+		u = &models.TenantUsage{Tenant: tenant, Day: day}
+		byTenant[tenant] = u
+	}
+	return u
+}
+
+// RecordIngest counts metrics as they are stored
+func (m *Metering) RecordIngest(metrics []*models.Metric) {
+	if len(metrics) == 0 || m.readOnly {
+		return
+	}
+	day := time.Now().UTC().Format(meteringDay)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, metric := range metrics {
+		u := m.usage(day, m.tenant(metric.Labels))
+		u.IngestedSamples++
+		u.IngestedBytes += metricWireSize(metric)
+	}
+}
+
+// RecordQuery counts the samples a query returned
+func (m *Metering) RecordQuery(series []*models.TimeSeries) {
+	if len(series) == 0 || m.readOnly {
+		return
+	}
+	day := time.Now().UTC().Format(meteringDay)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range series {
+		if len(s.Samples) > 0 {
+			m.usage(day, m.tenant(s.Labels)).QuerySamples += int64(len(s.Samples))
+		}
+	}
+}
+
+// measureStorage attributes the bytes of every stored series to its tenant
+func (m *Metering) measureStorage(now time.Time) error {
+	series, err := m.store.ListSeries(time.Unix(0, 0), now)
+	if err != nil {
+		return err
+	}
+
+	stored := make(map[string]*models.TenantUsage)
+	for _, s := range series {
+		tenant := m.tenant(s.Labels)
+		u, ok := stored[tenant]
+		if !ok {
+			u = &models.TenantUsage{}
+			stored[tenant] = u
+		}
+		u.StoredBytes += s.Bytes
+		u.StoredSeries++
+	}
+
+	day := now.UTC().Format(meteringDay)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for tenant, s := range stored {
+		u := m.usage(day, tenant)
+		u.StoredBytes = max(u.StoredBytes, s.StoredBytes)
+		u.StoredSeries = max(u.StoredSeries, s.StoredSeries)
+	}
+	return nil
+}
+
+// Flush adds the pending usage to the saved records. Usage that fails to
+// save is kept pending for the next flush.
+func (m *Metering) Flush() {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[string]map[string]*models.TenantUsage)
+	m.mu.Unlock()
+
+	for day, byTenant := range pending {
+		saved, err := m.store.ListTenantUsage(day, day)
+		if err != nil {
+			m.logger.Error("Failed to read tenant usage", zap.String("day", day), zap.Error(err))
+			m.restore(byTenant)
+			continue
+		}
+		records := make(map[string]*models.TenantUsage, len(saved))
+		for _, u := range saved {
+			records[u.Tenant] = u
+		}
+
+		now := time.Now()
+		for tenant, delta := range byTenant {
+			u, ok := records[tenant]
+			if !ok {
+				u = &models.TenantUsage{Tenant: tenant, Day: day}
+			}
+			u.IngestedSamples += delta.IngestedSamples
+			u.IngestedBytes += delta.IngestedBytes
+			u.QuerySamples += delta.QuerySamples
+			u.StoredBytes = max(u.StoredBytes, delta.StoredBytes)
+			u.StoredSeries = max(u.StoredSeries, delta.StoredSeries)
+			u.UpdatedAt = now
+			if err := m.store.SaveTenantUsage(u); err != nil {
+				m.logger.Error("Failed to save tenant usage",
+					zap.String("tenant", tenant),
+					zap.String("day", day),
+					zap.Error(err),
+				)
+				m.restore(map[string]*models.TenantUsage{tenant: delta})
+			}
+		}
+	}
+}
+
+// restore puts usage that could not be saved back in pending
+func (m *Metering) restore(byTenant map[string]*models.TenantUsage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for tenant, delta := range byTenant {
+		u := m.usage(delta.Day, tenant)
+		u.IngestedSamples += delta.IngestedSamples
+		u.IngestedBytes += delta.IngestedBytes
+		u.QuerySamples += delta.QuerySamples
+		u.StoredBytes = max(u.StoredBytes, delta.StoredBytes)
+		u.StoredSeries = max(u.StoredSeries, delta.StoredSeries)
+	}
+}
+
+// prune deletes the records of days past the retention
+func (m *Metering) prune(now time.Time) {
+	cutoff := now.Add(-m.config.Retention).UTC().Format(meteringDay)
+	deleted, err := m.store.DeleteTenantUsageBefore(cutoff)
+	if err != nil {
+		m.logger.Warn("Failed to delete old tenant usage", zap.Error(err))
+		return
+	}
+	if deleted > 0 {
+		m.logger.Info("Deleted old tenant usage", zap.Int64("records", deleted), zap.String("before", cutoff))
+	}
+}
+
+// Run flushes usage and measures storage until stop is closed. The server
+// flushes once more on shutdown.
+func (m *Metering) Run(stop <-chan struct{}) {
+	m.logger.Info("Metering tenant usage",
+		zap.String("tenant_label", m.config.TenantLabel),
+		zap.Duration("flush_interval", m.config.FlushInterval),
+	)
+
+	measure := func(now time.Time) {
+		if err := m.measureStorage(now); err != nil {
+			m.logger.Warn("Failed to measure tenant storage", zap.Error(err))
+		}
+		m.prune(now)
+	}
+	measure(time.Now())
+
+	flushTicker := time.NewTicker(m.config.FlushInterval)
+	defer flushTicker.Stop()
+	storageTicker := time.NewTicker(m.config.StorageInterval)
+	defer storageTicker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-flushTicker.C:
+			m.Flush()
+		case now := <-storageTicker.C:
+			measure(now)
+		}
+	}
+}
+
+// Report returns the usage of the days from and to, for one tenant or
+// every tenant if tenant is empty. Pending usage is flushed first.
+func (m *Metering) Report(from, to, tenant string) (*models.TenantUsageReport, error) {
+	m.Flush()
+
+	records, err := m.store.ListTenantUsage(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.TenantUsageReport{
+		From:        from,
+		To:          to,
+		TenantLabel: m.config.TenantLabel,
+		Days:        make([]*models.TenantUsage, 0, len(records)),
+		Totals:      make([]*models.TenantUsage, 0),
+	}
+	totals := make(map[string]*models.TenantUsage)
+	for _, u := range records {
+		if tenant != "" && u.Tenant != tenant {
+			continue
+		}
+		report.Days = append(report.Days, u)
+
+		total, ok := totals[u.Tenant]
+		if !ok {
+			total = &models.TenantUsage{Tenant: u.Tenant}
+			totals[u.Tenant] = total
+			report.Totals = append(report.Totals, total)
+		}
+		total.IngestedSamples += u.IngestedSamples
+		total.IngestedBytes += u.IngestedBytes
+		total.QuerySamples += u.QuerySamples
+		total.StoredBytes = max(total.StoredBytes, u.StoredBytes)
+		total.StoredSeries = max(total.StoredSeries, u.StoredSeries)
+		if u.UpdatedAt.After(total.UpdatedAt) {
+			total.UpdatedAt = u.UpdatedAt
+		}
+	}
+	sort.Slice(report.Totals, func(i, j int) bool {
+		return report.Totals[i].Tenant < report.Totals[j].Tenant
+	})
+
+	return report, nil
+}
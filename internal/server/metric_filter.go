@@ -0,0 +1,107 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// maxFilterVerdicts bounds the cached verdicts of each filter, so nodes
+// sending ever-new names cannot grow it without limit
+const maxFilterVerdicts = 10000
+
+// MetricFilters enforces the configured metric name allowlists and
+// denylists as metrics are received
+type MetricFilters struct {
+	filters []*metricFilter
+	ingest  *IngestStats
+	metrics *telemetry.Metrics
+	logger  *zap.Logger
+}
+
+type metricFilter struct {
+	*utils.MetricFilter
+	// verdicts caches the result of Check for each metric name
+	verdicts map[string]string
+	mu       sync.Mutex
+}
+
+// NewMetricFilters compiles the configured filters
+func NewMetricFilters(configs []utils.MetricFilterConfig, logger *zap.Logger) (*MetricFilters, error) {
+	mf := &MetricFilters{logger: logger}
+	for i := range configs {
+		f, err := utils.NewMetricFilter(&configs[i])
+		if err != nil {
+			return nil, err
+		}
+		mf.filters = append(mf.filters, &metricFilter{
+			MetricFilter: f,
+			verdicts:     make(map[string]string),
+		})
+	}
+	return mf, nil
+}
+
+// Apply removes the metrics a node may not send, counting them, and
+// returns the rest. labels are the node's labels.
+func (mf *MetricFilters) Apply(nodeID string, labels map[string]string, metrics []*models.Metric) []*models.Metric {
+	f := mf.filterFor(nodeID, labels)
+	if f == nil {
+		return metrics
+	}
+
+	kept := metrics[:0]
+	rejected := make(map[string]int)
+	for _, metric := range metrics {
+		if reason := f.check(metric.Name); reason != "" {
+			rejected[reason]++
+			continue
+		}
+		kept = append(kept, metric)
+	}
+
+	if len(rejected) > 0 {
+		total := 0
+		for reason, n := range rejected {
+			mf.metrics.Rejected(f.Name, reason, n)
+			total += n
+		}
+		if mf.ingest != nil {
+			mf.ingest.RecordRejected(nodeID, total)
+		}
+		mf.logger.Debug("Rejected metrics from node",
+			zap.String("node_id", nodeID),
+			zap.String("filter", f.Name),
+			zap.Int("rejected", total),
+		)
+	}
+	return kept
+}
+
+// filterFor returns the first filter selecting a node
+func (mf *MetricFilters) filterFor(nodeID string, labels map[string]string) *metricFilter {
+	for _, f := range mf.filters {
+		if f.Selects(nodeID, labels) {
+			return f
+		}
+	}
+	return nil
+}
+
+func (f *metricFilter) check(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if reason, ok := f.verdicts[name]; ok {
+		return reason
+	}
+	if len(f.verdicts) >= maxFilterVerdicts {
+		f.verdicts = make(map[string]string)
+	}
+	reason := f.Check(name)
+	f.verdicts[name] = reason
+	return reason
+}
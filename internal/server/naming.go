@@ -0,0 +1,186 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxNamingViolations bounds the violations recorded; later ones are
+	// not tracked until the server restarts
+	maxNamingViolations = 10000
+	// maxNamingCache bounds the cached results of each check
+	maxNamingCache = 10000
+	// maxViolationNodes bounds the nodes listed for a violation
+	maxViolationNodes = 20
+)
+
+// NamingChecker checks received metrics against the naming conventions and
+// records the violations for the naming report
+type NamingChecker struct {
+	linter *utils.NamingLinter
+	since  time.Time
+	logger *zap.Logger
+
+	mu sync.Mutex
+	// names and labels cache the violations of each metric name, type
+	// and unit, and of each label key
+	names      map[namingKey][]utils.NamingViolation
+	labels     map[string]*utils.NamingViolation
+	violations map[violationKey]*namingViolation
+}
+
+type namingKey struct {
+	name    string
+	unit    string
+	counter bool
+}
+
+type violationKey struct {
+	metric  string
+	message string
+}
+
+type namingViolation struct {
+	models.NamingViolation
+	nodes map[string]bool
+}
+
+// NewNamingChecker compiles the naming conventions
+func NewNamingChecker(config *utils.NamingConfig, logger *zap.Logger) (*NamingChecker, error) {
+	linter, err := utils.NewNamingLinter(config)
+	if err != nil {
+		return nil, err
+	}
+	return &NamingChecker{
+		linter:     linter,
+		since:      time.Now(),
+		logger:     logger.Named("naming"),
+		names:      make(map[namingKey][]utils.NamingViolation),
+		labels:     make(map[string]*utils.NamingViolation),
+		violations: make(map[violationKey]*namingViolation),
+	}, nil
+}
+
+// Check records the conventions broken by metrics a node sent
+func (n *NamingChecker) Check(nodeID string, metrics []*models.Metric) {
+	now := time.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, metric := range metrics {
+		for _, v := range n.checkName(metric) {
+			n.record(nodeID, metric.Name, v, now)
+		}
+		for key := range metric.Labels {
+			if v := n.checkLabel(key); v != nil {
+				n.record(nodeID, metric.Name, *v, now)
+			}
+		}
+	}
+}
+
+func (n *NamingChecker) checkName(metric *models.Metric) []utils.NamingViolation {
+	key := namingKey{name: metric.Name, unit: metric.Unit, counter: metric.Type == models.MetricTypeCounter}
+	if violations, ok := n.names[key]; ok {
+		return violations
+	}
+	if len(n.names) >= maxNamingCache {
+		n.names = make(map[namingKey][]utils.NamingViolation)
+	}
+	violations := n.linter.CheckName(key.name, key.unit, key.counter)
+	n.names[key] = violations
+	return violations
+}
+
+func (n *NamingChecker) checkLabel(key string) *utils.NamingViolation {
+	if v, ok := n.labels[key]; ok {
+		return v
+	}
+	if len(n.labels) >= maxNamingCache {
+		n.labels = make(map[string]*utils.NamingViolation)
+	}
+	v := n.linter.CheckLabel(key)
+	n.labels[key] = v
+	return v
+}
+
+func (n *NamingChecker) record(nodeID, metric string, v utils.NamingViolation, now time.Time) {
+	key := violationKey{metric: metric, message: v.Message}
+	recorded := n.violations[key]
+	if recorded == nil {
+		if len(n.violations) >= maxNamingViolations {
+			return
+		}
+		recorded = &namingViolation{
+			NamingViolation: models.NamingViolation{
+				Metric:    metric,
+				Rule:      v.Rule,
+				Message:   v.Message,
+				FirstSeen: now,
+			},
+			nodes: make(map[string]bool),
+		}
+		n.violations[key] = recorded
+		n.logger.Debug("Metric breaks naming convention",
+			zap.String("node_id", nodeID),
+			zap.String("metric", metric),
+			zap.String("rule", v.Rule),
+			zap.String("message", v.Message),
+		)
+	}
+
+	recorded.Samples++
+	recorded.LastSeen = now
+	if len(recorded.nodes) < maxViolationNodes {
+		recorded.nodes[nodeID] = true
+	}
+}
+
+// Report returns the recorded violations, those with the most samples
+// first, and the number of violations of each rule. A limit of 0 returns
+// every violation.
+func (n *NamingChecker) Report(rule string, limit int) *models.NamingReport {
+	n.mu.Lock()
+	report := &models.NamingReport{
+		TrackingSince: n.since,
+		Rules:         make(map[string]int),
+		Violations:    make([]*models.NamingViolation, 0, len(n.violations)),
+	}
+	for _, recorded := range n.violations {
+		report.Rules[recorded.Rule]++
+		if rule != "" && recorded.Rule != rule {
+			continue
+		}
+
+		v := recorded.NamingViolation
+		v.Nodes = make([]string, 0, len(recorded.nodes))
+		for nodeID := range recorded.nodes {
+			v.Nodes = append(v.Nodes, nodeID)
+		}
+		sort.Strings(v.Nodes)
+		report.Violations = append(report.Violations, &v)
+	}
+	n.mu.Unlock()
+
+	sort.Slice(report.Violations, func(i, j int) bool {
+		a, b := report.Violations[i], report.Violations[j]
+		if a.Samples != b.Samples {
+			return a.Samples > b.Samples
+		}
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		return a.Message < b.Message
+	})
+	if limit > 0 && len(report.Violations) > limit {
+		report.Violations = report.Violations[:limit]
+	}
+	return report
+}
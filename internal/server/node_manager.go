@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"regexp"
 	"sync"
 	"time"
 
@@ -10,12 +11,24 @@ import (
 	"go.uber.org/zap"
 )
 
+var nodeLabelPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedNodeLabels are set by agents on every metric and cannot be
+// overridden with server-side labels
+var reservedNodeLabels = map[string]bool{
+	"node":      true,
+	"collector": true,
+}
+
 // NodeManager manages node lifecycle and health
 type NodeManager struct {
 	store   storage.Storage
 	logger  *zap.Logger
 	nodes   map[string]*NodeInfo
 	nodesMu sync.RWMutex
+	hooks   *LifecycleHooks
+	// live receives status changes for live clients, when set
+	live LivePublisher
 }
 
 // NodeInfo contains runtime information about a node
@@ -46,6 +59,24 @@ func (nm *NodeManager) RegisterNode(node *models.Node) error {
 	nm.nodesMu.Lock()
 	defer nm.nodesMu.Unlock()
 
+	var previous *models.Node
+	if existing, exists := nm.nodes[node.ID]; exists {
+		previous = existing.Node
+	} else if stored, err := nm.store.GetNode(node.ID); err == nil {
+		previous = stored
+	}
+
+	if previous != nil {
+		// Server-side labels survive re-registration
+		node.ServerLabels = previous.ServerLabels
+
+		// A retiring node stays retiring when its agent reconnects
+		if previous.Status == models.NodeStatusRetiring {
+			node.Status = previous.Status
+			node.Decommission = previous.Decommission
+		}
+	}
+
 	// Check if node already exists
 	if existing, exists := nm.nodes[node.ID]; exists {
 		nm.logger.Info("Node re-registering",
@@ -70,6 +101,13 @@ func (nm *NodeManager) RegisterNode(node *models.Node) error {
 		}
 	}
 
+	if previous == nil || previous.Status == models.NodeStatusDecommissioned || previous.Status == models.NodeStatusDeparted {
+		nm.hooks.Emit(models.NodeEventRegistered, node)
+	}
+	if previous == nil || previous.Status != node.Status {
+		nm.statusChanged(node)
+	}
+
 	// Save to storage
 	return nm.store.SaveNode(node)
 }
@@ -85,24 +123,42 @@ func (nm *NodeManager) UpdateNodeStatus(nodeID string, status models.NodeStatus)
 	}
 
 	oldStatus := nodeInfo.Node.Status
-	nodeInfo.Node.Status = status
 	nodeInfo.Node.LastSeen = time.Now()
 
+	// Decommissioning owns the status of retiring nodes
+	if isRetired(oldStatus) {
+		return nm.store.SaveNode(nodeInfo.Node)
+	}
+	nodeInfo.Node.Status = status
+
 	// Update health flag
 	nodeInfo.IsHealthy = (status == models.NodeStatusHealthy)
 
 	if oldStatus != status {
+		nm.statusChanged(nodeInfo.Node)
 		nm.logger.Info("Node status changed",
 			zap.String("node_id", nodeID),
 			zap.String("old_status", oldStatus.String()),
 			zap.String("new_status", status.String()),
 		)
+		if status == models.NodeStatusHealthy &&
+			(oldStatus == models.NodeStatusUnhealthy || oldStatus == models.NodeStatusOffline) {
+			nm.hooks.Emit(models.NodeEventRecovered, nodeInfo.Node)
+		}
 	}
 
 	// Persist to storage
 	return nm.store.SaveNode(nodeInfo.Node)
 }
 
+// statusChanged publishes a node's new status to live clients. Callers
+// must hold nodesMu.
+func (nm *NodeManager) statusChanged(node *models.Node) {
+	if nm.live != nil {
+		nm.live.BroadcastNodeStatus(node)
+	}
+}
+
 // UpdateHeartbeat updates the last heartbeat time for a node
 func (nm *NodeManager) UpdateHeartbeat(nodeID string) error {
 	nm.nodesMu.Lock()
@@ -119,41 +175,345 @@ func (nm *NodeManager) UpdateHeartbeat(nodeID string) error {
 	// Mark as healthy if it was down
 	if !nodeInfo.IsHealthy {
 		nodeInfo.IsHealthy = true
-		nodeInfo.Node.Status = models.NodeStatusHealthy
-		nm.logger.Info("Node recovered",
-			zap.String("node_id", nodeID),
-		)
+		if !isRetired(nodeInfo.Node.Status) {
+			nodeInfo.Node.Status = models.NodeStatusHealthy
+			nm.logger.Info("Node recovered",
+				zap.String("node_id", nodeID),
+			)
+			nm.hooks.Emit(models.NodeEventRecovered, nodeInfo.Node)
+			nm.statusChanged(nodeInfo.Node)
+		}
 	}
 
 	return nm.store.SaveNode(nodeInfo.Node)
 }
 
-// GetNode returns information about a node
-func (nm *NodeManager) GetNode(nodeID string) (*NodeInfo, error) {
+// UpdateInventory merges inventory details reported by a node's agent
+func (nm *NodeManager) UpdateInventory(nodeID string, inventory map[string]string) error {
+	nodeInfo, err := nm.lockNode(nodeID)
+	if err != nil {
+		return err
+	}
+	defer nm.nodesMu.Unlock()
+
+	if nodeInfo.Node.Inventory == nil {
+		nodeInfo.Node.Inventory = make(map[string]string)
+	}
+	for k, v := range inventory {
+		nodeInfo.Node.Inventory[k] = v
+	}
+
+	return nm.store.SaveNode(nodeInfo.Node)
+}
+
+// UpdateVitals records the health summary sent with a node's heartbeat
+func (nm *NodeManager) UpdateVitals(nodeID string, vitals *models.NodeVitals) error {
+	nodeInfo, err := nm.lockNode(nodeID)
+	if err != nil {
+		return err
+	}
+	defer nm.nodesMu.Unlock()
+
+	nodeInfo.Node.Vitals = vitals
+
+	return nm.store.SaveNode(nodeInfo.Node)
+}
+
+// UpdateLabels sets and removes server-side labels on a node and returns
+// the updated node
+func (nm *NodeManager) UpdateLabels(nodeID string, set map[string]string, remove []string) (*models.Node, error) {
+	for name, value := range set {
+		if !nodeLabelPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid label name: %q", name)
+		}
+		if reservedNodeLabels[name] {
+			return nil, fmt.Errorf("label %s is reserved", name)
+		}
+		if value == "" {
+			return nil, fmt.Errorf("label %s has an empty value", name)
+		}
+	}
+
+	nodeInfo, err := nm.lockNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer nm.nodesMu.Unlock()
+
+
+	// Copy so ingest never reads a map being modified
+	node := nodeInfo.Node
+	labels := make(map[string]string, len(node.ServerLabels)+len(set))
+	for k, v := range node.ServerLabels {
+		labels[k] = v
+	}
+	for _, name := range remove {
+		delete(labels, name)
+	}
+	for k, v := range set {
+		labels[k] = v
+	}
+	node.ServerLabels = labels
+
+	nm.logger.Info("Node labels updated",
+		zap.String("node_id", nodeID),
+		zap.Any("labels", labels),
+	)
+
+	if err := nm.store.SaveNode(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// MarkRetiring starts decommissioning a node
+func (nm *NodeManager) MarkRetiring(nodeID string, decommission *models.Decommission) (*models.Node, error) {
+	nodeInfo, err := nm.lockNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer nm.nodesMu.Unlock()
+
+	node := nodeInfo.Node
+	if node.Status == models.NodeStatusDecommissioned {
+		return nil, fmt.Errorf("node %s is already decommissioned", nodeID)
+	}
+
+	node.Status = models.NodeStatusRetiring
+	node.Decommission = decommission
+
+	nm.logger.Info("Node retiring",
+		zap.String("node_id", nodeID),
+		zap.String("action", string(decommission.Action)),
+		zap.Time("complete_at", decommission.CompleteAt),
+	)
+
+	if err := nm.store.SaveNode(node); err != nil {
+		return nil, err
+	}
+	nm.hooks.Emit(models.NodeEventRetiring, node)
+	nm.statusChanged(node)
+	return node, nil
+}
+
+// Reactivate cancels decommissioning of a retiring node
+func (nm *NodeManager) Reactivate(nodeID string) (*models.Node, error) {
+	nodeInfo, err := nm.lockNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer nm.nodesMu.Unlock()
+
+	node := nodeInfo.Node
+	if node.Status != models.NodeStatusRetiring {
+		return nil, fmt.Errorf("node %s is not retiring", nodeID)
+	}
+
+	// Health is re-established by the next heartbeat or metric batch
+	node.Status = models.NodeStatusUnknown
+	node.Decommission = nil
+	nodeInfo.IsHealthy = true
+	nodeInfo.LastHeartbeat = time.Now()
+
+	nm.logger.Info("Node reactivated", zap.String("node_id", nodeID))
+
+	if err := nm.store.SaveNode(node); err != nil {
+		return nil, err
+	}
+	nm.hooks.Emit(models.NodeEventReactivated, node)
+	nm.statusChanged(node)
+	return node, nil
+}
+
+// MarkDecommissioned completes decommissioning of a retiring node
+func (nm *NodeManager) MarkDecommissioned(nodeID string, deletedSamples int64, archivePath string) (*models.Node, error) {
+	nodeInfo, err := nm.lockNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer nm.nodesMu.Unlock()
+
+	node := nodeInfo.Node
+	if node.Status != models.NodeStatusRetiring || node.Decommission == nil {
+		return nil, fmt.Errorf("node %s is not retiring", nodeID)
+	}
+
+	now := time.Now()
+	node.Status = models.NodeStatusDecommissioned
+	node.Decommission.CompletedAt = &now
+	node.Decommission.DeletedSamples = deletedSamples
+	node.Decommission.ArchivePath = archivePath
+
+	nm.logger.Info("Node decommissioned",
+		zap.String("node_id", nodeID),
+		zap.Int64("deleted_samples", deletedSamples),
+	)
+
+	if err := nm.store.SaveNode(node); err != nil {
+		return nil, err
+	}
+	nm.hooks.Emit(models.NodeEventDecommissioned, node)
+	nm.statusChanged(node)
+	return node, nil
+}
+
+// Depart records a clean scale-in of an ephemeral node. It is no longer
+// expected to report and is removed once the ephemeral TTL passes.
+func (nm *NodeManager) Depart(nodeID string) (*models.Node, error) {
+	nodeInfo, err := nm.lockNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer nm.nodesMu.Unlock()
+
+	node := nodeInfo.Node
+	if !node.Ephemeral {
+		return nil, fmt.Errorf("node %s is not ephemeral, decommission it instead", nodeID)
+	}
+	if node.Status == models.NodeStatusDeparted {
+		return node, nil
+	}
+
+	now := time.Now()
+	node.Status = models.NodeStatusDeparted
+	node.DepartedAt = &now
+
+	nm.logger.Info("Ephemeral node departed",
+		zap.String("node_id", nodeID),
+		zap.String("instance_group", node.InstanceGroup),
+	)
+
+	if err := nm.store.SaveNode(node); err != nil {
+		return nil, err
+	}
+	nm.hooks.Emit(models.NodeEventDeparted, node)
+	nm.statusChanged(node)
+	return node, nil
+}
+
+// MarkStopped records that a node's agent shut down cleanly. Health checks
+// skip the node until it reports again.
+func (nm *NodeManager) MarkStopped(nodeID, reason string) (*models.Node, error) {
+	nodeInfo, err := nm.lockNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer nm.nodesMu.Unlock()
+
+	node := nodeInfo.Node
+	if isRetired(node.Status) {
+		return node, nil
+	}
+
+	node.Status = models.NodeStatusStopped
+	node.LastSeen = time.Now()
+
+	nm.logger.Info("Node stopped",
+		zap.String("node_id", nodeID),
+		zap.String("reason", reason),
+	)
+
+	if err := nm.store.SaveNode(node); err != nil {
+		return nil, err
+	}
+	nm.hooks.Emit(models.NodeEventStopped, node)
+	nm.statusChanged(node)
+	return node, nil
+}
+
+// Remove deletes a node record. Its series are kept.
+func (nm *NodeManager) Remove(nodeID string) error {
+	// Concurrent removes find the node gone once the first one completes
+	info, err := nm.lockNode(nodeID)
+	if err != nil {
+		return err
+	}
+	defer nm.nodesMu.Unlock()
+
+	if err := nm.store.DeleteNode(nodeID); err != nil {
+		return err
+	}
+	delete(nm.nodes, nodeID)
+
+	nm.logger.Info("Node removed", zap.String("node_id", nodeID))
+	nm.hooks.Emit(models.NodeEventRemoved, info.Node)
+	return nil
+}
+
+// IsRetiring reports whether a node is being or has been decommissioned,
+// or has departed
+func (nm *NodeManager) IsRetiring(nodeID string) bool {
 	nm.nodesMu.RLock()
 	defer nm.nodesMu.RUnlock()
 
 	nodeInfo, exists := nm.nodes[nodeID]
-	if !exists {
-		// Try to load from storage
-		node, err := nm.store.GetNode(nodeID)
-		if err != nil {
-			return nil, fmt.Errorf("node %s not found", nodeID)
-		}
+	return exists && isRetired(nodeInfo.Node.Status)
+}
 
-		// Add to cache
-		nm.nodesMu.RUnlock()
-		nm.nodesMu.Lock()
-		nodeInfo = &NodeInfo{
-			Node:          node,
-			LastHeartbeat: node.LastSeen,
-			IsHealthy:     node.Status == models.NodeStatusHealthy,
-		}
-		nm.nodes[nodeID] = nodeInfo
+func isRetired(status models.NodeStatus) bool {
+	switch status {
+	case models.NodeStatusRetiring, models.NodeStatusDecommissioned, models.NodeStatusDeparted:
+		return true
+	default:
+		return false
+	}
+}
+
+// ServerLabels returns the server-side labels of a node. The returned map
+// must not be modified.
+func (nm *NodeManager) ServerLabels(nodeID string) map[string]string {
+	nm.nodesMu.RLock()
+	defer nm.nodesMu.RUnlock()
+
+	if nodeInfo, exists := nm.nodes[nodeID]; exists {
+		return nodeInfo.Node.ServerLabels
+	}
+	return nil
+}
+
+// GetNode returns information about a node
+func (nm *NodeManager) GetNode(nodeID string) (*NodeInfo, error) {
+	nm.nodesMu.RLock()
+	nodeInfo, exists := nm.nodes[nodeID]
+	nm.nodesMu.RUnlock()
+	if exists {
+		return nodeInfo, nil
+	}
+
+	nm.nodesMu.Lock()
+	defer nm.nodesMu.Unlock()
+	return nm.loadNode(nodeID)
+}
+
+// lockNode takes the write lock and returns a node, loading it from storage
+// when it is not cached. On success the caller must unlock nodesMu.
+func (nm *NodeManager) lockNode(nodeID string) (*NodeInfo, error) {
+	nm.nodesMu.Lock()
+	nodeInfo, err := nm.loadNode(nodeID)
+	if err != nil {
 		nm.nodesMu.Unlock()
-		nm.nodesMu.RLock()
+		return nil, err
+	}
+	return nodeInfo, nil
+}
+
+// loadNode returns a cached node, adding it to the cache from storage when
+// missing. The caller must hold the write lock.
+func (nm *NodeManager) loadNode(nodeID string) (*NodeInfo, error) {
+	if nodeInfo, exists := nm.nodes[nodeID]; exists {
+		return nodeInfo, nil
 	}
 
+	node, err := nm.store.GetNode(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("node %s not found", nodeID)
+	}
+	nodeInfo := &NodeInfo{
+		Node:          node,
+		LastHeartbeat: node.LastSeen,
+		IsHealthy:     node.Status == models.NodeStatusHealthy,
+	}
+	nm.nodes[nodeID] = nodeInfo
 	return nodeInfo, nil
 }
 
@@ -178,6 +538,11 @@ func (nm *NodeManager) CheckHealth(timeout time.Duration) {
 	now := time.Now()
 
 	for nodeID, nodeInfo := range nm.nodes {
+		// Retiring and stopped nodes are expected to stop sending heartbeats
+		if isRetired(nodeInfo.Node.Status) || nodeInfo.Node.Status == models.NodeStatusStopped {
+			continue
+		}
+
 		timeSinceHeartbeat := now.Sub(nodeInfo.LastHeartbeat)
 
 		if timeSinceHeartbeat > timeout {
@@ -188,6 +553,8 @@ func (nm *NodeManager) CheckHealth(timeout time.Duration) {
 				)
 				nodeInfo.IsHealthy = false
 				nodeInfo.Node.Status = models.NodeStatusUnhealthy
+				nm.hooks.Emit(models.NodeEventUnhealthy, nodeInfo.Node)
+				nm.statusChanged(nodeInfo.Node)
 
 				// Persist status change
 				if err := nm.store.SaveNode(nodeInfo.Node); err != nil {
@@ -206,6 +573,8 @@ func (nm *NodeManager) CheckHealth(timeout time.Duration) {
 						zap.Duration("time_since_heartbeat", timeSinceHeartbeat),
 					)
 					nodeInfo.Node.Status = models.NodeStatusOffline
+					nm.hooks.Emit(models.NodeEventOffline, nodeInfo.Node)
+					nm.statusChanged(nodeInfo.Node)
 
 					if err := nm.store.SaveNode(nodeInfo.Node); err != nil {
 						nm.logger.Error("Failed to save node status",
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/i18n"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// newReceiver creates the integrations of a receiver, localizing their
+// messages with catalog
+func newReceiver(config utils.ReceiverConfig, catalog *i18n.Catalog) (*Receiver, error) {
+	r := &Receiver{name: config.Name}
+	for _, w := range config.Webhooks {
+		r.integrations = append(r.integrations, newWebhookNotifier(w))
+	}
+	for _, s := range config.Slack {
+		slack, err := newSlackNotifier(s, catalog)
+		if err != nil {
+			return nil, fmt.Errorf("slack: %w", err)
+		}
+		r.integrations = append(r.integrations, slack)
+	}
+	for _, e := range config.Email {
+		email, err := newEmailNotifier(e, catalog)
+		if err != nil {
+			return nil, fmt.Errorf("email: %w", err)
+		}
+		r.integrations = append(r.integrations, email)
+	}
+	return r, nil
+}
+
+// permanentError is a delivery failure that retrying cannot fix, such as
+// a rejected request
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// retry calls send up to notifyAttempts times, backing off exponentially
+// from one second, until it succeeds or fails permanently
+func retry(ctx context.Context, send func() error) error {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := send()
+		var permanent *permanentError
+		if err == nil || attempt == notifyAttempts || errors.As(err, &permanent) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// webhookMessage is the Alertmanager webhook payload, so receivers written
+// for Alertmanager work unchanged
+type webhookMessage struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []webhookAlert    `json:"alerts"`
+}
+
+type webhookAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// webhookNotifier posts notifications as JSON
+type webhookNotifier struct {
+	config utils.WebhookConfig
+	client *http.Client
+}
+
+func newWebhookNotifier(config utils.WebhookConfig) *webhookNotifier {
+	return &webhookNotifier{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+func (w *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	msg := webhookMessage{
+		Version:           "4",
+		GroupKey:          n.GroupKey,
+		Status:            n.Status,
+		Receiver:          n.Receiver,
+		GroupLabels:       n.GroupLabels,
+		CommonLabels:      n.CommonLabels,
+		CommonAnnotations: n.CommonAnnotations,
+		Alerts:            make([]webhookAlert, 0, len(n.Alerts)),
+	}
+	for _, alert := range n.Alerts {
+		labels := routingLabels(alert)
+		wa := webhookAlert{
+			Status:      alertStatus(alert),
+			Labels:      labels,
+			Annotations: alert.Annotations,
+			StartsAt:    alert.ActiveAt,
+			Fingerprint: utils.HashLabels(labels),
+		}
+		if alert.ResolvedAt != nil {
+			wa.EndsAt = *alert.ResolvedAt
+		}
+		msg.Alerts = append(msg.Alerts, wa)
+	}
+
+	body, err := json.Marshal(&msg)
+	if err != nil {
+		return err
+	}
+
+	return retry(ctx, func() error { return w.post(ctx, body) })
+}
+
+func (w *webhookNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return statusError("webhook", resp)
+}
+
+// statusError returns the error for an unsuccessful HTTP response, which
+// is permanent for client errors other than timeouts and rate limits
+func statusError(name string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err := fmt.Errorf("%s returned %s: %s", name, resp.Status, strings.TrimSpace(string(body)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err: err}
+	}
+	return err
+}
+
+// Severities in increasing order of urgency; alerts without a known
+// severity rank lowest
+var severityRank = map[string]int{
+	"info":     1,
+	"warning":  2,
+	"error":    3,
+	"critical": 4,
+}
+
+// severityColors are the colors notifications use by severity
+var severityColors = map[string]string{
+	"critical":           "#E01E5A",
+	"error":              "#E01E5A",
+	"warning":            "#ECB22E",
+	"info":               "#36C5F0",
+	notificationResolved: "#2EB67D",
+}
+
+// severityColor returns the color of a severity, or gray for severities
+// without one
+func severityColor(severity string) string {
+	if c, ok := severityColors[severity]; ok {
+		return c
+	}
+	return "#616061"
+}
+
+// alertStatus returns firing or resolved for an alert
+func alertStatus(alert *models.Alert) string {
+	if alert.State == models.AlertStateResolved {
+		return notificationResolved
+	}
+	return notificationFiring
+}
+
+// alertColor returns the color of an alert's severity, or of resolution
+func alertColor(alert *models.Alert) string {
+	if alert.State == models.AlertStateResolved {
+		return severityColor(notificationResolved)
+	}
+	return severityColor(alert.Labels["severity"])
+}
+
+// notificationSeverity returns the most urgent severity of the firing
+// alerts of a notification
+func notificationSeverity(n *Notification) string {
+	severity := ""
+	for _, alert := range n.Firing() {
+		s := alert.Labels["severity"]
+		if severity == "" || severityRank[s] > severityRank[severity] {
+			severity = s
+		}
+	}
+	return severity
+}
+
+// alertSummary returns an alert's summary annotation, falling back to its
+// description
+func alertSummary(alert *models.Alert) string {
+	if s := alert.Annotations["summary"]; s != "" {
+		return s
+	}
+	return alert.Annotations["description"]
+}
+
+// templateFuncs are available to notification templates
+var templateFuncs = map[string]interface{}{
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"join":     strings.Join,
+	"summary":  alertSummary,
+	"severity": notificationSeverity,
+	"since": func(t time.Time) string {
+		return time.Since(t).Round(time.Second).String()
+	},
+}
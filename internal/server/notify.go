@@ -0,0 +1,497 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/i18n"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// defaultReceiver is the receiver built from the notification settings
+// when no receivers are configured
+const defaultReceiver = "default"
+
+// groupByAll in group_by groups alerts by all of their labels
+const groupByAll = "..."
+
+// notifyTimeout bounds one delivery of a notification to a receiver,
+// including retries
+const notifyTimeout = time.Minute
+
+// notifyAttempts is how often an integration tries to deliver a
+// notification before giving up
+const notifyAttempts = 3
+
+// Notification statuses
+const (
+	notificationFiring   = "firing"
+	notificationResolved = "resolved"
+)
+
+// Route is a node of the notification routing tree with its settings
+// inherited from the parent
+type Route struct {
+	receiver       string
+	groupBy        []string
+	groupByAll     bool
+	matchers       []*utils.Matcher
+	continueMatch  bool
+	groupWait      time.Duration
+	groupInterval  time.Duration
+	repeatInterval time.Duration
+	routes         []*Route
+	// id identifies the route in group keys
+	id string
+}
+
+// NewRoute builds the routing tree from its configuration. parent is nil
+// for the root route.
+func NewRoute(config *utils.RouteConfig, parent *Route, id string) (*Route, error) {
+	matchers, err := utils.ParseMatchers(config.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Route{
+		receiver:       config.Receiver,
+		groupBy:        config.GroupBy,
+		matchers:       matchers,
+		continueMatch:  config.Continue,
+		groupWait:      config.GroupWait,
+		groupInterval:  config.GroupInterval,
+		repeatInterval: config.RepeatInterval,
+		id:             id,
+	}
+	if parent != nil {
+		if r.receiver == "" {
+			r.receiver = parent.receiver
+		}
+		if r.groupBy == nil {
+			r.groupBy = parent.groupBy
+		}
+		if r.groupWait == 0 {
+			r.groupWait = parent.groupWait
+		}
+		if r.groupInterval == 0 {
+			r.groupInterval = parent.groupInterval
+		}
+		if r.repeatInterval == 0 {
+			r.repeatInterval = parent.repeatInterval
+		}
+	}
+	for _, label := range r.groupBy {
+		if label == groupByAll {
+			r.groupByAll = true
+		}
+	}
+
+	for i := range config.Routes {
+		child, err := NewRoute(&config.Routes[i], r, fmt.Sprintf("%s/%d", id, i))
+		if err != nil {
+			return nil, err
+		}
+		r.routes = append(r.routes, child)
+	}
+	return r, nil
+}
+
+// Match returns the routes that handle an alert with the given labels: the
+// deepest matching routes, or this route when no child matches
+func (r *Route) Match(labels map[string]string) []*Route {
+	for _, m := range r.matchers {
+		if !m.Matches(labels) {
+			return nil
+		}
+	}
+
+	var matches []*Route
+	for _, child := range r.routes {
+		found := child.Match(labels)
+		if len(found) == 0 {
+			continue
+		}
+		matches = append(matches, found...)
+		if !child.continueMatch {
+			break
+		}
+	}
+	if len(matches) == 0 {
+		return []*Route{r}
+	}
+	return matches
+}
+
+// groupLabels returns the labels an alert is grouped by on this route
+func (r *Route) groupLabels(labels map[string]string) map[string]string {
+	if r.groupByAll {
+		return copyLabels(labels)
+	}
+	group := make(map[string]string, len(r.groupBy))
+	for _, name := range r.groupBy {
+		if value, ok := labels[name]; ok {
+			group[name] = value
+		}
+	}
+	return group
+}
+
+// Notification is a message about a group of alerts sent to a receiver
+type Notification struct {
+	Receiver    string
+	GroupKey    string
+	Status      string
+	GroupLabels map[string]string
+	// CommonLabels and CommonAnnotations are shared by all alerts
+	CommonLabels      map[string]string
+	CommonAnnotations map[string]string
+	Alerts            []*models.Alert
+}
+
+// Firing returns the alerts of the notification that are still firing
+func (n *Notification) Firing() []*models.Alert {
+	var firing []*models.Alert
+	for _, alert := range n.Alerts {
+		if alert.State != models.AlertStateResolved {
+			firing = append(firing, alert)
+		}
+	}
+	return firing
+}
+
+// Resolved returns the alerts of the notification that have resolved
+func (n *Notification) Resolved() []*models.Alert {
+	var resolved []*models.Alert
+	for _, alert := range n.Alerts {
+		if alert.State == models.AlertStateResolved {
+			resolved = append(resolved, alert)
+		}
+	}
+	return resolved
+}
+
+// Integration delivers notifications to one destination
+type Integration interface {
+	Name() string
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Receiver is a named set of integrations
+type Receiver struct {
+	name         string
+	integrations []Integration
+}
+
+// alertGroup is the set of alerts a route notifies about together
+type alertGroup struct {
+	key    string
+	route  *Route
+	labels map[string]string
+	// alerts are keyed by fingerprint
+	alerts map[string]*models.Alert
+	// changed is set when an alert was added or resolved since the last
+	// notification
+	changed  bool
+	notified bool
+	lastSent time.Time
+	timer    *time.Timer
+}
+
+// Dispatcher routes alerts through the routing tree into groups and sends
+// each group to its receiver after group_wait, on changes every
+// group_interval, and unchanged every repeat_interval
+type Dispatcher struct {
+	route     *Route
+	receivers map[string]*Receiver
+	// catalog localizes the receivers' messages
+	catalog *i18n.Catalog
+	logger  *zap.Logger
+
+	mu      sync.Mutex
+	groups  map[string]*alertGroup
+	stopped bool
+}
+
+// NewDispatcher creates a dispatcher for the routing configuration
+func NewDispatcher(config *utils.Config, catalog *i18n.Catalog, logger *zap.Logger) (*Dispatcher, error) {
+	route, receivers, err := newRouting(config, catalog)
+	if err != nil {
+		return nil, err
+	}
+	return &Dispatcher{
+		route:     route,
+		receivers: receivers,
+		catalog:   catalog,
+		logger:    logger,
+		groups:    make(map[string]*alertGroup),
+	}, nil
+}
+
+// Reconfigure replaces the routing tree and receivers. Groups already
+// pending keep their route until they are empty.
+func (d *Dispatcher) Reconfigure(config *utils.Config) error {
+	route, receivers, err := newRouting(config, d.catalog)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.route = route
+	d.receivers = receivers
+	return nil
+}
+
+// newRouting builds the routing tree and receivers of a configuration
+func newRouting(config *utils.Config, catalog *i18n.Catalog) (*Route, map[string]*Receiver, error) {
+	routeConfig := config.Alerting.Route
+	receivers := config.Alerting.Receivers
+
+	// Without receivers, notify the configured Slack and email settings
+	if len(receivers) == 0 {
+		legacy := utils.ReceiverConfig{Name: defaultReceiver}
+		if n := config.Alerting.Notification; n.Slack.Enabled {
+			legacy.Slack = append(legacy.Slack, n.Slack.SlackConfig)
+		}
+		if n := config.Alerting.Notification; n.Email.Enabled {
+			legacy.Email = append(legacy.Email, n.Email.EmailConfig)
+		}
+		receivers = []utils.ReceiverConfig{legacy}
+		routeConfig.Receiver = defaultReceiver
+	}
+
+	route, err := NewRoute(&routeConfig, nil, "root")
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid alert route: %w", err)
+	}
+
+	byName := make(map[string]*Receiver, len(receivers))
+	for _, rc := range receivers {
+		receiver, err := newReceiver(rc, catalog)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid receiver %s: %w", rc.Name, err)
+		}
+		byName[rc.Name] = receiver
+	}
+	return route, byName, nil
+}
+
+// routingLabels are the labels routes and groups see: the alert's labels
+// plus alertname
+func routingLabels(alert *models.Alert) map[string]string {
+	labels := copyLabels(alert.Labels)
+	labels["alertname"] = alert.Name
+	return labels
+}
+
+// Dispatch adds an alert state change to the groups of its routes. The
+// alert is copied, so callers may keep changing it. It does not block.
+func (d *Dispatcher) Dispatch(alert *models.Alert) {
+	snapshot := *alert
+	snapshot.Labels = copyLabels(alert.Labels)
+	labels := routingLabels(&snapshot)
+	fingerprint := utils.HashLabels(labels)
+	resolved := snapshot.State == models.AlertStateResolved
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+
+	for _, route := range d.route.Match(labels) {
+		groupLabels := route.groupLabels(labels)
+		key := route.id + ":" + route.receiver + ":" + utils.HashLabels(groupLabels)
+
+		group, exists := d.groups[key]
+		if !exists {
+			// A resolution for an alert nobody was told about is dropped
+			if resolved {
+				continue
+			}
+			group = &alertGroup{
+				key:    key,
+				route:  route,
+				labels: groupLabels,
+				alerts: make(map[string]*models.Alert),
+			}
+			d.groups[key] = group
+			group.timer = time.AfterFunc(route.groupWait, func() { d.flush(group) })
+		}
+
+		if _, known := group.alerts[fingerprint]; !known && resolved {
+			continue
+		}
+		group.alerts[fingerprint] = &snapshot
+		group.changed = true
+	}
+}
+
+// Drop removes an alert from its groups without notifying, for alerts
+// closed silently
+func (d *Dispatcher) Drop(alert *models.Alert) {
+	fingerprint := utils.HashLabels(routingLabels(alert))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, group := range d.groups {
+		delete(group.alerts, fingerprint)
+		if len(group.alerts) == 0 {
+			group.timer.Stop()
+			delete(d.groups, key)
+		}
+	}
+}
+
+// flush sends a group's notification if it changed or is due for a
+// repeat, and schedules the next flush
+func (d *Dispatcher) flush(group *alertGroup) {
+	d.mu.Lock()
+	if d.stopped || d.groups[group.key] != group {
+		d.mu.Unlock()
+		return
+	}
+
+	route := group.route
+	due := group.changed || time.Since(group.lastSent) >= route.repeatInterval
+	var notification *Notification
+	if due {
+		notification = d.notification(group)
+		// Nothing was sent about a group whose alerts resolved before
+		// group_wait ended
+		if !group.notified && len(notification.Firing()) == 0 {
+			notification = nil
+		}
+		group.changed = false
+		group.lastSent = time.Now()
+		if notification != nil {
+			group.notified = true
+		}
+
+		for fp, alert := range group.alerts {
+			if alert.State == models.AlertStateResolved {
+				delete(group.alerts, fp)
+			}
+		}
+	}
+
+	if len(group.alerts) == 0 {
+		delete(d.groups, group.key)
+	} else {
+		group.timer = time.AfterFunc(route.groupInterval, func() { d.flush(group) })
+	}
+	d.mu.Unlock()
+
+	if notification != nil {
+		d.send(notification)
+	}
+}
+
+// notification builds the message for a group. The caller holds d.mu.
+func (d *Dispatcher) notification(group *alertGroup) *Notification {
+	alerts := make([]*models.Alert, 0, len(group.alerts))
+	for _, alert := range group.alerts {
+		copied := *alert
+		alerts = append(alerts, &copied)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Name != alerts[j].Name {
+			return alerts[i].Name < alerts[j].Name
+		}
+		return alerts[i].ActiveAt.Before(alerts[j].ActiveAt)
+	})
+
+	n := &Notification{
+		Receiver:    group.route.receiver,
+		GroupKey:    group.key,
+		Status:      notificationResolved,
+		GroupLabels: copyLabels(group.labels),
+		Alerts:      alerts,
+	}
+	if len(n.Firing()) > 0 {
+		n.Status = notificationFiring
+	}
+
+	n.CommonLabels = commonPairs(alerts, func(a *models.Alert) map[string]string { return routingLabels(a) })
+	n.CommonAnnotations = commonPairs(alerts, func(a *models.Alert) map[string]string { return a.Annotations })
+	return n
+}
+
+// commonPairs returns the key/value pairs shared by every alert
+func commonPairs(alerts []*models.Alert, pairs func(*models.Alert) map[string]string) map[string]string {
+	common := make(map[string]string)
+	if len(alerts) == 0 {
+		return common
+	}
+	for k, v := range pairs(alerts[0]) {
+		common[k] = v
+	}
+	for _, alert := range alerts[1:] {
+		p := pairs(alert)
+		for k, v := range common {
+			if p[k] != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
+
+// send delivers a notification through every integration of its receiver
+func (d *Dispatcher) send(n *Notification) {
+	d.mu.Lock()
+	receiver, ok := d.receivers[n.Receiver]
+	d.mu.Unlock()
+	if !ok {
+		d.logger.Error("Alert routed to unknown receiver", zap.String("receiver", n.Receiver))
+		return
+	}
+
+	names := make([]string, 0, len(n.Alerts))
+	for _, alert := range n.Alerts {
+		names = append(names, alert.Name)
+	}
+	d.logger.Info("Sending alert notification",
+		zap.String("receiver", n.Receiver),
+		zap.String("status", n.Status),
+		zap.Any("group", n.GroupLabels),
+		zap.String("alerts", strings.Join(names, ",")),
+	)
+
+	var wg sync.WaitGroup
+	for _, integration := range receiver.integrations {
+		wg.Add(1)
+		go func(integration Integration) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+
+			if err := integration.Notify(ctx, n); err != nil {
+				d.logger.Warn("Failed to send alert notification",
+					zap.String("receiver", n.Receiver),
+					zap.String("integration", integration.Name()),
+					zap.Error(err),
+				)
+			}
+		}(integration)
+	}
+	wg.Wait()
+}
+
+// Stop cancels pending notifications
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stopped = true
+	for _, group := range d.groups {
+		group.timer.Stop()
+	}
+	d.groups = make(map[string]*alertGroup)
+}
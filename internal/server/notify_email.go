@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/i18n"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// Default email templates, rendered with the notification
+const (
+	defaultEmailSubject = `[{{ t (print "notification.status." .Status) | upper }}{{ if eq .Status "firing" }}:{{ len .Firing }}{{ end }}] ` +
+		`{{ or .CommonLabels.alertname (t "notification.multiple_alerts") }}`
+
+	defaultEmailText = `{{ range .Firing }}[{{ t "notification.status.firing" | upper }}] {{ .Name }}{{ with summary . }}: {{ . }}{{ end }}
+  {{ t "notification.label.severity" }}: {{ .Labels.severity }}  {{ t "notification.label.node" }}: {{ .Labels.node }}  {{ t "notification.label.value" }}: {{ .Value }}
+  {{ t "notification.label.since" }}: {{ .ActiveAt.UTC.Format "2006-01-02 15:04:05 MST" }}
+{{ end }}{{ range .Resolved }}[{{ t "notification.status.resolved" | upper }}] {{ .Name }}{{ with summary . }}: {{ . }}{{ end }}
+{{ end }}`
+
+	defaultEmailHTML = `<!DOCTYPE html>
+<html>
+<body style="font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #1d1c1d;">
+<h2 style="margin: 0 0 12px;">{{ or .CommonLabels.alertname (t "notification.multiple_alerts") }}</h2>
+<p>{{ t "notification.counts" (len .Firing) (len .Resolved) }}</p>
+<table cellpadding="6" cellspacing="0" style="border-collapse: collapse; width: 100%;">
+<tr style="background: #f4f4f4; text-align: left;">
+<th>{{ t "notification.label.status" }}</th><th>{{ t "notification.label.alert" }}</th><th>{{ t "notification.label.severity" }}</th><th>{{ t "notification.label.node" }}</th><th>{{ t "notification.label.value" }}</th><th>{{ t "notification.label.since" }}</th>
+</tr>
+{{ range .Alerts }}<tr style="border-top: 1px solid #ddd;">
+<td style="border-left: 4px solid {{ color . }};">{{ t (print "notification.status." (status .)) }}</td>
+<td><strong>{{ .Name }}</strong>{{ with summary . }}<br>{{ . }}{{ end }}</td>
+<td>{{ .Labels.severity }}</td>
+<td>{{ .Labels.node }}</td>
+<td>{{ .Value }}</td>
+<td>{{ .ActiveAt.UTC.Format "2006-01-02 15:04:05 MST" }}</td>
+</tr>
+{{ end }}</table>
+</body>
+</html>
+`
+)
+
+// emailNotifier sends notifications over SMTP as multipart messages with
+// plain text and HTML bodies
+type emailNotifier struct {
+	config  utils.EmailConfig
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+func newEmailNotifier(config utils.EmailConfig, catalog *i18n.Catalog) (*emailNotifier, error) {
+	t := catalog.Translator(config.Locale)
+	subject, err := parseTextTemplate("subject", config.Subject, defaultEmailSubject, t)
+	if err != nil {
+		return nil, err
+	}
+	text, err := parseTextTemplate("text", "", defaultEmailText, t)
+	if err != nil {
+		return nil, err
+	}
+
+	body := defaultEmailHTML
+	if config.HTMLTemplate != "" {
+		data, err := os.ReadFile(config.HTMLTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HTML template: %w", err)
+		}
+		body = string(data)
+	}
+	funcs := htmltemplate.FuncMap{"color": alertColor, "status": alertStatus, "t": t}
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+	html, err := htmltemplate.New("html").Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTML template: %w", err)
+	}
+
+	return &emailNotifier{
+		config:  config,
+		subject: subject,
+		text:    text,
+		html:    html,
+	}, nil
+}
+
+func (e *emailNotifier) Name() string {
+	return "email"
+}
+
+func (e *emailNotifier) Notify(ctx context.Context, n *Notification) error {
+	msg, err := e.message(n)
+	if err != nil {
+		return err
+	}
+	return retry(ctx, func() error { return e.send(ctx, msg) })
+}
+
+// message renders a notification as a MIME message
+func (e *emailNotifier) message(n *Notification) ([]byte, error) {
+	subject, err := executeTemplate(e.subject, n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render subject: %w", err)
+	}
+	text, err := executeTemplate(e.text, n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render text: %w", err)
+	}
+	var html bytes.Buffer
+	if err := e.html.Execute(&html, n); err != nil {
+		return nil, fmt.Errorf("failed to render HTML: %w", err)
+	}
+	return e.compose(subject, text, html.Bytes(), utils.HashLabels(n.GroupLabels))
+}
+
+// compose builds a MIME message with plain text and HTML alternatives. id
+// makes the Message-ID unique among messages sent at the same time.
+func (e *emailNotifier) compose(subject, text string, html []byte, id string) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", []byte(text)},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write(part.content)
+		qp.Close()
+	}
+	mw.Close()
+
+	// Templates may render newlines, which would end the header
+	subject = strings.Join(strings.Fields(subject), " ")
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%d.%s@lnmonja>\r\n", time.Now().UnixNano(), id)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+// send delivers a message in one SMTP session
+func (e *emailNotifier) send(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(e.config.SMTPHost, strconv.Itoa(e.config.SMTPPort))
+	tlsConfig := &tls.Config{
+		ServerName:         e.config.SMTPHost,
+		InsecureSkipVerify: e.config.InsecureSkipVerify,
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if e.config.TLS == utils.EmailTLSImplicit {
+		dialer := &tls.Dialer{Config: tlsConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	// net/smtp has no context support, so the deadline bounds the session
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, e.config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if e.config.TLS == utils.EmailTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return &permanentError{err: fmt.Errorf("%s does not support STARTTLS", addr)}
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if e.config.Username != "" {
+		auth := smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return smtpError(err)
+		}
+	}
+
+	if err := client.Mail(e.config.From); err != nil {
+		return smtpError(err)
+	}
+	for _, to := range e.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return smtpError(err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(err)
+	}
+	return client.Quit()
+}
+
+// smtpError marks permanent SMTP failures (5xx replies) so they are not
+// retried
+func smtpError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return &permanentError{err: err}
+	}
+	return err
+}
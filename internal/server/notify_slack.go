@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/i18n"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// Default Slack templates. The title is rendered with the notification,
+// the text with each alert.
+const (
+	defaultSlackTitle = `[{{ t (print "notification.status." .Status) | upper }}{{ if eq .Status "firing" }}:{{ len .Firing }}{{ end }}] ` +
+		`{{ or .CommonLabels.alertname (t "notification.multiple_alerts") }}`
+	defaultSlackText = `*{{ .Name }}*{{ with summary . }}: {{ . }}{{ end }}`
+)
+
+// slackMaxAlerts bounds the alerts listed in one message, as Slack allows
+// 50 blocks per message
+const slackMaxAlerts = 10
+
+// slackMessage is an incoming webhook payload. Blocks go in an attachment,
+// which is the only way to give a Block Kit message a colored bar.
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	IconEmoji   string            `json:"icon_emoji,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string       `json:"type"`
+	Text     *slackText   `json:"text,omitempty"`
+	Fields   []*slackText `json:"fields,omitempty"`
+	Elements []*slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func plainText(text string) *slackText {
+	return &slackText{Type: "plain_text", Text: text}
+}
+
+func markdown(text string) *slackText {
+	return &slackText{Type: "mrkdwn", Text: text}
+}
+
+// slackNotifier sends notifications to a Slack incoming webhook as Block
+// Kit messages colored by severity
+type slackNotifier struct {
+	config utils.SlackConfig
+	title  *template.Template
+	text   *template.Template
+	t      i18n.Translator
+	client *http.Client
+}
+
+func newSlackNotifier(config utils.SlackConfig, catalog *i18n.Catalog) (*slackNotifier, error) {
+	t := catalog.Translator(config.Locale)
+	title, err := parseTextTemplate("title", config.Title, defaultSlackTitle, t)
+	if err != nil {
+		return nil, err
+	}
+	text, err := parseTextTemplate("text", config.Text, defaultSlackText, t)
+	if err != nil {
+		return nil, err
+	}
+	return &slackNotifier{
+		config: config,
+		title:  title,
+		text:   text,
+		t:      t,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// parseTextTemplate parses a configured template, or the default when none
+// is set. The t function translates message keys with t.
+func parseTextTemplate(name, text, fallback string, t i18n.Translator) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Funcs(template.FuncMap{"t": t}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func executeTemplate(t *template.Template, data interface{}) (string, error) {
+	var buf strings.Builder
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (s *slackNotifier) Name() string {
+	return "slack"
+}
+
+func (s *slackNotifier) Notify(ctx context.Context, n *Notification) error {
+	msg, err := s.message(n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return retry(ctx, func() error { return s.post(ctx, body) })
+}
+
+// message builds the Slack message for a notification: a header, one
+// section per alert with its details, and a footer naming the group
+func (s *slackNotifier) message(n *Notification) (*slackMessage, error) {
+	title, err := executeTemplate(s.title, n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render title: %w", err)
+	}
+
+	// Header blocks are limited to 150 characters
+	header := title
+	if runes := []rune(header); len(runes) > 150 {
+		header = string(runes[:149]) + "…"
+	}
+	blocks := []slackBlock{{Type: "header", Text: plainText(header)}}
+
+	for i, alert := range n.Alerts {
+		if i == slackMaxAlerts {
+			blocks = append(blocks, slackBlock{
+				Type:     "context",
+				Elements: []*slackText{markdown(s.t("notification.more_alerts", len(n.Alerts)-slackMaxAlerts))},
+			})
+			break
+		}
+
+		text, err := executeTemplate(s.text, alert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render text: %w", err)
+		}
+		blocks = append(blocks, slackBlock{
+			Type:   "section",
+			Text:   markdown(text),
+			Fields: s.fields(alert),
+		})
+	}
+
+	var group []string
+	for k, v := range n.GroupLabels {
+		group = append(group, k+"="+v)
+	}
+	if len(group) > 0 {
+		sort.Strings(group)
+		blocks = append(blocks, slackBlock{
+			Type:     "context",
+			Elements: []*slackText{markdown(s.t("notification.label.group") + ": " + strings.Join(group, ", "))},
+		})
+	}
+
+	return &slackMessage{
+		Channel:   s.config.Channel,
+		Username:  s.config.Username,
+		IconEmoji: s.config.IconEmoji,
+		Text:      title,
+		Attachments: []slackAttachment{{
+			Color:  s.color(n),
+			Blocks: blocks,
+		}},
+	}, nil
+}
+
+// fields are the details shown under each alert
+func (s *slackNotifier) fields(alert *models.Alert) []*slackText {
+	field := func(key, value string) *slackText {
+		return markdown("*" + s.t(key) + "*\n" + value)
+	}
+	fields := []*slackText{field("notification.label.status", s.t("notification.status."+alertStatus(alert)))}
+	if severity := alert.Labels["severity"]; severity != "" {
+		fields = append(fields, field("notification.label.severity", severity))
+	}
+	if node := alert.Labels["node"]; node != "" {
+		fields = append(fields, field("notification.label.node", node))
+	}
+	fields = append(fields,
+		field("notification.label.value", fmt.Sprintf("%g", alert.Value)),
+		field("notification.label.since", fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>",
+			alert.ActiveAt.Unix(), alert.ActiveAt.UTC().Format(time.RFC3339))),
+	)
+	return fields
+}
+
+// color returns the message color: green once everything resolved,
+// otherwise the color of the most urgent severity
+func (s *slackNotifier) color(n *Notification) string {
+	key := notificationResolved
+	if n.Status == notificationFiring {
+		key = notificationSeverity(n)
+	}
+	if c, ok := s.config.Colors[key]; ok {
+		return c
+	}
+	return severityColor(key)
+}
+
+func (s *slackNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return statusError("slack", resp)
+}
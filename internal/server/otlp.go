@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/server/api"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// otlpDefaultNode is the node of resources without any of the configured
+// node attributes
+const otlpDefaultNode = "otlp"
+
+// OTLPReceiver converts OpenTelemetry metrics to lnmonja metrics and
+// stores them like those sent by agents
+type OTLPReceiver struct {
+	config   *utils.OTLPConfig
+	store    storage.Storage
+	nodeMgr  *NodeManager
+	alertMgr *AlertManager
+	rules    *IngestRules
+	ingest   *IngestStats
+	metrics  *telemetry.Metrics
+	filters  *MetricFilters
+	naming   *NamingChecker
+	live     LivePublisher
+	logger   *zap.Logger
+}
+
+// NewOTLPReceiver creates the receiver
+func NewOTLPReceiver(config *utils.OTLPConfig, store storage.Storage, nodeMgr *NodeManager, alertMgr *AlertManager, logger *zap.Logger) *OTLPReceiver {
+	return &OTLPReceiver{
+		config:   config,
+		store:    store,
+		nodeMgr:  nodeMgr,
+		alertMgr: alertMgr,
+		logger:   logger.Named("otlp"),
+	}
+}
+
+// Export stores the metrics of an export request. It returns the number of
+// data points that were rejected, because their type is not supported,
+// with the reason.
+func (o *OTLPReceiver) Export(data *metricspb.MetricsData) (int64, string, error) {
+	byNode := make(map[string][]*models.Metric)
+	var rejected int64
+	var reason string
+
+	for _, rm := range data.GetResourceMetrics() {
+		resource := rm.GetResource().GetAttributes()
+		nodeID := o.nodeID(resource)
+
+		labels := make(map[string]string)
+		addAttributes(labels, resource)
+		for _, sm := range rm.GetScopeMetrics() {
+			scopeLabels := labels
+			if scope := sm.GetScope(); scope.GetName() != "" {
+				scopeLabels = copyLabels(labels)
+				scopeLabels["otel_scope_name"] = scope.GetName()
+				if scope.GetVersion() != "" {
+					scopeLabels["otel_scope_version"] = scope.GetVersion()
+				}
+			}
+
+			for _, m := range sm.GetMetrics() {
+				metrics, n := convertOTLPMetric(nodeID, scopeLabels, m)
+				byNode[nodeID] = append(byNode[nodeID], metrics...)
+				if n > 0 {
+					rejected += int64(n)
+					reason = fmt.Sprintf("metric %s: only gauges, sums and histograms are supported", m.GetName())
+				}
+			}
+		}
+	}
+
+	for nodeID, metrics := range byNode {
+		if err := o.write(nodeID, metrics); err != nil {
+			return rejected, reason, err
+		}
+	}
+	return rejected, reason, nil
+}
+
+// write runs the agent ingest path for the metrics of one node
+func (o *OTLPReceiver) write(nodeID string, metrics []*models.Metric) error {
+	serverLabels := o.nodeMgr.ServerLabels(nodeID)
+	if o.filters != nil {
+		if metrics = o.filters.Apply(nodeID, serverLabels, metrics); len(metrics) == 0 {
+			return nil
+		}
+	}
+	if o.naming != nil {
+		o.naming.Check(nodeID, metrics)
+	}
+
+	addNodeLabels(metrics, serverLabels)
+	if o.rules != nil {
+		received := len(metrics)
+		metrics = o.rules.Apply(metrics)
+		o.metrics.Dropped("otlp", received-len(metrics))
+		if len(metrics) == 0 {
+			return nil
+		}
+	}
+	if o.ingest != nil {
+		o.ingest.Record(nodeID, metrics)
+	}
+
+	if err := o.store.WriteMetrics(metrics); err != nil {
+		o.metrics.StorageError("write")
+		o.logger.Error("Failed to store OTLP metrics",
+			zap.String("node_id", nodeID),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to store metrics: %w", err)
+	}
+	o.metrics.Ingested("otlp", len(metrics))
+	if o.live != nil {
+		o.live.BroadcastMetrics(metrics)
+	}
+
+	o.alertMgr.CheckMetrics(nodeID, metrics)
+	return nil
+}
+
+// nodeID returns the first configured node attribute of a resource
+func (o *OTLPReceiver) nodeID(attrs []*commonpb.KeyValue) string {
+	for _, name := range o.config.NodeAttributes {
+		for _, kv := range attrs {
+			if kv.GetKey() == name {
+				if value, ok := attributeValue(kv.GetValue()); ok && value != "" {
+					return value
+				}
+			}
+		}
+	}
+	return otlpDefaultNode
+}
+
+// convertOTLPMetric converts the data points of a metric, returning the
+// number of points of unsupported types. Histograms become _bucket series
+// with an le label and _sum and _count series, as in Prometheus.
+func convertOTLPMetric(nodeID string, labels map[string]string, m *metricspb.Metric) ([]*models.Metric, int) {
+	name := utils.Symbols.Intern(sanitizeOTLPName(m.GetName()))
+	var metrics []*models.Metric
+	add := func(name string, value float64, ts uint64, labels map[string]string, typ models.MetricType) {
+		metrics = append(metrics, &models.Metric{
+			NodeID:    nodeID,
+			Name:      name,
+			Value:     value,
+			Timestamp: otlpTime(ts),
+			Labels:    labels,
+			Type:      typ,
+			Help:      m.GetDescription(),
+			Unit:      m.GetUnit(),
+		})
+	}
+
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, p := range data.Gauge.GetDataPoints() {
+			if noRecordedValue(p.GetFlags()) {
+				continue
+			}
+			add(name, numberValue(p), p.GetTimeUnixNano(), pointLabels(labels, p.GetAttributes()), models.MetricTypeGauge)
+		}
+	case *metricspb.Metric_Sum:
+		// Delta sums are stored as the change in each interval
+		typ := models.MetricTypeGauge
+		if data.Sum.GetIsMonotonic() && data.Sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			typ = models.MetricTypeCounter
+		}
+		for _, p := range data.Sum.GetDataPoints() {
+			if noRecordedValue(p.GetFlags()) {
+				continue
+			}
+			add(name, numberValue(p), p.GetTimeUnixNano(), pointLabels(labels, p.GetAttributes()), typ)
+		}
+	case *metricspb.Metric_Histogram:
+		for _, p := range data.Histogram.GetDataPoints() {
+			if noRecordedValue(p.GetFlags()) {
+				continue
+			}
+			base := pointLabels(labels, p.GetAttributes())
+			ts := p.GetTimeUnixNano()
+
+			var cumulative uint64
+			counts := p.GetBucketCounts()
+			for i, bound := range p.GetExplicitBounds() {
+				if i < len(counts) {
+					cumulative += counts[i]
+				}
+				bucket := copyLabels(base)
+				bucket["le"] = strconv.FormatFloat(bound, 'g', -1, 64)
+				add(name+"_bucket", float64(cumulative), ts, bucket, models.MetricTypeHistogram)
+			}
+			bucket := copyLabels(base)
+			bucket["le"] = "+Inf"
+			add(name+"_bucket", float64(p.GetCount()), ts, bucket, models.MetricTypeHistogram)
+
+			if p.Sum != nil {
+				add(name+"_sum", p.GetSum(), ts, base, models.MetricTypeHistogram)
+			}
+			add(name+"_count", float64(p.GetCount()), ts, base, models.MetricTypeHistogram)
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		return nil, len(data.ExponentialHistogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		return nil, len(data.Summary.GetDataPoints())
+	}
+	return metrics, 0
+}
+
+func numberValue(p *metricspb.NumberDataPoint) float64 {
+	if v, ok := p.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return p.GetAsDouble()
+}
+
+func noRecordedValue(flags uint32) bool {
+	return flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+// otlpTime converts a timestamp in nanoseconds, using the current time
+// for points without one
+func otlpTime(ts uint64) time.Time {
+	if ts == 0 || ts > math.MaxInt64 {
+		return time.Now()
+	}
+	return time.Unix(0, int64(ts))
+}
+
+// pointLabels merges a data point's attributes over the resource and
+// scope labels
+func pointLabels(labels map[string]string, attrs []*commonpb.KeyValue) map[string]string {
+	merged := copyLabels(labels)
+	addAttributes(merged, attrs)
+	return merged
+}
+
+// addAttributes adds attributes with scalar values as labels, with their
+// names made valid label names
+func addAttributes(labels map[string]string, attrs []*commonpb.KeyValue) {
+	for _, kv := range attrs {
+		if value, ok := attributeValue(kv.GetValue()); ok {
+			labels[utils.Symbols.Intern(sanitizeOTLPLabel(kv.GetKey()))] = utils.Symbols.Intern(value)
+		}
+	}
+}
+
+func attributeValue(v *commonpb.AnyValue) (string, bool) {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue, true
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(value.BoolValue), true
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(value.IntValue, 10), true
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(value.DoubleValue, 'g', -1, 64), true
+	}
+	return "", false
+}
+
+// sanitizeOTLPName makes a metric name such as http.server.duration a
+// valid lnmonja name, http_server_duration
+func sanitizeOTLPName(name string) string {
+	return sanitizeOTLP(name, true)
+}
+
+func sanitizeOTLPLabel(name string) string {
+	return sanitizeOTLP(name, false)
+}
+
+func sanitizeOTLP(name string, colons bool) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':' && colons:
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// otlpMetricsServiceDesc describes the OTLP MetricsService. An
+// ExportMetricsServiceRequest has the wire format of MetricsData, and a
+// response, holding only a partial_success message as field 1, that of a
+// BytesValue, so the service needs no generated collector package.
+var otlpMetricsServiceDesc = grpc.ServiceDesc{
+	ServiceName: "opentelemetry.proto.collector.metrics.v1.MetricsService",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Export",
+			Handler:    otlpExportHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "opentelemetry/proto/collector/metrics/v1/metrics_service.proto",
+}
+
+func otlpExportHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(metricspb.MetricsData)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	export := func(ctx context.Context, req interface{}) (interface{}, error) {
+		rejected, reason, err := srv.(*OTLPReceiver).Export(req.(*metricspb.MetricsData))
+		if err != nil {
+			return nil, err
+		}
+		return &wrapperspb.BytesValue{Value: api.OTLPPartialSuccess(rejected, reason)}, nil
+	}
+	if interceptor == nil {
+		return export(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export",
+	}
+	return interceptor(ctx, in, info, export)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
+	"github.com/meettoy2004/lnmonja/pkg/protocol"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Overload levels, by how many batches are being processed at once
+const (
+	overloadNone = iota
+	// overloadElevated samples low priority metrics
+	overloadElevated
+	// overloadSevere drops low priority metrics and samples normal ones
+	overloadSevere
+)
+
+var overloadStates = [...]string{"normal", "elevated", "overloaded"}
+
+// overloadHold is how long the guard stays at a level before lowering it,
+// so a load around a threshold does not flap
+const overloadHold = 10 * time.Second
+
+// OverloadGuard sheds metrics by priority when the server processes more
+// agent batches at once than it keeps up with. Critical metrics are never
+// shed, and heartbeats do not go through it.
+type OverloadGuard struct {
+	maxInflight  int64
+	lowSample    float64
+	normalSample float64
+	logger       *zap.Logger
+	// metrics records the samples shed
+	metrics *telemetry.Metrics
+
+	inflight atomic.Int64
+
+	mu    sync.Mutex
+	level int
+	since time.Time
+	// raised is when the level was last reached or exceeded
+	raised time.Time
+
+	// shed counts the samples shed by priority
+	shed [3]atomic.Uint64
+}
+
+// NewOverloadGuard creates a guard with the configured thresholds, or nil
+// if overload shedding is disabled
+func NewOverloadGuard(config *utils.Config, logger *zap.Logger) *OverloadGuard {
+	overload := config.Server.GRPC.Overload
+	if !overload.Enabled {
+		return nil
+	}
+	return &OverloadGuard{
+		maxInflight:  int64(overload.MaxInflight),
+		lowSample:    overload.LowSampleRate,
+		normalSample: overload.NormalSampleRate,
+		logger:       logger,
+		since:        time.Now(),
+	}
+}
+
+// enter accounts a batch being processed and returns the level to shed
+// its metrics at. Each call must be followed by leave.
+func (g *OverloadGuard) enter() int {
+	if g == nil {
+		return overloadNone
+	}
+	return g.update(g.inflight.Add(1))
+}
+
+// leave accounts a batch done processing
+func (g *OverloadGuard) leave() {
+	if g != nil {
+		g.inflight.Add(-1)
+	}
+}
+
+// update sets the level for the batches in flight and returns it. Levels
+// are raised at once and lowered once they held for overloadHold.
+func (g *OverloadGuard) update(inflight int64) int {
+	level := overloadNone
+	switch {
+	case inflight >= g.maxInflight:
+		level = overloadSevere
+	case inflight > g.maxInflight/2:
+		level = overloadElevated
+	}
+
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if level >= g.level {
+		g.raised = now
+	}
+	if level == g.level || level < g.level && now.Sub(g.raised) < overloadHold {
+		return g.level
+	}
+
+	g.logger.Warn("Ingestion overload state changed",
+		zap.String("from", overloadStates[g.level]),
+		zap.String("to", overloadStates[level]),
+		zap.Int64("inflight", inflight),
+		zap.Int64("max_inflight", g.maxInflight),
+	)
+	g.level = level
+	g.since = now
+	return level
+}
+
+// Level returns the current overload level
+func (g *OverloadGuard) Level() int {
+	if g == nil {
+		return overloadNone
+	}
+	return g.update(g.inflight.Load())
+}
+
+// filter returns the metrics kept at a level, without modifying the batch
+func (g *OverloadGuard) filter(level int, metrics []*protocol.Metric) []*protocol.Metric {
+	if level == overloadNone {
+		return metrics
+	}
+
+	var shed [3]int
+	kept := make([]*protocol.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		priority := m.GetPriority()
+		if priority < 0 || int(priority) >= len(shed) {
+			// Priorities added later are treated as normal
+			priority = protocol.Priority_NORMAL
+		}
+		if g.keep(level, priority) {
+			kept = append(kept, m)
+		} else {
+			shed[priority]++
+		}
+	}
+
+	for priority, n := range shed {
+		if n > 0 {
+			g.shed[priority].Add(uint64(n))
+			g.metrics.Shed(priorityName(protocol.Priority(priority)), n)
+		}
+	}
+	return kept
+}
+
+// keep decides whether a metric of a priority is processed at a level
+func (g *OverloadGuard) keep(level int, priority protocol.Priority) bool {
+	switch priority {
+	case protocol.Priority_CRITICAL:
+		return true
+	case protocol.Priority_LOW:
+		if level == overloadSevere {
+			return false
+		}
+		return rand.Float64() < g.lowSample
+	default:
+		if level == overloadSevere {
+			return rand.Float64() < g.normalSample
+		}
+		return true
+	}
+}
+
+// Status reports the overload state and the samples shed so far
+func (g *OverloadGuard) Status() *models.OverloadStatus {
+	if g == nil {
+		return &models.OverloadStatus{State: overloadStates[overloadNone], Shed: map[string]uint64{}}
+	}
+
+	level := g.Level()
+	g.mu.Lock()
+	since := g.since
+	g.mu.Unlock()
+
+	shed := make(map[string]uint64, len(g.shed))
+	for priority := range g.shed {
+		shed[priorityName(protocol.Priority(priority))] = g.shed[priority].Load()
+	}
+	return &models.OverloadStatus{
+		Enabled:     true,
+		State:       overloadStates[level],
+		Since:       since,
+		Inflight:    g.inflight.Load(),
+		MaxInflight: int(g.maxInflight),
+		Shed:        shed,
+	}
+}
+
+// priorityName names a priority as in the agent config
+func priorityName(priority protocol.Priority) string {
+	switch priority {
+	case protocol.Priority_CRITICAL:
+		return "critical"
+	case protocol.Priority_LOW:
+		return "low"
+	default:
+		return "normal"
+	}
+}
@@ -0,0 +1,524 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// restStore adapts storage.Storage to the interface used by the REST API
+type restStore struct {
+	store   storage.Storage
+	derived *DerivedMetrics
+	usage   *UsageTracker
+	ingest  *IngestStats
+	grpc    *GRPCServer
+	decom   *Decommissioner
+	// kube serves dashboards defined as custom resources, if enabled
+	kube *KubeController
+	// dashboards holds the dashboards stored through the API
+	dashboards *Dashboards
+	// alerts applies silences as they are changed
+	alerts *AlertManager
+	// ingestRules transform metrics as they are received
+	ingestRules *IngestRules
+	// otlp stores OTLP/HTTP exports, if the receiver is enabled
+	otlp *OTLPReceiver
+	// metrics times queries for the server's self-metrics
+	metrics *telemetry.Metrics
+	// naming records naming convention violations, if checks are enabled
+	naming *NamingChecker
+	// reloader applies changes to the configuration file
+	reloader *ConfigReloader
+	// metering counts the samples queries return per tenant, if enabled
+	metering *Metering
+	// trends reports monthly node trends, if enabled
+	trends *Trends
+}
+
+func newRESTStore(store storage.Storage, derived *DerivedMetrics, usage *UsageTracker, ingest *IngestStats, grpc *GRPCServer, decom *Decommissioner) *restStore {
+	return &restStore{store: store, derived: derived, usage: usage, ingest: ingest, grpc: grpc, decom: decom}
+}
+
+// QueryMetrics executes a selector query such as `name{label="value"}`.
+// Derived metric names are evaluated from their expression.
+func (r *restStore) QueryMetrics(query string, start, end time.Time, step time.Duration) (series []*models.TimeSeries, err error) {
+	defer func(began time.Time) { r.metrics.ObserveQuery("metrics", began, err) }(time.Now())
+
+	name, labels := storage.ParseQuery(query)
+
+	if series, ok, err := r.derived.Query(name, labels, start, end, step); ok {
+		if r.metering != nil && err == nil {
+			r.metering.RecordQuery(series)
+		}
+		return series, err
+	}
+
+	series, err = r.store.QueryMetrics(&models.Query{
+		MetricName: name,
+		Labels:     labels,
+		StartTime:  start,
+		EndTime:    end,
+		Step:       step,
+	})
+	if r.metering != nil && err == nil {
+		r.metering.RecordQuery(series)
+	}
+	return series, err
+}
+
+// FindSeries returns the series matching any of the selectors, such as
+// `name{label="value"}`, in the time range, or every series without one.
+// They are sorted by their selector.
+func (r *restStore) FindSeries(matchers []string, start, end time.Time) (_ []*models.Series, err error) {
+	defer func(began time.Time) { r.metrics.ObserveQuery("series", began, err) }(time.Now())
+
+	if len(matchers) == 0 {
+		matchers = []string{""}
+	}
+
+	found := make(map[string]*models.Series)
+	for _, matcher := range matchers {
+		name, labels := storage.ParseQuery(matcher)
+		series, err := r.store.FindSeries(&models.Query{
+			MetricName: name,
+			Labels:     labels,
+			StartTime:  start,
+			EndTime:    end,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range series {
+			found[s.String()] = s
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]*models.Series, len(keys))
+	for i, key := range keys {
+		series[i] = found[key]
+	}
+	return series, nil
+}
+
+// LabelNames returns the label names, sorted, of the series in the time
+// range, only those matching any of the selectors if there are some
+func (r *restStore) LabelNames(matchers []string, start, end time.Time) ([]string, error) {
+	if len(matchers) == 0 {
+		return r.store.LabelNames(start, end)
+	}
+
+	series, err := r.FindSeries(matchers, start, end)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{"__name__": true}
+	for _, s := range series {
+		for k := range s.Labels {
+			names[k] = true
+		}
+	}
+	return sortedNames(names), nil
+}
+
+// LabelValues returns the values, sorted, of a label in the time range,
+// only in the series matching any of the selectors if there are some
+func (r *restStore) LabelValues(label string, matchers []string, start, end time.Time) ([]string, error) {
+	if len(matchers) == 0 {
+		return r.store.LabelValues(label, start, end)
+	}
+
+	series, err := r.FindSeries(matchers, start, end)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]bool)
+	for _, s := range series {
+		if label == "__name__" {
+			values[s.Name] = true
+		} else if v, ok := s.Labels[label]; ok {
+			values[v] = true
+		}
+	}
+	return sortedNames(values), nil
+}
+
+// GetNodes returns all known nodes
+func (r *restStore) GetNodes() ([]*models.Node, error) {
+	return r.store.ListNodes()
+}
+
+// GetNode returns a single node
+func (r *restStore) GetNode(nodeID string) (*models.Node, error) {
+	return r.store.GetNode(nodeID)
+}
+
+// UpdateNodeLabels sets and removes server-side labels on a node
+func (r *restStore) UpdateNodeLabels(nodeID string, set map[string]string, remove []string) (*models.Node, error) {
+	return r.grpc.nodeMgr.UpdateLabels(nodeID, set, remove)
+}
+
+// DecommissionNode starts retiring a node
+func (r *restStore) DecommissionNode(nodeID string, decommission *models.Decommission) (*models.Node, error) {
+	return r.decom.Decommission(nodeID, decommission)
+}
+
+// ReactivateNode cancels decommissioning of a retiring node
+func (r *restStore) ReactivateNode(nodeID string) (*models.Node, error) {
+	return r.decom.Reactivate(nodeID)
+}
+
+// DepartNode records a clean scale-in of an ephemeral node
+func (r *restStore) DepartNode(nodeID string) (*models.Node, error) {
+	return r.decom.Depart(nodeID)
+}
+
+// GetAlerts returns alerts, optionally filtered by state name
+func (r *restStore) GetAlerts(state string) ([]*models.Alert, error) {
+	filter := &models.AlertFilter{}
+
+	if state != "" {
+		alertState, err := parseAlertState(state)
+		if err != nil {
+			return nil, err
+		}
+		filter.State = &alertState
+	}
+
+	return r.store.GetAlerts(filter)
+}
+
+// AlertEvents returns persisted alert transitions after a sequence
+func (r *restStore) AlertEvents(after uint64, limit int) ([]*models.AlertEvent, error) {
+	return r.grpc.alertMgr.feed.Events(after, limit)
+}
+
+// SubscribeAlertEvents subscribes to live alert transitions
+func (r *restStore) SubscribeAlertEvents() (<-chan *models.AlertEvent, uint64, func(), error) {
+	return r.grpc.alertMgr.feed.Subscribe()
+}
+
+// ListDashboards returns the stored dashboards and those defined as custom
+// resources that match the filter, sorted by name
+func (r *restStore) ListDashboards(filter *models.DashboardFilter) ([]*models.Dashboard, error) {
+	all := r.dashboards.List()
+	if r.kube != nil {
+		all = append(all, r.kube.Dashboards()...)
+	}
+
+	dashboards := make([]*models.Dashboard, 0, len(all))
+	for _, dashboard := range all {
+		if filter == nil || filter.Matches(dashboard) {
+			dashboards = append(dashboards, dashboard)
+		}
+	}
+	sort.Slice(dashboards, func(i, j int) bool {
+		if dashboards[i].Name != dashboards[j].Name {
+			return dashboards[i].Name < dashboards[j].Name
+		}
+		return dashboards[i].ID < dashboards[j].ID
+	})
+	if filter != nil && filter.Limit > 0 && len(dashboards) > filter.Limit {
+		dashboards = dashboards[:filter.Limit]
+	}
+	return dashboards, nil
+}
+
+// GetDashboard returns a stored dashboard or one defined as a custom
+// resource
+func (r *restStore) GetDashboard(id string) (*models.Dashboard, error) {
+	if dashboard, ok := r.dashboards.Get(id); ok {
+		return dashboard, nil
+	}
+	if r.kube != nil {
+		if dashboard, ok := r.kube.Dashboard(id); ok {
+			return dashboard, nil
+		}
+	}
+	return nil, fmt.Errorf("dashboard %s not found", id)
+}
+
+// CreateDashboard stores a new dashboard
+func (r *restStore) CreateDashboard(dashboard *models.Dashboard) (*models.Dashboard, error) {
+	return r.dashboards.Create(dashboard)
+}
+
+// UpdateDashboard saves a new version of a stored dashboard
+func (r *restStore) UpdateDashboard(id string, dashboard *models.Dashboard) (*models.Dashboard, error) {
+	if err := r.checkDashboardEditable(id); err != nil {
+		return nil, err
+	}
+	return r.dashboards.Update(id, dashboard)
+}
+
+// DeleteDashboard deletes a stored dashboard and its versions
+func (r *restStore) DeleteDashboard(id string) error {
+	if err := r.checkDashboardEditable(id); err != nil {
+		return err
+	}
+	return r.dashboards.Delete(id)
+}
+
+// DashboardVersions lists the saved versions of a stored dashboard
+func (r *restStore) DashboardVersions(id string) ([]*models.DashboardVersion, error) {
+	return r.dashboards.Versions(id)
+}
+
+// DashboardVersion returns a saved version of a stored dashboard
+func (r *restStore) DashboardVersion(id string, version int) (*models.Dashboard, error) {
+	return r.dashboards.Version(id, version)
+}
+
+// RestoreDashboardVersion saves an earlier version of a stored dashboard
+// as its newest
+func (r *restStore) RestoreDashboardVersion(id string, version int, user string) (*models.Dashboard, error) {
+	return r.dashboards.Restore(id, version, user)
+}
+
+// ImportDashboards stores exported dashboards as new ones
+func (r *restStore) ImportDashboards(dashboards []*models.Dashboard, user string) ([]*models.Dashboard, error) {
+	return r.dashboards.Import(dashboards, user)
+}
+
+// checkDashboardEditable rejects changes to dashboards defined as custom
+// resources, which are changed through Kubernetes
+func (r *restStore) checkDashboardEditable(id string) error {
+	if r.kube == nil {
+		return nil
+	}
+	if _, ok := r.kube.Dashboard(id); ok {
+		return fmt.Errorf("dashboard %s is managed by a Kubernetes resource", id)
+	}
+	return nil
+}
+
+// ListDerivedMetrics returns all derived metric definitions
+func (r *restStore) ListDerivedMetrics() ([]*models.DerivedMetric, error) {
+	return r.derived.List(), nil
+}
+
+// GetDerivedMetric returns a derived metric definition
+func (r *restStore) GetDerivedMetric(name string) (*models.DerivedMetric, error) {
+	def, ok := r.derived.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("derived metric %s not found", name)
+	}
+	return def, nil
+}
+
+// SaveDerivedMetric validates and stores a derived metric definition
+func (r *restStore) SaveDerivedMetric(def *models.DerivedMetric) (*models.DerivedMetric, error) {
+	return r.derived.Save(def)
+}
+
+// DeleteDerivedMetric deletes a derived metric definition
+func (r *restStore) DeleteDerivedMetric(name string) error {
+	return r.derived.Delete(name)
+}
+
+// ListIngestRules returns the ingest rules in the order they run
+func (r *restStore) ListIngestRules() ([]*models.IngestRule, error) {
+	return r.ingestRules.List(), nil
+}
+
+// GetIngestRule returns an ingest rule
+func (r *restStore) GetIngestRule(name string) (*models.IngestRule, error) {
+	rule, ok := r.ingestRules.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("ingest rule %s not found", name)
+	}
+	return rule, nil
+}
+
+// SaveIngestRule validates and stores an ingest rule
+func (r *restStore) SaveIngestRule(rule *models.IngestRule) (*models.IngestRule, error) {
+	return r.ingestRules.Save(rule)
+}
+
+// DeleteIngestRule deletes an ingest rule
+func (r *restStore) DeleteIngestRule(name string) error {
+	return r.ingestRules.Delete(name)
+}
+
+// ExportOTLPMetrics stores the metrics of an OTLP export request
+func (r *restStore) ExportOTLPMetrics(data *metricspb.MetricsData) (int64, string, error) {
+	if r.otlp == nil {
+		return 0, "", fmt.Errorf("the OTLP receiver is not enabled")
+	}
+	return r.otlp.Export(data)
+}
+
+// ListSilences returns the silences with a status, or all of them
+func (r *restStore) ListSilences(status string) ([]*models.Silence, error) {
+	return r.alerts.silences.List(status), nil
+}
+
+// GetSilence returns a silence
+func (r *restStore) GetSilence(id string) (*models.Silence, error) {
+	return r.alerts.silences.Get(id)
+}
+
+// CreateSilence stores a silence and mutes the alerts it matches
+func (r *restStore) CreateSilence(silence *models.Silence) (*models.Silence, error) {
+	saved, err := r.alerts.silences.Create(silence)
+	if err != nil {
+		return nil, err
+	}
+	r.alerts.ApplySilences()
+	return saved, nil
+}
+
+// UpdateSilence changes a silence and reapplies it to the alerts
+func (r *restStore) UpdateSilence(id string, silence *models.Silence) (*models.Silence, error) {
+	saved, err := r.alerts.silences.Update(id, silence)
+	if err != nil {
+		return nil, err
+	}
+	r.alerts.ApplySilences()
+	return saved, nil
+}
+
+// ExpireSilence ends a silence, notifying the alerts it muted again
+func (r *restStore) ExpireSilence(id string) (*models.Silence, error) {
+	saved, err := r.alerts.silences.Expire(id)
+	if err != nil {
+		return nil, err
+	}
+	r.alerts.ApplySilences()
+	return saved, nil
+}
+
+// ReloadRules reloads the alert rule files
+func (r *restStore) ReloadRules() error {
+	return r.alerts.ReloadRules()
+}
+
+// ReloadConfig applies the reloadable changes to the configuration file
+func (r *restStore) ReloadConfig() (*models.ConfigReload, error) {
+	return r.reloader.Reload()
+}
+
+// GetAnnotations returns annotations matching the filter
+func (r *restStore) GetAnnotations(filter *models.AnnotationFilter) ([]*models.Annotation, error) {
+	return r.store.GetAnnotations(filter)
+}
+
+// SaveAnnotation stores an annotation
+func (r *restStore) SaveAnnotation(annotation *models.Annotation) error {
+	return r.store.SaveAnnotation(annotation)
+}
+
+// DeleteAnnotation deletes an annotation
+func (r *restStore) DeleteAnnotation(id string) error {
+	return r.store.DeleteAnnotation(id)
+}
+
+// RecordQueryUsage records that a query was run through the API
+func (r *restStore) RecordQueryUsage(query string) {
+	r.usage.RecordQuery(query, UsageSourceQuery)
+}
+
+// UsageReport summarizes metric usage for series stored in the range
+func (r *restStore) UsageReport(start, end time.Time) (*models.UsageReport, error) {
+	return r.usage.Report(start, end)
+}
+
+// UnusedSeries lists series stored in the range that nothing uses
+func (r *restStore) UnusedSeries(start, end time.Time) (*models.UnusedSeriesReport, error) {
+	return r.usage.Unused(start, end)
+}
+
+// IngestStats returns ingestion rates per node and metric over the window
+func (r *restStore) IngestStats(window time.Duration, limit int) *models.IngestStats {
+	return r.ingest.Snapshot(window, limit)
+}
+
+// OverloadStatus returns the ingestion overload state and the samples
+// shed by priority
+func (r *restStore) OverloadStatus() *models.OverloadStatus {
+	return r.grpc.overload.Status()
+}
+
+// TenantUsage returns the metered usage of tenants over a range of days
+func (r *restStore) TenantUsage(from, to, tenant string) (*models.TenantUsageReport, error) {
+	if r.metering == nil {
+		return nil, fmt.Errorf("metering is not enabled")
+	}
+	return r.metering.Report(from, to, tenant)
+}
+
+// TrendReport returns the monthly trends of nodes or groups over a range
+// of months
+func (r *restStore) TrendReport(from, to, groupBy string) (*models.TrendReport, error) {
+	if r.trends == nil {
+		return nil, fmt.Errorf("trends are not enabled")
+	}
+	return r.trends.Report(from, to, groupBy)
+}
+
+// NamingReport returns the naming convention violations of received
+// metrics, optionally only those of one rule
+func (r *restStore) NamingReport(rule string, limit int) *models.NamingReport {
+	return r.naming.Report(rule, limit)
+}
+
+// StorageUsage returns the latest storage usage estimate
+func (r *restStore) StorageUsage() (*models.StorageUsage, error) {
+	return r.store.StorageUsage()
+}
+
+// MigrationStatus returns the progress of the storage migration
+func (r *restStore) MigrationStatus() *models.MigrationStatus {
+	return r.store.MigrationStatus()
+}
+
+// CollectNow runs collectors on a connected agent and returns the results
+func (r *restStore) CollectNow(ctx context.Context, nodeID string, collectors []string) ([]*models.Metric, error) {
+	return r.grpc.CollectNow(ctx, nodeID, collectors)
+}
+
+// UpdateCollectors changes collector schedules on a connected agent
+func (r *restStore) UpdateCollectors(ctx context.Context, nodeID string, changes []*models.CollectorChange) ([]*models.CollectorState, error) {
+	return r.grpc.UpdateCollectors(ctx, nodeID, changes)
+}
+
+// Ping checks that the storage backend is reachable
+func (r *restStore) Ping() error {
+	_, err := r.store.ListNodes()
+	return err
+}
+
+func parseAlertState(s string) (models.AlertState, error) {
+	for _, state := range []models.AlertState{
+		models.AlertStateInactive,
+		models.AlertStatePending,
+		models.AlertStateFiring,
+		models.AlertStateResolved,
+	} {
+		if state.String() == s {
+			return state, nil
+		}
+	}
+
+	return models.AlertStateInactive, fmt.Errorf("unknown alert state: %s", s)
+}
+
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
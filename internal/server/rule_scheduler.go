@@ -0,0 +1,384 @@
+package server
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// staleSample is how long the latest sample of a series is evaluated after
+// it was received, so series that stop reporting drop out
+const staleSample = 5 * time.Minute
+
+// Reasons a scheduled evaluation did not run
+const (
+	// missedLate evaluations were due while the scheduler was not running,
+	// such as when the host was suspended
+	missedLate = "late"
+	// missedOverrun evaluations were due while the rule's previous one was
+	// still running
+	missedOverrun = "overrun"
+	// missedQueued evaluations waited for their group's concurrency limit
+	// until their next slot
+	missedQueued = "queued"
+)
+
+// latestSample is the latest sample of a series, as received
+type latestSample struct {
+	nodeID    string
+	labels    map[string]string
+	value     float64
+	timestamp time.Time
+	received  time.Time
+}
+
+// scheduledRule is a rule's place in the evaluation schedule. Each rule has
+// a slot in its interval, at an offset hashed from its group and name, so
+// the rules are spread over the interval rather than evaluated at once.
+type scheduledRule struct {
+	name     string
+	group    string
+	interval time.Duration
+	// due is the rule's current slot; runAt adds the jitter
+	due     time.Time
+	runAt   time.Time
+	running atomic.Bool
+	index   int
+}
+
+// ruleQueue orders scheduled rules by when they run
+type ruleQueue []*scheduledRule
+
+func (q ruleQueue) Len() int           { return len(q) }
+func (q ruleQueue) Less(i, j int) bool { return q[i].runAt.Before(q[j].runAt) }
+func (q ruleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *ruleQueue) Push(x interface{}) {
+	e := x.(*scheduledRule)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *ruleQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return e
+}
+
+// RuleScheduler evaluates the alert rules on their interval against the
+// latest sample of each series, instead of against every batch received.
+// At most group_concurrency rules of a group are evaluated at once, and
+// evaluations that cannot run in their slot are counted as missed.
+type RuleScheduler struct {
+	alerts      *AlertManager
+	interval    time.Duration
+	jitter      time.Duration
+	concurrency int
+	logger      *zap.Logger
+	// metrics records evaluations and missed evaluations
+	metrics *telemetry.Metrics
+
+	// changed is signalled when the rules change
+	changed chan struct{}
+	stop    <-chan struct{}
+
+	mu sync.RWMutex
+	// latest holds the latest sample of each series of the metrics the
+	// rules use, by metric name and by node and series
+	latest  map[string]map[string]*latestSample
+	watched map[string]bool
+
+	// The schedule is only used by Run
+	queue  ruleQueue
+	rules  map[string]*scheduledRule
+	groups map[string]chan struct{}
+}
+
+// NewRuleScheduler creates a scheduler of the alert manager's rules
+func NewRuleScheduler(config *utils.Config, alerts *AlertManager, logger *zap.Logger) *RuleScheduler {
+	return &RuleScheduler{
+		alerts:      alerts,
+		interval:    config.Alerting.EvaluationInterval,
+		jitter:      config.Alerting.EvaluationJitter,
+		concurrency: config.Alerting.GroupConcurrency,
+		logger:      logger,
+		changed:     make(chan struct{}, 1),
+		latest:      make(map[string]map[string]*latestSample),
+		watched:     make(map[string]bool),
+		rules:       make(map[string]*scheduledRule),
+		groups:      make(map[string]chan struct{}),
+	}
+}
+
+// observe keeps the latest sample of each series a rule uses
+func (rs *RuleScheduler) observe(nodeID string, metrics []*models.Metric) {
+	now := time.Now()
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, metric := range metrics {
+		if !rs.watched[metric.Name] {
+			continue
+		}
+		series := rs.latest[metric.Name]
+		if series == nil {
+			series = make(map[string]*latestSample)
+			rs.latest[metric.Name] = series
+		}
+		// Metrics are reused once the batch is handled, so only their
+		// values are kept
+		key := nodeID + "\x00" + (&models.Series{Name: metric.Name, Labels: metric.Labels}).String()
+		sample := series[key]
+		if sample == nil {
+			sample = &latestSample{nodeID: nodeID}
+			series[key] = sample
+		}
+		sample.labels = metric.Labels
+		sample.value = metric.Value
+		sample.timestamp = metric.Timestamp
+		sample.received = now
+	}
+}
+
+// notify tells the scheduler the rules changed
+func (rs *RuleScheduler) notify() {
+	if rs == nil {
+		return
+	}
+	select {
+	case rs.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run evaluates the rules on schedule until stop is closed
+func (rs *RuleScheduler) Run(stop <-chan struct{}) {
+	rs.stop = stop
+	rs.sync(time.Now())
+	rs.logger.Info("Scheduled alert rule evaluation",
+		zap.Duration("interval", rs.interval),
+		zap.Int("rules", len(rs.rules)),
+	)
+
+	prune := time.NewTicker(staleSample)
+	defer prune.Stop()
+	for {
+		var (
+			timer *time.Timer
+			wait  <-chan time.Time
+		)
+		if len(rs.queue) > 0 {
+			timer = time.NewTimer(time.Until(rs.queue[0].runAt))
+			wait = timer.C
+		}
+
+		select {
+		case <-stop:
+		case <-rs.changed:
+			rs.sync(time.Now())
+		case <-prune.C:
+			rs.prune(time.Now())
+		case <-wait:
+			rs.runDue(time.Now())
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// sync schedules new and changed rules and drops deleted and disabled ones.
+// Unchanged rules keep their slot.
+func (rs *RuleScheduler) sync(now time.Time) {
+	rs.alerts.rulesMu.RLock()
+	rules := make(map[string]*AlertRule, len(rs.alerts.rules))
+	for name, rule := range rs.alerts.rules {
+		if rule.Enabled {
+			rules[name] = rule
+		}
+	}
+	rs.alerts.rulesMu.RUnlock()
+
+	watched := make(map[string]bool)
+	for name, rule := range rules {
+		watched[rule.MetricName] = true
+
+		interval := rule.Interval
+		if interval == 0 {
+			interval = rs.interval
+		}
+		e, ok := rs.rules[name]
+		if ok && e.group == rule.Group && e.interval == interval {
+			continue
+		}
+		if ok {
+			heap.Remove(&rs.queue, e.index)
+		}
+		e = &scheduledRule{name: name, group: rule.Group, interval: interval}
+		e.due = now.Truncate(interval).Add(ruleOffset(rule.Group, name, interval))
+		if e.due.Before(now) {
+			e.due = e.due.Add(interval)
+		}
+		e.runAt = e.due.Add(rs.jitterOf(e))
+		rs.rules[name] = e
+		heap.Push(&rs.queue, e)
+	}
+	for name, e := range rs.rules {
+		if rules[name] == nil {
+			heap.Remove(&rs.queue, e.index)
+			delete(rs.rules, name)
+		}
+	}
+
+	rs.mu.Lock()
+	rs.watched = watched
+	for name := range rs.latest {
+		if !watched[name] {
+			delete(rs.latest, name)
+		}
+	}
+	rs.mu.Unlock()
+}
+
+// ruleOffset returns a rule's slot in its interval
+func ruleOffset(group, name string, interval time.Duration) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(group))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// jitterOf returns a random delay of a rule's next evaluation, at most
+// half its interval so evaluations keep their order
+func (rs *RuleScheduler) jitterOf(e *scheduledRule) time.Duration {
+	jitter := min(rs.jitter, e.interval/2)
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(jitter)))
+}
+
+// runDue starts the evaluations due by now and schedules the next ones
+func (rs *RuleScheduler) runDue(now time.Time) {
+	for len(rs.queue) > 0 && !rs.queue[0].runAt.After(now) {
+		e := rs.queue[0]
+		if late := now.Sub(e.due); late >= e.interval {
+			skipped := int(late / e.interval)
+			rs.metrics.MissedRuleEvaluations(e.group, missedLate, skipped)
+			rs.logger.Debug("Skipped late rule evaluations",
+				zap.String("rule", e.name),
+				zap.Int("skipped", skipped),
+			)
+			e.due = e.due.Add(time.Duration(skipped) * e.interval)
+		}
+		rs.dispatch(e)
+
+		e.due = e.due.Add(e.interval)
+		e.runAt = e.due.Add(rs.jitterOf(e))
+		heap.Fix(&rs.queue, 0)
+	}
+}
+
+// dispatch evaluates a rule in its current slot once its group has room
+func (rs *RuleScheduler) dispatch(e *scheduledRule) {
+	if !e.running.CompareAndSwap(false, true) {
+		rs.metrics.MissedRuleEvaluations(e.group, missedOverrun, 1)
+		return
+	}
+	sem := rs.groups[e.group]
+	if sem == nil {
+		sem = make(chan struct{}, rs.concurrency)
+		rs.groups[e.group] = sem
+	}
+
+	due, name, group, interval := e.due, e.name, e.group, e.interval
+	go func() {
+		defer e.running.Store(false)
+		select {
+		case sem <- struct{}{}:
+		case <-rs.stop:
+			return
+		}
+		defer func() { <-sem }()
+
+		start := time.Now()
+		if start.Sub(due) >= interval {
+			rs.metrics.MissedRuleEvaluations(group, missedQueued, 1)
+			return
+		}
+		rule := rs.alerts.rule(name)
+		if rule == nil {
+			return
+		}
+		n := rs.evaluate(rule, start)
+		rs.metrics.ObserveRuleEvaluation(group, n, start.Sub(due), start)
+	}()
+}
+
+// evaluate checks the latest sample of each series of a rule and returns
+// how many were checked
+func (rs *RuleScheduler) evaluate(rule *AlertRule, now time.Time) int {
+	rs.mu.RLock()
+	var samples []latestSample
+	for _, sample := range rs.latest[rule.MetricName] {
+		if now.Sub(sample.received) < staleSample && matchesAll(rule.Matchers, sample.labels) {
+			samples = append(samples, *sample)
+		}
+	}
+	rs.mu.RUnlock()
+
+	am := rs.alerts
+	for _, sample := range samples {
+		if am.nodes != nil && am.nodes.IsRetiring(sample.nodeID) {
+			continue
+		}
+		if am.evaluateRule(rule, sample.value) {
+			am.fireAlert(sample.nodeID, rule, &models.Metric{
+				NodeID:    sample.nodeID,
+				Name:      rule.MetricName,
+				Value:     sample.value,
+				Timestamp: sample.timestamp,
+				Labels:    sample.labels,
+			})
+		} else {
+			am.resolveAlert(sample.nodeID, rule.Name)
+		}
+	}
+	return len(samples)
+}
+
+// prune drops the series that stopped reporting
+func (rs *RuleScheduler) prune(now time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for name, series := range rs.latest {
+		for key, sample := range series {
+			if now.Sub(sample.received) >= staleSample {
+				delete(series, key)
+			}
+		}
+		if len(series) == 0 {
+			delete(rs.latest, name)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ruleExprPattern matches the expressions the alert engine evaluates: a
+// metric, optionally with label matchers, compared to a number
+var ruleExprPattern = regexp.MustCompile(`^\s*([a-zA-Z_:][a-zA-Z0-9_:]*)\s*(?:\{(.*)\})?\s*(>=|<=|==|!=|>|<)\s*(\S+)\s*$`)
+
+// RuleError is an invalid rule in a rule file
+type RuleError struct {
+	File string
+	Line int
+	Rule string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	if e.Rule == "" {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: rule %s: %v", e.File, e.Line, e.Rule, e.Err)
+}
+
+// ruleGroupFile is the layout of a rule file, as used by Prometheus
+type ruleGroupFile struct {
+	Groups []struct {
+		Name string `yaml:"name"`
+		// Interval overrides alerting.evaluation_interval for the group's
+		// rules when evaluation is scheduled
+		Interval string      `yaml:"interval"`
+		Rules    []yaml.Node `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+// ruleDefinition is one rule of a rule file. Name is accepted as an alias
+// of alert.
+type ruleDefinition struct {
+	Alert       string            `yaml:"alert"`
+	Name        string            `yaml:"name"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// ruleFiles returns the rule files a rules path selects: the file itself,
+// the YAML files of a directory, or the matches of a glob pattern
+func ruleFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && !info.IsDir():
+		return []string{path}, nil
+	case err == nil:
+		var files []string
+		for _, ext := range []string{"*.yaml", "*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(path, ext))
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	files, globErr := filepath.Glob(path)
+	if globErr != nil {
+		return nil, fmt.Errorf("invalid rules path %s: %w", path, globErr)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no rule files found at %s", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// loadRuleFiles reads every rule file under path. Files that cannot be read
+// or parsed fail the load; invalid rules are returned as RuleErrors and
+// left out.
+func loadRuleFiles(path string) ([]*AlertRule, []error, error) {
+	files, err := ruleFiles(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		rules   []*AlertRule
+		invalid []error
+	)
+	seen := make(map[string]string)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		var content ruleGroupFile
+		if err := yaml.Unmarshal(data, &content); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		for _, group := range content.Groups {
+			var interval time.Duration
+			if group.Interval != "" {
+				interval, err = time.ParseDuration(group.Interval)
+				if err != nil || interval <= 0 {
+					return nil, nil, fmt.Errorf("%s: group %s: invalid interval %q", file, group.Name, group.Interval)
+				}
+			}
+			for i := range group.Rules {
+				node := &group.Rules[i]
+				rule, err := parseRule(node)
+				if err != nil {
+					invalid = append(invalid, &RuleError{File: file, Line: node.Line, Rule: ruleName(node), Err: err})
+					continue
+				}
+				if previous, ok := seen[rule.Name]; ok {
+					invalid = append(invalid, &RuleError{File: file, Line: node.Line, Rule: rule.Name,
+						Err: fmt.Errorf("duplicate rule, first defined at %s", previous)})
+					continue
+				}
+				seen[rule.Name] = fmt.Sprintf("%s:%d", file, node.Line)
+				rule.Group = group.Name
+				rule.Interval = interval
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, invalid, nil
+}
+
+// ruleName returns the name of a rule node for error messages, even when
+// the rule does not decode
+func ruleName(node *yaml.Node) string {
+	var def ruleDefinition
+	node.Decode(&def)
+	if def.Alert != "" {
+		return def.Alert
+	}
+	return def.Name
+}
+
+// parseRule validates a rule of a rule file
+func parseRule(node *yaml.Node) (*AlertRule, error) {
+	var def ruleDefinition
+	if err := node.Decode(&def); err != nil {
+		return nil, err
+	}
+
+	name := def.Alert
+	if name == "" {
+		name = def.Name
+	}
+	if name == "" {
+		return nil, fmt.Errorf("alert name is required")
+	}
+	if def.Expr == "" {
+		return nil, fmt.Errorf("expr is required")
+	}
+
+	rule, err := parseRuleExpr(def.Expr)
+	if err != nil {
+		return nil, err
+	}
+	rule.Name = name
+	rule.Labels = copyLabels(def.Labels)
+	rule.Annotations = def.Annotations
+	rule.Severity = def.Labels["severity"]
+	rule.Enabled = true
+
+	if def.For != "" {
+		d, err := time.ParseDuration(def.For)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid for duration %q", def.For)
+		}
+		rule.For = d
+	}
+	return rule, nil
+}
+
+// parseRuleExpr parses an expression of the form
+// metric{label="value", ...} > threshold
+func parseRuleExpr(expr string) (*AlertRule, error) {
+	m := ruleExprPattern.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("unsupported expression %q: expected metric{labels} <op> <number>", expr)
+	}
+
+	threshold, err := strconv.ParseFloat(m[4], 64)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported expression %q: threshold must be a number", expr)
+	}
+
+	var matchers []*utils.Matcher
+	if strings.TrimSpace(m[2]) != "" {
+		for _, spec := range splitMatchers(m[2]) {
+			matcher, err := utils.ParseMatcher(spec)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, matcher)
+		}
+	}
+
+	return &AlertRule{
+		Expression: strings.TrimSpace(expr),
+		MetricName: m[1],
+		Matchers:   matchers,
+		Operator:   m[3],
+		Threshold:  threshold,
+	}, nil
+}
+
+// splitMatchers splits a label matcher list on the commas outside quoted
+// values
+func splitMatchers(s string) []string {
+	var (
+		parts   []string
+		start   int
+		quoted  bool
+		escaped bool
+	)
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if last := s[start:]; strings.TrimSpace(last) != "" {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+// rulesSignature identifies the current contents of the rule files by
+// their names, sizes and modification times
+func rulesSignature(path string) string {
+	files, _ := ruleFiles(path)
+	var b strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&b, "%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
+
+// ReloadRules replaces the rules loaded from the rule files with their
+// current contents. If a file cannot be read or parsed the current rules
+// are kept; invalid rules are logged and skipped.
+func (am *AlertManager) ReloadRules() error {
+	am.reloadMu.Lock()
+	defer am.reloadMu.Unlock()
+	return am.reloadRules()
+}
+
+// reloadRules loads the rule files. The caller holds reloadMu.
+func (am *AlertManager) reloadRules() error {
+	path := am.rulesPath
+	if path == "" {
+		return nil
+	}
+
+	// A broken file is reported once, not on every check until it is fixed
+	am.rulesSignature = rulesSignature(path)
+	rules, invalid, err := loadRuleFiles(path)
+	if err != nil {
+		return fmt.Errorf("failed to load alert rules: %w", err)
+	}
+	for _, err := range invalid {
+		am.logger.Error("Invalid alert rule", zap.Error(err))
+	}
+
+	removed := am.replaceFileRules(rules)
+	am.logger.Info("Loaded alert rules",
+		zap.String("path", path),
+		zap.Int("count", len(rules)),
+		zap.Int("invalid", len(invalid)),
+		zap.Int("removed", removed),
+	)
+	return nil
+}
+
+// replaceFileRules replaces the rules loaded from the rule files, or the
+// default rules, with rules and returns how many rules were removed. A
+// rule named like one added with AddRule is rejected, as removing it from
+// the files would delete the other rule.
+func (am *AlertManager) replaceFileRules(rules []*AlertRule) int {
+	loaded := make(map[string]bool, len(rules))
+	var conflicts []string
+	am.rulesMu.Lock()
+	for _, rule := range rules {
+		if _, exists := am.rules[rule.Name]; exists && !am.fileRules[rule.Name] {
+			conflicts = append(conflicts, rule.Name)
+			continue
+		}
+		am.rules[rule.Name] = rule
+		loaded[rule.Name] = true
+	}
+	var removed []string
+	for name := range am.fileRules {
+		if !loaded[name] {
+			delete(am.rules, name)
+			removed = append(removed, name)
+		}
+	}
+	am.fileRules = loaded
+	am.rulesMu.Unlock()
+	am.scheduler.notify()
+
+	for _, name := range conflicts {
+		am.logger.Error("Ignoring alert rule named like a rule not loaded from rule files", zap.String("rule", name))
+	}
+
+	// Alerts of deleted rules would otherwise never resolve
+	for _, name := range removed {
+		am.resolveRule(name)
+	}
+	return len(removed)
+}
+
+// SetRulesPath loads the rules from the rule files at another path, or
+// the default rules if it is empty. If the files cannot be loaded, the
+// current rules and path are kept.
+func (am *AlertManager) SetRulesPath(path string) error {
+	am.reloadMu.Lock()
+	defer am.reloadMu.Unlock()
+	if path == am.rulesPath {
+		return nil
+	}
+
+	previous := am.rulesPath
+	am.rulesPath = path
+	if path == "" {
+		am.loadDefaultRules()
+		return nil
+	}
+	if err := am.reloadRules(); err != nil {
+		am.rulesPath = previous
+		return err
+	}
+	return nil
+}
+
+// WatchRules reloads the rule files whenever they change, until stop is
+// closed
+func (am *AlertManager) WatchRules(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			am.reloadMu.Lock()
+			changed := am.rulesPath != "" && rulesSignature(am.rulesPath) != am.rulesSignature
+			am.reloadMu.Unlock()
+			if !changed {
+				continue
+			}
+			if err := am.ReloadRules(); err != nil {
+				am.logger.Error("Failed to reload alert rules", zap.Error(err))
+			}
+		}
+	}
+}
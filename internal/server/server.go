@@ -2,12 +2,17 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/meettoy2004/lnmonja/internal/models"
 	"github.com/meettoy2004/lnmonja/internal/server/api"
 	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/internal/telemetry"
+	"github.com/meettoy2004/lnmonja/pkg/i18n"
 	"github.com/meettoy2004/lnmonja/pkg/utils"
 	"go.uber.org/zap"
 )
@@ -19,34 +24,267 @@ type Server struct {
 	store     storage.Storage
 	grpc      *GRPCServer
 	http      *http.Server
+	restAPI   *api.RESTAPI
 	websocket *api.WebSocketServer
+	wsHTTP    *http.Server
 	nodeMgr   *NodeManager
 	alertMgr  *AlertManager
+	derived   *DerivedMetrics
+	exporter  *Exporter
+	decom     *Decommissioner
+	kube      *KubeController
+	// demo simulates nodes in demo mode
+	demo *DemoGenerator
+	// metering records tenant usage, if enabled
+	metering *Metering
+	// trends aggregates monthly node trends, if enabled
+	trends *Trends
+	// reloader applies changes to the configuration file
+	reloader *ConfigReloader
+	// metrics are the server's self-metrics, served on /metrics
+	metrics *telemetry.Metrics
+	// stop ends background jobs
+	stop chan struct{}
+
+	// httpListener and wsListener are opened by Listen
+	httpListener net.Listener
+	wsListener   net.Listener
+	// allowed are the allowlists of the listeners, by name
+	allowed map[string]*utils.Allowlist
+	// httpCerts is set when HTTP TLS is enabled
+	httpCerts *certReloader
 }
 
 // NewServer creates a new server instance
 func NewServer(config *utils.Config, store storage.Storage, logger *zap.Logger) (*Server, error) {
 	s := &Server{
-		config: config,
-		logger: logger,
-		store:  store,
+		config:  config,
+		logger:  logger,
+		store:   store,
+		metrics: telemetry.New(),
+		stop:    make(chan struct{}),
+	}
+
+	if config.TLS.Strict && (!config.Server.GRPC.TLS.Enabled || !config.Server.HTTP.TLS.Enabled) {
+		return nil, fmt.Errorf("strict TLS mode needs TLS enabled on the gRPC and HTTP listeners")
+	}
+
+	// Restrict each listener to its allowed networks
+	s.allowed = make(map[string]*utils.Allowlist)
+	for name, networks := range map[string][]string{
+		"grpc":      config.Server.GRPC.AllowedNetworks,
+		"http":      config.Server.HTTP.AllowedNetworks,
+		"websocket": config.Server.WebSocket.AllowedNetworks,
+	} {
+		allowed, err := utils.NewAllowlist(networks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s allowed networks: %w", name, err)
+		}
+		s.allowed[name] = allowed
 	}
 
 	// Initialize node manager
 	s.nodeMgr = NewNodeManager(store, logger)
+	s.nodeMgr.hooks = NewLifecycleHooks(config.Lifecycle.Webhooks, logger)
+
+	// Load derived metric definitions
+	derived, err := NewDerivedMetrics(store, logger)
+	if err != nil {
+		return nil, err
+	}
+	s.derived = derived
+
+	// Load the ingest rules applied to received metrics
+	ingestRules, err := NewIngestRules(store, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject metric names nodes may not send
+	filters, err := NewMetricFilters(config.Server.Ingest.MetricFilters, logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metric filters: %w", err)
+	}
+	filters.metrics = s.metrics
+
+	// Report metrics that break the naming conventions
+	var naming *NamingChecker
+	if config.Naming.Enabled {
+		if naming, err = NewNamingChecker(&config.Naming, logger); err != nil {
+			return nil, fmt.Errorf("invalid naming conventions: %w", err)
+		}
+	}
 
 	// Initialize alert manager
 	s.alertMgr = NewAlertManager(config, store, logger)
+	s.alertMgr.derived = derived
+	s.alertMgr.nodes = s.nodeMgr
+	s.alertMgr.metrics = s.metrics
+	if config.Alerting.EvaluationInterval > 0 && !s.readOnly() {
+		s.alertMgr.scheduler = NewRuleScheduler(config, s.alertMgr, logger)
+		s.alertMgr.scheduler.metrics = s.metrics
+	}
+	// Messages of notifications and the dashboard, by locale
+	catalog, err := i18n.Load(config.I18n.DefaultLocale, config.I18n.LocalesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load locales: %w", err)
+	}
+	dispatcher, err := NewDispatcher(config, catalog, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create alert dispatcher: %w", err)
+	}
+	s.alertMgr.dispatcher = dispatcher
+	silences, err := NewSilencer(store, config.Alerting.SilenceRetention, logger)
+	if err != nil {
+		return nil, err
+	}
+	s.alertMgr.silences = silences
+
+	// Dashboards saved through the API, with their versions
+	dashboards, err := NewDashboards(store, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retire nodes on request
+	s.decom = NewDecommissioner(&config.Lifecycle, store, s.nodeMgr, s.alertMgr, logger)
 
 	// Initialize gRPC server
-	grpcServer, err := NewGRPCServer(config, store, logger)
+	grpcServer, err := NewGRPCServer(config, store, s.nodeMgr, s.alertMgr, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create gRPC server: %w", err)
 	}
 	s.grpc = grpcServer
+	s.reloader = NewConfigReloader(config, s.alertMgr, grpcServer, logger)
+	grpcServer.decom = s.decom
+	grpcServer.rules = ingestRules
+	grpcServer.stop = s.stop
+	grpcServer.metrics = s.metrics
+	s.metrics.GaugeFunc("memory_limit_bytes", "Soft memory limit of the Go runtime, 0 without one.", func() float64 {
+		return float64(utils.MemoryLimit())
+	})
+	s.metrics.GaugeFunc("label_symbols", "Distinct label names and values interned in memory.", func() float64 {
+		return float64(utils.Symbols.Len())
+	})
+	s.metrics.GaugeFunc("agent_sessions", "Agents with a registered session.", func() float64 {
+		return float64(grpcServer.SessionCount())
+	})
 
-	// Initialize WebSocket server
-	s.websocket = api.NewWebSocketServer(store, logger)
+	// Shed metrics by priority when batches arrive faster than they are
+	// stored
+	if grpcServer.overload = NewOverloadGuard(config, logger); grpcServer.overload != nil {
+		grpcServer.overload.metrics = s.metrics
+		s.metrics.GaugeFunc("ingest_overload_level", "Ingestion overload: 0 normal, 1 elevated, 2 overloaded.", func() float64 {
+			return float64(grpcServer.overload.Level())
+		})
+	}
+
+	// Track ingestion volume per node and metric
+	ingest := NewIngestStats()
+	if config.Metering.Enabled {
+		// A replica reports the usage the primary records
+		s.metering = NewMetering(&config.Metering, store, logger)
+		s.metering.readOnly = s.readOnly()
+		ingest.meter = s.metering
+	}
+	if config.Trends.Enabled {
+		// A replica reports the trends the primary aggregates
+		s.trends = NewTrends(&config.Trends, store, logger)
+		s.trends.readOnly = s.readOnly()
+		if s.trends.reports, err = newTrendReports(config.Trends.Reports, catalog); err != nil {
+			return nil, fmt.Errorf("failed to create trend reports: %w", err)
+		}
+		ingest.trends = s.trends
+		s.alertMgr.trends = s.trends
+	}
+	grpcServer.ingest = ingest
+	filters.ingest = ingest
+	grpcServer.filters = filters
+	grpcServer.naming = naming
+
+	// Receive OpenTelemetry metrics through the same ingest path
+	var otlp *OTLPReceiver
+	if config.Server.OTLP.Enabled {
+		otlp = NewOTLPReceiver(&config.Server.OTLP, store, s.nodeMgr, s.alertMgr, logger)
+		otlp.rules = ingestRules
+		otlp.ingest = ingest
+		otlp.metrics = s.metrics
+		otlp.filters = filters
+		otlp.naming = naming
+		grpcServer.otlp = otlp
+	}
+
+	// Initialize WebSocket server, on its own port unless only served on
+	// the HTTP port
+	s.websocket = api.NewWebSocketServer(config, store, logger)
+	s.websocket.SetAllowlist(s.allowed["websocket"])
+	s.metrics.GaugeFunc("websocket_clients", "Connected WebSocket and Server-Sent Events clients.", func() float64 {
+		return float64(s.websocket.GetConnectedClients())
+	})
+
+	// Publish stored metrics, alert transitions and node status changes
+	// to live clients
+	grpcServer.live = s.websocket
+	s.alertMgr.live = s.websocket
+	s.nodeMgr.live = s.websocket
+	if otlp != nil {
+		otlp.live = s.websocket
+	}
+
+	// Simulated nodes report through the same ingest path in demo mode
+	if config.Demo.Enabled && !s.readOnly() {
+		s.demo = NewDemoGenerator(&config.Demo, store, s.nodeMgr, s.alertMgr, logger)
+		s.demo.ingest = ingest
+		s.demo.metrics = s.metrics
+		s.demo.live = s.websocket
+	}
+	if config.Server.WebSocket.Mode != utils.WebSocketModeHTTP {
+		mux := http.NewServeMux()
+		mux.Handle("/ws", s.websocket)
+		s.wsHTTP = &http.Server{
+			Addr:    fmt.Sprintf("%s:%d", config.Server.WebSocket.Address, config.Server.WebSocket.Port),
+			Handler: mux,
+		}
+	}
+
+	// Watch custom resources when running as a Kubernetes controller
+	if config.Kubernetes.Enabled {
+		controller, err := NewKubeController(&config.Kubernetes, store, s.alertMgr, grpcServer, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kubernetes controller: %w", err)
+		}
+		s.kube = controller
+	}
+
+	// Initialize REST API
+	usage := NewUsageTracker(store, derived, s.alertMgr, config.Exports)
+	rest := newRESTStore(store, derived, usage, ingest, grpcServer, s.decom)
+	rest.kube = s.kube
+	rest.dashboards = dashboards
+	rest.alerts = s.alertMgr
+	rest.ingestRules = ingestRules
+	rest.otlp = otlp
+	rest.naming = naming
+	rest.metrics = s.metrics
+	rest.reloader = s.reloader
+	rest.metering = s.metering
+	rest.trends = s.trends
+	s.restAPI = api.NewRESTAPI(config, rest, logger)
+	s.restAPI.SetMetrics(s.metrics)
+	s.restAPI.SetCatalog(catalog)
+	s.restAPI.SetWebSocket(s.websocket, config.Server.WebSocket.Mode != utils.WebSocketModeStandalone)
+
+	// A read replica picks up the changes the primary replicates
+	if s.readOnly() {
+		go s.reloadReplica(dashboards, derived, silences)
+	}
+
+	// Initialize scheduled exports
+	exporter, err := NewExporter(config.Exports, rest, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter: %w", err)
+	}
+	s.exporter = exporter
 
 	// Initialize HTTP server
 	s.http = &http.Server{
@@ -56,43 +294,173 @@ func NewServer(config *utils.Config, store storage.Storage, logger *zap.Logger)
 		WriteTimeout: 10 * time.Second,
 	}
 
+	// Serve HTTP and the standalone WebSocket listener over TLS, with the
+	// certificate reloaded when renewed
+	if config.Server.HTTP.TLS.Enabled {
+		certs, err := newCertReloader(config.Server.HTTP.TLS.CertFile, config.Server.HTTP.TLS.KeyFile, "", logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load HTTP TLS certificate: %w", err)
+		}
+		s.httpCerts = certs
+		go certs.watch(s.stop)
+
+		if s.http.TLSConfig, err = certs.tlsConfig(tls.NoClientCert, &config.TLS); err != nil {
+			return nil, fmt.Errorf("invalid TLS policy: %w", err)
+		}
+		if s.wsHTTP != nil {
+			// ServeTLS clones the config, so the servers can share it
+			s.wsHTTP.TLSConfig = s.http.TLSConfig
+		}
+	}
+
 	return s, nil
 }
 
-// StartGRPC starts the gRPC server
+// Listen opens the gRPC, HTTP and WebSocket listeners, so the server
+// accepts connections before it reports itself ready. Sockets passed by
+// systemd socket activation, named grpc, http and websocket, are used
+// instead of listening on the configured addresses. Each listener only
+// accepts connections from its allowed networks.
+func (s *Server) Listen(activated map[string]net.Listener) (err error) {
+	defer func() {
+		if err != nil {
+			for _, l := range []net.Listener{s.grpc.listener, s.httpListener, s.wsListener} {
+				if l != nil {
+					l.Close()
+				}
+			}
+		}
+	}()
+
+	listen := func(name, addr string) (net.Listener, error) {
+		if l, ok := activated[name]; ok {
+			delete(activated, name)
+			s.logger.Info("Using socket passed by systemd",
+				zap.String("name", name),
+				zap.String("addr", l.Addr().String()),
+			)
+			return restrict(l, name, s.allowed[name], s.logger), nil
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for %s on %s: %w", name, addr, err)
+		}
+		return restrict(l, name, s.allowed[name], s.logger), nil
+	}
+
+	grpcAddr := fmt.Sprintf("%s:%d", s.config.Server.GRPC.Address, s.config.Server.GRPC.Port)
+	// A read replica takes no agents, and a socket passed for them is
+	// closed below
+	if !s.readOnly() {
+		if s.grpc.listener, err = listen("grpc", grpcAddr); err != nil {
+			return err
+		}
+	}
+	if s.httpListener, err = listen("http", s.http.Addr); err != nil {
+		return err
+	}
+	if s.wsHTTP != nil {
+		if s.wsListener, err = listen("websocket", s.wsHTTP.Addr); err != nil {
+			return err
+		}
+	}
+
+	for name, l := range activated {
+		s.logger.Warn("Ignoring unknown socket passed by systemd",
+			zap.String("name", name),
+			zap.String("addr", l.Addr().String()),
+		)
+		l.Close()
+	}
+	return nil
+}
+
+// StartGRPC starts the gRPC server, unless the server is a read replica
 func (s *Server) StartGRPC() error {
+	if s.readOnly() {
+		s.logger.Info("Serving as a read replica; not accepting agents")
+		return nil
+	}
 	return s.grpc.Start()
 }
 
 // StartHTTP starts the HTTP server
 func (s *Server) StartHTTP() error {
-	s.logger.Info("Starting HTTP server", zap.String("addr", s.http.Addr))
-	return s.http.ListenAndServe()
+	return s.serve(s.http, s.httpListener, "HTTP")
 }
 
-// StartWebSocket starts the WebSocket server
+// StartWebSocket starts the legacy standalone WebSocket listener. It
+// returns immediately when /ws is only served on the HTTP port.
 func (s *Server) StartWebSocket() error {
-	addr := fmt.Sprintf("%s:%d", s.config.Server.WebSocket.Address, s.config.Server.WebSocket.Port)
+	if s.wsHTTP == nil {
+		s.logger.Info("Serving WebSocket on the HTTP server", zap.String("addr", s.http.Addr))
+		return nil
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		s.websocket.ServeHTTP(w, r)
-	})
+	return s.serve(s.wsHTTP, s.wsListener, "WebSocket")
+}
 
-	server := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+// serve runs an HTTP server on its listener, or its address when Listen
+// was not called, over TLS when it has a TLS config
+func (s *Server) serve(srv *http.Server, listener net.Listener, name string) error {
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", srv.Addr); err != nil {
+			return err
+		}
 	}
 
-	s.logger.Info("Starting WebSocket server", zap.String("addr", addr))
-	return server.ListenAndServe()
+	s.logger.Info("Starting "+name+" server",
+		zap.String("addr", listener.Addr().String()),
+		zap.Bool("tls", srv.TLSConfig != nil),
+	)
+	if srv.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate
+		return srv.ServeTLS(listener, "", "")
+	}
+	return srv.Serve(listener)
 }
 
 // StartAlertEngine starts the alert engine
 func (s *Server) StartAlertEngine() {
+	// The primary evaluates alerts
+	if s.readOnly() {
+		return
+	}
 	s.logger.Info("Starting alert engine")
-	// The alert engine is event-driven and doesn't need a separate goroutine
-	// It will be called by the gRPC server when metrics are received
+	// Without an evaluation interval the alert engine is event-driven: the
+	// gRPC server calls it as metrics are received. Otherwise the rules
+	// are evaluated on schedule against the latest samples.
+	if s.alertMgr.scheduler != nil {
+		go s.alertMgr.scheduler.Run(s.stop)
+	}
+	go s.alertMgr.WatchSilences(silenceCheckInterval, s.stop)
+	s.alertMgr.WatchRules(s.config.Alerting.RulesReloadInterval, s.stop)
+}
+
+// ReloadRules reloads the alert rule files
+func (s *Server) ReloadRules() error {
+	return s.alertMgr.ReloadRules()
+}
+
+// ReloadConfig re-reads the configuration file and applies the settings
+// that can change while the server runs
+func (s *Server) ReloadConfig() (*models.ConfigReload, error) {
+	return s.reloader.Reload()
+}
+
+// ReloadTLS reloads the gRPC and HTTP certificates, which are otherwise
+// reloaded when their files change
+func (s *Server) ReloadTLS() error {
+	for _, certs := range []*certReloader{s.grpc.certs, s.httpCerts} {
+		if certs == nil {
+			continue
+		}
+		if err := certs.Reload(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // StartRetentionJob starts the data retention job
@@ -101,8 +469,54 @@ func (s *Server) StartRetentionJob() {
 	// The retention job is handled by the TimeSeriesDB internally
 }
 
+// StartExports starts the scheduled query exports
+func (s *Server) StartExports() {
+	if len(s.config.Exports) == 0 || s.readOnly() {
+		return
+	}
+	s.exporter.Start()
+}
+
+// StartKubeController starts watching custom resources, if enabled
+func (s *Server) StartKubeController() {
+	if s.kube == nil || s.readOnly() {
+		return
+	}
+	s.kube.Start()
+}
+
+// StartDemo starts the simulated nodes, in demo mode
+func (s *Server) StartDemo() {
+	if s.demo == nil {
+		return
+	}
+	s.demo.Run(s.stop)
+}
+
+// StartMetering starts flushing tenant usage, if metering is enabled
+func (s *Server) StartMetering() {
+	if s.metering == nil || s.readOnly() {
+		return
+	}
+	s.metering.Run(s.stop)
+}
+
+// StartTrends starts flushing monthly trends and emailing trend reports,
+// if trends are enabled
+func (s *Server) StartTrends() {
+	if s.trends == nil || s.readOnly() {
+		return
+	}
+	go s.trends.RunReports(s.stop)
+	s.trends.Run(s.stop)
+}
+
 // StartHealthCheck starts the health check routine
 func (s *Server) StartHealthCheck() {
+	// Nodes report to the primary, which tracks their health
+	if s.readOnly() {
+		return
+	}
 	s.logger.Info("Starting health check")
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -115,19 +529,63 @@ func (s *Server) StartHealthCheck() {
 				timeout = 90 * time.Second
 			}
 			s.nodeMgr.CheckHealth(timeout)
+
+			// Finish decommissioning nodes past their grace period
+			s.decom.Run(time.Now())
 		}
 	}()
 }
 
+// readOnly reports whether the server is a read replica, serving the API
+// from storage replicated by a primary
+func (s *Server) readOnly() bool {
+	return s.config.Storage.Replication.Role == utils.ReplicationRoleReplica
+}
+
+// reloadReplica reloads the definitions kept in memory every metadata
+// interval, as the primary replicates them to storage
+func (s *Server) reloadReplica(dashboards *Dashboards, derived *DerivedMetrics, silences *Silencer) {
+	ticker := time.NewTicker(s.config.Storage.Replication.MetadataInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			for _, reload := range []func() error{dashboards.Reload, derived.Reload, silences.Reload} {
+				if err := reload(); err != nil {
+					s.logger.Warn("Failed to reload replicated definitions", zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.Info("Shutting down server...")
 
+	close(s.stop)
+
 	// Stop gRPC server
 	if s.grpc != nil {
 		s.grpc.Stop()
 	}
 
+	// Stop live update clients first, as their streams keep HTTP
+	// requests open
+	if s.wsHTTP != nil {
+		if err := s.wsHTTP.Shutdown(ctx); err != nil {
+			s.logger.Error("Failed to shutdown WebSocket listener", zap.Error(err))
+		}
+	}
+	if s.websocket != nil {
+		if err := s.websocket.Close(); err != nil {
+			s.logger.Error("Failed to close WebSocket server", zap.Error(err))
+		}
+	}
+
 	// Stop HTTP server
 	if s.http != nil {
 		if err := s.http.Shutdown(ctx); err != nil {
@@ -135,16 +593,47 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		}
 	}
 
-	// Stop WebSocket server
-	if s.websocket != nil {
-		if err := s.websocket.Close(); err != nil {
-			s.logger.Error("Failed to close WebSocket server", zap.Error(err))
-		}
+	// Stop scheduled exports
+	if s.exporter != nil {
+		s.exporter.Stop()
+	}
+
+	// Save the usage counted since the last flush
+	if s.metering != nil {
+		s.metering.Flush()
+	}
+
+	// Save the trends aggregated since the last flush
+	if s.trends != nil {
+		s.trends.Flush()
+	}
+
+	if s.kube != nil {
+		s.kube.Stop()
 	}
 
+	// Pending notifications are dropped
+	s.alertMgr.dispatcher.Stop()
+
 	return nil
 }
 
+// Alive checks that storage still answers, for the systemd watchdog
+func (s *Server) Alive(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.store.ListNodes()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("storage is not responding")
+	}
+}
+
 // setupHTTPRoutes sets up HTTP routes
 func (s *Server) setupHTTPRoutes() http.Handler {
 	mux := http.NewServeMux()
@@ -155,45 +644,11 @@ func (s *Server) setupHTTPRoutes() http.Handler {
 		w.Write([]byte(`{"status":"healthy"}`))
 	})
 
-	// Metrics endpoint (for Prometheus scraping)
-	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		// Placeholder for Prometheus metrics
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("# Prometheus metrics\n"))
-	})
+	// Server self-metrics, for Prometheus scraping
+	mux.Handle("/metrics", s.metrics.Handler())
 
-	// API endpoints
-	mux.HandleFunc("/api/v1/nodes", s.handleNodes)
-	mux.HandleFunc("/api/v1/alerts", s.handleAlerts)
-	mux.HandleFunc("/api/v1/query", s.handleQuery)
+	// REST API
+	mux.Handle("/", s.restAPI)
 
 	return mux
 }
-
-// HTTP handlers
-func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
-	nodes, err := s.store.ListNodes()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	// Marshal and write nodes
-	fmt.Fprintf(w, `{"nodes":%d}`, len(nodes))
-}
-
-func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
-	alerts := s.alertMgr.GetActiveAlerts()
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"alerts":%d}`, len(alerts))
-}
-
-func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok"}`))
-}
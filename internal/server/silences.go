@@ -0,0 +1,293 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// silenceCheckInterval is how often silences that started or ended are
+// applied to the active alerts
+const silenceCheckInterval = 10 * time.Second
+
+// Silencer keeps silences and their parsed matchers in memory and
+// persists them to storage
+type Silencer struct {
+	store     storage.Storage
+	logger    *zap.Logger
+	retention time.Duration
+	silences  map[string]*silence
+	mu        sync.RWMutex
+}
+
+type silence struct {
+	def      *models.Silence
+	matchers []*utils.Matcher
+}
+
+// NewSilencer creates the silence registry and loads stored silences.
+// Expired silences are deleted once they are older than retention.
+func NewSilencer(store storage.Storage, retention time.Duration, logger *zap.Logger) (*Silencer, error) {
+	s := &Silencer{
+		store:     store,
+		logger:    logger,
+		retention: retention,
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the silences with the stored ones
+func (s *Silencer) Reload() error {
+	defs, err := s.store.ListSilences()
+	if err != nil {
+		return fmt.Errorf("failed to load silences: %w", err)
+	}
+
+	silences := make(map[string]*silence, len(defs))
+	for _, def := range defs {
+		matchers, err := utils.ParseMatchers(def.Matchers)
+		if err != nil {
+			s.logger.Warn("Skipping invalid silence",
+				zap.String("id", def.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+		silences[def.ID] = &silence{def: def, matchers: matchers}
+	}
+
+	s.mu.Lock()
+	s.silences = silences
+	s.mu.Unlock()
+	return nil
+}
+
+// Create validates and stores a new silence. A silence without a start
+// time starts now.
+func (s *Silencer) Create(def *models.Silence) (*models.Silence, error) {
+	now := time.Now().UTC()
+	saved := *def
+	saved.ID = utils.GenerateSilenceID()
+	if saved.StartsAt.IsZero() {
+		saved.StartsAt = now
+	}
+	saved.CreatedAt = now
+	saved.UpdatedAt = now
+
+	matchers, err := validateSilence(&saved, now)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.SaveSilence(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save silence: %w", err)
+	}
+	s.silences[saved.ID] = &silence{def: &saved, matchers: matchers}
+
+	s.logger.Info("Silence created",
+		zap.String("id", saved.ID),
+		zap.Strings("matchers", saved.Matchers),
+		zap.Time("ends_at", saved.EndsAt),
+		zap.String("created_by", saved.CreatedBy),
+	)
+
+	return withStatus(&saved, now), nil
+}
+
+// Update replaces the matchers, time range and comment of a silence that
+// has not expired
+func (s *Silencer) Update(id string, def *models.Silence) (*models.Silence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.silences[id]
+	if !exists {
+		return nil, fmt.Errorf("silence %s not found", id)
+	}
+
+	now := time.Now().UTC()
+	if existing.def.StatusAt(now) == models.SilenceStatusExpired {
+		return nil, fmt.Errorf("silence %s has expired", id)
+	}
+
+	saved := *def
+	saved.ID = id
+	if saved.StartsAt.IsZero() {
+		saved.StartsAt = existing.def.StartsAt
+	}
+	if saved.CreatedBy == "" {
+		saved.CreatedBy = existing.def.CreatedBy
+	}
+	saved.CreatedAt = existing.def.CreatedAt
+	saved.UpdatedAt = now
+
+	matchers, err := validateSilence(&saved, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.SaveSilence(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save silence: %w", err)
+	}
+	s.silences[id] = &silence{def: &saved, matchers: matchers}
+
+	s.logger.Info("Silence updated",
+		zap.String("id", id),
+		zap.Strings("matchers", saved.Matchers),
+		zap.Time("ends_at", saved.EndsAt),
+	)
+
+	return withStatus(&saved, now), nil
+}
+
+// Expire ends a silence now. The silence is kept until it is older than
+// the retention, so it can still be looked up.
+func (s *Silencer) Expire(id string) (*models.Silence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.silences[id]
+	if !exists {
+		return nil, fmt.Errorf("silence %s not found", id)
+	}
+
+	now := time.Now().UTC()
+	if existing.def.StatusAt(now) == models.SilenceStatusExpired {
+		return withStatus(existing.def, now), nil
+	}
+
+	saved := *existing.def
+	if saved.StartsAt.After(now) {
+		saved.StartsAt = now
+	}
+	saved.EndsAt = now
+	saved.UpdatedAt = now
+
+	if err := s.store.SaveSilence(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save silence: %w", err)
+	}
+	s.silences[id] = &silence{def: &saved, matchers: existing.matchers}
+
+	s.logger.Info("Silence expired", zap.String("id", id))
+
+	return withStatus(&saved, now), nil
+}
+
+// Get returns a silence by ID
+func (s *Silencer) Get(id string) (*models.Silence, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	existing, exists := s.silences[id]
+	if !exists {
+		return nil, fmt.Errorf("silence %s not found", id)
+	}
+	return withStatus(existing.def, time.Now()), nil
+}
+
+// List returns the silences with the given status, or all of them when
+// status is empty, most recently started first
+func (s *Silencer) List(status string) []*models.Silence {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	defs := make([]*models.Silence, 0, len(s.silences))
+	for _, existing := range s.silences {
+		def := withStatus(existing.def, now)
+		if status != "" && def.Status != status {
+			continue
+		}
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		if !defs[i].StartsAt.Equal(defs[j].StartsAt) {
+			return defs[i].StartsAt.After(defs[j].StartsAt)
+		}
+		return defs[i].ID < defs[j].ID
+	})
+
+	return defs
+}
+
+// Silenced returns the IDs of the active silences matching the labels
+func (s *Silencer) Silenced(labels map[string]string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var ids []string
+	for id, existing := range s.silences {
+		if existing.def.StatusAt(now) != models.SilenceStatusActive {
+			continue
+		}
+		if matchesAll(existing.matchers, labels) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// GC deletes the silences that expired longer than the retention ago
+func (s *Silencer) GC() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-s.retention)
+	for id, existing := range s.silences {
+		if existing.def.EndsAt.After(cutoff) {
+			continue
+		}
+		if err := s.store.DeleteSilence(id); err != nil {
+			s.logger.Warn("Failed to delete expired silence",
+				zap.String("id", id),
+				zap.Error(err),
+			)
+			continue
+		}
+		delete(s.silences, id)
+		s.logger.Debug("Expired silence deleted", zap.String("id", id))
+	}
+}
+
+// withStatus returns a copy of a silence with its status at now
+func withStatus(def *models.Silence, now time.Time) *models.Silence {
+	out := *def
+	out.Status = def.StatusAt(now)
+	return &out
+}
+
+// validateSilence checks a silence's matchers and time range
+func validateSilence(def *models.Silence, now time.Time) ([]*utils.Matcher, error) {
+	if len(def.Matchers) == 0 {
+		return nil, fmt.Errorf("silence must have at least one matcher")
+	}
+	matchers, err := utils.ParseMatchers(def.Matchers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid matchers: %w", err)
+	}
+	if def.EndsAt.IsZero() {
+		return nil, fmt.Errorf("silence must have an end time")
+	}
+	if !def.EndsAt.After(def.StartsAt) {
+		return nil, fmt.Errorf("silence must end after it starts")
+	}
+	if !def.EndsAt.After(now) {
+		return nil, fmt.Errorf("silence end time is in the past")
+	}
+	return matchers, nil
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// certReloadInterval is how often certificate files are checked for changes
+const certReloadInterval = 30 * time.Second
+
+// certReloader serves a certificate, and optionally a client CA pool,
+// loaded from files. They are loaded again when the files change, so
+// renewed certificates are used without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   *zap.Logger
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTime   time.Time
+	// trusted are client CAs added with trust, kept across reloads
+	trusted []*x509.Certificate
+}
+
+// newCertReloader loads a certificate and key, and the client CA file if
+// set
+func newCertReloader(certFile, keyFile, caFile string, logger *zap.Logger) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		logger:   logger,
+	}
+	if _, err := r.reload(true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+// reload loads the files if they changed since they were last loaded, or
+// always when force is set. It reports whether they were loaded; on error
+// the current certificate is kept.
+func (r *certReloader) reload(force bool) (bool, error) {
+	var modTime time.Time
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	r.mu.RLock()
+	unchanged := !modTime.After(r.modTime)
+	r.mu.RUnlock()
+	if unchanged && !force {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load certificate %s: %w", r.certFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false, fmt.Errorf("failed to parse certificate %s: %w", r.certFile, err)
+		}
+	}
+
+	// Without a CA file no client certificate is trusted
+	clientCAs := x509.NewCertPool()
+	if r.caFile != "" {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return false, fmt.Errorf("no PEM certificates found in client CA file %s", r.caFile)
+		}
+	}
+	r.mu.RLock()
+	for _, ca := range r.trusted {
+		clientCAs.AddCert(ca)
+	}
+	r.mu.RUnlock()
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTime = modTime
+	r.mu.Unlock()
+
+	if time.Until(cert.Leaf.NotAfter) < 7*24*time.Hour {
+		r.logger.Warn("TLS certificate expires soon",
+			zap.String("cert_file", r.certFile),
+			zap.Time("not_after", cert.Leaf.NotAfter),
+		)
+	}
+	return true, nil
+}
+
+// trust adds a client CA besides those in the CA file
+func (r *certReloader) trust(ca *x509.Certificate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trusted = append(r.trusted, ca)
+	r.clientCAs.AddCert(ca)
+}
+
+// Reload loads the files again, even if they look unchanged
+func (r *certReloader) Reload() error {
+	if _, err := r.reload(true); err != nil {
+		return err
+	}
+	r.logger.Info("Reloaded TLS certificate",
+		zap.String("cert_file", r.certFile),
+		zap.Time("not_after", r.leaf().NotAfter),
+	)
+	return nil
+}
+
+// watch checks the files for changes until stop is closed
+func (r *certReloader) watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			reloaded, err := r.reload(false)
+			if err != nil {
+				// A renewal may be half written; it is retried next time
+				r.logger.Warn("Failed to reload TLS certificate, keeping the current one",
+					zap.String("cert_file", r.certFile),
+					zap.Error(err),
+				)
+				continue
+			}
+			if reloaded {
+				r.logger.Info("Reloaded TLS certificate",
+					zap.String("cert_file", r.certFile),
+					zap.Time("not_after", r.leaf().NotAfter),
+				)
+			}
+		}
+	}
+}
+
+func (r *certReloader) leaf() *x509.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert.Leaf
+}
+
+// GetCertificate returns the current certificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// tlsConfig returns a server TLS config restricted by policy that uses
+// the current certificate and client CAs for each handshake
+func (r *certReloader) tlsConfig(clientAuth tls.ClientAuthType, policy *utils.TLSPolicyConfig) (*tls.Config, error) {
+	config := &tls.Config{
+		GetCertificate: r.GetCertificate,
+		ClientAuth:     clientAuth,
+	}
+	if err := policy.Apply(config); err != nil {
+		return nil, err
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = r.clientCAs
+		return c, nil
+	}
+	return config, nil
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/i18n"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Default trend report templates, rendered with the report
+const (
+	defaultTrendSubject = `{{ t "report.trends.title" }} {{ .Name }}: {{ t "report.trends.period" .From .To }}`
+
+	defaultTrendText = `{{ t "report.trends.title" }} {{ .Name }}: {{ t "report.trends.period" .From .To }}
+{{ range .Series }}
+{{ or .Key "(none)" }}
+{{ range .Months }}  {{ .Month }}  {{ t "report.label.cpu_avg" }} {{ percent .CPUAvg }}{{ with .Change }} ({{ delta .CPUAvg }}){{ end }}  {{ t "report.label.cpu_max" }} {{ percent .CPUMax }}  {{ t "report.label.disk_used" }} {{ bytes .DiskUsedBytes }}  {{ t "report.label.disk_growth" }} {{ bytes .DiskGrowthBytes }}{{ with .Change }} ({{ deltaBytes .DiskGrowthBytes }}){{ end }}  {{ t "report.label.alerts" }} {{ .Alerts }}{{ with .Change }} ({{ printf "%+d" .Alerts }}){{ end }}
+{{ end }}{{ else }}
+{{ t "report.trends.none" }}
+{{ end }}`
+
+	defaultTrendHTML = `<!DOCTYPE html>
+<html>
+<body style="font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #1d1c1d;">
+<h2 style="margin: 0 0 12px;">{{ t "report.trends.title" }} {{ .Name }}</h2>
+<p>{{ t "report.trends.period" .From .To }}</p>
+{{ range .Series }}<h3 style="margin: 16px 0 8px;">{{ or .Key "(none)" }}</h3>
+<table cellpadding="6" cellspacing="0" style="border-collapse: collapse; width: 100%;">
+<tr style="background: #f4f4f4; text-align: left;">
+<th>{{ t "report.label.month" }}</th><th>{{ t "report.label.nodes" }}</th><th>{{ t "report.label.cpu_avg" }}</th><th>{{ t "report.label.cpu_max" }}</th><th>{{ t "report.label.disk_used" }}</th><th>{{ t "report.label.disk_growth" }}</th><th>{{ t "report.label.alerts" }}</th>
+</tr>
+{{ range .Months }}<tr style="border-top: 1px solid #ddd;">
+<td>{{ .Month }}</td>
+<td>{{ .Nodes }}</td>
+<td>{{ percent .CPUAvg }}{{ with .Change }} <small>({{ delta .CPUAvg }})</small>{{ end }}</td>
+<td>{{ percent .CPUMax }}{{ with .Change }} <small>({{ delta .CPUMax }})</small>{{ end }}</td>
+<td>{{ bytes .DiskUsedBytes }}{{ with .Change }} <small>({{ deltaBytes .DiskUsedBytes }})</small>{{ end }}</td>
+<td>{{ bytes .DiskGrowthBytes }}</td>
+<td>{{ .Alerts }}{{ with .Change }} <small>({{ printf "%+d" .Alerts }})</small>{{ end }}</td>
+</tr>
+{{ end }}</table>
+{{ else }}<p>{{ t "report.trends.none" }}</p>
+{{ end }}</body>
+</html>
+`
+)
+
+// trendFuncs format the figures in trend report templates
+var trendFuncs = map[string]interface{}{
+	"percent": func(v float64) string {
+		return fmt.Sprintf("%.1f%%", v)
+	},
+	"delta": func(v float64) string {
+		return fmt.Sprintf("%+.1f", v)
+	},
+	"bytes": formatTrendBytes,
+	"deltaBytes": func(v float64) string {
+		if v < 0 {
+			return formatTrendBytes(v)
+		}
+		return "+" + formatTrendBytes(v)
+	},
+}
+
+// formatTrendBytes renders a byte count in binary units
+func formatTrendBytes(v float64) string {
+	abs := math.Abs(v)
+	if abs < 1024 {
+		return fmt.Sprintf("%.0fB", v)
+	}
+	exp := 0
+	for div := abs / 1024; div >= 1024 && exp < 5; div /= 1024 {
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", v/math.Pow(1024, float64(exp+1)), "KMGTPE"[exp])
+}
+
+// trendReport is a report emailed on a schedule
+type trendReport struct {
+	config   utils.TrendReportConfig
+	schedule *utils.CronSchedule
+	email    *emailNotifier
+	subject  *template.Template
+	text     *template.Template
+	html     *htmltemplate.Template
+}
+
+// trendReportData is what trend report templates are rendered with
+type trendReportData struct {
+	Name string
+	*models.TrendReport
+}
+
+// newTrendReports parses the schedules and templates of the configured
+// trend reports
+func newTrendReports(configs []utils.TrendReportConfig, catalog *i18n.Catalog) ([]*trendReport, error) {
+	reports := make([]*trendReport, 0, len(configs))
+	for _, cfg := range configs {
+		report, err := newTrendReport(cfg, catalog)
+		if err != nil {
+			return nil, fmt.Errorf("trend report %s: %w", cfg.Name, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func newTrendReport(cfg utils.TrendReportConfig, catalog *i18n.Catalog) (*trendReport, error) {
+	schedule, err := utils.ParseCron(cfg.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	t := catalog.Translator(cfg.Email.Locale)
+	funcs := template.FuncMap{"t": t}
+	for name, fn := range trendFuncs {
+		funcs[name] = fn
+	}
+	subjectText := cfg.Email.Subject
+	if subjectText == "" {
+		subjectText = defaultTrendSubject
+	}
+	subject, err := template.New("subject").Funcs(funcs).Parse(subjectText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+	text, err := template.New("text").Funcs(funcs).Parse(defaultTrendText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid text template: %w", err)
+	}
+
+	body := defaultTrendHTML
+	if cfg.Email.HTMLTemplate != "" {
+		data, err := os.ReadFile(cfg.Email.HTMLTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HTML template: %w", err)
+		}
+		body = string(data)
+	}
+	html, err := htmltemplate.New("html").Funcs(htmltemplate.FuncMap(funcs)).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTML template: %w", err)
+	}
+
+	return &trendReport{
+		config:   cfg,
+		schedule: schedule,
+		email:    &emailNotifier{config: cfg.Email},
+		subject:  subject,
+		text:     text,
+		html:     html,
+	}, nil
+}
+
+// RunReports emails each trend report on its schedule until stop is
+// closed
+func (t *Trends) RunReports(stop <-chan struct{}) {
+	if len(t.reports) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	var wg sync.WaitGroup
+	for _, report := range t.reports {
+		wg.Add(1)
+		go func(report *trendReport) {
+			defer wg.Done()
+			t.runReport(ctx, report)
+		}(report)
+	}
+	t.logger.Info("Scheduled trend reports started", zap.Int("reports", len(t.reports)))
+	wg.Wait()
+}
+
+func (t *Trends) runReport(ctx context.Context, report *trendReport) {
+	for {
+		next := report.schedule.Next(time.Now())
+		if next.IsZero() {
+			t.logger.Warn("Trend report schedule has no future runs", zap.String("report", report.config.Name))
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			if err := t.sendReport(ctx, report, now); err != nil {
+				t.logger.Error("Failed to send trend report",
+					zap.String("report", report.config.Name),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// sendReport emails the report of the complete months before now
+func (t *Trends) sendReport(ctx context.Context, report *trendReport, now time.Time) error {
+	to := addMonths(now.UTC().Format(trendMonth), -1)
+	from := addMonths(to, 1-report.config.Months)
+	trends, err := t.Report(from, to, report.config.GroupBy)
+	if err != nil {
+		return err
+	}
+
+	data := trendReportData{Name: report.config.Name, TrendReport: trends}
+	subject, err := executeTemplate(report.subject, data)
+	if err != nil {
+		return fmt.Errorf("failed to render subject: %w", err)
+	}
+	text, err := executeTemplate(report.text, data)
+	if err != nil {
+		return fmt.Errorf("failed to render text: %w", err)
+	}
+	var html bytes.Buffer
+	if err := report.html.Execute(&html, data); err != nil {
+		return fmt.Errorf("failed to render HTML: %w", err)
+	}
+
+	msg, err := report.email.compose(subject, text, html.Bytes(),
+		utils.HashLabels(map[string]string{"report": report.config.Name}))
+	if err != nil {
+		return err
+	}
+	if err := retry(ctx, func() error { return report.email.send(ctx, msg) }); err != nil {
+		return err
+	}
+
+	t.logger.Info("Sent trend report",
+		zap.String("report", report.config.Name),
+		zap.String("from", from),
+		zap.String("to", to),
+		zap.Int("series", len(trends.Series)),
+	)
+	return nil
+}
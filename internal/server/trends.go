@@ -0,0 +1,413 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// trendMonth formats the UTC month trends are aggregated over
+const trendMonth = "2006-01"
+
+// diskStaleAfter is how long a filesystem counts towards its node's used
+// disk bytes after it was last measured, so unmounted filesystems drop out
+const diskStaleAfter = 10 * time.Minute
+
+// Trends aggregates each node's CPU usage, used disk bytes and alerts per
+// UTC month, for long-term reports. Aggregates are kept in memory and
+// merged into the month's saved records every flush interval, so they
+// outlive the retention of the samples.
+type Trends struct {
+	config *utils.TrendsConfig
+	store  storage.Storage
+	logger *zap.Logger
+
+	// pending holds the aggregates since the last flush, by month then node
+	pending map[string]map[string]*models.NodeMonth
+	// disks holds the last used bytes of each node's filesystems, by
+	// series
+	disks map[string]map[string]diskUsage
+	mu    sync.Mutex
+
+	// flushMu serializes flushes, which read and update saved records
+	flushMu sync.Mutex
+	// readOnly is set on read replicas, which only report trends
+	readOnly bool
+
+	// reports are emailed on their schedules
+	reports []*trendReport
+}
+
+// diskUsage is a filesystem's used bytes when last measured
+type diskUsage struct {
+	bytes float64
+	at    time.Time
+}
+
+// NewTrends creates a monthly trend aggregator
+func NewTrends(config *utils.TrendsConfig, store storage.Storage, logger *zap.Logger) *Trends {
+	return &Trends{
+		config:  config,
+		store:   store,
+		logger:  logger.Named("trends"),
+		pending: make(map[string]map[string]*models.NodeMonth),
+		disks:   make(map[string]map[string]diskUsage),
+	}
+}
+
+// month returns the pending aggregates of a node in a month. The caller
+// holds t.mu.
+func (t *Trends) month(month, nodeID string) *models.NodeMonth {
+	byNode, ok := t.pending[month]
+	if !ok {
+		byNode = make(map[string]*models.NodeMonth)
+		t.pending[month] = byNode
+	}
+	r, ok := byNode[nodeID]
+	if !ok {
+		r = &models.NodeMonth{NodeID: nodeID, Month: month}
+		byNode[nodeID] = r
+	}
+	return r
+}
+
+// RecordIngest aggregates a node's CPU and disk metrics as they are stored
+func (t *Trends) RecordIngest(nodeID string, metrics []*models.Metric) {
+	if len(metrics) == 0 || nodeID == "" || t.readOnly {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var diskAt time.Time
+	for _, metric := range metrics {
+		ts := metric.Timestamp
+		if ts.IsZero() {
+			ts = now
+		}
+		ts = ts.UTC()
+
+		switch metric.Name {
+		case t.config.CPUMetric:
+			r := t.month(ts.Format(trendMonth), nodeID)
+			r.CPUSum += metric.Value
+			r.CPUSamples++
+			r.CPUMax = max(r.CPUMax, metric.Value)
+		case t.config.DiskMetric:
+			disks, ok := t.disks[nodeID]
+			if !ok {
+				disks = make(map[string]diskUsage)
+				t.disks[nodeID] = disks
+			}
+			disks[utils.HashLabels(metric.Labels)] = diskUsage{bytes: metric.Value, at: ts}
+			if ts.After(diskAt) {
+				diskAt = ts
+			}
+		}
+	}
+	if diskAt.IsZero() {
+		return
+	}
+
+	var used float64
+	for key, disk := range t.disks[nodeID] {
+		if diskAt.Sub(disk.at) > diskStaleAfter {
+			delete(t.disks[nodeID], key)
+			continue
+		}
+		used += disk.bytes
+	}
+	mergeNodeMonth(t.month(diskAt.Format(trendMonth), nodeID), &models.NodeMonth{
+		DiskFirst:   used,
+		DiskFirstAt: diskAt,
+		DiskLast:    used,
+		DiskLastAt:  diskAt,
+	})
+}
+
+// RecordAlert counts an alert that started firing against its node
+func (t *Trends) RecordAlert(alert *models.Alert) {
+	nodeID := alert.Labels["node"]
+	if nodeID == "" || t.readOnly {
+		return
+	}
+	month := time.Now().UTC().Format(trendMonth)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.month(month, nodeID).Alerts++
+}
+
+// mergeNodeMonth adds the aggregates of src to dst, keeping the earliest
+// and latest disk measurements
+func mergeNodeMonth(dst, src *models.NodeMonth) {
+	dst.CPUSum += src.CPUSum
+	dst.CPUSamples += src.CPUSamples
+	dst.CPUMax = max(dst.CPUMax, src.CPUMax)
+	if !src.DiskFirstAt.IsZero() && (dst.DiskFirstAt.IsZero() || src.DiskFirstAt.Before(dst.DiskFirstAt)) {
+		dst.DiskFirst = src.DiskFirst
+		dst.DiskFirstAt = src.DiskFirstAt
+	}
+	if !src.DiskLastAt.IsZero() && !src.DiskLastAt.Before(dst.DiskLastAt) {
+		dst.DiskLast = src.DiskLast
+		dst.DiskLastAt = src.DiskLastAt
+	}
+	dst.Alerts += src.Alerts
+}
+
+// Flush merges the pending aggregates into the saved records. Aggregates
+// that fail to save are kept pending for the next flush.
+func (t *Trends) Flush() {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[string]map[string]*models.NodeMonth)
+	t.mu.Unlock()
+
+	for month, byNode := range pending {
+		saved, err := t.store.ListNodeMonths(month, month)
+		if err != nil {
+			t.logger.Error("Failed to read node trends", zap.String("month", month), zap.Error(err))
+			t.restore(byNode)
+			continue
+		}
+		records := make(map[string]*models.NodeMonth, len(saved))
+		for _, r := range saved {
+			records[r.NodeID] = r
+		}
+
+		now := time.Now()
+		for nodeID, delta := range byNode {
+			r, ok := records[nodeID]
+			if !ok {
+				r = &models.NodeMonth{NodeID: nodeID, Month: month}
+			}
+			mergeNodeMonth(r, delta)
+			r.UpdatedAt = now
+			if err := t.store.SaveNodeMonth(r); err != nil {
+				t.logger.Error("Failed to save node trends",
+					zap.String("node_id", nodeID),
+					zap.String("month", month),
+					zap.Error(err),
+				)
+				t.restore(map[string]*models.NodeMonth{nodeID: delta})
+			}
+		}
+	}
+}
+
+// restore puts aggregates that could not be saved back in pending
+func (t *Trends) restore(byNode map[string]*models.NodeMonth) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for nodeID, delta := range byNode {
+		mergeNodeMonth(t.month(delta.Month, nodeID), delta)
+	}
+}
+
+// prune deletes the records of months past the retention
+func (t *Trends) prune(now time.Time) {
+	cutoff := addMonths(now.UTC().Format(trendMonth), 1-t.config.RetentionMonths)
+	deleted, err := t.store.DeleteNodeMonthsBefore(cutoff)
+	if err != nil {
+		t.logger.Warn("Failed to delete old node trends", zap.Error(err))
+		return
+	}
+	if deleted > 0 {
+		t.logger.Info("Deleted old node trends", zap.Int64("records", deleted), zap.String("before", cutoff))
+	}
+}
+
+// Run flushes aggregates until stop is closed, pruning old months daily.
+// The server flushes once more on shutdown.
+func (t *Trends) Run(stop <-chan struct{}) {
+	t.logger.Info("Aggregating monthly trends",
+		zap.String("cpu_metric", t.config.CPUMetric),
+		zap.String("disk_metric", t.config.DiskMetric),
+		zap.Duration("flush_interval", t.config.FlushInterval),
+	)
+	t.prune(time.Now())
+
+	flushTicker := time.NewTicker(t.config.FlushInterval)
+	defer flushTicker.Stop()
+	pruneTicker := time.NewTicker(24 * time.Hour)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-flushTicker.C:
+			t.Flush()
+		case now := <-pruneTicker.C:
+			t.prune(now)
+		}
+	}
+}
+
+// trendGroup accumulates the aggregates of a node or group in a month
+type trendGroup struct {
+	nodes      int
+	cpuSum     float64
+	cpuSamples int64
+	cpuMax     float64
+	diskUsed   float64
+	diskGrowth float64
+	alerts     int64
+}
+
+func (g *trendGroup) add(r *models.NodeMonth) {
+	g.nodes++
+	g.cpuSum += r.CPUSum
+	g.cpuSamples += r.CPUSamples
+	g.cpuMax = max(g.cpuMax, r.CPUMax)
+	if !r.DiskLastAt.IsZero() {
+		g.diskUsed += r.DiskLast
+		g.diskGrowth += r.DiskLast - r.DiskFirst
+	}
+	g.alerts += r.Alerts
+}
+
+func (g *trendGroup) month(month string) *models.TrendMonth {
+	m := &models.TrendMonth{
+		Month:           month,
+		Nodes:           g.nodes,
+		CPUMax:          g.cpuMax,
+		DiskUsedBytes:   g.diskUsed,
+		DiskGrowthBytes: g.diskGrowth,
+		Alerts:          g.alerts,
+	}
+	if g.cpuSamples > 0 {
+		m.CPUAvg = g.cpuSum / float64(g.cpuSamples)
+	}
+	return m
+}
+
+// trendChange returns the difference of a month from the previous one
+func trendChange(cur, prev *models.TrendMonth) *models.TrendChange {
+	change := &models.TrendChange{
+		CPUAvg:          cur.CPUAvg - prev.CPUAvg,
+		CPUMax:          cur.CPUMax - prev.CPUMax,
+		DiskUsedBytes:   cur.DiskUsedBytes - prev.DiskUsedBytes,
+		DiskGrowthBytes: cur.DiskGrowthBytes - prev.DiskGrowthBytes,
+		Alerts:          cur.Alerts - prev.Alerts,
+	}
+	if prev.Alerts > 0 {
+		percent := float64(change.Alerts) / float64(prev.Alerts) * 100
+		change.AlertsPercent = &percent
+	}
+	return change
+}
+
+// Report returns the monthly aggregates of the months from and to, per
+// node or grouped by "instance_group" or a node label. Nodes without the
+// label are grouped under an empty key. Pending aggregates are flushed
+// first.
+func (t *Trends) Report(from, to, groupBy string) (*models.TrendReport, error) {
+	if groupBy == "" {
+		groupBy = "node"
+	}
+	for _, month := range []string{from, to} {
+		if _, err := time.Parse(trendMonth, month); err != nil {
+			return nil, fmt.Errorf("invalid month %q, want YYYY-MM", month)
+		}
+	}
+	if from > to {
+		return nil, fmt.Errorf("from month %s is after to month %s", from, to)
+	}
+	t.Flush()
+
+	// The month before from gives the first month its change
+	prevFrom := addMonths(from, -1)
+	records, err := t.store.ListNodeMonths(prevFrom, to)
+	if err != nil {
+		return nil, err
+	}
+
+	group := func(nodeID string) string { return nodeID }
+	if groupBy != "node" {
+		nodes, err := t.store.ListNodes()
+		if err != nil {
+			return nil, err
+		}
+		keys := make(map[string]string, len(nodes))
+		for _, node := range nodes {
+			if groupBy == "instance_group" {
+				keys[node.ID] = node.InstanceGroup
+			} else {
+				keys[node.ID] = nodeLabels(node.Labels, node.ServerLabels)[groupBy]
+			}
+		}
+		group = func(nodeID string) string { return keys[nodeID] }
+	}
+
+	groups := make(map[string]map[string]*trendGroup)
+	for _, r := range records {
+		key := group(r.NodeID)
+		byMonth, ok := groups[key]
+		if !ok {
+			byMonth = make(map[string]*trendGroup)
+			groups[key] = byMonth
+		}
+		g, ok := byMonth[r.Month]
+		if !ok {
+			g = &trendGroup{}
+			byMonth[r.Month] = g
+		}
+		g.add(r)
+	}
+
+	report := &models.TrendReport{
+		From:    from,
+		To:      to,
+		GroupBy: groupBy,
+		Series:  make([]*models.TrendSeries, 0, len(groups)),
+	}
+	for key, byMonth := range groups {
+		series := &models.TrendSeries{Key: key, Months: make([]*models.TrendMonth, 0)}
+		var prev *models.TrendMonth
+		for month := prevFrom; month <= to; month = addMonths(month, 1) {
+			g, ok := byMonth[month]
+			if !ok {
+				prev = nil
+				continue
+			}
+			cur := g.month(month)
+			if prev != nil {
+				cur.Change = trendChange(cur, prev)
+			}
+			if month >= from {
+				series.Months = append(series.Months, cur)
+			}
+			prev = cur
+		}
+		if len(series.Months) > 0 {
+			report.Series = append(report.Series, series)
+		}
+	}
+	sort.Slice(report.Series, func(i, j int) bool {
+		return report.Series[i].Key < report.Series[j].Key
+	})
+
+	return report, nil
+}
+
+// addMonths returns the month n months after a month formatted 2006-01.
+// Months that fail to parse are returned unchanged.
+func addMonths(month string, n int) string {
+	t, err := time.Parse(trendMonth, month)
+	if err != nil {
+		return month
+	}
+	return t.AddDate(0, n, 0).Format(trendMonth)
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/internal/storage"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// Usage sources
+const (
+	UsageSourceQuery  = "query"
+	UsageSourceRule   = "rule"
+	UsageSourceExport = "export"
+)
+
+// UsageTracker records which metric names and label combinations are
+// queried, so series nobody looks at can be reported and pruned. Usage is
+// kept in memory and covers the time since the server started; alert rules
+// and exports are always counted as usage.
+type UsageTracker struct {
+	store    storage.Storage
+	derived  *DerivedMetrics
+	alertMgr *AlertManager
+	exports  []utils.ExportConfig
+	since    time.Time
+
+	// usage is keyed by metric name, then by the canonical label matchers
+	usage map[string]map[string]*usageEntry
+	mu    sync.RWMutex
+}
+
+type usageEntry struct {
+	matchers map[string]string
+	count    int64
+	lastUsed time.Time
+	sources  map[string]bool
+}
+
+// NewUsageTracker creates a new usage tracker
+func NewUsageTracker(store storage.Storage, derived *DerivedMetrics, alertMgr *AlertManager, exports []utils.ExportConfig) *UsageTracker {
+	return &UsageTracker{
+		store:    store,
+		derived:  derived,
+		alertMgr: alertMgr,
+		exports:  exports,
+		since:    time.Now(),
+		usage:    make(map[string]map[string]*usageEntry),
+	}
+}
+
+// RecordQuery records a selector query. Queries of derived metrics also
+// count as usage of the metrics they are calculated from.
+func (u *UsageTracker) RecordQuery(query, source string) {
+	name, labels := storage.ParseQuery(query)
+	if name == "" {
+		return
+	}
+
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.record(name, labels, source, now)
+	for _, sel := range u.derived.Selectors(name) {
+		u.record(sel.Name, sel.Labels, source, now)
+	}
+}
+
+func (u *UsageTracker) record(name string, matchers map[string]string, source string, now time.Time) {
+	byMatcher, ok := u.usage[name]
+	if !ok {
+		byMatcher = make(map[string]*usageEntry)
+		u.usage[name] = byMatcher
+	}
+
+	key := utils.HashLabels(matchers)
+	entry, ok := byMatcher[key]
+	if !ok {
+		entry = &usageEntry{
+			matchers: copyLabels(matchers),
+			sources:  make(map[string]bool),
+		}
+		byMatcher[key] = entry
+	}
+
+	entry.count++
+	entry.lastUsed = now
+	entry.sources[source] = true
+}
+
+// staticUsage returns usage implied by configuration: alert rules and
+// scheduled exports use their metrics whether or not they ran recently
+func (u *UsageTracker) staticUsage() map[string]map[string]*usageEntry {
+	static := make(map[string]map[string]*usageEntry)
+
+	add := func(name string, matchers map[string]string, source string) {
+		if static[name] == nil {
+			static[name] = make(map[string]*usageEntry)
+		}
+		key := utils.HashLabels(matchers)
+		entry, ok := static[name][key]
+		if !ok {
+			entry = &usageEntry{matchers: matchers, sources: make(map[string]bool)}
+			static[name][key] = entry
+		}
+		entry.sources[source] = true
+	}
+
+	addQuery := func(name string, matchers map[string]string, source string) {
+		add(name, matchers, source)
+		for _, sel := range u.derived.Selectors(name) {
+			add(sel.Name, sel.Labels, source)
+		}
+	}
+
+	if u.alertMgr != nil {
+		for _, rule := range u.alertMgr.GetRules() {
+			if rule.Enabled {
+				addQuery(rule.MetricName, nil, UsageSourceRule)
+			}
+		}
+	}
+
+	for _, e := range u.exports {
+		name, labels := storage.ParseQuery(e.Query)
+		addQuery(name, labels, UsageSourceExport)
+	}
+
+	return static
+}
+
+// entries returns recorded and static usage entries for each metric name
+func (u *UsageTracker) entries() map[string][]*usageEntry {
+	result := make(map[string][]*usageEntry)
+
+	u.mu.RLock()
+	for name, byMatcher := range u.usage {
+		for _, entry := range byMatcher {
+			copied := *entry
+			copied.sources = make(map[string]bool, len(entry.sources))
+			for s := range entry.sources {
+				copied.sources[s] = true
+			}
+			result[name] = append(result[name], &copied)
+		}
+	}
+	u.mu.RUnlock()
+
+	for name, byMatcher := range u.staticUsage() {
+		for _, entry := range byMatcher {
+			result[name] = append(result[name], entry)
+		}
+	}
+
+	return result
+}
+
+// Report summarizes usage per metric name for series stored in the range
+func (u *UsageTracker) Report(start, end time.Time) (*models.UsageReport, error) {
+	series, err := u.store.ListSeries(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := u.entries()
+	byName := make(map[string]*models.MetricUsage)
+
+	get := func(name string) *models.MetricUsage {
+		mu, ok := byName[name]
+		if !ok {
+			mu = &models.MetricUsage{Name: name}
+			byName[name] = mu
+		}
+		return mu
+	}
+
+	for _, s := range series {
+		mu := get(s.Name)
+		mu.Series++
+		if seriesUsed(s, entries[s.Name]) {
+			mu.UsedSeries++
+		}
+	}
+
+	for name, list := range entries {
+		mu := get(name)
+		sources := make(map[string]bool)
+		for _, entry := range list {
+			mu.Queries += entry.count
+			if !entry.lastUsed.IsZero() && (mu.LastQueriedAt == nil || entry.lastUsed.After(*mu.LastQueriedAt)) {
+				last := entry.lastUsed
+				mu.LastQueriedAt = &last
+			}
+			for s := range entry.sources {
+				sources[s] = true
+			}
+		}
+		for s := range sources {
+			mu.Sources = append(mu.Sources, s)
+		}
+		sort.Strings(mu.Sources)
+	}
+
+	report := &models.UsageReport{
+		TrackingSince: u.since,
+		Metrics:       make([]*models.MetricUsage, 0, len(byName)),
+	}
+	for _, mu := range byName {
+		report.Metrics = append(report.Metrics, mu)
+	}
+	sort.Slice(report.Metrics, func(i, j int) bool {
+		return report.Metrics[i].Name < report.Metrics[j].Name
+	})
+
+	return report, nil
+}
+
+// Unused returns series stored in the range that no recorded query, rule
+// or export matches, largest first
+func (u *UsageTracker) Unused(start, end time.Time) (*models.UnusedSeriesReport, error) {
+	series, err := u.store.ListSeries(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := u.entries()
+	report := &models.UnusedSeriesReport{
+		TrackingSince: u.since,
+		TotalSeries:   len(series),
+		Series:        make([]*models.SeriesInfo, 0),
+	}
+
+	for _, s := range series {
+		if seriesUsed(s, entries[s.Name]) {
+			continue
+		}
+		report.Series = append(report.Series, s)
+		report.UnusedSamples += s.Samples
+		report.UnusedBytes += s.Bytes
+	}
+	report.UnusedSeries = len(report.Series)
+
+	sort.Slice(report.Series, func(i, j int) bool {
+		if report.Series[i].Bytes != report.Series[j].Bytes {
+			return report.Series[i].Bytes > report.Series[j].Bytes
+		}
+		return report.Series[i].Name < report.Series[j].Name
+	})
+
+	return report, nil
+}
+
+// seriesUsed reports whether any usage entry's matchers select the series
+func seriesUsed(s *models.SeriesInfo, entries []*usageEntry) bool {
+	for _, entry := range entries {
+		if matchLabels(s.Labels, entry.matchers) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
@@ -16,28 +20,70 @@ import (
 )
 
 type BadgerStore struct {
+	// chunkSpanNanos is read with atomic; see chunkSpan
+	chunkSpanNanos int64
+
+	// db holds everything but samples, which are in shards
 	db     *badger.DB
+	shards *shardSet
+	// cold is set when tiering archives old shards
+	cold   *coldTier
 	config *utils.StorageConfig
 	logger *zap.Logger
+
+	// legacy is set while db still holds samples written before sharding
+	legacy atomic.Bool
+
+	// alertSeq is the sequence of the last alert event, loaded from the
+	// event log on first use
+	alertSeq       uint64
+	alertSeqLoaded bool
+	alertSeqMu     sync.Mutex
 }
 
 func NewBadgerStore(config *utils.StorageConfig, logger *zap.Logger) (*BadgerStore, error) {
-	opts := badger.DefaultOptions(config.Path)
-	opts.Logger = &badgerLogger{logger: logger}
-	opts.SyncWrites = config.SyncWrites
-	opts.ValueLogFileSize = config.ValueLogFileSize
-	opts.MemTableSize = config.MemTableSize
-
+	opts := mainOptions(config, logger)
 	db, err := badger.Open(opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	if limit := utils.MemoryLimit(); limit > 0 {
+		logger.Info("Storage memory sized to the memory limit",
+			zap.Int64("limit_bytes", limit),
+			zap.Int64("mem_table_size", opts.MemTableSize),
+			zap.Int64("block_cache_size", opts.BlockCacheSize),
+		)
+	}
 
 	store := &BadgerStore{
 		db:     db,
 		config: config,
 		logger: logger,
 	}
+	if err := store.loadChunkSpan(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load chunk duration: %w", err)
+	}
+
+	store.shards, err = newShardSet(filepath.Join(config.Path, shardsDir), config.Shards.BlockDuration, config.Shards.MaxOpen, store.shardOptions, logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store.shards.summarize = store.summarizeShard
+	legacy, err := store.hasLegacySamples()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to check for unsharded samples: %w", err)
+	}
+	store.legacy.Store(legacy)
+
+	if config.Tiering.Enabled {
+		if store.cold, err = store.newColdTier(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
 
 	// Start compaction goroutine
 	go store.runCompaction()
@@ -49,170 +95,112 @@ func NewBadgerStore(config *utils.StorageConfig, logger *zap.Logger) (*BadgerSto
 	return store, nil
 }
 
+// WriteMetrics writes samples to the shards of their timestamps. Samples
+// already past the retention period, or the warm retention when old
+// shards are archived, are discarded.
 func (s *BadgerStore) WriteMetrics(metrics []*models.Metric) error {
-	return s.db.Update(func(txn *badger.Txn) error {
-		for _, metric := range metrics {
-			key := s.encodeMetricKey(metric)
-			value, err := s.encodeMetricValue(metric)
-			if err != nil {
-				s.logger.Error("Failed to encode metric", zap.Error(err))
-				continue
-			}
-
-			if err := txn.Set(key, value); err != nil {
-				return fmt.Errorf("failed to write metric: %w", err)
-			}
-		}
-		return nil
-	})
-}
-
-func (s *BadgerStore) QueryMetrics(query string, start, end time.Time, step time.Duration) ([]*models.TimeSeries, error) {
-	// Parse query (simplified for now)
-	// In production, you'd want to implement a proper query parser
-	metricName, filters := parseSimpleQuery(query)
+	var cutoff time.Time
+	if s.config.RetentionPeriod > 0 {
+		cutoff = time.Now().Add(-s.config.RetentionPeriod)
+	}
+	if s.cold != nil {
+		// An archived block is never written again
+		cutoff = time.Now().Add(-s.config.Tiering.WarmRetention)
+	}
 
-	var series []*models.TimeSeries
-	seriesMap := make(map[string]*models.TimeSeries)
+	w := &shardWriter{set: s.shards}
+	defer w.release()
 
-	err := s.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
+	groups := make(map[*shard][]*models.Metric)
+	var order []*shard
+	var expired int
+	for _, metric := range metrics {
+		if metric.Timestamp.Before(cutoff) {
+			expired++
+			continue
+		}
+		sh, err := w.shardFor(metric.Timestamp)
+		if err != nil {
+			return err
+		}
+		if _, ok := groups[sh]; !ok {
+			order = append(order, sh)
+		}
+		groups[sh] = append(groups[sh], metric)
+	}
+	if expired > 0 {
+		s.logger.Debug("Discarded samples older than the retention period", zap.Int("samples", expired))
+	}
 
-		prefix := []byte(fmt.Sprintf("metric:%s:", metricName))
-		
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
+	arena := getWriteArena()
+	defer putWriteArena(arena)
+	for _, sh := range order {
+		group := groups[sh]
+		hashes := make([]string, len(group))
+		first, last := int64(math.MaxInt64), int64(math.MinInt64)
+		for i, metric := range group {
+			hashes[i] = utils.HashLabels(metric.Labels)
+			ts := metric.Timestamp.UnixNano()
+			first, last = min(first, ts), max(last, ts)
+		}
 
-			// Decode metric from key/value
-			metric, err := s.decodeMetric(item)
-			if err != nil {
-				s.logger.Warn("Failed to decode metric", zap.Error(err))
-				continue
-			}
-			
-			// Filter by time range
-			if metric.Timestamp.Before(start) || metric.Timestamp.After(end) {
-				continue
-			}
-			
-			// Apply filters
-			if !s.matchesFilters(metric, filters) {
-				continue
-			}
-			
-			// Group by labels
-			seriesKey := s.seriesKey(metric.Labels)
-			if _, exists := seriesMap[seriesKey]; !exists {
-				seriesMap[seriesKey] = &models.TimeSeries{
-					Labels: metric.Labels,
-					Samples: make([]models.Sample, 0),
-				}
+		var indexed []*seriesEntry
+		err := sh.db.Update(func(txn *badger.Txn) error {
+			var err error
+			if indexed, err = indexNew(txn, sh, group, hashes); err != nil {
+				return fmt.Errorf("failed to index series: %w", err)
 			}
-			
-			// Apply downsampling based on step
-			roundedTime := metric.Timestamp.Truncate(step)
-			series := seriesMap[seriesKey]
-			
-			// Find or create sample for this time bucket
-			found := false
-			for i := range series.Samples {
-				if series.Samples[i].Timestamp.Equal(roundedTime) {
-					// Aggregate (average for now)
-					series.Samples[i].Value = (series.Samples[i].Value + metric.Value) / 2
-					found = true
-					break
+			for i, metric := range group {
+				key := arena.metricKey(metric, hashes[i])
+				if err := txn.Set(key, arena.sampleValue(metric)); err != nil {
+					return fmt.Errorf("failed to write metric: %w", err)
 				}
 			}
-			
-			if !found {
-				series.Samples = append(series.Samples, models.Sample{
-					Timestamp: roundedTime,
-					Value:     metric.Value,
-				})
-			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		
-		return nil
-	})
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	// Convert map to slice
-	for _, ts := range seriesMap {
-		series = append(series, ts)
+		for _, e := range indexed {
+			sh.series.Store(e.id(), struct{}{})
+		}
+		s.shards.observe(sh, first, last, indexed)
 	}
-	
-	return series, nil
+	return nil
 }
 
-func (s *BadgerStore) encodeMetricKey(metric *models.Metric) []byte {
-	// Key format: metric:name:timestamp:labels_hash
-	timestamp := metric.Timestamp.UnixNano()
-	labelsHash := utils.HashLabels(metric.Labels)
-	
-	key := fmt.Sprintf("metric:%s:%d:%s", 
-		metric.Name, 
-		timestamp,
-		labelsHash,
-	)
-	
-	return []byte(key)
-}
-
-func (s *BadgerStore) encodeMetricValue(metric *models.Metric) ([]byte, error) {
-	data := struct {
-		Value     float64           `json:"v"`
-		Labels    map[string]string `json:"l,omitempty"`
-		NodeID    string            `json:"n"`
-		Type      string            `json:"t"`
-		Help      string            `json:"h,omitempty"`
-		Unit      string            `json:"u,omitempty"`
-	}{
-		Value:  metric.Value,
-		Labels: metric.Labels,
-		NodeID: metric.NodeID,
-		Type:   metric.Type.String(),
-		Help:   metric.Help,
-		Unit:   metric.Unit,
+func (s *BadgerStore) QueryMetrics(query string, start, end time.Time, step time.Duration) ([]*models.TimeSeries, error) {
+	// Parse query (simplified for now)
+	// In production, you'd want to implement a proper query parser
+	metricName, filters := parseSimpleQuery(query)
+
+	// Samples are averaged over each step
+	q := make(querySeries)
+	if err := s.queryRaw(q, metricName, filters, start, end, step); err != nil {
+		return nil, err
 	}
-	
-	return json.Marshal(data)
+	return q.timeSeries(), nil
 }
 
 func (s *BadgerStore) decodeMetric(item *badger.Item) (*models.Metric, error) {
-	var data struct {
-		Value  float64           `json:"v"`
-		Labels map[string]string `json:"l"`
-		NodeID string            `json:"n"`
-		Type   string            `json:"t"`
-		Help   string            `json:"h"`
-		Unit   string            `json:"u"`
-	}
-	
+	var data *sampleValue
 	err := item.Value(func(val []byte) error {
-		return json.Unmarshal(val, &data)
+		var err error
+		data, err = decodeSampleValue(val, false)
+		return err
 	})
 	if err != nil {
 		return nil, err
 	}
 	
-	// Parse key to get timestamp
-	key := item.Key()
-	parts := bytes.Split(key, []byte(":"))
-	if len(parts) < 3 {
+	// Parse key to get name and timestamp
+	name, timestamp, _, ok := splitSeriesKey(item.Key(), rawPrefix)
+	if !ok {
 		return nil, fmt.Errorf("invalid key format")
 	}
 	
-	timestamp, err := strconv.ParseInt(string(parts[2]), 10, 64)
-	if err != nil {
-		return nil, err
-	}
-	
 	metric := &models.Metric{
-		Name:      string(parts[1]),
+		Name:      name,
 		Value:     data.Value,
 		Timestamp: time.Unix(0, timestamp),
 		Labels:    data.Labels,
@@ -225,24 +213,6 @@ func (s *BadgerStore) decodeMetric(item *badger.Item) (*models.Metric, error) {
 	return metric, nil
 }
 
-func (s *BadgerStore) seriesKey(labels map[string]string) string {
-	keys := make([]string, 0, len(labels))
-	for k := range labels {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	
-	var b strings.Builder
-	for _, k := range keys {
-		b.WriteString(k)
-		b.WriteString("=")
-		b.WriteString(labels[k])
-		b.WriteString(",")
-	}
-	
-	return b.String()
-}
-
 func (s *BadgerStore) matchesFilters(metric *models.Metric, filters map[string]string) bool {
 	for key, value := range filters {
 		if metric.Labels[key] != value {
@@ -252,6 +222,17 @@ func (s *BadgerStore) matchesFilters(metric *models.Metric, filters map[string]s
 	return true
 }
 
+// mainOptions returns the options of the main database
+func mainOptions(config *utils.StorageConfig, logger *zap.Logger) badger.Options {
+	opts := badger.DefaultOptions(config.Path)
+	opts.Logger = &badgerLogger{logger: logger}
+	opts.SyncWrites = config.SyncWrites
+	opts.ValueLogFileSize = config.ValueLogFileSize
+	opts.MemTableSize = config.MemTableSize
+	fitMemory(&opts, config)
+	return opts
+}
+
 func (s *BadgerStore) runCompaction() {
 	ticker := time.NewTicker(30 * time.Minute)
 	defer ticker.Stop()
@@ -285,6 +266,13 @@ func (s *BadgerStore) SaveNode(node *models.Node) error {
 	})
 }
 
+// DeleteNode removes a node record. Its series are left in place.
+func (s *BadgerStore) DeleteNode(nodeID string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(fmt.Sprintf("node:%s", nodeID)))
+	})
+}
+
 // GetNode retrieves a node by ID
 func (s *BadgerStore) GetNode(nodeID string) (*models.Node, error) {
 	var node models.Node
@@ -396,170 +384,1183 @@ func (s *BadgerStore) GetAlerts(filter *models.AlertFilter) ([]*models.Alert, er
 	return alerts, err
 }
 
-// WriteCompressedMetrics writes compressed metrics
-func (s *BadgerStore) WriteCompressedMetrics(compressed *CompressedMetrics) error {
-	if compressed == nil {
-		return fmt.Errorf("compressed metrics is nil")
+const alertEventPrefix = "alertlog:"
+
+func alertEventKey(seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d", alertEventPrefix, seq))
+}
+
+// AppendAlertEvent assigns the next sequence number to an alert event and
+// appends it to the event log
+func (s *BadgerStore) AppendAlertEvent(event *models.AlertEvent) error {
+	s.alertSeqMu.Lock()
+	defer s.alertSeqMu.Unlock()
+
+	if err := s.loadAlertSeq(); err != nil {
+		return err
 	}
 
-	// For now, just store the compressed data as-is
-	// In a real implementation, you might want additional indexing
-	return s.db.Update(func(txn *badger.Txn) error {
-		key := []byte(fmt.Sprintf("compressed:%d", time.Now().UnixNano()))
-		return txn.Set(key, compressed.Data)
-	})
+	event.Seq = s.alertSeq + 1
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(alertEventKey(event.Seq), data)
+	}); err != nil {
+		return err
+	}
+
+	s.alertSeq = event.Seq
+	return nil
 }
 
-// DeleteMetricsOlderThan deletes metrics older than the specified time
-func (s *BadgerStore) DeleteMetricsOlderThan(cutoff time.Time) (int64, error) {
-	var deleted int64
+// LastAlertEventSeq returns the sequence of the newest alert event, or 0
+// if there are none
+func (s *BadgerStore) LastAlertEventSeq() (uint64, error) {
+	s.alertSeqMu.Lock()
+	defer s.alertSeqMu.Unlock()
 
-	cutoffNano := cutoff.UnixNano()
+	if err := s.loadAlertSeq(); err != nil {
+		return 0, err
+	}
+	return s.alertSeq, nil
+}
+
+// loadAlertSeq reads the last sequence from the event log. Callers must
+// hold alertSeqMu.
+func (s *BadgerStore) loadAlertSeq() error {
+	if s.alertSeqLoaded {
+		return nil
+	}
 
-	err := s.db.Update(func(txn *badger.Txn) error {
+	err := s.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte("metric:")
+		opts.Reverse = true
+		opts.PrefetchValues = false
 
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			key := item.Key()
-
-			// Extract timestamp from key
-			if len(key) > 7 {
-				// Assuming key format: metric:<node_id>:<metric_name>:<timestamp>
-				// We need to check if the timestamp is older than cutoff
-				err := item.Value(func(val []byte) error {
-					var metric models.Metric
-					if err := json.Unmarshal(val, &metric); err != nil {
-						return nil // Skip invalid entries
-					}
-
-					if metric.Timestamp.Before(cutoff) || metric.Timestamp.UnixNano() < cutoffNano {
-						if err := txn.Delete(key); err == nil {
-							deleted++
-						}
-					}
-
-					return nil
-				})
-				if err != nil {
-					continue
-				}
-			}
+		// Seek past the largest possible key of the prefix
+		prefix := []byte(alertEventPrefix)
+		it.Seek(append(append([]byte{}, prefix...), 0xff))
+		if !it.ValidForPrefix(prefix) {
+			return nil
 		}
 
+		seq, err := strconv.ParseUint(string(bytes.TrimPrefix(it.Item().Key(), prefix)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid alert event key %q: %w", it.Item().Key(), err)
+		}
+		s.alertSeq = seq
 		return nil
 	})
+	if err != nil {
+		return fmt.Errorf("failed to load alert event sequence: %w", err)
+	}
 
-	return deleted, err
-}
-
-// CompactMetricsInRange compacts metrics in a time range
-func (s *BadgerStore) CompactMetricsInRange(start, end time.Time) error {
-	// Placeholder for compaction logic
-	// In a real implementation, this would downsample or compress metrics
-	s.logger.Debug("Compacting metrics",
-		zap.Time("start", start),
-		zap.Time("end", end),
-	)
+	s.alertSeqLoaded = true
 	return nil
 }
 
-// RunGC runs garbage collection
-func (s *BadgerStore) RunGC() error {
-	return s.db.RunValueLogGC(0.5)
-}
-
-// GetStats returns database statistics
-func (s *BadgerStore) GetStats() (*DBStats, error) {
-	stats := &DBStats{
-		OldestMetric: time.Now(),
-		NewestMetric: time.Time{},
-	}
+// GetAlertEvents returns up to limit alert events with a sequence after
+// the given one, oldest first. A limit of 0 returns every event.
+func (s *BadgerStore) GetAlertEvents(after uint64, limit int) ([]*models.AlertEvent, error) {
+	var events []*models.AlertEvent
 
-	// Count metrics, nodes, alerts
-	s.db.View(func(txn *badger.Txn) error {
-		// Count metrics
+	err := s.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte("metric:")
+		opts.Prefix = []byte(alertEventPrefix)
+
 		it := txn.NewIterator(opts)
-		for it.Rewind(); it.Valid(); it.Next() {
-			stats.TotalMetrics++
-		}
-		it.Close()
+		defer it.Close()
 
-		// Count nodes
-		opts.Prefix = []byte("node:")
-		it = txn.NewIterator(opts)
-		for it.Rewind(); it.Valid(); it.Next() {
-			stats.TotalNodes++
-		}
-		it.Close()
+		for it.Seek(alertEventKey(after + 1)); it.Valid(); it.Next() {
+			if limit > 0 && len(events) >= limit {
+				break
+			}
 
-		// Count alerts
-		opts.Prefix = []byte("alert:")
-		it = txn.NewIterator(opts)
-		for it.Rewind(); it.Valid(); it.Next() {
-			stats.TotalAlerts++
+			err := it.Item().Value(func(val []byte) error {
+				var event models.AlertEvent
+				if err := json.Unmarshal(val, &event); err != nil {
+					return err
+				}
+				events = append(events, &event)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
 		}
-		it.Close()
 
 		return nil
 	})
 
-	return stats, nil
+	return events, err
 }
 
-func (s *BadgerStore) Close() error {
-	return s.db.Close()
-}
+// DeleteAlertEventsOlderThan trims the alert event log. Events are
+// appended in time order, so trimming stops at the first newer event.
+func (s *BadgerStore) DeleteAlertEventsOlderThan(cutoff time.Time) (int64, error) {
+	var keys [][]byte
 
-// Helper functions
-func parseSimpleQuery(query string) (string, map[string]string) {
-	// Simple parser for queries like "metric_name{label1="value1",label2="value2"}"
-	parts := strings.SplitN(query, "{", 2)
-	metricName := strings.TrimSpace(parts[0])
-	
-	filters := make(map[string]string)
-	
-	if len(parts) > 1 {
-		filterStr := strings.TrimSuffix(parts[1], "}")
-		pairs := strings.Split(filterStr, ",")
-		
-		for _, pair := range pairs {
-			pair = strings.TrimSpace(pair)
-			if pair == "" {
-				continue
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(alertEventPrefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var event models.AlertEvent
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &event)
+			})
+			if err != nil {
+				return err
 			}
-			
-			kv := strings.SplitN(pair, "=", 2)
-			if len(kv) == 2 {
-				key := strings.TrimSpace(kv[0])
-				value := strings.Trim(strings.TrimSpace(kv[1]), "\"")
-				filters[key] = value
+			if !event.Time.Before(cutoff) {
+				break
 			}
+			keys = append(keys, it.Item().KeyCopy(nil))
 		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	
-	return metricName, filters
-}
 
-type badgerLogger struct {
-	logger *zap.Logger
-}
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
 
-func (l *badgerLogger) Errorf(f string, v ...interface{}) {
-	l.logger.Error(fmt.Sprintf(f, v...))
+	return int64(len(keys)), nil
 }
 
-func (l *badgerLogger) Warningf(f string, v ...interface{}) {
-	l.logger.Warn(fmt.Sprintf(f, v...))
+// SaveDerivedMetric saves a derived metric definition
+func (s *BadgerStore) SaveDerivedMetric(metric *models.DerivedMetric) error {
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := []byte(fmt.Sprintf("derived:%s", metric.Name))
+		return txn.Set(key, data)
+	})
+}
+
+// ListDerivedMetrics lists all derived metric definitions
+func (s *BadgerStore) ListDerivedMetrics() ([]*models.DerivedMetric, error) {
+	var metrics []*models.DerivedMetric
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("derived:")
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var metric models.DerivedMetric
+				if err := json.Unmarshal(val, &metric); err != nil {
+					return err
+				}
+				metrics = append(metrics, &metric)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return metrics, err
+}
+
+// DeleteDerivedMetric deletes a derived metric definition
+func (s *BadgerStore) DeleteDerivedMetric(name string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(fmt.Sprintf("derived:%s", name)))
+	})
+}
+
+// SaveIngestRule saves an ingest rule
+func (s *BadgerStore) SaveIngestRule(rule *models.IngestRule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := []byte(fmt.Sprintf("ingestrule:%s", rule.Name))
+		return txn.Set(key, data)
+	})
+}
+
+// ListIngestRules lists all ingest rules
+func (s *BadgerStore) ListIngestRules() ([]*models.IngestRule, error) {
+	var rules []*models.IngestRule
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("ingestrule:")
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var rule models.IngestRule
+				if err := json.Unmarshal(val, &rule); err != nil {
+					return err
+				}
+				rules = append(rules, &rule)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return rules, err
+}
+
+// DeleteIngestRule deletes an ingest rule
+func (s *BadgerStore) DeleteIngestRule(name string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(fmt.Sprintf("ingestrule:%s", name)))
+	})
+}
+
+// SaveAnnotation saves an annotation
+func (s *BadgerStore) SaveAnnotation(annotation *models.Annotation) error {
+	data, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := []byte(fmt.Sprintf("annotation:%s", annotation.ID))
+		return txn.Set(key, data)
+	})
+}
+
+// GetAnnotations retrieves annotations matching the filter, newest first
+func (s *BadgerStore) GetAnnotations(filter *models.AnnotationFilter) ([]*models.Annotation, error) {
+	var annotations []*models.Annotation
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("annotation:")
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var annotation models.Annotation
+				if err := json.Unmarshal(val, &annotation); err != nil {
+					return err
+				}
+
+				if filter != nil && !filter.Matches(&annotation) {
+					return nil
+				}
+
+				annotations = append(annotations, &annotation)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].Time.After(annotations[j].Time)
+	})
+
+	if filter != nil && filter.Limit > 0 && len(annotations) > filter.Limit {
+		annotations = annotations[:filter.Limit]
+	}
+
+	return annotations, nil
+}
+
+// DeleteAnnotation deletes an annotation by ID
+func (s *BadgerStore) DeleteAnnotation(id string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := []byte(fmt.Sprintf("annotation:%s", id))
+		if _, err := txn.Get(key); err != nil {
+			return err
+		}
+		return txn.Delete(key)
+	})
+}
+
+// SaveSilence saves a silence
+func (s *BadgerStore) SaveSilence(silence *models.Silence) error {
+	data, err := json.Marshal(silence)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := []byte(fmt.Sprintf("silence:%s", silence.ID))
+		return txn.Set(key, data)
+	})
+}
+
+// ListSilences retrieves all silences
+func (s *BadgerStore) ListSilences() ([]*models.Silence, error) {
+	var silences []*models.Silence
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("silence:")
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var silence models.Silence
+				if err := json.Unmarshal(val, &silence); err != nil {
+					return err
+				}
+				silences = append(silences, &silence)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return silences, err
+}
+
+// DeleteSilence deletes a silence by ID
+func (s *BadgerStore) DeleteSilence(id string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(fmt.Sprintf("silence:%s", id)))
+	})
+}
+
+// SaveDashboard saves a dashboard and records it as its version
+func (s *BadgerStore) SaveDashboard(dashboard *models.Dashboard) error {
+	data, err := json.Marshal(dashboard)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte(fmt.Sprintf("dashboard:%s", dashboard.ID)), data); err != nil {
+			return err
+		}
+		return txn.Set(dashboardVersionKey(dashboard.ID, dashboard.Version), data)
+	})
+}
+
+// dashboardVersionKey pads the version so versions sort numerically
+func dashboardVersionKey(id string, version int) []byte {
+	return []byte(fmt.Sprintf("dashboardversion:%s:%010d", id, version))
+}
+
+// ListDashboards retrieves all dashboards
+func (s *BadgerStore) ListDashboards() ([]*models.Dashboard, error) {
+	return s.scanDashboards("dashboard:")
+}
+
+// GetDashboardVersions retrieves the saved versions of a dashboard, newest
+// first
+func (s *BadgerStore) GetDashboardVersions(id string) ([]*models.Dashboard, error) {
+	versions, err := s.scanDashboards(fmt.Sprintf("dashboardversion:%s:", id))
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions, err
+}
+
+func (s *BadgerStore) scanDashboards(prefix string) ([]*models.Dashboard, error) {
+	var dashboards []*models.Dashboard
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(prefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var dashboard models.Dashboard
+				if err := json.Unmarshal(val, &dashboard); err != nil {
+					return err
+				}
+				dashboards = append(dashboards, &dashboard)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return dashboards, err
+}
+
+// DeleteDashboard deletes a dashboard and its versions
+func (s *BadgerStore) DeleteDashboard(id string) error {
+	versions, err := s.GetDashboardVersions(id)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete([]byte(fmt.Sprintf("dashboard:%s", id))); err != nil {
+			return err
+		}
+		for _, version := range versions {
+			if err := txn.Delete(dashboardVersionKey(id, version.Version)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+const tenantUsagePrefix = "tenantusage:"
+
+// tenantUsageKey orders records by day, then tenant
+func tenantUsageKey(day, tenant string) []byte {
+	return []byte(tenantUsagePrefix + day + ":" + tenant)
+}
+
+// SaveTenantUsage saves a tenant's usage on a day
+func (s *BadgerStore) SaveTenantUsage(usage *models.TenantUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(tenantUsageKey(usage.Day, usage.Tenant), data)
+	})
+}
+
+// ListTenantUsage lists the usage records of the days from and to, either
+// of which may be empty, ordered by day and tenant
+func (s *BadgerStore) ListTenantUsage(from, to string) ([]*models.TenantUsage, error) {
+	var records []*models.TenantUsage
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(tenantUsagePrefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek([]byte(tenantUsagePrefix + from)); it.Valid(); it.Next() {
+			var usage models.TenantUsage
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &usage)
+			})
+			if err != nil {
+				return err
+			}
+			if to != "" && usage.Day > to {
+				break
+			}
+			records = append(records, &usage)
+		}
+
+		return nil
+	})
+
+	return records, err
+}
+
+// DeleteTenantUsageBefore deletes the usage records of days before day
+func (s *BadgerStore) DeleteTenantUsageBefore(day string) (int64, error) {
+	var keys [][]byte
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(tenantUsagePrefix)
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		cutoff := []byte(tenantUsagePrefix + day)
+		for it.Rewind(); it.Valid(); it.Next() {
+			if bytes.Compare(it.Item().Key(), cutoff) >= 0 {
+				break
+			}
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+
+	return int64(len(keys)), nil
+}
+
+const nodeMonthPrefix = "nodemonth:"
+
+// nodeMonthKey orders records by month, then node
+func nodeMonthKey(month, nodeID string) []byte {
+	return []byte(nodeMonthPrefix + month + ":" + nodeID)
+}
+
+// SaveNodeMonth saves a node's aggregates for a month
+func (s *BadgerStore) SaveNodeMonth(record *models.NodeMonth) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(nodeMonthKey(record.Month, record.NodeID), data)
+	})
+}
+
+// ListNodeMonths lists the node aggregates of the months from and to,
+// either of which may be empty, ordered by month and node
+func (s *BadgerStore) ListNodeMonths(from, to string) ([]*models.NodeMonth, error) {
+	var records []*models.NodeMonth
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(nodeMonthPrefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek([]byte(nodeMonthPrefix + from)); it.Valid(); it.Next() {
+			var record models.NodeMonth
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &record)
+			})
+			if err != nil {
+				return err
+			}
+			if to != "" && record.Month > to {
+				break
+			}
+			records = append(records, &record)
+		}
+
+		return nil
+	})
+
+	return records, err
+}
+
+// DeleteNodeMonthsBefore deletes the node aggregates of months before
+// month
+func (s *BadgerStore) DeleteNodeMonthsBefore(month string) (int64, error) {
+	var keys [][]byte
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(nodeMonthPrefix)
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		cutoff := []byte(nodeMonthPrefix + month)
+		for it.Rewind(); it.Valid(); it.Next() {
+			if bytes.Compare(it.Item().Key(), cutoff) >= 0 {
+				break
+			}
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+
+	return int64(len(keys)), nil
+}
+
+// MetricArchiver receives samples before they are deleted. Close is called
+// once every sample has been archived; deletion only starts if it succeeds.
+type MetricArchiver interface {
+	Archive(metric *models.Metric) error
+	Close() error
+}
+
+// DeleteNodeMetrics deletes every sample reported by a node, archiving them
+// first when archiver is set
+func (s *BadgerStore) DeleteNodeMetrics(nodeID string, archiver MetricArchiver) (int64, error) {
+	type shardKeys struct {
+		shard  *shard
+		keys   [][]byte
+		series []string
+	}
+	var pending []shardKeys
+	var deleted int64
+
+	var err error
+	for _, sh := range s.shardsFor(beginningOfTime, endOfTime) {
+		err = s.withShard(sh, func(db *badger.DB) error {
+			keys, series, n, err := s.collectNodeSamples(db, nodeID, archiver)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, shardKeys{sh, keys, series})
+			deleted += n
+			return nil
+		})
+		if err != nil {
+			break
+		}
+	}
+	if archiver != nil {
+		if closeErr := archiver.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close archive: %w", closeErr)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range pending {
+		if err := s.withShard(p.shard, func(db *badger.DB) error {
+			if err := deleteKeys(db, p.keys); err != nil {
+				return err
+			}
+			if p.shard == nil {
+				return nil
+			}
+			for _, id := range p.series {
+				p.shard.series.Delete(id)
+			}
+			return nil
+		}); err != nil {
+			return 0, fmt.Errorf("failed to delete node metrics: %w", err)
+		}
+	}
+
+	if err := s.deleteNodeRollups(nodeID); err != nil {
+		return 0, fmt.Errorf("failed to delete node rollups: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// collectNodeSamples returns the keys of a node's chunks and raw samples
+// in a shard, with the index entries of the series left without samples,
+// those series' IDs and how many samples they hold, archiving the samples
+// when archiver is set
+func (s *BadgerStore) collectNodeSamples(db *badger.DB, nodeID string, archiver MetricArchiver) ([][]byte, []string, int64, error) {
+	var keys [][]byte
+	var deleted int64
+	// Series are unindexed unless other nodes also wrote to them
+	series := make(map[string]*seriesEntry)
+	kept := make(map[string]bool)
+
+	err := db.View(func(txn *badger.Txn) error {
+		// Chunks hold a single series, so are deleted whole
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(chunkPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			name, ts, hash, ok := splitSeriesKey(item.Key(), chunkPrefix)
+			if !ok {
+				continue
+			}
+			id := seriesID(name, hash)
+
+			var samples []chunkSample
+			var meta *chunkMeta
+			if err := item.Value(func(val []byte) error {
+				var data []byte
+				var err error
+				if meta, data, err = decodeChunk(val); err != nil || meta.NodeID != nodeID {
+					if err == nil {
+						kept[id] = true
+					}
+					meta = nil
+					return nil
+				}
+				if archiver != nil {
+					samples, err = chunkSamples(meta, data)
+				}
+				return err
+			}); err != nil {
+				return fmt.Errorf("failed to read chunk: %w", err)
+			}
+			if meta == nil {
+				continue
+			}
+
+			for _, sample := range samples {
+				if err := archiver.Archive(meta.metric(name, sample)); err != nil {
+					return fmt.Errorf("failed to archive sample: %w", err)
+				}
+			}
+			keys = append(keys, item.KeyCopy(nil), chunkRefKey(id, ts))
+			series[id] = &seriesEntry{Name: name, Hash: hash, Labels: meta.Labels}
+			deleted += int64(meta.Count)
+		}
+
+		opts.Prefix = []byte(rawPrefix)
+		raw := txn.NewIterator(opts)
+		defer raw.Close()
+
+		for raw.Rewind(); raw.Valid(); raw.Next() {
+			item := raw.Item()
+			name, _, hash, ok := splitSeriesKey(item.Key(), rawPrefix)
+			if !ok {
+				continue
+			}
+			id := seriesID(name, hash)
+
+			metric, err := s.decodeMetric(item)
+			if err != nil {
+				continue
+			}
+			if metric.NodeID != nodeID {
+				kept[id] = true
+				continue
+			}
+
+			if archiver != nil {
+				if err := archiver.Archive(metric); err != nil {
+					return fmt.Errorf("failed to archive sample: %w", err)
+				}
+			}
+			keys = append(keys, item.KeyCopy(nil))
+			series[id] = &seriesEntry{Name: name, Hash: hash, Labels: metric.Labels}
+			deleted++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var ids []string
+	for id, e := range series {
+		if kept[id] {
+			continue
+		}
+		keys = append(keys, seriesKey(id), tombstoneKey(id))
+		keys = append(keys, e.postingKeys()...)
+		ids = append(ids, id)
+	}
+	return keys, ids, deleted, nil
+}
+
+// CompactMetricsInRange compacts metrics in a time range
+func (s *BadgerStore) CompactMetricsInRange(start, end time.Time) error {
+	// Placeholder for compaction logic
+	// In a real implementation, this would downsample or compress metrics
+	s.logger.Debug("Compacting metrics",
+		zap.Time("start", start),
+		zap.Time("end", end),
+	)
+	return nil
+}
+
+// RunGC runs value log garbage collection on the main database and the
+// shards that are open. Older shards are rarely rewritten and are dropped
+// whole.
+func (s *BadgerStore) RunGC() error {
+	for _, sh := range s.shards.openShards() {
+		if err := sh.db.RunValueLogGC(0.5); err != nil && err != badger.ErrNoRewrite {
+			s.logger.Warn("Failed to run shard GC", zap.String("shard", sh.dir), zap.Error(err))
+		}
+		s.shards.release(sh)
+	}
+	return s.db.RunValueLogGC(0.5)
+}
+
+// ListSeries returns every series with samples in the given time range
+func (s *BadgerStore) ListSeries(start, end time.Time) ([]*models.SeriesInfo, error) {
+	seriesMap := make(map[string]*models.SeriesInfo)
+
+	err := s.scanSamples("", start, end.Add(1), func(metric *models.Metric, hash string, size int64) error {
+		key := metric.Name + "|" + hash
+		info, exists := seriesMap[key]
+		if !exists {
+			info = &models.SeriesInfo{
+				Name:      metric.Name,
+				Labels:    metric.Labels,
+				FirstSeen: metric.Timestamp,
+				LastSeen:  metric.Timestamp,
+			}
+			seriesMap[key] = info
+		}
+
+		info.Samples++
+		info.Bytes += size
+		if metric.Timestamp.Before(info.FirstSeen) {
+			info.FirstSeen = metric.Timestamp
+		}
+		if metric.Timestamp.After(info.LastSeen) {
+			info.LastSeen = metric.Timestamp
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list series: %w", err)
+	}
+
+	series := make([]*models.SeriesInfo, 0, len(seriesMap))
+	for _, info := range seriesMap {
+		series = append(series, info)
+	}
+
+	return series, nil
+}
+
+// EstimateUsage attributes stored bytes to metric names and nodes. Metric
+// names come from the keys, so every sample is counted; node IDs are only
+// stored in values, so one value in sampleRate is read and scaled up.
+func (s *BadgerStore) EstimateUsage(sampleRate int) (*models.StorageUsage, error) {
+	if sampleRate < 1 {
+		sampleRate = 1
+	}
+
+	started := time.Now()
+	usage := &models.StorageUsage{SampleRate: sampleRate}
+	metrics := make(map[string]*models.StorageUsageEntry)
+	nodes := make(map[string]*models.StorageUsageEntry)
+
+	entry := func(entries map[string]*models.StorageUsageEntry, name string) *models.StorageUsageEntry {
+		e, ok := entries[name]
+		if !ok {
+			e = &models.StorageUsageEntry{Name: name}
+			entries[name] = e
+		}
+		return e
+	}
+
+	// Shards are read one at a time, so only a few are open at once
+	err := s.forEachShard(beginningOfTime, endOfTime, func(db *badger.DB) error {
+		return db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte("metric:")
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			var n int
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				size := item.EstimatedSize()
+
+				name := metricNameFromKey(item.Key())
+				m := entry(metrics, name)
+				m.Samples++
+				m.Bytes += size
+				usage.Samples++
+				usage.Bytes += size
+
+				n++
+				if n%sampleRate != 0 {
+					continue
+				}
+
+				var data *sampleValue
+				if err := item.Value(func(val []byte) error {
+					var err error
+					data, err = decodeSampleValue(val, true)
+					return err
+				}); err != nil {
+					continue
+				}
+
+				node := entry(nodes, data.NodeID)
+				node.Samples += int64(sampleRate)
+				node.Bytes += size * int64(sampleRate)
+			}
+
+			// Chunks are few and each holds one series, so every one is read
+			opts.Prefix = []byte(chunkPrefix)
+			opts.PrefetchValues = true
+			chunks := txn.NewIterator(opts)
+			defer chunks.Close()
+
+			for chunks.Rewind(); chunks.Valid(); chunks.Next() {
+				item := chunks.Item()
+				name, _, _, ok := splitSeriesKey(item.Key(), chunkPrefix)
+				if !ok {
+					continue
+				}
+
+				var meta *chunkMeta
+				if err := item.Value(func(val []byte) error {
+					var err error
+					meta, _, err = decodeChunk(val)
+					return err
+				}); err != nil {
+					continue
+				}
+
+				size := item.EstimatedSize()
+				for _, e := range []*models.StorageUsageEntry{entry(metrics, name), entry(nodes, meta.NodeID)} {
+					e.Samples += int64(meta.Count)
+					e.Bytes += size
+				}
+				usage.Samples += int64(meta.Count)
+				usage.Bytes += size
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate storage usage: %w", err)
+	}
+
+	usage.LSMBytes, usage.ValueLogBytes = s.db.Size()
+	for _, sh := range s.shards.overlapping(beginningOfTime, endOfTime) {
+		lsm, vlog := shardDiskUsage(sh.dir)
+		usage.LSMBytes += lsm
+		usage.ValueLogBytes += vlog
+	}
+	usage.DiskBytes = usage.LSMBytes + usage.ValueLogBytes
+	usage.Metrics = usageEntries(metrics, usage.Bytes, usage.DiskBytes)
+	usage.Nodes = usageEntries(nodes, usage.Bytes, usage.DiskBytes)
+	usage.GeneratedAt = time.Now()
+	usage.Duration = usage.GeneratedAt.Sub(started).String()
+
+	return usage, nil
+}
+
+// usageEntries converts stored bytes into a share of the on-disk size,
+// largest first
+func usageEntries(entries map[string]*models.StorageUsageEntry, total, disk int64) []*models.StorageUsageEntry {
+	// Badger refreshes its size counters periodically; until then use the
+	// stored bytes as the best estimate
+	if disk <= 0 {
+		disk = total
+	}
+
+	list := make([]*models.StorageUsageEntry, 0, len(entries))
+	for _, e := range entries {
+		if total > 0 {
+			share := float64(e.Bytes) / float64(total)
+			e.Percent = share * 100
+			e.EstimatedDiskBytes = int64(share * float64(disk))
+		}
+		list = append(list, e)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Bytes != list[j].Bytes {
+			return list[i].Bytes > list[j].Bytes
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// metricNameFromKey extracts the name from a "metric:name:timestamp:hash" key
+func metricNameFromKey(key []byte) string {
+	rest := bytes.TrimPrefix(key, []byte("metric:"))
+	for i := 0; i < 2; i++ {
+		if idx := bytes.LastIndexByte(rest, ':'); idx >= 0 {
+			rest = rest[:idx]
+		}
+	}
+	return string(rest)
+}
+
+// GetStats returns database statistics
+func (s *BadgerStore) GetStats() (*DBStats, error) {
+	stats := &DBStats{
+		OldestMetric: time.Now(),
+		NewestMetric: time.Time{},
+	}
+
+	// Count samples, one shard at a time
+	s.forEachShard(beginningOfTime, endOfTime, func(db *badger.DB) error {
+		return db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte("metric:")
+			it := txn.NewIterator(opts)
+			for it.Rewind(); it.Valid(); it.Next() {
+				stats.TotalMetrics++
+			}
+			it.Close()
+
+			// Count samples in chunks
+			opts.Prefix = []byte(chunkPrefix)
+			it = txn.NewIterator(opts)
+			for it.Rewind(); it.Valid(); it.Next() {
+				it.Item().Value(func(val []byte) error {
+					meta, _, err := decodeChunk(val)
+					if err == nil {
+						stats.TotalMetrics += int64(meta.Count)
+					}
+					return nil
+				})
+			}
+			it.Close()
+			return nil
+		})
+	})
+
+	// Count nodes and alerts
+	s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		// Count nodes
+		opts.Prefix = []byte("node:")
+		it := txn.NewIterator(opts)
+		for it.Rewind(); it.Valid(); it.Next() {
+			stats.TotalNodes++
+		}
+		it.Close()
+
+		// Count alerts
+		opts.Prefix = []byte("alert:")
+		it = txn.NewIterator(opts)
+		for it.Rewind(); it.Valid(); it.Next() {
+			stats.TotalAlerts++
+		}
+		it.Close()
+
+		return nil
+	})
+
+	return stats, nil
+}
+
+func (s *BadgerStore) Close() error {
+	err := s.shards.close()
+	if s.cold != nil {
+		if cerr := s.cold.cache.close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		s.db.Close()
+		return err
+	}
+	return s.db.Close()
+}
+
+// ParseQuery splits a selector like `name{label="value"}` into the metric
+// name and its label filters
+func ParseQuery(query string) (string, map[string]string) {
+	return parseSimpleQuery(query)
+}
+
+// Helper functions
+func parseSimpleQuery(query string) (string, map[string]string) {
+	// Simple parser for queries like "metric_name{label1="value1",label2="value2"}"
+	parts := strings.SplitN(query, "{", 2)
+	metricName := strings.TrimSpace(parts[0])
+	
+	filters := make(map[string]string)
+	
+	if len(parts) > 1 {
+		filterStr := strings.TrimSuffix(parts[1], "}")
+		pairs := strings.Split(filterStr, ",")
+		
+		for _, pair := range pairs {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) == 2 {
+				key := strings.TrimSpace(kv[0])
+				value := strings.Trim(strings.TrimSpace(kv[1]), "\"")
+				filters[key] = value
+			}
+		}
+	}
+	
+	return metricName, filters
+}
+
+type badgerLogger struct {
+	logger *zap.Logger
+	// quiet logs Badger's info messages at debug level
+	quiet bool
+}
+
+func (l *badgerLogger) Errorf(f string, v ...interface{}) {
+	l.logger.Error(fmt.Sprintf(f, v...))
+}
+
+func (l *badgerLogger) Warningf(f string, v ...interface{}) {
+	l.logger.Warn(fmt.Sprintf(f, v...))
 }
 
 func (l *badgerLogger) Infof(f string, v ...interface{}) {
+	if l.quiet {
+		l.logger.Debug(fmt.Sprintf(f, v...))
+		return
+	}
 	l.logger.Info(fmt.Sprintf(f, v...))
 }
 
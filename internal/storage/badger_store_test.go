@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+func openTestStore(t *testing.T) *BadgerStore {
+	t.Helper()
+	config := &utils.StorageConfig{
+		Path:             t.TempDir(),
+		ValueLogFileSize: 1 << 20,
+		MemTableSize:     16 << 20,
+	}
+	config.Chunks.Duration = 2 * time.Hour
+	config.Shards.BlockDuration = 2 * time.Hour
+	config.Shards.MaxOpen = 4
+
+	store, err := NewBadgerStore(config, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestEstimateUsageAttributesSamplesToNodes(t *testing.T) {
+	store := openTestStore(t)
+
+	now := time.Now().Truncate(time.Second)
+	var metrics []*models.Metric
+	for i := 0; i < 10; i++ {
+		node := "node-a"
+		if i%5 == 0 {
+			node = "node-b"
+		}
+		metrics = append(metrics, &models.Metric{
+			Name:      "cpu_usage",
+			Value:     float64(i),
+			Timestamp: now.Add(time.Duration(i) * time.Second),
+			Labels:    map[string]string{"cpu": "0"},
+			NodeID:    node,
+			Type:      models.MetricTypeGauge,
+		})
+	}
+	if err := store.WriteMetrics(metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := store.EstimateUsage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Samples != 10 {
+		t.Fatalf("got %d samples, want 10", usage.Samples)
+	}
+
+	samples := make(map[string]int64)
+	for _, n := range usage.Nodes {
+		samples[n.Name] = n.Samples
+	}
+	if samples["node-a"] != 8 || samples["node-b"] != 2 || len(samples) != 2 {
+		t.Fatalf("got node samples %v, want node-a 8 and node-b 2", samples)
+	}
+}
@@ -0,0 +1,481 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// Chunk keys are "chunk:<name>:<chunk start>:<labels hash>", like raw
+	// sample keys
+	chunkPrefix = "chunk:"
+	// compressedPrefix holds gzipped JSON batches written by earlier
+	// versions, which are migrated into chunks
+	compressedPrefix = "compressed:"
+	// chunkSpanKey records the longest chunk duration ever used, so reads
+	// look far enough back after the duration is changed
+	chunkSpanKey = "chunkspan"
+	// chunkBatchSamples bounds the samples sealed per write batch
+	chunkBatchSamples = 100000
+)
+
+// chunkMeta is the header of a chunk. Times are in milliseconds.
+type chunkMeta struct {
+	Labels  map[string]string `json:"l,omitempty"`
+	NodeID  string            `json:"n"`
+	Type    string            `json:"t"`
+	Help    string            `json:"h,omitempty"`
+	Unit    string            `json:"u,omitempty"`
+	Count   int               `json:"c"`
+	MinTime int64             `json:"mn"`
+	MaxTime int64             `json:"mx"`
+}
+
+type chunkSample struct {
+	t int64
+	v float64
+}
+
+// encodeChunk encodes samples, which must be sorted by time, as the
+// length of the JSON header, the header and the Gorilla stream
+func encodeChunk(meta chunkMeta, samples []chunkSample) ([]byte, error) {
+	var enc gorillaEncoder
+	for _, s := range samples {
+		enc.append(s.t, s.v)
+	}
+	meta.Count = len(samples)
+	meta.MinTime = samples[0].t
+	meta.MaxTime = samples[len(samples)-1].t
+
+	header, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	data := binary.AppendUvarint(nil, uint64(len(header)))
+	data = append(data, header...)
+	return append(data, enc.bytes()...), nil
+}
+
+// decodeChunk splits a chunk into its header and Gorilla stream
+func decodeChunk(val []byte) (*chunkMeta, []byte, error) {
+	size, n := binary.Uvarint(val)
+	if n <= 0 || uint64(len(val)-n) < size {
+		return nil, nil, fmt.Errorf("invalid chunk header")
+	}
+	var meta chunkMeta
+	if err := json.Unmarshal(val[n:n+int(size)], &meta); err != nil {
+		return nil, nil, fmt.Errorf("invalid chunk header: %w", err)
+	}
+	meta.Labels = utils.Symbols.InternLabels(meta.Labels)
+	meta.NodeID = utils.Symbols.Intern(meta.NodeID)
+	return &meta, val[n+int(size):], nil
+}
+
+// chunkSamples decodes every sample of a chunk
+func chunkSamples(meta *chunkMeta, data []byte) ([]chunkSample, error) {
+	dec := newGorillaDecoder(data, meta.Count)
+	samples := make([]chunkSample, 0, meta.Count)
+	for {
+		t, v, ok, err := dec.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return samples, nil
+		}
+		samples = append(samples, chunkSample{t, v})
+	}
+}
+
+// mergeSamples sorts samples by time, keeping the last of samples with the
+// same timestamp
+func mergeSamples(samples []chunkSample) []chunkSample {
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].t < samples[j].t })
+	out := samples[:0]
+	for _, s := range samples {
+		if len(out) > 0 && out[len(out)-1].t == s.t {
+			out[len(out)-1] = s
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func (m *chunkMeta) metric(name string, s chunkSample) *models.Metric {
+	return &models.Metric{
+		Name:      name,
+		Value:     s.v,
+		Timestamp: time.UnixMilli(s.t),
+		Labels:    m.Labels,
+		NodeID:    m.NodeID,
+		Type:      models.MetricTypeFromString(m.Type),
+		Help:      m.Help,
+		Unit:      m.Unit,
+	}
+}
+
+// chunkSpan returns the longest chunk duration, or zero if no chunks have
+// been written
+func (s *BadgerStore) chunkSpan() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.chunkSpanNanos))
+}
+
+func (s *BadgerStore) loadChunkSpan() error {
+	return s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(chunkSpanKey))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			span, err := strconv.ParseInt(string(val), 10, 64)
+			if err != nil {
+				return err
+			}
+			atomic.StoreInt64(&s.chunkSpanNanos, span)
+			return nil
+		})
+	})
+}
+
+// extendChunkSpan records a chunk duration if it is the longest yet
+func (s *BadgerStore) extendChunkSpan(span time.Duration) error {
+	if span <= s.chunkSpan() {
+		return nil
+	}
+	if err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(chunkSpanKey), []byte(strconv.FormatInt(int64(span), 10)))
+	}); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&s.chunkSpanNanos, int64(span))
+	return nil
+}
+
+// scanSamples calls fn for each sample of a metric in [from, to), from
+// the chunks and then the raw samples of each shard overlapping the range,
+// archived ones included. An empty name scans every metric. Each shard is read in one transaction,
+// so a sample being sealed is seen exactly once.
+func (s *BadgerStore) scanSamples(name string, from, to time.Time, fn func(metric *models.Metric, hash string, size int64) error) error {
+	// A chunk starting up to a span before from may hold samples after it
+	span := s.chunkSpan()
+	return s.forEachBlock(from.Add(-span), to, blockFilter(name, nil, from, to), func(db *badger.DB) error {
+		return db.View(func(txn *badger.Txn) error {
+			return s.scanShard(txn, name, from, to, span, fn)
+		})
+	})
+}
+
+func (s *BadgerStore) scanShard(txn *badger.Txn, name string, from, to time.Time, span time.Duration, fn func(metric *models.Metric, hash string, size int64) error) error {
+	fromNano, toNano := from.UnixNano(), to.UnixNano()
+
+	if span > 0 {
+		err := scanRange(txn, chunkPrefix, name, from.Add(-span), to, func(item *badger.Item, name string, _ int64, hash string) error {
+			return s.scanChunk(item, name, hash, fromNano, toNano, fn)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanRange(txn, rawPrefix, name, from, to, func(item *badger.Item, _ string, _ int64, hash string) error {
+		metric, err := s.decodeMetric(item)
+		if err != nil {
+			s.logger.Warn("Failed to decode metric", zap.Error(err))
+			return nil
+		}
+		return fn(metric, hash, item.EstimatedSize())
+	})
+}
+
+// scanChunk calls fn for each sample of a chunk in [fromNano, toNano)
+func (s *BadgerStore) scanChunk(item *badger.Item, name, hash string, fromNano, toNano int64, fn func(metric *models.Metric, hash string, size int64) error) error {
+	return item.Value(func(val []byte) error {
+		meta, data, err := decodeChunk(val)
+		if err != nil {
+			s.logger.Warn("Failed to decode chunk", zap.String("key", string(item.Key())), zap.Error(err))
+			return nil
+		}
+		if meta.MaxTime*int64(time.Millisecond) < fromNano || meta.MinTime*int64(time.Millisecond) >= toNano {
+			return nil
+		}
+		samples, err := chunkSamples(meta, data)
+		if err != nil {
+			s.logger.Warn("Failed to decode chunk", zap.String("key", string(item.Key())), zap.Error(err))
+			return nil
+		}
+
+		size := item.EstimatedSize() / int64(len(samples))
+		for _, sample := range samples {
+			ts := sample.t * int64(time.Millisecond)
+			if ts < fromNano || ts >= toNano {
+				continue
+			}
+			if err := fn(meta.metric(name, sample), hash, size); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ChunkCompactor seals samples into Gorilla-encoded chunks in the
+// background, and migrates samples stored by earlier versions
+type ChunkCompactor struct {
+	config *utils.StorageConfig
+	store  *BadgerStore
+	logger *zap.Logger
+	legacy *CompressionEngine
+}
+
+// NewChunkCompactor creates a chunk compactor
+func NewChunkCompactor(config *utils.StorageConfig, store *BadgerStore, logger *zap.Logger) (*ChunkCompactor, error) {
+	if err := store.extendChunkSpan(config.Chunks.Duration); err != nil {
+		return nil, fmt.Errorf("failed to save chunk duration: %w", err)
+	}
+	return &ChunkCompactor{
+		config: config,
+		store:  store,
+		logger: logger,
+		legacy: NewCompressionEngine(config, logger),
+	}, nil
+}
+
+// errBatchFull stops a scan once a batch has enough samples
+var errBatchFull = errors.New("batch full")
+
+// Run seals every raw sample in chunks that ended at least Delay ago,
+// merging late samples into chunks already written
+func (cc *ChunkCompactor) Run() error {
+	if err := cc.migrateCompressed(); err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-cc.config.Chunks.Delay).Truncate(cc.config.Chunks.Duration)
+	var sealed int
+	for _, sh := range cc.store.shards.overlapping(beginningOfTime, cutoff) {
+		n, err := cc.sealShard(sh, cutoff)
+		sealed += n
+		if err != nil {
+			return fmt.Errorf("failed to seal chunks: %w", err)
+		}
+	}
+
+	if sealed > 0 {
+		cc.logger.Debug("Sealed samples into chunks",
+			zap.Int("samples", sealed),
+			zap.Time("before", cutoff),
+		)
+	}
+	return nil
+}
+
+// sealShard seals a shard's raw samples before the cutoff. A shard that
+// ends by the cutoff is then marked sealed, and is not opened again until
+// samples are written to it.
+func (cc *ChunkCompactor) sealShard(sh *shard, cutoff time.Time) (int, error) {
+	shards := cc.store.shards
+	sealed, writes, idle := shards.sealState(sh)
+	if sealed {
+		return 0, nil
+	}
+
+	var total int
+	err := cc.store.withShard(sh, func(db *badger.DB) error {
+		for {
+			n, err := cc.seal(db, cutoff)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return nil
+			}
+			total += n
+		}
+	})
+	if err != nil {
+		return total, err
+	}
+
+	// Samples written while sealing may have been missed
+	if idle && !sh.end.After(cutoff) {
+		return total, shards.markSealed(sh, writes)
+	}
+	return total, nil
+}
+
+// seal moves up to chunkBatchSamples raw samples of a shard before the
+// cutoff into chunks, returning how many were moved
+func (cc *ChunkCompactor) seal(db *badger.DB, cutoff time.Time) (int, error) {
+	type pendingChunk struct {
+		ref     []byte
+		meta    chunkMeta
+		samples []chunkSample
+		rawKeys [][]byte
+	}
+	pending := make(map[string]*pendingChunk)
+	var count int
+
+	err := db.View(func(txn *badger.Txn) error {
+		err := scanRange(txn, rawPrefix, "", time.Unix(0, 0), cutoff, func(item *badger.Item, name string, ts int64, hash string) error {
+			if count >= chunkBatchSamples {
+				return errBatchFull
+			}
+			metric, err := cc.store.decodeMetric(item)
+			if err != nil {
+				return nil
+			}
+
+			start := bucketOf(ts, cc.config.Chunks.Duration)
+			key := string(chunkKey(name, start, hash))
+			chunk, ok := pending[key]
+			if !ok {
+				chunk = &pendingChunk{ref: chunkRefKey(seriesID(name, hash), start), meta: chunkMeta{
+					Labels: metric.Labels,
+					NodeID: metric.NodeID,
+					Type:   metric.Type.String(),
+					Help:   metric.Help,
+					Unit:   metric.Unit,
+				}}
+				pending[key] = chunk
+			}
+			chunk.samples = append(chunk.samples, chunkSample{metric.Timestamp.UnixMilli(), metric.Value})
+			chunk.rawKeys = append(chunk.rawKeys, item.KeyCopy(nil))
+			count++
+			return nil
+		})
+		if err != nil && err != errBatchFull {
+			return err
+		}
+
+		// Late samples and batches that split a chunk are merged into the
+		// chunk already written
+		for key, chunk := range pending {
+			item, err := txn.Get([]byte(key))
+			if err == badger.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := item.Value(func(val []byte) error {
+				meta, data, err := decodeChunk(val)
+				if err != nil {
+					return err
+				}
+				samples, err := chunkSamples(meta, data)
+				if err != nil {
+					return err
+				}
+				chunk.samples = append(samples, chunk.samples...)
+				return nil
+			}); err != nil {
+				cc.logger.Warn("Replacing unreadable chunk", zap.String("key", key), zap.Error(err))
+			}
+		}
+		return nil
+	})
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	// The chunk is written before its raw samples are deleted; a sample
+	// left behind by a crash is merged again as a duplicate of itself
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for key, chunk := range pending {
+		value, err := encodeChunk(chunk.meta, mergeSamples(chunk.samples))
+		if err != nil {
+			return 0, err
+		}
+		if err := wb.Set([]byte(key), value); err != nil {
+			return 0, err
+		}
+		if err := wb.Set(chunk.ref, nil); err != nil {
+			return 0, err
+		}
+		for _, rawKey := range chunk.rawKeys {
+			if err := wb.Delete(rawKey); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// migrateCompressed rewrites gzipped JSON batches written by earlier
+// versions as raw samples, which are then sealed like any others
+func (cc *ChunkCompactor) migrateCompressed() error {
+	var keys [][]byte
+	err := cc.store.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(compressedPrefix)
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list compressed batches: %w", err)
+	}
+
+	var migrated, failed int
+	for _, key := range keys {
+		var metrics []*models.Metric
+		err := cc.store.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(key)
+			if err != nil {
+				return err
+			}
+			return item.Value(func(val []byte) error {
+				metrics, err = cc.legacy.DecompressMetrics(&CompressedMetrics{Data: val})
+				return err
+			})
+		})
+		if err != nil {
+			// Kept, so the batch is not lost
+			failed++
+			continue
+		}
+
+		if err := cc.store.WriteMetrics(metrics); err != nil {
+			return fmt.Errorf("failed to migrate compressed batch: %w", err)
+		}
+		if err := cc.store.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(key)
+		}); err != nil {
+			return fmt.Errorf("failed to delete migrated batch: %w", err)
+		}
+		migrated++
+	}
+
+	if migrated > 0 || failed > 0 {
+		cc.logger.Info("Migrated compressed batches",
+			zap.Int("migrated", migrated),
+			zap.Int("failed", failed),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// sampleCodecV1 starts a binary raw sample value. Values written before it
+// are JSON objects, which start with '{', and are still read.
+//
+// A binary value is the marker, the value as 8 little-endian bytes, the
+// metric type, then the node ID, help and unit as length-prefixed strings
+// and the label count followed by each label's name and value, sorted.
+const sampleCodecV1 = 0x01
+
+// sampleValue is the decoded value of a raw sample
+type sampleValue struct {
+	Value  float64           `json:"v"`
+	Labels map[string]string `json:"l"`
+	NodeID string            `json:"n"`
+	Type   string            `json:"t"`
+	Help   string            `json:"h"`
+	Unit   string            `json:"u"`
+}
+
+// writeArena holds the keys and values of one write transaction, which
+// Badger references until it commits, in a buffer reused across writes
+type writeArena struct {
+	buf []byte
+	// keys sorts label names while encoding
+	keys []string
+}
+
+var writeArenas = sync.Pool{
+	New: func() interface{} { return &writeArena{buf: make([]byte, 0, 64<<10)} },
+}
+
+func getWriteArena() *writeArena {
+	return writeArenas.Get().(*writeArena)
+}
+
+// putWriteArena returns an arena once the transaction using it has
+// committed or been discarded
+func putWriteArena(a *writeArena) {
+	// Keep arenas grown by an unusually large batch out of the pool
+	if cap(a.buf) > 16<<20 {
+		return
+	}
+	a.buf = a.buf[:0]
+	a.keys = a.keys[:0]
+	writeArenas.Put(a)
+}
+
+// slice returns the bytes appended since start. Later appends may move the
+// buffer, but never change bytes already returned.
+func (a *writeArena) slice(start int) []byte {
+	return a.buf[start:len(a.buf):len(a.buf)]
+}
+
+// metricKey appends the raw sample key of a metric with its labels hash
+func (a *writeArena) metricKey(metric *models.Metric, labelsHash string) []byte {
+	start := len(a.buf)
+	a.buf = append(a.buf, rawPrefix...)
+	a.buf = append(a.buf, metric.Name...)
+	a.buf = append(a.buf, ':')
+	a.buf = strconv.AppendInt(a.buf, metric.Timestamp.UnixNano(), 10)
+	a.buf = append(a.buf, ':')
+	a.buf = append(a.buf, labelsHash...)
+	return a.slice(start)
+}
+
+// sampleValue appends the binary raw sample value of a metric
+func (a *writeArena) sampleValue(metric *models.Metric) []byte {
+	start := len(a.buf)
+	a.buf = append(a.buf, sampleCodecV1)
+	a.buf = binary.LittleEndian.AppendUint64(a.buf, math.Float64bits(metric.Value))
+	a.buf = append(a.buf, byte(metric.Type))
+	a.buf = appendString(a.buf, metric.NodeID)
+	a.buf = appendString(a.buf, metric.Help)
+	a.buf = appendString(a.buf, metric.Unit)
+
+	a.keys = a.keys[:0]
+	for k := range metric.Labels {
+		a.keys = append(a.keys, k)
+	}
+	sort.Strings(a.keys)
+	a.buf = binary.AppendUvarint(a.buf, uint64(len(a.keys)))
+	for _, k := range a.keys {
+		a.buf = appendString(a.buf, k)
+		a.buf = appendString(a.buf, metric.Labels[k])
+	}
+	return a.slice(start)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decodeSampleValue decodes a raw sample value in either format. With
+// labelsOnly set, only the node ID and labels are decoded.
+func decodeSampleValue(val []byte, labelsOnly bool) (*sampleValue, error) {
+	var data sampleValue
+	if len(val) == 0 || val[0] != sampleCodecV1 {
+		if err := json.Unmarshal(val, &data); err != nil {
+			return nil, err
+		}
+		data.Labels = utils.Symbols.InternLabels(data.Labels)
+		data.NodeID = utils.Symbols.Intern(data.NodeID)
+		return &data, nil
+	}
+
+	d := sampleDecoder{val: val[1:]}
+	bits := d.fixed64()
+	typ := d.byte()
+	data.NodeID = d.symbol()
+	if labelsOnly {
+		d.skipString()
+		d.skipString()
+	} else {
+		data.Value = math.Float64frombits(bits)
+		data.Type = models.MetricType(typ).String()
+		data.Help = d.symbol()
+		data.Unit = d.symbol()
+	}
+	if n := d.uvarint(); n > 0 && d.err == nil {
+		// Each label takes at least two bytes
+		if n > uint64(len(d.val))/2 {
+			return nil, fmt.Errorf("invalid sample value: %d labels", n)
+		}
+		data.Labels = make(map[string]string, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			k := d.symbol()
+			data.Labels[k] = d.symbol()
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &data, nil
+}
+
+// sampleDecoder reads a binary sample value, recording the first error
+type sampleDecoder struct {
+	val []byte
+	err error
+}
+
+var errShortSample = errors.New("invalid sample value: truncated")
+
+func (d *sampleDecoder) fixed64() uint64 {
+	if len(d.val) < 8 {
+		d.fail()
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.val)
+	d.val = d.val[8:]
+	return v
+}
+
+func (d *sampleDecoder) byte() byte {
+	if len(d.val) < 1 {
+		d.fail()
+		return 0
+	}
+	b := d.val[0]
+	d.val = d.val[1:]
+	return b
+}
+
+func (d *sampleDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.val)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.val = d.val[n:]
+	return v
+}
+
+// symbol reads a length-prefixed string as an interned symbol, which also
+// copies it out of the value's buffer Badger reuses once the read returns
+func (d *sampleDecoder) symbol() string {
+	n := d.uvarint()
+	if d.err != nil || n > uint64(len(d.val)) {
+		d.fail()
+		return ""
+	}
+	s := utils.Symbols.InternBytes(d.val[:n])
+	d.val = d.val[n:]
+	return s
+}
+
+func (d *sampleDecoder) skipString() {
+	n := d.uvarint()
+	if d.err != nil || n > uint64(len(d.val)) {
+		d.fail()
+		return
+	}
+	d.val = d.val[n:]
+}
+
+func (d *sampleDecoder) fail() {
+	if d.err == nil {
+		d.err = errShortSample
+	}
+	d.val = nil
+}
@@ -0,0 +1,527 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// archivePrefix keys the index of archived blocks in the main database
+	archivePrefix = "archive:"
+	// archiveIndexObject mirrors the index in the cold tier, for restoring
+	// without the server's database
+	archiveIndexObject = "index.json"
+	// coldCacheDir holds archived blocks restored for queries
+	coldCacheDir = "cold-cache"
+	// restoreMaxPendingWrites bounds the memory used loading a backup
+	restoreMaxPendingWrites = 256
+)
+
+// ArchivedBlock is a shard exported to the cold tier as a gzipped Badger
+// backup
+type ArchivedBlock struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Object     string    `json:"object"`
+	Size       int64     `json:"size"`
+	ArchivedAt time.Time `json:"archived_at"`
+	// Summary lets queries skip the block without restoring it
+	Summary *blockSummary `json:"summary,omitempty"`
+}
+
+func (b *ArchivedBlock) name() string {
+	return shardDirName(b.Start, b.End)
+}
+
+// objectStore is where cold blocks are archived: an S3Client, or a
+// dirStore for cold_path
+type objectStore interface {
+	Key(name string) string
+	PutObject(ctx context.Context, key string, data []byte, contentType string) error
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	DeleteObject(ctx context.Context, key string) error
+}
+
+// newObjectStore returns the object store of the cold tier
+func newObjectStore(config *utils.StorageConfig) (objectStore, error) {
+	if config.Tiering.S3.Bucket != "" {
+		return NewS3Client(config.Tiering.S3)
+	}
+	return &dirStore{dir: config.Tiering.ColdPath}, nil
+}
+
+// dirStore keeps objects as files under a directory, such as a network
+// mount
+type dirStore struct {
+	dir string
+}
+
+func (d *dirStore) Key(name string) string {
+	return name
+}
+
+func (d *dirStore) PutObject(ctx context.Context, key string, data []byte, contentType string) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d *dirStore) GetObject(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(d.dir, filepath.FromSlash(key)))
+}
+
+func (d *dirStore) DeleteObject(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(d.dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// coldTier tracks the blocks archived to the object store and restores
+// them into a cache of shards for queries
+type coldTier struct {
+	objects   objectStore
+	cache     *shardSet
+	maxCached int
+	logger    *zap.Logger
+
+	// mu guards blocks, sorted by start, and serializes restores
+	mu     sync.Mutex
+	blocks []*ArchivedBlock
+}
+
+// newColdTier loads the index of archived blocks and the cache of those
+// already restored
+func (s *BadgerStore) newColdTier() (*coldTier, error) {
+	objects, err := newObjectStore(s.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure the cold tier: %w", err)
+	}
+
+	dir := filepath.Join(s.config.Path, coldCacheDir)
+	cache, err := newShardSet(dir, s.config.Shards.BlockDuration, s.config.Shards.MaxOpen, s.shardOptions, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	removeIncompleteRestores(dir)
+
+	c := &coldTier{
+		objects:   objects,
+		cache:     cache,
+		maxCached: s.config.Tiering.CacheBlocks,
+		logger:    s.logger,
+	}
+	err = s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(archivePrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var block ArchivedBlock
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &block)
+			})
+			if err != nil {
+				return err
+			}
+			c.blocks = append(c.blocks, &block)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the archive index: %w", err)
+	}
+	sort.Slice(c.blocks, func(i, j int) bool { return c.blocks[i].Start.Before(c.blocks[j].Start) })
+	return c, nil
+}
+
+// removeIncompleteRestores deletes restores interrupted by a crash
+func removeIncompleteRestores(dir string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	for _, match := range matches {
+		os.RemoveAll(match)
+	}
+}
+
+// archived returns the archived block [start, end), or nil
+func (c *coldTier) archived(start, end time.Time) *ArchivedBlock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, block := range c.blocks {
+		if block.Start.Equal(start) && block.End.Equal(end) {
+			return block
+		}
+	}
+	return nil
+}
+
+// overlapping returns the archived blocks overlapping [from, to)
+func (c *coldTier) overlapping(from, to time.Time) []*ArchivedBlock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var blocks []*ArchivedBlock
+	for _, block := range c.blocks {
+		if block.Start.Before(to) && block.End.After(from) {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// open returns the cached shard of an archived block, restoring it first
+// if needed. It must be released with cache.release.
+func (c *coldTier) open(ctx context.Context, block *ArchivedBlock) (*shard, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sh := c.cache.exact(block.Start, block.End, false)
+	if sh == nil {
+		started := time.Now()
+		if err := restoreBlock(ctx, c.objects, block, c.cache.dir, c.cache.options); err != nil {
+			return nil, err
+		}
+		c.logger.Info("Restored archived block",
+			zap.String("block", block.name()),
+			zap.Duration("duration", time.Since(started)),
+		)
+		sh = c.cache.exact(block.Start, block.End, true)
+	}
+	if err := c.cache.open(sh); err != nil {
+		return nil, err
+	}
+	c.cache.trim(c.maxCached)
+	return sh, nil
+}
+
+// restoreBlock downloads an archived block and loads it into a new shard
+// under dir. The shard only appears once complete.
+func restoreBlock(ctx context.Context, objects objectStore, block *ArchivedBlock, dir string, options func(dir string) badger.Options) error {
+	data, err := objects.GetObject(ctx, block.Object)
+	if err != nil {
+		return fmt.Errorf("failed to download archived block %s: %w", block.name(), err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to read archived block %s: %w", block.name(), err)
+	}
+
+	final := filepath.Join(dir, block.name())
+	tmp := final + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	db, err := badger.Open(options(tmp))
+	if err != nil {
+		return fmt.Errorf("failed to create shard for archived block %s: %w", block.name(), err)
+	}
+	if err := db.Load(gz, restoreMaxPendingWrites); err != nil {
+		db.Close()
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to load archived block %s: %w", block.name(), err)
+	}
+	if err := db.Close(); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	// Restored blocks are never written, so the chunk compactor skips them
+	if err := os.WriteFile(filepath.Join(tmp, sealedFile), nil, 0644); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if block.Summary != nil {
+		if err := saveSummary(tmp, block.Summary); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, final)
+}
+
+// forEachBlock is forEachShard followed by the archived blocks
+// overlapping [from, to) that were not read locally, restored as needed.
+// A shard archived during the scan is read from one place or the other.
+// With keep set, blocks whose summaries it rejects are skipped unopened.
+func (s *BadgerStore) forEachBlock(from, to time.Time, keep func(*blockSummary) bool, fn func(db *badger.DB) error) error {
+	read := make(map[string]bool)
+	for _, sh := range s.shardsFor(from, to) {
+		if sh != nil && keep != nil && !keep(s.shards.summaryOf(sh)) {
+			read[shardDirName(sh.start, sh.end)] = true
+			continue
+		}
+		err := s.withShard(sh, func(db *badger.DB) error {
+			if sh != nil {
+				read[shardDirName(sh.start, sh.end)] = true
+			}
+			return fn(db)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if s.cold == nil {
+		return nil
+	}
+
+	for _, block := range s.cold.overlapping(from, to) {
+		if read[block.name()] || (keep != nil && !keep(block.Summary)) {
+			continue
+		}
+		sh, err := s.cold.open(context.Background(), block)
+		if err != nil {
+			return err
+		}
+		err = fn(sh.db)
+		s.cold.cache.release(sh)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchiveShardsBefore exports the shards whose block ends before the
+// cutoff to the cold tier and drops them locally, returning how many were
+// archived. With compression, shards are archived once sealed.
+func (s *BadgerStore) ArchiveShardsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	if s.cold == nil {
+		return 0, nil
+	}
+
+	var archived int
+	for _, sh := range s.shards.overlapping(beginningOfTime, cutoff) {
+		if ctx.Err() != nil {
+			break
+		}
+		if sh.end.After(cutoff) {
+			continue
+		}
+		if s.cold.archived(sh.start, sh.end) != nil {
+			// Only written after archiving by a change of block duration
+			// or a restore into the storage path; kept until retention
+			s.logger.Warn("Shard overlaps an archived block and is kept locally", zap.String("shard", sh.dir))
+			continue
+		}
+		sealed, writes, idle := s.shards.sealState(sh)
+		if !idle || (s.config.Compression && !sealed) {
+			continue
+		}
+
+		ok, err := s.archiveShard(ctx, sh, writes)
+		if err != nil {
+			return archived, err
+		}
+		if ok {
+			archived++
+		}
+	}
+	return archived, nil
+}
+
+// archiveShard uploads a shard and drops it, unless it was written to
+// meanwhile
+func (s *BadgerStore) archiveShard(ctx context.Context, sh *shard, writes uint64) (bool, error) {
+	var buf bytes.Buffer
+	var summary *blockSummary
+	err := s.withShard(sh, func(db *badger.DB) error {
+		if summary = s.shards.summaryOf(sh).compact(); summary == nil {
+			// Still being built, or never built before indexing
+			if built, err := s.summarizeShard(db); err == nil {
+				summary = built.compact()
+			}
+		}
+		gz := gzip.NewWriter(&buf)
+		if _, err := db.Backup(gz, 0); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to export shard %s: %w", sh.dir, err)
+	}
+
+	block := &ArchivedBlock{
+		Start:      sh.start,
+		End:        sh.end,
+		Object:     s.cold.objects.Key("blocks/" + shardDirName(sh.start, sh.end) + ".badger.gz"),
+		Size:       int64(buf.Len()),
+		ArchivedAt: time.Now(),
+		Summary:    summary,
+	}
+	if err := s.cold.objects.PutObject(ctx, block.Object, buf.Bytes(), "application/gzip"); err != nil {
+		return false, fmt.Errorf("failed to upload shard %s: %w", sh.dir, err)
+	}
+
+	dropped, err := s.shards.dropUnchanged(sh, writes, func() error {
+		return s.saveArchivedBlock(block)
+	})
+	if err != nil {
+		return false, err
+	}
+	if !dropped {
+		// Uploaded again on the next run
+		return false, nil
+	}
+
+	s.logger.Info("Archived shard to the cold tier",
+		zap.String("block", block.name()),
+		zap.String("object", block.Object),
+		zap.Int64("bytes", block.Size),
+	)
+	return true, nil
+}
+
+// saveArchivedBlock adds a block to the index
+func (s *BadgerStore) saveArchivedBlock(block *ArchivedBlock) error {
+	data, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(archivePrefix+block.name()), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save archived block: %w", err)
+	}
+
+	s.cold.mu.Lock()
+	i := sort.Search(len(s.cold.blocks), func(i int) bool { return s.cold.blocks[i].Start.After(block.Start) })
+	s.cold.blocks = append(s.cold.blocks, nil)
+	copy(s.cold.blocks[i+1:], s.cold.blocks[i:])
+	s.cold.blocks[i] = block
+	s.cold.mu.Unlock()
+	return nil
+}
+
+// DeleteArchivedBefore deletes the archived blocks that end before the
+// cutoff from the cold tier, returning how many were deleted
+func (s *BadgerStore) DeleteArchivedBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	if s.cold == nil {
+		return 0, nil
+	}
+
+	var deleted int
+	for _, block := range s.cold.overlapping(beginningOfTime, cutoff) {
+		if block.End.After(cutoff) {
+			continue
+		}
+		if err := s.cold.objects.DeleteObject(ctx, block.Object); err != nil {
+			return deleted, fmt.Errorf("failed to delete archived block %s: %w", block.name(), err)
+		}
+		err := s.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete([]byte(archivePrefix + block.name()))
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete archived block %s: %w", block.name(), err)
+		}
+
+		s.cold.mu.Lock()
+		for i, other := range s.cold.blocks {
+			if other == block {
+				s.cold.blocks = append(s.cold.blocks[:i], s.cold.blocks[i+1:]...)
+				break
+			}
+		}
+		s.cold.mu.Unlock()
+		deleted++
+	}
+	s.cold.cache.drop(cutoff)
+	return deleted, nil
+}
+
+// SyncArchiveIndex writes the index of archived blocks to the cold tier,
+// where RestoreArchivedBlocks reads it
+func (s *BadgerStore) SyncArchiveIndex(ctx context.Context) error {
+	if s.cold == nil {
+		return nil
+	}
+
+	s.cold.mu.Lock()
+	data, err := json.MarshalIndent(s.cold.blocks, "", "  ")
+	s.cold.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := s.cold.objects.PutObject(ctx, s.cold.objects.Key(archiveIndexObject), data, "application/json"); err != nil {
+		return fmt.Errorf("failed to upload the archive index: %w", err)
+	}
+	return nil
+}
+
+// ListArchivedBlocks reads the index of archived blocks from the cold tier
+// configured in config
+func ListArchivedBlocks(ctx context.Context, config *utils.StorageConfig) ([]*ArchivedBlock, error) {
+	objects, err := newObjectStore(config)
+	if err != nil {
+		return nil, err
+	}
+	data, err := objects.GetObject(ctx, objects.Key(archiveIndexObject))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the archive index: %w", err)
+	}
+	var blocks []*ArchivedBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return nil, fmt.Errorf("failed to parse the archive index: %w", err)
+	}
+	return blocks, nil
+}
+
+// RestoreArchivedBlocks downloads the archived blocks overlapping
+// [from, to) into the shards directory under dir, where a server with
+// its storage path set to dir reads them. Blocks already there are
+// skipped. It returns the blocks restored.
+func RestoreArchivedBlocks(ctx context.Context, config *utils.StorageConfig, from, to time.Time, dir string) ([]*ArchivedBlock, error) {
+	blocks, err := ListArchivedBlocks(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := newObjectStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	target := filepath.Join(dir, shardsDir)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create shard directory: %w", err)
+	}
+	options := func(dir string) badger.Options {
+		return badger.DefaultOptions(dir).WithLogger(nil)
+	}
+
+	var restored []*ArchivedBlock
+	for _, block := range blocks {
+		if !block.Start.Before(to) || !block.End.After(from) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(target, block.name())); err == nil {
+			continue
+		}
+		if err := restoreBlock(ctx, objects, block, target, options); err != nil {
+			return restored, err
+		}
+		restored = append(restored, block)
+	}
+	return restored, nil
+}
@@ -12,7 +12,9 @@ import (
 	"go.uber.org/zap"
 )
 
-// CompressionEngine handles metric compression
+// CompressionEngine handles metric compression. Stored samples are
+// compressed into Gorilla chunks (see ChunkCompactor); gzipped batches are
+// only read to migrate those written by earlier versions.
 type CompressionEngine struct {
 	config *utils.StorageConfig
 	logger *zap.Logger
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Gorilla encoding, as described in "Gorilla: A Fast, Scalable, In-Memory
+// Time Series Database" (Pelkonen et al., 2015). Timestamps are stored as
+// the difference between consecutive deltas and values as the XOR with the
+// previous value, so regular samples of slowly changing values take a few
+// bits each. Timestamps are in milliseconds.
+
+// bitWriter appends bits to a byte slice, most significant bit first
+type bitWriter struct {
+	buf   []byte
+	count uint8 // bits free in the last byte
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.count == 0 {
+		w.buf = append(w.buf, 0)
+		w.count = 8
+	}
+	w.count--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.count
+	}
+}
+
+func (w *bitWriter) writeBits(value uint64, n int) {
+	for n > 0 {
+		n--
+		w.writeBit(value>>uint(n)&1 == 1)
+	}
+}
+
+// bitReader reads bits written by bitWriter
+type bitReader struct {
+	buf []byte
+	pos int // bit offset into buf
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf)*8 {
+		return false, fmt.Errorf("chunk is truncated")
+	}
+	bit := r.buf[r.pos/8]>>(7-uint(r.pos%8))&1 == 1
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var value uint64
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value <<= 1
+		if bit {
+			value |= 1
+		}
+	}
+	return value, nil
+}
+
+// dodBuckets are the sizes a delta of delta is written in, after a prefix
+// of as many 1 bits as the bucket's index plus a 0 bit. Deltas of delta
+// that fit none are written in full after a prefix of four 1 bits.
+var dodBuckets = []int{14, 17, 20}
+
+// gorillaEncoder appends samples to a Gorilla-encoded stream
+type gorillaEncoder struct {
+	w     bitWriter
+	count int
+
+	t, delta int64
+	v        uint64
+	leading  int
+	trailing int
+}
+
+func (e *gorillaEncoder) append(t int64, v float64) {
+	bitsV := math.Float64bits(v)
+
+	if e.count == 0 {
+		e.w.writeBits(uint64(t), 64)
+		e.w.writeBits(bitsV, 64)
+		e.t, e.v = t, bitsV
+		e.leading = -1
+		e.count++
+		return
+	}
+
+	delta := t - e.t
+	e.writeDoD(delta - e.delta)
+	e.writeXOR(bitsV)
+	e.t, e.delta, e.v = t, delta, bitsV
+	e.count++
+}
+
+func (e *gorillaEncoder) writeDoD(dod int64) {
+	if dod == 0 {
+		e.w.writeBit(false)
+		return
+	}
+	for i, size := range dodBuckets {
+		if dod >= -(1<<(size-1))+1 && dod <= 1<<(size-1) {
+			e.w.writeBits(1<<uint(i+2)-2, i+2)
+			e.w.writeBits(uint64(dod), size)
+			return
+		}
+	}
+	e.w.writeBits(0xf, 4)
+	e.w.writeBits(uint64(dod), 64)
+}
+
+func (e *gorillaEncoder) writeXOR(v uint64) {
+	xor := v ^ e.v
+	if xor == 0 {
+		e.w.writeBit(false)
+		return
+	}
+	e.w.writeBit(true)
+
+	leading := bits.LeadingZeros64(xor)
+	trailing := bits.TrailingZeros64(xor)
+	// The leading count is written in 5 bits
+	if leading > 31 {
+		leading = 31
+	}
+
+	// Reuse the previous window when the meaningful bits fit in it
+	if e.leading >= 0 && leading >= e.leading && trailing >= e.trailing {
+		e.w.writeBit(false)
+		e.w.writeBits(xor>>uint(e.trailing), 64-e.leading-e.trailing)
+		return
+	}
+
+	e.leading, e.trailing = leading, trailing
+	meaningful := 64 - leading - trailing
+	e.w.writeBit(true)
+	e.w.writeBits(uint64(leading), 5)
+	// 64 meaningful bits do not fit in 6 bits and are written as 0
+	e.w.writeBits(uint64(meaningful&63), 6)
+	e.w.writeBits(xor>>uint(trailing), meaningful)
+}
+
+func (e *gorillaEncoder) bytes() []byte {
+	return e.w.buf
+}
+
+// gorillaDecoder reads the samples of a Gorilla-encoded stream
+type gorillaDecoder struct {
+	r         bitReader
+	remaining int
+	read      int
+
+	t, delta int64
+	v        uint64
+	leading  int
+	trailing int
+}
+
+func newGorillaDecoder(data []byte, count int) *gorillaDecoder {
+	return &gorillaDecoder{r: bitReader{buf: data}, remaining: count}
+}
+
+// next returns the next sample, and false once all have been read
+func (d *gorillaDecoder) next() (int64, float64, bool, error) {
+	if d.remaining == 0 {
+		return 0, 0, false, nil
+	}
+	d.remaining--
+
+	if d.read == 0 {
+		t, err := d.r.readBits(64)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		v, err := d.r.readBits(64)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		d.t, d.v = int64(t), v
+		d.read++
+		return d.t, math.Float64frombits(d.v), true, nil
+	}
+
+	dod, err := d.readDoD()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	d.delta += dod
+	d.t += d.delta
+
+	if err := d.readXOR(); err != nil {
+		return 0, 0, false, err
+	}
+	d.read++
+	return d.t, math.Float64frombits(d.v), true, nil
+}
+
+func (d *gorillaDecoder) readDoD() (int64, error) {
+	prefix := 0
+	for prefix < 4 {
+		bit, err := d.r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		prefix++
+	}
+
+	switch {
+	case prefix == 0:
+		return 0, nil
+	case prefix == 4:
+		value, err := d.r.readBits(64)
+		return int64(value), err
+	}
+
+	size := dodBuckets[prefix-1]
+	value, err := d.r.readBits(size)
+	if err != nil {
+		return 0, err
+	}
+	// Sign-extend from size bits; values above the midpoint are negative,
+	// except the largest positive value, 1<<(size-1)
+	if value > 1<<uint(size-1) {
+		return int64(value) - 1<<uint(size), nil
+	}
+	return int64(value), nil
+}
+
+func (d *gorillaDecoder) readXOR() error {
+	changed, err := d.r.readBit()
+	if err != nil || !changed {
+		return err
+	}
+
+	newWindow, err := d.r.readBit()
+	if err != nil {
+		return err
+	}
+	if newWindow {
+		leading, err := d.r.readBits(5)
+		if err != nil {
+			return err
+		}
+		meaningful, err := d.r.readBits(6)
+		if err != nil {
+			return err
+		}
+		if meaningful == 0 {
+			meaningful = 64
+		}
+		d.leading = int(leading)
+		d.trailing = 64 - int(leading) - int(meaningful)
+	}
+
+	value, err := d.r.readBits(64 - d.leading - d.trailing)
+	if err != nil {
+		return err
+	}
+	d.v ^= value << uint(d.trailing)
+	return nil
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"math"
+	"testing"
+)
+
+// sameBits compares floats by their bits, so NaN equals itself and -0
+// differs from 0
+func sameBits(a, b float64) bool {
+	return math.Float64bits(a) == math.Float64bits(b)
+}
+
+func roundTrip(t *testing.T, samples []chunkSample) {
+	t.Helper()
+
+	var enc gorillaEncoder
+	for _, s := range samples {
+		enc.append(s.t, s.v)
+	}
+
+	dec := newGorillaDecoder(enc.bytes(), len(samples))
+	for i, want := range samples {
+		ts, v, ok, err := dec.next()
+		if err != nil {
+			t.Fatalf("sample %d: %v", i, err)
+		}
+		if !ok {
+			t.Fatalf("sample %d: stream ended early", i)
+		}
+		if ts != want.t || !sameBits(v, want.v) {
+			t.Fatalf("sample %d: got (%d, %v), want (%d, %v)", i, ts, v, want.t, want.v)
+		}
+	}
+	if _, _, ok, err := dec.next(); ok || err != nil {
+		t.Fatalf("expected the end of the stream, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestGorillaRoundTrip(t *testing.T) {
+	const base = int64(1_700_000_000_000)
+
+	tests := []struct {
+		name    string
+		samples []chunkSample
+	}{
+		{"single", []chunkSample{{base, 42}}},
+		{"regular", []chunkSample{{base, 1}, {base + 15000, 1}, {base + 30000, 1.5}, {base + 45000, 2}}},
+		{"special values", []chunkSample{
+			{base, math.NaN()},
+			{base + 1000, math.Inf(1)},
+			{base + 2000, math.Inf(-1)},
+			{base + 3000, math.Copysign(0, -1)},
+			{base + 4000, 0},
+			{base + 5000, math.NaN()},
+			{base + 6000, math.MaxFloat64},
+			{base + 7000, math.SmallestNonzeroFloat64},
+		}},
+		{"equal timestamps", []chunkSample{{base, 1}, {base, 2}, {base, 3}, {base + 10, 4}, {base + 10, 5}}},
+		{"out of order timestamps", []chunkSample{{base, 1}, {base - 5000, 2}, {base + 100000, 3}, {base - 1, 4}, {0, 5}, {-base, 6}}},
+		{"delta of delta buckets", []chunkSample{
+			{base, 1},
+			{base + 1<<13, 2},
+			{base + 1<<13 + 1<<16, 3},
+			{base + 1<<13 + 1<<16 + 1<<19, 4},
+			{base + 1<<13 + 1<<16 + 1<<19 + 1<<40, 5},
+			{math.MinInt64, 6},
+			{math.MaxInt64, 7},
+		}},
+		{"bucket edges", []chunkSample{
+			// Deltas of delta around the bucket limits
+			{base, 0}, {base + 1<<13, 0}, {base + 1<<13 + 1, 0}, {base + 1<<13 + 1 + 1<<16 + 1, 0},
+		}},
+		{"all 64 bits differ", []chunkSample{
+			{base, math.Float64frombits(0)},
+			{base + 1, math.Float64frombits(0x8000000000000001)},
+			{base + 2, math.Float64frombits(0xffffffffffffffff)},
+			{base + 3, math.Float64frombits(1)},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roundTrip(t, tt.samples)
+		})
+	}
+}
+
+func TestGorillaRandomWalk(t *testing.T) {
+	// A deterministic walk mixing reused and new XOR windows with
+	// irregular intervals
+	samples := make([]chunkSample, 0, 2000)
+	ts, v := int64(1_700_000_000_000), 100.0
+	x := uint64(88172645463325252)
+	for i := 0; i < cap(samples); i++ {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		ts += int64(x%30000) - 5000
+		switch x % 5 {
+		case 0:
+		case 1:
+			v = math.Round(v)
+		default:
+			v += float64(int64(x%2001)-1000) / 7
+		}
+		samples = append(samples, chunkSample{ts, v})
+	}
+	roundTrip(t, samples)
+}
+
+func TestGorillaTruncated(t *testing.T) {
+	var enc gorillaEncoder
+	for i := int64(0); i < 10; i++ {
+		enc.append(i*1000, float64(i)*1.1)
+	}
+	data := enc.bytes()
+
+	dec := newGorillaDecoder(data[:len(data)/2], 10)
+	for {
+		_, _, ok, err := dec.next()
+		if err != nil {
+			return
+		}
+		if !ok {
+			t.Fatal("expected an error decoding a truncated stream")
+		}
+	}
+}
+
+func TestChunkRoundTrip(t *testing.T) {
+	meta := chunkMeta{
+		Labels: map[string]string{"mount": "/"},
+		NodeID: "node-1",
+		Type:   "gauge",
+		Unit:   "bytes",
+	}
+	samples := []chunkSample{{1000, 1}, {2000, math.NaN()}, {3000, math.Inf(1)}}
+
+	data, err := encodeChunk(meta, samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, stream, err := decodeChunk(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Count != 3 || got.MinTime != 1000 || got.MaxTime != 3000 {
+		t.Fatalf("header: got count %d, min %d, max %d", got.Count, got.MinTime, got.MaxTime)
+	}
+	if got.NodeID != "node-1" || got.Labels["mount"] != "/" || got.Unit != "bytes" {
+		t.Fatalf("header: got %+v", got)
+	}
+
+	decoded, err := chunkSamples(got, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(samples) {
+		t.Fatalf("got %d samples, want %d", len(decoded), len(samples))
+	}
+	for i := range samples {
+		if decoded[i].t != samples[i].t || !sameBits(decoded[i].v, samples[i].v) {
+			t.Fatalf("sample %d: got %+v, want %+v", i, decoded[i], samples[i])
+		}
+	}
+
+	if _, _, err := decodeChunk(data[:2]); err == nil {
+		t.Fatal("expected an error decoding a truncated header")
+	}
+}
+
+func TestMergeSamples(t *testing.T) {
+	merged := mergeSamples([]chunkSample{{3, 3}, {1, 1}, {2, 2}, {1, 10}, {3, 30}})
+	want := []chunkSample{{1, 10}, {2, 2}, {3, 30}}
+	if len(merged) != len(want) {
+		t.Fatalf("got %v, want %v", merged, want)
+	}
+	for i := range want {
+		if merged[i] != want[i] {
+			t.Fatalf("got %v, want %v", merged, want)
+		}
+	}
+}
@@ -0,0 +1,765 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// Each shard indexes the series it holds:
+	//   series:<id>                    the series' name, labels hash and labels
+	//   postings:<label>=<value>:<id>  a series with a label value; the
+	//                                  metric name is the __name__ label
+	//   chunkref:<id>:<chunk start>    a chunk of the series
+	//   tombstone:<id>                 a series that stopped reporting, with
+	//                                  the time of its last sample; its
+	//                                  postings are removed
+	// Series IDs hash the name and labels hash, so they are known from
+	// sample keys without reading values.
+	seriesPrefix    = "series:"
+	postingsPrefix  = "postings:"
+	chunkRefPrefix  = "chunkref:"
+	tombstonePrefix = "tombstone:"
+	// indexedKey marks a shard whose series are all indexed: one created
+	// since the index was added, or one the index build has finished
+	indexedKey = "indexed"
+	// indexBuiltKey records in the main database that the shards written
+	// before the index was added have been indexed
+	indexBuiltKey = "indexbuilt"
+	// nameLabel is the label the metric name is indexed under
+	nameLabel = "__name__"
+)
+
+// seriesEntry is the value of a series key
+type seriesEntry struct {
+	Name   string            `json:"n"`
+	Hash   string            `json:"h"`
+	Labels map[string]string `json:"l,omitempty"`
+}
+
+// seriesID identifies the series of a metric name and labels hash
+func seriesID(name, hash string) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + hash))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (e *seriesEntry) id() string {
+	return seriesID(e.Name, e.Hash)
+}
+
+func seriesKey(id string) []byte {
+	return []byte(seriesPrefix + id)
+}
+
+func tombstoneKey(id string) []byte {
+	return []byte(tombstonePrefix + id)
+}
+
+func chunkKey(name string, start int64, hash string) []byte {
+	return []byte(fmt.Sprintf("%s%s:%d:%s", chunkPrefix, name, start, hash))
+}
+
+func chunkRefKey(id string, start int64) []byte {
+	return []byte(fmt.Sprintf("%s%s:%d", chunkRefPrefix, id, start))
+}
+
+// postingKeys returns the postings of a series, its name included
+func (e *seriesEntry) postingKeys() [][]byte {
+	id := e.id()
+	keys := [][]byte{[]byte(postingsPrefix + nameLabel + "=" + e.Name + ":" + id)}
+	for k, v := range e.Labels {
+		keys = append(keys, []byte(postingsPrefix+k+"="+v+":"+id))
+	}
+	return keys
+}
+
+// indexSeries writes a series and its postings with set, a transaction's
+// or write batch's Set
+func indexSeries(set func(key, value []byte) error, e *seriesEntry) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := set(seriesKey(e.id()), value); err != nil {
+		return err
+	}
+	for _, key := range e.postingKeys() {
+		if err := set(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexNew indexes the series of metrics not yet indexed in the shard
+// since it was opened, lifting their tombstones. The series returned are
+// recorded in sh.series once the transaction commits.
+func indexNew(txn *badger.Txn, sh *shard, metrics []*models.Metric, hashes []string) ([]*seriesEntry, error) {
+	var added []*seriesEntry
+	seen := make(map[string]bool)
+	for i, metric := range metrics {
+		hash := hashes[i]
+		id := seriesID(metric.Name, hash)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, ok := sh.series.Load(id); ok {
+			continue
+		}
+		e := &seriesEntry{Name: metric.Name, Hash: hash, Labels: metric.Labels}
+		if err := indexSeries(txn.Set, e); err != nil {
+			return nil, err
+		}
+		// Deleted without reading it, so tombstoning never conflicts
+		// with writes
+		if err := txn.Delete(tombstoneKey(id)); err != nil {
+			return nil, err
+		}
+		added = append(added, e)
+	}
+	return added, nil
+}
+
+// markIndexed marks a shard's series as all indexed
+func markIndexed(db *badger.DB) error {
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(indexedKey), nil)
+	})
+}
+
+// isIndexed reports whether a shard's series are all indexed
+func isIndexed(txn *badger.Txn) (bool, error) {
+	_, err := txn.Get([]byte(indexedKey))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// postings returns the IDs of the series with a label value
+func postings(txn *badger.Txn, label, value string) map[string]bool {
+	prefix := postingsPrefix + label + "=" + value + ":"
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(prefix)
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	ids := make(map[string]bool)
+	for it.Rewind(); it.Valid(); it.Next() {
+		id := string(it.Item().Key()[len(prefix):])
+		// Values that extend this one with a colon share the prefix
+		if !strings.Contains(id, ":") {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+func getSeries(txn *badger.Txn, id string) (*seriesEntry, error) {
+	item, err := txn.Get(seriesKey(id))
+	if err != nil {
+		return nil, err
+	}
+	var e *seriesEntry
+	err = item.Value(func(val []byte) error {
+		e, err = decodeSeriesEntry(val)
+		return err
+	})
+	return e, err
+}
+
+// decodeSeriesEntry decodes the value of a series key, interning its
+// strings, which the series of every query share
+func decodeSeriesEntry(val []byte) (*seriesEntry, error) {
+	var e seriesEntry
+	if err := json.Unmarshal(val, &e); err != nil {
+		return nil, err
+	}
+	e.Name = utils.Symbols.Intern(e.Name)
+	e.Labels = utils.Symbols.InternLabels(e.Labels)
+	return &e, nil
+}
+
+// allSeries returns every series in a shard's index
+func allSeries(txn *badger.Txn) ([]*seriesEntry, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(seriesPrefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var entries []*seriesEntry
+	for it.Rewind(); it.Valid(); it.Next() {
+		var e *seriesEntry
+		if err := it.Item().Value(func(val []byte) (err error) {
+			e, err = decodeSeriesEntry(val)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// tombstones returns the tombstoned series of a shard with the time of
+// their last sample in Unix nanoseconds
+func tombstones(txn *badger.Txn) (map[string]int64, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(tombstonePrefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	stones := make(map[string]int64)
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		err := item.Value(func(val []byte) error {
+			last, err := strconv.ParseInt(string(val), 10, 64)
+			if err == nil {
+				stones[string(item.Key()[len(tombstonePrefix):])] = last
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stones, nil
+}
+
+// tombstonedSeries returns the tombstoned series of a shard with samples
+// at or after from
+func tombstonedSeries(txn *badger.Txn, from time.Time) ([]*seriesEntry, error) {
+	stones, err := tombstones(txn)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*seriesEntry
+	for id, last := range stones {
+		if last < from.UnixNano() {
+			continue
+		}
+		e, err := getSeries(txn, id)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// lookupSeries returns the series of a shard with the metric name, if
+// set, and the label filters, from its index. Tombstoned series are only
+// returned when they have samples at or after from. Filters on empty
+// values, which match a missing label, are left to the caller. It reports
+// false when the shard is not indexed.
+func lookupSeries(txn *badger.Txn, name string, filters map[string]string, from time.Time) ([]*seriesEntry, bool, error) {
+	ok, err := isIndexed(txn)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	// ids stays nil until a posting list narrows it down
+	var ids map[string]bool
+	intersect := func(label, value string) {
+		found := postings(txn, label, value)
+		if ids == nil {
+			ids = found
+			return
+		}
+		for id := range ids {
+			if !found[id] {
+				delete(ids, id)
+			}
+		}
+	}
+	if name != "" {
+		intersect(nameLabel, name)
+	}
+	for k, v := range filters {
+		if v != "" && (ids == nil || len(ids) > 0) {
+			intersect(k, v)
+		}
+	}
+
+	stones, err := tombstones(txn)
+	if err != nil {
+		return nil, true, err
+	}
+	if ids == nil {
+		all, err := allSeries(txn)
+		if err != nil {
+			return nil, true, err
+		}
+		entries := all[:0]
+		for _, e := range all {
+			if last, ok := stones[e.id()]; !ok || last >= from.UnixNano() {
+				entries = append(entries, e)
+			}
+		}
+		return entries, true, nil
+	}
+
+	entries := make([]*seriesEntry, 0, len(ids))
+	for id := range ids {
+		e, err := getSeries(txn, id)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		entries = append(entries, e)
+	}
+
+	// Tombstoned series have no postings, so are matched on their entry
+	for id, last := range stones {
+		if last < from.UnixNano() || ids[id] {
+			continue
+		}
+		e, err := getSeries(txn, id)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		if e.matches(name, filters) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, true, nil
+}
+
+// matches reports whether a series has the metric name, if set, and the
+// label filters' non-empty values
+func (e *seriesEntry) matches(name string, filters map[string]string) bool {
+	if name != "" && e.Name != name {
+		return false
+	}
+	for k, v := range filters {
+		if v != "" && e.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// scanSeries reads the samples in [from, to) of the series of a metric
+// matching the filters. Indexed shards only read those series, split
+// into tasks a pool of workers runs concurrently in the shard's read
+// transaction; others are scanned like scanSamples, and fn must still
+// check filters. Each task calls the fn of its own part from newPart, and
+// the part's merge is called, one at a time, as soon as the task is done.
+func (s *BadgerStore) scanSeries(name string, filters map[string]string, from, to time.Time, newPart func() (fn sampleFunc, merge func())) error {
+	span := s.chunkSpan()
+	workers := queryWorkers()
+	return s.forEachBlock(from.Add(-span), to, blockFilter(name, filters, from, to), func(db *badger.DB) error {
+		return db.View(func(txn *badger.Txn) error {
+			series, ok, err := lookupSeries(txn, name, filters, from)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fn, merge := newPart()
+				if err := s.scanShard(txn, name, from, to, span, fn); err != nil {
+					return err
+				}
+				merge()
+				return nil
+			}
+			return runScanTasks(txn, s.indexedTasks(series, from, to, span, workers), workers, newPart)
+		})
+	})
+}
+
+// scanChunks calls fn for each sample in [from, to) in the chunks of the
+// given series, read through their chunk refs
+func (s *BadgerStore) scanChunks(txn *badger.Txn, series []*seriesEntry, from, to time.Time, span time.Duration, fn sampleFunc) error {
+	fromNano, toNano := from.UnixNano(), to.UnixNano()
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(chunkRefPrefix)
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for _, e := range series {
+		prefix := []byte(chunkRefPrefix + e.id() + ":")
+		for it.Seek(chunkRefKey(e.id(), from.Add(-span).UnixNano())); it.ValidForPrefix(prefix); it.Next() {
+			start, err := strconv.ParseInt(string(it.Item().Key()[len(prefix):]), 10, 64)
+			if err != nil {
+				continue
+			}
+			if start >= toNano {
+				break
+			}
+			item, err := txn.Get(chunkKey(e.Name, start, e.Hash))
+			if err == badger.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := s.scanChunk(item, e.Name, e.Hash, fromNano, toNano, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// seriesHashes returns the labels hashes of series by metric name
+func seriesHashes(series []*seriesEntry) map[string]map[string]bool {
+	hashes := make(map[string]map[string]bool)
+	for _, e := range series {
+		if hashes[e.Name] == nil {
+			hashes[e.Name] = make(map[string]bool)
+		}
+		hashes[e.Name][e.Hash] = true
+	}
+	return hashes
+}
+
+// scanRaw calls fn for each raw sample in [from, to) of the series whose
+// hashes are given by metric name, skipping the samples of others by key
+func (s *BadgerStore) scanRaw(txn *badger.Txn, hashes map[string]map[string]bool, from, to time.Time, fn sampleFunc) error {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := scanRange(txn, rawPrefix, name, from, to, func(item *badger.Item, n string, _ int64, hash string) error {
+			// An empty name scans every name
+			if n != name || !hashes[name][hash] {
+				return nil
+			}
+			metric, err := s.decodeMetric(item)
+			if err != nil {
+				s.logger.Warn("Failed to decode metric", zap.Error(err))
+				return nil
+			}
+			return fn(metric, hash, item.EstimatedSize())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockSeries returns the series of a shard with the metric name and
+// label filters, from its index or by scanning its samples in [from, to)
+func (s *BadgerStore) blockSeries(txn *badger.Txn, name string, filters map[string]string, from, to time.Time, span time.Duration) ([]*seriesEntry, error) {
+	entries, ok, err := lookupSeries(txn, name, filters, from)
+	if ok || err != nil {
+		return entries, err
+	}
+
+	found := make(map[string]bool)
+	err = s.scanShard(txn, name, from, to, span, func(metric *models.Metric, hash string, _ int64) error {
+		id := seriesID(metric.Name, hash)
+		if found[id] || !s.matchesFilters(metric, filters) {
+			return nil
+		}
+		found[id] = true
+		entries = append(entries, &seriesEntry{Name: metric.Name, Hash: hash, Labels: metric.Labels})
+		return nil
+	})
+	return entries, err
+}
+
+// FindSeries returns the series of a metric, or of every metric when the
+// name is empty, with the query's labels, that are in the shards
+// overlapping the query's time range
+func (s *BadgerStore) FindSeries(name string, filters map[string]string, start, end time.Time) ([]*models.Series, error) {
+	found := make(map[string]*seriesEntry)
+	span := s.chunkSpan()
+	err := s.forEachBlock(start.Add(-span), end.Add(1), blockFilter(name, filters, start, end.Add(1)), func(db *badger.DB) error {
+		return db.View(func(txn *badger.Txn) error {
+			entries, err := s.blockSeries(txn, name, filters, start, end.Add(1), span)
+			for _, e := range entries {
+				found[e.id()] = e
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find series: %w", err)
+	}
+
+	series := make([]*models.Series, 0, len(found))
+	for _, e := range found {
+		if !s.matchesFilters(&models.Metric{Labels: e.Labels}, filters) {
+			continue
+		}
+		series = append(series, &models.Series{Name: e.Name, Labels: e.Labels})
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].String() < series[j].String()
+	})
+	return series, nil
+}
+
+// LabelNames returns the label names of the series in the shards
+// overlapping [start, end], __name__ included
+func (s *BadgerStore) LabelNames(start, end time.Time) ([]string, error) {
+	names := make(map[string]bool)
+	err := s.forEachLabelBlock(start, end, func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(postingsPrefix)
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); {
+			rest := it.Item().Key()[len(postingsPrefix):]
+			i := bytes.IndexByte(rest, '=')
+			if i < 0 {
+				it.Next()
+				continue
+			}
+			label := string(rest[:i])
+			names[label] = true
+			// Skip the label's other postings
+			it.Seek([]byte(postingsPrefix + label + ">"))
+		}
+		return nil
+	}, func(e *seriesEntry) {
+		names[nameLabel] = true
+		for k := range e.Labels {
+			names[k] = true
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list label names: %w", err)
+	}
+	return sortedSet(names), nil
+}
+
+// LabelValues returns the values of a label in the series in the shards
+// overlapping [start, end]. The __name__ label's values are the metric
+// names.
+func (s *BadgerStore) LabelValues(label string, start, end time.Time) ([]string, error) {
+	values := make(map[string]bool)
+	err := s.forEachLabelBlock(start, end, func(txn *badger.Txn) error {
+		prefix := postingsPrefix + label + "="
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(prefix)
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			rest := it.Item().Key()[len(prefix):]
+			if i := bytes.LastIndexByte(rest, ':'); i >= 0 {
+				values[string(rest[:i])] = true
+			}
+		}
+		return nil
+	}, func(e *seriesEntry) {
+		if label == nameLabel {
+			values[e.Name] = true
+		} else if v, ok := e.Labels[label]; ok {
+			values[v] = true
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list label values: %w", err)
+	}
+	return sortedSet(values), nil
+}
+
+// forEachLabelBlock calls indexed with each indexed shard overlapping
+// [start, end], and scanned with each of their tombstoned series with
+// samples since start and each series of the others
+func (s *BadgerStore) forEachLabelBlock(start, end time.Time, indexed func(txn *badger.Txn) error, scanned func(e *seriesEntry)) error {
+	span := s.chunkSpan()
+	return s.forEachBlock(start.Add(-span), end.Add(1), blockFilter("", nil, start, end.Add(1)), func(db *badger.DB) error {
+		return db.View(func(txn *badger.Txn) error {
+			ok, err := isIndexed(txn)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err := indexed(txn); err != nil {
+					return err
+				}
+				entries, err := tombstonedSeries(txn, start)
+				for _, e := range entries {
+					scanned(e)
+				}
+				return err
+			}
+			entries, err := s.blockSeries(txn, "", nil, start, end.Add(1), span)
+			for _, e := range entries {
+				scanned(e)
+			}
+			return err
+		})
+	})
+}
+
+func sortedSet(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for v := range set {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// BuildIndex indexes the series of shards written before the index was
+// added, until done or ctx is cancelled. Once all are indexed this is
+// recorded, and later calls return at once.
+func (s *BadgerStore) BuildIndex(ctx context.Context) error {
+	var built bool
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(indexBuiltKey))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		built = err == nil
+		return err
+	})
+	if err != nil || built {
+		return err
+	}
+
+	var indexed, series int
+	for _, sh := range s.shards.overlapping(beginningOfTime, endOfTime) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		err := s.withShard(sh, func(db *badger.DB) error {
+			n, err := indexShard(db)
+			if n > 0 {
+				indexed++
+				series += n
+				// Shards are only summarized once indexed
+				s.shards.resummarize(sh)
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to index shard %s: %w", sh.dir, err)
+		}
+	}
+
+	if err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(indexBuiltKey), nil)
+	}); err != nil {
+		return err
+	}
+	if indexed > 0 {
+		s.logger.Info("Indexed the series of existing shards",
+			zap.Int("shards", indexed),
+			zap.Int("series", series),
+		)
+	}
+	return nil
+}
+
+// indexShard indexes every series and chunk of a shard not yet marked
+// indexed, returning how many series it indexed. Samples written
+// meanwhile index themselves.
+func indexShard(db *badger.DB) (int, error) {
+	entries := make(map[string]*seriesEntry)
+	var refs [][]byte
+	err := db.View(func(txn *badger.Txn) error {
+		if ok, err := isIndexed(txn); ok || err != nil {
+			entries = nil
+			return err
+		}
+
+		for _, prefix := range []string{chunkPrefix, rawPrefix} {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte(prefix)
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				name, ts, hash, ok := splitSeriesKey(item.Key(), prefix)
+				if !ok {
+					continue
+				}
+				id := seriesID(name, hash)
+				if prefix == chunkPrefix {
+					refs = append(refs, chunkRefKey(id, ts))
+				}
+				if _, ok := entries[id]; ok {
+					continue
+				}
+
+				e := &seriesEntry{Name: name, Hash: hash}
+				if err := item.Value(func(val []byte) error {
+					labels, err := sampleLabels(prefix, val)
+					e.Labels = labels
+					return err
+				}); err != nil {
+					// Indexed when its next sample is read
+					continue
+				}
+				entries[id] = e
+			}
+			it.Close()
+		}
+		return nil
+	})
+	if err != nil || entries == nil {
+		return 0, err
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, e := range entries {
+		if err := indexSeries(wb.Set, e); err != nil {
+			return 0, err
+		}
+	}
+	for _, ref := range refs {
+		if err := wb.Set(ref, nil); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Set([]byte(indexedKey), nil); err != nil {
+		return 0, err
+	}
+	return len(entries), wb.Flush()
+}
+
+// sampleLabels reads the labels of a chunk or raw sample value
+func sampleLabels(prefix string, val []byte) (map[string]string, error) {
+	if prefix == chunkPrefix {
+		meta, _, err := decodeChunk(val)
+		if err != nil {
+			return nil, err
+		}
+		return meta.Labels, nil
+	}
+	data, err := decodeSampleValue(val, true)
+	if err != nil {
+		return nil, err
+	}
+	return data.Labels, nil
+}
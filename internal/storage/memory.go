@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+)
+
+// Under a memory limit, the memtables of the main database and every open
+// shard, which hold the most recent writes, take at most a quarter of it
+// and their block caches an eighth. Interned label strings take at most a
+// sixteenth. Configured sizes are only ever lowered.
+const (
+	memTableShare   = 4
+	blockCacheShare = 8
+	symbolShare     = 16
+	// minMemTableSize keeps Badger's batches larger than its value
+	// threshold
+	minMemTableSize = 8 << 20
+	// Badger needs a block cache to read compressed tables
+	minBlockCacheSize = 4 << 20
+	// symbolBytes is roughly what an interned string costs
+	symbolBytes = 96
+)
+
+// memoryFit returns the bytes the memtables and the block cache of each
+// database may take under the memory limit, or zeros without one
+func memoryFit(config *utils.StorageConfig) (memTables, blockCache int64) {
+	limit := utils.MemoryLimit()
+	if limit <= 0 {
+		return 0, 0
+	}
+	// The main database, the open shards and the cold tier's cache
+	databases := int64(1 + config.Shards.MaxOpen)
+	if config.Tiering.Enabled {
+		databases += int64(config.Shards.MaxOpen)
+	}
+	memTables = limit / memTableShare / databases
+	blockCache = max(limit/blockCacheShare/databases, minBlockCacheSize)
+	return memTables, blockCache
+}
+
+// fitMemory lowers a database's memtables and block cache to fit the
+// memory limit, keeping fewer memtables once they are as small as they
+// can be
+func fitMemory(opts *badger.Options, config *utils.StorageConfig) {
+	memTables, blockCache := memoryFit(config)
+	if memTables > 0 && memTables < opts.MemTableSize*int64(opts.NumMemtables) {
+		opts.MemTableSize = max(memTables/int64(opts.NumMemtables), min(minMemTableSize, opts.MemTableSize))
+		if n := int(memTables / opts.MemTableSize); n < opts.NumMemtables {
+			opts.NumMemtables = max(n, 2)
+		}
+	}
+	if blockCache > 0 && blockCache < opts.BlockCacheSize {
+		opts.BlockCacheSize = blockCache
+	}
+}
+
+// fitSymbols lowers the size of the symbol table to fit the memory limit
+func fitSymbols(size int) int {
+	limit := utils.MemoryLimit()
+	if size <= 0 || limit <= 0 {
+		return size
+	}
+	return int(min(int64(size), max(limit/symbolShare/symbolBytes, 1<<10)))
+}
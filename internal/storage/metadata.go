@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// MetadataStore holds the server's metadata: nodes, alerts and their event
+// log, derived metrics, ingest rules, annotations, silences and dashboards.
+// Only time series are kept in the TSDB.
+type MetadataStore interface {
+	SaveNode(node *models.Node) error
+	GetNode(nodeID string) (*models.Node, error)
+	DeleteNode(nodeID string) error
+	ListNodes() ([]*models.Node, error)
+	SaveAlert(alert *models.Alert) error
+	GetAlerts(filter *models.AlertFilter) ([]*models.Alert, error)
+	AppendAlertEvent(event *models.AlertEvent) error
+	GetAlertEvents(after uint64, limit int) ([]*models.AlertEvent, error)
+	LastAlertEventSeq() (uint64, error)
+	DeleteAlertEventsOlderThan(cutoff time.Time) (int64, error)
+	SaveDerivedMetric(metric *models.DerivedMetric) error
+	ListDerivedMetrics() ([]*models.DerivedMetric, error)
+	DeleteDerivedMetric(name string) error
+	SaveIngestRule(rule *models.IngestRule) error
+	ListIngestRules() ([]*models.IngestRule, error)
+	DeleteIngestRule(name string) error
+	SaveAnnotation(annotation *models.Annotation) error
+	GetAnnotations(filter *models.AnnotationFilter) ([]*models.Annotation, error)
+	DeleteAnnotation(id string) error
+	SaveSilence(silence *models.Silence) error
+	ListSilences() ([]*models.Silence, error)
+	DeleteSilence(id string) error
+	SaveDashboard(dashboard *models.Dashboard) error
+	ListDashboards() ([]*models.Dashboard, error)
+	GetDashboardVersions(id string) ([]*models.Dashboard, error)
+	DeleteDashboard(id string) error
+	SaveTenantUsage(usage *models.TenantUsage) error
+	ListTenantUsage(from, to string) ([]*models.TenantUsage, error)
+	DeleteTenantUsageBefore(day string) (int64, error)
+	SaveNodeMonth(record *models.NodeMonth) error
+	ListNodeMonths(from, to string) ([]*models.NodeMonth, error)
+	DeleteNodeMonthsBefore(month string) (int64, error)
+}
+
+// openMetadataStore opens the configured metadata store. A new SQL store
+// is seeded with the metadata kept in Badger by earlier versions.
+func openMetadataStore(config *utils.StorageConfig, badgerStore *BadgerStore, logger *zap.Logger) (MetadataStore, error) {
+	driver := config.Metadata.Driver
+	if driver == "" {
+		driver = utils.MetadataDriverBadger
+		if sqliteAvailable {
+			driver = utils.MetadataDriverSQLite
+		}
+	}
+
+	dsn := config.Metadata.DSN
+	switch driver {
+	case utils.MetadataDriverBadger:
+		logger.Info("Metadata stored in the TSDB")
+		return badgerStore, nil
+	case utils.MetadataDriverSQLite:
+		if !sqliteAvailable {
+			return nil, fmt.Errorf("the sqlite metadata store requires a build with cgo; use postgres or badger")
+		}
+		if dsn == "" {
+			dsn = "file:" + filepath.Join(config.Path, "metadata.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+		}
+	}
+
+	store, err := OpenSQLMetadataStore(driver, dsn, badgerStore, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s metadata store: %w", driver, err)
+	}
+	return store, nil
+}
@@ -0,0 +1,553 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// migrateStateFile holds an offline migration's progress in the target
+// path
+const migrateStateFile = "migrate.json"
+
+// MigrateOptions describe an offline copy of a storage path to another
+type MigrateOptions struct {
+	// Target is the storage path written; it must be outside the source's
+	Target string
+	// Compression seals the copied samples into compressed chunks
+	Compression bool
+	// Metadata selects the target's metadata store; an empty driver keeps
+	// the source's
+	Metadata utils.MetadataConfig
+	// Progress, if set, is called after each block is copied or verified
+	Progress func(*MigrateProgress)
+}
+
+// MigrateBlock is a time block of the source and the samples counted in
+// it in each store
+type MigrateBlock struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Source int64     `json:"source"`
+	Target int64     `json:"target"`
+	// Copied is set once every sample is written to the target, which
+	// may not have sealed them yet
+	Copied   bool `json:"copied"`
+	Verified bool `json:"verified"`
+}
+
+// Matches reports whether both stores hold as many samples in the block
+func (b *MigrateBlock) Matches() bool {
+	return b.Source == b.Target
+}
+
+// MigrateProgress reports a block just copied or verified
+type MigrateProgress struct {
+	Block *MigrateBlock
+	// Done counts the blocks finished out of Total, including those
+	// finished by an earlier run
+	Done, Total int
+	// Resumed is set for a block finished by an earlier run
+	Resumed bool
+	Elapsed time.Duration
+}
+
+// MigrateReport is the outcome of a migration or a verification
+type MigrateReport struct {
+	Blocks     []*MigrateBlock
+	Samples    int64
+	Mismatched int
+	Elapsed    time.Duration
+}
+
+// migrateProgressState is the progress saved in the target across runs
+type migrateProgressState struct {
+	Source       string                   `json:"source"`
+	Compression  bool                     `json:"compression"`
+	Started      time.Time                `json:"started"`
+	MetadataDone bool                     `json:"metadata_done"`
+	Blocks       map[string]*MigrateBlock `json:"blocks"`
+	Completed    time.Time                `json:"completed,omitempty"`
+}
+
+// Migrator copies the samples and metadata of a stopped server's storage
+// path to a new one a block at a time, converting them to the target's
+// format, and checks each block holds as many samples in both. Progress is
+// saved in the target, so an interrupted run resumes with the first block
+// not copied.
+type Migrator struct {
+	options    MigrateOptions
+	source     *BadgerStore
+	target     *BadgerStore
+	sourceMeta MetadataStore
+	targetMeta MetadataStore
+	// sameMeta is set when both use the same database, which is not copied
+	sameMeta bool
+	chunks   *ChunkCompactor
+	logger   *zap.Logger
+	state    migrateProgressState
+}
+
+// NewMigrator opens the source storage of config and the target path. The
+// server must not be running on either.
+func NewMigrator(config *utils.StorageConfig, options MigrateOptions, logger *zap.Logger) (*Migrator, error) {
+	if options.Target == "" {
+		return nil, fmt.Errorf("target path is required")
+	}
+	source, err := filepath.Abs(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage path: %w", err)
+	}
+	target, err := filepath.Abs(options.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target path: %w", err)
+	}
+	if pathWithin(target, source) || pathWithin(source, target) {
+		return nil, fmt.Errorf("target path must be outside the storage path")
+	}
+
+	// Archived blocks stay in the bucket, and nothing is written to the
+	// source
+	sourceConfig := *config
+	sourceConfig.Path = source
+	sourceConfig.Tiering.Enabled = false
+	sourceConfig.Replication = utils.ReplicationConfig{}
+	sourceConfig.Migration.Enabled = false
+
+	// Retention is applied by the server once it runs on the target
+	targetConfig := sourceConfig
+	targetConfig.Path = target
+	targetConfig.Compression = options.Compression
+	targetConfig.RetentionPeriod = 0
+	targetConfig.Rollups.Enabled = false
+	targetConfig.StaleSeries.Enabled = false
+	if options.Metadata.Driver != "" {
+		targetConfig.Metadata = options.Metadata
+	}
+
+	m := &Migrator{options: options, logger: logger}
+	m.sameMeta = targetConfig.Metadata.Driver == utils.MetadataDriverPostgres &&
+		sourceConfig.Metadata.Driver == utils.MetadataDriverPostgres &&
+		targetConfig.Metadata.DSN == sourceConfig.Metadata.DSN
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create target path: %w", err)
+	}
+	resumed, err := m.loadState(target)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !resumed:
+		m.state = migrateProgressState{
+			Source:      source,
+			Compression: options.Compression,
+			Started:     time.Now(),
+			Blocks:      make(map[string]*MigrateBlock),
+		}
+	case m.state.Source != source || m.state.Compression != options.Compression:
+		return nil, fmt.Errorf("target holds a migration from %s (compression %t); clear it to start over", m.state.Source, m.state.Compression)
+	}
+
+	if m.source, err = NewBadgerStore(&sourceConfig, logger); err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	if m.source.legacy.Load() {
+		m.Close()
+		return nil, fmt.Errorf("storage holds samples written before sharding; start the server once to move them into shards")
+	}
+	if m.sourceMeta, err = openMetadataStore(&sourceConfig, m.source, logger); err != nil {
+		m.Close()
+		return nil, err
+	}
+	if m.target, err = NewBadgerStore(&targetConfig, logger); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("failed to open target storage: %w", err)
+	}
+	if m.sameMeta {
+		m.targetMeta = m.sourceMeta
+	} else if m.targetMeta, err = openMetadataStore(&targetConfig, m.target, logger); err != nil {
+		m.Close()
+		return nil, err
+	}
+	if targetConfig.Compression {
+		if m.chunks, err = NewChunkCompactor(&targetConfig, m.target, logger); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to create chunk compactor: %w", err)
+		}
+	}
+
+	logger.Info("Storage migration opened",
+		zap.String("source", source),
+		zap.String("target", target),
+		zap.Bool("compression", targetConfig.Compression),
+		zap.Bool("resumed", resumed),
+	)
+	return m, nil
+}
+
+// loadState reads the progress saved in the target, reporting whether
+// there was any
+func (m *Migrator) loadState(target string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(target, migrateStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read migration state: %w", err)
+	}
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return false, fmt.Errorf("invalid migration state: %w", err)
+	}
+	if m.state.Blocks == nil {
+		m.state.Blocks = make(map[string]*MigrateBlock)
+	}
+	return true, nil
+}
+
+// saveState writes the progress, replacing the file atomically
+func (m *Migrator) saveState() error {
+	data, err := json.MarshalIndent(&m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(m.options.Target, migrateStateFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to save migration state: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to save migration state: %w", err)
+	}
+	return nil
+}
+
+// Run copies the metadata, then each block not copied by an earlier run,
+// oldest first
+func (m *Migrator) Run(ctx context.Context) (*MigrateReport, error) {
+	start := time.Now()
+	if !m.state.MetadataDone {
+		if err := m.copyMetadata(); err != nil {
+			return nil, err
+		}
+		m.state.MetadataDone = true
+		if err := m.saveState(); err != nil {
+			return nil, err
+		}
+	}
+
+	shards := m.source.shards.overlapping(beginningOfTime, endOfTime)
+	for i, sh := range shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		key := shardDirName(sh.start, sh.end)
+		block := m.state.Blocks[key]
+		resumed := block != nil && block.Verified
+		if !resumed {
+			var err error
+			if block, err = m.migrateBlock(ctx, key, sh.start, sh.end); err != nil {
+				return nil, err
+			}
+		}
+		m.progress(block, i+1, len(shards), resumed, start)
+	}
+
+	m.state.Completed = time.Now()
+	if err := m.saveState(); err != nil {
+		return nil, err
+	}
+	return m.report(start), nil
+}
+
+// migrateBlock copies a block unless an earlier run did, seals it and
+// counts its samples in the target. A block is only copied once, as
+// samples written again after being sealed would be sealed twice.
+func (m *Migrator) migrateBlock(ctx context.Context, key string, from, to time.Time) (*MigrateBlock, error) {
+	block := m.state.Blocks[key]
+	if block == nil || !block.Copied {
+		block = &MigrateBlock{Start: from, End: to}
+		n, err := copySamples(ctx, m.source, m.target, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy block %s: %w", key, err)
+		}
+		block.Source = n
+		block.Copied = true
+		m.state.Blocks[key] = block
+		if err := m.saveState(); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.chunks != nil {
+		if err := m.chunks.Run(); err != nil {
+			return nil, fmt.Errorf("failed to seal block %s: %w", key, err)
+		}
+	}
+	n, err := countSamples(m.target, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count block %s: %w", key, err)
+	}
+	block.Target = n
+	block.Verified = true
+	if !block.Matches() {
+		m.logger.Warn("Migrated block holds a different number of samples",
+			zap.String("block", key),
+			zap.Int64("source", block.Source),
+			zap.Int64("target", block.Target),
+		)
+	}
+	return block, m.saveState()
+}
+
+// Verify counts the samples of every block in both stores again, without
+// copying anything
+func (m *Migrator) Verify(ctx context.Context) (*MigrateReport, error) {
+	start := time.Now()
+	shards := m.source.shards.overlapping(beginningOfTime, endOfTime)
+	for i, sh := range shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		key := shardDirName(sh.start, sh.end)
+		source, err := countSamples(m.source, sh.start, sh.end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count block %s: %w", key, err)
+		}
+		target, err := countSamples(m.target, sh.start, sh.end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count block %s in the target: %w", key, err)
+		}
+
+		block := m.state.Blocks[key]
+		if block == nil {
+			block = &MigrateBlock{Start: sh.start, End: sh.end}
+			m.state.Blocks[key] = block
+		}
+		block.Source, block.Target = source, target
+		m.progress(block, i+1, len(shards), false, start)
+	}
+
+	if err := m.saveState(); err != nil {
+		return nil, err
+	}
+	return m.report(start), nil
+}
+
+func (m *Migrator) progress(block *MigrateBlock, done, total int, resumed bool, start time.Time) {
+	if m.options.Progress == nil {
+		return
+	}
+	m.options.Progress(&MigrateProgress{
+		Block:   block,
+		Done:    done,
+		Total:   total,
+		Resumed: resumed,
+		Elapsed: time.Since(start),
+	})
+}
+
+// report lists the blocks recorded, oldest first
+func (m *Migrator) report(start time.Time) *MigrateReport {
+	report := &MigrateReport{Elapsed: time.Since(start)}
+	for _, block := range m.state.Blocks {
+		report.Blocks = append(report.Blocks, block)
+		report.Samples += block.Target
+		if !block.Matches() {
+			report.Mismatched++
+		}
+	}
+	sort.Slice(report.Blocks, func(i, j int) bool {
+		return report.Blocks[i].Start.Before(report.Blocks[j].Start)
+	})
+	return report
+}
+
+// copyMetadata copies every node, alert and its events, derived metric,
+// ingest rule, annotation, silence, dashboard with its versions, tenant
+// usage record and node month. Alert events are numbered again from the
+// target's last one, so events copied by an interrupted run are skipped.
+func (m *Migrator) copyMetadata() error {
+	if m.sameMeta {
+		m.logger.Info("Metadata is shared by both storage paths; not copied")
+		return nil
+	}
+	from, to := m.sourceMeta, m.targetMeta
+
+	nodes, err := from.ListNodes()
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for _, node := range nodes {
+		if err := to.SaveNode(node); err != nil {
+			return fmt.Errorf("failed to copy node %s: %w", node.ID, err)
+		}
+	}
+
+	alerts, err := from.GetAlerts(nil)
+	if err != nil {
+		return fmt.Errorf("failed to list alerts: %w", err)
+	}
+	for _, alert := range alerts {
+		if err := to.SaveAlert(alert); err != nil {
+			return fmt.Errorf("failed to copy alert %s: %w", alert.ID, err)
+		}
+	}
+
+	events, err := from.GetAlertEvents(0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to list alert events: %w", err)
+	}
+	copied, err := to.LastAlertEventSeq()
+	if err != nil {
+		return err
+	}
+	for i := int(copied); i < len(events); i++ {
+		if err := to.AppendAlertEvent(events[i]); err != nil {
+			return fmt.Errorf("failed to copy alert event: %w", err)
+		}
+	}
+
+	derived, err := from.ListDerivedMetrics()
+	if err != nil {
+		return fmt.Errorf("failed to list derived metrics: %w", err)
+	}
+	for _, metric := range derived {
+		if err := to.SaveDerivedMetric(metric); err != nil {
+			return fmt.Errorf("failed to copy derived metric %s: %w", metric.Name, err)
+		}
+	}
+
+	rules, err := from.ListIngestRules()
+	if err != nil {
+		return fmt.Errorf("failed to list ingest rules: %w", err)
+	}
+	for _, rule := range rules {
+		if err := to.SaveIngestRule(rule); err != nil {
+			return fmt.Errorf("failed to copy ingest rule %s: %w", rule.Name, err)
+		}
+	}
+
+	annotations, err := from.GetAnnotations(nil)
+	if err != nil {
+		return fmt.Errorf("failed to list annotations: %w", err)
+	}
+	for _, annotation := range annotations {
+		if err := to.SaveAnnotation(annotation); err != nil {
+			return fmt.Errorf("failed to copy annotation %s: %w", annotation.ID, err)
+		}
+	}
+
+	silences, err := from.ListSilences()
+	if err != nil {
+		return fmt.Errorf("failed to list silences: %w", err)
+	}
+	for _, silence := range silences {
+		if err := to.SaveSilence(silence); err != nil {
+			return fmt.Errorf("failed to copy silence %s: %w", silence.ID, err)
+		}
+	}
+
+	dashboards, err := from.ListDashboards()
+	if err != nil {
+		return fmt.Errorf("failed to list dashboards: %w", err)
+	}
+	for _, dashboard := range dashboards {
+		if err := copyDashboard(from, to, dashboard); err != nil {
+			return fmt.Errorf("failed to copy dashboard %s: %w", dashboard.ID, err)
+		}
+	}
+
+	usage, err := from.ListTenantUsage("", "")
+	if err != nil {
+		return fmt.Errorf("failed to list tenant usage: %w", err)
+	}
+	for _, record := range usage {
+		if err := to.SaveTenantUsage(record); err != nil {
+			return fmt.Errorf("failed to copy tenant usage %s/%s: %w", record.Tenant, record.Day, err)
+		}
+	}
+
+	months, err := from.ListNodeMonths("", "")
+	if err != nil {
+		return fmt.Errorf("failed to list node months: %w", err)
+	}
+	for _, record := range months {
+		if err := to.SaveNodeMonth(record); err != nil {
+			return fmt.Errorf("failed to copy node month %s/%s: %w", record.NodeID, record.Month, err)
+		}
+	}
+
+	m.logger.Info("Copied metadata",
+		zap.Int("nodes", len(nodes)),
+		zap.Int("alerts", len(alerts)),
+		zap.Int("alert_events", len(events)),
+		zap.Int("derived_metrics", len(derived)),
+		zap.Int("ingest_rules", len(rules)),
+		zap.Int("annotations", len(annotations)),
+		zap.Int("silences", len(silences)),
+		zap.Int("dashboards", len(dashboards)),
+		zap.Int("tenant_usage", len(usage)),
+		zap.Int("node_months", len(months)),
+	)
+	return nil
+}
+
+// copyDashboard saves a dashboard's versions oldest first, then the
+// dashboard itself
+func copyDashboard(from, to MetadataStore, dashboard *models.Dashboard) error {
+	versions, err := from.GetDashboardVersions(dashboard.ID)
+	if err != nil {
+		return err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if err := to.SaveDashboard(versions[i]); err != nil {
+			return err
+		}
+	}
+	return to.SaveDashboard(dashboard)
+}
+
+// countSamples counts a store's samples in [from, to)
+func countSamples(store *BadgerStore, from, to time.Time) (int64, error) {
+	var n int64
+	err := store.scanSamples("", from, to, func(*models.Metric, string, int64) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Close closes both stores
+func (m *Migrator) Close() error {
+	var errs []error
+	for _, meta := range []MetadataStore{m.sourceMeta, m.targetMeta} {
+		if sqlMeta, ok := meta.(*SQLMetadataStore); ok && sqlMeta != nil {
+			errs = append(errs, sqlMeta.Close())
+			if m.sameMeta {
+				break
+			}
+		}
+	}
+	for _, store := range []*BadgerStore{m.source, m.target} {
+		if store != nil {
+			errs = append(errs, store.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pathWithin reports whether path is dir or under it
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,568 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// migrationStateFile holds a migration's progress in the new store's path
+const migrationStateFile = "migration.json"
+
+// migrationSettle is how recent samples are left out of comparisons, as a
+// query may see them in one store before they are written to the other
+const migrationSettle = time.Minute
+
+// migrationBatch bounds the samples copied per write while backfilling
+const migrationBatch = 10000
+
+// migrationState is the progress saved across restarts
+type migrationState struct {
+	Started        time.Time `json:"started"`
+	BackfilledFrom time.Time `json:"backfilled_from"`
+	BackfillDone   bool      `json:"backfill_done"`
+	// LastSeen is when the new store last received writes; after a
+	// restart, the samples written since are copied again
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// StorageMigration writes every batch to a second store in the compressed
+// chunk format as well as the current one, copies the samples written
+// before it started, and compares a share of raw queries between the two.
+// Writes to the new store never fail ingestion; they are counted, and show
+// up as mismatches.
+type StorageMigration struct {
+	config *utils.StorageConfig
+	source *BadgerStore
+	store  *BadgerStore
+	chunks *ChunkCompactor
+	logger *zap.Logger
+	path   string
+	ratio  float64
+	// runStart is when this run began writing to the new store
+	runStart time.Time
+
+	mu    sync.Mutex
+	state migrationState
+	// catchUp is set until the samples written while the server was
+	// stopped have been copied
+	catchUp      bool
+	lastMismatch *models.MigrationMismatch
+
+	writes      atomic.Uint64
+	writeErrors atomic.Uint64
+	comparisons atomic.Uint64
+	mismatches  atomic.Uint64
+	skipped     atomic.Uint64
+	backfilled  atomic.Int64
+
+	// One comparison runs at a time; queries sampled meanwhile are skipped
+	comparing atomic.Bool
+	wg        sync.WaitGroup
+}
+
+// NewStorageMigration opens the new store at the migration path, resuming
+// a migration started earlier
+func NewStorageMigration(config *utils.StorageConfig, source *BadgerStore, logger *zap.Logger) (*StorageMigration, error) {
+	target := *config
+	target.Path = config.Migration.Path
+	target.Compression = true
+	target.Tiering.Enabled = false
+	target.Rollups.Enabled = false
+	target.StaleSeries.Enabled = false
+	target.Replication = utils.ReplicationConfig{}
+	target.Migration.Enabled = false
+
+	if err := os.MkdirAll(target.Path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create migration path: %w", err)
+	}
+	m := &StorageMigration{
+		config:   &target,
+		source:   source,
+		logger:   logger,
+		path:     target.Path,
+		ratio:    config.Migration.CompareRatio,
+		runStart: time.Now(),
+	}
+	resumed, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewBadgerStore(&target, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migration store: %w", err)
+	}
+	chunks, err := NewChunkCompactor(&target, store, logger)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to create migration chunk compactor: %w", err)
+	}
+	m.store = store
+	m.chunks = chunks
+
+	if resumed {
+		m.catchUp = true
+	} else {
+		m.state = migrationState{Started: m.runStart, BackfilledFrom: m.runStart, LastSeen: m.runStart}
+	}
+	if err := m.saveState(); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	logger.Info("Storage migration started",
+		zap.String("path", m.path),
+		zap.Bool("resumed", resumed),
+		zap.Time("backfilled_from", m.state.BackfilledFrom),
+		zap.Bool("backfill_done", m.state.BackfillDone),
+	)
+	return m, nil
+}
+
+// loadState reads the saved progress, reporting whether there was any
+func (m *StorageMigration) loadState() (bool, error) {
+	data, err := os.ReadFile(filepath.Join(m.path, migrationStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read migration state: %w", err)
+	}
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return false, fmt.Errorf("invalid migration state: %w", err)
+	}
+	return true, nil
+}
+
+// saveState writes the progress, replacing the file atomically
+func (m *StorageMigration) saveState() error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(&m.state, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(m.path, migrationStateFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to save migration state: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to save migration state: %w", err)
+	}
+	return nil
+}
+
+// write copies a batch written to the current store to the new one
+func (m *StorageMigration) write(metrics []*models.Metric) {
+	if m == nil {
+		return
+	}
+	m.writes.Add(1)
+	if err := m.store.WriteMetrics(metrics); err != nil {
+		// Logged once every thousand failures, as the new store may be
+		// failing every batch
+		if n := m.writeErrors.Add(1); n%1000 == 1 {
+			m.logger.Warn("Failed to write to the migration store",
+				zap.Uint64("write_errors", n),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// deleteNode deletes a node's samples from the new store too
+func (m *StorageMigration) deleteNode(nodeID string) {
+	if m == nil {
+		return
+	}
+	if _, err := m.store.DeleteNodeMetrics(nodeID, nil); err != nil {
+		m.logger.Warn("Failed to delete node metrics from the migration store",
+			zap.String("node_id", nodeID),
+			zap.Error(err),
+		)
+	}
+}
+
+// cleanup drops the new store's shards past the retention period
+func (m *StorageMigration) cleanup(cutoff time.Time) {
+	if m == nil {
+		return
+	}
+	m.store.DropShardsBefore(cutoff)
+	if err := m.store.RunGC(); err != nil {
+		m.logger.Debug("Failed to run migration store garbage collection", zap.Error(err))
+	}
+}
+
+// run seals the new store's samples into chunks and backfills it until
+// ctx is done
+func (m *StorageMigration) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.runChunks(ctx)
+	}()
+	m.backfill(ctx)
+	wg.Wait()
+}
+
+// runChunks periodically seals samples and records that writes go on
+func (m *StorageMigration) runChunks(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Chunks.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.chunks.Run(); err != nil {
+			m.logger.Error("Migration chunk compaction failed", zap.Error(err))
+		}
+		m.mu.Lock()
+		if !m.catchUp {
+			m.state.LastSeen = time.Now()
+		}
+		m.mu.Unlock()
+		if err := m.saveState(); err != nil {
+			m.logger.Warn("Failed to save migration state", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// backfill copies the samples written while the server was stopped, then
+// those written before the migration started, newest first, a window at a
+// time
+func (m *StorageMigration) backfill(ctx context.Context) {
+	m.mu.Lock()
+	catchUp, lastSeen := m.catchUp, m.state.LastSeen
+	m.mu.Unlock()
+	if catchUp {
+		if err := m.copyRange(ctx, lastSeen.Add(-migrationSettle), m.runStart); err != nil {
+			if ctx.Err() == nil {
+				m.logger.Error("Storage migration catch-up failed; it is retried on the next start", zap.Error(err))
+			}
+			return
+		}
+		m.mu.Lock()
+		m.catchUp = false
+		m.state.LastSeen = time.Now()
+		m.mu.Unlock()
+		m.logger.Info("Storage migration caught up with the samples written while stopped",
+			zap.Time("from", lastSeen),
+		)
+	}
+
+	window := m.config.Migration.BackfillWindow
+	for ctx.Err() == nil {
+		m.mu.Lock()
+		to, done := m.state.BackfilledFrom, m.state.BackfillDone
+		m.mu.Unlock()
+		if done {
+			return
+		}
+
+		from := to.Add(-window)
+		oldest := m.source.legacy.Load() || len(m.source.shards.overlapping(beginningOfTime, from)) > 0
+		if m.config.RetentionPeriod > 0 {
+			if cutoff := time.Now().Add(-m.config.RetentionPeriod); !from.After(cutoff) {
+				from, oldest = cutoff, false
+			}
+		}
+		if err := m.copyRange(ctx, from, to); err != nil {
+			if ctx.Err() == nil {
+				m.logger.Error("Storage migration backfill failed; it is retried on the next start", zap.Error(err))
+			}
+			return
+		}
+
+		m.mu.Lock()
+		m.state.BackfilledFrom = from
+		m.state.BackfillDone = !oldest
+		m.mu.Unlock()
+		if err := m.saveState(); err != nil {
+			m.logger.Warn("Failed to save migration state", zap.Error(err))
+		}
+		if !oldest {
+			m.logger.Info("Storage migration backfill completed",
+				zap.Int64("samples", m.backfilled.Load()),
+			)
+		}
+	}
+}
+
+// copyRange copies the current store's samples in [from, to) to the new
+// store. Samples already there are overwritten with themselves.
+func (m *StorageMigration) copyRange(ctx context.Context, from, to time.Time) error {
+	n, err := copySamples(ctx, m.source, m.store, from, to)
+	m.backfilled.Add(n)
+	return err
+}
+
+// copySamples copies the samples in [from, to) from one store to another
+// in batches, returning how many were written
+func copySamples(ctx context.Context, from, to *BadgerStore, start, end time.Time) (int64, error) {
+	if !start.Before(end) {
+		return 0, nil
+	}
+	var copied int64
+	batch := make([]*models.Metric, 0, migrationBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := to.WriteMetrics(batch); err != nil {
+			return fmt.Errorf("failed to write samples: %w", err)
+		}
+		copied += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	err := from.scanSamples("", start, end, func(metric *models.Metric, _ string, _ int64) error {
+		batch = append(batch, metric)
+		if len(batch) < migrationBatch {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return flush()
+	})
+	if err != nil {
+		return copied, err
+	}
+	return copied, flush()
+}
+
+// coveredFrom returns the oldest time the new store holds every sample
+// from
+func (m *StorageMigration) coveredFrom() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.catchUp {
+		return m.runStart
+	}
+	return m.state.BackfilledFrom
+}
+
+// compare runs a sampled share of raw queries against the new store in
+// the background and checks the results match over the time it covers
+func (m *StorageMigration) compare(query *models.Query, selector string, want []*models.TimeSeries) {
+	if m == nil || rand.Float64() >= m.ratio {
+		return
+	}
+
+	now := time.Now()
+	from := m.coveredFrom()
+	if m.config.RetentionPeriod > 0 {
+		// Near the retention cutoff the stores drop blocks at different times
+		if cutoff := now.Add(-m.config.RetentionPeriod + m.config.Shards.BlockDuration); cutoff.After(from) {
+			from = cutoff
+		}
+	}
+	if query.StartTime.After(from) {
+		from = query.StartTime
+	}
+	to := now.Add(-migrationSettle)
+	if query.EndTime.Before(to) {
+		to = query.EndTime
+	}
+	if !from.Before(to) || !m.comparing.CompareAndSwap(false, true) {
+		m.skipped.Add(1)
+		return
+	}
+
+	// The results may be changed by the caller, so they are digested now
+	expected := newSeriesDigest(want, from, to, query.Step)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer m.comparing.Store(false)
+
+		got, err := m.store.QueryMetrics(selector, query.StartTime, query.EndTime, query.Step)
+		if err != nil {
+			m.skipped.Add(1)
+			m.logger.Warn("Failed to query the migration store", zap.String("query", selector), zap.Error(err))
+			return
+		}
+		m.comparisons.Add(1)
+		detail := expected.diff(newSeriesDigest(got, from, to, query.Step))
+		if detail == "" {
+			return
+		}
+
+		m.mismatches.Add(1)
+		mismatch := &models.MigrationMismatch{Query: selector, At: time.Now(), Detail: detail}
+		m.mu.Lock()
+		m.lastMismatch = mismatch
+		m.mu.Unlock()
+		m.logger.Warn("Storage migration query results differ",
+			zap.String("query", selector),
+			zap.Time("start", from),
+			zap.Time("end", to),
+			zap.String("detail", detail),
+		)
+	}()
+}
+
+// Status reports the migration's progress and comparisons
+func (m *StorageMigration) Status() *models.MigrationStatus {
+	if m == nil {
+		return &models.MigrationStatus{}
+	}
+	covered := m.coveredFrom()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &models.MigrationStatus{
+		Enabled:           true,
+		Path:              m.path,
+		Started:           m.state.Started,
+		BackfilledFrom:    m.state.BackfilledFrom,
+		BackfillDone:      m.state.BackfillDone,
+		CoveredFrom:       covered,
+		BackfilledSamples: m.backfilled.Load(),
+		Writes:            m.writes.Load(),
+		WriteErrors:       m.writeErrors.Load(),
+		Comparisons:       m.comparisons.Load(),
+		Mismatches:        m.mismatches.Load(),
+		Skipped:           m.skipped.Load(),
+		LastMismatch:      m.lastMismatch,
+	}
+}
+
+// close waits for comparisons, saves the progress and closes the new
+// store
+func (m *StorageMigration) close() error {
+	if m == nil {
+		return nil
+	}
+	m.wg.Wait()
+	m.mu.Lock()
+	if !m.catchUp {
+		m.state.LastSeen = time.Now()
+	}
+	m.mu.Unlock()
+	if err := m.saveState(); err != nil {
+		m.logger.Warn("Failed to save migration state", zap.Error(err))
+	}
+	return m.store.Close()
+}
+
+// seriesDigest holds a query's samples by series, at the millisecond
+// precision of chunks, over the range compared
+type seriesDigest map[string]*digestSeries
+
+type digestSeries struct {
+	labels  map[string]string
+	samples map[int64]float64
+}
+
+// newSeriesDigest keeps the samples, or step buckets, wholly in [from, to)
+func newSeriesDigest(series []*models.TimeSeries, from, to time.Time, step time.Duration) seriesDigest {
+	d := make(seriesDigest, len(series))
+	for _, ts := range series {
+		var samples map[int64]float64
+		for _, s := range ts.Samples {
+			if s.Timestamp.Before(from) || s.Timestamp.Add(step).After(to) || !s.Timestamp.Before(to) {
+				continue
+			}
+			if samples == nil {
+				samples = make(map[int64]float64)
+			}
+			samples[s.Timestamp.UnixMilli()] = s.Value
+		}
+		if samples != nil {
+			d[utils.HashLabels(ts.Labels)] = &digestSeries{labels: ts.Labels, samples: samples}
+		}
+	}
+	return d
+}
+
+// diff describes how got differs from d, or returns "" if they match
+func (d seriesDigest) diff(got seriesDigest) string {
+	keys := make([]string, 0, len(d)+len(got))
+	for key := range d {
+		keys = append(keys, key)
+	}
+	for key := range got {
+		if _, ok := d[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var missing, extra, differ int
+	var first string
+	note := func(format string, args ...interface{}) {
+		if first == "" {
+			first = fmt.Sprintf(format, args...)
+		}
+	}
+	for _, key := range keys {
+		want, have := d[key], got[key]
+		switch {
+		case have == nil:
+			missing += len(want.samples)
+			note("series %s is missing", describeLabels(want.labels))
+			continue
+		case want == nil:
+			extra += len(have.samples)
+			note("series %s is only in the new store", describeLabels(have.labels))
+			continue
+		}
+		for ts, v := range want.samples {
+			hv, ok := have.samples[ts]
+			switch {
+			case !ok:
+				missing++
+				note("%s is missing the sample at %s", describeLabels(want.labels), time.UnixMilli(ts).UTC().Format(time.RFC3339Nano))
+			case !sameValue(v, hv):
+				differ++
+				note("%s at %s is %g instead of %g", describeLabels(want.labels), time.UnixMilli(ts).UTC().Format(time.RFC3339Nano), hv, v)
+			}
+		}
+		for ts := range have.samples {
+			if _, ok := want.samples[ts]; !ok {
+				extra++
+				note("%s has an extra sample at %s", describeLabels(have.labels), time.UnixMilli(ts).UTC().Format(time.RFC3339Nano))
+			}
+		}
+	}
+	if first == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d samples missing, %d extra, %d different; %s", missing, extra, differ, first)
+}
+
+// sameValue compares values allowing for the order step averages are
+// summed in
+func sameValue(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return a == b || math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
+
+func describeLabels(labels map[string]string) string {
+	series := &models.Series{Labels: labels}
+	return series.String()
+}
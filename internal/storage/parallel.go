@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/meettoy2004/lnmonja/internal/models"
+)
+
+// tasksPerWorker splits a query's series finer than the workers, so a
+// worker given series with more samples does not hold the others up
+const tasksPerWorker = 4
+
+// sampleFunc receives a sample with the hash of its labels and its share
+// of the stored size
+type sampleFunc func(metric *models.Metric, hash string, size int64) error
+
+// scanTask reads part of a shard in its read transaction
+type scanTask func(txn *badger.Txn, fn sampleFunc) error
+
+// queryWorkers returns how many workers decode and aggregate a query's
+// series
+func queryWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// indexedTasks splits the scan of indexed series in [from, to) into
+// tasks: their chunks by batches of series, and their raw samples, which
+// sort by time, by slices of the range
+func (s *BadgerStore) indexedTasks(series []*seriesEntry, from, to time.Time, span time.Duration, workers int) []scanTask {
+	if len(series) == 0 {
+		return nil
+	}
+	var tasks []scanTask
+
+	if span > 0 {
+		batch := (len(series) + workers*tasksPerWorker - 1) / (workers * tasksPerWorker)
+		for i := 0; i < len(series); i += batch {
+			part := series[i:min(i+batch, len(series))]
+			tasks = append(tasks, func(txn *badger.Txn, fn sampleFunc) error {
+				return s.scanChunks(txn, part, from, to, span, fn)
+			})
+		}
+	}
+
+	hashes := seriesHashes(series)
+	slices := workers
+	if to.Sub(from) < time.Duration(workers) {
+		slices = 1
+	}
+	width := to.Sub(from) / time.Duration(slices)
+	for i := 0; i < slices; i++ {
+		sliceFrom, sliceTo := from.Add(time.Duration(i)*width), from.Add(time.Duration(i+1)*width)
+		if i == slices-1 {
+			sliceTo = to
+		}
+		tasks = append(tasks, func(txn *badger.Txn, fn sampleFunc) error {
+			return s.scanRaw(txn, hashes, sliceFrom, sliceTo, fn)
+		})
+	}
+	return tasks
+}
+
+// runScanTasks runs tasks on up to workers goroutines sharing a read
+// transaction, which Badger allows, so they all see the same snapshot.
+// Each task fills a part from newPart, merged by the calling goroutine as
+// soon as the task is done. After a task fails no others start, and the
+// first error is returned.
+func runScanTasks(txn *badger.Txn, tasks []scanTask, workers int, newPart func() (sampleFunc, func())) error {
+	if workers <= 1 || len(tasks) <= 1 {
+		for _, task := range tasks {
+			fn, merge := newPart()
+			if err := task(txn, fn); err != nil {
+				return err
+			}
+			merge()
+		}
+		return nil
+	}
+
+	queue := make(chan scanTask)
+	done := make(chan func(), workers)
+	stop := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		stopOnce sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		stopOnce.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+
+	for i := 0; i < min(workers, len(tasks)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				fn, merge := newPart()
+				if err := task(txn, fn); err != nil {
+					fail(err)
+					continue
+				}
+				done <- merge
+			}
+		}()
+	}
+
+	go func() {
+	feed:
+		for _, task := range tasks {
+			select {
+			case queue <- task:
+			case <-stop:
+				break feed
+			}
+		}
+		close(queue)
+		wg.Wait()
+		close(done)
+	}()
+
+	for merge := range done {
+		merge()
+	}
+	return firstErr
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// replicationMainDB names the main database in replication requests;
+	// shards are named after their directory under the storage path
+	replicationMainDB = "main"
+	// promotedFile marks a standby's storage path once it is promoted, so
+	// it starts as a server from then on
+	promotedFile = "PROMOTED"
+	// metadataSnapshotFile is where sqlite metadata is snapshotted before
+	// it is shipped, and received before it replaces metadata.db
+	metadataSnapshotFile = "metadata.db.replica"
+	// replicationVersionTrailer carries the version a segment reaches,
+	// which is only known once it has been sent
+	replicationVersionTrailer = "X-Replication-Version"
+)
+
+// ReplicationStatus is a standby's progress: the latest version applied to
+// each database, and when it last applied writes and metadata
+type ReplicationStatus struct {
+	Databases   map[string]uint64 `json:"databases"`
+	LastApplied time.Time         `json:"last_applied,omitempty"`
+	MetadataAt  time.Time         `json:"metadata_at,omitempty"`
+}
+
+// Replicator ships the writes to a primary's databases to a warm standby
+// or a read replica.
+// Each run sends every database whose latest version is past the one the
+// standby has applied as an incremental Badger backup of the newer
+// versions, and tells the standby about shards dropped since.
+type Replicator struct {
+	store  *BadgerStore
+	config *utils.StorageConfig
+	logger *zap.Logger
+	client *http.Client
+	// target is the base URL of the standby or replica
+	target string
+	// meta is set when metadata is kept in sqlite, which is shipped as a
+	// snapshot rather than a stream of writes
+	meta     *SQLMetadataStore
+	metaSent time.Time
+
+	// applied is the standby's latest version of each database, loaded
+	// from it on the first run and after a failure
+	applied map[string]uint64
+}
+
+// NewReplicator creates a replicator shipping the primary's storage to
+// target
+func NewReplicator(config *utils.StorageConfig, target string, store *BadgerStore, meta MetadataStore, logger *zap.Logger) (*Replicator, error) {
+	client, err := replicationClient(&config.Replication)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replicator{
+		store:  store,
+		config: config,
+		logger: logger.With(zap.String("target", target)),
+		client: client,
+		target: target,
+	}
+	if sqlMeta, ok := meta.(*SQLMetadataStore); ok && !sqlMeta.postgres {
+		r.meta = sqlMeta
+	}
+	return r, nil
+}
+
+// Run ships the writes since the last run, and metadata when it is due
+func (r *Replicator) Run(ctx context.Context) error {
+	if r.applied == nil {
+		status, err := fetchReplicationStatus(ctx, r.client, r.target, r.config.Replication.Token)
+		if err != nil {
+			return err
+		}
+		r.applied = status.Databases
+	}
+
+	if err := r.run(ctx); err != nil {
+		// The standby may have applied part of what was sent
+		r.applied = nil
+		return err
+	}
+
+	if r.meta != nil && time.Since(r.metaSent) >= r.config.Replication.MetadataInterval {
+		if err := r.shipMetadata(ctx); err != nil {
+			return err
+		}
+		r.metaSent = time.Now()
+	}
+	return nil
+}
+
+func (r *Replicator) run(ctx context.Context) error {
+	var shipped int
+	if r.store.db.MaxVersion() > r.applied[replicationMainDB] {
+		if err := r.ship(ctx, replicationMainDB, r.store.db); err != nil {
+			return err
+		}
+		shipped++
+	}
+
+	local := make(map[string]bool)
+	for _, sv := range r.store.shards.versions() {
+		name := shardsDir + "/" + filepath.Base(sv.shard.dir)
+		local[name] = true
+		// A shard not opened since the server started may have changed
+		// before it stopped
+		if sv.version != 0 && sv.version <= r.applied[name] {
+			continue
+		}
+
+		// A shard dropped meanwhile is skipped, and dropped on the standby
+		// by the next run
+		err := r.store.withShard(sv.shard, func(db *badger.DB) error {
+			if db.MaxVersion() <= r.applied[name] {
+				return nil
+			}
+			shipped++
+			return r.ship(ctx, name, db)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for name := range r.applied {
+		if name == replicationMainDB || local[name] {
+			continue
+		}
+		if err := r.drop(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	if shipped > 0 {
+		r.logger.Debug("Replicated storage writes", zap.Int("databases", shipped))
+	}
+	return nil
+}
+
+// ship sends the versions of db the standby has not applied
+func (r *Replicator) ship(ctx context.Context, name string, db *badger.DB) error {
+	body, w := io.Pipe()
+	req, err := newReplicationRequest(ctx, http.MethodPost, r.target+"/replication/segment?db="+url.QueryEscape(name), r.config.Replication.Token, body)
+	if err != nil {
+		return err
+	}
+	req.Trailer = http.Header{replicationVersionTrailer: nil}
+
+	since := r.applied[name]
+	go func() {
+		gz := gzip.NewWriter(w)
+		// Backup sends the versions after since
+		version, err := db.Backup(gz, since)
+		if err == nil {
+			err = gz.Close()
+		}
+		if version < since {
+			version = since
+		}
+		// The trailer is sent once the body is closed
+		req.Trailer.Set(replicationVersionTrailer, strconv.FormatUint(version, 10))
+		w.CloseWithError(err)
+	}()
+
+	var status struct {
+		Version uint64 `json:"version"`
+	}
+	err = doReplicationRequest(r.client, req, &status)
+	body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to replicate %s: %w", name, err)
+	}
+	r.applied[name] = status.Version
+	return nil
+}
+
+// drop tells the standby to delete a shard dropped by retention or
+// archived to the cold tier
+func (r *Replicator) drop(ctx context.Context, name string) error {
+	if err := r.request(ctx, http.MethodPost, "/replication/drop?db="+url.QueryEscape(name), nil, nil); err != nil {
+		return fmt.Errorf("failed to drop %s on the standby: %w", name, err)
+	}
+	delete(r.applied, name)
+	return nil
+}
+
+// shipMetadata sends a snapshot of the sqlite metadata
+func (r *Replicator) shipMetadata(ctx context.Context) error {
+	path := filepath.Join(r.config.Path, metadataSnapshotFile)
+	os.Remove(path)
+	if err := r.meta.Snapshot(path); err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open metadata snapshot: %w", err)
+	}
+	defer f.Close()
+
+	body, w := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(w)
+		_, err := io.Copy(gz, f)
+		if err == nil {
+			err = gz.Close()
+		}
+		w.CloseWithError(err)
+	}()
+
+	err = r.request(ctx, http.MethodPut, "/replication/metadata", body, nil)
+	body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to replicate metadata: %w", err)
+	}
+	return nil
+}
+
+func (r *Replicator) request(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	return replicationRequest(ctx, r.client, method, r.target+path, r.config.Replication.Token, body, out)
+}
+
+// StandbyStatus returns the progress of the standby a config describes
+func StandbyStatus(ctx context.Context, config *utils.StorageConfig) (*ReplicationStatus, error) {
+	client, err := replicationClient(&config.Replication)
+	if err != nil {
+		return nil, err
+	}
+	return fetchReplicationStatus(ctx, client, standbyURL(&config.Replication), config.Replication.Token)
+}
+
+// PromoteStandby stops the standby a config describes from applying
+// writes and has it start as a server
+func PromoteStandby(ctx context.Context, config *utils.StorageConfig) error {
+	client, err := replicationClient(&config.Replication)
+	if err != nil {
+		return err
+	}
+	return replicationRequest(ctx, client, http.MethodPost, standbyURL(&config.Replication)+"/replication/promote", config.Replication.Token, nil, nil)
+}
+
+// IsPromoted reports whether the standby with a storage path has been
+// promoted
+func IsPromoted(path string) bool {
+	_, err := os.Stat(filepath.Join(path, promotedFile))
+	return err == nil
+}
+
+// standbyURL is the standby URL of a primary's config, or the address a
+// standby's config listens on
+func standbyURL(config *utils.ReplicationConfig) string {
+	if config.Role == utils.ReplicationRolePrimary {
+		return config.StandbyURL
+	}
+	scheme := "http"
+	if config.CertFile != "" {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(config.Listen)
+	if err != nil {
+		return scheme + "://" + config.Listen
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+func fetchReplicationStatus(ctx context.Context, client *http.Client, base, token string) (*ReplicationStatus, error) {
+	var status ReplicationStatus
+	if err := replicationRequest(ctx, client, http.MethodGet, base+"/replication/status", token, nil, &status); err != nil {
+		return nil, fmt.Errorf("failed to get standby status: %w", err)
+	}
+	if status.Databases == nil {
+		status.Databases = make(map[string]uint64)
+	}
+	return &status, nil
+}
+
+// replicationRequest sends a request to a standby and decodes its JSON
+// response into out, if set
+func replicationRequest(ctx context.Context, client *http.Client, method, target, token string, body io.Reader, out interface{}) error {
+	req, err := newReplicationRequest(ctx, method, target, token, body)
+	if err != nil {
+		return err
+	}
+	return doReplicationRequest(client, req, out)
+}
+
+func newReplicationRequest(ctx context.Context, method, target, token string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+func doReplicationRequest(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("standby returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// replicationClient returns an HTTP client that verifies the standby with
+// the configured CA, if any
+func replicationClient(config *utils.ReplicationConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.CAFile != "" {
+		data, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read replication CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport}, nil
+}
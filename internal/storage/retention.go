@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -13,6 +14,10 @@ type RetentionManager struct {
 	config *utils.StorageConfig
 	store  *BadgerStore
 	logger *zap.Logger
+	// meta holds the alert event log
+	meta MetadataStore
+	// rollups is set when rollups are enabled
+	rollups *RollupManager
 }
 
 // NewRetentionManager creates a new retention manager
@@ -21,6 +26,7 @@ func NewRetentionManager(config *utils.StorageConfig, store *BadgerStore, logger
 		config: config,
 		store:  store,
 		logger: logger,
+		meta:   store,
 	}
 }
 
@@ -37,14 +43,27 @@ func (rm *RetentionManager) Cleanup() error {
 		zap.Duration("retention_period", rm.config.RetentionPeriod),
 	)
 
-	// Delete metrics older than retention period
-	deleted, err := rm.store.DeleteMetricsOlderThan(cutoffTime)
+	// Samples are dropped a whole shard at a time
+	droppedShards := rm.store.DropShardsBefore(cutoffTime)
+
+	// The alert event log follows the same retention period
+	deletedEvents, err := rm.meta.DeleteAlertEventsOlderThan(cutoffTime)
 	if err != nil {
-		return fmt.Errorf("failed to delete old metrics: %w", err)
+		return fmt.Errorf("failed to delete old alert events: %w", err)
+	}
+
+	// Each rollup resolution has its own retention
+	var deletedRollups int64
+	if rm.rollups != nil {
+		if deletedRollups, err = rm.rollups.Cleanup(); err != nil {
+			return err
+		}
 	}
 
 	rm.logger.Info("Retention cleanup completed",
-		zap.Int64("deleted_metrics", deleted),
+		zap.Int("dropped_shards", droppedShards),
+		zap.Int64("deleted_alert_events", deletedEvents),
+		zap.Int64("deleted_rollups", deletedRollups),
 	)
 
 	// Run garbage collection if enabled
@@ -56,7 +75,7 @@ func (rm *RetentionManager) Cleanup() error {
 }
 
 // ApplyTieringPolicy applies tiered retention (hot/warm/cold)
-func (rm *RetentionManager) ApplyTieringPolicy() error {
+func (rm *RetentionManager) ApplyTieringPolicy(ctx context.Context) error {
 	if !rm.config.Tiering.Enabled {
 		return nil
 	}
@@ -84,29 +103,34 @@ func (rm *RetentionManager) ApplyTieringPolicy() error {
 	}
 
 	// Archive cold data
-	if rm.config.Tiering.ColdPath != "" {
-		if err := rm.archiveColdData(coldCutoff, warmCutoff); err != nil {
-			rm.logger.Warn("Failed to archive cold data", zap.Error(err))
-		}
-	}
-
-	return nil
+	return rm.archiveColdData(ctx, coldCutoff, warmCutoff)
 }
 
-// archiveColdData moves old metrics to archive storage
-func (rm *RetentionManager) archiveColdData(start, end time.Time) error {
-	rm.logger.Info("Archiving cold data",
-		zap.Time("start", start),
-		zap.Time("end", end),
-	)
+// archiveColdData moves shards that ended before the warm cutoff to the
+// cold tier and deletes archived blocks that ended before the cold cutoff
+func (rm *RetentionManager) archiveColdData(ctx context.Context, coldCutoff, warmCutoff time.Time) error {
+	archived, err := rm.store.ArchiveShardsBefore(ctx, warmCutoff)
+	if err != nil {
+		// Blocks archived before the error are still recorded below
+		rm.logger.Warn("Failed to archive cold data", zap.Error(err))
+	}
+
+	deleted, err := rm.store.DeleteArchivedBefore(ctx, coldCutoff)
+	if err != nil {
+		rm.logger.Warn("Failed to delete expired cold data", zap.Error(err))
+	}
 
-	// This is a placeholder for actual archival logic
-	// In a real implementation, you would:
-	// 1. Export metrics in the time range to a file
-	// 2. Compress the file
-	// 3. Move to cold storage (S3, etc.)
-	// 4. Delete from hot storage
+	// The index is written every run, so one failed upload is repaired
+	if err := rm.store.SyncArchiveIndex(ctx); err != nil {
+		return err
+	}
 
+	if archived+deleted > 0 {
+		rm.logger.Info("Cold tier updated",
+			zap.Int("archived_blocks", archived),
+			zap.Int("deleted_blocks", deleted),
+		)
+	}
 	return nil
 }
 
@@ -0,0 +1,592 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	rawPrefix       = "metric:"
+	rollupPrefix    = "rollup:"
+	rollupMarkKey   = "rollupmark:"
+	rollupChunkSize = 60 // buckets computed per write batch
+)
+
+// rollupAgg is the aggregate of a series' samples in one bucket
+type rollupAgg struct {
+	Count  float64           `json:"c"`
+	Sum    float64           `json:"s"`
+	Min    float64           `json:"mn"`
+	Max    float64           `json:"mx"`
+	Labels map[string]string `json:"l,omitempty"`
+	NodeID string            `json:"n"`
+	Type   string            `json:"t"`
+}
+
+func (a *rollupAgg) add(value float64) {
+	if a.Count == 0 || value < a.Min {
+		a.Min = value
+	}
+	if a.Count == 0 || value > a.Max {
+		a.Max = value
+	}
+	a.Count++
+	a.Sum += value
+}
+
+func (a *rollupAgg) merge(b *rollupAgg) {
+	if b.Count == 0 {
+		return
+	}
+	if a.Count == 0 || b.Min < a.Min {
+		a.Min = b.Min
+	}
+	if a.Count == 0 || b.Max > a.Max {
+		a.Max = b.Max
+	}
+	a.Count += b.Count
+	a.Sum += b.Sum
+}
+
+// rollupPrefixFor returns the key prefix of a resolution's buckets
+func rollupPrefixFor(resolution time.Duration) string {
+	return fmt.Sprintf("%s%d:", rollupPrefix, int64(resolution/time.Second))
+}
+
+// splitSeriesKey splits a "<prefix><name>:<timestamp>:<labels hash>" key.
+// The name may itself contain colons.
+func splitSeriesKey(key []byte, prefix string) (name string, ts int64, hash string, ok bool) {
+	rest := bytes.TrimPrefix(key, []byte(prefix))
+	i := bytes.LastIndexByte(rest, ':')
+	if i < 0 {
+		return "", 0, "", false
+	}
+	hash = string(rest[i+1:])
+	rest = rest[:i]
+	j := bytes.LastIndexByte(rest, ':')
+	if j < 0 {
+		return "", 0, "", false
+	}
+	ts, err := strconv.ParseInt(string(rest[j+1:]), 10, 64)
+	if err != nil {
+		return "", 0, "", false
+	}
+	return string(rest[:j]), ts, hash, true
+}
+
+// scanRange calls fn for each key under prefix with a timestamp in
+// [from, to). Series keys sort by name and then timestamp, so each name is
+// sought directly to from and skipped once past to. An empty name scans
+// every name.
+func scanRange(txn *badger.Txn, prefix, name string, from, to time.Time, fn func(item *badger.Item, name string, ts int64, hash string) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(prefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	fromNano, toNano := from.UnixNano(), to.UnixNano()
+	seekTo := func(target []byte) {
+		// Only seek forward, so keys that sort unexpectedly cannot loop
+		if bytes.Compare(it.Item().Key(), target) < 0 {
+			it.Seek(target)
+		} else {
+			it.Next()
+		}
+	}
+
+	if name != "" {
+		it.Seek([]byte(fmt.Sprintf("%s%s:%d", prefix, name, fromNano)))
+	} else {
+		it.Rewind()
+	}
+	for it.Valid() {
+		item := it.Item()
+		n, ts, hash, ok := splitSeriesKey(item.Key(), prefix)
+		switch {
+		case !ok:
+			it.Next()
+		case name != "" && n != name:
+			return nil
+		case ts < fromNano:
+			seekTo([]byte(fmt.Sprintf("%s%s:%d", prefix, n, fromNano)))
+		case ts >= toNano:
+			if name != "" {
+				return nil
+			}
+			// Past the largest timestamp, but before names that extend this
+			// one with a colon
+			seekTo([]byte(prefix + n + ":9999999999999999999;"))
+		default:
+			if err := fn(item, n, ts, hash); err != nil {
+				return err
+			}
+			it.Next()
+		}
+	}
+	return nil
+}
+
+// querySeries collects a query's samples into step buckets per series
+type querySeries map[string]*bucketedSeries
+
+type bucketedSeries struct {
+	labels  map[string]string
+	buckets map[int64]*rollupAgg
+}
+
+func (q querySeries) add(labels map[string]string, bucket int64, agg *rollupAgg) {
+	key := utils.HashLabels(labels)
+	s, ok := q[key]
+	if !ok {
+		s = &bucketedSeries{labels: utils.Symbols.InternLabels(labels), buckets: make(map[int64]*rollupAgg)}
+		q[key] = s
+	}
+	b, ok := s.buckets[bucket]
+	if !ok {
+		b = &rollupAgg{}
+		s.buckets[bucket] = b
+	}
+	b.merge(agg)
+}
+
+// merge adds the buckets of another query's series to q
+func (q querySeries) merge(other querySeries) {
+	for key, o := range other {
+		s, ok := q[key]
+		if !ok {
+			q[key] = o
+			continue
+		}
+		for bucket, agg := range o.buckets {
+			if b, ok := s.buckets[bucket]; ok {
+				b.merge(agg)
+			} else {
+				s.buckets[bucket] = agg
+			}
+		}
+	}
+}
+
+// timeSeries returns the average of each bucket, oldest first
+func (q querySeries) timeSeries() []*models.TimeSeries {
+	keys := make([]string, 0, len(q))
+	for key := range q {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	series := make([]*models.TimeSeries, 0, len(q))
+	for _, key := range keys {
+		s := q[key]
+		ts := &models.TimeSeries{Labels: s.labels, Samples: make([]models.Sample, 0, len(s.buckets))}
+		for bucket, agg := range s.buckets {
+			ts.Samples = append(ts.Samples, models.Sample{
+				Timestamp: time.Unix(0, bucket),
+				Value:     agg.Sum / agg.Count,
+			})
+		}
+		sort.Slice(ts.Samples, func(i, j int) bool {
+			return ts.Samples[i].Timestamp.Before(ts.Samples[j].Timestamp)
+		})
+		series = append(series, ts)
+	}
+	return series
+}
+
+// queryRaw adds the samples of a metric in [start, end] to q, reading
+// only the matching series of indexed shards. Workers aggregate their
+// series into parts of their own, merged into q as each finishes.
+func (s *BadgerStore) queryRaw(q querySeries, name string, filters map[string]string, start, end time.Time, step time.Duration) error {
+	return s.scanSeries(name, filters, start, end.Add(1), func() (sampleFunc, func()) {
+		part := make(querySeries)
+		fn := func(metric *models.Metric, _ string, _ int64) error {
+			if !s.matchesFilters(metric, filters) {
+				return nil
+			}
+			agg := &rollupAgg{}
+			agg.add(metric.Value)
+			part.add(metric.Labels, bucketOf(metric.Timestamp.UnixNano(), step), agg)
+			return nil
+		}
+		return fn, func() { q.merge(part) }
+	})
+}
+
+// queryRollup adds a resolution's buckets of a metric in [start, end) to q
+func (s *BadgerStore) queryRollup(q querySeries, resolution time.Duration, name string, filters map[string]string, start, end time.Time, step time.Duration) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		return scanRange(txn, rollupPrefixFor(resolution), name, start, end, func(item *badger.Item, _ string, ts int64, _ string) error {
+			var agg rollupAgg
+			if err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &agg)
+			}); err != nil {
+				return nil
+			}
+			for k, v := range filters {
+				if agg.Labels[k] != v {
+					return nil
+				}
+			}
+			q.add(agg.Labels, bucketOf(ts, step), &agg)
+			return nil
+		})
+	})
+}
+
+// bucketOf returns the start of the step a timestamp falls in
+func bucketOf(ts int64, step time.Duration) int64 {
+	if step <= 0 {
+		return ts
+	}
+	return time.Unix(0, ts).Truncate(step).UnixNano()
+}
+
+// computeRollup aggregates the source samples in [from, to) into buckets
+// of resolution. The source is raw samples when source is 0, otherwise
+// the buckets of that resolution.
+func (s *BadgerStore) computeRollup(resolution, source time.Duration, from, to time.Time) (int, error) {
+	type bucketKey struct {
+		name   string
+		hash   string
+		bucket int64
+	}
+	aggs := make(map[bucketKey]*rollupAgg)
+
+	var err error
+	if source == 0 {
+		err = s.scanSamples("", from, to, func(metric *models.Metric, hash string, _ int64) error {
+			key := bucketKey{metric.Name, hash, bucketOf(metric.Timestamp.UnixNano(), resolution)}
+			agg, ok := aggs[key]
+			if !ok {
+				agg = &rollupAgg{Labels: metric.Labels, NodeID: metric.NodeID, Type: metric.Type.String()}
+				aggs[key] = agg
+			}
+			agg.add(metric.Value)
+			return nil
+		})
+	} else {
+		err = s.db.View(func(txn *badger.Txn) error {
+			return scanRange(txn, rollupPrefixFor(source), "", from, to, func(item *badger.Item, name string, ts int64, hash string) error {
+				var src rollupAgg
+				if err := item.Value(func(val []byte) error {
+					return json.Unmarshal(val, &src)
+				}); err != nil {
+					return nil
+				}
+				key := bucketKey{name, hash, bucketOf(ts, resolution)}
+				agg, ok := aggs[key]
+				if !ok {
+					agg = &rollupAgg{Labels: src.Labels, NodeID: src.NodeID, Type: src.Type}
+					aggs[key] = agg
+				}
+				agg.merge(&src)
+				return nil
+			})
+		})
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	prefix := rollupPrefixFor(resolution)
+	for key, agg := range aggs {
+		value, err := json.Marshal(agg)
+		if err != nil {
+			return 0, err
+		}
+		if err := wb.Set([]byte(fmt.Sprintf("%s%s:%d:%s", prefix, key.name, key.bucket, key.hash)), value); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	return len(aggs), nil
+}
+
+// RollupWatermark returns the end of the last bucket computed at a
+// resolution, or the zero time if none has been
+func (s *BadgerStore) RollupWatermark(resolution time.Duration) (time.Time, error) {
+	var mark time.Time
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(fmt.Sprintf("%s%d", rollupMarkKey, int64(resolution/time.Second))))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			nanos, err := strconv.ParseInt(string(val), 10, 64)
+			if err != nil {
+				return err
+			}
+			mark = time.Unix(0, nanos)
+			return nil
+		})
+	})
+	return mark, err
+}
+
+func (s *BadgerStore) setRollupWatermark(resolution time.Duration, mark time.Time) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(fmt.Sprintf("%s%d", rollupMarkKey, int64(resolution/time.Second))),
+			[]byte(strconv.FormatInt(mark.UnixNano(), 10)))
+	})
+}
+
+// DeleteRollupsOlderThan deletes a resolution's buckets that start before
+// the cutoff
+func (s *BadgerStore) DeleteRollupsOlderThan(resolution time.Duration, cutoff time.Time) (int64, error) {
+	var keys [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		return scanRange(txn, rollupPrefixFor(resolution), "", time.Unix(0, 0), cutoff, func(item *badger.Item, _ string, _ int64, _ string) error {
+			keys = append(keys, item.KeyCopy(nil))
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(keys)), deleteKeys(s.db, keys)
+}
+
+// deleteNodeRollups deletes every bucket of a node's series
+func (s *BadgerStore) deleteNodeRollups(nodeID string) error {
+	var keys [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(rollupPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var agg struct {
+				NodeID string `json:"n"`
+			}
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &agg)
+			}); err != nil || agg.NodeID != nodeID {
+				continue
+			}
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteKeys(s.db, keys)
+}
+
+func deleteKeys(db *badger.DB, keys [][]byte) error {
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// RollupManager computes rollups in the background and picks the
+// resolution a query reads
+type RollupManager struct {
+	store  *BadgerStore
+	logger *zap.Logger
+	delay  time.Duration
+	levels []*rollupLevel
+}
+
+type rollupLevel struct {
+	resolution time.Duration
+	retention  time.Duration
+	// source is the resolution this level is computed from; 0 is raw
+	source time.Duration
+
+	mu        sync.RWMutex
+	watermark time.Time
+}
+
+func (l *rollupLevel) Watermark() time.Time {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.watermark
+}
+
+// NewRollupManager creates a rollup manager for the configured
+// resolutions, which must be ascending
+func NewRollupManager(config *utils.StorageConfig, store *BadgerStore, logger *zap.Logger) (*RollupManager, error) {
+	rm := &RollupManager{
+		store:  store,
+		logger: logger,
+		delay:  config.Rollups.Delay,
+	}
+
+	var source time.Duration
+	for _, r := range config.Rollups.Resolutions {
+		mark, err := store.RollupWatermark(r.Resolution)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s rollup watermark: %w", r.Resolution, err)
+		}
+		rm.levels = append(rm.levels, &rollupLevel{
+			resolution: r.Resolution,
+			retention:  r.Retention,
+			source:     source,
+			watermark:  mark,
+		})
+		source = r.Resolution
+	}
+	return rm, nil
+}
+
+// reloadWatermarks reads the watermark of every level from the store,
+// for a read replica whose rollups are computed by its primary
+func (rm *RollupManager) reloadWatermarks() error {
+	for _, level := range rm.levels {
+		mark, err := rm.store.RollupWatermark(level.resolution)
+		if err != nil {
+			return fmt.Errorf("failed to load %s rollup watermark: %w", level.resolution, err)
+		}
+		level.mu.Lock()
+		level.watermark = mark
+		level.mu.Unlock()
+	}
+	return nil
+}
+
+// Run computes every bucket that has ended since the last run. On the
+// first run each resolution is backfilled over its retention, limited to
+// what its source still holds.
+func (rm *RollupManager) Run(rawRetention time.Duration) error {
+	now := time.Now()
+	var sourceMark time.Time
+	sourceRetention := rawRetention
+
+	for i, level := range rm.levels {
+		cutoff := now.Add(-rm.delay).Truncate(level.resolution)
+		if i > 0 {
+			// Only buckets whose source buckets are all computed
+			cutoff = sourceMark.Truncate(level.resolution)
+		}
+
+		from := level.Watermark()
+		if from.IsZero() {
+			from = now.Add(-time.Duration(math.Min(float64(level.retention), float64(sourceRetention)))).Truncate(level.resolution)
+		}
+
+		for from.Before(cutoff) {
+			to := from.Add(level.resolution * rollupChunkSize)
+			if to.After(cutoff) {
+				to = cutoff
+			}
+			n, err := rm.store.computeRollup(level.resolution, level.source, from, to)
+			if err != nil {
+				return fmt.Errorf("failed to compute %s rollups: %w", level.resolution, err)
+			}
+			if err := rm.store.setRollupWatermark(level.resolution, to); err != nil {
+				return fmt.Errorf("failed to save %s rollup watermark: %w", level.resolution, err)
+			}
+
+			level.mu.Lock()
+			level.watermark = to
+			level.mu.Unlock()
+
+			rm.logger.Debug("Computed rollups",
+				zap.Duration("resolution", level.resolution),
+				zap.Time("from", from),
+				zap.Time("to", to),
+				zap.Int("buckets", n),
+			)
+			from = to
+		}
+
+		sourceMark = level.Watermark()
+		sourceRetention = level.retention
+	}
+	return nil
+}
+
+// Cleanup deletes buckets older than each resolution's retention
+func (rm *RollupManager) Cleanup() (int64, error) {
+	var deleted int64
+	for _, level := range rm.levels {
+		n, err := rm.store.DeleteRollupsOlderThan(level.resolution, time.Now().Add(-level.retention))
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete old %s rollups: %w", level.resolution, err)
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// levelFor picks the rollups a query reads: the coarsest resolution that
+// fits in the step and still holds the start of the range. When raw samples
+// no longer hold the start either, the finest coarser resolution that does
+// is used. Otherwise the query reads raw samples.
+func (rm *RollupManager) levelFor(start time.Time, step, rawRetention time.Duration) *rollupLevel {
+	if step <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	covers := func(l *rollupLevel) bool {
+		return !start.Before(now.Add(-l.retention)) && l.Watermark().After(start)
+	}
+
+	for i := len(rm.levels) - 1; i >= 0; i-- {
+		l := rm.levels[i]
+		if l.resolution <= step && step%l.resolution == 0 && covers(l) {
+			return l
+		}
+	}
+	if !start.Before(now.Add(-rawRetention)) {
+		return nil
+	}
+	for _, l := range rm.levels {
+		if l.resolution > step && covers(l) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Query reads a metric from rollups up to the level's watermark and from
+// raw samples after it
+func (rm *RollupManager) Query(level *rollupLevel, query *models.Query) ([]*models.TimeSeries, error) {
+	step := query.Step
+	if step < level.resolution {
+		step = level.resolution
+	}
+
+	split := level.Watermark().Truncate(step)
+	if split.After(query.EndTime) {
+		split = query.EndTime
+	}
+
+	q := make(querySeries)
+	if err := rm.store.queryRollup(q, level.resolution, query.MetricName, query.Labels, query.StartTime, split, step); err != nil {
+		return nil, fmt.Errorf("failed to query %s rollups: %w", level.resolution, err)
+	}
+	if split.Before(query.EndTime) {
+		if err := rm.store.queryRaw(q, query.MetricName, query.Labels, split, query.EndTime, step); err != nil {
+			return nil, err
+		}
+	}
+	return q.timeSeries(), nil
+}
@@ -0,0 +1,786 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// shardsDir is the directory under the storage path that holds one
+	// Badger database per time block
+	shardsDir = "shards"
+	// sealedFile marks a shard whose raw samples are all sealed into
+	// chunks, so the chunk compactor does not open it again
+	sealedFile = "SEALED"
+)
+
+var (
+	// errShardDropped is returned when opening a shard dropped by retention
+	errShardDropped = errors.New("shard dropped")
+	// beginningOfTime and endOfTime bound a scan of every shard
+	beginningOfTime = time.Unix(0, 0)
+	endOfTime       = time.Unix(0, math.MaxInt64)
+)
+
+// shard is the Badger database holding the raw samples and chunks of one
+// time block. Its directory is named after the block's start and end in
+// Unix seconds.
+type shard struct {
+	start, end time.Time
+	dir        string
+
+	// Guarded by shardSet.mu. db is only closed once refs drops to zero.
+	db      *badger.DB
+	refs    int
+	used    time.Time
+	sealed  bool
+	dropped bool
+	// writes counts the writers started and writers those still running,
+	// so sealing can tell whether samples arrived while it ran
+	writes  uint64
+	writers int
+	// series holds the IDs of the series indexed since db was opened, so
+	// writes only index new ones
+	series *sync.Map
+	// summary is kept once loaded or built, and updated by writes
+	summary *blockSummary
+
+	// closedVersion is db's latest version when it was last closed, or
+	// zero if it has not been opened, so replication can skip shards that
+	// have not changed without opening them
+	closedVersion uint64
+}
+
+func (sh *shard) contains(t time.Time) bool {
+	return !t.Before(sh.start) && t.Before(sh.end)
+}
+
+func shardDirName(start, end time.Time) string {
+	return fmt.Sprintf("%d-%d", start.Unix(), end.Unix())
+}
+
+// parseShardDirName parses the block of a shard directory
+func parseShardDirName(name string) (start, end time.Time, ok bool) {
+	var s, e int64
+	if _, err := fmt.Sscanf(name, "%d-%d", &s, &e); err != nil || e <= s || fmt.Sprintf("%d-%d", s, e) != name {
+		return time.Time{}, time.Time{}, false
+	}
+	return time.Unix(s, 0), time.Unix(e, 0), true
+}
+
+// shardSet opens shards as they are used, and closes the least recently
+// used once more than maxOpen are open
+type shardSet struct {
+	dir     string
+	block   time.Duration
+	maxOpen int
+	options func(dir string) badger.Options
+	logger  *zap.Logger
+	// summarize builds the summary of an open shard; without it shards are
+	// not summarized
+	summarize func(db *badger.DB) (*blockSummary, error)
+
+	mu sync.Mutex
+	// shards is sorted by start. Blocks only overlap after the block
+	// duration is changed.
+	shards []*shard
+}
+
+// newShardSet finds the shards under dir without opening them
+func newShardSet(dir string, block time.Duration, maxOpen int, options func(dir string) badger.Options, logger *zap.Logger) (*shardSet, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create shard directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shards: %w", err)
+	}
+
+	ss := &shardSet{
+		dir:     dir,
+		block:   block,
+		maxOpen: maxOpen,
+		options: options,
+		logger:  logger,
+	}
+	for _, entry := range entries {
+		start, end, ok := parseShardDirName(entry.Name())
+		if !entry.IsDir() || !ok {
+			continue
+		}
+		sh := &shard{start: start, end: end, dir: filepath.Join(dir, entry.Name())}
+		if _, err := os.Stat(filepath.Join(sh.dir, sealedFile)); err == nil {
+			sh.sealed = true
+		}
+		sh.summary = loadSummary(sh.dir)
+		ss.shards = append(ss.shards, sh)
+	}
+	sort.Slice(ss.shards, func(i, j int) bool { return ss.shards[i].start.Before(ss.shards[j].start) })
+	return ss, nil
+}
+
+// locate returns the shard holding t, or nil. Callers must hold mu.
+func (ss *shardSet) locate(t time.Time) *shard {
+	i := sort.Search(len(ss.shards), func(i int) bool { return ss.shards[i].start.After(t) })
+	for i--; i >= 0; i-- {
+		if ss.shards[i].contains(t) {
+			return ss.shards[i]
+		}
+	}
+	return nil
+}
+
+// acquire returns the shard holding t for writing, creating it if needed.
+// The shard must be released with releaseWrite.
+func (ss *shardSet) acquire(t time.Time) (*shard, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sh := ss.locate(t)
+	created := sh == nil
+	if created {
+		start := t.Truncate(ss.block)
+		sh = ss.addLocked(start, start.Add(ss.block))
+		if ss.summarize != nil {
+			// Nothing to build it from yet
+			sh.summary = newBlockSummary()
+		}
+	}
+
+	if err := ss.openLocked(sh); err != nil {
+		return nil, err
+	}
+	if created {
+		// A new shard indexes its series from the first sample
+		if err := markIndexed(sh.db); err != nil {
+			ss.releaseLocked(sh)
+			return nil, fmt.Errorf("failed to mark shard %s indexed: %w", sh.dir, err)
+		}
+	}
+	if sh.sealed {
+		if err := os.Remove(filepath.Join(sh.dir, sealedFile)); err != nil && !os.IsNotExist(err) {
+			ss.releaseLocked(sh)
+			return nil, fmt.Errorf("failed to unseal shard %s: %w", sh.dir, err)
+		}
+		sh.sealed = false
+	}
+	sh.writes++
+	sh.writers++
+	return sh, nil
+}
+
+// addLocked adds the shard of a block, whose directory need not exist yet.
+// Callers must hold mu.
+func (ss *shardSet) addLocked(start, end time.Time) *shard {
+	sh := &shard{start: start, end: end, dir: filepath.Join(ss.dir, shardDirName(start, end))}
+	i := sort.Search(len(ss.shards), func(i int) bool { return ss.shards[i].start.After(start) })
+	ss.shards = append(ss.shards, nil)
+	copy(ss.shards[i+1:], ss.shards[i:])
+	ss.shards[i] = sh
+	return sh
+}
+
+// exact returns the shard of exactly the block [start, end), adding it
+// if create is set and it is missing
+func (ss *shardSet) exact(start, end time.Time, create bool) *shard {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for _, sh := range ss.shards {
+		if sh.start.Equal(start) && sh.end.Equal(end) {
+			return sh
+		}
+	}
+	if !create {
+		return nil
+	}
+	return ss.addLocked(start, end)
+}
+
+// releaseWrite releases a shard returned by acquire
+func (ss *shardSet) releaseWrite(sh *shard) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sh.writers--
+	ss.releaseLocked(sh)
+}
+
+// overlapping returns the shards overlapping [from, to), oldest first.
+// They are not opened.
+func (ss *shardSet) overlapping(from, to time.Time) []*shard {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var shards []*shard
+	for _, sh := range ss.shards {
+		if sh.start.Before(to) && sh.end.After(from) {
+			shards = append(shards, sh)
+		}
+	}
+	return shards
+}
+
+// open opens a shard for reading. It must be released with release.
+func (ss *shardSet) open(sh *shard) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.openLocked(sh)
+}
+
+func (ss *shardSet) openLocked(sh *shard) error {
+	if sh.dropped {
+		return errShardDropped
+	}
+	if sh.db == nil {
+		db, err := badger.Open(ss.options(sh.dir))
+		if err != nil {
+			return fmt.Errorf("failed to open shard %s: %w", sh.dir, err)
+		}
+		sh.db = db
+		sh.series = &sync.Map{}
+		// Written again when the shard is closed
+		os.Remove(filepath.Join(sh.dir, summaryFile))
+		ss.evictLocked(sh)
+	}
+	sh.refs++
+	sh.used = time.Now()
+	if sh.summary == nil && ss.summarize != nil {
+		sh.summary = newBlockSummary()
+		sh.summary.building = true
+		ss.summarizeLocked(sh)
+	}
+	return nil
+}
+
+// evictLocked closes the least recently used shards that are not in use
+// until at most maxOpen are open
+func (ss *shardSet) evictLocked(keep *shard) {
+	for {
+		var open int
+		var lru *shard
+		for _, sh := range ss.shards {
+			if sh.db == nil {
+				continue
+			}
+			open++
+			if sh != keep && sh.refs == 0 && (lru == nil || sh.used.Before(lru.used)) {
+				lru = sh
+			}
+		}
+		if open <= ss.maxOpen || lru == nil {
+			return
+		}
+		lru.closedVersion = lru.db.MaxVersion()
+		if err := lru.db.Close(); err != nil {
+			ss.logger.Warn("Failed to close shard", zap.String("shard", lru.dir), zap.Error(err))
+		}
+		lru.db = nil
+		lru.series = nil
+		ss.saveSummaryLocked(lru)
+	}
+}
+
+// saveSummaryLocked saves the summary of a shard just closed. Callers
+// must hold mu.
+func (ss *shardSet) saveSummaryLocked(sh *shard) {
+	if sh.summary == nil {
+		return
+	}
+	if err := saveSummary(sh.dir, sh.summary); err != nil {
+		ss.logger.Warn("Failed to save shard summary", zap.String("shard", sh.dir), zap.Error(err))
+	}
+}
+
+// release releases a shard opened with open
+func (ss *shardSet) release(sh *shard) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.releaseLocked(sh)
+}
+
+func (ss *shardSet) releaseLocked(sh *shard) {
+	sh.refs--
+	if sh.dropped && sh.refs == 0 {
+		ss.removeLocked(sh)
+	}
+}
+
+// removeLocked closes a dropped shard and deletes its directory
+func (ss *shardSet) removeLocked(sh *shard) {
+	if sh.db != nil {
+		if err := sh.db.Close(); err != nil {
+			ss.logger.Warn("Failed to close shard", zap.String("shard", sh.dir), zap.Error(err))
+		}
+		sh.db = nil
+		sh.series = nil
+	}
+	if err := os.RemoveAll(sh.dir); err != nil {
+		ss.logger.Error("Failed to delete shard", zap.String("shard", sh.dir), zap.Error(err))
+	}
+}
+
+// drop deletes the shards that end before the cutoff. Shards in use are
+// deleted once released.
+func (ss *shardSet) drop(cutoff time.Time) int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	kept := ss.shards[:0]
+	var dropped int
+	for _, sh := range ss.shards {
+		if sh.end.After(cutoff) {
+			kept = append(kept, sh)
+			continue
+		}
+		sh.dropped = true
+		if sh.refs == 0 {
+			ss.removeLocked(sh)
+		}
+		dropped++
+	}
+	ss.shards = kept
+	return dropped
+}
+
+// dropUnchanged deletes a shard unless it was written to since sealState
+// returned writes, calling commit first and keeping the shard if commit
+// fails. It reports whether the shard was dropped.
+func (ss *shardSet) dropUnchanged(sh *shard, writes uint64, commit func() error) (bool, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if sh.dropped || sh.writers > 0 || sh.writes != writes {
+		return false, nil
+	}
+	if err := commit(); err != nil {
+		return false, err
+	}
+	ss.dropLocked(sh)
+	return true, nil
+}
+
+// dropShard removes a shard from the set and deletes it once released
+func (ss *shardSet) dropShard(sh *shard) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if !sh.dropped {
+		ss.dropLocked(sh)
+	}
+}
+
+// dropLocked removes a shard from the set and deletes it once released.
+// Callers must hold mu.
+func (ss *shardSet) dropLocked(sh *shard) {
+	for i, other := range ss.shards {
+		if other == sh {
+			ss.shards = append(ss.shards[:i], ss.shards[i+1:]...)
+			break
+		}
+	}
+	sh.dropped = true
+	if sh.refs == 0 {
+		ss.removeLocked(sh)
+	}
+}
+
+// trim drops the least recently used shards that are not in use until at
+// most max remain
+func (ss *shardSet) trim(max int) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for len(ss.shards) > max {
+		var lru *shard
+		for _, sh := range ss.shards {
+			if sh.refs == 0 && (lru == nil || sh.used.Before(lru.used)) {
+				lru = sh
+			}
+		}
+		if lru == nil {
+			return
+		}
+		ss.dropLocked(lru)
+	}
+}
+
+// sealState reports whether a shard is sealed and, when no writer is
+// running, the count of writes so far for markSealed
+func (ss *shardSet) sealState(sh *shard) (sealed bool, writes uint64, idle bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return sh.sealed, sh.writes, sh.writers == 0
+}
+
+// markSealed marks a shard sealed, unless it was written to since
+// sealState returned writes
+func (ss *shardSet) markSealed(sh *shard, writes uint64) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if sh.dropped || sh.writers > 0 || sh.writes != writes {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(sh.dir, sealedFile), nil, 0644); err != nil {
+		return fmt.Errorf("failed to mark shard %s sealed: %w", sh.dir, err)
+	}
+	sh.sealed = true
+	return nil
+}
+
+// openShards opens every shard that is already open, for work that is
+// only worth doing on recently used shards. Each must be released.
+func (ss *shardSet) openShards() []*shard {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var shards []*shard
+	for _, sh := range ss.shards {
+		if sh.db != nil {
+			sh.refs++
+			shards = append(shards, sh)
+		}
+	}
+	return shards
+}
+
+// shardVersion is a shard and its database's latest version
+type shardVersion struct {
+	shard   *shard
+	version uint64
+}
+
+// versions returns every shard with its latest version: the open
+// database's, or the one it was closed at. It is zero for shards not
+// opened since the set was created.
+func (ss *shardSet) versions() []shardVersion {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	versions := make([]shardVersion, 0, len(ss.shards))
+	for _, sh := range ss.shards {
+		v := sh.closedVersion
+		if sh.db != nil {
+			v = sh.db.MaxVersion()
+		}
+		versions = append(versions, shardVersion{shard: sh, version: v})
+	}
+	return versions
+}
+
+// close closes every open shard
+func (ss *shardSet) close() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var firstErr error
+	for _, sh := range ss.shards {
+		if sh.db == nil {
+			continue
+		}
+		sh.closedVersion = sh.db.MaxVersion()
+		if err := sh.db.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close shard %s: %w", sh.dir, err)
+		}
+		sh.db = nil
+		sh.series = nil
+		ss.saveSummaryLocked(sh)
+	}
+	return firstErr
+}
+
+// shardWriter acquires the shards a batch of samples is written to, each
+// once, until released
+type shardWriter struct {
+	set  *shardSet
+	held []*shard
+}
+
+func (w *shardWriter) shardFor(t time.Time) (*shard, error) {
+	for _, sh := range w.held {
+		if sh.contains(t) {
+			return sh, nil
+		}
+	}
+	sh, err := w.set.acquire(t)
+	if err != nil {
+		return nil, err
+	}
+	w.held = append(w.held, sh)
+	return sh, nil
+}
+
+func (w *shardWriter) release() {
+	for _, sh := range w.held {
+		w.set.releaseWrite(sh)
+	}
+	w.held = nil
+}
+
+// shardsFor returns the shards overlapping [from, to). While the main
+// database still holds samples written before sharding, it comes first as
+// a nil shard; a sample moved during a scan may then be read twice.
+func (s *BadgerStore) shardsFor(from, to time.Time) []*shard {
+	shards := s.shards.overlapping(from, to)
+	if s.legacy.Load() {
+		shards = append([]*shard{nil}, shards...)
+	}
+	return shards
+}
+
+// withShard calls fn with a shard's database, opening it as needed. A nil
+// shard is the main database. Shards dropped meanwhile are skipped.
+func (s *BadgerStore) withShard(sh *shard, fn func(db *badger.DB) error) error {
+	if sh == nil {
+		return fn(s.db)
+	}
+	if err := s.shards.open(sh); err != nil {
+		if errors.Is(err, errShardDropped) {
+			return nil
+		}
+		return err
+	}
+	defer s.shards.release(sh)
+	return fn(sh.db)
+}
+
+// forEachShard calls fn with the database of each shard overlapping
+// [from, to), oldest first
+func (s *BadgerStore) forEachShard(from, to time.Time, fn func(db *badger.DB) error) error {
+	for _, sh := range s.shardsFor(from, to) {
+		if err := s.withShard(sh, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardOptions returns the Badger options of a shard
+func (s *BadgerStore) shardOptions(dir string) badger.Options {
+	return shardOptions(s.config, s.logger, dir)
+}
+
+// shardOptions returns the options of the shard database in dir
+func shardOptions(config *utils.StorageConfig, logger *zap.Logger, dir string) badger.Options {
+	opts := badger.DefaultOptions(dir)
+	// Badger's info messages would be repeated each time a shard is opened
+	opts.Logger = &badgerLogger{logger: logger.With(zap.String("shard", filepath.Base(dir))), quiet: true}
+	opts.SyncWrites = config.SyncWrites
+	opts.MemTableSize = config.MemTableSize
+	opts.ValueLogFileSize = config.ValueLogFileSize
+	if size, err := utils.ParseByteSize(config.ShardSize); err == nil && size > 0 && size < opts.ValueLogFileSize {
+		opts.ValueLogFileSize = size
+	}
+	// Open shards share the block cache of a single database
+	opts.BlockCacheSize /= int64(config.Shards.MaxOpen)
+	fitMemory(&opts, config)
+	return opts
+}
+
+// DropShardsBefore deletes the shards whose block ends before the cutoff,
+// returning how many were dropped. Samples are kept until their whole
+// block is past the cutoff.
+func (s *BadgerStore) DropShardsBefore(cutoff time.Time) int {
+	return s.shards.drop(cutoff)
+}
+
+// shardDiskUsage returns the bytes of a shard's LSM tree and value log
+// files on disk
+func shardDiskUsage(dir string) (lsm, vlog int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(entry.Name(), ".sst"):
+			lsm += info.Size()
+		case strings.HasSuffix(entry.Name(), ".vlog"):
+			vlog += info.Size()
+		}
+	}
+	return lsm, vlog
+}
+
+// hasLegacySamples reports whether the main database holds samples or
+// chunks written before sharding
+func (s *BadgerStore) hasLegacySamples() (bool, error) {
+	var found bool
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, prefix := range []string{rawPrefix, chunkPrefix} {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte(prefix)
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			it.Rewind()
+			found = found || it.Valid()
+			it.Close()
+		}
+		return nil
+	})
+	return found, err
+}
+
+// migrateToShards moves the samples and chunks written before sharding
+// from the main database into shards, until done or ctx is cancelled.
+// Those already past the retention period are deleted instead.
+func (s *BadgerStore) migrateToShards(ctx context.Context) error {
+	var moved, expired int
+	for _, prefix := range []string{chunkPrefix, rawPrefix} {
+		for ctx.Err() == nil {
+			m, e, err := s.migrateBatch(prefix)
+			if err != nil {
+				return fmt.Errorf("failed to move samples into shards: %w", err)
+			}
+			if m+e == 0 {
+				break
+			}
+			moved += m
+			expired += e
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	s.legacy.Store(false)
+	s.logger.Info("Moved samples into time-partitioned shards",
+		zap.Int("moved", moved),
+		zap.Int("expired", expired),
+	)
+	return nil
+}
+
+// migrateBatch moves up to chunkBatchSamples keys under prefix from the
+// main database into shards
+func (s *BadgerStore) migrateBatch(prefix string) (moved, expired int, err error) {
+	cutoff := time.Now().Add(-s.config.RetentionPeriod)
+	if prefix == chunkPrefix {
+		// Chunk keys hold the chunk's start
+		cutoff = cutoff.Add(-s.chunkSpan())
+	}
+
+	type entry struct {
+		key, value []byte
+		name, hash string
+		ts         time.Time
+	}
+	var entries []entry
+	var keys [][]byte
+	err = s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid() && len(keys) < chunkBatchSamples; it.Next() {
+			item := it.Item()
+			keys = append(keys, item.KeyCopy(nil))
+
+			// Keys that cannot be read back are deleted too
+			name, ts, hash, ok := splitSeriesKey(item.Key(), prefix)
+			if !ok || (s.config.RetentionPeriod > 0 && time.Unix(0, ts).Before(cutoff)) {
+				continue
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{key: keys[len(keys)-1], value: value, name: name, hash: hash, ts: time.Unix(0, ts)})
+		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, 0, err
+	}
+
+	w := &shardWriter{set: s.shards}
+	defer w.release()
+
+	batches := make(map[*shard]*badger.WriteBatch)
+	indexed := make(map[*shard]map[string]bool)
+	series := make(map[*shard][]*seriesEntry)
+	bounds := make(map[*shard][2]int64)
+	for _, e := range entries {
+		sh, err := w.shardFor(e.ts)
+		if err != nil {
+			return 0, 0, err
+		}
+		wb, ok := batches[sh]
+		if !ok {
+			wb = sh.db.NewWriteBatch()
+			defer wb.Cancel()
+			batches[sh] = wb
+		}
+		if err := wb.Set(e.key, e.value); err != nil {
+			return 0, 0, err
+		}
+		first, last := e.ts.UnixNano(), e.ts.UnixNano()
+		if prefix == chunkPrefix {
+			if meta, _, err := decodeChunk(e.value); err == nil {
+				first, last = meta.MinTime*int64(time.Millisecond), meta.MaxTime*int64(time.Millisecond)
+			}
+		}
+		if b, ok := bounds[sh]; ok {
+			first, last = min(first, b[0]), max(last, b[1])
+		}
+		bounds[sh] = [2]int64{first, last}
+
+		id := seriesID(e.name, e.hash)
+		if prefix == chunkPrefix {
+			if err := wb.Set(chunkRefKey(id, e.ts.UnixNano()), nil); err != nil {
+				return 0, 0, err
+			}
+		}
+		if _, ok := sh.series.Load(id); ok || indexed[sh][id] {
+			continue
+		}
+		labels, err := sampleLabels(prefix, e.value)
+		if err != nil {
+			continue
+		}
+		entry := &seriesEntry{Name: e.name, Hash: e.hash, Labels: labels}
+		if err := indexSeries(wb.Set, entry); err != nil {
+			return 0, 0, err
+		}
+		series[sh] = append(series[sh], entry)
+		if err := wb.Delete(tombstoneKey(id)); err != nil {
+			return 0, 0, err
+		}
+		if indexed[sh] == nil {
+			indexed[sh] = make(map[string]bool)
+		}
+		indexed[sh][id] = true
+	}
+	for sh, wb := range batches {
+		if err := wb.Flush(); err != nil {
+			return 0, 0, err
+		}
+		for id := range indexed[sh] {
+			sh.series.Store(id, struct{}{})
+		}
+		s.shards.observe(sh, bounds[sh][0], bounds[sh][1], series[sh])
+	}
+
+	// Keys are deleted once written to their shard; after a crash in
+	// between they are moved again, overwriting the copy
+	if err := deleteKeys(s.db, keys); err != nil {
+		return 0, 0, err
+	}
+	return len(entries), len(keys) - len(entries), nil
+}
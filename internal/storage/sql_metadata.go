@@ -0,0 +1,881 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meettoy2004/lnmonja/internal/models"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+
+	// Registers the postgres database/sql driver
+	_ "github.com/lib/pq"
+)
+
+// metadataMigrations are applied in order and recorded in
+// schema_migrations; never edit one that has shipped, append a new one.
+// Records are stored as JSON documents, with the columns queries filter
+// and sort on alongside.
+var metadataMigrations = [][]string{
+	{
+		`CREATE TABLE nodes (
+			id TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE alerts (
+			id TEXT PRIMARY KEY,
+			state INTEGER NOT NULL,
+			node_id TEXT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX alerts_state ON alerts (state)`,
+		`CREATE TABLE alert_events (
+			seq BIGINT PRIMARY KEY,
+			time BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX alert_events_time ON alert_events (time)`,
+		`CREATE TABLE derived_metrics (
+			name TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE ingest_rules (
+			name TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE annotations (
+			id TEXT PRIMARY KEY,
+			time BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX annotations_time ON annotations (time)`,
+		`CREATE TABLE silences (
+			id TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+	},
+	{
+		`CREATE TABLE dashboards (
+			id TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE dashboard_versions (
+			dashboard_id TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (dashboard_id, version)
+		)`,
+	},
+	{
+		`CREATE TABLE tenant_usage (
+			day TEXT NOT NULL,
+			tenant TEXT NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (day, tenant)
+		)`,
+	},
+	{
+		`CREATE TABLE node_months (
+			month TEXT NOT NULL,
+			node_id TEXT NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (month, node_id)
+		)`,
+	},
+}
+
+// execer is a database or a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLMetadataStore keeps metadata in SQLite or PostgreSQL
+type SQLMetadataStore struct {
+	db       *sql.DB
+	postgres bool
+	logger   *zap.Logger
+
+	// alertSeq is the sequence of the newest alert event, loaded on first
+	// use
+	alertSeq       uint64
+	alertSeqLoaded bool
+	alertSeqMu     sync.Mutex
+}
+
+// OpenSQLMetadataStore connects to the database and applies pending
+// migrations. When the schema is created, the metadata in seed, if any, is
+// copied in the same transaction.
+func OpenSQLMetadataStore(driver, dsn string, seed *BadgerStore, logger *zap.Logger) (*SQLMetadataStore, error) {
+	sqlDriver := "sqlite3"
+	if driver == utils.MetadataDriverPostgres {
+		sqlDriver = "postgres"
+	}
+
+	db, err := sql.Open(sqlDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == utils.MetadataDriverSQLite {
+		// SQLite allows one writer; serialize rather than fail with
+		// SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	s := &SQLMetadataStore{
+		db:       db,
+		postgres: driver == utils.MetadataDriverPostgres,
+		logger:   logger.Named("metadata"),
+	}
+	if err := s.migrate(seed); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.logger.Info("Metadata store opened", zap.String("driver", driver))
+	return s, nil
+}
+
+// migrate applies the migrations not yet recorded in schema_migrations,
+// each in its own transaction
+func (s *SQLMetadataStore) migrate(seed *BadgerStore) error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > len(metadataMigrations) {
+		return fmt.Errorf("metadata schema version %d is newer than this server supports (%d)", version, len(metadataMigrations))
+	}
+
+	for i := version; i < len(metadataMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := s.applyMigration(tx, i+1, seed); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		s.logger.Info("Applied metadata migration", zap.Int("version", i+1))
+	}
+	return nil
+}
+
+func (s *SQLMetadataStore) applyMigration(tx *sql.Tx, version int, seed *BadgerStore) error {
+	for _, stmt := range metadataMigrations[version-1] {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if version == 1 && seed != nil {
+		if err := s.importBadger(tx, seed); err != nil {
+			return fmt.Errorf("failed to import metadata from the TSDB: %w", err)
+		}
+	}
+	if version == 2 && seed != nil {
+		if err := s.importBadgerDashboards(tx, seed); err != nil {
+			return fmt.Errorf("failed to import dashboards from the TSDB: %w", err)
+		}
+	}
+	if version == 3 && seed != nil {
+		records, err := seed.ListTenantUsage("", "")
+		if err != nil {
+			return fmt.Errorf("failed to import tenant usage from the TSDB: %w", err)
+		}
+		for _, usage := range records {
+			if err := s.saveTenantUsage(tx, usage); err != nil {
+				return err
+			}
+		}
+	}
+	if version == 4 && seed != nil {
+		records, err := seed.ListNodeMonths("", "")
+		if err != nil {
+			return fmt.Errorf("failed to import node months from the TSDB: %w", err)
+		}
+		for _, record := range records {
+			if err := s.saveNodeMonth(tx, record); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := tx.Exec(s.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), version, time.Now().Unix())
+	return err
+}
+
+// importBadger copies the metadata kept in Badger, preserving alert event
+// sequence numbers so feed clients can resume
+func (s *SQLMetadataStore) importBadger(tx *sql.Tx, from *BadgerStore) error {
+	nodes, err := from.ListNodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := s.saveNode(tx, node); err != nil {
+			return err
+		}
+	}
+
+	alerts, err := from.GetAlerts(nil)
+	if err != nil {
+		return err
+	}
+	for _, alert := range alerts {
+		if err := s.saveAlert(tx, alert); err != nil {
+			return err
+		}
+	}
+
+	events, err := from.GetAlertEvents(0, 0)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := s.insertAlertEvent(tx, event); err != nil {
+			return err
+		}
+	}
+
+	derived, err := from.ListDerivedMetrics()
+	if err != nil {
+		return err
+	}
+	for _, metric := range derived {
+		if err := s.saveDocument(tx, "derived_metrics", "name", metric.Name, metric); err != nil {
+			return err
+		}
+	}
+
+	rules, err := from.ListIngestRules()
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := s.saveDocument(tx, "ingest_rules", "name", rule.Name, rule); err != nil {
+			return err
+		}
+	}
+
+	annotations, err := from.GetAnnotations(nil)
+	if err != nil {
+		return err
+	}
+	for _, annotation := range annotations {
+		if err := s.saveAnnotation(tx, annotation); err != nil {
+			return err
+		}
+	}
+
+	silences, err := from.ListSilences()
+	if err != nil {
+		return err
+	}
+	for _, silence := range silences {
+		if err := s.saveDocument(tx, "silences", "id", silence.ID, silence); err != nil {
+			return err
+		}
+	}
+
+	if total := len(nodes) + len(alerts) + len(events) + len(derived) + len(rules) + len(annotations) + len(silences); total > 0 {
+		s.logger.Info("Imported metadata from the TSDB",
+			zap.Int("nodes", len(nodes)),
+			zap.Int("alerts", len(alerts)),
+			zap.Int("alert_events", len(events)),
+			zap.Int("derived_metrics", len(derived)),
+			zap.Int("ingest_rules", len(rules)),
+			zap.Int("annotations", len(annotations)),
+			zap.Int("silences", len(silences)),
+		)
+	}
+	return nil
+}
+
+// importBadgerDashboards copies the dashboards kept in Badger with their
+// versions
+func (s *SQLMetadataStore) importBadgerDashboards(tx *sql.Tx, from *BadgerStore) error {
+	dashboards, err := from.ListDashboards()
+	if err != nil {
+		return err
+	}
+
+	var total int
+	for _, dashboard := range dashboards {
+		versions, err := from.GetDashboardVersions(dashboard.ID)
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			if err := s.insertDashboardVersion(tx, version); err != nil {
+				return err
+			}
+		}
+		if err := s.saveDocument(tx, "dashboards", "id", dashboard.ID, dashboard); err != nil {
+			return err
+		}
+		total += len(versions)
+	}
+
+	if len(dashboards) > 0 {
+		s.logger.Info("Imported dashboards from the TSDB",
+			zap.Int("dashboards", len(dashboards)),
+			zap.Int("versions", total),
+		)
+	}
+	return nil
+}
+
+// rebind replaces ? placeholders with PostgreSQL's $n
+func (s *SQLMetadataStore) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// saveDocument inserts or replaces the JSON document v of a table with a
+// key column and a data column
+func (s *SQLMetadataStore) saveDocument(q execer, table, keyColumn, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(s.rebind(fmt.Sprintf(
+		`INSERT INTO %[1]s (%[2]s, data) VALUES (?, ?) ON CONFLICT (%[2]s) DO UPDATE SET data = excluded.data`,
+		table, keyColumn)), key, string(data))
+	return err
+}
+
+// deleteDocument deletes a row by key, returning whether it existed
+func (s *SQLMetadataStore) deleteDocument(table, keyColumn, key string) (bool, error) {
+	res, err := s.db.Exec(s.rebind(fmt.Sprintf(`DELETE FROM %s WHERE %s = ?`, table, keyColumn)), key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// queryDocuments decodes the data column of each row a query returns
+func queryDocuments[T any](s *SQLMetadataStore, query string, args ...interface{}) ([]*T, error) {
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []*T
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		doc := new(T)
+		if err := json.Unmarshal([]byte(data), doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// SaveNode saves a node
+func (s *SQLMetadataStore) SaveNode(node *models.Node) error {
+	return s.saveNode(s.db, node)
+}
+
+func (s *SQLMetadataStore) saveNode(q execer, node *models.Node) error {
+	return s.saveDocument(q, "nodes", "id", node.ID, node)
+}
+
+// GetNode retrieves a node by ID
+func (s *SQLMetadataStore) GetNode(nodeID string) (*models.Node, error) {
+	var data string
+	err := s.db.QueryRow(s.rebind(`SELECT data FROM nodes WHERE id = ?`), nodeID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("node %s not found", nodeID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var node models.Node
+	if err := json.Unmarshal([]byte(data), &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// DeleteNode removes a node record
+func (s *SQLMetadataStore) DeleteNode(nodeID string) error {
+	_, err := s.deleteDocument("nodes", "id", nodeID)
+	return err
+}
+
+// ListNodes lists all nodes
+func (s *SQLMetadataStore) ListNodes() ([]*models.Node, error) {
+	return queryDocuments[models.Node](s, `SELECT data FROM nodes ORDER BY id`)
+}
+
+// SaveAlert saves an alert
+func (s *SQLMetadataStore) SaveAlert(alert *models.Alert) error {
+	return s.saveAlert(s.db, alert)
+}
+
+func (s *SQLMetadataStore) saveAlert(q execer, alert *models.Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(s.rebind(`INSERT INTO alerts (id, state, node_id, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET state = excluded.state, node_id = excluded.node_id, data = excluded.data`),
+		alert.ID, int(alert.State), alert.Labels["node"], string(data))
+	return err
+}
+
+// GetAlerts retrieves alerts matching the filter's state and node
+func (s *SQLMetadataStore) GetAlerts(filter *models.AlertFilter) ([]*models.Alert, error) {
+	query := `SELECT data FROM alerts`
+	var where []string
+	var args []interface{}
+	if filter != nil {
+		if filter.State != nil {
+			where = append(where, "state = ?")
+			args = append(args, int(*filter.State))
+		}
+		if filter.NodeID != "" {
+			where = append(where, "node_id = ?")
+			args = append(args, filter.NodeID)
+		}
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	return queryDocuments[models.Alert](s, query+" ORDER BY id", args...)
+}
+
+// AppendAlertEvent assigns the next sequence number to an alert event and
+// appends it to the event log
+func (s *SQLMetadataStore) AppendAlertEvent(event *models.AlertEvent) error {
+	s.alertSeqMu.Lock()
+	defer s.alertSeqMu.Unlock()
+
+	if err := s.loadAlertSeq(); err != nil {
+		return err
+	}
+
+	event.Seq = s.alertSeq + 1
+	if err := s.insertAlertEvent(s.db, event); err != nil {
+		return err
+	}
+	s.alertSeq = event.Seq
+	return nil
+}
+
+func (s *SQLMetadataStore) insertAlertEvent(q execer, event *models.AlertEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(s.rebind(`INSERT INTO alert_events (seq, time, data) VALUES (?, ?, ?)`),
+		int64(event.Seq), event.Time.UnixNano(), string(data))
+	return err
+}
+
+// LastAlertEventSeq returns the sequence of the newest alert event, or 0
+// if there are none
+func (s *SQLMetadataStore) LastAlertEventSeq() (uint64, error) {
+	s.alertSeqMu.Lock()
+	defer s.alertSeqMu.Unlock()
+
+	if err := s.loadAlertSeq(); err != nil {
+		return 0, err
+	}
+	return s.alertSeq, nil
+}
+
+// loadAlertSeq reads the last sequence from the event log. Callers must
+// hold alertSeqMu.
+func (s *SQLMetadataStore) loadAlertSeq() error {
+	if s.alertSeqLoaded {
+		return nil
+	}
+
+	var seq int64
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(seq), 0) FROM alert_events`).Scan(&seq); err != nil {
+		return fmt.Errorf("failed to load alert event sequence: %w", err)
+	}
+	s.alertSeq = uint64(seq)
+	s.alertSeqLoaded = true
+	return nil
+}
+
+// GetAlertEvents returns up to limit alert events with a sequence after
+// the given one, oldest first. A limit of 0 returns every event.
+func (s *SQLMetadataStore) GetAlertEvents(after uint64, limit int) ([]*models.AlertEvent, error) {
+	query := `SELECT data FROM alert_events WHERE seq > ? ORDER BY seq`
+	args := []interface{}{int64(after)}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	return queryDocuments[models.AlertEvent](s, query, args...)
+}
+
+// DeleteAlertEventsOlderThan trims the alert event log
+func (s *SQLMetadataStore) DeleteAlertEventsOlderThan(cutoff time.Time) (int64, error) {
+	res, err := s.db.Exec(s.rebind(`DELETE FROM alert_events WHERE time < ?`), cutoff.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SaveDerivedMetric saves a derived metric definition
+func (s *SQLMetadataStore) SaveDerivedMetric(metric *models.DerivedMetric) error {
+	return s.saveDocument(s.db, "derived_metrics", "name", metric.Name, metric)
+}
+
+// ListDerivedMetrics lists all derived metric definitions
+func (s *SQLMetadataStore) ListDerivedMetrics() ([]*models.DerivedMetric, error) {
+	return queryDocuments[models.DerivedMetric](s, `SELECT data FROM derived_metrics ORDER BY name`)
+}
+
+// DeleteDerivedMetric deletes a derived metric definition
+func (s *SQLMetadataStore) DeleteDerivedMetric(name string) error {
+	_, err := s.deleteDocument("derived_metrics", "name", name)
+	return err
+}
+
+// SaveIngestRule saves an ingest rule
+func (s *SQLMetadataStore) SaveIngestRule(rule *models.IngestRule) error {
+	return s.saveDocument(s.db, "ingest_rules", "name", rule.Name, rule)
+}
+
+// ListIngestRules lists all ingest rules
+func (s *SQLMetadataStore) ListIngestRules() ([]*models.IngestRule, error) {
+	return queryDocuments[models.IngestRule](s, `SELECT data FROM ingest_rules ORDER BY name`)
+}
+
+// DeleteIngestRule deletes an ingest rule
+func (s *SQLMetadataStore) DeleteIngestRule(name string) error {
+	_, err := s.deleteDocument("ingest_rules", "name", name)
+	return err
+}
+
+// SaveAnnotation saves an annotation
+func (s *SQLMetadataStore) SaveAnnotation(annotation *models.Annotation) error {
+	return s.saveAnnotation(s.db, annotation)
+}
+
+func (s *SQLMetadataStore) saveAnnotation(q execer, annotation *models.Annotation) error {
+	data, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(s.rebind(`INSERT INTO annotations (id, time, data) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET time = excluded.time, data = excluded.data`),
+		annotation.ID, annotation.Time.UnixNano(), string(data))
+	return err
+}
+
+// GetAnnotations retrieves annotations matching the filter, newest first
+func (s *SQLMetadataStore) GetAnnotations(filter *models.AnnotationFilter) ([]*models.Annotation, error) {
+	query := `SELECT data FROM annotations`
+	var args []interface{}
+	if filter != nil && !filter.End.IsZero() {
+		query += ` WHERE time <= ?`
+		args = append(args, filter.End.UnixNano())
+	}
+
+	annotations, err := queryDocuments[models.Annotation](s, query+` ORDER BY time DESC`, args...)
+	if err != nil || filter == nil {
+		return annotations, err
+	}
+
+	matched := annotations[:0]
+	for _, annotation := range annotations {
+		if filter.Matches(annotation) {
+			matched = append(matched, annotation)
+		}
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched, nil
+}
+
+// DeleteAnnotation deletes an annotation by ID
+func (s *SQLMetadataStore) DeleteAnnotation(id string) error {
+	found, err := s.deleteDocument("annotations", "id", id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("annotation %s not found", id)
+	}
+	return nil
+}
+
+// SaveSilence saves a silence
+func (s *SQLMetadataStore) SaveSilence(silence *models.Silence) error {
+	return s.saveDocument(s.db, "silences", "id", silence.ID, silence)
+}
+
+// ListSilences retrieves all silences
+func (s *SQLMetadataStore) ListSilences() ([]*models.Silence, error) {
+	return queryDocuments[models.Silence](s, `SELECT data FROM silences ORDER BY id`)
+}
+
+// DeleteSilence deletes a silence by ID
+func (s *SQLMetadataStore) DeleteSilence(id string) error {
+	_, err := s.deleteDocument("silences", "id", id)
+	return err
+}
+
+// SaveDashboard saves a dashboard and records it as its version
+func (s *SQLMetadataStore) SaveDashboard(dashboard *models.Dashboard) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.saveDocument(tx, "dashboards", "id", dashboard.ID, dashboard); err != nil {
+		return err
+	}
+	if err := s.insertDashboardVersion(tx, dashboard); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLMetadataStore) insertDashboardVersion(q execer, dashboard *models.Dashboard) error {
+	data, err := json.Marshal(dashboard)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(s.rebind(`INSERT INTO dashboard_versions (dashboard_id, version, data) VALUES (?, ?, ?)
+		ON CONFLICT (dashboard_id, version) DO UPDATE SET data = excluded.data`),
+		dashboard.ID, dashboard.Version, string(data))
+	return err
+}
+
+// ListDashboards retrieves all dashboards
+func (s *SQLMetadataStore) ListDashboards() ([]*models.Dashboard, error) {
+	return queryDocuments[models.Dashboard](s, `SELECT data FROM dashboards ORDER BY id`)
+}
+
+// GetDashboardVersions retrieves the saved versions of a dashboard, newest
+// first
+func (s *SQLMetadataStore) GetDashboardVersions(id string) ([]*models.Dashboard, error) {
+	return queryDocuments[models.Dashboard](s,
+		`SELECT data FROM dashboard_versions WHERE dashboard_id = ? ORDER BY version DESC`, id)
+}
+
+// DeleteDashboard deletes a dashboard and its versions
+func (s *SQLMetadataStore) DeleteDashboard(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.rebind(`DELETE FROM dashboard_versions WHERE dashboard_id = ?`), id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.rebind(`DELETE FROM dashboards WHERE id = ?`), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveTenantUsage saves a tenant's usage on a day
+func (s *SQLMetadataStore) SaveTenantUsage(usage *models.TenantUsage) error {
+	return s.saveTenantUsage(s.db, usage)
+}
+
+func (s *SQLMetadataStore) saveTenantUsage(q execer, usage *models.TenantUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(s.rebind(`INSERT INTO tenant_usage (day, tenant, data) VALUES (?, ?, ?)
+		ON CONFLICT (day, tenant) DO UPDATE SET data = excluded.data`),
+		usage.Day, usage.Tenant, string(data))
+	return err
+}
+
+// ListTenantUsage lists the usage records of the days from and to, either
+// of which may be empty, ordered by day and tenant
+func (s *SQLMetadataStore) ListTenantUsage(from, to string) ([]*models.TenantUsage, error) {
+	if to == "" {
+		to = "9999-12-31"
+	}
+	return queryDocuments[models.TenantUsage](s,
+		`SELECT data FROM tenant_usage WHERE day >= ? AND day <= ? ORDER BY day, tenant`, from, to)
+}
+
+// DeleteTenantUsageBefore deletes the usage records of days before day
+func (s *SQLMetadataStore) DeleteTenantUsageBefore(day string) (int64, error) {
+	res, err := s.db.Exec(s.rebind(`DELETE FROM tenant_usage WHERE day < ?`), day)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SaveNodeMonth saves a node's aggregates for a month
+func (s *SQLMetadataStore) SaveNodeMonth(record *models.NodeMonth) error {
+	return s.saveNodeMonth(s.db, record)
+}
+
+func (s *SQLMetadataStore) saveNodeMonth(q execer, record *models.NodeMonth) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(s.rebind(`INSERT INTO node_months (month, node_id, data) VALUES (?, ?, ?)
+		ON CONFLICT (month, node_id) DO UPDATE SET data = excluded.data`),
+		record.Month, record.NodeID, string(data))
+	return err
+}
+
+// ListNodeMonths lists the node aggregates of the months from and to,
+// either of which may be empty, ordered by month and node
+func (s *SQLMetadataStore) ListNodeMonths(from, to string) ([]*models.NodeMonth, error) {
+	if to == "" {
+		to = "9999-12"
+	}
+	return queryDocuments[models.NodeMonth](s,
+		`SELECT data FROM node_months WHERE month >= ? AND month <= ? ORDER BY month, node_id`, from, to)
+}
+
+// DeleteNodeMonthsBefore deletes the node aggregates of months before
+// month
+func (s *SQLMetadataStore) DeleteNodeMonthsBefore(month string) (int64, error) {
+	res, err := s.db.Exec(s.rebind(`DELETE FROM node_months WHERE month < ?`), month)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Snapshot writes a consistent copy of a sqlite database to path, which
+// must not exist
+func (s *SQLMetadataStore) Snapshot(path string) error {
+	if s.postgres {
+		return fmt.Errorf("snapshots are only supported for sqlite")
+	}
+	if _, err := s.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("failed to snapshot metadata: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the contents of every table with those of a sqlite
+// snapshot at the same schema version, in one transaction
+func (s *SQLMetadataStore) Restore(path string) error {
+	if s.postgres {
+		return fmt.Errorf("snapshots are only supported for sqlite")
+	}
+	ctx := context.Background()
+	// ATTACH only applies to the connection it runs on
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS snapshot`, path); err != nil {
+		return fmt.Errorf("failed to open metadata snapshot: %w", err)
+	}
+	defer conn.ExecContext(ctx, `DETACH DATABASE snapshot`)
+
+	var local, snapshot int
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM main.schema_migrations`).Scan(&local); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM snapshot.schema_migrations`).Scan(&snapshot); err != nil {
+		return fmt.Errorf("failed to read snapshot schema version: %w", err)
+	}
+	if snapshot != local {
+		return fmt.Errorf("metadata snapshot schema version %d does not match %d", snapshot, local)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT name FROM main.sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'`)
+	if err != nil {
+		return fmt.Errorf("failed to list tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range tables {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM main.%q`, table)); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO main.%q SELECT * FROM snapshot.%q`, table, table)); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to restore metadata: %w", err)
+	}
+
+	s.alertSeqMu.Lock()
+	s.alertSeqLoaded = false
+	s.alertSeqMu.Unlock()
+	return nil
+}
+
+// Close closes the database
+func (s *SQLMetadataStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,12 @@
+//go:build cgo
+
+package storage
+
+import (
+	// Registers the sqlite3 database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteAvailable reports whether the sqlite metadata store can be used;
+// its driver needs cgo
+const sqliteAvailable = true
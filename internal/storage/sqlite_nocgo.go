@@ -0,0 +1,7 @@
+//go:build !cgo
+
+package storage
+
+// sqliteAvailable reports whether the sqlite metadata store can be used;
+// its driver needs cgo
+const sqliteAvailable = false
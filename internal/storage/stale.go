@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"go.uber.org/zap"
+)
+
+// tombstoneBatchSeries bounds the series tombstoned in one transaction
+const tombstoneBatchSeries = 500
+
+// TombstoneIdleSeries tombstones the series with no sample for idle in the
+// shards overlapping the last idle plus window, returning how many it
+// tombstoned. Their samples stay queryable, but their postings are
+// removed, so lookups for later time ranges skip them. A series that
+// reports again is indexed anew.
+func (s *BadgerStore) TombstoneIdleSeries(ctx context.Context, idle, window time.Duration) (int, error) {
+	now := time.Now()
+	cutoff := now.Add(-idle)
+
+	var total int
+	for _, sh := range s.shards.overlapping(cutoff.Add(-window), now) {
+		if ctx.Err() != nil {
+			break
+		}
+		err := s.withShard(sh, func(db *badger.DB) error {
+			n, err := s.tombstoneShard(db, sh, cutoff)
+			total += n
+			return err
+		})
+		if err != nil {
+			return total, fmt.Errorf("failed to tombstone series of shard %s: %w", sh.dir, err)
+		}
+	}
+
+	if total > 0 {
+		s.logger.Info("Tombstoned idle series",
+			zap.Int("series", total),
+			zap.Duration("idle", idle),
+		)
+	}
+	return total, nil
+}
+
+// tombstoneShard tombstones the series of an indexed shard with no sample
+// since the cutoff
+func (s *BadgerStore) tombstoneShard(db *badger.DB, sh *shard, cutoff time.Time) (int, error) {
+	idle, err := s.idleSeries(db, cutoff)
+	if err != nil || len(idle) == 0 {
+		return 0, err
+	}
+
+	// Once forgotten, a write to the series indexes it again, rewriting its
+	// series key, so a batch read before that write conflicts. Samples
+	// written before it are seen by the second look.
+	for id := range idle {
+		sh.series.Delete(id)
+	}
+	idle, err = s.idleSeries(db, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, 0, len(idle))
+	for id := range idle {
+		ids = append(ids, id)
+	}
+
+	var total int
+	for len(ids) > 0 {
+		n := min(len(ids), tombstoneBatchSeries)
+		var done int
+		err := db.Update(func(txn *badger.Txn) error {
+			for _, id := range ids[:n] {
+				e, err := getSeries(txn, id)
+				if err == badger.ErrKeyNotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if err := txn.Set(tombstoneKey(id), []byte(strconv.FormatInt(idle[id], 10))); err != nil {
+					return err
+				}
+				for _, key := range e.postingKeys() {
+					if err := txn.Delete(key); err != nil {
+						return err
+					}
+				}
+				done++
+			}
+			return nil
+		})
+		switch {
+		case err == badger.ErrConflict:
+			// Some of these series reported again; the rest are retried on
+			// the next run
+		case err != nil:
+			return total, err
+		default:
+			total += done
+		}
+		ids = ids[n:]
+	}
+	return total, nil
+}
+
+// idleSeries returns the series of an indexed shard not yet tombstoned
+// with no sample since the cutoff, with the time of their last sample in
+// Unix nanoseconds. A chunk counts as holding samples until it ends.
+func (s *BadgerStore) idleSeries(db *badger.DB, cutoff time.Time) (map[string]int64, error) {
+	idle := make(map[string]int64)
+	span := s.chunkSpan().Nanoseconds()
+	err := db.View(func(txn *badger.Txn) error {
+		if ok, err := isIndexed(txn); !ok || err != nil {
+			return err
+		}
+		stones, err := tombstones(txn)
+		if err != nil {
+			return err
+		}
+
+		last := make(map[string]int64)
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		opts.Prefix = []byte(seriesPrefix)
+		it := txn.NewIterator(opts)
+		for it.Rewind(); it.Valid(); it.Next() {
+			id := string(it.Item().Key()[len(seriesPrefix):])
+			if _, ok := stones[id]; !ok {
+				last[id] = 0
+			}
+		}
+		it.Close()
+
+		opts.Prefix = []byte(chunkRefPrefix)
+		it = txn.NewIterator(opts)
+		for it.Rewind(); it.Valid(); it.Next() {
+			rest := string(it.Item().Key()[len(chunkRefPrefix):])
+			i := strings.LastIndexByte(rest, ':')
+			if i < 0 {
+				continue
+			}
+			start, err := strconv.ParseInt(rest[i+1:], 10, 64)
+			if t, tracked := last[rest[:i]]; err == nil && tracked && start+span > t {
+				last[rest[:i]] = start + span
+			}
+		}
+		it.Close()
+
+		opts.Prefix = []byte(rawPrefix)
+		it = txn.NewIterator(opts)
+		for it.Rewind(); it.Valid(); it.Next() {
+			name, ts, hash, ok := splitSeriesKey(it.Item().Key(), rawPrefix)
+			if !ok {
+				continue
+			}
+			id := seriesID(name, hash)
+			if t, tracked := last[id]; tracked && ts > t {
+				last[id] = ts
+			}
+		}
+		it.Close()
+
+		for id, t := range last {
+			if t < cutoff.UnixNano() {
+				idle[id] = t
+			}
+		}
+		return nil
+	})
+	return idle, err
+}
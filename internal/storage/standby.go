@@ -0,0 +1,568 @@
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/meettoy2004/lnmonja/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// replicatedFile holds the latest version a standby database has fully
+// applied. Versions are not applied in order, so after a failed segment
+// the database may hold newer ones than this.
+const replicatedFile = "REPLICATED"
+
+// Standby applies the writes a primary replicates to it into its own
+// storage path, until it is promoted. It only opens the databases while
+// applying writes, so a promoted standby's storage path is a server's. A
+// read replica's standby applies them to the store it serves queries
+// from instead, and cannot be promoted.
+type Standby struct {
+	config *utils.StorageConfig
+	logger *zap.Logger
+	dbs    replicaDatabases
+	// replica is set for a read replica's standby
+	replica bool
+	// meta is the live sqlite metadata of a read replica, which snapshots
+	// are restored into rather than replacing the file
+	meta *SQLMetadataStore
+	// onApply is called after writes are applied to a database, and
+	// onMetadata after a metadata snapshot is
+	onApply    func(name string)
+	onMetadata func()
+
+	mu       sync.Mutex
+	status   ReplicationStatus
+	promoted bool
+	done     chan struct{}
+}
+
+// replicaDatabases are the databases a standby applies writes to
+type replicaDatabases interface {
+	// open returns a database, created if missing, and a func releasing it
+	open(name string) (*badger.DB, func(), error)
+	drop(name string) error
+	close() error
+}
+
+// NewStandby creates a standby for a storage path, loading the versions
+// already applied to it
+func NewStandby(config *utils.StorageConfig, logger *zap.Logger) (*Standby, error) {
+	if IsPromoted(config.Path) {
+		return nil, fmt.Errorf("storage path %s belongs to a promoted standby", config.Path)
+	}
+	if err := os.MkdirAll(filepath.Join(config.Path, shardsDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage path: %w", err)
+	}
+	dbs := &standbyDatabases{config: config, logger: logger, dbs: make(map[string]*standbyDB)}
+	return newStandby(config, dbs, logger)
+}
+
+// newReplica creates the standby of a read replica, applying writes to
+// the store it serves
+func newReplica(config *utils.StorageConfig, store *BadgerStore, meta MetadataStore, logger *zap.Logger) (*Standby, error) {
+	s, err := newStandby(config, &storeDatabases{store: store}, logger)
+	if err != nil {
+		return nil, err
+	}
+	s.replica = true
+	if sqlMeta, ok := meta.(*SQLMetadataStore); ok && !sqlMeta.postgres {
+		s.meta = sqlMeta
+	}
+	return s, nil
+}
+
+func newStandby(config *utils.StorageConfig, dbs replicaDatabases, logger *zap.Logger) (*Standby, error) {
+	s := &Standby{
+		config: config,
+		logger: logger,
+		dbs:    dbs,
+		status: ReplicationStatus{Databases: make(map[string]uint64)},
+		done:   make(chan struct{}),
+	}
+
+	if v, err := readReplicated(config.Path); err != nil {
+		return nil, err
+	} else if v > 0 {
+		s.status.Databases[replicationMainDB] = v
+	}
+	entries, err := os.ReadDir(filepath.Join(config.Path, shardsDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shards: %w", err)
+	}
+	for _, entry := range entries {
+		name := shardsDir + "/" + entry.Name()
+		if _, _, ok := parseShardDirName(entry.Name()); !ok || !entry.IsDir() {
+			continue
+		}
+		v, err := readReplicated(replicaDir(config, name))
+		if err != nil {
+			return nil, err
+		}
+		s.status.Databases[name] = v
+	}
+	return s, nil
+}
+
+// Run serves the primary until the standby is promoted, returning nil,
+// or until ctx is done
+func (s *Standby) Run(ctx context.Context) error {
+	repl := s.config.Replication
+	server := &http.Server{
+		Addr:              repl.Listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		if repl.CertFile != "" {
+			err = server.ListenAndServeTLS(repl.CertFile, repl.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		errc <- err
+	}()
+	s.logger.Info("Standby waiting for replicated writes", zap.String("listen", repl.Listen))
+
+	var err error
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case serveErr := <-errc:
+		err = fmt.Errorf("failed to serve replication: %w", serveErr)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if serr := server.Shutdown(shutdownCtx); serr != nil {
+		s.logger.Warn("Failed to stop replication listener", zap.Error(serr))
+	}
+	if cerr := s.close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Handler returns the replication API
+func (s *Standby) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/replication/status", s.handleStatus)
+	mux.HandleFunc("/replication/segment", s.handleSegment)
+	mux.HandleFunc("/replication/drop", s.handleDrop)
+	mux.HandleFunc("/replication/metadata", s.handleMetadata)
+	mux.HandleFunc("/replication/promote", s.handlePromote)
+
+	token := []byte(s.config.Replication.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if subtle.ConstantTimeCompare(given, token) != 1 {
+			http.Error(w, "invalid replication token", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Standby) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, s.status)
+}
+
+// handleSegment applies a gzipped Badger backup to a database
+func (s *Standby) handleSegment(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.request(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+	defer s.mu.Unlock()
+
+	version, err := s.apply(name, r)
+	if err != nil {
+		s.logger.Error("Failed to apply replicated writes", zap.String("db", name), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]uint64{"version": version})
+}
+
+func (s *Standby) handleDrop(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.request(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+	defer s.mu.Unlock()
+
+	if name == replicationMainDB {
+		http.Error(w, "the main database cannot be dropped", http.StatusBadRequest)
+		return
+	}
+	if err := s.dbs.drop(name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	delete(s.status.Databases, name)
+
+	s.logger.Info("Dropped replicated shard", zap.String("db", name))
+	writeJSON(w, map[string]string{"status": "dropped"})
+}
+
+// handleMetadata replaces the sqlite metadata with a gzipped snapshot
+func (s *Standby) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.promoted {
+		http.Error(w, "standby has been promoted", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := s.applyMetadata(r.Body); err != nil {
+		s.logger.Error("Failed to apply replicated metadata", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.status.MetadataAt = time.Now().UTC()
+	if s.onMetadata != nil {
+		s.onMetadata()
+	}
+	writeJSON(w, map[string]string{"status": "applied"})
+}
+
+// handlePromote stops applying writes and marks the storage path promoted
+func (s *Standby) handlePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.replica {
+		http.Error(w, "a read replica cannot be promoted", http.StatusConflict)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.promoted {
+		writeJSON(w, map[string]string{"status": "promoted"})
+		return
+	}
+
+	if err := s.dbs.close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(s.config.Path, promotedFile), []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		http.Error(w, fmt.Sprintf("failed to mark standby promoted: %v", err), http.StatusInternalServerError)
+		return
+	}
+	s.promoted = true
+	close(s.done)
+
+	s.logger.Info("Standby promoted", zap.Time("last_applied", s.status.LastApplied))
+	writeJSON(w, map[string]string{"status": "promoted"})
+}
+
+// request checks a request for a database and locks mu, which the caller
+// must unlock if it returns true
+func (s *Standby) request(w http.ResponseWriter, r *http.Request, method string) (string, bool) {
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	name := r.URL.Query().Get("db")
+	if !validReplicaName(name) {
+		http.Error(w, fmt.Sprintf("invalid database: %q", name), http.StatusBadRequest)
+		return "", false
+	}
+
+	s.mu.Lock()
+	if s.promoted {
+		s.mu.Unlock()
+		http.Error(w, "standby has been promoted", http.StatusServiceUnavailable)
+		return "", false
+	}
+	return name, true
+}
+
+// apply loads a gzipped backup into a database and records the version it
+// reached. Callers must hold mu.
+func (s *Standby) apply(name string, r *http.Request) (uint64, error) {
+	db, release, err := s.dbs.open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read segment: %w", err)
+	}
+	defer gz.Close()
+	if err := db.Load(gz, restoreMaxPendingWrites); err != nil {
+		return 0, fmt.Errorf("failed to load segment: %w", err)
+	}
+	if err := db.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync: %w", err)
+	}
+
+	// The primary sends the version it reached as a trailer, which is only
+	// read at the end of the body. A standby is only written by segments,
+	// so without one its latest version is the latest one replicated.
+	io.Copy(io.Discard, r.Body)
+	version := db.MaxVersion()
+	if v := r.Trailer.Get(replicationVersionTrailer); v != "" {
+		if version, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 0, fmt.Errorf("invalid replicated version %q", v)
+		}
+	}
+	if err := writeReplicated(replicaDir(s.config, name), version); err != nil {
+		return 0, err
+	}
+	s.status.Databases[name] = version
+	s.status.LastApplied = time.Now().UTC()
+	if s.onApply != nil {
+		s.onApply(name)
+	}
+	return version, nil
+}
+
+// applyMetadata writes a gzipped sqlite snapshot over metadata.db, or
+// restores it into a read replica's metadata. Callers must hold mu.
+func (s *Standby) applyMetadata(body io.Reader) error {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	tmp := filepath.Join(s.config.Path, metadataSnapshotFile)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata snapshot: %w", err)
+	}
+	_, err = io.Copy(f, gz)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write metadata snapshot: %w", err)
+	}
+
+	if s.replica {
+		defer os.Remove(tmp)
+		if s.meta == nil {
+			return fmt.Errorf("read replica does not keep metadata in sqlite")
+		}
+		return s.meta.Restore(tmp)
+	}
+
+	path := filepath.Join(s.config.Path, "metadata.db")
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path+suffix, err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace metadata: %w", err)
+	}
+	return nil
+}
+
+func (s *Standby) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dbs.close()
+}
+
+// standbyDatabases are a standby's own databases, opened while writes are
+// applied, closing the least recently used shards beyond Shards.MaxOpen
+type standbyDatabases struct {
+	config *utils.StorageConfig
+	logger *zap.Logger
+	dbs    map[string]*standbyDB
+}
+
+type standbyDB struct {
+	db   *badger.DB
+	used time.Time
+}
+
+func (d *standbyDatabases) open(name string) (*badger.DB, func(), error) {
+	if sdb, ok := d.dbs[name]; ok {
+		sdb.used = time.Now()
+		return sdb.db, func() {}, nil
+	}
+
+	opts := mainOptions(d.config, d.logger)
+	if name != replicationMainDB {
+		opts = shardOptions(d.config, d.logger, replicaDir(d.config, name))
+		// Applied segments do not update it; promoted, the shard is
+		// summarized when first opened
+		os.Remove(filepath.Join(opts.Dir, summaryFile))
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	d.dbs[name] = &standbyDB{db: db, used: time.Now()}
+
+	for len(d.dbs) > d.config.Shards.MaxOpen+1 {
+		var lru string
+		for other, sdb := range d.dbs {
+			if other != name && other != replicationMainDB && (lru == "" || sdb.used.Before(d.dbs[lru].used)) {
+				lru = other
+			}
+		}
+		if lru == "" {
+			break
+		}
+		if err := d.dbs[lru].db.Close(); err != nil {
+			d.logger.Warn("Failed to close shard", zap.String("db", lru), zap.Error(err))
+		}
+		delete(d.dbs, lru)
+	}
+	return db, func() {}, nil
+}
+
+func (d *standbyDatabases) drop(name string) error {
+	if sdb, ok := d.dbs[name]; ok {
+		if err := sdb.db.Close(); err != nil {
+			d.logger.Warn("Failed to close shard", zap.String("db", name), zap.Error(err))
+		}
+		delete(d.dbs, name)
+	}
+	return os.RemoveAll(replicaDir(d.config, name))
+}
+
+// close closes every open database
+func (d *standbyDatabases) close() error {
+	var firstErr error
+	for name, sdb := range d.dbs {
+		if err := sdb.db.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close %s: %w", name, err)
+		}
+		delete(d.dbs, name)
+	}
+	return firstErr
+}
+
+// storeDatabases are the databases of the store a read replica serves,
+// so shards written by the primary become visible to queries as they
+// are applied
+type storeDatabases struct {
+	store *BadgerStore
+}
+
+func (d *storeDatabases) open(name string) (*badger.DB, func(), error) {
+	if name == replicationMainDB {
+		return d.store.db, func() {}, nil
+	}
+	start, end, _ := parseShardDirName(strings.TrimPrefix(name, shardsDir+"/"))
+	sh := d.store.shards.exact(start, end, true)
+	if err := d.store.shards.open(sh); err != nil {
+		return nil, nil, err
+	}
+	// Queries read the shard until the segment is applied and its summary
+	// rebuilt
+	d.store.shards.invalidateSummary(sh)
+	return sh.db, func() {
+		d.store.shards.resummarize(sh)
+		d.store.shards.release(sh)
+	}, nil
+}
+
+func (d *storeDatabases) drop(name string) error {
+	start, end, _ := parseShardDirName(strings.TrimPrefix(name, shardsDir+"/"))
+	if sh := d.store.shards.exact(start, end, false); sh != nil {
+		d.store.shards.dropShard(sh)
+		return nil
+	}
+	return os.RemoveAll(replicaDir(d.store.config, name))
+}
+
+// close leaves the store to its owner
+func (d *storeDatabases) close() error {
+	return nil
+}
+
+// replicaDir returns the directory of a database
+func replicaDir(config *utils.StorageConfig, name string) string {
+	if name == replicationMainDB {
+		return config.Path
+	}
+	return filepath.Join(config.Path, filepath.FromSlash(name))
+}
+
+// validReplicaName reports whether name is the main database or a shard
+func validReplicaName(name string) bool {
+	if name == replicationMainDB {
+		return true
+	}
+	dir, ok := strings.CutPrefix(name, shardsDir+"/")
+	if !ok {
+		return false
+	}
+	_, _, ok = parseShardDirName(dir)
+	return ok
+}
+
+// readReplicated returns the version recorded in a database directory, or
+// zero if none is
+func readReplicated(dir string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, replicatedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read replicated version: %w", err)
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid replicated version in %s: %w", dir, err)
+	}
+	return v, nil
+}
+
+// writeReplicated records the version a database has fully applied
+func writeReplicated(dir string, version uint64) error {
+	tmp := filepath.Join(dir, replicatedFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(version, 10)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to record replicated version: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, replicatedFile)); err != nil {
+		return fmt.Errorf("failed to record replicated version: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
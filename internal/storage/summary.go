@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// summaryFile holds the summary of a closed shard, so queries can skip
+	// the shard without opening it. It is removed while the shard is open,
+	// so one left by a crash is never trusted.
+	summaryFile = "SUMMARY"
+	// bloomBytes sizes the label filter of a shard being written; it is
+	// folded down to what it holds when saved
+	bloomBytes    = 32 << 10
+	minBloomBytes = 64
+	bloomHashes   = 4
+)
+
+// blockSummary bounds what a block holds: the timestamps of its earliest
+// and latest samples, and a bloom filter of its metric names and label
+// pairs. Queries skip the blocks their summaries rule out; blocks without
+// one, or with one still being built, are always read.
+type blockSummary struct {
+	mu sync.RWMutex
+	// building is set until the summary covers every sample in the block
+	building bool
+	MinTime  int64  `json:"min_time"`
+	MaxTime  int64  `json:"max_time"`
+	Bloom    []byte `json:"bloom"`
+}
+
+func newBlockSummary() *blockSummary {
+	return &blockSummary{MinTime: math.MaxInt64, MaxTime: math.MinInt64, Bloom: make([]byte, bloomBytes)}
+}
+
+// bloomKey is how a label pair is hashed; the metric name is the
+// __name__ label, as in the postings
+func bloomKey(label, value string) []byte {
+	return []byte(label + "=" + value)
+}
+
+func (b *blockSummary) addKeyLocked(key []byte) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	m := uint64(len(b.Bloom)) * 8
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		b.Bloom[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (b *blockSummary) hasKeyLocked(key []byte) bool {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	m := uint64(len(b.Bloom)) * 8
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if b.Bloom[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *blockSummary) observeLocked(first, last int64) {
+	b.MinTime = min(b.MinTime, first)
+	b.MaxTime = max(b.MaxTime, last)
+}
+
+// observe adds samples in [first, last] and the series to the summary
+func (b *blockSummary) observe(first, last int64, series []*seriesEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.observeLocked(first, last)
+	for _, e := range series {
+		b.addKeyLocked(bloomKey(nameLabel, e.Name))
+		for k, v := range e.Labels {
+			b.addKeyLocked(bloomKey(k, v))
+		}
+	}
+}
+
+// merge adds a summary built from a snapshot of the block, completing b
+func (b *blockSummary) merge(built *blockSummary) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.observeLocked(built.MinTime, built.MaxTime)
+	// Summaries are only folded once saved, so both are full size
+	for i, v := range built.Bloom {
+		b.Bloom[i] |= v
+	}
+	b.building = false
+}
+
+// fold halves a bloom filter whose size is a power of two. A bit is at
+// the same position modulo the smaller size, so lookups still match.
+func fold(bloom []byte) []byte {
+	half := len(bloom) / 2
+	folded := make([]byte, half)
+	for i := range folded {
+		folded[i] = bloom[i] | bloom[i+half]
+	}
+	return folded
+}
+
+// compact returns a copy of a complete summary with its bloom filter
+// folded while it stays at most half full, or nil if it is missing or
+// incomplete
+func (b *blockSummary) compact() *blockSummary {
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.building {
+		return nil
+	}
+	bloom := b.Bloom
+	for len(bloom) > minBloomBytes {
+		folded := fold(bloom)
+		if setBits(folded)*2 > len(folded)*8 {
+			break
+		}
+		bloom = folded
+	}
+	return &blockSummary{MinTime: b.MinTime, MaxTime: b.MaxTime, Bloom: bytes.Clone(bloom)}
+}
+
+func setBits(bloom []byte) int {
+	var n int
+	for _, v := range bloom {
+		n += bits.OnesCount8(v)
+	}
+	return n
+}
+
+// mayHold reports whether a block may hold samples in [from, to) of a
+// metric name, or any name if empty, with the filters' labels. A nil or
+// incomplete summary may hold anything.
+func (b *blockSummary) mayHold(name string, filters map[string]string, from, to time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.building || len(b.Bloom) == 0 {
+		return true
+	}
+	if b.MaxTime < from.UnixNano() || b.MinTime >= to.UnixNano() {
+		return false
+	}
+	if name != "" && !b.hasKeyLocked(bloomKey(nameLabel, name)) {
+		return false
+	}
+	for k, v := range filters {
+		if v != "" && !b.hasKeyLocked(bloomKey(k, v)) {
+			return false
+		}
+	}
+	return true
+}
+
+// blockFilter returns the check forEachBlock skips blocks with: those that
+// cannot hold samples in [from, to) of a metric name, or any name if
+// empty, with the filters' labels
+func blockFilter(name string, filters map[string]string, from, to time.Time) func(*blockSummary) bool {
+	return func(b *blockSummary) bool {
+		return b.mayHold(name, filters, from, to)
+	}
+}
+
+// loadSummary reads the summary a shard was closed with, or returns nil
+func loadSummary(dir string) *blockSummary {
+	data, err := os.ReadFile(filepath.Join(dir, summaryFile))
+	if err != nil {
+		return nil
+	}
+	var b blockSummary
+	if err := json.Unmarshal(data, &b); err != nil || len(b.Bloom) == 0 || bits.OnesCount(uint(len(b.Bloom))) != 1 {
+		return nil
+	}
+	return &b
+}
+
+// saveSummary writes the summary of a shard being closed, if complete
+func saveSummary(dir string, b *blockSummary) error {
+	compact := b.compact()
+	if compact == nil {
+		return nil
+	}
+	data, err := json.Marshal(compact)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, summaryFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, summaryFile))
+}
+
+// errNotIndexed stops building the summary of a shard whose series are not
+// indexed yet
+var errNotIndexed = errors.New("shard not indexed")
+
+// summarizeShard builds the summary of a shard from its index, its raw
+// sample keys and the headers of its chunks
+func (s *BadgerStore) summarizeShard(db *badger.DB) (*blockSummary, error) {
+	b := newBlockSummary()
+	err := db.View(func(txn *badger.Txn) error {
+		if ok, err := isIndexed(txn); err != nil || !ok {
+			if err == nil {
+				err = errNotIndexed
+			}
+			return err
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		items := func(prefix string, fn func(item *badger.Item) error) error {
+			opts.Prefix = []byte(prefix)
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				if err := fn(it.Item()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		err := items(postingsPrefix, func(item *badger.Item) error {
+			key := item.Key()[len(postingsPrefix):]
+			if i := bytes.LastIndexByte(key, ':'); i > 0 {
+				b.addKeyLocked(key[:i])
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Tombstoned series lost their postings, but are still read
+		entries, err := tombstonedSeries(txn, beginningOfTime)
+		if err != nil {
+			return err
+		}
+		b.observe(math.MaxInt64, math.MinInt64, entries)
+
+		err = items(chunkPrefix, func(item *badger.Item) error {
+			return item.Value(func(val []byte) error {
+				meta, _, err := decodeChunk(val)
+				if err != nil {
+					// Chunks that cannot be decoded are skipped when read
+					return nil
+				}
+				b.observeLocked(meta.MinTime*int64(time.Millisecond), meta.MaxTime*int64(time.Millisecond))
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		return items(rawPrefix, func(item *badger.Item) error {
+			if _, ts, _, ok := splitSeriesKey(item.Key(), rawPrefix); ok {
+				b.observeLocked(ts, ts)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// observe adds samples in [first, last] written to a shard, and the
+// series they indexed, to its summary
+func (ss *shardSet) observe(sh *shard, first, last int64, indexed []*seriesEntry) {
+	if b := ss.summaryOf(sh); b != nil && first <= last {
+		b.observe(first, last, indexed)
+	}
+}
+
+// summarizeLocked builds the summary of an open shard in the background
+// and completes its current one with it. Callers must hold mu.
+func (ss *shardSet) summarizeLocked(sh *shard) {
+	if ss.summarize == nil || sh.summary == nil || !sh.summary.isBuilding() {
+		return
+	}
+	sh.refs++
+	b, db := sh.summary, sh.db
+	go func() {
+		defer ss.release(sh)
+		built, err := ss.summarize(db)
+		if errors.Is(err, errNotIndexed) {
+			// Built again once the index build reaches the shard
+			return
+		}
+		if err != nil {
+			ss.logger.Warn("Failed to summarize shard", zap.String("shard", sh.dir), zap.Error(err))
+			return
+		}
+		b.merge(built)
+	}()
+}
+
+// resummarize builds a shard's summary again, after writes that did not
+// update it, such as a replicated segment or the index build
+func (ss *shardSet) resummarize(sh *shard) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if sh.db == nil || sh.dropped {
+		return
+	}
+	if sh.summary == nil || !sh.summary.isBuilding() {
+		sh.summary = newBlockSummary()
+		sh.summary.building = true
+	}
+	ss.summarizeLocked(sh)
+}
+
+// invalidateSummary stops queries from skipping a shard until
+// resummarize rebuilds its summary
+func (ss *shardSet) invalidateSummary(sh *shard) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sh.summary = newBlockSummary()
+	sh.summary.building = true
+}
+
+// summaryOf returns a shard's summary, or nil
+func (ss *shardSet) summaryOf(sh *shard) *blockSummary {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return sh.summary
+}
+
+func (b *blockSummary) isBuilding() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.building
+}
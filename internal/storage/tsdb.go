@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,11 +17,44 @@ import (
 type Storage interface {
 	WriteMetrics(metrics []*models.Metric) error
 	QueryMetrics(query *models.Query) ([]*models.TimeSeries, error)
+	ListSeries(start, end time.Time) ([]*models.SeriesInfo, error)
+	FindSeries(query *models.Query) ([]*models.Series, error)
+	LabelNames(start, end time.Time) ([]string, error)
+	LabelValues(label string, start, end time.Time) ([]string, error)
+	StorageUsage() (*models.StorageUsage, error)
+	MigrationStatus() *models.MigrationStatus
+	DeleteNodeMetrics(nodeID string, archiver MetricArchiver) (int64, error)
 	SaveNode(node *models.Node) error
 	GetNode(nodeID string) (*models.Node, error)
+	DeleteNode(nodeID string) error
 	ListNodes() ([]*models.Node, error)
 	SaveAlert(alert *models.Alert) error
 	GetAlerts(filter *models.AlertFilter) ([]*models.Alert, error)
+	AppendAlertEvent(event *models.AlertEvent) error
+	GetAlertEvents(after uint64, limit int) ([]*models.AlertEvent, error)
+	LastAlertEventSeq() (uint64, error)
+	SaveDerivedMetric(metric *models.DerivedMetric) error
+	ListDerivedMetrics() ([]*models.DerivedMetric, error)
+	DeleteDerivedMetric(name string) error
+	SaveIngestRule(rule *models.IngestRule) error
+	ListIngestRules() ([]*models.IngestRule, error)
+	DeleteIngestRule(name string) error
+	SaveAnnotation(annotation *models.Annotation) error
+	GetAnnotations(filter *models.AnnotationFilter) ([]*models.Annotation, error)
+	DeleteAnnotation(id string) error
+	SaveSilence(silence *models.Silence) error
+	ListSilences() ([]*models.Silence, error)
+	DeleteSilence(id string) error
+	SaveDashboard(dashboard *models.Dashboard) error
+	ListDashboards() ([]*models.Dashboard, error)
+	GetDashboardVersions(id string) ([]*models.Dashboard, error)
+	DeleteDashboard(id string) error
+	SaveTenantUsage(usage *models.TenantUsage) error
+	ListTenantUsage(from, to string) ([]*models.TenantUsage, error)
+	DeleteTenantUsageBefore(day string) (int64, error)
+	SaveNodeMonth(record *models.NodeMonth) error
+	ListNodeMonths(from, to string) ([]*models.NodeMonth, error)
+	DeleteNodeMonthsBefore(month string) (int64, error)
 	Close() error
 }
 
@@ -31,12 +66,22 @@ type TimeSeriesDB struct {
 	nodes       map[string]*models.Node
 	nodesMu     sync.RWMutex
 	retention   *RetentionManager
-	compression *CompressionEngine
+	rollups     *RollupManager
+	chunks      *ChunkCompactor
+	usage       *models.StorageUsage
+	usageMu     sync.RWMutex
 	ctx         context.Context
 	cancel      context.CancelFunc
 	wg          sync.WaitGroup
+	// meta holds metadata; it is badgerStore with the badger driver
+	meta MetadataStore
+	// migration duplicates writes to a new store; nil unless enabled
+	migration *StorageMigration
 }
 
+// ErrReadOnly is returned by writes to a read replica
+var ErrReadOnly = errors.New("storage is a read-only replica")
+
 // NewTimeSeriesDB creates a new time-series database instance
 func NewTimeSeriesDB(config *utils.StorageConfig, logger *zap.Logger) (*TimeSeriesDB, error) {
 	if logger == nil {
@@ -47,18 +92,30 @@ func NewTimeSeriesDB(config *utils.StorageConfig, logger *zap.Logger) (*TimeSeri
 		}
 	}
 
+	// Label strings are interned process-wide
+	if config.SymbolTableSize != 0 {
+		utils.Symbols.SetMax(fitSymbols(config.SymbolTableSize))
+	}
+
 	// Initialize BadgerDB store
 	badgerStore, err := NewBadgerStore(config, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create badger store: %w", err)
 	}
 
+	meta, err := openMetadataStore(config, badgerStore, logger)
+	if err != nil {
+		badgerStore.Close()
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	tsdb := &TimeSeriesDB{
 		config:      config,
 		logger:      logger,
 		badgerStore: badgerStore,
+		meta:        meta,
 		nodes:       make(map[string]*models.Node),
 		ctx:         ctx,
 		cancel:      cancel,
@@ -66,19 +123,98 @@ func NewTimeSeriesDB(config *utils.StorageConfig, logger *zap.Logger) (*TimeSeri
 
 	// Initialize retention manager
 	tsdb.retention = NewRetentionManager(config, badgerStore, logger)
+	tsdb.retention.meta = meta
+
+	// Initialize rollups if enabled
+	if config.Rollups.Enabled {
+		rollups, err := NewRollupManager(config, badgerStore, logger)
+		if err != nil {
+			cancel()
+			badgerStore.Close()
+			return nil, fmt.Errorf("failed to create rollup manager: %w", err)
+		}
+		tsdb.rollups = rollups
+		tsdb.retention.rollups = rollups
+	}
 
-	// Initialize compression engine if enabled
+	// Seal samples into compressed chunks if enabled
 	if config.Compression {
-		tsdb.compression = NewCompressionEngine(config, logger)
+		chunks, err := NewChunkCompactor(config, badgerStore, logger)
+		if err != nil {
+			cancel()
+			badgerStore.Close()
+			return nil, fmt.Errorf("failed to create chunk compactor: %w", err)
+		}
+		tsdb.chunks = chunks
 	}
 
 	// Start background jobs
 	tsdb.wg.Add(1)
-	go tsdb.runRetentionJob()
+	go tsdb.runUsageJob()
+	if tsdb.readOnly() {
+		// A read replica's storage is only written by its primary, so it
+		// runs none of the jobs that maintain it
+		replica, err := newReplica(config, badgerStore, meta, logger)
+		if err != nil {
+			tsdb.Close()
+			return nil, fmt.Errorf("failed to create replica: %w", err)
+		}
+		replica.onApply = tsdb.replicaApplied
+		replica.onMetadata = tsdb.clearNodes
+		tsdb.wg.Add(1)
+		go tsdb.runReplica(replica)
+	} else {
+		tsdb.wg.Add(1)
+		go tsdb.runRetentionJob()
+		tsdb.wg.Add(1)
+		go tsdb.runIndexBuild()
+		if badgerStore.legacy.Load() {
+			tsdb.wg.Add(1)
+			go tsdb.runShardMigration()
+		}
+		if tsdb.chunks != nil {
+			tsdb.wg.Add(1)
+			go tsdb.runChunkJob()
+		}
+		if tsdb.rollups != nil {
+			tsdb.wg.Add(1)
+			go tsdb.runRollupJob()
+		}
+		if config.StaleSeries.Enabled {
+			tsdb.wg.Add(1)
+			go tsdb.runStaleSeriesJob()
+		}
+		if config.Migration.Enabled {
+			migration, err := NewStorageMigration(config, badgerStore, logger)
+			if err != nil {
+				tsdb.Close()
+				return nil, fmt.Errorf("failed to start storage migration: %w", err)
+			}
+			tsdb.migration = migration
+			tsdb.wg.Add(1)
+			go tsdb.runMigration()
+		}
+		if config.Replication.Role == utils.ReplicationRolePrimary {
+			targets := config.Replication.ReplicaURLs
+			if config.Replication.StandbyURL != "" {
+				targets = append([]string{config.Replication.StandbyURL}, targets...)
+			}
+			for _, target := range targets {
+				replicator, err := NewReplicator(config, target, badgerStore, meta, logger)
+				if err != nil {
+					tsdb.Close()
+					return nil, fmt.Errorf("failed to create replicator: %w", err)
+				}
+				tsdb.wg.Add(1)
+				go tsdb.runReplicationJob(replicator)
+			}
+		}
+	}
 
 	logger.Info("Time-series database initialized",
 		zap.String("path", config.Path),
 		zap.Bool("compression", config.Compression),
+		zap.Bool("rollups", config.Rollups.Enabled),
 	)
 
 	return tsdb, nil
@@ -90,20 +226,17 @@ func (db *TimeSeriesDB) WriteMetrics(metrics []*models.Metric) error {
 		return nil
 	}
 
-	// Compress metrics if compression is enabled
-	if db.compression != nil {
-		compressedMetrics, err := db.compression.CompressMetrics(metrics)
-		if err != nil {
-			db.logger.Warn("Failed to compress metrics, writing uncompressed",
-				zap.Error(err),
-			)
-		} else {
-			return db.badgerStore.WriteCompressedMetrics(compressedMetrics)
-		}
+	if db.readOnly() {
+		return ErrReadOnly
 	}
 
-	// Write uncompressed metrics
-	return db.badgerStore.WriteMetrics(metrics)
+	// Samples are written individually and sealed into compressed chunks
+	// later, when compression is enabled
+	if err := db.badgerStore.WriteMetrics(metrics); err != nil {
+		return err
+	}
+	db.migration.write(metrics)
+	return nil
 }
 
 // QueryMetrics queries metrics based on the given query
@@ -112,6 +245,13 @@ func (db *TimeSeriesDB) QueryMetrics(query *models.Query) ([]*models.TimeSeries,
 		return nil, fmt.Errorf("query is nil")
 	}
 
+	// Coarse steps are served from rollups where they have been computed
+	if db.rollups != nil {
+		if level := db.rollups.levelFor(query.StartTime, query.Step, db.config.RetentionPeriod); level != nil {
+			return db.rollups.Query(level, query)
+		}
+	}
+
 	// Build query string from Query struct
 	queryStr := query.MetricName
 	if len(query.Labels) > 0 {
@@ -121,10 +261,93 @@ func (db *TimeSeriesDB) QueryMetrics(query *models.Query) ([]*models.TimeSeries,
 		for k, v := range query.Labels {
 			labelPairs = append(labelPairs, fmt.Sprintf("%s=\"%s\"", k, v))
 		}
-		queryStr = fmt.Sprintf("%s{%s}", query.MetricName, string(labelPairs[0]))
+		queryStr = fmt.Sprintf("%s{%s}", query.MetricName, strings.Join(labelPairs, ","))
+	}
+
+	series, err := db.badgerStore.QueryMetrics(queryStr, query.StartTime, query.EndTime, query.Step)
+	if err != nil {
+		return nil, err
+	}
+	db.migration.compare(query, queryStr, series)
+	return series, nil
+}
+
+// ListSeries returns every series with samples in the given time range
+func (db *TimeSeriesDB) ListSeries(start, end time.Time) ([]*models.SeriesInfo, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("end time is before start time")
+	}
+	return db.badgerStore.ListSeries(start, end)
+}
+
+// FindSeries returns the series matching a query's metric name, which may
+// be empty, and labels in its time range, without reading their samples
+func (db *TimeSeriesDB) FindSeries(query *models.Query) ([]*models.Series, error) {
+	if query.EndTime.Before(query.StartTime) {
+		return nil, fmt.Errorf("end time is before start time")
+	}
+	return db.badgerStore.FindSeries(query.MetricName, query.Labels, query.StartTime, query.EndTime)
+}
+
+// LabelNames returns the label names of the series in a time range
+func (db *TimeSeriesDB) LabelNames(start, end time.Time) ([]string, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("end time is before start time")
+	}
+	return db.badgerStore.LabelNames(start, end)
+}
+
+// LabelValues returns the values of a label in the series in a time range
+func (db *TimeSeriesDB) LabelValues(label string, start, end time.Time) ([]string, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("end time is before start time")
+	}
+	return db.badgerStore.LabelValues(label, start, end)
+}
+
+// StorageUsage returns the latest storage usage estimate, computing one if
+// the background job has not run yet
+func (db *TimeSeriesDB) StorageUsage() (*models.StorageUsage, error) {
+	db.usageMu.RLock()
+	usage := db.usage
+	db.usageMu.RUnlock()
+
+	if usage != nil {
+		return usage, nil
+	}
+	return db.refreshUsage()
+}
+
+func (db *TimeSeriesDB) refreshUsage() (*models.StorageUsage, error) {
+	usage, err := db.badgerStore.EstimateUsage(db.config.Usage.SampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	db.usageMu.Lock()
+	db.usage = usage
+	db.usageMu.Unlock()
+
+	return usage, nil
+}
+
+// DeleteNodeMetrics deletes every sample reported by a node, archiving them
+// first when archiver is set
+func (db *TimeSeriesDB) DeleteNodeMetrics(nodeID string, archiver MetricArchiver) (int64, error) {
+	if nodeID == "" {
+		return 0, fmt.Errorf("node ID is required")
+	}
+	deleted, err := db.badgerStore.DeleteNodeMetrics(nodeID, archiver)
+	if err != nil {
+		return deleted, err
 	}
+	db.migration.deleteNode(nodeID)
+	return deleted, nil
+}
 
-	return db.badgerStore.QueryMetrics(queryStr, query.StartTime, query.EndTime, query.Step)
+// MigrationStatus reports the progress of the storage migration
+func (db *TimeSeriesDB) MigrationStatus() *models.MigrationStatus {
+	return db.migration.Status()
 }
 
 // SaveNode saves a node to the database
@@ -139,7 +362,7 @@ func (db *TimeSeriesDB) SaveNode(node *models.Node) error {
 	db.nodesMu.Unlock()
 
 	// Persist to storage
-	return db.badgerStore.SaveNode(node)
+	return db.meta.SaveNode(node)
 }
 
 // GetNode retrieves a node by ID
@@ -158,7 +381,7 @@ func (db *TimeSeriesDB) GetNode(nodeID string) (*models.Node, error) {
 	}
 
 	// Fetch from storage
-	node, err := db.badgerStore.GetNode(nodeID)
+	node, err := db.meta.GetNode(nodeID)
 	if err != nil {
 		return nil, err
 	}
@@ -171,9 +394,22 @@ func (db *TimeSeriesDB) GetNode(nodeID string) (*models.Node, error) {
 	return node, nil
 }
 
+// DeleteNode removes a node record, keeping its series
+func (db *TimeSeriesDB) DeleteNode(nodeID string) error {
+	if nodeID == "" {
+		return fmt.Errorf("node ID is required")
+	}
+
+	db.nodesMu.Lock()
+	delete(db.nodes, nodeID)
+	db.nodesMu.Unlock()
+
+	return db.meta.DeleteNode(nodeID)
+}
+
 // ListNodes returns all registered nodes
 func (db *TimeSeriesDB) ListNodes() ([]*models.Node, error) {
-	return db.badgerStore.ListNodes()
+	return db.meta.ListNodes()
 }
 
 // SaveAlert saves an alert to the database
@@ -181,12 +417,165 @@ func (db *TimeSeriesDB) SaveAlert(alert *models.Alert) error {
 	if alert == nil {
 		return fmt.Errorf("alert is nil")
 	}
-	return db.badgerStore.SaveAlert(alert)
+	return db.meta.SaveAlert(alert)
 }
 
 // GetAlerts retrieves alerts based on the filter
 func (db *TimeSeriesDB) GetAlerts(filter *models.AlertFilter) ([]*models.Alert, error) {
-	return db.badgerStore.GetAlerts(filter)
+	return db.meta.GetAlerts(filter)
+}
+
+// AppendAlertEvent appends an alert state transition to the event log and
+// sets its sequence number
+func (db *TimeSeriesDB) AppendAlertEvent(event *models.AlertEvent) error {
+	if event == nil || event.Alert == nil {
+		return fmt.Errorf("invalid alert event: nil or missing alert")
+	}
+	return db.meta.AppendAlertEvent(event)
+}
+
+// GetAlertEvents returns alert events with a sequence after the given one
+func (db *TimeSeriesDB) GetAlertEvents(after uint64, limit int) ([]*models.AlertEvent, error) {
+	return db.meta.GetAlertEvents(after, limit)
+}
+
+// LastAlertEventSeq returns the sequence of the newest alert event
+func (db *TimeSeriesDB) LastAlertEventSeq() (uint64, error) {
+	return db.meta.LastAlertEventSeq()
+}
+
+// SaveDerivedMetric saves a derived metric definition
+func (db *TimeSeriesDB) SaveDerivedMetric(metric *models.DerivedMetric) error {
+	if metric == nil || metric.Name == "" {
+		return fmt.Errorf("invalid derived metric: nil or empty name")
+	}
+	return db.meta.SaveDerivedMetric(metric)
+}
+
+// ListDerivedMetrics returns all derived metric definitions
+func (db *TimeSeriesDB) ListDerivedMetrics() ([]*models.DerivedMetric, error) {
+	return db.meta.ListDerivedMetrics()
+}
+
+// DeleteDerivedMetric deletes a derived metric definition
+func (db *TimeSeriesDB) DeleteDerivedMetric(name string) error {
+	return db.meta.DeleteDerivedMetric(name)
+}
+
+// SaveIngestRule saves an ingest rule
+func (db *TimeSeriesDB) SaveIngestRule(rule *models.IngestRule) error {
+	if rule == nil || rule.Name == "" {
+		return fmt.Errorf("invalid ingest rule: nil or empty name")
+	}
+	return db.meta.SaveIngestRule(rule)
+}
+
+// ListIngestRules returns all ingest rules
+func (db *TimeSeriesDB) ListIngestRules() ([]*models.IngestRule, error) {
+	return db.meta.ListIngestRules()
+}
+
+// DeleteIngestRule deletes an ingest rule
+func (db *TimeSeriesDB) DeleteIngestRule(name string) error {
+	return db.meta.DeleteIngestRule(name)
+}
+
+// SaveAnnotation saves an annotation
+func (db *TimeSeriesDB) SaveAnnotation(annotation *models.Annotation) error {
+	if annotation == nil || annotation.ID == "" {
+		return fmt.Errorf("invalid annotation: nil or empty ID")
+	}
+	return db.meta.SaveAnnotation(annotation)
+}
+
+// GetAnnotations retrieves annotations based on the filter
+func (db *TimeSeriesDB) GetAnnotations(filter *models.AnnotationFilter) ([]*models.Annotation, error) {
+	return db.meta.GetAnnotations(filter)
+}
+
+// DeleteAnnotation deletes an annotation
+func (db *TimeSeriesDB) DeleteAnnotation(id string) error {
+	return db.meta.DeleteAnnotation(id)
+}
+
+// SaveSilence saves a silence
+func (db *TimeSeriesDB) SaveSilence(silence *models.Silence) error {
+	if silence == nil || silence.ID == "" {
+		return fmt.Errorf("invalid silence: nil or empty ID")
+	}
+	return db.meta.SaveSilence(silence)
+}
+
+// ListSilences retrieves all silences
+func (db *TimeSeriesDB) ListSilences() ([]*models.Silence, error) {
+	return db.meta.ListSilences()
+}
+
+// DeleteSilence deletes a silence
+func (db *TimeSeriesDB) DeleteSilence(id string) error {
+	return db.meta.DeleteSilence(id)
+}
+
+// SaveDashboard saves a dashboard and records it as its version
+func (db *TimeSeriesDB) SaveDashboard(dashboard *models.Dashboard) error {
+	if dashboard == nil || dashboard.ID == "" {
+		return fmt.Errorf("invalid dashboard: nil or empty ID")
+	}
+	return db.meta.SaveDashboard(dashboard)
+}
+
+// ListDashboards retrieves all dashboards
+func (db *TimeSeriesDB) ListDashboards() ([]*models.Dashboard, error) {
+	return db.meta.ListDashboards()
+}
+
+// GetDashboardVersions retrieves the saved versions of a dashboard
+func (db *TimeSeriesDB) GetDashboardVersions(id string) ([]*models.Dashboard, error) {
+	return db.meta.GetDashboardVersions(id)
+}
+
+// DeleteDashboard deletes a dashboard and its versions
+func (db *TimeSeriesDB) DeleteDashboard(id string) error {
+	return db.meta.DeleteDashboard(id)
+}
+
+// SaveTenantUsage saves a tenant's usage on a day
+func (db *TimeSeriesDB) SaveTenantUsage(usage *models.TenantUsage) error {
+	if usage == nil || usage.Tenant == "" || usage.Day == "" {
+		return fmt.Errorf("invalid tenant usage: nil or empty tenant or day")
+	}
+	return db.meta.SaveTenantUsage(usage)
+}
+
+// ListTenantUsage lists the usage records of the days from and to, either
+// of which may be empty
+func (db *TimeSeriesDB) ListTenantUsage(from, to string) ([]*models.TenantUsage, error) {
+	return db.meta.ListTenantUsage(from, to)
+}
+
+// DeleteTenantUsageBefore deletes the usage records of days before day
+func (db *TimeSeriesDB) DeleteTenantUsageBefore(day string) (int64, error) {
+	return db.meta.DeleteTenantUsageBefore(day)
+}
+
+// SaveNodeMonth saves a node's aggregates for a month
+func (db *TimeSeriesDB) SaveNodeMonth(record *models.NodeMonth) error {
+	if record == nil || record.NodeID == "" || record.Month == "" {
+		return fmt.Errorf("invalid node month: nil or empty node or month")
+	}
+	return db.meta.SaveNodeMonth(record)
+}
+
+// ListNodeMonths lists the node aggregates of the months from and to,
+// either of which may be empty
+func (db *TimeSeriesDB) ListNodeMonths(from, to string) ([]*models.NodeMonth, error) {
+	return db.meta.ListNodeMonths(from, to)
+}
+
+// DeleteNodeMonthsBefore deletes the node aggregates of months before
+// month
+func (db *TimeSeriesDB) DeleteNodeMonthsBefore(month string) (int64, error) {
+	return db.meta.DeleteNodeMonthsBefore(month)
 }
 
 // Close closes the database and releases resources
@@ -199,6 +588,16 @@ func (db *TimeSeriesDB) Close() error {
 	// Wait for background jobs to finish
 	db.wg.Wait()
 
+	if err := db.migration.close(); err != nil {
+		db.logger.Warn("Failed to close migration store", zap.Error(err))
+	}
+
+	if sqlMeta, ok := db.meta.(*SQLMetadataStore); ok {
+		if err := sqlMeta.Close(); err != nil {
+			db.logger.Warn("Failed to close metadata store", zap.Error(err))
+		}
+	}
+
 	// Close BadgerDB
 	if db.badgerStore != nil {
 		if err := db.badgerStore.Close(); err != nil {
@@ -225,11 +624,207 @@ func (db *TimeSeriesDB) runRetentionJob() {
 			db.logger.Info("Retention job stopped")
 			return
 		case <-ticker.C:
+			// Shards are archived before retention would drop them
+			if err := db.retention.ApplyTieringPolicy(db.ctx); err != nil {
+				db.logger.Error("Tiering failed", zap.Error(err))
+			}
 			if err := db.retention.Cleanup(); err != nil {
 				db.logger.Error("Retention cleanup failed", zap.Error(err))
 			} else {
 				db.logger.Debug("Retention cleanup completed")
 			}
+			db.migration.cleanup(time.Now().Add(-db.config.RetentionPeriod))
+		}
+	}
+}
+
+// runShardMigration moves samples written before sharding into shards.
+// They stay readable from the main database until moved.
+func (db *TimeSeriesDB) runShardMigration() {
+	defer db.wg.Done()
+
+	db.logger.Info("Moving samples into time-partitioned shards")
+	if err := db.badgerStore.migrateToShards(db.ctx); err != nil {
+		db.logger.Error("Shard migration failed; it is retried on the next start", zap.Error(err))
+	}
+}
+
+// runIndexBuild indexes the series of shards written before the index
+// was added. Their queries scan samples until then.
+func (db *TimeSeriesDB) runIndexBuild() {
+	defer db.wg.Done()
+
+	if err := db.badgerStore.BuildIndex(db.ctx); err != nil {
+		db.logger.Error("Series index build failed; it is retried on the next start", zap.Error(err))
+	}
+}
+
+// runChunkJob periodically seals samples into compressed chunks
+func (db *TimeSeriesDB) runChunkJob() {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(db.config.Chunks.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := db.chunks.Run(); err != nil {
+			db.logger.Error("Chunk compaction failed", zap.Error(err))
+		}
+
+		select {
+		case <-db.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runRollupJob periodically computes rollups of the samples written since
+// the last run
+func (db *TimeSeriesDB) runRollupJob() {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(db.config.Rollups.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := db.rollups.Run(db.config.RetentionPeriod); err != nil {
+			db.logger.Error("Rollup computation failed", zap.Error(err))
+		}
+
+		select {
+		case <-db.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runStaleSeriesJob periodically tombstones the index entries of series
+// that stopped reporting
+func (db *TimeSeriesDB) runStaleSeriesJob() {
+	defer db.wg.Done()
+
+	stale := db.config.StaleSeries
+	ticker := time.NewTicker(stale.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.ctx.Done():
+			return
+		case <-ticker.C:
+			// Shards overlapping the last run's cutoff are checked again
+			if _, err := db.badgerStore.TombstoneIdleSeries(db.ctx, stale.IdleTimeout, stale.Interval); err != nil {
+				db.logger.Error("Stale series tombstoning failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// runMigration duplicates writes to the new store and backfills it until
+// the database is closed
+func (db *TimeSeriesDB) runMigration() {
+	defer db.wg.Done()
+	db.migration.run(db.ctx)
+}
+
+// runReplicationJob periodically ships storage writes to the standby
+func (db *TimeSeriesDB) runReplicationJob(replicator *Replicator) {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(db.config.Replication.Interval)
+	defer ticker.Stop()
+
+	var failing bool
+	for {
+		select {
+		case <-db.ctx.Done():
+			return
+		case <-ticker.C:
+			err := replicator.Run(db.ctx)
+			switch {
+			case err != nil && db.ctx.Err() == nil:
+				// Logged once per outage, as the standby may be down for a while
+				if !failing {
+					db.logger.Error("Replication to the standby failed", zap.Error(err))
+				}
+				failing = true
+			case err == nil && failing:
+				db.logger.Info("Replication to the standby resumed")
+				failing = false
+			}
+		}
+	}
+}
+
+// readOnly reports whether the database is a read replica
+func (db *TimeSeriesDB) readOnly() bool {
+	return db.config.Replication.Role == utils.ReplicationRoleReplica
+}
+
+// runReplica applies the writes the primary replicates until the database
+// is closed
+func (db *TimeSeriesDB) runReplica(replica *Standby) {
+	defer db.wg.Done()
+
+	if err := replica.Run(db.ctx); err != nil && db.ctx.Err() == nil {
+		db.logger.Error("Read replica stopped receiving writes", zap.Error(err))
+	}
+}
+
+// replicaApplied reloads the state kept in memory from a database the
+// primary has written to
+func (db *TimeSeriesDB) replicaApplied(name string) {
+	if name != replicationMainDB {
+		return
+	}
+	if err := db.badgerStore.loadChunkSpan(); err != nil {
+		db.logger.Warn("Failed to reload chunk duration", zap.Error(err))
+	}
+	if db.rollups != nil {
+		if err := db.rollups.reloadWatermarks(); err != nil {
+			db.logger.Warn("Failed to reload rollup watermarks", zap.Error(err))
+		}
+	}
+	// Badger metadata is kept in the main database
+	db.clearNodes()
+}
+
+// clearNodes empties the node cache
+func (db *TimeSeriesDB) clearNodes() {
+	db.nodesMu.Lock()
+	db.nodes = make(map[string]*models.Node)
+	db.nodesMu.Unlock()
+}
+
+// runUsageJob periodically estimates storage usage per metric and node
+func (db *TimeSeriesDB) runUsageJob() {
+	defer db.wg.Done()
+
+	interval := db.config.Usage.Interval
+	if interval <= 0 {
+		interval = 1 * time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.ctx.Done():
+			return
+		case <-ticker.C:
+			usage, err := db.refreshUsage()
+			if err != nil {
+				db.logger.Error("Storage usage estimation failed", zap.Error(err))
+				continue
+			}
+			db.logger.Debug("Storage usage estimated",
+				zap.Int64("disk_bytes", usage.DiskBytes),
+				zap.Int("metrics", len(usage.Metrics)),
+				zap.String("duration", usage.Duration),
+			)
 		}
 	}
 }
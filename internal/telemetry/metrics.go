@@ -0,0 +1,256 @@
+// Package telemetry instruments the server with Prometheus metrics about
+// itself, served in the exposition format on /metrics.
+package telemetry
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "lnmonja"
+
+// Metrics holds the server's self-metrics. Methods are safe to call on a
+// nil *Metrics, which records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	ingestedSamples  *prometheus.CounterVec
+	droppedSamples   *prometheus.CounterVec
+	rejectedSamples  *prometheus.CounterVec
+	shedSamples      *prometheus.CounterVec
+	storageErrors    *prometheus.CounterVec
+	queryDuration    *prometheus.HistogramVec
+	requestDuration  *prometheus.HistogramVec
+	alertEvaluations prometheus.Counter
+	alertDuration    prometheus.Histogram
+	ruleDuration     *prometheus.HistogramVec
+	ruleLag          prometheus.Histogram
+	missedRules      *prometheus.CounterVec
+}
+
+// New creates the metrics on their own registry, with the Go runtime and
+// process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		ingestedSamples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ingested_samples_total",
+			Help:      "Samples stored, by the transport they were received on.",
+		}, []string{"transport"}),
+		droppedSamples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ingest_dropped_samples_total",
+			Help:      "Samples dropped by ingest rules, by transport.",
+		}, []string{"transport"}),
+		rejectedSamples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ingest_rejected_samples_total",
+			Help:      "Samples rejected by metric filters, by filter and reason.",
+		}, []string{"filter", "reason"}),
+		shedSamples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ingest_shed_samples_total",
+			Help:      "Samples shed while the server was overloaded, by priority.",
+		}, []string{"priority"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_errors_total",
+			Help:      "Failed storage operations, by operation.",
+		}, []string{"operation"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Time taken to evaluate metric queries, by query kind.",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"kind"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve REST API requests, by route, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		alertEvaluations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "alert_evaluations_total",
+			Help:      "Alert rule evaluations against received metrics or the latest samples.",
+		}),
+		alertDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "alert_check_duration_seconds",
+			Help:      "Time taken to check a batch of metrics against the alert rules.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		}),
+		ruleDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "alert_rule_evaluation_duration_seconds",
+			Help:      "Time taken by scheduled evaluations of a rule, by rule group.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		}, []string{"group"}),
+		ruleLag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "alert_rule_evaluation_lag_seconds",
+			Help:      "Delay of scheduled rule evaluations behind their slot, including jitter.",
+			Buckets:   []float64{.001, .01, .1, .5, 1, 2.5, 5, 10, 30, 60},
+		}),
+		missedRules: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "alert_rule_evaluations_missed_total",
+			Help:      "Scheduled rule evaluations skipped, by rule group and reason.",
+		}, []string{"group", "reason"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.ingestedSamples,
+		m.droppedSamples,
+		m.rejectedSamples,
+		m.shedSamples,
+		m.storageErrors,
+		m.queryDuration,
+		m.requestDuration,
+		m.alertEvaluations,
+		m.alertDuration,
+		m.ruleDuration,
+		m.ruleLag,
+		m.missedRules,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at each scrape
+func (m *Metrics) GaugeFunc(name, help string, fn func() float64) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// CounterFunc registers a counter whose value is read from fn at each
+// scrape
+func (m *Metrics) CounterFunc(name, help string, fn func() float64) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// Ingested records samples stored from a transport
+func (m *Metrics) Ingested(transport string, n int) {
+	if m == nil {
+		return
+	}
+	m.ingestedSamples.WithLabelValues(transport).Add(float64(n))
+}
+
+// Dropped records samples dropped by ingest rules
+func (m *Metrics) Dropped(transport string, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.droppedSamples.WithLabelValues(transport).Add(float64(n))
+}
+
+// Rejected records samples rejected by a metric filter
+func (m *Metrics) Rejected(filter, reason string, n int) {
+	if m == nil {
+		return
+	}
+	m.rejectedSamples.WithLabelValues(filter, reason).Add(float64(n))
+}
+
+// Shed records samples of a priority shed under overload
+func (m *Metrics) Shed(priority string, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.shedSamples.WithLabelValues(priority).Add(float64(n))
+}
+
+// StorageError records a failed storage operation, "write" or "read"
+func (m *Metrics) StorageError(operation string) {
+	if m == nil {
+		return
+	}
+	m.storageErrors.WithLabelValues(operation).Inc()
+}
+
+// ObserveQuery records the duration of a query started at start, and a
+// read error if it failed
+func (m *Metrics) ObserveQuery(kind string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.queryDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.storageErrors.WithLabelValues("read").Inc()
+	}
+}
+
+// ObserveRequest records the duration of an API request
+func (m *Metrics) ObserveRequest(route, method string, code int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requestDuration.WithLabelValues(route, method, statusCode(code)).Observe(d.Seconds())
+}
+
+// ObserveAlertCheck records a check of a batch that evaluated n rules
+func (m *Metrics) ObserveAlertCheck(n int, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.alertEvaluations.Add(float64(n))
+	m.alertDuration.Observe(time.Since(start).Seconds())
+}
+
+// ObserveRuleEvaluation records a scheduled evaluation of a rule of a
+// group against n series, started lag after its slot
+func (m *Metrics) ObserveRuleEvaluation(group string, n int, lag time.Duration, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.alertEvaluations.Add(float64(n))
+	m.ruleDuration.WithLabelValues(group).Observe(time.Since(start).Seconds())
+	m.ruleLag.Observe(lag.Seconds())
+}
+
+// MissedRuleEvaluations records n scheduled evaluations of a rule of a
+// group that did not run
+func (m *Metrics) MissedRuleEvaluations(group, reason string, n int) {
+	if m == nil {
+		return
+	}
+	m.missedRules.WithLabelValues(group, reason).Add(float64(n))
+}
+
+func statusCode(code int) string {
+	switch {
+	case code >= 500:
+		return "5xx"
+	case code >= 400:
+		return "4xx"
+	case code >= 300:
+		return "3xx"
+	default:
+		return "2xx"
+	}
+}
@@ -0,0 +1,56 @@
+package i18n
+
+// english holds the built-in messages. Keys under notification are used by
+// the default alert templates, keys under report by the default trend
+// report templates, and keys under ui by the dashboard.
+var english = map[string]string{
+	"notification.status.firing":   "firing",
+	"notification.status.resolved": "resolved",
+	"notification.multiple_alerts": "Multiple alerts",
+	"notification.counts":          "%d firing, %d resolved",
+	"notification.more_alerts":     "…and %d more alerts",
+
+	"notification.label.group":    "Group",
+	"notification.label.status":   "Status",
+	"notification.label.alert":    "Alert",
+	"notification.label.severity": "Severity",
+	"notification.label.node":     "Node",
+	"notification.label.value":    "Value",
+	"notification.label.since":    "Since",
+
+	"report.trends.title":      "Trend report",
+	"report.trends.period":     "%s to %s",
+	"report.trends.none":       "No data for these months",
+	"report.label.month":       "Month",
+	"report.label.nodes":       "Nodes",
+	"report.label.cpu_avg":     "CPU avg",
+	"report.label.cpu_max":     "CPU max",
+	"report.label.disk_used":   "Disk used",
+	"report.label.disk_growth": "Disk growth",
+	"report.label.alerts":      "Alerts",
+
+	"ui.title":             "LnMonja",
+	"ui.nav.dashboard":     "Dashboard",
+	"ui.nav.nodes":         "Nodes & Agents",
+	"ui.nav.alerts":        "Alerts",
+	"ui.nav.settings":      "Settings",
+	"ui.sidebar.collapse":  "Collapse sidebar",
+	"ui.sidebar.expand":    "Expand sidebar",
+	"ui.loading":           "Loading…",
+	"ui.error":             "Something went wrong",
+	"ui.retry":             "Retry",
+	"ui.save":              "Save",
+	"ui.cancel":            "Cancel",
+	"ui.delete":            "Delete",
+	"ui.search":            "Search",
+	"ui.refresh":           "Refresh",
+	"ui.time_range":        "Time range",
+	"ui.status.online":     "Online",
+	"ui.status.offline":    "Offline",
+	"ui.alerts.firing":     "Firing",
+	"ui.alerts.resolved":   "Resolved",
+	"ui.alerts.silence":    "Silence",
+	"ui.alerts.none":       "No alerts",
+	"ui.nodes.none":        "No nodes registered",
+	"ui.settings.language": "Language",
+}
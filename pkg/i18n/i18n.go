@@ -0,0 +1,261 @@
+// Package i18n holds the message catalogs that alert notifications and the
+// dashboard are localized with. English is built in; other locales are
+// YAML or JSON files named after their locale, e.g. de.yaml or pt-BR.json,
+// mapping message keys to text. Nested maps are flattened into dotted keys.
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultLocale is the built-in locale every other falls back to
+const DefaultLocale = "en"
+
+// localePattern matches the locale tags catalogs are named by
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+
+// Translator returns the text of a message key, formatted with args if
+// any are given. Keys without a message are returned as they are.
+type Translator func(key string, args ...interface{}) string
+
+// Catalog holds the messages of each locale. A message missing from a
+// locale is taken from its base language, then the default locale, then
+// English.
+type Catalog struct {
+	defaultLocale string
+	locales       map[string]map[string]string
+}
+
+// Load returns the built-in English messages plus the locales in dir, if
+// set. A locale file may override English messages too.
+func Load(defaultLocale, dir string) (*Catalog, error) {
+	c := &Catalog{
+		defaultLocale: DefaultLocale,
+		locales:       map[string]map[string]string{DefaultLocale: copyMessages(english)},
+	}
+	if defaultLocale != "" {
+		if !ValidLocale(defaultLocale) {
+			return nil, fmt.Errorf("invalid locale: %q", defaultLocale)
+		}
+		c.defaultLocale = normalize(defaultLocale)
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read locales: %w", err)
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			locale := strings.TrimSuffix(entry.Name(), ext)
+			if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") || !ValidLocale(locale) {
+				continue
+			}
+			messages, err := loadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			locale = normalize(locale)
+			if c.locales[locale] == nil {
+				c.locales[locale] = make(map[string]string, len(messages))
+			}
+			for k, v := range messages {
+				c.locales[locale][k] = v
+			}
+		}
+	}
+
+	if !c.has(c.defaultLocale) && !c.has(baseLanguage(c.defaultLocale)) {
+		return nil, fmt.Errorf("default locale %s has no messages", c.defaultLocale)
+	}
+	return c, nil
+}
+
+// loadFile reads a locale file into flat message keys
+func loadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read locale: %w", err)
+	}
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("invalid locale %s: %w", filepath.Base(path), err)
+	}
+	messages := make(map[string]string)
+	if err := flatten("", tree, messages); err != nil {
+		return nil, fmt.Errorf("invalid locale %s: %w", filepath.Base(path), err)
+	}
+	return messages, nil
+}
+
+func flatten(prefix string, tree map[string]interface{}, messages map[string]string) error {
+	for k, v := range tree {
+		key := prefix + k
+		switch v := v.(type) {
+		case string:
+			messages[key] = v
+		case map[string]interface{}:
+			if err := flatten(key+".", v, messages); err != nil {
+				return err
+			}
+		case nil:
+		default:
+			return fmt.Errorf("message %s is not text", key)
+		}
+	}
+	return nil
+}
+
+// ValidLocale reports whether a locale tag is well formed, e.g. fr or
+// pt-BR
+func ValidLocale(locale string) bool {
+	return localePattern.MatchString(locale)
+}
+
+// normalize lowercases a locale and separates its parts with hyphens
+func normalize(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+// baseLanguage returns the language of a locale, e.g. pt for pt-br
+func baseLanguage(locale string) string {
+	language, _, _ := strings.Cut(locale, "-")
+	return language
+}
+
+func (c *Catalog) has(locale string) bool {
+	_, ok := c.locales[locale]
+	return ok
+}
+
+// Default returns the locale used when none is requested
+func (c *Catalog) Default() string {
+	return c.defaultLocale
+}
+
+// Locales returns the locales with messages, sorted
+func (c *Catalog) Locales() []string {
+	locales := make([]string, 0, len(c.locales))
+	for locale := range c.locales {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// chain returns the locales a message is looked up in, most specific
+// first
+func (c *Catalog) chain(locale string) []string {
+	if locale == "" {
+		locale = c.defaultLocale
+	}
+	locale = normalize(locale)
+	var chain []string
+	for _, l := range []string{locale, baseLanguage(locale), c.defaultLocale, baseLanguage(c.defaultLocale), DefaultLocale} {
+		if c.has(l) && !contains(chain, l) {
+			chain = append(chain, l)
+		}
+	}
+	return chain
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the most specific locale with messages for a locale
+func (c *Catalog) Resolve(locale string) string {
+	return c.chain(locale)[0]
+}
+
+// Translate returns the text of a message in a locale
+func (c *Catalog) Translate(locale, key string, args ...interface{}) string {
+	for _, l := range c.chain(locale) {
+		if text, ok := c.locales[l][key]; ok {
+			if len(args) > 0 {
+				return fmt.Sprintf(text, args...)
+			}
+			return text
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf("%s%v", key, args)
+	}
+	return key
+}
+
+// Translator returns a translator for a locale
+func (c *Catalog) Translator(locale string) Translator {
+	return func(key string, args ...interface{}) string {
+		return c.Translate(locale, key, args...)
+	}
+}
+
+// Messages returns every message in a locale, including those it falls
+// back to
+func (c *Catalog) Messages(locale string) map[string]string {
+	chain := c.chain(locale)
+	messages := make(map[string]string)
+	for i := len(chain) - 1; i >= 0; i-- {
+		for k, v := range c.locales[chain[i]] {
+			messages[k] = v
+		}
+	}
+	return messages
+}
+
+// Negotiate picks the locale for an Accept-Language header: the preferred
+// language with messages, or the default locale
+func (c *Catalog) Negotiate(acceptLanguage string) string {
+	type preference struct {
+		locale string
+		q      float64
+	}
+	var prefs []preference
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 && ValidLocale(tag) {
+			prefs = append(prefs, preference{locale: normalize(tag), q: q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, p := range prefs {
+		if c.has(p.locale) {
+			return p.locale
+		}
+		if base := baseLanguage(p.locale); c.has(base) {
+			return base
+		}
+	}
+	return c.Resolve(c.defaultLocale)
+}
+
+func copyMessages(messages map[string]string) map[string]string {
+	copied := make(map[string]string, len(messages))
+	for k, v := range messages {
+		copied[k] = v
+	}
+	return copied
+}
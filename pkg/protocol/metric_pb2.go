@@ -1,5 +0,0 @@
-package protocol
-
-// This file is a placeholder for protobuf-generated code
-// In the simplified implementation, all protocol types are in protocol.go
-// In production, this would be auto-generated from .proto files using protoc
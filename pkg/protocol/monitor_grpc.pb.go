@@ -0,0 +1,378 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: monitor.proto
+
+package protocol
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	MonitorService_Register_FullMethodName         = "/lnmonja.MonitorService/Register"
+	MonitorService_StreamMetrics_FullMethodName    = "/lnmonja.MonitorService/StreamMetrics"
+	MonitorService_Heartbeat_FullMethodName        = "/lnmonja.MonitorService/Heartbeat"
+	MonitorService_UpdateConfig_FullMethodName     = "/lnmonja.MonitorService/UpdateConfig"
+	MonitorService_Unregister_FullMethodName       = "/lnmonja.MonitorService/Unregister"
+	MonitorService_Enroll_FullMethodName           = "/lnmonja.MonitorService/Enroll"
+	MonitorService_RenewCertificate_FullMethodName = "/lnmonja.MonitorService/RenewCertificate"
+)
+
+// MonitorServiceClient is the client API for MonitorService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MonitorServiceClient interface {
+	// Agent registration
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	// Bidirectional metric streaming
+	StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (MonitorService_StreamMetricsClient, error)
+	// Heartbeat
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	// Configuration updates
+	UpdateConfig(ctx context.Context, in *ConfigUpdate, opts ...grpc.CallOption) (*ConfigAck, error)
+	// Clean agent shutdown
+	Unregister(ctx context.Context, in *UnregisterRequest, opts ...grpc.CallOption) (*UnregisterResponse, error)
+	// Exchange an enrollment token for a client certificate
+	Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*CertificateResponse, error)
+	// Reissue the caller's client certificate before it expires
+	RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*CertificateResponse, error)
+}
+
+type monitorServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMonitorServiceClient(cc grpc.ClientConnInterface) MonitorServiceClient {
+	return &monitorServiceClient{cc}
+}
+
+func (c *monitorServiceClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, MonitorService_Register_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *monitorServiceClient) StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (MonitorService_StreamMetricsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MonitorService_ServiceDesc.Streams[0], MonitorService_StreamMetrics_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &monitorServiceStreamMetricsClient{stream}
+	return x, nil
+}
+
+type MonitorService_StreamMetricsClient interface {
+	Send(*MetricBatch) error
+	Recv() (*ControlMessage, error)
+	grpc.ClientStream
+}
+
+type monitorServiceStreamMetricsClient struct {
+	grpc.ClientStream
+}
+
+func (x *monitorServiceStreamMetricsClient) Send(m *MetricBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *monitorServiceStreamMetricsClient) Recv() (*ControlMessage, error) {
+	m := new(ControlMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *monitorServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, MonitorService_Heartbeat_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *monitorServiceClient) UpdateConfig(ctx context.Context, in *ConfigUpdate, opts ...grpc.CallOption) (*ConfigAck, error) {
+	out := new(ConfigAck)
+	err := c.cc.Invoke(ctx, MonitorService_UpdateConfig_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *monitorServiceClient) Unregister(ctx context.Context, in *UnregisterRequest, opts ...grpc.CallOption) (*UnregisterResponse, error) {
+	out := new(UnregisterResponse)
+	err := c.cc.Invoke(ctx, MonitorService_Unregister_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *monitorServiceClient) Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*CertificateResponse, error) {
+	out := new(CertificateResponse)
+	err := c.cc.Invoke(ctx, MonitorService_Enroll_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *monitorServiceClient) RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*CertificateResponse, error) {
+	out := new(CertificateResponse)
+	err := c.cc.Invoke(ctx, MonitorService_RenewCertificate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MonitorServiceServer is the server API for MonitorService service.
+// All implementations must embed UnimplementedMonitorServiceServer
+// for forward compatibility
+type MonitorServiceServer interface {
+	// Agent registration
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	// Bidirectional metric streaming
+	StreamMetrics(MonitorService_StreamMetricsServer) error
+	// Heartbeat
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	// Configuration updates
+	UpdateConfig(context.Context, *ConfigUpdate) (*ConfigAck, error)
+	// Clean agent shutdown
+	Unregister(context.Context, *UnregisterRequest) (*UnregisterResponse, error)
+	// Exchange an enrollment token for a client certificate
+	Enroll(context.Context, *EnrollRequest) (*CertificateResponse, error)
+	// Reissue the caller's client certificate before it expires
+	RenewCertificate(context.Context, *RenewCertificateRequest) (*CertificateResponse, error)
+	mustEmbedUnimplementedMonitorServiceServer()
+}
+
+// UnimplementedMonitorServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMonitorServiceServer struct {
+}
+
+func (UnimplementedMonitorServiceServer) Register(context.Context, *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedMonitorServiceServer) StreamMetrics(MonitorService_StreamMetricsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamMetrics not implemented")
+}
+func (UnimplementedMonitorServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedMonitorServiceServer) UpdateConfig(context.Context, *ConfigUpdate) (*ConfigAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateConfig not implemented")
+}
+func (UnimplementedMonitorServiceServer) Unregister(context.Context, *UnregisterRequest) (*UnregisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unregister not implemented")
+}
+func (UnimplementedMonitorServiceServer) Enroll(context.Context, *EnrollRequest) (*CertificateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Enroll not implemented")
+}
+func (UnimplementedMonitorServiceServer) RenewCertificate(context.Context, *RenewCertificateRequest) (*CertificateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewCertificate not implemented")
+}
+func (UnimplementedMonitorServiceServer) mustEmbedUnimplementedMonitorServiceServer() {}
+
+// UnsafeMonitorServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MonitorServiceServer will
+// result in compilation errors.
+type UnsafeMonitorServiceServer interface {
+	mustEmbedUnimplementedMonitorServiceServer()
+}
+
+func RegisterMonitorServiceServer(s grpc.ServiceRegistrar, srv MonitorServiceServer) {
+	s.RegisterService(&MonitorService_ServiceDesc, srv)
+}
+
+func _MonitorService_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MonitorServiceServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MonitorService_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MonitorServiceServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MonitorService_StreamMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MonitorServiceServer).StreamMetrics(&monitorServiceStreamMetricsServer{stream})
+}
+
+type MonitorService_StreamMetricsServer interface {
+	Send(*ControlMessage) error
+	Recv() (*MetricBatch, error)
+	grpc.ServerStream
+}
+
+type monitorServiceStreamMetricsServer struct {
+	grpc.ServerStream
+}
+
+func (x *monitorServiceStreamMetricsServer) Send(m *ControlMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *monitorServiceStreamMetricsServer) Recv() (*MetricBatch, error) {
+	m := new(MetricBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _MonitorService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MonitorServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MonitorService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MonitorServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MonitorService_UpdateConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfigUpdate)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MonitorServiceServer).UpdateConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MonitorService_UpdateConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MonitorServiceServer).UpdateConfig(ctx, req.(*ConfigUpdate))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MonitorService_Unregister_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnregisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MonitorServiceServer).Unregister(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MonitorService_Unregister_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MonitorServiceServer).Unregister(ctx, req.(*UnregisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MonitorService_Enroll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MonitorServiceServer).Enroll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MonitorService_Enroll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MonitorServiceServer).Enroll(ctx, req.(*EnrollRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MonitorService_RenewCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MonitorServiceServer).RenewCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MonitorService_RenewCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MonitorServiceServer).RenewCertificate(ctx, req.(*RenewCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MonitorService_ServiceDesc is the grpc.ServiceDesc for MonitorService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MonitorService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "lnmonja.MonitorService",
+	HandlerType: (*MonitorServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _MonitorService_Register_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _MonitorService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "UpdateConfig",
+			Handler:    _MonitorService_UpdateConfig_Handler,
+		},
+		{
+			MethodName: "Unregister",
+			Handler:    _MonitorService_Unregister_Handler,
+		},
+		{
+			MethodName: "Enroll",
+			Handler:    _MonitorService_Enroll_Handler,
+		},
+		{
+			MethodName: "RenewCertificate",
+			Handler:    _MonitorService_RenewCertificate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamMetrics",
+			Handler:       _MonitorService_StreamMetrics_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "monitor.proto",
+}
@@ -0,0 +1,64 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd
+const listenFDsStart = 3
+
+// Listeners returns the sockets passed by systemd socket activation, keyed
+// by the FileDescriptorName of their socket unit. It returns nil when the
+// process was not socket activated. The LISTEN_* variables are cleared so
+// child processes do not take the sockets too.
+func Listeners() (map[string]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string]net.Listener, count)
+	for i := 0; i < count; i++ {
+		// Unnamed sockets are called "unknown" by systemd
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed to use socket %q passed by systemd: %w", name, err)
+		}
+		if _, ok := listeners[name]; ok {
+			listener.Close()
+			closeListeners(listeners)
+			return nil, fmt.Errorf("systemd passed more than one socket named %q; set FileDescriptorName in each socket unit", name)
+		}
+		listeners[name] = listener
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners map[string]net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
@@ -0,0 +1,105 @@
+// Package systemd integrates the server and agent with systemd: readiness
+// and watchdog notifications (sd_notify) and socket activation. Outside
+// systemd every function is a no-op.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// States sent with Notify
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Status returns a state that sets the status line shown by systemctl
+func Status(status string) string {
+	return "STATUS=" + status
+}
+
+// Notify sends states to the service manager over $NOTIFY_SOCKET. It
+// returns false when the process was not started by systemd with
+// Type=notify or NotifyAccess set.
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A leading @ is an abstract socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	var msg []byte
+	for _, state := range states {
+		msg = append(msg, state...)
+		msg = append(msg, '\n')
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return false, fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the WatchdogSec of the unit, or zero when the
+// watchdog is not enabled for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pings the watchdog at half its interval until ctx is done.
+// A ping is only sent after check passes, so systemd restarts the process
+// when check fails or hangs for longer than the interval.
+func RunWatchdog(ctx context.Context, check func(ctx context.Context) error, logger *zap.Logger) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	logger.Info("systemd watchdog enabled", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, interval/2)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			logger.Warn("Health check failed, not pinging the systemd watchdog", zap.Error(err))
+			continue
+		}
+		if _, err := Notify(Watchdog); err != nil {
+			logger.Warn("Failed to ping the systemd watchdog", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Allowlist is a set of networks allowed to connect to a listener. A nil
+// Allowlist allows every address.
+type Allowlist struct {
+	networks []*net.IPNet
+}
+
+// NewAllowlist parses networks in CIDR notation or as single addresses.
+// It returns nil, allowing everything, when there are none.
+func NewAllowlist(networks []string) (*Allowlist, error) {
+	if len(networks) == 0 {
+		return nil, nil
+	}
+
+	a := &Allowlist{}
+	for _, n := range networks {
+		n = strings.TrimSpace(n)
+		if !strings.Contains(n, "/") {
+			ip := net.ParseIP(n)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", n)
+			}
+			if ip.To4() != nil {
+				n += "/32"
+			} else {
+				n += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", n)
+		}
+		a.networks = append(a.networks, ipNet)
+	}
+	return a, nil
+}
+
+// Allows reports whether ip is in one of the networks
+func (a *Allowlist) Allows(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	for _, n := range a.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAddr reports whether the host of a host:port address, such as a
+// connection's remote address, is allowed. Addresses without an IP, like
+// Unix sockets, are allowed.
+func (a *Allowlist) AllowsAddr(addr string) bool {
+	if a == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+	return a.Allows(ip)
+}
@@ -2,12 +2,55 @@ package utils
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/meettoy2004/lnmonja/pkg/i18n"
 	"gopkg.in/yaml.v3"
 )
 
+// WebSocket listener modes
+const (
+	WebSocketModeHTTP       = "http"
+	WebSocketModeStandalone = "standalone"
+	WebSocketModeBoth       = "both"
+)
+
+// Policies for WebSocket clients whose queue is full
+const (
+	WebSocketPolicyDropOldest = "drop_oldest"
+	WebSocketPolicyDisconnect = "disconnect"
+)
+
+// Agent Kubernetes modes. In auto mode the agent enables pod and node
+// metadata when it finds a service account.
+const (
+	KubernetesModeAuto     = "auto"
+	KubernetesModeEnabled  = "enabled"
+	KubernetesModeDisabled = "disabled"
+)
+
+// Metadata store drivers. Badger keeps metadata in the TSDB.
+const (
+	MetadataDriverSQLite   = "sqlite"
+	MetadataDriverPostgres = "postgres"
+	MetadataDriverBadger   = "badger"
+)
+
+// Storage replication roles. A primary ships its writes to a standby,
+// which applies them until it is promoted, and to read replicas, which
+// serve queries from them.
+const (
+	ReplicationRolePrimary = "primary"
+	ReplicationRoleStandby = "standby"
+	ReplicationRoleReplica = "replica"
+)
+
 type Config struct {
 	Server struct {
 		GRPC struct {
@@ -21,12 +64,43 @@ type Config struct {
 			} `yaml:"tls"`
 			HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
 			HeartbeatTimeout  time.Duration `yaml:"heartbeat_timeout"`
+
+			// Enrollment issues agents client certificates for TLS
+			Enrollment EnrollmentConfig `yaml:"enrollment"`
+
+			// AllowedNetworks are the CIDRs agents may connect from;
+			// empty allows all
+			AllowedNetworks []string `yaml:"allowed_networks"`
+
+			// CollectorDefaults are the collectors agents are told to
+			// run when they register, and pushed to connected agents when
+			// they change
+			CollectorDefaults []CollectorDefaultConfig `yaml:"collector_defaults"`
+
+			// Overload sheds metrics by their priority when more batches
+			// are being processed at once than MaxInflight allows. From
+			// half of it, low priority metrics are sampled at
+			// LowSampleRate; from MaxInflight on they are dropped and
+			// normal priority ones are sampled at NormalSampleRate.
+			// Critical metrics and heartbeats are never shed.
+			Overload struct {
+				Enabled          bool    `yaml:"enabled"`
+				MaxInflight      int     `yaml:"max_inflight"`
+				LowSampleRate    float64 `yaml:"low_sample_rate"`
+				NormalSampleRate float64 `yaml:"normal_sample_rate"`
+			} `yaml:"overload"`
 		} `yaml:"grpc"`
 
 		HTTP struct {
 			Address string `yaml:"address"`
 			Port    int    `yaml:"port"`
-			CORS    struct {
+			// TLS also covers the standalone WebSocket listener
+			TLS struct {
+				Enabled  bool   `yaml:"enabled"`
+				CertFile string `yaml:"cert_file"`
+				KeyFile  string `yaml:"key_file"`
+			} `yaml:"tls"`
+			CORS struct {
 				Enabled        bool     `yaml:"enabled"`
 				AllowedOrigins []string `yaml:"allowed_origins"`
 				AllowedMethods []string `yaml:"allowed_methods"`
@@ -36,60 +110,202 @@ type Config struct {
 				Enabled bool   `yaml:"enabled"`
 				Path    string `yaml:"path"`
 			} `yaml:"static"`
+			// AllowedNetworks are the CIDRs API clients may connect from;
+			// empty allows all
+			AllowedNetworks []string `yaml:"allowed_networks"`
 		} `yaml:"http"`
 
 		WebSocket struct {
+			// Mode is "http" to serve /ws on the HTTP port, "standalone"
+			// for the legacy separate listener, or "both"
+			Mode             string        `yaml:"mode"`
 			Address          string        `yaml:"address"`
 			Port             int           `yaml:"port"`
 			ReadBufferSize   int           `yaml:"read_buffer_size"`
 			WriteBufferSize  int           `yaml:"write_buffer_size"`
 			MaxMessageSize   int64         `yaml:"max_message_size"`
 			PingInterval     time.Duration `yaml:"ping_interval"`
+
+			// Each client has a bounded queue; updates on coalesced
+			// topics replace a queued update for the same node
+			ClientQueueSize  int      `yaml:"client_queue_size"`
+			SlowClientPolicy string   `yaml:"slow_client_policy"`
+			CoalesceTopics   []string `yaml:"coalesce_topics"`
+			// FanOutWorkers queue live updates to clients in parallel,
+			// each taking the updates of some nodes
+			FanOutWorkers int `yaml:"fanout_workers"`
+
+			// AllowedNetworks are the CIDRs WebSocket clients may connect
+			// from, on either port; empty allows all
+			AllowedNetworks []string `yaml:"allowed_networks"`
+
+			// Tokens grant the viewer role on live updates only. With
+			// tokens set, clients must present one or an API key even
+			// when authentication is disabled.
+			Tokens []string `yaml:"tokens"`
+			// AllowedOrigins are the browser origins that may connect, as
+			// glob patterns; empty allows the HTTP CORS origins when CORS
+			// is enabled, otherwise only the server's own origin
+			AllowedOrigins []string `yaml:"allowed_origins"`
+			// MaxConnections caps WebSocket and Server-Sent Events clients;
+			// 0 is unlimited
+			MaxConnections int `yaml:"max_connections"`
+			// ClientRateLimit caps the messages sent to each client a
+			// second, in bursts of up to ClientRateBurst; messages beyond
+			// it are dropped. 0 is unlimited.
+			ClientRateLimit float64 `yaml:"client_rate_limit"`
+			ClientRateBurst int     `yaml:"client_rate_burst"`
 		} `yaml:"websocket"`
+
+		// OTLP receives OpenTelemetry metrics on the gRPC port and at
+		// /v1/metrics on the HTTP port
+		OTLP OTLPConfig `yaml:"otlp"`
+
+		// Ingest limits the metrics accepted from nodes
+		Ingest IngestConfig `yaml:"ingest"`
+
+		Query struct {
+			// Series longer than this are downsampled in query responses
+			MaxPointsPerSeries int    `yaml:"max_points_per_series"`
+			DownsampleMethod   string `yaml:"downsample_method"`
+		} `yaml:"query"`
 	} `yaml:"server"`
 
 	Storage StorageConfig `yaml:"storage"`
 
 	Alerting struct {
-		Enabled            bool          `yaml:"enabled"`
-		RulesPath          string        `yaml:"rules_path"`
+		Enabled bool `yaml:"enabled"`
+		// EvaluationInterval schedules rule evaluation against the latest
+		// sample of each series, spreading the rules over the interval;
+		// 0 evaluates them as metrics arrive. Rule groups may set their
+		// own interval.
 		EvaluationInterval time.Duration `yaml:"evaluation_interval"`
-		DefaultCooldown    time.Duration `yaml:"default_cooldown"`
-		Notification       struct {
+		// EvaluationJitter delays each scheduled evaluation by up to this
+		// much at random
+		EvaluationJitter time.Duration `yaml:"evaluation_jitter"`
+		// GroupConcurrency bounds the rules of a group evaluated at once
+		GroupConcurrency int           `yaml:"group_concurrency"`
+		DefaultCooldown  time.Duration `yaml:"default_cooldown"`
+		// RulesPath is a rule file, a directory of them or a glob. The
+		// files are reloaded on SIGHUP and when they change.
+		RulesPath           string        `yaml:"rules_path"`
+		RulesReloadInterval time.Duration `yaml:"rules_reload_interval"`
+		// SilenceRetention is how long expired silences are kept before
+		// they are deleted
+		SilenceRetention time.Duration `yaml:"silence_retention"`
+		// Notification is the single receiver used when no receivers are
+		// configured
+		Notification struct {
 			Slack struct {
-				Enabled    bool   `yaml:"enabled"`
-				WebhookURL string `yaml:"webhook_url"`
-				Channel    string `yaml:"channel"`
+				Enabled     bool `yaml:"enabled"`
+				SlackConfig `yaml:",inline"`
 			} `yaml:"slack"`
 			Email struct {
-				Enabled  bool     `yaml:"enabled"`
-				SMTPHost string   `yaml:"smtp_host"`
-				SMTPPort int      `yaml:"smtp_port"`
-				Username string   `yaml:"username"`
-				Password string   `yaml:"password"`
-				From     string   `yaml:"from"`
-				To       []string `yaml:"to"`
+				Enabled     bool `yaml:"enabled"`
+				EmailConfig `yaml:",inline"`
 			} `yaml:"email"`
 		} `yaml:"notification"`
+		// Route and Receivers configure Alertmanager-style routing of
+		// notifications to teams and channels by alert labels
+		Route     RouteConfig      `yaml:"route"`
+		Receivers []ReceiverConfig `yaml:"receivers"`
 	} `yaml:"alerting"`
 
-	Authentication struct {
-		Enabled    bool     `yaml:"enabled"`
-		JWTSecret  string   `yaml:"jwt_secret"`
-		TokenExpiry time.Duration `yaml:"token_expiry"`
-		APIKeys    []string `yaml:"api_keys"`
-		Users      []User   `yaml:"users"`
-	} `yaml:"authentication"`
+	Authentication AuthenticationConfig `yaml:"authentication"`
 
 	Logging LogConfig `yaml:"logging"`
 
+	// Scheduled exports of query results
+	Exports []ExportConfig `yaml:"exports"`
+
+	// Node decommissioning and lifecycle webhooks
+	Lifecycle LifecycleConfig `yaml:"lifecycle"`
+
+	// Alert rules, dashboards and agent configs from custom resources
+	Kubernetes KubernetesConfig `yaml:"kubernetes"`
+
+	// TLS versions, cipher suites and curves of the server's listeners
+	// and the agent's connection to it
+	TLS TLSPolicyConfig `yaml:"tls"`
+
+	// Metric naming conventions checked by the server as metrics are
+	// received and by the agent as they are collected
+	Naming NamingConfig `yaml:"naming"`
+
+	// Memory tunes the Go runtime to the memory the server or agent may
+	// use in its container
+	Memory MemoryConfig `yaml:"memory"`
+
+	// Locales of alert notifications and the dashboard
+	I18n I18nConfig `yaml:"i18n"`
+
+	// Demo simulates nodes, for evaluating the server without agents
+	Demo DemoConfig `yaml:"demo"`
+
+	// Metering records usage per tenant per day, for chargeback
+	Metering MeteringConfig `yaml:"metering"`
+
+	// Trends keeps monthly aggregates per node for long-term reports
+	Trends TrendsConfig `yaml:"trends"`
+
 	// Agent-specific config
 	Agent struct {
 		NodeID         string        `yaml:"node_id"`
 		ServerAddress  string        `yaml:"server_address"`
+		// APIKey authenticates the agent to the server; its user needs the
+		// editor role
+		APIKey         string        `yaml:"api_key"`
+		// TLS secures the connection to the server's gRPC port
+		TLS            AgentTLSConfig `yaml:"tls"`
 		BatchSize      int           `yaml:"batch_size"`
 		MaxBatchWait   time.Duration `yaml:"max_batch_wait"`
 		HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+		// Ephemeral marks autoscaled nodes; InstanceGroup is added to every
+		// metric so series can be aggregated after instances are gone
+		Ephemeral     bool   `yaml:"ephemeral"`
+		InstanceGroup string `yaml:"instance_group"`
+		// LocalCollectors keeps the collector schedule in this file,
+		// ignoring the one the server sends at registration and rejecting
+		// changes it pushes later
+		LocalCollectors bool `yaml:"local_collectors"`
+		// Priorities tag metrics critical, normal or low so an overloaded
+		// server sheds low priority ones first. Metrics take the priority
+		// of their collector, or of the first Metrics entry matching
+		// their name; they are normal otherwise.
+		Priorities struct {
+			Collectors map[string]string      `yaml:"collectors"`
+			Metrics    []MetricPriorityConfig `yaml:"metrics"`
+		} `yaml:"priorities"`
+		// Buffer queues batches on disk while the server is unreachable
+		// and replays them in order once it is back
+		Buffer struct {
+			Enabled bool          `yaml:"enabled"`
+			Path    string        `yaml:"path"`
+			MaxSize int64         `yaml:"max_size"` // bytes
+			MaxAge  time.Duration `yaml:"max_age"`
+		} `yaml:"buffer"`
+		// Telemetry serves the agent's own health at /health and its
+		// metrics at /metrics
+		Telemetry struct {
+			Enabled bool   `yaml:"enabled"`
+			Address string `yaml:"address"`
+		} `yaml:"telemetry"`
+		// Kubernetes labels metrics with cluster, node and zone and
+		// attributes process and cgroup metrics to pods when the agent
+		// runs as a DaemonSet
+		Kubernetes struct {
+			Mode    string `yaml:"mode"`
+			Cluster string `yaml:"cluster"`
+			// NodeName and Zone default to $NODE_NAME and the node's
+			// topology labels
+			NodeName string `yaml:"node_name"`
+			Zone     string `yaml:"zone"`
+			// KubeletURL defaults to https://$NODE_IP:10250, or the node
+			// name if NODE_IP is not set
+			KubeletURL                string        `yaml:"kubelet_url"`
+			KubeletInsecureSkipVerify bool          `yaml:"kubelet_insecure_skip_verify"`
+			PodRefreshInterval        time.Duration `yaml:"pod_refresh_interval"`
+		} `yaml:"kubernetes"`
 	} `yaml:"agent"`
 
 	// Collectors config
@@ -98,13 +314,22 @@ type Config struct {
 			Enabled  bool          `yaml:"enabled"`
 			Interval time.Duration `yaml:"interval"`
 			Metrics  struct {
-				CPU     bool `yaml:"cpu"`
-				Memory  bool `yaml:"memory"`
-				Load    bool `yaml:"load"`
-				Disk    bool `yaml:"disk"`
-				Network bool `yaml:"network"`
-				Uptime  bool `yaml:"uptime"`
+				CPU       bool `yaml:"cpu"`
+				Memory    bool `yaml:"memory"`
+				Load      bool `yaml:"load"`
+				Disk      bool `yaml:"disk"`
+				Network   bool `yaml:"network"`
+				Uptime    bool `yaml:"uptime"`
+				Pressure  bool `yaml:"pressure"`
+				Cgroup    bool `yaml:"cgroup"`
+				NUMA      bool `yaml:"numa"`
+				Hugepages bool `yaml:"hugepages"`
+				Sockets   bool `yaml:"sockets"`
 			} `yaml:"metrics"`
+			Cgroup struct {
+				Root  string `yaml:"root"`
+				Depth int    `yaml:"depth"`
+			} `yaml:"cgroup"`
 		} `yaml:"system"`
 
 		Process struct {
@@ -114,23 +339,482 @@ type Config struct {
 		} `yaml:"process"`
 
 		Container struct {
-			Enabled      bool   `yaml:"enabled"`
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+			// Runtime is auto, docker, podman, cri, containerd, cgroupfs or
+			// hcs for Windows containers
 			Runtime      string `yaml:"runtime"`
 			DockerSocket string `yaml:"docker_socket"`
+			// PodmanSocket defaults to the rootful socket and the rootless
+			// sockets of all users
+			PodmanSocket string `yaml:"podman_socket"`
+			// CRISocket is a CRI runtime such as CRI-O
+			CRISocket string `yaml:"cri_socket"`
+			// ContainerdSocket locates containerd; its task state is read
+			// from the socket's directory
+			ContainerdSocket string `yaml:"containerd_socket"`
+			// DockerRoot and ContainersStorage hold container metadata for
+			// the cgroupfs runtime
+			DockerRoot        string `yaml:"docker_root"`
+			ContainersStorage string `yaml:"containers_storage"`
+			// Labels selects container labels, by glob, that are added to
+			// metrics as container_label_<name>
+			Labels struct {
+				Include []string `yaml:"include"`
+				Exclude []string `yaml:"exclude"`
+			} `yaml:"labels"`
 		} `yaml:"container"`
 
+		Packages struct {
+			Enabled         bool          `yaml:"enabled"`
+			Interval        time.Duration `yaml:"interval"`
+			Manager         string        `yaml:"manager"`
+			IncludePackages bool          `yaml:"include_packages"`
+		} `yaml:"packages"`
+
+		Kernel struct {
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+		} `yaml:"kernel"`
+
+		Limits struct {
+			Enabled      bool          `yaml:"enabled"`
+			Interval     time.Duration `yaml:"interval"`
+			TopProcesses int           `yaml:"top_processes"`
+		} `yaml:"limits"`
+
+		WindowsServices struct {
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+			Services []string      `yaml:"services"`
+		} `yaml:"windows_services"`
+
+		WindowsEventLog struct {
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+			Channels []struct {
+				Name      string   `yaml:"name"`
+				Providers []string `yaml:"providers"`
+				Levels    []string `yaml:"levels"`
+			} `yaml:"channels"`
+		} `yaml:"windows_eventlog"`
+
+		HyperV struct {
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+		} `yaml:"hyperv"`
+
+		JMX struct {
+			Enabled  bool           `yaml:"enabled"`
+			Interval time.Duration  `yaml:"interval"`
+			Apps     []JMXAppConfig `yaml:"apps"`
+		} `yaml:"jmx"`
+
+		PHPFPM struct {
+			Enabled  bool               `yaml:"enabled"`
+			Interval time.Duration      `yaml:"interval"`
+			Pools    []PHPFPMPoolConfig `yaml:"pools"`
+		} `yaml:"php_fpm"`
+
+		UWSGI struct {
+			Enabled  bool                `yaml:"enabled"`
+			Interval time.Duration       `yaml:"interval"`
+			Servers  []UWSGIServerConfig `yaml:"servers"`
+		} `yaml:"uwsgi"`
+
+		Gunicorn struct {
+			Enabled  bool                   `yaml:"enabled"`
+			Interval time.Duration          `yaml:"interval"`
+			Servers  []GunicornServerConfig `yaml:"servers"`
+		} `yaml:"gunicorn"`
+
+		HAProxy struct {
+			Enabled   bool                    `yaml:"enabled"`
+			Interval  time.Duration           `yaml:"interval"`
+			Instances []HAProxyInstanceConfig `yaml:"instances"`
+		} `yaml:"haproxy"`
+
+		Envoy struct {
+			Enabled   bool                  `yaml:"enabled"`
+			Interval  time.Duration         `yaml:"interval"`
+			Instances []EnvoyInstanceConfig `yaml:"instances"`
+		} `yaml:"envoy"`
+
+		RabbitMQ struct {
+			Enabled  bool                   `yaml:"enabled"`
+			Interval time.Duration          `yaml:"interval"`
+			Servers  []RabbitMQServerConfig `yaml:"servers"`
+		} `yaml:"rabbitmq"`
+
+		Elasticsearch struct {
+			Enabled  bool                         `yaml:"enabled"`
+			Interval time.Duration                `yaml:"interval"`
+			Clusters []ElasticsearchClusterConfig `yaml:"clusters"`
+		} `yaml:"elasticsearch"`
+
+		// Ceph runs the ceph command, so enable it on a monitor or admin
+		// node with a keyring that can read cluster status
+		Ceph struct {
+			Enabled    bool          `yaml:"enabled"`
+			Interval   time.Duration `yaml:"interval"`
+			Cluster    string        `yaml:"cluster"`
+			ConfigFile string        `yaml:"config_file"`
+			User       string        `yaml:"user"`
+			Timeout    time.Duration `yaml:"timeout"`
+		} `yaml:"ceph"`
+
+		// Sockets reports TCP connections by state, listening sockets, UDP
+		// errors and conntrack usage from procfs, on Linux
+		Sockets struct {
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+			// Processes counts the TCP connections of the TopProcesses
+			// processes holding the most; it reads every process's file
+			// descriptors, which needs root
+			Processes    bool `yaml:"processes"`
+			TopProcesses int  `yaml:"top_processes"`
+			// ProcPath is the host's procfs when the agent runs in a
+			// container, /proc by default
+			ProcPath string `yaml:"proc_path"`
+		} `yaml:"sockets"`
+
+		// Systemd reports the state and restarts of systemd units and the
+		// errors logged to the journal, through systemctl and journalctl
+		Systemd struct {
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+			// Units are unit names or globs, e.g. nginx.service or
+			// "postgresql@*"; the number of failed units is reported
+			// either way
+			Units []string `yaml:"units"`
+			// CrashLoopWindow is how far back restarts are counted in
+			// systemd_unit_recent_restarts
+			CrashLoopWindow time.Duration `yaml:"crash_loop_window"`
+			Timeout         time.Duration `yaml:"timeout"`
+			// Journal counts the messages logged at Priority or above,
+			// err by default
+			Journal struct {
+				Enabled  bool   `yaml:"enabled"`
+				Priority string `yaml:"priority"`
+			} `yaml:"journal"`
+		} `yaml:"systemd"`
+
+		// Kubernetes reports pod usage and phases, node conditions and
+		// deployment availability through the Kubernetes API, with the
+		// pod's service account or a kubeconfig
+		Kubernetes struct {
+			Enabled    bool          `yaml:"enabled"`
+			Interval   time.Duration `yaml:"interval"`
+			Kubeconfig string        `yaml:"kubeconfig"`
+			Context    string        `yaml:"context"`
+			// Scope is node, for an agent in a DaemonSet reporting its own
+			// node and pods, or cluster, for a single agent reporting every
+			// node and pod and the deployments
+			Scope    string `yaml:"scope"`
+			NodeName string `yaml:"node_name"`
+			// Namespaces limits the pods and deployments reported
+			Namespaces []string `yaml:"namespaces"`
+			// UsageSource is kubelet, reading each node's summary API
+			// through the API server, or metrics-server
+			UsageSource string        `yaml:"usage_source"`
+			Timeout     time.Duration `yaml:"timeout"`
+		} `yaml:"kubernetes"`
+
+		// EBPF measures TCP connect latency, retransmissions and drops
+		// per destination, and process execs and file I/O, with eBPF
+		// programs, on Linux with CAP_BPF and CAP_PERFMON or CAP_SYS_ADMIN
+		EBPF struct {
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+			// MaxDestinations bounds the destinations tracked; the least
+			// recently updated are evicted
+			MaxDestinations int `yaml:"max_destinations"`
+			Programs        struct {
+				TCPConnect    bool `yaml:"tcp_connect"`
+				TCPRetransmit bool `yaml:"tcp_retransmit"`
+				// TCPDrop needs the kernel's BTF, and is skipped without it
+				TCPDrop bool `yaml:"tcp_drop"`
+				// Exec counts execs by command name, including processes
+				// too short-lived for the process collector to sample
+				Exec bool `yaml:"exec"`
+				// FileIO counts bytes read and written by command name
+				FileIO bool `yaml:"file_io"`
+			} `yaml:"programs"`
+			Processes struct {
+				// Comms are glob patterns of the command names reported by
+				// the exec and file I/O programs; empty reports all
+				Comms []string `yaml:"comms"`
+				// Paths are glob patterns, or directory prefixes ending in
+				// /, of the executables whose execs are reported
+				Paths        []string `yaml:"paths"`
+				MaxProcesses int      `yaml:"max_processes"`
+				// ProcPath is the host's procfs when the agent runs in a
+				// container
+				ProcPath string `yaml:"proc_path"`
+				// Events streams each reported exec as a JSON line at
+				// /events/exec on the telemetry endpoint
+				Events bool `yaml:"events"`
+			} `yaml:"processes"`
+		} `yaml:"ebpf"`
+
+		ZFS struct {
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+			Pools    []string      `yaml:"pools"`
+		} `yaml:"zfs"`
+
+		// Custom runs scripts and reports the metrics they print, in the
+		// Prometheus text format or InfluxDB line protocol
 		Custom struct {
-			Enabled bool   `yaml:"enabled"`
-			Path    string `yaml:"path"`
+			Enabled  bool          `yaml:"enabled"`
+			Interval time.Duration `yaml:"interval"`
+			// Path is a directory whose executable files are each run as
+			// a script, named after the file
+			Path string `yaml:"path"`
+			// Scripts are run in addition to those under Path
+			Scripts     []CustomScriptConfig `yaml:"scripts"`
+			Timeout     time.Duration        `yaml:"timeout"`
+			MaxParallel int                  `yaml:"max_parallel"`
+			// MaxOutput bounds the bytes read from a script; MaxMemory
+			// and MaxCPUTime limit its address space and CPU time, on
+			// Linux only
+			MaxOutput  int64         `yaml:"max_output"`
+			MaxMemory  int64         `yaml:"max_memory"`
+			MaxCPUTime time.Duration `yaml:"max_cpu_time"`
 		} `yaml:"custom"`
+
+		// Scrape reads applications instrumented for Prometheus from the
+		// /metrics endpoints of its targets
+		Scrape struct {
+			Enabled  bool                 `yaml:"enabled"`
+			Interval time.Duration        `yaml:"interval"`
+			Targets  []ScrapeTargetConfig `yaml:"targets"`
+		} `yaml:"scrape"`
 	} `yaml:"collectors"`
 
 	Version string `yaml:"-"`
+	// File is the file the config was loaded from
+	File string `yaml:"-"`
+}
+
+// AgentTLSConfig is how the agent verifies the server and, when the
+// server requires client certificates, which certificate it presents.
+// The certificate, key and CA files are read again after they change, so
+// rotated certificates are used on the next handshake.
+type AgentTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CAFile verifies the server certificate; empty uses the system roots
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the name checked against the server
+	// certificate, which defaults to the host of server_address
+	ServerName string `yaml:"server_name"`
+	// InsecureSkipVerify disables server verification; for development only
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+	// EnrollmentToken gets a certificate from the server's built-in CA
+	// when cert_file does not exist or has expired, and renews it before
+	// it expires
+	EnrollmentToken string `yaml:"enrollment_token"`
+}
+
+// EnrollmentConfig runs a CA in the server that issues agents short-lived
+// client certificates, so mTLS needs no external PKI. An agent enrolls
+// once with a token and then renews with its current certificate.
+type EnrollmentConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CADir holds ca.crt and ca.key, which are created on first start
+	CADir  string   `yaml:"ca_dir"`
+	Tokens []string `yaml:"tokens"`
+	// CertValidity is how long an issued certificate is valid
+	CertValidity time.Duration `yaml:"cert_validity"`
+}
+
+// TLSPolicyConfig restricts the TLS parameters that are negotiated.
+// Empty cipher suites and curves use Go's defaults. Strict mode refuses
+// to start with TLS disabled, unverified servers, or anything but TLS 1.2
+// or later with FIPS-approved cipher suites and curves, which it defaults
+// to.
+type TLSPolicyConfig struct {
+	// MinVersion is "1.0", "1.1", "1.2" or "1.3"
+	MinVersion string `yaml:"min_version"`
+	// CipherSuites are Go cipher suite names, such as
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. They apply to TLS 1.2 and
+	// earlier; TLS 1.3 suites are not configurable.
+	CipherSuites []string `yaml:"cipher_suites"`
+	// CurvePreferences are X25519, P-256, P-384 and P-521, in order
+	CurvePreferences []string `yaml:"curve_preferences"`
+	Strict           bool     `yaml:"strict"`
+}
+
+// OTLPConfig configures the OpenTelemetry metrics receiver
+type OTLPConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// NodeAttributes are the resource attributes tried in turn for the
+	// node a resource's metrics are stored under
+	NodeAttributes []string `yaml:"node_attributes"`
+	// MaxRequestSize limits the decoded size of an OTLP/HTTP request
+	MaxRequestSize int64 `yaml:"max_request_size"`
+}
+
+// DemoConfig configures the simulated nodes of demo mode. They report
+// system metrics with daily cycles and noise, and now and then a CPU
+// spike, memory leak, disk filling up or traffic burst that fires the
+// default alert rules.
+type DemoConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Nodes    int           `yaml:"nodes"`
+	Interval time.Duration `yaml:"interval"`
+	// Backfill is the history generated at startup, so charts have data
+	// straight away
+	Backfill time.Duration `yaml:"backfill"`
+	// AnomaliesPerHour is how often each node starts an anomaly on
+	// average; 0 uses the default, a negative value disables them
+	AnomaliesPerHour float64 `yaml:"anomalies_per_hour"`
+	// Seed makes the simulation repeatable; 0 seeds it from the clock
+	Seed int64 `yaml:"seed"`
+}
+
+// MeteringConfig records each tenant's ingested samples, stored bytes and
+// queried samples per UTC day. A series belongs to the tenant named by its
+// TenantLabel, which is usually set on the tenant's nodes as a server-side
+// node label.
+type MeteringConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	TenantLabel string `yaml:"tenant_label"`
+	// DefaultTenant is charged for series without the tenant label
+	DefaultTenant string `yaml:"default_tenant"`
+	// FlushInterval is how often counters are saved to the metadata store
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// StorageInterval is how often stored bytes are measured, which reads
+	// the index of every stored series
+	StorageInterval time.Duration `yaml:"storage_interval"`
+	// Retention is how long daily records are kept
+	Retention time.Duration `yaml:"retention"`
+}
+
+// TrendsConfig keeps each node's CPU usage, disk growth and alert count per
+// UTC month, accumulated as metrics are received, so month-over-month
+// reports reach back further than the retention of the samples
+type TrendsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CPUMetric is a node's CPU usage in percent, and DiskMetric the used
+	// bytes of each of its filesystems, which are summed
+	CPUMetric  string `yaml:"cpu_metric"`
+	DiskMetric string `yaml:"disk_metric"`
+	// FlushInterval is how often aggregates are saved to the metadata store
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// RetentionMonths is how many months of aggregates are kept
+	RetentionMonths int `yaml:"retention_months"`
+	// Reports are emailed on a schedule
+	Reports []TrendReportConfig `yaml:"reports"`
+}
+
+// TrendReportConfig emails a trend report on a cron schedule, in UTC
+type TrendReportConfig struct {
+	Name     string `yaml:"name"`
+	Schedule string `yaml:"schedule"`
+	// Months is how many complete months the report covers, ending with
+	// the month before it is sent
+	Months int `yaml:"months"`
+	// GroupBy is "node" or a node label, such as team
+	GroupBy string `yaml:"group_by"`
+	// Email's Subject is a Go template over the report
+	Email EmailConfig `yaml:"email"`
+}
+
+// IngestConfig limits the metrics the server accepts
+type IngestConfig struct {
+	// MetricFilters are tried in order; the first that selects a node
+	// applies to its metrics. Nodes no filter selects may send any metric.
+	MetricFilters []MetricFilterConfig `yaml:"metric_filters"`
+}
+
+// MetricFilterConfig restricts the metric names a set of nodes may send
+type MetricFilterConfig struct {
+	Name string `yaml:"name"`
+	// Nodes are node ID glob patterns; empty selects every node
+	Nodes []string `yaml:"nodes"`
+	// NodeLabels select nodes, such as a tenant's, by their labels
+	NodeLabels map[string]string `yaml:"node_labels"`
+	// Allow and Deny are regular expressions matching whole metric names.
+	// When Allow is set only matching names are accepted; Deny wins.
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// CollectorDefaultConfig is a collector the server tells registering
+// agents to run, every Interval with Params
+type CollectorDefaultConfig struct {
+	Name     string            `yaml:"name"`
+	Enabled  bool              `yaml:"enabled"`
+	Interval time.Duration     `yaml:"interval"`
+	Params   map[string]string `yaml:"params"`
+}
+
+// MetricPriorityConfig gives the metrics whose name matches the Match glob
+// a priority
+type MetricPriorityConfig struct {
+	Match    string `yaml:"match"`
+	Priority string `yaml:"priority"`
+}
+
+// MemoryConfig sets the soft memory limit the Go runtime collects garbage
+// to stay under. Limit is a size like "2GB"; without it, $GOMEMLIMIT is
+// kept, or else LimitRatio of the cgroup memory limit is used, leaving
+// the rest for memory the runtime does not manage. The server also sizes
+// its storage memtables and caches to the limit.
+type MemoryConfig struct {
+	Limit      string  `yaml:"limit"`
+	LimitRatio float64 `yaml:"limit_ratio"`
+	// GCPercent sets GOGC unless $GOGC is set; 0 keeps the default and -1
+	// leaves collection to the memory limit
+	GCPercent int `yaml:"gc_percent"`
+	// Ballast is a size allocated and never used, so small heaps are
+	// collected less often. It counts toward the limit.
+	Ballast string `yaml:"ballast"`
+}
+
+// I18nConfig sets the locale notifications and the dashboard use unless
+// a receiver or browser asks for another. LocalesPath is a directory of
+// locale files, e.g. de.yaml, adding to the built-in English messages.
+type I18nConfig struct {
+	DefaultLocale string `yaml:"default_locale"`
+	LocalesPath   string `yaml:"locales_path"`
+}
+
+// NamingConfig configures the metric naming convention checks. Names
+// that break a convention are still accepted, and reported.
+type NamingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// NamePattern and LabelPattern are regular expressions matching
+	// whole metric names and label keys
+	NamePattern  string `yaml:"name_pattern"`
+	LabelPattern string `yaml:"label_pattern"`
+	// CounterSuffix ends every counter name
+	CounterSuffix string `yaml:"counter_suffix"`
+	// UnitSuffixes maps a unit to the suffix of names with that unit,
+	// before the counter suffix
+	UnitSuffixes map[string]string `yaml:"unit_suffixes"`
+}
+
+// AuthenticationConfig configures the API keys and users that may call
+// the REST API, WebSocket and gRPC services
+type AuthenticationConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	JWTSecret   string        `yaml:"jwt_secret"`
+	TokenExpiry time.Duration `yaml:"token_expiry"`
+	// APIKeys are shared keys with the admin role; prefer per-user keys
+	APIKeys []string `yaml:"api_keys"`
+	Users   []User   `yaml:"users"`
 }
 
+// User is an API user. Role is viewer (the default), editor or admin. A
+// user authenticates with its API key or with basic auth.
 type User struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	APIKey   string `yaml:"api_key"`
 	Role     string `yaml:"role"`
 	Email    string `yaml:"email"`
 }
@@ -145,13 +829,452 @@ type StorageConfig struct {
 	SyncWrites       bool          `yaml:"sync_writes"`
 	ValueLogFileSize int64         `yaml:"value_log_file_size"`
 	MemTableSize     int64         `yaml:"mem_table_size"`
-	Tiering          struct {
+	Usage            struct {
+		Interval   time.Duration `yaml:"interval"`
+		SampleRate int           `yaml:"sample_rate"`
+	} `yaml:"usage"`
+	// Tiering archives shards that end more than WarmRetention ago to the
+	// cold tier, an S3-compatible bucket if S3.Bucket is set and ColdPath
+	// otherwise, and deletes them from it after ColdRetention. Queries
+	// restore the archived blocks they need into a local cache.
+	Tiering struct {
 		Enabled       bool          `yaml:"enabled"`
 		HotRetention  time.Duration `yaml:"hot_retention"`
 		WarmRetention time.Duration `yaml:"warm_retention"`
 		ColdRetention time.Duration `yaml:"cold_retention"`
 		ColdPath      string        `yaml:"cold_path"`
+		S3            S3Config      `yaml:"s3"`
+		// CacheBlocks is how many restored blocks are kept on disk
+		CacheBlocks int `yaml:"cache_blocks"`
 	} `yaml:"tiering"`
+	// Chunks configures compression. With Compression set, each series'
+	// samples are sealed into Gorilla-encoded chunks of Duration, Delay
+	// after the chunk ends.
+	Chunks struct {
+		Duration time.Duration `yaml:"duration"`
+		Delay    time.Duration `yaml:"delay"`
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"chunks"`
+	// Shards partition samples by time. Each block of BlockDuration is a
+	// separate Badger database under <path>/shards, so retention drops
+	// whole blocks and queries only open the blocks they overlap. At most
+	// MaxOpen shards are open at once; ShardSize caps their value log files.
+	Shards struct {
+		BlockDuration time.Duration `yaml:"block_duration"`
+		MaxOpen       int           `yaml:"max_open"`
+	} `yaml:"shards"`
+	// Rollups pre-aggregate each series into buckets of fixed resolutions
+	// so long-range queries read far fewer samples. Each resolution is
+	// computed from the one before it, and Delay is how long after a
+	// bucket ends it is computed, leaving time for late samples.
+	Rollups struct {
+		Enabled     bool               `yaml:"enabled"`
+		Interval    time.Duration      `yaml:"interval"`
+		Delay       time.Duration      `yaml:"delay"`
+		Resolutions []RollupResolution `yaml:"resolutions"`
+	} `yaml:"rollups"`
+	// Metadata stores nodes, alerts, silences, annotations, derived
+	// metrics and ingest rules outside the TSDB
+	Metadata MetadataConfig `yaml:"metadata"`
+	// StaleSeries tombstones the index entries of series with no sample
+	// for IdleTimeout, checked every Interval. Their samples stay
+	// queryable; queries starting after a series' last sample skip it.
+	StaleSeries struct {
+		Enabled     bool          `yaml:"enabled"`
+		IdleTimeout time.Duration `yaml:"idle_timeout"`
+		Interval    time.Duration `yaml:"interval"`
+	} `yaml:"stale_series"`
+	// Replication keeps a warm standby of the storage path. See
+	// ReplicationConfig.
+	Replication ReplicationConfig `yaml:"replication"`
+	// Migration writes samples to a second store at Path as well, in
+	// compressed chunks, copies the samples written before into it a
+	// BackfillWindow at a time, and compares the results of a
+	// CompareRatio share of raw queries. Once they match, the server can
+	// be restarted on Path with compression enabled.
+	Migration struct {
+		Enabled        bool          `yaml:"enabled"`
+		Path           string        `yaml:"path"`
+		CompareRatio   float64       `yaml:"compare_ratio"`
+		BackfillWindow time.Duration `yaml:"backfill_window"`
+	} `yaml:"migration"`
+	// SymbolTableSize caps how many distinct label names and values are
+	// interned, shared by every label map holding them; -1 disables it
+	SymbolTableSize int `yaml:"symbol_table_size"`
+}
+
+// ReplicationConfig configures warm standby replication. A primary ships
+// the writes to its databases to StandbyURL every Interval, and a snapshot
+// of sqlite metadata every MetadataInterval. A standby applies them on
+// Listen until it is promoted, then starts as a server. Both authenticate
+// with Token; CertFile and KeyFile serve the standby over TLS, and CAFile
+// verifies it on the primary.
+//
+// A primary also ships to each of ReplicaURLs. A replica applies them on
+// Listen like a standby, while serving the API read-only from the same
+// storage path.
+type ReplicationConfig struct {
+	Role             string        `yaml:"role"`
+	StandbyURL       string        `yaml:"standby_url"`
+	ReplicaURLs      []string      `yaml:"replica_urls"`
+	Listen           string        `yaml:"listen"`
+	Token            string        `yaml:"token"`
+	Interval         time.Duration `yaml:"interval"`
+	MetadataInterval time.Duration `yaml:"metadata_interval"`
+	CertFile         string        `yaml:"cert_file"`
+	KeyFile          string        `yaml:"key_file"`
+	CAFile           string        `yaml:"ca_file"`
+}
+
+// MetadataConfig selects the metadata store. Driver is sqlite, postgres or
+// badger; empty uses sqlite, or badger in builds without cgo. DSN defaults
+// to metadata.db under the storage path for sqlite and is required for
+// postgres.
+type MetadataConfig struct {
+	Driver string `yaml:"driver"`
+	DSN    string `yaml:"dsn"`
+}
+
+// RollupResolution is a rollup bucket size and how long its buckets are
+// kept. Retention defaults to the raw retention period.
+type RollupResolution struct {
+	Resolution time.Duration `yaml:"resolution"`
+	Retention  time.Duration `yaml:"retention"`
+}
+
+// ExportConfig describes a saved query exported on a schedule
+type ExportConfig struct {
+	Name        string        `yaml:"name"`
+	Query       string        `yaml:"query"`
+	Schedule    string        `yaml:"schedule"`
+	Range       time.Duration `yaml:"range"`
+	Step        time.Duration `yaml:"step"`
+	// Format is csv, appended to <path>/<name>.csv, or parquet, written
+	// to a new <path>/<name>/<name>-<time>.parquet each run. Each run
+	// uploads a new object to S3 in either format.
+	Format      string `yaml:"format"`
+	Destination struct {
+		Path string   `yaml:"path"`
+		S3   S3Config `yaml:"s3"`
+	} `yaml:"destination"`
+}
+
+// Export formats
+const (
+	ExportFormatCSV     = "csv"
+	ExportFormatParquet = "parquet"
+)
+
+// JMXAppConfig is a Java application the JMX collector reads through
+// Jolokia. URL is the app's Jolokia agent, or a Jolokia proxy when Target
+// is set to a JMX service URL such as
+// service:jmx:rmi:///jndi/rmi://host:9010/jmxrmi.
+type JMXAppConfig struct {
+	Name               string            `yaml:"name"`
+	URL                string            `yaml:"url"`
+	Username           string            `yaml:"username"`
+	Password           string            `yaml:"password"`
+	Target             string            `yaml:"target"`
+	TargetUsername     string            `yaml:"target_username"`
+	TargetPassword     string            `yaml:"target_password"`
+	Timeout            time.Duration     `yaml:"timeout"`
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify"`
+	Labels             map[string]string `yaml:"labels"`
+	DisableJVMMetrics  bool              `yaml:"disable_jvm_metrics"`
+	Beans              []JMXBeanConfig   `yaml:"beans"`
+}
+
+// JMXBeanConfig maps MBean attributes to metrics. MBean may be an object
+// name pattern such as kafka.server:type=BrokerTopicMetrics,name=*.
+type JMXBeanConfig struct {
+	MBean      string   `yaml:"mbean"`
+	Attributes []string `yaml:"attributes"`
+	Metric     string   `yaml:"metric"`
+	// Type is gauge or counter
+	Type string `yaml:"type"`
+}
+
+// PHPFPMPoolConfig is a php-fpm pool. Address is the URL of its status
+// page, or its FastCGI socket (unix:///path or tcp://host:port), through
+// which StatusPath, the pool's pm.status_path, is requested.
+type PHPFPMPoolConfig struct {
+	Name       string        `yaml:"name"`
+	Address    string        `yaml:"address"`
+	StatusPath string        `yaml:"status_path"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// CustomScriptConfig is a script the custom collector runs. Name defaults
+// to the command's file name; Format is prometheus or influx, or detected
+// from the output if empty. Interval and Timeout default to the
+// collector's.
+type CustomScriptConfig struct {
+	Name     string            `yaml:"name"`
+	Command  string            `yaml:"command"`
+	Args     []string          `yaml:"args"`
+	Format   string            `yaml:"format"`
+	Interval time.Duration     `yaml:"interval"`
+	Timeout  time.Duration     `yaml:"timeout"`
+	Env      map[string]string `yaml:"env"`
+	Labels   map[string]string `yaml:"labels"`
+}
+
+// UWSGIServerConfig is a uWSGI instance. Address is its stats socket, or
+// the URL of the stats server with --stats-http.
+type UWSGIServerConfig struct {
+	Name    string        `yaml:"name"`
+	Address string        `yaml:"address"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// GunicornServerConfig is a Gunicorn instance, read from its master
+// process and, with --statsd-host pointed at StatsdAddress, the statsd
+// metrics it sends
+type GunicornServerConfig struct {
+	Name          string `yaml:"name"`
+	PIDFile       string `yaml:"pid_file"`
+	StatsdAddress string `yaml:"statsd_address"`
+}
+
+// HAProxyInstanceConfig is an HAProxy process. Address is its stats
+// socket, or the URL of its stats page in CSV form (.../stats;csv), with
+// the stats auth credentials if any.
+type HAProxyInstanceConfig struct {
+	Name     string        `yaml:"name"`
+	Address  string        `yaml:"address"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// EnvoyInstanceConfig is an Envoy proxy, read through the URL of its admin
+// interface
+type EnvoyInstanceConfig struct {
+	Name    string        `yaml:"name"`
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// RabbitMQServerConfig is a RabbitMQ broker, read through the URL of its
+// management API. VHosts and QueuePattern, a regular expression, limit
+// the queues reported; a queue is growing when its depth rose over
+// GrowthWindow.
+type RabbitMQServerConfig struct {
+	Name         string        `yaml:"name"`
+	URL          string        `yaml:"url"`
+	Username     string        `yaml:"username"`
+	Password     string        `yaml:"password"`
+	VHosts       []string      `yaml:"vhosts"`
+	QueuePattern string        `yaml:"queue_pattern"`
+	GrowthWindow time.Duration `yaml:"growth_window"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// ElasticsearchClusterConfig is an Elasticsearch or OpenSearch cluster,
+// read through the URL of one of its nodes. It authenticates with
+// APIKey, an encoded Elasticsearch API key, or with basic auth.
+type ElasticsearchClusterConfig struct {
+	Name               string        `yaml:"name"`
+	URL                string        `yaml:"url"`
+	Username           string        `yaml:"username"`
+	Password           string        `yaml:"password"`
+	APIKey             string        `yaml:"api_key"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+	Timeout            time.Duration `yaml:"timeout"`
+}
+
+// ScrapeTargetConfig is an endpoint exposing metrics in the Prometheus
+// text format. Its metrics are labelled with job, the target's name, and
+// instance, its host and port; HonorLabels keeps the scraped values of
+// those labels instead. Interval scrapes the target less often than the
+// collector, and SampleLimit fails scrapes with more samples.
+type ScrapeTargetConfig struct {
+	Name                 string            `yaml:"name"`
+	URL                  string            `yaml:"url"`
+	Interval             time.Duration     `yaml:"interval"`
+	Timeout              time.Duration     `yaml:"timeout"`
+	Username             string            `yaml:"username"`
+	Password             string            `yaml:"password"`
+	BearerToken          string            `yaml:"bearer_token"`
+	InsecureSkipVerify   bool              `yaml:"insecure_skip_verify"`
+	Labels               map[string]string `yaml:"labels"`
+	HonorLabels          bool              `yaml:"honor_labels"`
+	SampleLimit          int               `yaml:"sample_limit"`
+	MetricRelabelConfigs []RelabelConfig   `yaml:"metric_relabel_configs"`
+}
+
+// RelabelConfig rewrites, keeps or drops scraped metrics by their labels,
+// as in Prometheus. The metric name is the __name__ label. Action is
+// replace, keep, drop, labelmap, labeldrop or labelkeep.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    string   `yaml:"separator"`
+	Regex        string   `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  string   `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+}
+
+// S3Config holds the settings for an S3-compatible bucket
+type S3Config struct {
+	Bucket          string `yaml:"bucket"`
+	Prefix          string `yaml:"prefix"`
+	Region          string `yaml:"region"`
+	Endpoint        string `yaml:"endpoint"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	UsePathStyle    bool   `yaml:"use_path_style"`
+}
+
+// KubernetesConfig controls the controller mode, in which the server
+// watches AlertRule, Dashboard and AgentConfig custom resources
+type KubernetesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Namespace limits the watch to one namespace; empty watches all
+	Namespace string `yaml:"namespace"`
+	// APIServer defaults to the in-cluster service address
+	APIServer string `yaml:"api_server"`
+	TokenFile string `yaml:"token_file"`
+	CAFile    string `yaml:"ca_file"`
+	// ResyncInterval is how often resources are listed again and agent
+	// configs re-applied, which also reaches newly connected agents
+	ResyncInterval time.Duration `yaml:"resync_interval"`
+}
+
+// LifecycleConfig controls node decommissioning and where lifecycle
+// events are sent
+type LifecycleConfig struct {
+	// DecommissionGrace is how long a retiring node's series are kept
+	// before they are archived or purged
+	DecommissionGrace time.Duration `yaml:"decommission_grace"`
+	ArchivePath       string        `yaml:"archive_path"`
+	// EphemeralTTL is how long an ephemeral node is kept after a clean
+	// scale-in before it is removed
+	EphemeralTTL time.Duration   `yaml:"ephemeral_ttl"`
+	Webhooks     []WebhookConfig `yaml:"webhooks"`
+}
+
+// RouteConfig is a node of the alert notification routing tree. An alert
+// is handled by the deepest routes whose matchers it satisfies; with
+// continue, matching also proceeds to the following sibling routes. Unset
+// fields are inherited from the parent route.
+type RouteConfig struct {
+	Receiver string `yaml:"receiver"`
+	// GroupBy lists the labels alerts are grouped by into one
+	// notification; "..." groups by all labels
+	GroupBy []string `yaml:"group_by"`
+	// Matchers are name=value, name!=value, name=~regex or name!~regex;
+	// alertname matches the rule name
+	Matchers []string `yaml:"matchers"`
+	Continue bool     `yaml:"continue"`
+	// GroupWait delays the first notification of a new group to collect
+	// related alerts, GroupInterval spaces notifications about changes to
+	// a group, and RepeatInterval resends an unchanged firing group
+	GroupWait      time.Duration `yaml:"group_wait"`
+	GroupInterval  time.Duration `yaml:"group_interval"`
+	RepeatInterval time.Duration `yaml:"repeat_interval"`
+	Routes         []RouteConfig `yaml:"routes"`
+}
+
+// ReceiverConfig is a named set of notification integrations
+type ReceiverConfig struct {
+	Name     string          `yaml:"name"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Slack    []SlackConfig   `yaml:"slack"`
+	Email    []EmailConfig   `yaml:"email"`
+}
+
+// Email TLS modes
+const (
+	EmailTLSStartTLS = "starttls"
+	EmailTLSImplicit = "tls"
+	EmailTLSNone     = "none"
+)
+
+// SlackConfig sends notifications to a Slack incoming webhook
+type SlackConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+	Channel    string `yaml:"channel"`
+	Username   string `yaml:"username"`
+	IconEmoji  string `yaml:"icon_emoji"`
+	// Title is a Go template over the notification, Text over each alert
+	Title string `yaml:"title"`
+	Text  string `yaml:"text"`
+	// Colors overrides the message color by severity, or for "resolved"
+	Colors map[string]string `yaml:"colors"`
+	// Locale of the default templates, and of the t function in
+	// configured ones; the i18n default locale if unset
+	Locale string `yaml:"locale"`
+}
+
+// EmailConfig sends notifications over SMTP
+type EmailConfig struct {
+	SMTPHost string   `yaml:"smtp_host"`
+	SMTPPort int      `yaml:"smtp_port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// TLS is starttls, tls for implicit TLS on port 465, or none
+	TLS                string `yaml:"tls"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	// Subject is a Go template over the notification, and HTMLTemplate a
+	// file holding an html/template for the body
+	Subject      string `yaml:"subject"`
+	HTMLTemplate string `yaml:"html_template"`
+	// Locale of the default templates, and of the t function in
+	// configured ones; the i18n default locale if unset
+	Locale string `yaml:"locale"`
+}
+
+func (e *EmailConfig) setDefaults() {
+	if e.SMTPPort == 0 {
+		e.SMTPPort = 587
+	}
+	if e.TLS == "" {
+		e.TLS = EmailTLSStartTLS
+		if e.SMTPPort == 465 {
+			e.TLS = EmailTLSImplicit
+		}
+	}
+}
+
+func (e *EmailConfig) validate() error {
+	if e.SMTPHost == "" {
+		return fmt.Errorf("smtp_host is required")
+	}
+	if e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("from and to are required")
+	}
+	switch e.TLS {
+	case EmailTLSStartTLS, EmailTLSImplicit, EmailTLSNone:
+	default:
+		return fmt.Errorf("invalid tls mode: %s", e.TLS)
+	}
+	if e.Locale != "" && !i18n.ValidLocale(e.Locale) {
+		return fmt.Errorf("invalid locale: %q", e.Locale)
+	}
+	return nil
+}
+
+func (s *SlackConfig) validate() error {
+	u, err := url.Parse(s.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid slack webhook_url: %q", s.WebhookURL)
+	}
+	if s.Locale != "" && !i18n.ValidLocale(s.Locale) {
+		return fmt.Errorf("invalid slack locale: %q", s.Locale)
+	}
+	return nil
+}
+
+// WebhookConfig is an HTTP endpoint receiving JSON events. An empty event
+// list subscribes to every event.
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Events  []string          `yaml:"events"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"`
 }
 
 type LogConfig struct {
@@ -178,6 +1301,7 @@ func LoadConfig(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	config.File = path
 
 	// Set defaults
 	config.setDefaults()
@@ -190,6 +1314,20 @@ func LoadConfig(path string) (*Config, error) {
 	return &config, nil
 }
 
+// DefaultConfig returns the configuration used without a config file, as
+// in demo mode, with storage at storagePath
+func DefaultConfig(storagePath string) (*Config, error) {
+	config := &Config{}
+	config.Storage.Path = storagePath
+	config.Logging.Output = "stdout"
+	config.Logging.Format = "console"
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
 func (c *Config) setDefaults() {
 	if c.Server.GRPC.Address == "" {
 		c.Server.GRPC.Address = "0.0.0.0"
@@ -203,6 +1341,30 @@ func (c *Config) setDefaults() {
 	if c.Server.GRPC.HeartbeatTimeout == 0 {
 		c.Server.GRPC.HeartbeatTimeout = 90 * time.Second
 	}
+	if c.Server.GRPC.Overload.MaxInflight == 0 {
+		c.Server.GRPC.Overload.MaxInflight = 256
+	}
+	if c.Server.GRPC.Overload.LowSampleRate == 0 {
+		c.Server.GRPC.Overload.LowSampleRate = 0.1
+	}
+	if c.Server.GRPC.Overload.NormalSampleRate == 0 {
+		c.Server.GRPC.Overload.NormalSampleRate = 0.5
+	}
+	if c.Server.GRPC.CollectorDefaults == nil {
+		c.Server.GRPC.CollectorDefaults = []CollectorDefaultConfig{
+			{
+				Name:     "system",
+				Enabled:  true,
+				Interval: time.Second,
+				Params: map[string]string{
+					"include_cpu":    "true",
+					"include_memory": "true",
+					"include_disk":   "true",
+				},
+			},
+			{Name: "process", Enabled: true, Interval: 5 * time.Second},
+		}
+	}
 
 	if c.Server.HTTP.Address == "" {
 		c.Server.HTTP.Address = "0.0.0.0"
@@ -211,9 +1373,76 @@ func (c *Config) setDefaults() {
 		c.Server.HTTP.Port = 8080
 	}
 
+	if len(c.Server.OTLP.NodeAttributes) == 0 {
+		c.Server.OTLP.NodeAttributes = []string{"host.name", "service.instance.id"}
+	}
+	if c.Server.OTLP.MaxRequestSize == 0 {
+		c.Server.OTLP.MaxRequestSize = 16 << 20
+	}
+
+	if c.Server.WebSocket.Mode == "" {
+		c.Server.WebSocket.Mode = WebSocketModeBoth
+	}
+	if c.Server.WebSocket.Address == "" {
+		c.Server.WebSocket.Address = "0.0.0.0"
+	}
+	if c.Server.WebSocket.Port == 0 {
+		c.Server.WebSocket.Port = 3000
+	}
+	if c.Server.WebSocket.ClientQueueSize == 0 {
+		c.Server.WebSocket.ClientQueueSize = 256
+	}
+	if c.Server.WebSocket.SlowClientPolicy == "" {
+		c.Server.WebSocket.SlowClientPolicy = WebSocketPolicyDropOldest
+	}
+	if c.Server.WebSocket.FanOutWorkers == 0 {
+		c.Server.WebSocket.FanOutWorkers = 4
+	}
+	if c.Server.WebSocket.CoalesceTopics == nil {
+		c.Server.WebSocket.CoalesceTopics = []string{"metrics", "node_status"}
+	}
+
+	if c.Server.Query.MaxPointsPerSeries == 0 {
+		c.Server.Query.MaxPointsPerSeries = 1000
+	}
+	if c.Server.Query.DownsampleMethod == "" {
+		c.Server.Query.DownsampleMethod = "lttb"
+	}
+
 	if c.Storage.Path == "" {
 		c.Storage.Path = "./data"
 	}
+	if c.Server.GRPC.Enrollment.CADir == "" {
+		c.Server.GRPC.Enrollment.CADir = filepath.Join(c.Storage.Path, "ca")
+	}
+	if c.Server.GRPC.Enrollment.CertValidity == 0 {
+		c.Server.GRPC.Enrollment.CertValidity = 24 * time.Hour
+	}
+	if c.TLS.MinVersion == "" {
+		c.TLS.MinVersion = "1.2"
+	}
+	if c.Naming.NamePattern == "" {
+		c.Naming.NamePattern = "[a-z_:][a-z0-9_:]*"
+	}
+	if c.Naming.LabelPattern == "" {
+		c.Naming.LabelPattern = "[a-z_][a-z0-9_]*"
+	}
+	if c.Naming.CounterSuffix == "" {
+		c.Naming.CounterSuffix = "_total"
+	}
+	if c.Naming.UnitSuffixes == nil {
+		c.Naming.UnitSuffixes = map[string]string{
+			"bytes":   "_bytes",
+			"seconds": "_seconds",
+			"percent": "_percent",
+		}
+	}
+	if c.Memory.LimitRatio == 0 {
+		c.Memory.LimitRatio = 0.9
+	}
+	if c.I18n.DefaultLocale == "" {
+		c.I18n.DefaultLocale = i18n.DefaultLocale
+	}
 	if c.Storage.RetentionPeriod == 0 {
 		c.Storage.RetentionPeriod = 720 * time.Hour // 30 days
 	}
@@ -229,6 +1458,72 @@ func (c *Config) setDefaults() {
 	if c.Storage.MemTableSize == 0 {
 		c.Storage.MemTableSize = 64 << 20 // 64MB
 	}
+	if c.Storage.Usage.Interval == 0 {
+		c.Storage.Usage.Interval = 1 * time.Hour
+	}
+	if c.Storage.Usage.SampleRate == 0 {
+		c.Storage.Usage.SampleRate = 100
+	}
+	if c.Storage.Chunks.Duration == 0 {
+		c.Storage.Chunks.Duration = 2 * time.Hour
+	}
+	if c.Storage.Chunks.Delay == 0 {
+		c.Storage.Chunks.Delay = 10 * time.Minute
+	}
+	if c.Storage.Chunks.Interval == 0 {
+		c.Storage.Chunks.Interval = 5 * time.Minute
+	}
+	if c.Storage.Shards.BlockDuration == 0 {
+		c.Storage.Shards.BlockDuration = 2 * time.Hour
+	}
+	if c.Storage.Shards.MaxOpen == 0 {
+		c.Storage.Shards.MaxOpen = 4
+	}
+	if c.Storage.Tiering.CacheBlocks == 0 {
+		c.Storage.Tiering.CacheBlocks = 4
+	}
+	if c.Storage.SymbolTableSize == 0 {
+		c.Storage.SymbolTableSize = DefaultMaxSymbols
+	}
+	if c.Storage.Rollups.Interval == 0 {
+		c.Storage.Rollups.Interval = 1 * time.Minute
+	}
+	if c.Storage.Rollups.Delay == 0 {
+		c.Storage.Rollups.Delay = 2 * time.Minute
+	}
+	if c.Storage.StaleSeries.IdleTimeout == 0 {
+		c.Storage.StaleSeries.IdleTimeout = 1 * time.Hour
+	}
+	if c.Storage.StaleSeries.Interval == 0 {
+		c.Storage.StaleSeries.Interval = 10 * time.Minute
+	}
+	if c.Storage.Replication.Listen == "" {
+		c.Storage.Replication.Listen = ":9096"
+	}
+	if c.Storage.Replication.Interval == 0 {
+		c.Storage.Replication.Interval = 5 * time.Second
+	}
+	if c.Storage.Replication.MetadataInterval == 0 {
+		c.Storage.Replication.MetadataInterval = 1 * time.Minute
+	}
+	if c.Storage.Migration.CompareRatio == 0 {
+		c.Storage.Migration.CompareRatio = 0.1
+	}
+	if c.Storage.Migration.BackfillWindow == 0 {
+		c.Storage.Migration.BackfillWindow = time.Hour
+	}
+	if len(c.Storage.Rollups.Resolutions) == 0 {
+		c.Storage.Rollups.Resolutions = []RollupResolution{
+			{Resolution: 1 * time.Minute, Retention: 168 * time.Hour},
+			{Resolution: 5 * time.Minute, Retention: 720 * time.Hour},
+			{Resolution: 1 * time.Hour, Retention: 8760 * time.Hour},
+		}
+	}
+	for i := range c.Storage.Rollups.Resolutions {
+		if c.Storage.Rollups.Resolutions[i].Retention == 0 {
+			c.Storage.Rollups.Resolutions[i].Retention = c.Storage.RetentionPeriod
+		}
+	}
 
 	if c.Agent.BatchSize == 0 {
 		c.Agent.BatchSize = 1000
@@ -239,32 +1534,415 @@ func (c *Config) setDefaults() {
 	if c.Agent.HeartbeatInterval == 0 {
 		c.Agent.HeartbeatInterval = 30 * time.Second
 	}
+	if c.Agent.Buffer.Path == "" {
+		c.Agent.Buffer.Path = "./data/agent-buffer"
+	}
+	if c.Agent.Buffer.MaxSize == 0 {
+		c.Agent.Buffer.MaxSize = 256 << 20 // 256MB
+	}
+	if c.Agent.Buffer.MaxAge == 0 {
+		c.Agent.Buffer.MaxAge = 24 * time.Hour
+	}
+	if c.Agent.Telemetry.Address == "" {
+		c.Agent.Telemetry.Address = "127.0.0.1:9101"
+	}
+	if c.Agent.Kubernetes.Mode == "" {
+		c.Agent.Kubernetes.Mode = KubernetesModeAuto
+	}
+	if c.Agent.Kubernetes.PodRefreshInterval == 0 {
+		c.Agent.Kubernetes.PodRefreshInterval = 30 * time.Second
+	}
 
 	if c.Collectors.System.Interval == 0 {
 		c.Collectors.System.Interval = 1 * time.Second
 	}
+	if c.Collectors.System.Cgroup.Root == "" {
+		c.Collectors.System.Cgroup.Root = "/sys/fs/cgroup"
+	}
+	if c.Collectors.System.Cgroup.Depth == 0 {
+		c.Collectors.System.Cgroup.Depth = 1
+	}
 	if c.Collectors.Process.Interval == 0 {
 		c.Collectors.Process.Interval = 5 * time.Second
 	}
 	if c.Collectors.Process.MaxProcesses == 0 {
 		c.Collectors.Process.MaxProcesses = 500
 	}
+	if c.Collectors.Container.Interval == 0 {
+		c.Collectors.Container.Interval = 2 * time.Second
+	}
+	if c.Collectors.Container.Runtime == "" {
+		c.Collectors.Container.Runtime = "auto"
+	}
 	if c.Collectors.Container.DockerSocket == "" {
 		c.Collectors.Container.DockerSocket = "/var/run/docker.sock"
 	}
-}
-
-func (c *Config) validate() error {
-	if c.Server.GRPC.Port <= 0 || c.Server.GRPC.Port > 65535 {
-		return fmt.Errorf("invalid gRPC port: %d", c.Server.GRPC.Port)
+	if c.Collectors.Container.CRISocket == "" {
+		c.Collectors.Container.CRISocket = "/var/run/crio/crio.sock"
 	}
-
-	if c.Server.HTTP.Port <= 0 || c.Server.HTTP.Port > 65535 {
-		return fmt.Errorf("invalid HTTP port: %d", c.Server.HTTP.Port)
+	if c.Collectors.Container.ContainerdSocket == "" {
+		c.Collectors.Container.ContainerdSocket = "/run/containerd/containerd.sock"
 	}
-
-	if c.Server.GRPC.TLS.Enabled {
-		if c.Server.GRPC.TLS.CertFile == "" {
+	if c.Collectors.Container.DockerRoot == "" {
+		c.Collectors.Container.DockerRoot = "/var/lib/docker"
+	}
+	if c.Collectors.Container.ContainersStorage == "" {
+		c.Collectors.Container.ContainersStorage = "/var/lib/containers/storage"
+	}
+	if c.Collectors.Packages.Interval == 0 {
+		c.Collectors.Packages.Interval = 1 * time.Hour
+	}
+	if c.Collectors.Packages.Manager == "" {
+		c.Collectors.Packages.Manager = "auto"
+	}
+	if c.Collectors.Kernel.Interval == 0 {
+		c.Collectors.Kernel.Interval = 5 * time.Minute
+	}
+	if c.Collectors.Limits.Interval == 0 {
+		c.Collectors.Limits.Interval = 30 * time.Second
+	}
+	if c.Collectors.Limits.TopProcesses == 0 {
+		c.Collectors.Limits.TopProcesses = 5
+	}
+	if c.Collectors.WindowsServices.Interval == 0 {
+		c.Collectors.WindowsServices.Interval = 30 * time.Second
+	}
+	if c.Collectors.WindowsEventLog.Interval == 0 {
+		c.Collectors.WindowsEventLog.Interval = 1 * time.Minute
+	}
+	if c.Collectors.HyperV.Interval == 0 {
+		c.Collectors.HyperV.Interval = 15 * time.Second
+	}
+	if c.Collectors.PHPFPM.Interval == 0 {
+		c.Collectors.PHPFPM.Interval = 15 * time.Second
+	}
+	for i := range c.Collectors.PHPFPM.Pools {
+		if c.Collectors.PHPFPM.Pools[i].StatusPath == "" {
+			c.Collectors.PHPFPM.Pools[i].StatusPath = "/status"
+		}
+		if c.Collectors.PHPFPM.Pools[i].Timeout == 0 {
+			c.Collectors.PHPFPM.Pools[i].Timeout = 5 * time.Second
+		}
+	}
+	if c.Collectors.UWSGI.Interval == 0 {
+		c.Collectors.UWSGI.Interval = 15 * time.Second
+	}
+	for i := range c.Collectors.UWSGI.Servers {
+		if c.Collectors.UWSGI.Servers[i].Timeout == 0 {
+			c.Collectors.UWSGI.Servers[i].Timeout = 5 * time.Second
+		}
+	}
+	if c.Collectors.HAProxy.Interval == 0 {
+		c.Collectors.HAProxy.Interval = 15 * time.Second
+	}
+	for i := range c.Collectors.HAProxy.Instances {
+		if c.Collectors.HAProxy.Instances[i].Timeout == 0 {
+			c.Collectors.HAProxy.Instances[i].Timeout = 5 * time.Second
+		}
+	}
+	if c.Collectors.Envoy.Interval == 0 {
+		c.Collectors.Envoy.Interval = 15 * time.Second
+	}
+	for i := range c.Collectors.Envoy.Instances {
+		if c.Collectors.Envoy.Instances[i].Timeout == 0 {
+			c.Collectors.Envoy.Instances[i].Timeout = 5 * time.Second
+		}
+	}
+	if c.Collectors.RabbitMQ.Interval == 0 {
+		c.Collectors.RabbitMQ.Interval = 30 * time.Second
+	}
+	for i := range c.Collectors.RabbitMQ.Servers {
+		if c.Collectors.RabbitMQ.Servers[i].GrowthWindow == 0 {
+			c.Collectors.RabbitMQ.Servers[i].GrowthWindow = 10 * time.Minute
+		}
+		if c.Collectors.RabbitMQ.Servers[i].Timeout == 0 {
+			c.Collectors.RabbitMQ.Servers[i].Timeout = 10 * time.Second
+		}
+	}
+	if c.Collectors.Gunicorn.Interval == 0 {
+		c.Collectors.Gunicorn.Interval = 15 * time.Second
+	}
+	if c.Collectors.Elasticsearch.Interval == 0 {
+		c.Collectors.Elasticsearch.Interval = 30 * time.Second
+	}
+	for i := range c.Collectors.Elasticsearch.Clusters {
+		if c.Collectors.Elasticsearch.Clusters[i].Timeout == 0 {
+			c.Collectors.Elasticsearch.Clusters[i].Timeout = 10 * time.Second
+		}
+	}
+	if c.Collectors.Ceph.Interval == 0 {
+		c.Collectors.Ceph.Interval = 60 * time.Second
+	}
+	if c.Collectors.Ceph.Cluster == "" {
+		c.Collectors.Ceph.Cluster = "ceph"
+	}
+	if c.Collectors.Ceph.Timeout == 0 {
+		c.Collectors.Ceph.Timeout = 30 * time.Second
+	}
+	if c.Collectors.Sockets.Interval == 0 {
+		c.Collectors.Sockets.Interval = 15 * time.Second
+	}
+	if c.Collectors.Sockets.TopProcesses == 0 {
+		c.Collectors.Sockets.TopProcesses = 10
+	}
+	if c.Collectors.Systemd.Interval == 0 {
+		c.Collectors.Systemd.Interval = 30 * time.Second
+	}
+	if c.Collectors.Systemd.CrashLoopWindow == 0 {
+		c.Collectors.Systemd.CrashLoopWindow = 10 * time.Minute
+	}
+	if c.Collectors.Systemd.Timeout == 0 {
+		c.Collectors.Systemd.Timeout = 10 * time.Second
+	}
+	if c.Collectors.Systemd.Journal.Priority == "" {
+		c.Collectors.Systemd.Journal.Priority = "err"
+	}
+	if c.Demo.Nodes == 0 {
+		c.Demo.Nodes = 8
+	}
+	if c.Demo.Interval == 0 {
+		c.Demo.Interval = 15 * time.Second
+	}
+	if c.Demo.Backfill == 0 {
+		c.Demo.Backfill = 6 * time.Hour
+	}
+	if c.Demo.AnomaliesPerHour == 0 {
+		c.Demo.AnomaliesPerHour = 0.5
+	}
+	if c.Metering.TenantLabel == "" {
+		c.Metering.TenantLabel = "tenant"
+	}
+	if c.Metering.DefaultTenant == "" {
+		c.Metering.DefaultTenant = "default"
+	}
+	if c.Metering.FlushInterval == 0 {
+		c.Metering.FlushInterval = time.Minute
+	}
+	if c.Metering.StorageInterval == 0 {
+		c.Metering.StorageInterval = time.Hour
+	}
+	if c.Metering.Retention == 0 {
+		c.Metering.Retention = 400 * 24 * time.Hour
+	}
+	if c.Trends.CPUMetric == "" {
+		c.Trends.CPUMetric = "system_cpu_usage_total"
+	}
+	if c.Trends.DiskMetric == "" {
+		c.Trends.DiskMetric = "system_disk_used_bytes"
+	}
+	if c.Trends.FlushInterval == 0 {
+		c.Trends.FlushInterval = time.Minute
+	}
+	if c.Trends.RetentionMonths == 0 {
+		c.Trends.RetentionMonths = 36
+	}
+	for i := range c.Trends.Reports {
+		if c.Trends.Reports[i].Months == 0 {
+			c.Trends.Reports[i].Months = 3
+		}
+		if c.Trends.Reports[i].GroupBy == "" {
+			c.Trends.Reports[i].GroupBy = "node"
+		}
+		c.Trends.Reports[i].Email.setDefaults()
+	}
+	if c.Collectors.Kubernetes.Interval == 0 {
+		c.Collectors.Kubernetes.Interval = 30 * time.Second
+	}
+	if c.Collectors.Kubernetes.Scope == "" {
+		c.Collectors.Kubernetes.Scope = "node"
+	}
+	if c.Collectors.Kubernetes.NodeName == "" {
+		c.Collectors.Kubernetes.NodeName = c.Agent.Kubernetes.NodeName
+	}
+	if c.Collectors.Kubernetes.UsageSource == "" {
+		c.Collectors.Kubernetes.UsageSource = "kubelet"
+	}
+	if c.Collectors.Kubernetes.Timeout == 0 {
+		c.Collectors.Kubernetes.Timeout = 10 * time.Second
+	}
+	if c.Collectors.EBPF.Interval == 0 {
+		c.Collectors.EBPF.Interval = 15 * time.Second
+	}
+	if c.Collectors.EBPF.MaxDestinations == 0 {
+		c.Collectors.EBPF.MaxDestinations = 1024
+	}
+	if c.Collectors.EBPF.Processes.MaxProcesses == 0 {
+		c.Collectors.EBPF.Processes.MaxProcesses = 4096
+	}
+	if c.Collectors.EBPF.Processes.ProcPath == "" {
+		c.Collectors.EBPF.Processes.ProcPath = "/proc"
+	}
+	if c.Collectors.ZFS.Interval == 0 {
+		c.Collectors.ZFS.Interval = 60 * time.Second
+	}
+	if c.Collectors.Custom.Interval == 0 {
+		c.Collectors.Custom.Interval = 60 * time.Second
+	}
+	if c.Collectors.Custom.Timeout == 0 {
+		c.Collectors.Custom.Timeout = 30 * time.Second
+	}
+	if c.Collectors.Custom.MaxParallel == 0 {
+		c.Collectors.Custom.MaxParallel = 4
+	}
+	if c.Collectors.Custom.MaxOutput == 0 {
+		c.Collectors.Custom.MaxOutput = 1 << 20
+	}
+	if c.Collectors.Scrape.Interval == 0 {
+		c.Collectors.Scrape.Interval = 15 * time.Second
+	}
+	for i := range c.Collectors.Scrape.Targets {
+		if c.Collectors.Scrape.Targets[i].Timeout == 0 {
+			c.Collectors.Scrape.Targets[i].Timeout = 10 * time.Second
+		}
+	}
+	if c.Collectors.JMX.Interval == 0 {
+		c.Collectors.JMX.Interval = 15 * time.Second
+	}
+	for i := range c.Collectors.JMX.Apps {
+		if c.Collectors.JMX.Apps[i].Timeout == 0 {
+			c.Collectors.JMX.Apps[i].Timeout = 10 * time.Second
+		}
+		for j := range c.Collectors.JMX.Apps[i].Beans {
+			if c.Collectors.JMX.Apps[i].Beans[j].Type == "" {
+				c.Collectors.JMX.Apps[i].Beans[j].Type = "gauge"
+			}
+		}
+	}
+
+	if c.Kubernetes.TokenFile == "" {
+		c.Kubernetes.TokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	}
+	if c.Kubernetes.CAFile == "" {
+		c.Kubernetes.CAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	}
+	if c.Kubernetes.ResyncInterval == 0 {
+		c.Kubernetes.ResyncInterval = 1 * time.Minute
+	}
+
+	if c.Alerting.RulesReloadInterval == 0 {
+		c.Alerting.RulesReloadInterval = 30 * time.Second
+	}
+	if c.Alerting.SilenceRetention == 0 {
+		c.Alerting.SilenceRetention = 120 * time.Hour
+	}
+	if c.Alerting.GroupConcurrency == 0 {
+		c.Alerting.GroupConcurrency = 4
+	}
+	if c.Alerting.Route.GroupWait == 0 {
+		c.Alerting.Route.GroupWait = 30 * time.Second
+	}
+	if c.Alerting.Route.GroupInterval == 0 {
+		c.Alerting.Route.GroupInterval = 5 * time.Minute
+	}
+	if c.Alerting.Route.RepeatInterval == 0 {
+		c.Alerting.Route.RepeatInterval = 4 * time.Hour
+	}
+	c.Alerting.Notification.Email.setDefaults()
+	for i := range c.Alerting.Receivers {
+		for j := range c.Alerting.Receivers[i].Webhooks {
+			if c.Alerting.Receivers[i].Webhooks[j].Timeout == 0 {
+				c.Alerting.Receivers[i].Webhooks[j].Timeout = 10 * time.Second
+			}
+		}
+		for j := range c.Alerting.Receivers[i].Email {
+			c.Alerting.Receivers[i].Email[j].setDefaults()
+		}
+	}
+
+	if c.Lifecycle.DecommissionGrace == 0 {
+		c.Lifecycle.DecommissionGrace = 72 * time.Hour
+	}
+	if c.Lifecycle.ArchivePath == "" {
+		c.Lifecycle.ArchivePath = "./archive/nodes"
+	}
+	if c.Lifecycle.EphemeralTTL == 0 {
+		c.Lifecycle.EphemeralTTL = 1 * time.Hour
+	}
+	for i := range c.Lifecycle.Webhooks {
+		if c.Lifecycle.Webhooks[i].Timeout == 0 {
+			c.Lifecycle.Webhooks[i].Timeout = 10 * time.Second
+		}
+	}
+
+	for i := range c.Exports {
+		if c.Exports[i].Format == "" {
+			c.Exports[i].Format = ExportFormatCSV
+		}
+		if c.Exports[i].Range == 0 {
+			c.Exports[i].Range = 1 * time.Hour
+		}
+		if c.Exports[i].Step == 0 {
+			c.Exports[i].Step = 1 * time.Minute
+		}
+	}
+}
+
+func (c *Config) validate() error {
+	if c.Server.GRPC.Port <= 0 || c.Server.GRPC.Port > 65535 {
+		return fmt.Errorf("invalid gRPC port: %d", c.Server.GRPC.Port)
+	}
+
+	if c.Server.HTTP.Port <= 0 || c.Server.HTTP.Port > 65535 {
+		return fmt.Errorf("invalid HTTP port: %d", c.Server.HTTP.Port)
+	}
+
+	if err := c.validatePriorities(); err != nil {
+		return err
+	}
+
+	switch c.Server.WebSocket.Mode {
+	case WebSocketModeHTTP:
+	case WebSocketModeStandalone, WebSocketModeBoth:
+		if c.Server.WebSocket.Port <= 0 || c.Server.WebSocket.Port > 65535 {
+			return fmt.Errorf("invalid WebSocket port: %d", c.Server.WebSocket.Port)
+		}
+	default:
+		return fmt.Errorf("unknown WebSocket mode: %s", c.Server.WebSocket.Mode)
+	}
+	if c.Server.WebSocket.ClientQueueSize < 0 {
+		return fmt.Errorf("invalid WebSocket client queue size: %d", c.Server.WebSocket.ClientQueueSize)
+	}
+	switch c.Server.WebSocket.SlowClientPolicy {
+	case WebSocketPolicyDropOldest, WebSocketPolicyDisconnect:
+	default:
+		return fmt.Errorf("unknown WebSocket slow client policy: %s", c.Server.WebSocket.SlowClientPolicy)
+	}
+	if c.Server.WebSocket.FanOutWorkers < 1 {
+		return fmt.Errorf("WebSocket fanout_workers must be at least 1: %d", c.Server.WebSocket.FanOutWorkers)
+	}
+	if c.Server.WebSocket.MaxConnections < 0 {
+		return fmt.Errorf("invalid WebSocket max connections: %d", c.Server.WebSocket.MaxConnections)
+	}
+	if c.Server.WebSocket.ClientRateLimit < 0 || c.Server.WebSocket.ClientRateBurst < 0 {
+		return fmt.Errorf("invalid WebSocket client rate limit: %g, burst %d", c.Server.WebSocket.ClientRateLimit, c.Server.WebSocket.ClientRateBurst)
+	}
+	for _, token := range c.Server.WebSocket.Tokens {
+		if token == "" {
+			return fmt.Errorf("WebSocket tokens must not be empty")
+		}
+	}
+	for _, origin := range c.Server.WebSocket.AllowedOrigins {
+		if _, err := path.Match(origin, ""); err != nil {
+			return fmt.Errorf("invalid WebSocket allowed origin %q: %w", origin, err)
+		}
+	}
+
+	if err := c.TLS.check(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	for name, networks := range map[string][]string{
+		"gRPC":      c.Server.GRPC.AllowedNetworks,
+		"HTTP":      c.Server.HTTP.AllowedNetworks,
+		"WebSocket": c.Server.WebSocket.AllowedNetworks,
+	} {
+		if _, err := NewAllowlist(networks); err != nil {
+			return fmt.Errorf("%s allowed_networks: %w", name, err)
+		}
+	}
+
+	if c.Server.GRPC.TLS.Enabled {
+		if c.Server.GRPC.TLS.CertFile == "" {
 			return fmt.Errorf("TLS cert file is required when TLS is enabled")
 		}
 		if c.Server.GRPC.TLS.KeyFile == "" {
@@ -272,13 +1950,697 @@ func (c *Config) validate() error {
 		}
 	}
 
+	if c.Server.GRPC.Enrollment.Enabled {
+		if !c.Server.GRPC.TLS.Enabled {
+			return fmt.Errorf("gRPC TLS must be enabled for agent enrollment")
+		}
+		if len(c.Server.GRPC.Enrollment.Tokens) == 0 {
+			return fmt.Errorf("at least one enrollment token is required when enrollment is enabled")
+		}
+		if c.Server.GRPC.Enrollment.CertValidity < 10*time.Minute {
+			return fmt.Errorf("enrollment cert validity must be at least 10m: %s", c.Server.GRPC.Enrollment.CertValidity)
+		}
+	}
+
+	filters := make(map[string]bool, len(c.Server.Ingest.MetricFilters))
+	for i := range c.Server.Ingest.MetricFilters {
+		f := &c.Server.Ingest.MetricFilters[i]
+		if filters[f.Name] {
+			return fmt.Errorf("metric filter names must be unique: %q", f.Name)
+		}
+		filters[f.Name] = true
+		if _, err := NewMetricFilter(f); err != nil {
+			return err
+		}
+	}
+
+	collectors := make(map[string]bool, len(c.Server.GRPC.CollectorDefaults))
+	for _, collector := range c.Server.GRPC.CollectorDefaults {
+		if collector.Name == "" {
+			return fmt.Errorf("collector_defaults entries need a name")
+		}
+		if collectors[collector.Name] {
+			return fmt.Errorf("duplicate collector default: %s", collector.Name)
+		}
+		collectors[collector.Name] = true
+		if collector.Interval < 0 {
+			return fmt.Errorf("collector default %s interval must not be negative: %s", collector.Name, collector.Interval)
+		}
+	}
+
+	if c.Memory.Limit != "" {
+		if limit, err := ParseByteSize(c.Memory.Limit); err != nil || limit <= 0 {
+			return fmt.Errorf("invalid memory limit: %q", c.Memory.Limit)
+		}
+	}
+	if c.Memory.LimitRatio <= 0 || c.Memory.LimitRatio > 1 {
+		return fmt.Errorf("memory limit_ratio must be between 0 and 1: %g", c.Memory.LimitRatio)
+	}
+	if c.Memory.GCPercent < -1 {
+		return fmt.Errorf("memory gc_percent must be -1 or more: %d", c.Memory.GCPercent)
+	}
+	if c.Memory.Ballast != "" {
+		if _, err := ParseByteSize(c.Memory.Ballast); err != nil {
+			return fmt.Errorf("invalid memory ballast: %q", c.Memory.Ballast)
+		}
+	}
+
+	if c.Naming.Enabled {
+		if _, err := NewNamingLinter(&c.Naming); err != nil {
+			return fmt.Errorf("naming: %w", err)
+		}
+	}
+
+	if _, err := i18n.Load(c.I18n.DefaultLocale, c.I18n.LocalesPath); err != nil {
+		return fmt.Errorf("i18n: %w", err)
+	}
+
+	if c.Server.HTTP.TLS.Enabled && (c.Server.HTTP.TLS.CertFile == "" || c.Server.HTTP.TLS.KeyFile == "") {
+		return fmt.Errorf("HTTP TLS cert_file and key_file are required when TLS is enabled")
+	}
+
+	if c.Agent.TLS.Enabled && (c.Agent.TLS.CertFile == "") != (c.Agent.TLS.KeyFile == "") {
+		return fmt.Errorf("agent TLS cert_file and key_file must be set together")
+	}
+	if c.Agent.TLS.EnrollmentToken != "" && (!c.Agent.TLS.Enabled || c.Agent.TLS.CertFile == "") {
+		return fmt.Errorf("agent enrollment needs TLS enabled and cert_file and key_file to write the certificate to")
+	}
+
+	switch c.Agent.Kubernetes.Mode {
+	case KubernetesModeAuto, KubernetesModeEnabled, KubernetesModeDisabled:
+	default:
+		return fmt.Errorf("unknown agent kubernetes mode: %s", c.Agent.Kubernetes.Mode)
+	}
+
+	if c.Agent.Buffer.Enabled && c.Agent.Buffer.MaxSize < 1<<20 {
+		return fmt.Errorf("agent buffer max size must be at least 1MB: %d", c.Agent.Buffer.MaxSize)
+	}
+
+	if c.Storage.Chunks.Duration < time.Minute {
+		return fmt.Errorf("storage chunk duration must be at least 1m: %s", c.Storage.Chunks.Duration)
+	}
+
+	if stale := c.Storage.StaleSeries; stale.Enabled && (stale.IdleTimeout < time.Minute || stale.Interval < time.Minute) {
+		return fmt.Errorf("storage stale series idle_timeout and interval must be at least 1m")
+	}
+
+	if err := c.validateShards(); err != nil {
+		return err
+	}
+
+	switch c.Storage.Metadata.Driver {
+	case "", MetadataDriverSQLite, MetadataDriverBadger:
+	case MetadataDriverPostgres:
+		if c.Storage.Metadata.DSN == "" {
+			return fmt.Errorf("storage metadata dsn is required for postgres")
+		}
+	default:
+		return fmt.Errorf("unknown storage metadata driver: %s", c.Storage.Metadata.Driver)
+	}
+
+	if err := c.validateTiering(); err != nil {
+		return err
+	}
+
+	if err := c.validateReplication(); err != nil {
+		return err
+	}
+	if err := c.validateMigration(); err != nil {
+		return err
+	}
+
+	if err := c.validateRollups(); err != nil {
+		return err
+	}
+
+	if c.Alerting.EvaluationInterval < 0 || c.Alerting.EvaluationJitter < 0 {
+		return fmt.Errorf("alerting evaluation_interval and evaluation_jitter must not be negative")
+	}
+	if c.Alerting.EvaluationInterval > 0 && c.Alerting.EvaluationJitter >= c.Alerting.EvaluationInterval {
+		return fmt.Errorf("alerting evaluation_jitter must be shorter than evaluation_interval")
+	}
+	if c.Alerting.GroupConcurrency < 1 {
+		return fmt.Errorf("alerting group_concurrency must be at least 1: %d", c.Alerting.GroupConcurrency)
+	}
+
+	if err := c.validateRouting(); err != nil {
+		return err
+	}
+
+	if err := c.validateAppServers(); err != nil {
+		return err
+	}
+	if err := c.validateProxies(); err != nil {
+		return err
+	}
+	if err := c.validateRabbitMQ(); err != nil {
+		return err
+	}
+	if err := c.validateElasticsearch(); err != nil {
+		return err
+	}
+	if err := c.validateScrape(); err != nil {
+		return err
+	}
+
+	switch c.Collectors.Systemd.Journal.Priority {
+	case "emerg", "alert", "crit", "err", "warning", "notice", "info", "debug":
+	default:
+		return fmt.Errorf("unknown systemd journal priority: %s", c.Collectors.Systemd.Journal.Priority)
+	}
+	for _, unit := range c.Collectors.Systemd.Units {
+		if _, err := path.Match(unit, ""); err != nil || unit == "" {
+			return fmt.Errorf("invalid systemd unit %q", unit)
+		}
+	}
+
+	if c.Demo.Enabled && c.Demo.Nodes < 0 {
+		return fmt.Errorf("demo nodes must not be negative")
+	}
+
+	if c.Metering.Enabled && (c.Metering.FlushInterval < 0 || c.Metering.StorageInterval < 0 || c.Metering.Retention < 0) {
+		return fmt.Errorf("metering intervals and retention must be positive")
+	}
+
+	if c.Trends.Enabled {
+		if c.Trends.FlushInterval < 0 || c.Trends.RetentionMonths < 0 {
+			return fmt.Errorf("trends flush interval and retention must be positive")
+		}
+		reports := make(map[string]bool, len(c.Trends.Reports))
+		for _, r := range c.Trends.Reports {
+			if r.Name == "" {
+				return fmt.Errorf("trend report name is required")
+			}
+			if reports[r.Name] {
+				return fmt.Errorf("duplicate trend report name: %s", r.Name)
+			}
+			reports[r.Name] = true
+			if _, err := ParseCron(r.Schedule); err != nil {
+				return fmt.Errorf("trend report %s: %w", r.Name, err)
+			}
+			if r.Months < 1 {
+				return fmt.Errorf("trend report %s: months must be at least 1", r.Name)
+			}
+			if err := r.Email.validate(); err != nil {
+				return fmt.Errorf("trend report %s: email: %w", r.Name, err)
+			}
+		}
+	}
+
+	switch c.Collectors.Kubernetes.Scope {
+	case "node", "cluster":
+	default:
+		return fmt.Errorf("unknown kubernetes collector scope: %s", c.Collectors.Kubernetes.Scope)
+	}
+	switch c.Collectors.Kubernetes.UsageSource {
+	case "kubelet", "metrics-server":
+	default:
+		return fmt.Errorf("unknown kubernetes usage source: %s", c.Collectors.Kubernetes.UsageSource)
+	}
+
+	if c.Collectors.EBPF.MaxDestinations < 0 {
+		return fmt.Errorf("ebpf max_destinations must not be negative")
+	}
+	if c.Collectors.EBPF.Processes.MaxProcesses < 0 {
+		return fmt.Errorf("ebpf max_processes must not be negative")
+	}
+	for _, patterns := range [][]string{c.Collectors.EBPF.Processes.Comms, c.Collectors.EBPF.Processes.Paths} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid ebpf process pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	apps := make(map[string]bool, len(c.Collectors.JMX.Apps))
+	for _, app := range c.Collectors.JMX.Apps {
+		if app.Name == "" {
+			return fmt.Errorf("jmx app name is required")
+		}
+		if apps[app.Name] {
+			return fmt.Errorf("duplicate jmx app name: %s", app.Name)
+		}
+		apps[app.Name] = true
+
+		u, err := url.Parse(app.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("jmx app %s: invalid url: %q", app.Name, app.URL)
+		}
+		for _, bean := range app.Beans {
+			if !strings.Contains(bean.MBean, ":") {
+				return fmt.Errorf("jmx app %s: invalid mbean %q", app.Name, bean.MBean)
+			}
+			if bean.Type != "gauge" && bean.Type != "counter" {
+				return fmt.Errorf("jmx app %s: unknown metric type %q for %s", app.Name, bean.Type, bean.MBean)
+			}
+		}
+	}
+
 	if c.Authentication.Enabled && c.Authentication.JWTSecret == "" {
 		return fmt.Errorf("JWT secret is required when authentication is enabled")
 	}
+	if err := c.validateUsers(); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(c.Exports))
+	for _, e := range c.Exports {
+		if e.Name == "" {
+			return fmt.Errorf("export name is required")
+		}
+		if names[e.Name] {
+			return fmt.Errorf("duplicate export name: %s", e.Name)
+		}
+		names[e.Name] = true
+
+		if e.Query == "" {
+			return fmt.Errorf("export %s: query is required", e.Name)
+		}
+		if _, err := ParseCron(e.Schedule); err != nil {
+			return fmt.Errorf("export %s: %w", e.Name, err)
+		}
+		if e.Format != ExportFormatCSV && e.Format != ExportFormatParquet {
+			return fmt.Errorf("export %s: unsupported format %q (csv or parquet)", e.Name, e.Format)
+		}
+		if e.Destination.Path == "" && e.Destination.S3.Bucket == "" {
+			return fmt.Errorf("export %s: destination path or s3 bucket is required", e.Name)
+		}
+	}
+
+	if c.Kubernetes.Enabled && c.Kubernetes.ResyncInterval < 10*time.Second {
+		return fmt.Errorf("kubernetes resync interval must be at least 10s: %s", c.Kubernetes.ResyncInterval)
+	}
+
+	for _, w := range c.Lifecycle.Webhooks {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid lifecycle webhook url: %q", w.URL)
+		}
+	}
+
+	return nil
+}
+
+// validateAppServers checks that php-fpm pools and uWSGI and Gunicorn
+// servers are named uniquely and say where to read their status
+func (c *Config) validateAppServers() error {
+	pools := make(map[string]bool, len(c.Collectors.PHPFPM.Pools))
+	for _, p := range c.Collectors.PHPFPM.Pools {
+		if p.Name == "" || pools[p.Name] {
+			return fmt.Errorf("php-fpm pool names must be set and unique: %q", p.Name)
+		}
+		pools[p.Name] = true
+		if p.Address == "" {
+			return fmt.Errorf("php-fpm pool %s: address is required", p.Name)
+		}
+	}
+
+	servers := make(map[string]bool, len(c.Collectors.UWSGI.Servers))
+	for _, s := range c.Collectors.UWSGI.Servers {
+		if s.Name == "" || servers[s.Name] {
+			return fmt.Errorf("uwsgi server names must be set and unique: %q", s.Name)
+		}
+		servers[s.Name] = true
+		if s.Address == "" {
+			return fmt.Errorf("uwsgi server %s: address is required", s.Name)
+		}
+	}
+
+	servers = make(map[string]bool, len(c.Collectors.Gunicorn.Servers))
+	for _, s := range c.Collectors.Gunicorn.Servers {
+		if s.Name == "" || servers[s.Name] {
+			return fmt.Errorf("gunicorn server names must be set and unique: %q", s.Name)
+		}
+		servers[s.Name] = true
+		if s.PIDFile == "" && s.StatsdAddress == "" {
+			return fmt.Errorf("gunicorn server %s: pid_file or statsd_address is required", s.Name)
+		}
+	}
+	return nil
+}
+
+// validateProxies checks that HAProxy and Envoy instances are named
+// uniquely and have an address
+func (c *Config) validateProxies() error {
+	instances := make(map[string]bool, len(c.Collectors.HAProxy.Instances))
+	for _, inst := range c.Collectors.HAProxy.Instances {
+		if inst.Name == "" || instances[inst.Name] {
+			return fmt.Errorf("haproxy instance names must be set and unique: %q", inst.Name)
+		}
+		instances[inst.Name] = true
+		if inst.Address == "" {
+			return fmt.Errorf("haproxy instance %s: address is required", inst.Name)
+		}
+	}
+
+	instances = make(map[string]bool, len(c.Collectors.Envoy.Instances))
+	for _, inst := range c.Collectors.Envoy.Instances {
+		if inst.Name == "" || instances[inst.Name] {
+			return fmt.Errorf("envoy instance names must be set and unique: %q", inst.Name)
+		}
+		instances[inst.Name] = true
+		if !strings.HasPrefix(inst.URL, "http://") && !strings.HasPrefix(inst.URL, "https://") {
+			return fmt.Errorf("envoy instance %s: url must be an http(s) URL", inst.Name)
+		}
+	}
+	return nil
+}
+
+// validateRabbitMQ checks that RabbitMQ servers are named uniquely and
+// have a management API URL and a valid queue pattern
+func (c *Config) validateRabbitMQ() error {
+	servers := make(map[string]bool, len(c.Collectors.RabbitMQ.Servers))
+	for _, s := range c.Collectors.RabbitMQ.Servers {
+		if s.Name == "" || servers[s.Name] {
+			return fmt.Errorf("rabbitmq server names must be set and unique: %q", s.Name)
+		}
+		servers[s.Name] = true
+		if !strings.HasPrefix(s.URL, "http://") && !strings.HasPrefix(s.URL, "https://") {
+			return fmt.Errorf("rabbitmq server %s: url must be an http(s) URL", s.Name)
+		}
+		if s.QueuePattern != "" {
+			if _, err := regexp.Compile(s.QueuePattern); err != nil {
+				return fmt.Errorf("rabbitmq server %s: invalid queue_pattern: %w", s.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateShards checks the shard settings. Chunks must not straddle
+// shards, so with compression the block is a multiple of the chunk
+// duration.
+func (c *Config) validateShards() error {
+	shards := c.Storage.Shards
+	if shards.BlockDuration < time.Minute {
+		return fmt.Errorf("storage shard block duration must be at least 1m: %s", shards.BlockDuration)
+	}
+	if c.Storage.Compression && shards.BlockDuration%c.Storage.Chunks.Duration != 0 {
+		return fmt.Errorf("storage shard block duration %s must be a multiple of the chunk duration %s",
+			shards.BlockDuration, c.Storage.Chunks.Duration)
+	}
+	if shards.MaxOpen < 1 {
+		return fmt.Errorf("storage shards max_open must be at least 1: %d", shards.MaxOpen)
+	}
+
+	size, err := ParseByteSize(c.Storage.ShardSize)
+	if err != nil {
+		return fmt.Errorf("invalid storage shard_size: %w", err)
+	}
+	if size < 1<<20 {
+		return fmt.Errorf("storage shard_size must be at least 1MB: %s", c.Storage.ShardSize)
+	}
+	return nil
+}
 
+// validateTiering checks that the cold tier has somewhere to archive to
+// and that shards reach it before retention drops them
+func (c *Config) validateTiering() error {
+	tiering := c.Storage.Tiering
+	if !tiering.Enabled {
+		return nil
+	}
+	if tiering.ColdPath == "" && tiering.S3.Bucket == "" {
+		return fmt.Errorf("storage tiering needs cold_path or an s3 bucket")
+	}
+	if tiering.WarmRetention <= 0 {
+		return fmt.Errorf("storage tiering warm_retention must be positive: %s", tiering.WarmRetention)
+	}
+	if c.Storage.RetentionPeriod > 0 && tiering.WarmRetention > c.Storage.RetentionPeriod {
+		return fmt.Errorf("storage tiering warm_retention %s must not exceed the retention period %s",
+			tiering.WarmRetention, c.Storage.RetentionPeriod)
+	}
+	if tiering.ColdRetention <= tiering.WarmRetention {
+		return fmt.Errorf("storage tiering cold_retention %s must exceed warm_retention %s",
+			tiering.ColdRetention, tiering.WarmRetention)
+	}
+	if tiering.CacheBlocks < 1 {
+		return fmt.Errorf("storage tiering cache_blocks must be at least 1: %d", tiering.CacheBlocks)
+	}
 	return nil
 }
 
+// validateReplication checks that a primary knows where to ship its
+// writes and that both sides share a token
+func (c *Config) validateReplication() error {
+	repl := c.Storage.Replication
+	switch repl.Role {
+	case "":
+		return nil
+	case ReplicationRolePrimary:
+		if repl.StandbyURL == "" && len(repl.ReplicaURLs) == 0 {
+			return fmt.Errorf("storage replication standby_url or replica_urls is required for a primary")
+		}
+	case ReplicationRoleStandby, ReplicationRoleReplica:
+		if (repl.CertFile == "") != (repl.KeyFile == "") {
+			return fmt.Errorf("storage replication cert_file and key_file must be set together")
+		}
+	default:
+		return fmt.Errorf("unknown storage replication role: %s", repl.Role)
+	}
+	if repl.Token == "" {
+		return fmt.Errorf("storage replication token is required")
+	}
+	if repl.Interval < time.Second || repl.MetadataInterval < time.Second {
+		return fmt.Errorf("storage replication interval and metadata_interval must be at least 1s")
+	}
+	return nil
+}
+
+// validateMigration checks that the new store has a path of its own and
+// that the server writes to its storage
+func (c *Config) validateMigration() error {
+	m := c.Storage.Migration
+	if !m.Enabled {
+		return nil
+	}
+	if m.Path == "" {
+		return fmt.Errorf("storage migration path is required")
+	}
+	path, err := filepath.Abs(m.Path)
+	if err != nil {
+		return fmt.Errorf("invalid storage migration path: %w", err)
+	}
+	current, err := filepath.Abs(c.Storage.Path)
+	if err != nil {
+		return fmt.Errorf("invalid storage path: %w", err)
+	}
+	if within(path, current) || within(current, path) {
+		return fmt.Errorf("storage migration path must be outside the storage path")
+	}
+	if c.Storage.Tiering.Enabled {
+		return fmt.Errorf("storage migration is not supported with tiering")
+	}
+	if c.Storage.Replication.Role == ReplicationRoleReplica || c.Storage.Replication.Role == ReplicationRoleStandby {
+		return fmt.Errorf("storage migration runs on the primary, not a %s", c.Storage.Replication.Role)
+	}
+	if m.CompareRatio < 0 || m.CompareRatio > 1 {
+		return fmt.Errorf("storage migration compare_ratio must be between 0 and 1: %g", m.CompareRatio)
+	}
+	if m.BackfillWindow < time.Minute {
+		return fmt.Errorf("storage migration backfill_window must be at least 1m")
+	}
+	return nil
+}
+
+// within reports whether path is dir or under it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// validateRollups checks that rollup resolutions are whole seconds, in
+// ascending order, and each a multiple of the one before, which it is
+// computed from
+func (c *Config) validateRollups() error {
+	var prev time.Duration
+	for _, r := range c.Storage.Rollups.Resolutions {
+		if r.Resolution < time.Second || r.Resolution%time.Second != 0 {
+			return fmt.Errorf("rollup resolution must be a whole number of seconds: %s", r.Resolution)
+		}
+		if prev != 0 && (r.Resolution <= prev || r.Resolution%prev != 0) {
+			return fmt.Errorf("rollup resolution %s must be a larger multiple of %s", r.Resolution, prev)
+		}
+		prev = r.Resolution
+	}
+	return nil
+}
+
+// validateElasticsearch checks that clusters are named uniquely and use
+// one kind of credentials
+func (c *Config) validateElasticsearch() error {
+	clusters := make(map[string]bool, len(c.Collectors.Elasticsearch.Clusters))
+	for _, cl := range c.Collectors.Elasticsearch.Clusters {
+		if cl.Name == "" || clusters[cl.Name] {
+			return fmt.Errorf("elasticsearch cluster names must be set and unique: %q", cl.Name)
+		}
+		clusters[cl.Name] = true
+		if !strings.HasPrefix(cl.URL, "http://") && !strings.HasPrefix(cl.URL, "https://") {
+			return fmt.Errorf("elasticsearch cluster %s: url must be an http(s) URL", cl.Name)
+		}
+		if cl.APIKey != "" && cl.Username != "" {
+			return fmt.Errorf("elasticsearch cluster %s: set either api_key or username, not both", cl.Name)
+		}
+	}
+	return nil
+}
+
+// validatePriorities checks the agent's metric priorities and the
+// server's overload thresholds
+func (c *Config) validatePriorities() error {
+	for collector, priority := range c.Agent.Priorities.Collectors {
+		if !validPriority(priority) {
+			return fmt.Errorf("collector %s: unknown priority %q", collector, priority)
+		}
+	}
+	for _, m := range c.Agent.Priorities.Metrics {
+		if _, err := path.Match(m.Match, ""); err != nil || m.Match == "" {
+			return fmt.Errorf("invalid metric priority match %q", m.Match)
+		}
+		if !validPriority(m.Priority) {
+			return fmt.Errorf("metrics %s: unknown priority %q", m.Match, m.Priority)
+		}
+	}
+
+	overload := c.Server.GRPC.Overload
+	if overload.MaxInflight < 2 {
+		return fmt.Errorf("overload max_inflight must be at least 2: %d", overload.MaxInflight)
+	}
+	if overload.LowSampleRate < 0 || overload.LowSampleRate > 1 || overload.NormalSampleRate < 0 || overload.NormalSampleRate > 1 {
+		return fmt.Errorf("overload sample rates must be between 0 and 1")
+	}
+	return nil
+}
+
+// validPriority reports whether a metric priority is known
+func validPriority(priority string) bool {
+	switch priority {
+	case "critical", "normal", "low":
+		return true
+	}
+	return false
+}
+
+// validateScrape checks that targets are named uniquely and use one kind
+// of credentials
+func (c *Config) validateScrape() error {
+	targets := make(map[string]bool, len(c.Collectors.Scrape.Targets))
+	for _, t := range c.Collectors.Scrape.Targets {
+		if t.Name == "" || targets[t.Name] {
+			return fmt.Errorf("scrape target names must be set and unique: %q", t.Name)
+		}
+		targets[t.Name] = true
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("scrape target %s: invalid url: %q", t.Name, t.URL)
+		}
+		if t.BearerToken != "" && t.Username != "" {
+			return fmt.Errorf("scrape target %s: set either bearer_token or username, not both", t.Name)
+		}
+		if t.Interval < 0 || t.Timeout < 0 || t.SampleLimit < 0 {
+			return fmt.Errorf("scrape target %s: interval, timeout and sample_limit cannot be negative", t.Name)
+		}
+	}
+	return nil
+}
+
+// validateUsers checks that users have unique names and API keys and a
+// known role
+func (c *Config) validateUsers() error {
+	names := make(map[string]bool, len(c.Authentication.Users))
+	keys := make(map[string]bool, len(c.Authentication.APIKeys))
+	for _, key := range c.Authentication.APIKeys {
+		keys[key] = true
+	}
+	for _, u := range c.Authentication.Users {
+		if u.Username == "" || names[u.Username] {
+			return fmt.Errorf("authentication usernames must be set and unique: %q", u.Username)
+		}
+		names[u.Username] = true
+		if _, err := ParseRole(u.Role); err != nil {
+			return fmt.Errorf("user %s: %w", u.Username, err)
+		}
+		if u.APIKey == "" && u.Password == "" {
+			return fmt.Errorf("user %s: api_key or password is required", u.Username)
+		}
+		if u.APIKey != "" {
+			if keys[u.APIKey] {
+				return fmt.Errorf("user %s: api_key is already in use", u.Username)
+			}
+			keys[u.APIKey] = true
+		}
+	}
+	return nil
+}
+
+// validateRouting checks that receivers are unique and that every route
+// names a known receiver and has valid matchers
+func (c *Config) validateRouting() error {
+	receivers := make(map[string]bool, len(c.Alerting.Receivers))
+	for _, r := range c.Alerting.Receivers {
+		if r.Name == "" {
+			return fmt.Errorf("alerting receiver name is required")
+		}
+		if receivers[r.Name] {
+			return fmt.Errorf("duplicate alerting receiver: %s", r.Name)
+		}
+		receivers[r.Name] = true
+
+		for _, w := range r.Webhooks {
+			u, err := url.Parse(w.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("receiver %s: invalid webhook url: %q", r.Name, w.URL)
+			}
+		}
+		for _, s := range r.Slack {
+			if err := s.validate(); err != nil {
+				return fmt.Errorf("receiver %s: %w", r.Name, err)
+			}
+		}
+		for _, e := range r.Email {
+			if err := e.validate(); err != nil {
+				return fmt.Errorf("receiver %s: email: %w", r.Name, err)
+			}
+		}
+	}
+
+	// Without receivers every alert goes to the notification settings
+	if len(receivers) == 0 {
+		n := &c.Alerting.Notification
+		if n.Slack.Enabled {
+			if err := n.Slack.validate(); err != nil {
+				return fmt.Errorf("alerting notification: %w", err)
+			}
+		}
+		if n.Email.Enabled {
+			if err := n.Email.validate(); err != nil {
+				return fmt.Errorf("alerting notification email: %w", err)
+			}
+		}
+		return nil
+	}
+	if c.Alerting.Route.Receiver == "" {
+		return fmt.Errorf("alerting route receiver is required")
+	}
+
+	var walk func(route *RouteConfig, path string) error
+	walk = func(route *RouteConfig, path string) error {
+		if route.Receiver != "" && !receivers[route.Receiver] {
+			return fmt.Errorf("%s: unknown receiver: %s", path, route.Receiver)
+		}
+		if _, err := ParseMatchers(route.Matchers); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for i := range route.Routes {
+			if err := walk(&route.Routes[i], fmt.Sprintf("%s.routes[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(&c.Alerting.Route, "alerting.route")
+}
+
 func (c *Config) Save(path string) error {
 	data, err := yaml.Marshal(c)
 	if err != nil {
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ChangedSettings returns the settings that differ between two configs as
+// dotted YAML paths, like alerting.route.receiver. Lists and maps are
+// compared whole.
+func ChangedSettings(old, new *Config) []string {
+	var changed []string
+	diffSettings("", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), &changed)
+	return changed
+}
+
+func diffSettings(path string, old, new reflect.Value, changed *[]string) {
+	if old.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+			*changed = append(*changed, path)
+		}
+		return
+	}
+
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// Inlined fields are settings of the struct holding them
+		fieldPath := path
+		if !strings.Contains(options, "inline") {
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			fieldPath = name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+		}
+		diffSettings(fieldPath, old.Field(i), new.Field(i), changed)
+	}
+}
@@ -47,3 +47,18 @@ func GenerateAPIKey() string {
 	}
 	return hex.EncodeToString(bytes)
 }
+
+// GenerateAnnotationID generates a unique annotation ID
+func GenerateAnnotationID() string {
+	return fmt.Sprintf("annotation-%s", uuid.New().String())
+}
+
+// GenerateSilenceID generates a unique silence ID
+func GenerateSilenceID() string {
+	return fmt.Sprintf("silence-%s", uuid.New().String())
+}
+
+// GenerateDashboardID generates a unique dashboard ID
+func GenerateDashboardID() string {
+	return fmt.Sprintf("dashboard-%s", uuid.New().String())
+}
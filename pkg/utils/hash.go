@@ -3,9 +3,7 @@ package utils
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"sort"
-	"strings"
 )
 
 // HashLabels creates a hash from a labels map
@@ -21,14 +19,23 @@ func HashLabels(labels map[string]string) string {
 	}
 	sort.Strings(keys)
 
-	// Build label string
-	var parts []string
+	// Build the "k=v,k=v" label string in one buffer; this runs for
+	// every ingested sample
+	size := len(keys)
 	for _, k := range keys {
-		parts = append(parts, fmt.Sprintf("%s=%s", k, labels[k]))
+		size += len(k) + len(labels[k])
+	}
+	labelStr := make([]byte, 0, size)
+	for i, k := range keys {
+		if i > 0 {
+			labelStr = append(labelStr, ',')
+		}
+		labelStr = append(labelStr, k...)
+		labelStr = append(labelStr, '=')
+		labelStr = append(labelStr, labels[k]...)
 	}
-	labelStr := strings.Join(parts, ",")
 
 	// Hash it
-	hash := sha256.Sum256([]byte(labelStr))
+	hash := sha256.Sum256(labelStr)
 	return hex.EncodeToString(hash[:8]) // Use first 8 bytes for shorter hash
 }
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxSymbols is how many strings Symbols holds unless configured
+const DefaultMaxSymbols = 1 << 20
+
+// symbolShards spreads a symbol table over this many locks
+const symbolShards = 32
+
+// Symbols interns the label names and values, metric names and node IDs
+// the server ingests, stores and queries, so the label maps of millions of
+// samples and series share one copy of each string.
+var Symbols = NewSymbolTable(DefaultMaxSymbols)
+
+// SymbolTable hands out one copy of each distinct string. It is bounded:
+// a shard that fills up is emptied, so high-cardinality values cost no
+// more than max strings, and strings already handed out stay valid.
+type SymbolTable struct {
+	shards [symbolShards]symbolShard
+	// perShard is the size a shard is emptied at; zero disables interning
+	perShard atomic.Int64
+}
+
+type symbolShard struct {
+	mu      sync.RWMutex
+	symbols map[string]string
+}
+
+// NewSymbolTable creates a symbol table holding at most max strings, or
+// interning nothing if max is not positive
+func NewSymbolTable(max int) *SymbolTable {
+	t := &SymbolTable{}
+	for i := range t.shards {
+		t.shards[i].symbols = make(map[string]string)
+	}
+	t.SetMax(max)
+	return t
+}
+
+// SetMax changes how many strings the table holds
+func (t *SymbolTable) SetMax(max int) {
+	perShard := int64(0)
+	if max > 0 {
+		perShard = int64((max + symbolShards - 1) / symbolShards)
+	}
+	t.perShard.Store(perShard)
+}
+
+// Len returns how many strings the table holds
+func (t *SymbolTable) Len() int {
+	n := 0
+	for i := range t.shards {
+		sh := &t.shards[i]
+		sh.mu.RLock()
+		n += len(sh.symbols)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// Intern returns the table's copy of s, adding s if there is none
+func (t *SymbolTable) Intern(s string) string {
+	if s == "" || t.perShard.Load() == 0 {
+		return s
+	}
+	sh := &t.shards[symbolHash(s)%symbolShards]
+	sh.mu.RLock()
+	sym, ok := sh.symbols[s]
+	sh.mu.RUnlock()
+	if ok {
+		return sym
+	}
+	return t.add(sh, s)
+}
+
+// InternBytes returns the table's copy of b as a string, without
+// allocating when it already holds one
+func (t *SymbolTable) InternBytes(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if t.perShard.Load() == 0 {
+		return string(b)
+	}
+	sh := &t.shards[symbolHash(b)%symbolShards]
+	sh.mu.RLock()
+	// The conversion in a map index does not allocate
+	sym, ok := sh.symbols[string(b)]
+	sh.mu.RUnlock()
+	if ok {
+		return sym
+	}
+	return t.add(sh, string(b))
+}
+
+// InternLabels returns a copy of labels holding the table's strings, or
+// labels itself if it is empty
+func (t *SymbolTable) InternLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 || t.perShard.Load() == 0 {
+		return labels
+	}
+	interned := make(map[string]string, len(labels))
+	for k, v := range labels {
+		interned[t.Intern(k)] = t.Intern(v)
+	}
+	return interned
+}
+
+func (t *SymbolTable) add(sh *symbolShard, s string) string {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sym, ok := sh.symbols[s]; ok {
+		return sym
+	}
+	if int64(len(sh.symbols)) >= t.perShard.Load() {
+		clear(sh.symbols)
+	}
+	sh.symbols[s] = s
+	return s
+}
+
+// symbolHash is FNV-1a, over a string or its bytes
+func symbolHash[T string | []byte](s T) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
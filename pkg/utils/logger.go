@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -10,11 +11,16 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// logLevel is the level of the loggers NewLogger creates, which
+// SetLogLevel changes while they run
+var logLevel = zap.NewAtomicLevel()
+
 func NewLogger(config LogConfig) (*zap.Logger, error) {
-	var level zapcore.Level
-	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+	level, err := ParseLogLevel(config.Level)
+	if err != nil {
 		level = zapcore.InfoLevel
 	}
+	logLevel.SetLevel(level)
 
 	encoderConfig := zap.NewProductionEncoderConfig()
 	encoderConfig.TimeKey = "timestamp"
@@ -68,12 +74,26 @@ func NewLogger(config LogConfig) (*zap.Logger, error) {
 	// Combine writers
 	writeSyncer := zapcore.NewMultiWriteSyncer(writers...)
 	
-	core := zapcore.NewCore(encoder, writeSyncer, level)
+	core := zapcore.NewCore(encoder, writeSyncer, logLevel)
 	logger := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
 	
 	return logger, nil
 }
 
+// ParseLogLevel parses a level name such as "debug"; empty is info
+func ParseLogLevel(level string) (zapcore.Level, error) {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return l, fmt.Errorf("invalid log level %q", level)
+	}
+	return l, nil
+}
+
+// SetLogLevel changes the level of the loggers NewLogger created
+func SetLogLevel(level zapcore.Level) {
+	logLevel.SetLevel(level)
+}
+
 func NewDevelopmentLogger() (*zap.Logger, error) {
 	config := zap.NewDevelopmentConfig()
 	config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MatchType is the comparison a label matcher performs
+type MatchType string
+
+// Label matcher comparisons
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+var matcherPattern = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+
+// Matcher matches one label of an alert. A missing label matches as the
+// empty string, so severity!="critical" matches alerts without a severity.
+type Matcher struct {
+	Name  string    `json:"name"`
+	Type  MatchType `json:"type"`
+	Value string    `json:"value"`
+
+	re *regexp.Regexp
+}
+
+// ParseMatcher parses a matcher in Alertmanager syntax: name=value,
+// name!=value, name=~regex or name!~regex. The value may be double-quoted
+// and regular expressions are anchored.
+func ParseMatcher(s string) (*Matcher, error) {
+	m := matcherPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid matcher %q", s)
+	}
+
+	value := m[3]
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: bad quoting", s)
+		}
+		value = unquoted
+	}
+
+	return NewMatcher(m[1], MatchType(m[2]), value)
+}
+
+// NewMatcher creates a matcher, compiling the value of regular expression
+// matchers
+func NewMatcher(name string, typ MatchType, value string) (*Matcher, error) {
+	matcher := &Matcher{Name: name, Type: typ, Value: value}
+
+	switch typ {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher regexp %q: %w", value, err)
+		}
+		matcher.re = re
+	default:
+		return nil, fmt.Errorf("unknown match type %q", typ)
+	}
+
+	return matcher, nil
+}
+
+// Matches reports whether the labels satisfy the matcher
+func (m *Matcher) Matches(labels map[string]string) bool {
+	return m.MatchesValue(labels[m.Name])
+}
+
+// MatchesValue reports whether the value of the matcher's label matches
+func (m *Matcher) MatchesValue(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+// String formats the matcher so ParseMatcher reads it back
+func (m *Matcher) String() string {
+	return m.Name + string(m.Type) + strconv.Quote(m.Value)
+}
+
+// ParseMatchers parses a list of matchers
+func ParseMatchers(specs []string) ([]*Matcher, error) {
+	matchers := make([]*Matcher, 0, len(specs))
+	for _, spec := range specs {
+		m, err := ParseMatcher(spec)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// cgroupUnlimited is the smallest cgroup v1 memory limit treated as none;
+// an unlimited v1 cgroup reports a page-rounded maximum int64
+const cgroupUnlimited = 1 << 62
+
+var (
+	// memoryLimit is the soft memory limit applied, or 0 without one
+	memoryLimit atomic.Int64
+	// ballast is kept reachable for the life of the process
+	ballast []byte
+)
+
+// MemoryLimit returns the soft memory limit the process runs under, or 0
+// if it has none
+func MemoryLimit() int64 {
+	return memoryLimit.Load()
+}
+
+// ApplyMemoryConfig sets the runtime's memory limit, GC percentage and
+// ballast, and returns the memory limit, or 0 if there is none
+func ApplyMemoryConfig(config *MemoryConfig, logger *zap.Logger) int64 {
+	var limit int64
+	var source string
+	switch {
+	case config.Limit != "":
+		limit, _ = ParseByteSize(config.Limit)
+		source = "config"
+		debug.SetMemoryLimit(limit)
+	case os.Getenv("GOMEMLIMIT") != "":
+		// The runtime has already applied it
+		limit = debug.SetMemoryLimit(-1)
+		source = "GOMEMLIMIT"
+	default:
+		if cgroup := cgroupMemoryLimit(); cgroup > 0 {
+			limit = int64(float64(cgroup) * config.LimitRatio)
+			source = "cgroup"
+			debug.SetMemoryLimit(limit)
+		}
+	}
+	if limit == math.MaxInt64 {
+		limit = 0
+	}
+	memoryLimit.Store(limit)
+
+	if config.GCPercent != 0 && os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(config.GCPercent)
+	}
+	if size, _ := ParseByteSize(config.Ballast); size > 0 {
+		ballast = make([]byte, size)
+	}
+
+	if limit > 0 {
+		logger.Info("Memory limit set",
+			zap.Int64("limit_bytes", limit),
+			zap.String("source", source),
+		)
+	} else if config.GCPercent == -1 {
+		logger.Warn("Garbage collection is disabled without a memory limit")
+	}
+	return limit
+}
+
+// cgroupMemoryLimit returns the memory limit of the process's cgroup, or
+// 0 if it has none or it cannot be read
+func cgroupMemoryLimit() int64 {
+	v2, v1 := "/", "/"
+	if f, err := os.Open("/proc/self/cgroup"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// hierarchy-ID:controllers:path
+			parts := strings.SplitN(scanner.Text(), ":", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			if parts[0] == "0" && parts[1] == "" {
+				v2 = parts[2]
+			}
+			for _, controller := range strings.Split(parts[1], ",") {
+				if controller == "memory" {
+					v1 = parts[2]
+				}
+			}
+		}
+		f.Close()
+	}
+
+	// Inside a container's cgroup namespace its own cgroup is the root;
+	// otherwise limits may be set on it or on the root
+	for _, path := range []string{
+		filepath.Join("/sys/fs/cgroup", v2, "memory.max"),
+		"/sys/fs/cgroup/memory.max",
+		filepath.Join("/sys/fs/cgroup/memory", v1, "memory.limit_in_bytes"),
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// memory.max is "max" without a limit
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil || limit <= 0 || limit >= cgroupUnlimited {
+			continue
+		}
+		return limit
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// Reasons a metric filter rejects a metric name
+const (
+	MetricNotAllowed = "not_allowed"
+	MetricDenied     = "denied"
+)
+
+// MetricFilter is a compiled metric name allowlist and denylist for the
+// nodes it selects
+type MetricFilter struct {
+	Name       string
+	nodes      []string
+	nodeLabels map[string]string
+	allow      []*regexp.Regexp
+	deny       []*regexp.Regexp
+}
+
+// NewMetricFilter compiles a filter's patterns, which match whole metric
+// names
+func NewMetricFilter(c *MetricFilterConfig) (*MetricFilter, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("metric filter name is required")
+	}
+	if len(c.Allow) == 0 && len(c.Deny) == 0 {
+		return nil, fmt.Errorf("metric filter %s: allow or deny is required", c.Name)
+	}
+
+	for _, pattern := range c.Nodes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("metric filter %s: invalid node pattern %q: %w", c.Name, pattern, err)
+		}
+	}
+
+	f := &MetricFilter{Name: c.Name, nodes: c.Nodes, nodeLabels: c.NodeLabels}
+	var err error
+	if f.allow, err = compileNamePatterns(c.Allow); err != nil {
+		return nil, fmt.Errorf("metric filter %s: allow: %w", c.Name, err)
+	}
+	if f.deny, err = compileNamePatterns(c.Deny); err != nil {
+		return nil, fmt.Errorf("metric filter %s: deny: %w", c.Name, err)
+	}
+	return f, nil
+}
+
+func compileNamePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// Selects reports whether the filter applies to a node: its ID matches one
+// of the node patterns, if any, and it has all of the node labels
+func (f *MetricFilter) Selects(nodeID string, labels map[string]string) bool {
+	if len(f.nodes) > 0 {
+		matched := false
+		for _, pattern := range f.nodes {
+			if ok, _ := path.Match(pattern, nodeID); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for k, v := range f.nodeLabels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Check returns why a metric name is rejected, MetricDenied or
+// MetricNotAllowed, or "" if it is accepted. The denylist takes
+// precedence over the allowlist.
+func (f *MetricFilter) Check(name string) string {
+	for _, re := range f.deny {
+		if re.MatchString(name) {
+			return MetricDenied
+		}
+	}
+	if len(f.allow) == 0 {
+		return ""
+	}
+	for _, re := range f.allow {
+		if re.MatchString(name) {
+			return ""
+		}
+	}
+	return MetricNotAllowed
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Metric naming conventions a linter checks
+const (
+	NamingRuleName          = "name_format"
+	NamingRuleLabel         = "label_format"
+	NamingRuleCounterSuffix = "counter_suffix"
+	NamingRuleUnitSuffix    = "unit_suffix"
+)
+
+// seriesSuffixes end the series a histogram or summary is exposed as, and
+// are removed before the unit suffix is checked
+var seriesSuffixes = []string{"_bucket", "_sum", "_count"}
+
+// NamingViolation is a naming convention a metric breaks
+type NamingViolation struct {
+	Rule    string
+	Message string
+}
+
+// NamingLinter checks metric names, units and label keys against the
+// configured conventions
+type NamingLinter struct {
+	name          *regexp.Regexp
+	label         *regexp.Regexp
+	namePattern   string
+	labelPattern  string
+	counterSuffix string
+	unitSuffixes  map[string]string
+}
+
+// NewNamingLinter compiles the naming conventions
+func NewNamingLinter(c *NamingConfig) (*NamingLinter, error) {
+	name, err := regexp.Compile("^(?:" + c.NamePattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid name pattern %q: %w", c.NamePattern, err)
+	}
+	label, err := regexp.Compile("^(?:" + c.LabelPattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid label pattern %q: %w", c.LabelPattern, err)
+	}
+	for unit, suffix := range c.UnitSuffixes {
+		if suffix == "" {
+			return nil, fmt.Errorf("unit %s: suffix is required", unit)
+		}
+	}
+
+	return &NamingLinter{
+		name:          name,
+		label:         label,
+		namePattern:   c.NamePattern,
+		labelPattern:  c.LabelPattern,
+		counterSuffix: c.CounterSuffix,
+		unitSuffixes:  c.UnitSuffixes,
+	}, nil
+}
+
+// CheckName returns the conventions a metric name breaks given its unit
+// and whether it is a counter
+func (l *NamingLinter) CheckName(name, unit string, counter bool) []NamingViolation {
+	var violations []NamingViolation
+	if !l.name.MatchString(name) {
+		violations = append(violations, NamingViolation{
+			Rule:    NamingRuleName,
+			Message: fmt.Sprintf("name %q does not match %s", name, l.namePattern),
+		})
+	}
+
+	base := name
+	if counter {
+		if strings.HasSuffix(name, l.counterSuffix) {
+			base = strings.TrimSuffix(name, l.counterSuffix)
+		} else {
+			violations = append(violations, NamingViolation{
+				Rule:    NamingRuleCounterSuffix,
+				Message: fmt.Sprintf("counter %q does not end in %s", name, l.counterSuffix),
+			})
+		}
+	} else {
+		for _, suffix := range seriesSuffixes {
+			if strings.HasSuffix(name, suffix) {
+				base = strings.TrimSuffix(name, suffix)
+				break
+			}
+		}
+	}
+
+	if suffix, ok := l.unitSuffixes[unit]; ok && !strings.HasSuffix(base, suffix) {
+		violations = append(violations, NamingViolation{
+			Rule:    NamingRuleUnitSuffix,
+			Message: fmt.Sprintf("metric %q in %s does not end in %s", name, unit, suffix),
+		})
+	}
+	return violations
+}
+
+// CheckLabel returns the convention a label key breaks, if any
+func (l *NamingLinter) CheckLabel(key string) *NamingViolation {
+	if l.label.MatchString(key) {
+		return nil
+	}
+	return &NamingViolation{
+		Rule:    NamingRuleLabel,
+		Message: fmt.Sprintf("label %q does not match %s", key, l.labelPattern),
+	}
+}
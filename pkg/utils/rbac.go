@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role is a user's level of access. Each role includes the access of the
+// roles below it.
+type Role string
+
+const (
+	// RoleViewer can read nodes, metrics, alerts and dashboards
+	RoleViewer Role = "viewer"
+	// RoleEditor can also change dashboards, silences, annotations and
+	// node labels, and is the role agents need to push metrics
+	RoleEditor Role = "editor"
+	// RoleAdmin can also reload configuration and change collectors and
+	// node lifecycle
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// ParseRole parses a configured role. An empty role is a viewer.
+func ParseRole(s string) (Role, error) {
+	if s == "" {
+		return RoleViewer, nil
+	}
+	role := Role(strings.ToLower(s))
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q (must be viewer, editor or admin)", s)
+	}
+	return role, nil
+}
+
+// Allows reports whether the role has at least the required role's access
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Name string
+	Role Role
+}
+
+// Anonymous is the principal of every request when authentication is
+// disabled; it may do anything
+var Anonymous = &Principal{Role: RoleAdmin}
+
+// ErrUnauthenticated is returned when a request has no valid credentials
+var ErrUnauthenticated = errors.New("invalid or missing credentials")
+
+// Authenticator resolves API keys and user passwords to principals
+type Authenticator struct {
+	enabled bool
+	keys    map[string]*Principal
+	users   map[string]*authUser
+}
+
+type authUser struct {
+	password  string
+	principal *Principal
+}
+
+// NewAuthenticator creates an authenticator from the authentication
+// config. Keys in api_keys are not tied to a user and have the admin role.
+func NewAuthenticator(config *AuthenticationConfig) *Authenticator {
+	a := &Authenticator{
+		enabled: config.Enabled,
+		keys:    make(map[string]*Principal),
+		users:   make(map[string]*authUser),
+	}
+
+	for _, key := range config.APIKeys {
+		a.keys[key] = &Principal{Name: "api-key", Role: RoleAdmin}
+	}
+	for _, u := range config.Users {
+		role, err := ParseRole(u.Role)
+		if err != nil {
+			// Rejected by config validation
+			continue
+		}
+		principal := &Principal{Name: u.Username, Role: role}
+		if u.APIKey != "" {
+			a.keys[u.APIKey] = principal
+		}
+		if u.Password != "" {
+			a.users[u.Username] = &authUser{password: u.Password, principal: principal}
+		}
+	}
+
+	return a
+}
+
+// Enabled reports whether requests must authenticate
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// APIKey returns the principal an API key belongs to
+func (a *Authenticator) APIKey(key string) (*Principal, error) {
+	if !a.enabled {
+		return Anonymous, nil
+	}
+	if key != "" {
+		// Compare every key so the time taken does not reveal a match
+		var found *Principal
+		for k, p := range a.keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				found = p
+			}
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+// Password returns the principal of a user whose password matches
+func (a *Authenticator) Password(username, password string) (*Principal, error) {
+	if !a.enabled {
+		return Anonymous, nil
+	}
+	u, ok := a.users[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(u.password), []byte(password)) != 1 {
+		return nil, ErrUnauthenticated
+	}
+	return u.principal, nil
+}
+
+// Request authenticates an HTTP request by its X-API-Key header, api_key
+// query parameter, bearer token or basic auth credentials
+func (a *Authenticator) Request(r *http.Request) (*Principal, error) {
+	if !a.enabled {
+		return Anonymous, nil
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return a.APIKey(key)
+	}
+	if key := r.URL.Query().Get("api_key"); key != "" {
+		return a.APIKey(key)
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return a.APIKey(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if username, password, ok := r.BasicAuth(); ok {
+		return a.Password(username, password)
+	}
+	return nil, ErrUnauthenticated
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored by WithPrincipal
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
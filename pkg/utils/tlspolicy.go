@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P-256":  tls.CurveP256,
+	"P-384":  tls.CurveP384,
+	"P-521":  tls.CurveP521,
+}
+
+// strictCipherSuites are the FIPS-approved TLS 1.2 cipher suites Go
+// implements, in preference order
+var strictCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// strictCurves are the FIPS-approved curves; the first two are used when
+// none are configured
+var strictCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// Apply sets the minimum version, cipher suites and curves of config
+func (c *TLSPolicyConfig) Apply(config *tls.Config) error {
+	version, suites, curves, err := c.parse()
+	if err != nil {
+		return err
+	}
+	config.MinVersion = version
+	config.CipherSuites = suites
+	config.CurvePreferences = curves
+	return nil
+}
+
+// check parses the policy and, in strict mode, rejects weak settings
+func (c *TLSPolicyConfig) check() error {
+	version, suites, curves, err := c.parse()
+	if err != nil {
+		return err
+	}
+
+	if len(c.CipherSuites) > 0 && version < tls.VersionTLS13 &&
+		!containsID(suites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) &&
+		!containsID(suites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		return fmt.Errorf("cipher_suites must include an ECDHE AES_128_GCM_SHA256 suite, which HTTP/2 requires")
+	}
+
+	if !c.Strict {
+		return nil
+	}
+	if version < tls.VersionTLS12 {
+		return fmt.Errorf("strict mode needs min_version 1.2 or later, got %s", c.MinVersion)
+	}
+	for i, id := range suites {
+		if !containsID(strictCipherSuites, id) {
+			return fmt.Errorf("strict mode does not allow cipher suite %s", c.CipherSuites[i])
+		}
+	}
+	for i, id := range curves {
+		if !containsID(strictCurves, id) {
+			return fmt.Errorf("strict mode does not allow curve %s", c.CurvePreferences[i])
+		}
+	}
+	return nil
+}
+
+// parse returns the policy as tls.Config values, with the strict defaults
+// for empty lists in strict mode
+func (c *TLSPolicyConfig) parse() (uint16, []uint16, []tls.CurveID, error) {
+	version, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return 0, nil, nil, fmt.Errorf("unknown min_version %q, expected 1.0, 1.1, 1.2 or 1.3", c.MinVersion)
+	}
+
+	var suites []uint16
+	for _, name := range c.CipherSuites {
+		id, ok := cipherSuiteID(name)
+		if !ok {
+			return 0, nil, nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+
+	var curves []tls.CurveID
+	for _, name := range c.CurvePreferences {
+		id, ok := tlsCurves[strings.ToUpper(name)]
+		if !ok {
+			return 0, nil, nil, fmt.Errorf("unknown curve %q, expected X25519, P-256, P-384 or P-521", name)
+		}
+		curves = append(curves, id)
+	}
+
+	if c.Strict {
+		if suites == nil {
+			suites = strictCipherSuites
+		}
+		if curves == nil {
+			curves = strictCurves[:2]
+		}
+	}
+	return version, suites, curves, nil
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, list := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range list {
+			if suite.Name == name {
+				return suite.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func containsID[T comparable](ids []T, id T) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
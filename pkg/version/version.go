@@ -0,0 +1,80 @@
+// Package version holds build metadata embedded at link time, e.g.
+//
+//	go build -ldflags "-X github.com/meettoy2004/lnmonja/pkg/version.Version=1.2.0"
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set via -ldflags -X at build time
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+	GitCommit = ""
+)
+
+// Info describes the build of a binary
+type Info struct {
+	Version   string `json:"version"`
+	BuildTime string `json:"build_time"`
+	GitCommit string `json:"git_commit"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// Get returns the build metadata of the running binary. The git commit falls
+// back to the VCS information recorded by the Go toolchain.
+func Get() Info {
+	commit := GitCommit
+	if commit == "" {
+		commit = vcsRevision()
+	}
+
+	return Info{
+		Version:   Version,
+		BuildTime: BuildTime,
+		GitCommit: commit,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+// String formats the build metadata for --version output
+func (i Info) String() string {
+	commit := i.GitCommit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	return fmt.Sprintf("v%s (commit: %s, built: %s, %s %s/%s)",
+		i.Version, commit, i.BuildTime, i.GoVersion, i.OS, i.Arch)
+}
+
+func vcsRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	var revision string
+	var modified bool
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
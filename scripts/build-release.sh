@@ -0,0 +1,46 @@
+#!/bin/bash
+# Build static lnmonja binaries for several platforms with embedded version
+# metadata. Output goes to $DIST_DIR/<os>-<arch>/.
+set -e
+
+VERSION=${VERSION:-dev}
+BUILD_TIME=${BUILD_TIME:-$(date -u '+%Y-%m-%d_%H:%M:%S')}
+GIT_COMMIT=${GIT_COMMIT:-$(git rev-parse HEAD 2>/dev/null || true)}
+DIST_DIR=${DIST_DIR:-dist}
+PLATFORMS=${PLATFORMS:-"linux/amd64 linux/arm64 linux/arm/7 darwin/amd64 darwin/arm64 windows/amd64"}
+
+VERSION_PKG=github.com/meettoy2004/lnmonja/pkg/version
+LDFLAGS="-s -w -X ${VERSION_PKG}.Version=${VERSION} -X ${VERSION_PKG}.BuildTime=${BUILD_TIME} -X ${VERSION_PKG}.GitCommit=${GIT_COMMIT}"
+
+for platform in $PLATFORMS; do
+    IFS=/ read -r os arch arm <<< "$platform"
+
+    target="${os}-${arch}"
+    if [ -n "$arm" ]; then
+        target="${target}v${arm}"
+    fi
+    ext=""
+    if [ "$os" = "windows" ]; then
+        ext=".exe"
+    fi
+
+    out="${DIST_DIR}/${target}"
+    mkdir -p "$out"
+    echo "Building ${target}..."
+
+    for cmd in lnmonja-server lnmonja-agent lnmonja-cli; do
+        # The server only targets Unix-like systems
+        if [ "$os" = "windows" ] && [ "$cmd" = "lnmonja-server" ]; then
+            continue
+        fi
+
+        CGO_ENABLED=0 GOOS=$os GOARCH=$arch GOARM=$arm \
+            go build -trimpath -ldflags "$LDFLAGS" -o "${out}/${cmd}${ext}" "./cmd/${cmd}"
+    done
+
+    tar -czf "${DIST_DIR}/lnmonja-${VERSION}-${target}.tar.gz" -C "$DIST_DIR" "$target"
+done
+
+(cd "$DIST_DIR" && sha256sum lnmonja-*.tar.gz > SHA256SUMS)
+
+echo "Release artifacts written to ${DIST_DIR}/"
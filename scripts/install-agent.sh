@@ -99,7 +99,9 @@ Description=lnmonja Agent
 After=network.target docker.service
 
 [Service]
-Type=simple
+Type=notify
+NotifyAccess=main
+WatchdogSec=30s
 User=$SERVICE_USER
 Group=$SERVICE_USER
 ExecStart=$INSTALL_DIR/lnmonja-agent --config $CONFIG_DIR/config.yaml
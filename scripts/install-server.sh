@@ -127,10 +127,13 @@ Description=lnmonja Monitoring Server
 After=network.target
 
 [Service]
-Type=simple
+Type=notify
+NotifyAccess=main
+WatchdogSec=30s
 User=$SERVICE_USER
 Group=$SERVICE_USER
 ExecStart=$INSTALL_DIR/lnmonja-server --config $CONFIG_DIR/config.yaml
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=always
 RestartSec=5
 LimitNOFILE=65536
@@ -114,6 +114,18 @@ class APIService {
     const response = await this.client.get('/stats');
     return response.data;
   }
+
+  // Localized messages
+  async getLocales() {
+    const response = await this.client.get('/i18n/locales');
+    return response.data;
+  }
+
+  async getMessages(locale) {
+    const params = locale ? { locale } : {};
+    const response = await this.client.get('/i18n/messages', { params });
+    return response.data;
+  }
 }
 
 export const api = new APIService();
@@ -0,0 +1,32 @@
+import { writable, derived } from 'svelte/store';
+import api from '../services/api';
+
+const STORAGE_KEY = 'lnmonja.locale';
+
+// The locale shown, and its messages as served by /api/v1/i18n/messages
+export const locale = writable(localStorage.getItem(STORAGE_KEY) || '');
+export const messages = writable({});
+
+// t translates a message key, substituting %s and %d with args in order.
+// Keys without a message are shown as they are.
+export const t = derived(messages, ($messages) => (key, ...args) => {
+  const text = $messages[key] ?? key;
+  let i = 0;
+  return text.replace(/%[sdgv]/g, (match) => (i < args.length ? String(args[i++]) : match));
+});
+
+// loadMessages fetches the messages of a locale, or of the browser's
+// languages when none was chosen
+export async function loadMessages(requested = '') {
+  try {
+    const data = await api.getMessages(requested);
+    messages.set(data.messages);
+    locale.set(data.locale);
+    document.documentElement.lang = data.locale;
+    if (requested) {
+      localStorage.setItem(STORAGE_KEY, data.locale);
+    }
+  } catch (error) {
+    console.error('Failed to load messages:', error);
+  }
+}